@@ -0,0 +1,195 @@
+// Package attest signs the approvals of privilege requests. Every approval is
+// kept as a record naming the approver, the time and the access approved,
+// signed with an Ed25519 key only the server holds; anyone with the public
+// keys can check that a grant was approved as recorded.
+package attest
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// payloadVersion prefixes the signed payload, so its layout can change later
+const payloadVersion = "apollo-approval-v1"
+
+// ErrUnknownKey is returned for records signed with a key the signer doesn't hold
+var ErrUnknownKey = errors.New("unknown signing key")
+
+// ErrInvalidSignature is returned for records whose signature doesn't match their content
+var ErrInvalidSignature = errors.New("invalid signature")
+
+// Signer signs approval records with its current key and verifies them with
+// any of its keys. Old keys are kept so approvals signed before a rotation
+// can still be verified.
+type Signer struct {
+	keys    map[string]ed25519.PrivateKey
+	current string
+}
+
+// NewSigner creates a signer from base64 encoded 32 byte Ed25519 seeds by ID,
+// signing new approvals with the current one
+func NewSigner(encoded map[string]string, current string) (*Signer, error) {
+	keys := make(map[string]ed25519.PrivateKey, len(encoded))
+	for id, value := range encoded {
+		seed, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid signing key %s: %v", id, err)
+		}
+		if len(seed) != ed25519.SeedSize {
+			return nil, fmt.Errorf("signing key %s must be %d bytes", id, ed25519.SeedSize)
+		}
+		keys[id] = ed25519.NewKeyFromSeed(seed)
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current signing key %q is not configured", current)
+	}
+	return &Signer{keys: keys, current: current}, nil
+}
+
+// NewEphemeralSigner creates a signer with a random key. Its signatures can't
+// be verified once the process exits.
+func NewEphemeralSigner() (*Signer, error) {
+	seed := make([]byte, ed25519.SeedSize)
+	if _, err := rand.Read(seed); err != nil {
+		return nil, fmt.Errorf("failed to generate signing key: %v", err)
+	}
+	return NewSigner(map[string]string{"ephemeral": base64.StdEncoding.EncodeToString(seed)}, "ephemeral")
+}
+
+// KeyID names the key new approvals are signed with
+func (s *Signer) KeyID() string {
+	return s.current
+}
+
+// PublicKey is a verification key as published to auditors
+type PublicKey struct {
+	ID        string `json:"id"`
+	Algorithm string `json:"algorithm"`
+	// Key is the base64 encoded 32 byte Ed25519 public key
+	Key     string `json:"key"`
+	Current bool   `json:"current"`
+}
+
+// PublicKeys returns the keys verifying the signer's approvals, ordered by ID
+func (s *Signer) PublicKeys() []PublicKey {
+	keys := make([]PublicKey, 0, len(s.keys))
+	for id, key := range s.keys {
+		keys = append(keys, PublicKey{
+			ID:        id,
+			Algorithm: "Ed25519",
+			Key:       base64.StdEncoding.EncodeToString(key.Public().(ed25519.PublicKey)),
+			Current:   id == s.current,
+		})
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return keys[i].ID < keys[j].ID
+	})
+	return keys
+}
+
+// Sign sets the record's key ID and signature
+func (s *Signer) Sign(record *models.ApprovalRecord) {
+	record.KeyID = s.current
+	record.Signature = base64.StdEncoding.EncodeToString(ed25519.Sign(s.keys[s.current], Payload(record)))
+}
+
+// Verify checks the record's signature
+func (s *Signer) Verify(record *models.ApprovalRecord) error {
+	key, ok := s.keys[record.KeyID]
+	if !ok {
+		return fmt.Errorf("%w %q", ErrUnknownKey, record.KeyID)
+	}
+	signature, err := base64.StdEncoding.DecodeString(record.Signature)
+	if err != nil || !ed25519.Verify(key.Public().(ed25519.PublicKey), Payload(record), signature) {
+		return ErrInvalidSignature
+	}
+	return nil
+}
+
+// Payload returns what a record's signature covers: the version and every
+// field but the signature, one per line, with times in RFC 3339 in UTC. The
+// hardware key assertion and the approval step follow only when there are
+// any, so records signed before they were recorded still verify.
+func Payload(record *models.ApprovalRecord) []byte {
+	fields := []string{
+		payloadVersion,
+		record.KeyID,
+		record.ApproverID,
+		record.ApproverKind,
+		record.Issuer,
+		record.Subject,
+		record.Channel,
+		formatTime(record.ApprovedAt),
+		record.UserID,
+		record.Module,
+		record.ResourceID,
+		string(record.Level),
+		record.Reason,
+		formatTime(record.RequestedAt),
+		formatTime(record.ExpiresAt),
+		record.ExtendsGrant,
+	}
+	if assertion := record.WebAuthn; assertion != nil {
+		fields = append(fields,
+			"webauthn",
+			assertion.CredentialID,
+			assertion.AuthenticatorData,
+			assertion.ClientDataJSON,
+			assertion.Signature,
+			strconv.FormatUint(uint64(assertion.SignCount), 10),
+			strconv.FormatBool(assertion.UserVerified),
+		)
+	}
+	if record.Step != "" {
+		fields = append(fields, "step", record.Step)
+	}
+	return []byte(strings.Join(fields, "\n"))
+}
+
+// NewRecord returns the unsigned record of the approver approving the request
+func NewRecord(request *models.PrivilegeRequest, approver *models.Approver, channel string, now time.Time) models.ApprovalRecord {
+	return models.ApprovalRecord{
+		ApproverID:   approver.ID,
+		ApproverKind: approver.Kind,
+		Channel:      channel,
+		ApprovedAt:   now.UTC(),
+		UserID:       request.UserID,
+		Module:       request.Module,
+		ResourceID:   request.ResourceID,
+		Level:        request.Level,
+		Reason:       request.Reason,
+		RequestedAt:  request.RequestedAt.UTC(),
+		ExpiresAt:    request.ExpiresAt.UTC(),
+		ExtendsGrant: request.ExtendsGrant,
+	}
+}
+
+// Covers reports why the record doesn't approve the grant's access, or nil
+// when it does. Extensions of the grant are covered too.
+func Covers(record *models.ApprovalRecord, grant *models.PrivilegeGrant) error {
+	switch {
+	case record.UserID != grant.UserID:
+		return fmt.Errorf("approved access for %s, grant is held by %s", record.UserID, grant.UserID)
+	case record.Module != grant.Module || record.ResourceID != grant.ResourceID:
+		return fmt.Errorf("approved access to %s/%s, grant is for %s/%s", record.Module, record.ResourceID, grant.Module, grant.ResourceID)
+	case record.Level != grant.Level:
+		return fmt.Errorf("approved %s access, grant is for %s", record.Level, grant.Level)
+	case record.ExtendsGrant != "" && record.ExtendsGrant != grant.ID:
+		return fmt.Errorf("approved an extension of grant %s", record.ExtendsGrant)
+	}
+	return nil
+}
+
+// formatTime formats a time for the signed payload
+func formatTime(t time.Time) string {
+	return t.UTC().Format(time.RFC3339Nano)
+}
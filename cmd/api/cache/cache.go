@@ -0,0 +1,120 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sync"
+	"time"
+)
+
+// Supported cache backends
+const (
+	TypeMemory = "memory"
+	TypeRedis  = "redis"
+)
+
+// Cache stores encoded values under string keys for a limited time
+type Cache interface {
+	// Get returns the value stored under key and whether it was found
+	Get(ctx context.Context, key string) ([]byte, bool, error)
+	// Set stores value under key until ttl has passed
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes the given keys
+	Delete(ctx context.Context, keys ...string) error
+	// Close releases the cache's resources
+	Close() error
+}
+
+// Config selects and configures the cache backend
+type Config struct {
+	// Type is memory or redis
+	Type string
+	// URL locates the Redis server, e.g. redis://:password@localhost:6379/0
+	URL string
+}
+
+// Open creates the cache selected by the configuration
+func Open(config Config) (Cache, error) {
+	switch config.Type {
+	case "", TypeMemory:
+		return NewMemory(), nil
+	case TypeRedis:
+		u, err := url.Parse(config.URL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Redis URL: %v", err)
+		}
+		return NewRedis(u)
+	default:
+		return nil, fmt.Errorf("unknown cache type %q", config.Type)
+	}
+}
+
+// memoryEntry is a value held by the in-process cache
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+// Memory is an in-process cache. Expired entries are dropped when read and
+// swept periodically so keys that are never read again don't accumulate.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	swept   time.Time
+}
+
+// NewMemory creates an empty in-process cache
+func NewMemory() *Memory {
+	return &Memory{entries: make(map[string]memoryEntry), swept: time.Now()}
+}
+
+// Get returns the value stored under key and whether it was found
+func (m *Memory) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	entry, ok := m.entries[key]
+	if !ok {
+		return nil, false, nil
+	}
+	if !time.Now().Before(entry.expiresAt) {
+		delete(m.entries, key)
+		return nil, false, nil
+	}
+	return entry.value, true, nil
+}
+
+// Set stores value under key until ttl has passed
+func (m *Memory) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(m.swept) > time.Minute {
+		for k, entry := range m.entries {
+			if !now.Before(entry.expiresAt) {
+				delete(m.entries, k)
+			}
+		}
+		m.swept = now
+	}
+	m.entries[key] = memoryEntry{value: value, expiresAt: now.Add(ttl)}
+	return nil
+}
+
+// Delete removes the given keys
+func (m *Memory) Delete(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+// Close releases the cache's resources
+func (m *Memory) Close() error {
+	return nil
+}
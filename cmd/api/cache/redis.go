@@ -0,0 +1,236 @@
+package cache
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	redisDefaultPort = "6379"
+	// redisKeyPrefix keeps Apollo's keys apart when the server is shared
+	redisKeyPrefix = "apollo:"
+)
+
+// errRedisNil is the reply to GET for a missing key
+var errRedisNil = errors.New("redis: nil")
+
+// Redis is a cache shared by all API servers, spoken to over the Redis
+// protocol. Commands run one at a time on a single connection, which is
+// reopened on the next command after an error.
+type Redis struct {
+	url *url.URL
+
+	mu     sync.Mutex
+	conn   net.Conn
+	reader *bufio.Reader
+	closed bool
+}
+
+// NewRedis connects to a Redis server at a redis:// or rediss:// URL
+func NewRedis(u *url.URL) (*Redis, error) {
+	if u.Scheme != "redis" && u.Scheme != "rediss" {
+		return nil, fmt.Errorf("Redis URL must use the redis or rediss scheme")
+	}
+	if u.Port() == "" {
+		u.Host = net.JoinHostPort(u.Hostname(), redisDefaultPort)
+	}
+
+	r := &Redis{url: u}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if err := r.connect(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// connect opens the connection, authenticates and selects the database
+func (r *Redis) connect() error {
+	dialer := &net.Dialer{Timeout: 5 * time.Second}
+	var conn net.Conn
+	var err error
+	if r.url.Scheme == "rediss" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", r.url.Host, &tls.Config{ServerName: r.url.Hostname()})
+	} else {
+		conn, err = dialer.Dial("tcp", r.url.Host)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to connect to Redis: %v", err)
+	}
+	r.conn = conn
+	r.reader = bufio.NewReader(conn)
+
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	defer conn.SetDeadline(time.Time{})
+	if user := r.url.User; user != nil {
+		args := []string{"AUTH", user.Username()}
+		if password, ok := user.Password(); ok {
+			args = []string{"AUTH", password}
+			if user.Username() != "" {
+				args = []string{"AUTH", user.Username(), password}
+			}
+		}
+		if _, err := r.roundTrip(args...); err != nil {
+			r.disconnect()
+			return fmt.Errorf("failed to authenticate to Redis: %v", err)
+		}
+	}
+	if db := strings.TrimPrefix(r.url.Path, "/"); db != "" {
+		if _, err := r.roundTrip("SELECT", db); err != nil {
+			r.disconnect()
+			return fmt.Errorf("failed to select Redis database %s: %v", db, err)
+		}
+	}
+	return nil
+}
+
+// disconnect drops the connection so the next command reconnects
+func (r *Redis) disconnect() {
+	if r.conn != nil {
+		r.conn.Close()
+		r.conn = nil
+		r.reader = nil
+	}
+}
+
+// do runs a command, reconnecting first when the connection was dropped
+func (r *Redis) do(ctx context.Context, args ...string) (interface{}, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.closed {
+		return nil, fmt.Errorf("Redis connection is closed")
+	}
+	if r.conn == nil {
+		if err := r.connect(); err != nil {
+			return nil, err
+		}
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		r.conn.SetDeadline(deadline)
+		defer func() {
+			if r.conn != nil {
+				r.conn.SetDeadline(time.Time{})
+			}
+		}()
+	}
+	reply, err := r.roundTrip(args...)
+	if err != nil && err != errRedisNil {
+		var serverErr redisError
+		if !errors.As(err, &serverErr) {
+			r.disconnect()
+		}
+	}
+	return reply, err
+}
+
+// roundTrip writes a command and reads its reply
+func (r *Redis) roundTrip(args ...string) (interface{}, error) {
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, arg := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(arg), arg)
+	}
+	if _, err := io.WriteString(r.conn, b.String()); err != nil {
+		return nil, err
+	}
+	return readReply(r.reader)
+}
+
+// redisError is an error reply from the server
+type redisError string
+
+func (e redisError) Error() string {
+	return "redis: " + string(e)
+}
+
+// readReply reads one reply; bulk strings are returned as []byte and integers as int64
+func readReply(reader *bufio.Reader) (interface{}, error) {
+	line, err := reader.ReadString('\n')
+	if err != nil {
+		return nil, err
+	}
+	line = strings.TrimRight(line, "\r\n")
+	if line == "" {
+		return nil, fmt.Errorf("empty Redis reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		size, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, fmt.Errorf("malformed Redis reply: %q", line)
+		}
+		if size < 0 {
+			return nil, errRedisNil
+		}
+		data := make([]byte, size+2)
+		if _, err := io.ReadFull(reader, data); err != nil {
+			return nil, err
+		}
+		return data[:size], nil
+	default:
+		return nil, fmt.Errorf("unexpected Redis reply: %q", line)
+	}
+}
+
+// Get returns the value stored under key and whether it was found
+func (r *Redis) Get(ctx context.Context, key string) ([]byte, bool, error) {
+	reply, err := r.do(ctx, "GET", redisKeyPrefix+key)
+	if err == errRedisNil {
+		return nil, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	value, ok := reply.([]byte)
+	if !ok {
+		return nil, false, fmt.Errorf("unexpected Redis reply to GET: %v", reply)
+	}
+	return value, true, nil
+}
+
+// Set stores value under key until ttl has passed
+func (r *Redis) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	_, err := r.do(ctx, "SET", redisKeyPrefix+key, string(value), "PX", strconv.FormatInt(ttl.Milliseconds(), 10))
+	return err
+}
+
+// Delete removes the given keys
+func (r *Redis) Delete(ctx context.Context, keys ...string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	args := []string{"DEL"}
+	for _, key := range keys {
+		args = append(args, redisKeyPrefix+key)
+	}
+	_, err := r.do(ctx, args...)
+	return err
+}
+
+// Close disconnects from the server
+func (r *Redis) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.closed = true
+	r.disconnect()
+	return nil
+}
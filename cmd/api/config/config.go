@@ -1,10 +1,16 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/configref"
 	"gopkg.in/yaml.v3"
 )
 
@@ -14,10 +20,132 @@ type Config struct {
 		Port           int    `yaml:"port"`
 		Host           string `yaml:"host"`
 		EnabledModules string `yaml:"enabled_modules"`
+		// TrustedProxies lists the CIDRs of reverse proxies allowed to set X-Forwarded-For
+		TrustedProxies []string `yaml:"trusted_proxies"`
+		// Admins may run retention and export the audit log through the API
+		Admins []string `yaml:"admins"`
+		// Region names the region the server runs in when API servers run in
+		// several regions
+		Region string `yaml:"region"`
+		// IdleTimeout is how long keep-alive connections wait for the next
+		// request; defaults to 2m
+		IdleTimeout string `yaml:"idle_timeout"`
+		// DisableCompression sends responses uncompressed, e.g. when a proxy
+		// in front of the server compresses them
+		DisableCompression bool `yaml:"disable_compression"`
+		// GRPCPort serves operators jobs, heartbeats and results over gRPC on
+		// another port of the host; off when 0
+		GRPCPort int `yaml:"grpc_port"`
+		// GRPCPollInterval is how often job streams check the database for
+		// jobs dispatched by other API servers; set it when several API
+		// servers share the database, as streams otherwise only wake for jobs
+		// this server dispatches
+		GRPCPollInterval string `yaml:"grpc_poll_interval"`
 	} `yaml:"server"`
 
 	Modules map[string]interface{} `yaml:"modules"`
 
+	Database struct {
+		// Driver selects the storage backend: memory, mysql, postgres or sqlite
+		Driver string `yaml:"driver"`
+		DSN    string `yaml:"dsn"`
+		// ReadDSN optionally points reads at a replica in the server's region;
+		// writes always go to the primary at DSN
+		ReadDSN string `yaml:"read_dsn"`
+		// DriverName overrides the database/sql driver used for the backend
+		DriverName   string `yaml:"driver_name"`
+		MaxOpenConns int    `yaml:"max_open_conns"`
+		// ConnectTimeout is how long to keep retrying the database at startup
+		ConnectTimeout string `yaml:"connect_timeout"`
+		// BreakerThreshold is the number of consecutive connection failures
+		// after which calls fail fast until the database answers a ping again
+		BreakerThreshold int `yaml:"breaker_threshold"`
+		// ReconnectDelay is the first delay between those pings; it doubles
+		// with every failed ping
+		ReconnectDelay string `yaml:"reconnect_delay"`
+	} `yaml:"database"`
+
+	// Bus optionally dispatches jobs to operators over NATS instead of HTTP polling
+	Bus struct {
+		Type string `yaml:"type"`
+		URL  string `yaml:"url"`
+	} `yaml:"bus"`
+
+	// Cleanup configures the worker finalizing expired grants, expiring
+	// requests left pending past their TTL and marking silent operators inactive
+	Cleanup struct {
+		Interval string `yaml:"interval"`
+		// PendingTTL is how long a request may wait for a decision before it
+		// expires; pending requests never expire when empty
+		PendingTTL string `yaml:"pending_ttl"`
+		// PendingSLA is the former name of PendingTTL, used when it is empty
+		PendingSLA string `yaml:"pending_sla"`
+		// OperatorTimeout is how long an operator may go without a health check
+		OperatorTimeout string `yaml:"operator_timeout"`
+		// ExpiryWarning is how long before a grant expires its holder is
+		// warned; no warnings are sent when empty
+		ExpiryWarning string `yaml:"expiry_warning"`
+	} `yaml:"cleanup"`
+
+	// Jobs controls how long finished jobs stay among the active jobs before
+	// they are moved to the job archive
+	Jobs struct {
+		ArchiveAfter    string `yaml:"archive_after"`
+		ArchiveInterval string `yaml:"archive_interval"`
+	} `yaml:"jobs"`
+
+	// Events delivers grant lifecycle events through the transactional outbox
+	Events struct {
+		// Bus publishes events on the bus as apollo.events.<type>
+		Bus      bool           `yaml:"bus"`
+		Webhooks []EventWebhook `yaml:"webhooks"`
+		// Encoding is apollo or cloudevents, for the bus and webhooks that
+		// don't choose their own; defaults to apollo
+		Encoding string `yaml:"encoding"`
+		// Source is the CloudEvents source attribute; defaults to /apollo
+		Source string `yaml:"source"`
+		// SNS publishes events to a topic
+		SNS struct {
+			// TopicARN is the topic; SNS is off when empty
+			TopicARN string `yaml:"topic_arn"`
+			// Region defaults to the topic's
+			Region          string `yaml:"region"`
+			Endpoint        string `yaml:"endpoint"`
+			AccessKeyID     string `yaml:"access_key_id"`
+			SecretAccessKey string `yaml:"secret_access_key"`
+			SessionToken    string `yaml:"session_token"`
+		} `yaml:"sns"`
+		// EventBridge puts events on an event bus
+		EventBridge struct {
+			// EventBus is the bus name or ARN; EventBridge is off when empty
+			EventBus string `yaml:"event_bus"`
+			// Source defaults to apollo
+			Source          string `yaml:"source"`
+			Region          string `yaml:"region"`
+			Endpoint        string `yaml:"endpoint"`
+			AccessKeyID     string `yaml:"access_key_id"`
+			SecretAccessKey string `yaml:"secret_access_key"`
+			SessionToken    string `yaml:"session_token"`
+		} `yaml:"eventbridge"`
+		// Interval is how often the outbox is checked for events to deliver
+		Interval string `yaml:"interval"`
+		// MaxAttempts is how often delivering an event may fail before it
+		// becomes a dead letter; defaults to 20
+		MaxAttempts int `yaml:"max_attempts"`
+		// DeliveryHistory is how long delivery attempts are kept; defaults to 168h
+		DeliveryHistory string `yaml:"delivery_history"`
+	} `yaml:"events"`
+
+	// Cache optionally caches module servers, operators and active grants,
+	// in process or in Redis shared by all API servers
+	Cache struct {
+		// Type is memory or redis; caching is off when empty
+		Type string `yaml:"type"`
+		URL  string `yaml:"url"`
+		// TTL bounds how long cached reads may miss writes made through other API servers
+		TTL string `yaml:"ttl"`
+	} `yaml:"cache"`
+
 	API struct {
 		Endpoint      string `yaml:"endpoint"`
 		RetryAttempts int    `yaml:"retry_attempts"`
@@ -36,14 +164,457 @@ type Config struct {
 		Retries  int    `yaml:"retries"`
 	} `yaml:"health"`
 
+	Rules struct {
+		// Engine names the registered rule engine, e.g. default or opa
+		Engine string `yaml:"engine"`
+		// EngineConfig configures engines other than the default one
+		EngineConfig yaml.Node `yaml:"engine_config"`
+		// OnCallURL is the schedule API consulted by time policies requiring
+		// on-call status; on_call takes precedence when configured
+		OnCallURL string `yaml:"on_call_url"`
+		// Path is the YAML file with the rule definitions; built-in rules apply when empty
+		Path string `yaml:"path"`
+		// VersionsDir stores versioned rule definitions with staged rollout; takes
+		// precedence over Path
+		VersionsDir string `yaml:"versions_dir"`
+		// ReloadInterval controls how often the rule definitions are reloaded
+		ReloadInterval string `yaml:"reload_interval"`
+	} `yaml:"rules"`
+
+	// OnCall reads who is on call from PagerDuty or Opsgenie for on-call
+	// policies and Slack mentions
+	OnCall struct {
+		// Provider is pagerduty or opsgenie; on-call lookups are off when empty
+		Provider string `yaml:"provider"`
+		Token    string `yaml:"token"`
+		// BaseURL overrides the provider's API, e.g. https://api.eu.opsgenie.com
+		BaseURL string `yaml:"base_url"`
+		// Schedules maps the services of resources to a PagerDuty schedule ID
+		// or an Opsgenie schedule name
+		Schedules map[string]string `yaml:"schedules"`
+		// EmailDomain is stripped from on-call email addresses to get user IDs
+		EmailDomain string `yaml:"email_domain"`
+		// CacheTTL defaults to 1m
+		CacheTTL string `yaml:"cache_ttl"`
+	} `yaml:"on_call"`
+
+	// Directory syncs groups from the identity provider for policies and
+	// approval routes to refer to by name
+	Directory struct {
+		// Provider is google, okta or azure; group sync is off when empty
+		Provider string `yaml:"provider"`
+		// BaseURL is the Okta org URL, or overrides the Google or Graph API
+		BaseURL string `yaml:"base_url"`
+		// Token is the Okta API token
+		Token string `yaml:"token"`
+		// CredentialsFile is a Google service account key with domain-wide
+		// delegation, acting as Subject, a Workspace admin
+		CredentialsFile string `yaml:"credentials_file"`
+		Subject         string `yaml:"subject"`
+		Customer        string `yaml:"customer"`
+		// TenantID, ClientID and ClientSecret identify the Azure AD application
+		TenantID     string `yaml:"tenant_id"`
+		ClientID     string `yaml:"client_id"`
+		ClientSecret string `yaml:"client_secret"`
+		// Groups limits the sync to these groups; all groups when empty
+		Groups []string `yaml:"groups"`
+		// EmailDomain is stripped from member email addresses to get user IDs
+		EmailDomain string `yaml:"email_domain"`
+		// Interval defaults to 15m
+		Interval string `yaml:"interval"`
+	} `yaml:"directory"`
+
+	Auth struct {
+		// OIDC identifies the provider that issues step-up ID tokens
+		OIDC struct {
+			Issuer   string `yaml:"issuer"`
+			ClientID string `yaml:"client_id"`
+		} `yaml:"oidc"`
+		// JWT authenticates requests by bearer tokens of the trusted issuers;
+		// it's off without issuers
+		JWT struct {
+			Issuers []JWTIssuer `yaml:"issuers"`
+			// Required rejects requests without a token; otherwise they are
+			// trusted with the X-Apollo-User header they send
+			Required bool `yaml:"required"`
+			// Public adds paths, or prefixes ending in /, served without a token
+			Public []string `yaml:"public"`
+			// AdminGroups are groups whose members are administrators
+			AdminGroups []string `yaml:"admin_groups"`
+			// JWKSRefresh is how long signing keys are cached; defaults to 1h
+			JWKSRefresh string `yaml:"jwks_refresh"`
+			// Leeway is the clock skew tolerated; defaults to 1m
+			Leeway string `yaml:"leeway"`
+		} `yaml:"jwt"`
+		// OperatorHMAC checks requests operators sign with their shared secrets
+		OperatorHMAC struct {
+			// Secrets holds base64 encoded secrets of at least 32 bytes by operator ID
+			Secrets map[string]string `yaml:"secrets"`
+			// Required rejects unsigned requests to the endpoints operators call
+			Required bool `yaml:"required"`
+			// MaxSkew is how far a request's timestamp may be off; defaults to 5m
+			MaxSkew string `yaml:"max_skew"`
+		} `yaml:"operator_hmac"`
+		// OperatorTokens are the short-lived tokens operators get at registration
+		OperatorTokens struct {
+			// TTL is how long a token is valid; defaults to 15m
+			TTL string `yaml:"ttl"`
+			// Required rejects requests to the endpoints operators call, except
+			// registration, that carry neither a token nor a signature
+			Required bool `yaml:"required"`
+		} `yaml:"operator_tokens"`
+		// WebAuthn lets approvers confirm approvals with a hardware key from
+		// the dashboard, as the rules require for admin and root access to
+		// restricted resources
+		WebAuthn struct {
+			// RPID is the dashboard's domain, e.g. apollo.example.com
+			RPID   string `yaml:"rp_id"`
+			RPName string `yaml:"rp_name"`
+			// Origins are the dashboard origins, e.g. https://apollo.example.com
+			Origins []string `yaml:"origins"`
+			// ChallengeKey is a base64 encoded key signing challenges, shared
+			// by all servers; a random key is used when empty
+			ChallengeKey string `yaml:"challenge_key"`
+			// ChallengeTTL is how long a challenge is valid; defaults to 5m
+			ChallengeTTL string `yaml:"challenge_ttl"`
+		} `yaml:"webauthn"`
+		// CookieSessions lets the dashboard trade a bearer token for a cookie
+		// session protected against CSRF; it needs jwt issuers
+		CookieSessions struct {
+			Enabled bool `yaml:"enabled"`
+			// TTL is how long a session lasts; defaults to 8h
+			TTL string `yaml:"ttl"`
+			// Insecure sends the cookies over plain HTTP, for local development only
+			Insecure bool `yaml:"insecure"`
+			// Origins are the dashboard origins allowed to change state, e.g.
+			// https://apollo.example.com
+			Origins []string `yaml:"origins"`
+		} `yaml:"cookie_sessions"`
+	} `yaml:"auth"`
+
+	Anomaly struct {
+		// Enabled turns on the background analyzer that flags unusual access patterns
+		Enabled  bool   `yaml:"enabled"`
+		Interval string `yaml:"interval"`
+		// Timezone, QuietHoursStart and QuietHoursEnd define the odd hours, e.g. 22:00-06:00
+		Timezone        string `yaml:"timezone"`
+		QuietHoursStart string `yaml:"quiet_hours_start"`
+		QuietHoursEnd   string `yaml:"quiet_hours_end"`
+		// SpikeThreshold requests by one user within SpikeWindow count as a spike
+		SpikeWindow    string `yaml:"spike_window"`
+		SpikeThreshold int    `yaml:"spike_threshold"`
+	} `yaml:"anomaly"`
+
+	Retention struct {
+		// Enabled turns on the scheduled retention worker
+		Enabled  bool   `yaml:"enabled"`
+		Interval string `yaml:"interval"`
+		// Jobs, Grants and Audit set how long each kind of record is kept
+		Jobs   RetentionRule `yaml:"jobs"`
+		Grants RetentionRule `yaml:"grants"`
+		Audit  RetentionRule `yaml:"audit"`
+		// Archive is where archived records are exported
+		Archive struct {
+			// Type is s3 (also used for GCS with HMAC keys) or file
+			Type            string `yaml:"type"`
+			Endpoint        string `yaml:"endpoint"`
+			Region          string `yaml:"region"`
+			Bucket          string `yaml:"bucket"`
+			Prefix          string `yaml:"prefix"`
+			AccessKeyID     string `yaml:"access_key_id"`
+			SecretAccessKey string `yaml:"secret_access_key"`
+			Dir             string `yaml:"dir"`
+		} `yaml:"archive"`
+	} `yaml:"retention"`
+
+	AuditExport struct {
+		// Dir holds exports generated in the background; defaults to a temporary directory
+		Dir string `yaml:"dir"`
+		// SigningKey signs download links; a random key is used when empty, so links
+		// don't survive a restart
+		SigningKey string `yaml:"signing_key"`
+		// AsyncThreshold is the range above which exports are generated in the background
+		AsyncThreshold string `yaml:"async_threshold"`
+	} `yaml:"audit_export"`
+
+	// Approvals holds the Ed25519 keys approval records are signed with; a random
+	// key is used when none are configured, so approvals can't be verified after
+	// a restart
+	Approvals struct {
+		// SigningKeys holds base64 encoded 32 byte Ed25519 seeds by ID; CurrentKey
+		// signs new approvals, older keys are kept to verify what they signed
+		SigningKeys map[string]string `yaml:"signing_keys"`
+		CurrentKey  string            `yaml:"current_key"`
+	} `yaml:"approvals"`
+
+	// Encryption selects the master key that wraps the data keys of stored grant
+	// credentials; a random key is used when no provider is set, so stored
+	// credentials don't survive a restart
+	Encryption struct {
+		// Provider is local, aws-kms or gcp-kms
+		Provider string `yaml:"provider"`
+		// Local holds base64 encoded 32 byte master keys by ID; CurrentKey wraps new
+		// data keys, older keys are kept until rotation has rewrapped their data keys
+		Local struct {
+			Keys       map[string]string `yaml:"keys"`
+			CurrentKey string            `yaml:"current_key"`
+		} `yaml:"local"`
+		AWSKMS struct {
+			KeyID           string `yaml:"key_id"`
+			Region          string `yaml:"region"`
+			Endpoint        string `yaml:"endpoint"`
+			AccessKeyID     string `yaml:"access_key_id"`
+			SecretAccessKey string `yaml:"secret_access_key"`
+			SessionToken    string `yaml:"session_token"`
+		} `yaml:"aws_kms"`
+		GCPKMS struct {
+			// KeyName is projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+			KeyName  string `yaml:"key_name"`
+			Endpoint string `yaml:"endpoint"`
+		} `yaml:"gcp_kms"`
+	} `yaml:"encryption"`
+
+	// Secrets selects where sealed grant credentials are kept; they stay in the
+	// database when no provider is set
+	Secrets struct {
+		// Provider is database, vault or aws-secrets-manager
+		Provider string `yaml:"provider"`
+		Vault    struct {
+			Address string `yaml:"address"`
+			Token   string `yaml:"token"`
+			// Mount is the path of the KV version 2 engine; defaults to secret
+			Mount string `yaml:"mount"`
+		} `yaml:"vault"`
+		AWSSecretsManager struct {
+			Region          string `yaml:"region"`
+			Endpoint        string `yaml:"endpoint"`
+			AccessKeyID     string `yaml:"access_key_id"`
+			SecretAccessKey string `yaml:"secret_access_key"`
+			SessionToken    string `yaml:"session_token"`
+		} `yaml:"aws_secrets_manager"`
+		// ReferenceKey is a base64 encoded key of at least 32 bytes signing the
+		// references holders redeem for credentials; API servers behind one load
+		// balancer must share it. A random key is used when empty.
+		ReferenceKey string `yaml:"reference_key"`
+		// ReferenceTTL is how long a reference can be redeemed; defaults to 5m
+		ReferenceTTL string `yaml:"reference_ttl"`
+	} `yaml:"secrets"`
+
+	// Slack posts request lifecycle events to channels when a token is set
 	Slack struct {
-		Token   string `yaml:"token"`
-		Channel string `yaml:"channel"`
+		Token string `yaml:"token"`
+		// Channel receives the events no route matches
+		Channel string       `yaml:"channel"`
+		Routes  []SlackRoute `yaml:"routes"`
+		// Templates override the message per event type, e.g. requested, with
+		// a Go template; see the slack package for the fields available
+		Templates map[string]string `yaml:"templates"`
+		// DirectMessages tells requesters about their own requests in a DM
+		DirectMessages struct {
+			Enabled bool `yaml:"enabled"`
+			// EmailDomain is appended to user IDs that aren't email addresses
+			// to look up their Slack accounts
+			EmailDomain string `yaml:"email_domain"`
+			// Templates override the DM per event type
+			Templates map[string]string `yaml:"templates"`
+		} `yaml:"direct_messages"`
 	} `yaml:"slack"`
+
+	// Discord posts request lifecycle events to channels, routed like Slack's,
+	// when a channel or routes are set
+	Discord struct {
+		// BotToken is needed to post to channel IDs; webhook URLs need none
+		BotToken string `yaml:"bot_token"`
+		// Channel receives the events no route matches: an incoming webhook
+		// URL or a channel ID
+		Channel string       `yaml:"channel"`
+		Routes  []SlackRoute `yaml:"routes"`
+		// Templates override the message per event type
+		Templates map[string]string `yaml:"templates"`
+	} `yaml:"discord"`
+
+	// Mattermost posts request lifecycle events through an incoming webhook,
+	// routed like Slack's, when a webhook URL is set
+	Mattermost struct {
+		WebhookURL string `yaml:"webhook_url"`
+		// Channel receives the events no route matches; defaults to the
+		// webhook's channel. Other channels require the webhook to allow them.
+		Channel   string            `yaml:"channel"`
+		Routes    []SlackRoute      `yaml:"routes"`
+		Templates map[string]string `yaml:"templates"`
+		// Actions adds approve and deny buttons to messages about new requests
+		Actions struct {
+			Enabled bool `yaml:"enabled"`
+			// BaseURL is the API's URL as the Mattermost server reaches it
+			BaseURL string `yaml:"base_url"`
+			// Key is a base64 encoded key of at least 32 bytes signing the
+			// buttons; API servers must share it. A random key is used when
+			// empty, so buttons stop working after a restart.
+			Key string `yaml:"key"`
+		} `yaml:"actions"`
+	} `yaml:"mattermost"`
+
+	// SIEM ships every grant lifecycle event to security monitoring through
+	// the outbox, which holds them while the SIEM is unreachable
+	SIEM struct {
+		Splunk struct {
+			// URL is the HTTP Event Collector, e.g. https://splunk.example.com:8088;
+			// Splunk is off when empty
+			URL   string `yaml:"url"`
+			Token string `yaml:"token"`
+			// Index overrides the token's default index
+			Index string `yaml:"index"`
+			// Source defaults to apollo
+			Source string `yaml:"source"`
+		} `yaml:"splunk"`
+		Syslog struct {
+			// Address is the receiver's host:port; syslog is off when empty
+			Address string `yaml:"address"`
+			// Network is udp, tcp or tls; defaults to tls
+			Network string `yaml:"network"`
+			// Format is rfc5424, with the event as JSON, or cef; defaults to rfc5424
+			Format string `yaml:"format"`
+			// CAFile verifies the receiver's certificate instead of the system roots
+			CAFile string `yaml:"ca_file"`
+		} `yaml:"syslog"`
+	} `yaml:"siem"`
+
+	// Digest emails approvers a daily summary of the requests waiting on them
+	// with one-click approve and deny links
+	Digest struct {
+		Enabled bool `yaml:"enabled"`
+		// At is the local time of day the digest is sent; defaults to 09:00
+		At       string `yaml:"at"`
+		Timezone string `yaml:"timezone"`
+		// BaseURL is the API's external URL the links point at
+		BaseURL string `yaml:"base_url"`
+		// DefaultApprovers receive the requests that don't name their approvers
+		DefaultApprovers []string `yaml:"default_approvers"`
+		// EmailDomain is appended to approver IDs that aren't email addresses
+		EmailDomain string `yaml:"email_domain"`
+		// LinkKey is a base64 encoded key of at least 32 bytes signing the
+		// links; API servers behind one load balancer must share it. A random
+		// key is used when empty, so links don't survive a restart.
+		LinkKey string `yaml:"link_key"`
+		// LinkTTL is how long the links work; defaults to 24h
+		LinkTTL string `yaml:"link_ttl"`
+		SMTP    struct {
+			// Address is the server's host:port
+			Address  string `yaml:"address"`
+			From     string `yaml:"from"`
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+		} `yaml:"smtp"`
+	} `yaml:"digest"`
+
+	// Reports summarize the grants issued per team owning the resources, as
+	// downloadable files optionally mailed to the teams
+	Reports struct {
+		Enabled bool `yaml:"enabled"`
+		// Period is weekly, reporting on Mondays, or monthly, reporting on
+		// the first of the month; defaults to weekly
+		Period   string `yaml:"period"`
+		Timezone string `yaml:"timezone"`
+		// Dir is where the reports are written; defaults to a temporary directory
+		Dir string `yaml:"dir"`
+		// Recipients maps teams, the owners of resources in the rules, to the
+		// addresses their report is mailed to
+		Recipients map[string][]string `yaml:"recipients"`
+		// SMTP sends the mails; reports aren't mailed when its address is empty
+		SMTP struct {
+			Address  string `yaml:"address"`
+			From     string `yaml:"from"`
+			Username string `yaml:"username"`
+			Password string `yaml:"password"`
+		} `yaml:"smtp"`
+	} `yaml:"reports"`
+
+	// Features turns subsystems on and off: auto_approval, webhooks and
+	// dashboard. APOLLO_FEATURE_<NAME> environment variables take precedence.
+	Features struct {
+		// Flags maps features to true, false or the percentage of users
+		// they're on for, e.g. 25%; features are on when not listed
+		Flags map[string]string `yaml:"flags"`
+		// Path is a YAML file of flags, reloaded while the server runs, that
+		// takes precedence over Flags
+		Path string `yaml:"path"`
+		// ReloadInterval controls how often the file and the environment are
+		// read again; defaults to 30s
+		ReloadInterval string `yaml:"reload_interval"`
+	} `yaml:"features"`
+}
+
+// SlackRoute sends the events of matching requests to a channel; the first
+// route whose non-empty lists all contain the request's value wins
+type SlackRoute struct {
+	Modules      []string `yaml:"modules"`
+	Environments []string `yaml:"environments"`
+	// Severities are low, medium or high, derived from the risk score
+	Severities []string `yaml:"severities"`
+	Events     []string `yaml:"events"`
+	Channel    string   `yaml:"channel"`
+}
+
+// EventWebhook receives grant lifecycle events as JSON posts
+type EventWebhook struct {
+	URL string `yaml:"url"`
+	// Secret signs the posts with HMAC-SHA256 in the X-Apollo-Signature header
+	Secret string `yaml:"secret"`
+	// Encoding overrides the events encoding for this webhook
+	Encoding string `yaml:"encoding"`
+	// Mode is structured or binary for CloudEvents; defaults to structured
+	Mode string `yaml:"mode"`
+}
+
+// JWTIssuer is an issuer whose bearer tokens are trusted
+type JWTIssuer struct {
+	Issuer string `yaml:"issuer"`
+	// Audiences are the aud values accepted
+	Audiences []string `yaml:"audiences"`
+	// JWKSURL defaults to the jwks_uri found via OIDC discovery
+	JWKSURL string `yaml:"jwks_url"`
+	// UserClaim holds the user ID; defaults to email, then the subject
+	UserClaim string `yaml:"user_claim"`
+	// GroupsClaim lists the user's groups; defaults to groups
+	GroupsClaim string `yaml:"groups_claim"`
+	// Nonce accepts every token only once, by its nonce
+	Nonce bool `yaml:"nonce"`
+}
+
+// RetentionRule sets how long one kind of record is kept
+type RetentionRule struct {
+	// MaxAge is a duration such as 720h; records are kept forever when empty
+	MaxAge string `yaml:"max_age"`
+	// Action is purge or archive
+	Action string `yaml:"action"`
+}
+
+// durationSuffixes end the YAML keys of settings holding a duration such as 30s
+var durationSuffixes = []string{
+	"interval", "timeout", "ttl", "_sla", "delay", "_after", "max_age", "history",
+	"warning", "window", "threshold", "refresh", "leeway", "skew",
 }
 
 // LoadConfig loads the configuration from a YAML file
 func LoadConfig(path string) (*Config, error) {
+	cfg, err := Parse(path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Validate config
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %v", errors.Join(errs...))
+	}
+
+	return cfg, nil
+}
+
+// Parse reads a YAML file and resolves its environment variable and secret
+// references, without validating the result. When the file parses, the
+// configuration is returned even with an error, so that what did resolve can
+// still be validated.
+func Parse(path string) (*Config, error) {
 	// Read config file
 	data, err := os.ReadFile(path)
 	if err != nil {
@@ -52,30 +623,95 @@ func LoadConfig(path string) (*Config, error) {
 
 	// Parse YAML
 	var cfg Config
-	if err := yaml.Unmarshal(data, &cfg); err != nil {
+	if err := configref.Unmarshal(data, &cfg); err != nil {
+		var refErr *configref.Error
+		if errors.As(err, &refErr) {
+			return &cfg, fmt.Errorf("failed to resolve config references: %w", err)
+		}
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
 
-	// Validate config
-	if err := validateConfig(&cfg); err != nil {
-		return nil, fmt.Errorf("invalid config: %v", err)
-	}
-
 	return &cfg, nil
 }
 
-// validateConfig validates the configuration
-func validateConfig(cfg *Config) error {
-	if cfg.Server.Port == 0 {
-		return fmt.Errorf("server port is required")
+// Validate returns every problem with the configuration: missing required
+// settings, unsupported bus types and durations that don't parse
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.Server.Port == 0 {
+		errs = append(errs, fmt.Errorf("server port is required"))
+	}
+	if c.Server.Host == "" {
+		errs = append(errs, fmt.Errorf("server host is required"))
 	}
-	if cfg.Server.Host == "" {
-		return fmt.Errorf("server host is required")
+	if c.Server.EnabledModules == "" {
+		errs = append(errs, fmt.Errorf("enabled modules are required"))
 	}
-	if cfg.Server.EnabledModules == "" {
-		return fmt.Errorf("enabled modules are required")
+	if c.Server.GRPCPort != 0 && c.Server.GRPCPort == c.Server.Port {
+		errs = append(errs, fmt.Errorf("server grpc_port must differ from port"))
+	}
+	// The server reaches operators in other processes only over NATS
+	switch c.Bus.Type {
+	case "":
+	case bus.TypeNATS:
+		if c.Bus.URL == "" {
+			errs = append(errs, fmt.Errorf("bus.url is required for the nats bus"))
+		}
+	case bus.TypeMemory:
+		errs = append(errs, fmt.Errorf("bus.type memory only reaches operators in the same process, as in apollo dev"))
+	default:
+		errs = append(errs, fmt.Errorf("bus.type %q is not supported; use nats", c.Bus.Type))
+	}
+	return append(errs, validateDurations(reflect.ValueOf(c).Elem(), "")...)
+}
+
+// validateDurations checks the duration settings in a section of the
+// configuration, naming each by its path of YAML keys
+func validateDurations(v reflect.Value, path string) []error {
+	var errs []error
+	switch v.Kind() {
+	case reflect.Struct:
+		for i := 0; i < v.NumField(); i++ {
+			key, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("yaml"), ",")
+			if key == "" || key == "-" {
+				continue
+			}
+			if path != "" {
+				key = path + "." + key
+			}
+			errs = append(errs, validateDurations(v.Field(i), key)...)
+		}
+	case reflect.Slice:
+		for i := 0; i < v.Len(); i++ {
+			errs = append(errs, validateDurations(v.Index(i), fmt.Sprintf("%s[%d]", path, i))...)
+		}
+	case reflect.Map:
+		if v.Type().Key().Kind() != reflect.String {
+			break
+		}
+		for _, key := range v.MapKeys() {
+			errs = append(errs, validateDurations(v.MapIndex(key), path+"."+key.String())...)
+		}
+	case reflect.String:
+		if v.String() == "" || !isDurationKey(path) {
+			break
+		}
+		if _, err := time.ParseDuration(v.String()); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %v", path, err))
+		}
+	}
+	return errs
+}
+
+// isDurationKey reports whether the setting at a path holds a duration
+func isDurationKey(path string) bool {
+	key := path[strings.LastIndex(path, ".")+1:]
+	for _, suffix := range durationSuffixes {
+		if strings.HasSuffix(key, suffix) {
+			return true
+		}
 	}
-	return nil
+	return false
 }
 
 // GetModuleConfig returns the configuration for a specific module
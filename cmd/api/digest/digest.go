@@ -0,0 +1,215 @@
+// Package digest emails approvers a daily summary of the privilege requests
+// waiting on them, with signed links to approve or deny each one
+package digest
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Config configures the digest
+type Config struct {
+	// At is the local time of day the digest is sent, e.g. 09:00
+	At string
+	// Timezone is the IANA timezone At is in; UTC when empty
+	Timezone string
+	// BaseURL is the API's external URL the links point at, e.g.
+	// https://apollo.example.com
+	BaseURL string
+	// DefaultApprovers receive the requests that don't name their approvers
+	DefaultApprovers []string
+	// EmailDomain is appended to approver IDs that aren't email addresses
+	EmailDomain string
+}
+
+// Digest sends the daily digest
+type Digest struct {
+	store    store.Store
+	mailer   Mailer
+	links    *Links
+	config   Config
+	at       int
+	location *time.Location
+}
+
+// New creates a digest of the requests in the store mailed with the mailer
+func New(s store.Store, mailer Mailer, links *Links, config Config) (*Digest, error) {
+	if config.At == "" {
+		config.At = "09:00"
+	}
+	at, err := time.Parse("15:04", config.At)
+	if err != nil {
+		return nil, fmt.Errorf("invalid digest time %q: expected HH:MM", config.At)
+	}
+	location := time.UTC
+	if config.Timezone != "" {
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+	}
+	if _, err := url.ParseRequestURI(config.BaseURL); err != nil {
+		return nil, fmt.Errorf("invalid digest base URL %q", config.BaseURL)
+	}
+	config.BaseURL = strings.TrimSuffix(config.BaseURL, "/")
+	return &Digest{
+		store:    s,
+		mailer:   mailer,
+		links:    links,
+		config:   config,
+		at:       at.Hour()*60 + at.Minute(),
+		location: location,
+	}, nil
+}
+
+// Start sends the digest every day at the configured time until the context is cancelled
+func (d *Digest) Start(ctx context.Context) {
+	go func() {
+		for {
+			timer := time.NewTimer(time.Until(d.next(time.Now())))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+				sent, err := d.Run(ctx)
+				if err != nil {
+					log.Printf("Failed to send approval digest: %v", err)
+				}
+				log.Printf("Sent approval digest to %d approvers", sent)
+			}
+		}
+	}()
+}
+
+// next returns the first time the digest is due after now
+func (d *Digest) next(now time.Time) time.Time {
+	local := now.In(d.location)
+	due := time.Date(local.Year(), local.Month(), local.Day(), d.at/60, d.at%60, 0, 0, d.location)
+	if !due.After(now) {
+		due = due.AddDate(0, 0, 1)
+	}
+	return due
+}
+
+// Run mails every approver the pending requests waiting on them and returns
+// how many were mailed. An approver that can't be mailed doesn't keep the
+// others from getting theirs.
+func (d *Digest) Run(ctx context.Context) (int, error) {
+	pending, err := d.waiting(ctx)
+	if err != nil {
+		return 0, err
+	}
+	approvers := make([]string, 0, len(pending))
+	for approver := range pending {
+		approvers = append(approvers, approver)
+	}
+	sort.Strings(approvers)
+
+	now := time.Now()
+	sent := 0
+	var failed []string
+	for _, approver := range approvers {
+		to := d.address(approver)
+		if to == "" {
+			log.Printf("Skipping approval digest for %s: no email address", approver)
+			continue
+		}
+		subject, body := d.compose(approver, pending[approver], now)
+		if err := d.mailer.Send(ctx, to, subject, body); err != nil {
+			log.Printf("Failed to send approval digest to %s: %v", to, err)
+			failed = append(failed, approver)
+			continue
+		}
+		sent++
+	}
+	if len(failed) > 0 {
+		return sent, fmt.Errorf("failed to mail %s", strings.Join(failed, ", "))
+	}
+	return sent, nil
+}
+
+// waiting returns the pending requests by the approvers who still have to
+// decide on them, oldest first. Requests awaiting step-up authentication
+// wait on their requester instead.
+func (d *Digest) waiting(ctx context.Context) (map[string][]*models.PrivilegeRequest, error) {
+	requests, err := d.store.ListRequests(ctx, store.RequestFilter{Status: store.RequestStatusPending})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list pending requests: %v", err)
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+
+	now := time.Now()
+	pending := make(map[string][]*models.PrivilegeRequest)
+	for _, request := range requests {
+		// Requests past their deadline expire before anyone could act on them
+		if !request.StepUp.Satisfied() || request.Lapsed(now) {
+			continue
+		}
+		approvers := request.Approvers
+		if len(approvers) == 0 {
+			approvers = d.config.DefaultApprovers
+		}
+		approvals, err := d.store.GetApprovals(ctx, request.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get approvals of request %s: %v", request.ID, err)
+		}
+		for _, approver := range approvers {
+			if approver == request.UserID || slices.Contains(approvals, approver) {
+				continue
+			}
+			pending[approver] = append(pending[approver], request)
+		}
+	}
+	return pending, nil
+}
+
+// compose writes the digest of an approver's pending requests
+func (d *Digest) compose(approver string, requests []*models.PrivilegeRequest, now time.Time) (string, string) {
+	subject := "1 access request is waiting for your approval"
+	if len(requests) > 1 {
+		subject = fmt.Sprintf("%d access requests are waiting for your approval", len(requests))
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "Hi %s,\n\n%s.\n", approver, subject)
+	for _, request := range requests {
+		fmt.Fprintf(&b, "\n%s requests %s access to %s %s\n", request.UserID, request.Level, request.Module, request.ResourceID)
+		fmt.Fprintf(&b, "  Reason: %s\n", request.Reason)
+		fmt.Fprintf(&b, "  Risk score %d, requested %s\n", request.RiskScore, request.CreatedAt.In(d.location).Format("2006-01-02 15:04 MST"))
+		if request.RequiredApprovals > 1 {
+			fmt.Fprintf(&b, "  Needs %d approvals\n", request.RequiredApprovals)
+		}
+		fmt.Fprintf(&b, "  Approve: %s\n", d.link(request.ID, approver, ActionApprove, now))
+		fmt.Fprintf(&b, "  Deny:    %s\n", d.link(request.ID, approver, ActionDeny, now))
+	}
+	fmt.Fprintf(&b, "\nThe links are valid until %s and ask you to confirm before anything changes.\n",
+		now.Add(d.links.ttl).In(d.location).Format("2006-01-02 15:04 MST"))
+	return subject, b.String()
+}
+
+// link returns the URL of a signed link taking the action on a request
+func (d *Digest) link(requestID, approver, action string, now time.Time) string {
+	return d.config.BaseURL + "/api/v1/approvals/" + d.links.Issue(requestID, approver, action, now)
+}
+
+// address returns the email address of an approver, or nothing when it has none
+func (d *Digest) address(approver string) string {
+	if strings.Contains(approver, "@") {
+		return approver
+	}
+	if d.config.EmailDomain == "" {
+		return ""
+	}
+	return approver + "@" + d.config.EmailDomain
+}
@@ -0,0 +1,102 @@
+package digest
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"mime"
+	"net"
+	"net/smtp"
+	"time"
+)
+
+// Mailer sends plain text emails
+type Mailer interface {
+	Send(ctx context.Context, to, subject, body string) error
+}
+
+// SMTP sends emails through an SMTP server, upgrading the connection with
+// STARTTLS when the server offers it
+type SMTP struct {
+	address  string
+	host     string
+	from     string
+	username string
+	password string
+}
+
+// NewSMTP creates a mailer sending from the address through the server at
+// host:port, authenticating when a username is set
+func NewSMTP(address, from, username, password string) (*SMTP, error) {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return nil, fmt.Errorf("invalid SMTP address %q: %v", address, err)
+	}
+	if from == "" {
+		return nil, fmt.Errorf("SMTP sender address is required")
+	}
+	return &SMTP{address: address, host: host, from: from, username: username, password: password}, nil
+}
+
+// Send sends an email
+func (m *SMTP) Send(ctx context.Context, to, subject, body string) error {
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+
+	conn, err := (&net.Dialer{}).DialContext(ctx, "tcp", m.address)
+	if err != nil {
+		return fmt.Errorf("failed to connect to SMTP server: %v", err)
+	}
+	deadline, _ := ctx.Deadline()
+	conn.SetDeadline(deadline)
+
+	client, err := smtp.NewClient(conn, m.host)
+	if err != nil {
+		conn.Close()
+		return fmt.Errorf("failed to start SMTP session: %v", err)
+	}
+	defer client.Close()
+
+	if ok, _ := client.Extension("STARTTLS"); ok {
+		if err := client.StartTLS(&tls.Config{ServerName: m.host}); err != nil {
+			return fmt.Errorf("failed to start TLS: %v", err)
+		}
+	}
+	if m.username != "" {
+		if err := client.Auth(smtp.PlainAuth("", m.username, m.password, m.host)); err != nil {
+			return fmt.Errorf("failed to authenticate to SMTP server: %v", err)
+		}
+	}
+	if err := client.Mail(m.from); err != nil {
+		return fmt.Errorf("SMTP server rejected sender: %v", err)
+	}
+	if err := client.Rcpt(to); err != nil {
+		return fmt.Errorf("SMTP server rejected recipient %s: %v", to, err)
+	}
+	w, err := client.Data()
+	if err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	if _, err := w.Write(message(m.from, to, subject, body)); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed to send email: %v", err)
+	}
+	return client.Quit()
+}
+
+// message formats a plain text email
+func message(from, to, subject, body string) []byte {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "From: %s\r\n", from)
+	fmt.Fprintf(&b, "To: %s\r\n", to)
+	fmt.Fprintf(&b, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(&b, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	b.WriteString("MIME-Version: 1.0\r\n")
+	b.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	b.WriteString("Content-Transfer-Encoding: 8bit\r\n\r\n")
+	b.WriteString(body)
+	return b.Bytes()
+}
@@ -0,0 +1,107 @@
+// Package discord posts request lifecycle events to Discord channels
+package discord
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is Discord's API
+const DefaultBaseURL = "https://discord.com/api/v10"
+
+// maxContentLength is the longest message Discord accepts, in characters
+const maxContentLength = 2000
+
+// Client posts messages through incoming webhooks or as a bot
+type Client struct {
+	botToken string
+	baseURL  string
+	client   *http.Client
+}
+
+// NewClient creates a client for the API at baseURL, DefaultBaseURL when
+// empty. Without a bot token it can only post through webhooks.
+func NewClient(botToken, baseURL string) *Client {
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		botToken: botToken,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Post posts a message formatted with Discord's markdown to a channel, which
+// is either an incoming webhook URL or the ID of a channel the bot can post
+// in. Mentions in the message never ping anyone, so request reasons can't.
+func (c *Client) Post(ctx context.Context, channel, content string) error {
+	if runes := []rune(content); len(runes) > maxContentLength {
+		content = string(runes[:maxContentLength-1]) + "…"
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"content":          content,
+		"allowed_mentions": map[string]interface{}{"parse": []string{}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+
+	endpoint := channel
+	webhook := strings.HasPrefix(channel, "https://")
+	if !webhook {
+		if c.botToken == "" {
+			return fmt.Errorf("a bot token is required to post to channel %s", channel)
+		}
+		endpoint = c.baseURL + "/channels/" + channel + "/messages"
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if !webhook {
+		req.Header.Set("Authorization", "Bot "+c.botToken)
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The error names the URL, which holds a webhook's token
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to post to discord: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return fmt.Errorf("discord rate limited the message, retry after %ss", resp.Header.Get("Retry-After"))
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("discord returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
+
+// describe names a channel in logs and errors without a webhook's token
+func describe(channel string) string {
+	if !strings.HasPrefix(channel, "https://") {
+		return channel
+	}
+	// https://discord.com/api/webhooks/<id>/<token>
+	parts := strings.Split(strings.TrimSuffix(channel, "/"), "/")
+	if len(parts) < 2 {
+		return "webhook"
+	}
+	return "webhook " + parts[len(parts)-2]
+}
@@ -0,0 +1,106 @@
+package discord
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/petermein/apollo/cmd/api/notify"
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// defaultTemplates format the events that have no template configured
+var defaultTemplates = map[string]string{
+	store.EventRequested:   `🔑 {{if .Request.HandedOverBy}}**{{.Request.HandedOverBy}}** asks to hand {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} over to **{{.Request.UserID}}**{{else}}**{{.Request.UserID}}** requested {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}{{end}}{{if .Environment}} ({{.Environment}}){{end}} - risk {{.Request.RiskScore}}: {{.Request.Reason}}`,
+	store.EventApproved:    `✅ {{if .Event.Actor}}{{.Event.Actor}} approved{{else}}Auto-approved{{end}} {{.Request.UserID}}'s {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}{{if .Grant}} until {{.Grant.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{end}}`,
+	store.EventDenied:      `⛔ {{.Event.Actor}} denied {{.Request.UserID}}'s {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}`,
+	store.EventProvisioned: `🔓 {{.Request.UserID}}'s {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} is ready`,
+	store.EventExtended:    `⏳ {{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}} was extended{{if .Grant}} until {{.Grant.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{end}}`,
+	store.EventRevoked:     `🔒 {{if .Event.Actor}}{{.Event.Actor}} revoked {{end}}{{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}}{{if not .Event.Actor}} was revoked{{end}}`,
+	store.EventExpired:     `🔒 {{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}} expired`,
+	store.EventClosed:      `🗑️ {{.Request.UserID}}'s request for {{.Request.Module}} {{.Request.ResourceID}} was closed without a decision`,
+	store.EventLapsed:      `⌛ {{.Request.UserID}}'s request for {{.Request.Module}} {{.Request.ResourceID}} expired without a decision`,
+	store.EventHandedOver:  `🔁 {{.Event.Actor}} handed {{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}} over to {{if .Grant}}{{.Grant.ToUserID}}{{else}}another user{{end}}`,
+}
+
+// Config configures the notifier
+type Config struct {
+	// Channel receives the events no route matches; they are dropped when empty
+	Channel string
+	Routes  []notify.Route
+	// Templates override the default message per event type with a Go
+	// template executed against notify.MessageData
+	Templates map[string]string
+	// Environment returns the environment of a resource, e.g. production;
+	// routes by environment never match without it
+	Environment func(resourceID string) string
+}
+
+// Notifier posts request lifecycle events to Discord channels, routed the
+// same way as Slack's. It is an outbox publisher, so a message that fails to
+// post is retried.
+type Notifier struct {
+	client      *Client
+	channel     string
+	routes      []notify.Route
+	templates   map[string]*template.Template
+	environment func(resourceID string) string
+	store       store.Store
+}
+
+// NewNotifier creates a notifier posting with the client and reading the
+// requests events refer to from the store
+func NewNotifier(client *Client, s store.Store, config Config) (*Notifier, error) {
+	if err := notify.ValidateRoutes(config.Routes); err != nil {
+		return nil, fmt.Errorf("discord %v", err)
+	}
+	templates, err := notify.ParseTemplates(defaultTemplates, config.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("discord %v", err)
+	}
+
+	environment := config.Environment
+	if environment == nil {
+		environment = func(string) string { return "" }
+	}
+	return &Notifier{
+		client:      client,
+		channel:     config.Channel,
+		routes:      config.Routes,
+		templates:   templates,
+		environment: environment,
+		store:       s,
+	}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (n *Notifier) Name() string {
+	return "discord"
+}
+
+// Publish posts an event to the channel it is routed to
+func (n *Notifier) Publish(ctx context.Context, event store.Event) error {
+	tmpl, ok := n.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	data, err := notify.NewMessageData(ctx, n.store, event)
+	if err != nil {
+		return err
+	}
+	data.Environment = n.environment(data.Request.ResourceID)
+	channel := notify.Channel(n.routes, n.channel, data)
+	if channel == "" {
+		return nil
+	}
+
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		return fmt.Errorf("failed to format %s event: %v", event.Type, err)
+	}
+	if err := n.client.Post(ctx, channel, text.String()); err != nil {
+		return fmt.Errorf("failed to post %s event to %s: %v", event.Type, describe(channel), err)
+	}
+	return nil
+}
@@ -0,0 +1,102 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/sigv4"
+)
+
+// AWSKMS wraps data keys with a key in AWS KMS. KMS rotates the key material
+// of a key on its own; moving to another key means changing the key ID and
+// rewrapping.
+type AWSKMS struct {
+	keyID       string
+	region      string
+	endpoint    string
+	credentials sigv4.Credentials
+	client      *http.Client
+}
+
+// NewAWSKMS creates a key manager for the KMS key with the given ID or ARN
+func NewAWSKMS(keyID, region, endpoint string, credentials sigv4.Credentials) (*AWSKMS, error) {
+	if keyID == "" || region == "" {
+		return nil, fmt.Errorf("KMS key ID and region are required")
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access key ID and secret access key are required")
+	}
+	if endpoint == "" {
+		endpoint = "https://kms." + region + ".amazonaws.com"
+	}
+	return &AWSKMS{
+		keyID:       keyID,
+		region:      region,
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		credentials: credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// KeyID names the master key new data keys are wrapped with
+func (k *AWSKMS) KeyID() string {
+	return k.keyID
+}
+
+// Wrap encrypts a data key with the KMS key
+func (k *AWSKMS) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var resp struct {
+		CiphertextBlob []byte
+	}
+	if err := k.call(ctx, "Encrypt", map[string]interface{}{"KeyId": k.keyID, "Plaintext": dataKey}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.CiphertextBlob, nil
+}
+
+// Unwrap decrypts a data key wrapped by the named KMS key
+func (k *AWSKMS) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte
+	}
+	if err := k.call(ctx, "Decrypt", map[string]interface{}{"KeyId": keyID, "CiphertextBlob": wrapped}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// call invokes a KMS API action; byte slices travel base64 encoded both ways
+func (k *AWSKMS) call(ctx context.Context, action string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal KMS %s request: %v", action, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "TrentService."+action)
+	sigv4.Sign(req, body, k.credentials, k.region, "kms", time.Now().UTC())
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call KMS %s: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("KMS %s returned status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode KMS %s response: %v", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,127 @@
+// Package envelope encrypts sensitive data at rest with envelope encryption:
+// every value is encrypted with its own data key, and the data key is stored
+// wrapped by a master key held in a key management service.
+package envelope
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"fmt"
+)
+
+// dataKeySize is the size of the AES-256 data keys
+const dataKeySize = 32
+
+// KeyManager wraps data keys with a master key it holds
+type KeyManager interface {
+	// KeyID names the master key new data keys are wrapped with
+	KeyID() string
+	// Wrap encrypts a data key with the current master key
+	Wrap(ctx context.Context, dataKey []byte) ([]byte, error)
+	// Unwrap decrypts a data key wrapped by the named master key
+	Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error)
+}
+
+// Sealed is an encrypted value together with its wrapped data key
+type Sealed struct {
+	KeyID      string `json:"key_id"`
+	WrappedKey []byte `json:"wrapped_key"`
+	Nonce      []byte `json:"nonce"`
+	Ciphertext []byte `json:"ciphertext"`
+}
+
+// Encrypter seals and opens values using data keys wrapped by a key manager
+type Encrypter struct {
+	keys KeyManager
+}
+
+// New creates an encrypter wrapping its data keys with the key manager
+func New(keys KeyManager) *Encrypter {
+	return &Encrypter{keys: keys}
+}
+
+// KeyID names the master key new values are sealed under
+func (e *Encrypter) KeyID() string {
+	return e.keys.KeyID()
+}
+
+// Seal encrypts plaintext under a new data key. The additional data is
+// authenticated but not encrypted, and must be passed to Open unchanged;
+// binding a value to its owner this way stops it from being moved to another.
+func (e *Encrypter) Seal(ctx context.Context, plaintext, additionalData []byte) (*Sealed, error) {
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, fmt.Errorf("failed to generate data key: %v", err)
+	}
+	defer clear(dataKey)
+
+	nonce, ciphertext, err := seal(dataKey, plaintext, additionalData)
+	if err != nil {
+		return nil, err
+	}
+	keyID := e.keys.KeyID()
+	wrapped, err := e.keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	return &Sealed{KeyID: keyID, WrappedKey: wrapped, Nonce: nonce, Ciphertext: ciphertext}, nil
+}
+
+// Open decrypts a sealed value
+func (e *Encrypter) Open(ctx context.Context, sealed *Sealed, additionalData []byte) ([]byte, error) {
+	dataKey, err := e.keys.Unwrap(ctx, sealed.KeyID, sealed.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	defer clear(dataKey)
+
+	gcm, err := newGCM(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, sealed.Nonce, sealed.Ciphertext, additionalData)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decrypt value: %v", err)
+	}
+	return plaintext, nil
+}
+
+// Rewrap wraps the data key of a sealed value with the current master key,
+// leaving the ciphertext as it is, so the old master key can be retired
+func (e *Encrypter) Rewrap(ctx context.Context, sealed *Sealed) (*Sealed, error) {
+	dataKey, err := e.keys.Unwrap(ctx, sealed.KeyID, sealed.WrappedKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to unwrap data key: %v", err)
+	}
+	defer clear(dataKey)
+
+	keyID := e.keys.KeyID()
+	wrapped, err := e.keys.Wrap(ctx, dataKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to wrap data key: %v", err)
+	}
+	return &Sealed{KeyID: keyID, WrappedKey: wrapped, Nonce: sealed.Nonce, Ciphertext: sealed.Ciphertext}, nil
+}
+
+// seal encrypts plaintext with AES-GCM under key and a random nonce
+func seal(key, plaintext, additionalData []byte) ([]byte, []byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, nil, err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	return nonce, gcm.Seal(nil, nonce, plaintext, additionalData), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key: %v", err)
+	}
+	return cipher.NewGCM(block)
+}
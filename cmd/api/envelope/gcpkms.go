@@ -0,0 +1,143 @@
+package envelope
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcpKMSEndpoint = "https://cloudkms.googleapis.com/v1/"
+	// gcpTokenURL serves access tokens of the instance's service account
+	gcpTokenURL = "http://metadata.google.internal/computeMetadata/v1/instance/service-accounts/default/token"
+)
+
+// GCPKMS wraps data keys with a key in Google Cloud KMS, authenticating as
+// the service account of the instance it runs on. Cloud KMS picks the key
+// version itself, so rotating the key's primary version needs no rewrapping.
+type GCPKMS struct {
+	keyName  string
+	endpoint string
+	client   *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// NewGCPKMS creates a key manager for the key named
+// projects/<project>/locations/<location>/keyRings/<ring>/cryptoKeys/<key>
+func NewGCPKMS(keyName, endpoint string) (*GCPKMS, error) {
+	if keyName == "" {
+		return nil, fmt.Errorf("KMS key name is required")
+	}
+	if endpoint == "" {
+		endpoint = gcpKMSEndpoint
+	}
+	return &GCPKMS{
+		keyName:  keyName,
+		endpoint: strings.TrimSuffix(endpoint, "/") + "/",
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// KeyID names the master key new data keys are wrapped with
+func (k *GCPKMS) KeyID() string {
+	return k.keyName
+}
+
+// Wrap encrypts a data key with the KMS key
+func (k *GCPKMS) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	var resp struct {
+		Ciphertext []byte `json:"ciphertext"`
+	}
+	if err := k.call(ctx, k.keyName+":encrypt", map[string][]byte{"plaintext": dataKey}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Ciphertext, nil
+}
+
+// Unwrap decrypts a data key wrapped by the named KMS key
+func (k *GCPKMS) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	var resp struct {
+		Plaintext []byte `json:"plaintext"`
+	}
+	if err := k.call(ctx, keyID+":decrypt", map[string][]byte{"ciphertext": wrapped}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Plaintext, nil
+}
+
+// call invokes a KMS method; byte slices travel base64 encoded both ways
+func (k *GCPKMS) call(ctx context.Context, method string, input, output interface{}) error {
+	token, err := k.accessToken(ctx)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal KMS request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, k.endpoint+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+token)
+
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call KMS: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("KMS returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode KMS response: %v", err)
+	}
+	return nil
+}
+
+// accessToken returns a cached access token, fetching a new one from the
+// metadata server shortly before it expires
+func (k *GCPKMS) accessToken(ctx context.Context) (string, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+
+	if k.token != "" && time.Now().Before(k.tokenExpiry) {
+		return k.token, nil
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, gcpTokenURL, nil)
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Metadata-Flavor", "Google")
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to fetch access token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("metadata server returned status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode access token: %v", err)
+	}
+	k.token = token.AccessToken
+	k.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn)*time.Second - time.Minute)
+	return k.token, nil
+}
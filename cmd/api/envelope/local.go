@@ -0,0 +1,66 @@
+package envelope
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+)
+
+// LocalKeys holds master keys in process, for deployments without a key
+// management service. Old keys are kept so values sealed under them can still
+// be opened and rewrapped after rotating to a new current key.
+type LocalKeys struct {
+	keys    map[string][]byte
+	current string
+}
+
+// NewLocalKeys creates a key manager from base64 encoded 32 byte keys by ID,
+// wrapping new data keys with the current one
+func NewLocalKeys(encoded map[string]string, current string) (*LocalKeys, error) {
+	keys := make(map[string][]byte, len(encoded))
+	for id, value := range encoded {
+		key, err := base64.StdEncoding.DecodeString(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid master key %s: %v", id, err)
+		}
+		if len(key) != dataKeySize {
+			return nil, fmt.Errorf("master key %s must be %d bytes", id, dataKeySize)
+		}
+		keys[id] = key
+	}
+	if _, ok := keys[current]; !ok {
+		return nil, fmt.Errorf("current master key %q is not configured", current)
+	}
+	return &LocalKeys{keys: keys, current: current}, nil
+}
+
+// KeyID names the master key new data keys are wrapped with
+func (k *LocalKeys) KeyID() string {
+	return k.current
+}
+
+// Wrap encrypts a data key with the current master key
+func (k *LocalKeys) Wrap(ctx context.Context, dataKey []byte) ([]byte, error) {
+	nonce, ciphertext, err := seal(k.keys[k.current], dataKey, []byte(k.current))
+	if err != nil {
+		return nil, err
+	}
+	return append(nonce, ciphertext...), nil
+}
+
+// Unwrap decrypts a data key wrapped by the named master key
+func (k *LocalKeys) Unwrap(ctx context.Context, keyID string, wrapped []byte) ([]byte, error) {
+	key, ok := k.keys[keyID]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %q", keyID)
+	}
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	if len(wrapped) < gcm.NonceSize() {
+		return nil, fmt.Errorf("wrapped key is too short")
+	}
+	nonce, ciphertext := wrapped[:gcm.NonceSize()], wrapped[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, []byte(keyID))
+}
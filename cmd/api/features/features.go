@@ -0,0 +1,247 @@
+// Package features turns subsystems on and off per deployment. Flags are set
+// in the configuration, in a file reloaded while the server runs and in
+// APOLLO_FEATURE_* environment variables, in increasing precedence, so a risky
+// feature can be rolled out to some users first and switched off again
+// without a redeploy.
+package features
+
+import (
+	"context"
+	"fmt"
+	"hash/fnv"
+	"log"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Feature flags
+const (
+	// AutoApproval lets policies approve requests that need no approvers;
+	// while it's off those requests wait for one approval
+	AutoApproval = "auto_approval"
+	// Webhooks delivers events to the configured webhooks; while it's off
+	// events aren't posted to them
+	Webhooks = "webhooks"
+	// Dashboard lets the dashboard sign in with cookie sessions
+	Dashboard = "dashboard"
+)
+
+// Sources of a flag's setting
+const (
+	SourceDefault = "default"
+	SourceConfig  = "config"
+	SourceFile    = "file"
+	SourceEnv     = "env"
+)
+
+// EnvPrefix starts the environment variables setting flags, e.g.
+// APOLLO_FEATURE_WEBHOOKS=false
+const EnvPrefix = "APOLLO_FEATURE_"
+
+// known describes the flags; all of them are on by default
+var known = map[string]string{
+	AutoApproval: "Policies approve requests that need no approvers",
+	Webhooks:     "Events are posted to the configured webhooks",
+	Dashboard:    "The dashboard signs in with cookie sessions",
+}
+
+// Flag is the current setting of a feature
+type Flag struct {
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	Enabled     bool   `json:"enabled"`
+	// Rollout is the percentage of users the feature is on for
+	Rollout int    `json:"rollout"`
+	Source  string `json:"source"`
+}
+
+// Set holds the current flags. A nil set has every feature on.
+type Set struct {
+	config map[string]int
+	path   string
+	getenv func(string) string
+
+	mu    sync.RWMutex
+	flags map[string]Flag
+}
+
+// New creates a set from the configured flags and the file of flags at path,
+// which may be empty. Flags are true, false or a rollout percentage like 25%.
+func New(config map[string]string, path string) (*Set, error) {
+	parsed, err := parseFlags(config)
+	if err != nil {
+		return nil, err
+	}
+	s := &Set{config: parsed, path: path, getenv: os.Getenv}
+	if err := s.Reload(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// Start reloads the file of flags and the environment at the given interval
+// until the context is cancelled
+func (s *Set) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := s.Reload(); err != nil {
+					log.Printf("Failed to reload feature flags, keeping the current ones: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Reload reads the file of flags and the environment again. On failure the
+// current flags are kept.
+func (s *Set) Reload() error {
+	var file map[string]int
+	if s.path != "" {
+		data, err := os.ReadFile(s.path)
+		if err != nil {
+			return fmt.Errorf("failed to read feature flags: %v", err)
+		}
+		var values map[string]string
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return fmt.Errorf("failed to parse feature flags: %v", err)
+		}
+		if file, err = parseFlags(values); err != nil {
+			return err
+		}
+	}
+
+	flags := make(map[string]Flag, len(known))
+	for name, description := range known {
+		flag := Flag{Name: name, Description: description, Rollout: 100, Source: SourceDefault}
+		if rollout, ok := s.config[name]; ok {
+			flag.Rollout, flag.Source = rollout, SourceConfig
+		}
+		if rollout, ok := file[name]; ok {
+			flag.Rollout, flag.Source = rollout, SourceFile
+		}
+		if value := s.getenv(EnvPrefix + strings.ToUpper(name)); value != "" {
+			rollout, err := parseValue(value)
+			if err != nil {
+				return fmt.Errorf("%s%s: %v", EnvPrefix, strings.ToUpper(name), err)
+			}
+			flag.Rollout, flag.Source = rollout, SourceEnv
+		}
+		flag.Enabled = flag.Rollout > 0
+		flags[name] = flag
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for name, flag := range flags {
+		if previous, ok := s.flags[name]; ok && previous.Rollout != flag.Rollout {
+			log.Printf("Feature %s is now on for %d%% of users (%s)", name, flag.Rollout, flag.Source)
+		}
+	}
+	s.flags = flags
+	return nil
+}
+
+// Enabled reports whether a feature is on for the whole deployment. Features
+// that don't apply to users, like webhooks, are off until rolled out to all.
+func (s *Set) Enabled(name string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.flags[name].Rollout >= 100
+}
+
+// EnabledFor reports whether a feature is on for a user. Users are assigned
+// to the rollout by a hash of their ID, so each keeps their setting as the
+// rollout grows.
+func (s *Set) EnabledFor(name, userID string) bool {
+	if s == nil {
+		return true
+	}
+	s.mu.RLock()
+	rollout := s.flags[name].Rollout
+	s.mu.RUnlock()
+	if rollout >= 100 || rollout <= 0 {
+		return rollout >= 100
+	}
+	hash := fnv.New32a()
+	fmt.Fprintf(hash, "%s:%s", name, userID)
+	return int(hash.Sum32()%100) < rollout
+}
+
+// List returns the current flags by name
+func (s *Set) List() []Flag {
+	if s == nil {
+		s = &Set{}
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	flags := make([]Flag, 0, len(known))
+	for name, description := range known {
+		flag, ok := s.flags[name]
+		if !ok {
+			flag = Flag{Name: name, Description: description, Enabled: true, Rollout: 100, Source: SourceDefault}
+		}
+		flags = append(flags, flag)
+	}
+	sort.Slice(flags, func(i, j int) bool {
+		return flags[i].Name < flags[j].Name
+	})
+	return flags
+}
+
+// parseFlags parses the settings of known flags
+func parseFlags(values map[string]string) (map[string]int, error) {
+	flags := make(map[string]int, len(values))
+	for name, value := range values {
+		if _, ok := known[name]; !ok {
+			return nil, fmt.Errorf("unknown feature %q", name)
+		}
+		rollout, err := parseValue(value)
+		if err != nil {
+			return nil, fmt.Errorf("feature %s: %v", name, err)
+		}
+		flags[name] = rollout
+	}
+	return flags, nil
+}
+
+// parseValue parses true, false, on, off or a percentage of users like 25%
+// into a rollout
+func parseValue(value string) (int, error) {
+	value = strings.TrimSpace(value)
+	if percent, ok := strings.CutSuffix(value, "%"); ok {
+		rollout, err := strconv.Atoi(percent)
+		if err != nil || rollout < 0 || rollout > 100 {
+			return 0, fmt.Errorf("invalid rollout %q: expected a percentage from 0%% to 100%%", value)
+		}
+		return rollout, nil
+	}
+	switch strings.ToLower(value) {
+	case "on":
+		return 100, nil
+	case "off":
+		return 0, nil
+	}
+	enabled, err := strconv.ParseBool(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid setting %q: expected true, false or a percentage", value)
+	}
+	if enabled {
+		return 100, nil
+	}
+	return 0, nil
+}
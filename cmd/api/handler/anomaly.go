@@ -0,0 +1,19 @@
+package handler
+
+import (
+	"context"
+
+	"github.com/petermein/apollo/internal/anomaly"
+	"github.com/petermein/apollo/internal/audit"
+)
+
+// StartAnomalyDetection analyzes the handler's privilege requests in the background
+// and records unusual access patterns until the context is cancelled
+func (h *Handler) StartAnomalyDetection(ctx context.Context, config anomaly.Config, recorder audit.Recorder) error {
+	analyzer, err := anomaly.NewAnalyzer(config, h.store, recorder)
+	if err != nil {
+		return err
+	}
+	analyzer.Start(ctx)
+	return nil
+}
@@ -0,0 +1,111 @@
+package handler
+
+import (
+	"html/template"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/digest"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// approvalPage is served for the links in approval digests. Opening a link
+// only shows the request; mail scanners that follow links must not decide
+// it, so the approver confirms with a POST.
+var approvalPage = template.Must(template.New("approval").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Apollo</title></head>
+<body>
+{{if .Request}}<p>{{.Request.UserID}} requests {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}.</p>
+<p>Reason: {{.Request.Reason}}<br>Risk score: {{.Request.RiskScore}}</p>
+{{end}}{{if .Message}}<p>{{.Message}}</p>
+{{else}}<form method="post"><button type="submit">{{if eq .Action "approve"}}Approve{{else}}Deny{{end}} as {{.Approver}}</button></form>
+{{end}}</body>
+</html>
+`))
+
+// approvalPageData is what the approval page is executed against
+type approvalPageData struct {
+	Request  *models.PrivilegeRequest
+	Action   string
+	Approver string
+	// Message replaces the confirmation form
+	Message string
+}
+
+// SetApprovalLinks configures the links of approval digests
+func (h *Handler) SetApprovalLinks(links *digest.Links) {
+	h.approvalLinks = links
+}
+
+// handleApprovalLink shows the request a signed approval link is for on GET
+// and approves or denies it as the approver the link was mailed to on POST
+func (h *Handler) handleApprovalLink(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.approvalLinks == nil {
+		http.Error(w, "Approval digests are not configured", http.StatusNotFound)
+		return
+	}
+	link, err := h.approvalLinks.Resolve(r.PathValue("token"), time.Now())
+	if err != nil {
+		writeApprovalPage(w, http.StatusForbidden, approvalPageData{Message: "This link is invalid or has expired."})
+		return
+	}
+	data := approvalPageData{Action: link.Action, Approver: link.Approver}
+
+	if r.Method == http.MethodGet {
+		request, err := h.store.GetRequest(r.Context(), link.RequestID)
+		if err != nil {
+			data.Message = "The request could not be loaded."
+			writeApprovalPage(w, ruleErrorStatus(err), data)
+			return
+		}
+		data.Request = request
+		if request.Status != store.RequestStatusPending {
+			data.Message = "This request is already " + request.Status + "."
+		} else if h.lapsed(request, time.Now()) {
+			data.Message = "This request expired without a decision."
+		}
+		writeApprovalPage(w, http.StatusOK, data)
+		return
+	}
+
+	approver := &models.Approver{ID: link.Approver, Kind: models.ApproverKindUser}
+	var request *models.PrivilegeRequest
+	if link.Action == digest.ActionApprove {
+		request, err = h.approveRequest(r.Context(), link.RequestID, approver, models.ApprovalChannelLink, nil)
+	} else {
+		request, err = h.denyRequest(r.Context(), link.RequestID, approver)
+	}
+	if err != nil {
+		status := ruleErrorStatus(err)
+		if status == http.StatusInternalServerError {
+			log.Printf("Failed to %s request %s from approval link: %v", link.Action, link.RequestID, err)
+			data.Message = "The request could not be updated, try again later."
+		} else {
+			data.Message = "The request could not be updated: " + err.Error() + "."
+		}
+		writeApprovalPage(w, status, data)
+		return
+	}
+	data.Request = request
+	data.Message = "Thanks, your decision was recorded. The request is now " + request.Status + "."
+	if request.Status == store.RequestStatusPending {
+		data.Message = "Thanks, your approval was recorded. The request needs more approvals."
+	}
+	writeApprovalPage(w, http.StatusOK, data)
+}
+
+// writeApprovalPage writes the approval page
+func writeApprovalPage(w http.ResponseWriter, status int, data approvalPageData) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+	if err := approvalPage.Execute(w, data); err != nil {
+		log.Printf("Failed to write approval page: %v", err)
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/petermein/apollo/cmd/api/attest"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SetApprovalSigner configures the keys approvals are signed and verified with
+func (h *Handler) SetApprovalSigner(signer *attest.Signer) {
+	h.approvalSigner = signer
+}
+
+// verifiedApproval is an approval record with the outcome of checking it
+type verifiedApproval struct {
+	models.ApprovalRecord
+	Valid bool   `json:"valid"`
+	Error string `json:"error,omitempty"`
+}
+
+// approvalVerification is the outcome of checking a grant's approvals
+type approvalVerification struct {
+	GrantID string `json:"grant_id"`
+	// Verified is set when the grant has approvals and every one of them is
+	// validly signed and approves the grant's access
+	Verified  bool               `json:"verified"`
+	Approvals []verifiedApproval `json:"approvals"`
+}
+
+// handleVerifyGrantApprovals checks the signature of every approval recorded
+// on a grant and that each approves the access the grant gives, for auditors
+func (h *Handler) handleVerifyGrantApprovals(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	grant, err := h.store.GetGrant(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	verification := approvalVerification{
+		GrantID:   grant.ID,
+		Verified:  len(grant.Approvals) > 0,
+		Approvals: make([]verifiedApproval, 0, len(grant.Approvals)),
+	}
+	for i := range grant.Approvals {
+		approval := verifiedApproval{ApprovalRecord: grant.Approvals[i], Valid: true}
+		if err := h.approvalSigner.Verify(&approval.ApprovalRecord); err != nil {
+			approval.Valid, approval.Error = false, err.Error()
+		} else if err := attest.Covers(&approval.ApprovalRecord, grant); err != nil {
+			approval.Valid, approval.Error = false, err.Error()
+		}
+		verification.Verified = verification.Verified && approval.Valid
+		verification.Approvals = append(verification.Approvals, approval)
+	}
+	log.Printf("Approvals of grant %s verified by %s: %t", grant.ID, userID, verification.Verified)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(verification)
+}
+
+// handleApprovalKeys lists the public keys approvals are signed with, so
+// auditors can verify exported grants on their own
+func (h *Handler) handleApprovalKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.approvalSigner.PublicKeys())
+}
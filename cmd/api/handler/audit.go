@@ -0,0 +1,526 @@
+package handler
+
+import (
+	"bufio"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/csv"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Audit export formats
+const (
+	exportFormatCSV   = "csv"
+	exportFormatJSONL = "jsonl"
+)
+
+// Background export statuses
+const (
+	exportStatusRunning   = "running"
+	exportStatusCompleted = "completed"
+	exportStatusFailed    = "failed"
+)
+
+const (
+	// exportBatchSize is the number of audit entries read from the store at a time
+	exportBatchSize = 1000
+	// exportLinkTTL is how long a signed download link stays valid
+	exportLinkTTL = time.Hour
+)
+
+// auditExport is an export generated in the background
+type auditExport struct {
+	ID          string    `json:"id"`
+	Format      string    `json:"format"`
+	From        time.Time `json:"from"`
+	To          time.Time `json:"to"`
+	RequestedBy string    `json:"requested_by"`
+	Status      string    `json:"status"`
+	Records     int       `json:"records"`
+	Error       string    `json:"error,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	DownloadURL string    `json:"download_url,omitempty"`
+
+	path string
+}
+
+// auditExporter keeps track of background exports and signs their download links
+type auditExporter struct {
+	dir            string
+	key            []byte
+	asyncThreshold time.Duration
+
+	mu      sync.Mutex
+	exports map[string]*auditExport
+}
+
+// newAuditExporter creates an exporter writing to dir. Without a signing key a
+// random one is generated, so links don't survive a restart.
+func newAuditExporter(dir string, key []byte, asyncThreshold time.Duration) (*auditExporter, error) {
+	if dir == "" {
+		dir = filepath.Join(os.TempDir(), "apollo-audit-exports")
+	}
+	if len(key) == 0 {
+		key = make([]byte, 32)
+		if _, err := rand.Read(key); err != nil {
+			return nil, fmt.Errorf("failed to generate signing key: %v", err)
+		}
+	}
+	if asyncThreshold == 0 {
+		asyncThreshold = 7 * 24 * time.Hour
+	}
+	return &auditExporter{
+		dir:            dir,
+		key:            key,
+		asyncThreshold: asyncThreshold,
+		exports:        make(map[string]*auditExport),
+	}, nil
+}
+
+// SetAuditExport configures where background audit exports are written, the key
+// signing their download links and the range above which exports run in the background
+func (h *Handler) SetAuditExport(dir string, key []byte, asyncThreshold time.Duration) error {
+	exporter, err := newAuditExporter(dir, key, asyncThreshold)
+	if err != nil {
+		return err
+	}
+	h.exports = exporter
+	return nil
+}
+
+// systemActor is recorded as the actor of what the server does on its own,
+// like expiring grants
+const systemActor = "system"
+
+// operatorActor returns who to record as the actor of an operator's request:
+// the authenticated identity, or operator when there is none
+func operatorActor(r *http.Request) string {
+	if principal, ok := auth.PrincipalFrom(r.Context()); ok {
+		return principal.ID
+	}
+	return "operator"
+}
+
+// SetAuditRecorder sets where the audit entries of privilege requests, grants
+// and operator actions are recorded; the store when unset
+func (h *Handler) SetAuditRecorder(recorder audit.Recorder) {
+	h.auditRecorder = recorder
+}
+
+// recordAudit appends an entry to the audit log. Failing to record an entry
+// is logged, but doesn't fail the action it records.
+func (h *Handler) recordAudit(ctx context.Context, entry audit.Entry) {
+	recorder := h.auditRecorder
+	if recorder == nil {
+		recorder = h.store
+	}
+	if err := recorder.Record(ctx, entry); err != nil {
+		log.Printf("Failed to record %s audit entry: %v", entry.Action, err)
+	}
+}
+
+// auditRequest records an action on a privilege request
+func (h *Handler) auditRequest(ctx context.Context, action, actor string, request *models.PrivilegeRequest, details map[string]string) {
+	h.recordAudit(ctx, audit.Entry{
+		Action:        action,
+		Actor:         actor,
+		UserID:        request.UserID,
+		ResourceID:    request.ResourceID,
+		RequestID:     request.ID,
+		Details:       details,
+		PolicyVersion: request.PolicyVersion,
+	})
+}
+
+// auditGrant records an action on a grant
+func (h *Handler) auditGrant(ctx context.Context, action, actor string, grant *models.PrivilegeGrant) {
+	h.recordAudit(ctx, audit.Entry{
+		Action:     action,
+		Actor:      actor,
+		UserID:     grant.UserID,
+		ResourceID: grant.ResourceID,
+		RequestID:  grant.RequestID,
+		Details: map[string]string{
+			"grant_id":   grant.ID,
+			"module":     grant.Module,
+			"level":      string(grant.Level),
+			"expires_at": grant.ExpiresAt.UTC().Format(time.RFC3339),
+		},
+	})
+}
+
+// auditPage is a page of audit entries; Next continues with the next page
+type auditPage struct {
+	Entries []audit.Entry `json:"entries"`
+	Next    string        `json:"next,omitempty"`
+}
+
+const (
+	// defaultAuditLimit is the number of audit entries listed when no limit is given
+	defaultAuditLimit = 100
+	// maxAuditLimit is the largest number of audit entries listed at once
+	maxAuditLimit = 1000
+)
+
+// handleListAudit lists audit entries, oldest first, filtered by the user they
+// concern, the resource, the action and the time range. Pages continue from
+// the next cursor of the previous one.
+func (h *Handler) handleListAudit(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	query := store.AuditQuery{
+		UserID:     params.Get("user"),
+		ResourceID: params.Get("resource"),
+		Action:     params.Get("action"),
+		Limit:      defaultAuditLimit,
+	}
+	var err error
+	if value := params.Get("from"); value != "" {
+		if query.From, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "Invalid from time, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := params.Get("to"); value != "" {
+		if query.To, err = time.Parse(time.RFC3339, value); err != nil {
+			http.Error(w, "Invalid to time, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxAuditLimit {
+			http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxAuditLimit), http.StatusBadRequest)
+			return
+		}
+		query.Limit = limit
+	}
+	if cursor := params.Get("after"); cursor != "" {
+		after, id, ok := strings.Cut(cursor, ",")
+		if query.AfterTime, err = time.Parse(time.RFC3339Nano, after); err != nil || !ok || id == "" {
+			http.Error(w, "Invalid after cursor", http.StatusBadRequest)
+			return
+		}
+		query.AfterID = id
+	}
+
+	entries, err := h.store.ListAudit(r.Context(), query)
+	if err != nil {
+		log.Printf("Failed to list audit entries: %v", err)
+		http.Error(w, "Failed to list audit entries", http.StatusInternalServerError)
+		return
+	}
+	page := auditPage{Entries: entries}
+	if page.Entries == nil {
+		page.Entries = []audit.Entry{}
+	}
+	if len(entries) == query.Limit {
+		last := entries[len(entries)-1]
+		page.Next = last.Time.UTC().Format(time.RFC3339Nano) + "," + last.ID
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+// sign returns the signature of a download link for the export
+func (e *auditExporter) sign(id string, expires int64) string {
+	mac := hmac.New(sha256.New, e.key)
+	fmt.Fprintf(mac, "%s:%d", id, expires)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// downloadURL returns a signed download link for the export
+func (e *auditExporter) downloadURL(id string) string {
+	expires := time.Now().Add(exportLinkTTL).Unix()
+	return fmt.Sprintf("/api/v1/audit/exports/%s/download?expires=%d&signature=%s", id, expires, e.sign(id, expires))
+}
+
+// verify checks a download link's signature and expiry
+func (e *auditExporter) verify(id, expires, signature string) bool {
+	unix, err := strconv.ParseInt(expires, 10, 64)
+	if err != nil || time.Now().Unix() > unix {
+		return false
+	}
+	return hmac.Equal([]byte(signature), []byte(e.sign(id, unix)))
+}
+
+// handleAuditExport streams audit entries in the requested range as CSV or JSON
+// Lines. Ranges longer than the async threshold, or requests with async=true,
+// are generated in the background instead.
+func (h *Handler) handleAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	query := r.URL.Query()
+	format := query.Get("format")
+	if format == "" {
+		format = exportFormatJSONL
+	}
+	if format != exportFormatCSV && format != exportFormatJSONL {
+		http.Error(w, "Format must be csv or jsonl", http.StatusBadRequest)
+		return
+	}
+	from, err := time.Parse(time.RFC3339, query.Get("from"))
+	if err != nil {
+		http.Error(w, "Invalid from time, expected RFC 3339", http.StatusBadRequest)
+		return
+	}
+	to := time.Now().UTC()
+	if query.Get("to") != "" {
+		if to, err = time.Parse(time.RFC3339, query.Get("to")); err != nil {
+			http.Error(w, "Invalid to time, expected RFC 3339", http.StatusBadRequest)
+			return
+		}
+	}
+	if !to.After(from) {
+		http.Error(w, "The to time must be after the from time", http.StatusBadRequest)
+		return
+	}
+
+	if query.Get("async") == "true" || to.Sub(from) > h.exports.asyncThreshold {
+		export := h.startAuditExport(userID, format, from, to)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		json.NewEncoder(w).Encode(export)
+		return
+	}
+
+	log.Printf("Audit export from %s to %s requested by %s", from.Format(time.RFC3339), to.Format(time.RFC3339), userID)
+	contentType := "application/x-ndjson"
+	if format == exportFormatCSV {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s-%s.%s", from.Format("20060102"), to.Format("20060102"), format))
+
+	flusher, _ := w.(http.Flusher)
+	if _, err := h.writeAudit(r.Context(), w, format, from, to, flusher); err != nil {
+		// The status has been sent already; the truncated body is all we can do
+		log.Printf("Audit export failed: %v", err)
+	}
+}
+
+// startAuditExport generates an export in the background
+func (h *Handler) startAuditExport(userID, format string, from, to time.Time) auditExport {
+	export := &auditExport{
+		ID:          fmt.Sprintf("export_%d", time.Now().UnixNano()),
+		Format:      format,
+		From:        from,
+		To:          to,
+		RequestedBy: userID,
+		Status:      exportStatusRunning,
+		CreatedAt:   time.Now().UTC(),
+	}
+	export.path = filepath.Join(h.exports.dir, export.ID+"."+format)
+
+	h.exports.mu.Lock()
+	h.exports.exports[export.ID] = export
+	snapshot := *export
+	h.exports.mu.Unlock()
+
+	log.Printf("Background audit export %s from %s to %s requested by %s", export.ID, from.Format(time.RFC3339), to.Format(time.RFC3339), userID)
+	go func() {
+		records, err := h.writeAuditFile(context.Background(), export.path, format, from, to)
+
+		h.exports.mu.Lock()
+		defer h.exports.mu.Unlock()
+		export.Records = records
+		if err != nil {
+			log.Printf("Background audit export %s failed: %v", export.ID, err)
+			export.Status = exportStatusFailed
+			export.Error = err.Error()
+			return
+		}
+		export.Status = exportStatusCompleted
+	}()
+	return snapshot
+}
+
+// writeAuditFile writes an export to a file
+func (h *Handler) writeAuditFile(ctx context.Context, path, format string, from, to time.Time) (int, error) {
+	if err := os.MkdirAll(filepath.Dir(path), 0o750); err != nil {
+		return 0, fmt.Errorf("failed to create export directory: %v", err)
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o640)
+	if err != nil {
+		return 0, fmt.Errorf("failed to create export file: %v", err)
+	}
+	buffered := bufio.NewWriter(file)
+	records, err := h.writeAudit(ctx, buffered, format, from, to, nil)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	return records, err
+}
+
+// writeAudit writes the audit entries in the range a batch at a time
+func (h *Handler) writeAudit(ctx context.Context, w io.Writer, format string, from, to time.Time, flusher http.Flusher) (int, error) {
+	var csvWriter *csv.Writer
+	if format == exportFormatCSV {
+		csvWriter = csv.NewWriter(w)
+		if err := csvWriter.Write([]string{"id", "time", "action", "actor", "resource_id", "request_id", "rule", "message", "policy_version", "details"}); err != nil {
+			return 0, err
+		}
+	}
+	encoder := json.NewEncoder(w)
+
+	records := 0
+	query := store.AuditQuery{From: from, To: to, Limit: exportBatchSize}
+	for {
+		entries, err := h.store.ListAudit(ctx, query)
+		if err != nil {
+			return records, err
+		}
+		for _, entry := range entries {
+			if csvWriter != nil {
+				err = csvWriter.Write(auditCSVRecord(entry))
+			} else {
+				err = encoder.Encode(entry)
+			}
+			if err != nil {
+				return records, err
+			}
+			records++
+		}
+		if csvWriter != nil {
+			csvWriter.Flush()
+			if err := csvWriter.Error(); err != nil {
+				return records, err
+			}
+		}
+		if flusher != nil {
+			flusher.Flush()
+		}
+
+		if len(entries) < exportBatchSize {
+			return records, nil
+		}
+		last := entries[len(entries)-1]
+		query.AfterTime, query.AfterID = last.Time, last.ID
+	}
+}
+
+// auditCSVRecord converts an audit entry to a CSV row
+func auditCSVRecord(entry audit.Entry) []string {
+	details := ""
+	if len(entry.Details) > 0 {
+		data, _ := json.Marshal(entry.Details)
+		details = string(data)
+	}
+	policyVersion := ""
+	if entry.PolicyVersion != 0 {
+		policyVersion = strconv.Itoa(entry.PolicyVersion)
+	}
+	return []string{
+		entry.ID,
+		entry.Time.UTC().Format(time.RFC3339Nano),
+		entry.Action,
+		entry.Actor,
+		entry.ResourceID,
+		entry.RequestID,
+		entry.Rule,
+		entry.Message,
+		policyVersion,
+		details,
+	}
+}
+
+// handleGetAuditExport returns the status of a background export, with a signed
+// download link once it has completed
+func (h *Handler) handleGetAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	h.exports.mu.Lock()
+	export, ok := h.exports.exports[r.PathValue("id")]
+	var snapshot auditExport
+	if ok {
+		snapshot = *export
+	}
+	h.exports.mu.Unlock()
+	if !ok {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	if snapshot.Status == exportStatusCompleted {
+		snapshot.DownloadURL = h.exports.downloadURL(snapshot.ID)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(snapshot)
+}
+
+// handleDownloadAuditExport serves a completed background export. The signed
+// link is the credential, so it can be handed to auditors as is.
+func (h *Handler) handleDownloadAuditExport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	id := r.PathValue("id")
+	if !h.exports.verify(id, r.URL.Query().Get("expires"), r.URL.Query().Get("signature")) {
+		http.Error(w, "Invalid or expired download link", http.StatusForbidden)
+		return
+	}
+
+	h.exports.mu.Lock()
+	export, ok := h.exports.exports[id]
+	var snapshot auditExport
+	if ok {
+		snapshot = *export
+	}
+	h.exports.mu.Unlock()
+	if !ok || snapshot.Status != exportStatusCompleted {
+		http.Error(w, "Export not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := "application/x-ndjson"
+	if snapshot.Format == exportFormatCSV {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=audit-%s-%s.%s", snapshot.From.Format("20060102"), snapshot.To.Format("20060102"), snapshot.Format))
+	http.ServeFile(w, r, snapshot.path)
+}
@@ -0,0 +1,32 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+)
+
+// moduleCatalog serves resource tags from the servers registered with the modules
+type moduleCatalog struct {
+	modules []modules.Module
+}
+
+// Tags returns the tags of the named server registered with the module
+func (c moduleCatalog) Tags(ctx context.Context, module, resourceID string) ([]string, error) {
+	for _, m := range c.modules {
+		if m.Name() != module {
+			continue
+		}
+		servers, err := m.ListServers(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list %s servers: %v", module, err)
+		}
+		for _, server := range servers {
+			if server.Name == resourceID {
+				return server.Tags, nil
+			}
+		}
+	}
+	return nil, nil
+}
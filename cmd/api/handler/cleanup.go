@@ -0,0 +1,193 @@
+package handler
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// expiryBatchSize is the number of expired grants finalized at a time
+const expiryBatchSize = 500
+
+// CleanupPolicy configures the cleanup worker
+type CleanupPolicy struct {
+	// OperatorTimeout is how long an operator may go without a health check
+	// before it is marked inactive
+	OperatorTimeout time.Duration
+	// ExpiryWarning is how long before a grant expires an expiring event is
+	// recorded, e.g. to remind its holder; no warnings are recorded when zero
+	ExpiryWarning time.Duration
+}
+
+// operatorTracker is implemented by modules that keep track of their operators
+type operatorTracker interface {
+	GetInactiveOperators(ctx context.Context, timeout time.Duration) ([]string, error)
+	MarkOperatorInactive(ctx context.Context, id string) error
+}
+
+// SetPendingTTL sets how long requests wait for a decision before they
+// expire. New requests are given the deadline when they are created; pending
+// requests that have none expire this long after they were requested.
+func (h *Handler) SetPendingTTL(ttl time.Duration) {
+	h.pendingTTL = ttl
+}
+
+// pendingDeadline returns when a pending request created now expires, nil
+// when pending requests don't expire
+func (h *Handler) pendingDeadline(now time.Time) *time.Time {
+	if h.pendingTTL <= 0 {
+		return nil
+	}
+	deadline := now.Add(h.pendingTTL)
+	return &deadline
+}
+
+// lapsed reports whether a request waited for a decision past its deadline,
+// or past the TTL when it was created without one
+func (h *Handler) lapsed(request *models.PrivilegeRequest, now time.Time) bool {
+	if request.PendingUntil == nil && h.pendingTTL > 0 && request.Status == store.RequestStatusPending {
+		return !now.Before(request.RequestedAt.Add(h.pendingTTL))
+	}
+	return request.Lapsed(now)
+}
+
+// StartCleanup finalizes expired grants, warns of grants about to expire,
+// expires requests left pending past their deadline and marks silent
+// operators inactive, checking at the given interval until the context is
+// cancelled
+func (h *Handler) StartCleanup(ctx context.Context, interval time.Duration, policy CleanupPolicy) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				now := time.Now().UTC()
+				h.expireGrants(ctx, now)
+				h.pruneOperatorTokens(ctx, now)
+				h.pruneSessions(ctx, now)
+				if policy.ExpiryWarning > 0 {
+					h.warnExpiringGrants(ctx, now, policy.ExpiryWarning)
+				}
+				h.expireStaleRequests(ctx, now)
+				if policy.OperatorTimeout > 0 {
+					h.markInactiveOperators(ctx, policy.OperatorTimeout)
+				}
+			}
+		}
+	}()
+}
+
+// expireGrants records the grants whose time is up as expired. The store
+// creates the job revoking each of them with the change, so none is left on
+// its target when the server stops before publishing the jobs.
+func (h *Handler) expireGrants(ctx context.Context, now time.Time) {
+	for {
+		grants, err := h.store.ExpireGrants(ctx, now, expiryBatchSize)
+		if err != nil {
+			log.Printf("Failed to expire grants: %v", err)
+			return
+		}
+		for _, grant := range grants {
+			log.Printf("Grant %s of %s to %s expired", grant.ID, grant.ResourceID, grant.UserID)
+			h.auditGrant(ctx, audit.ActionGrantExpired, systemActor, grant)
+			h.publishRevokeJob(ctx, grant.ID)
+			h.discardGrantSecret(ctx, grant.ID)
+		}
+		if len(grants) < expiryBatchSize {
+			return
+		}
+	}
+}
+
+// warnExpiringGrants records an expiring event for the grants expiring within
+// the warning period, once per expiry time
+func (h *Handler) warnExpiringGrants(ctx context.Context, now time.Time, warning time.Duration) {
+	for {
+		grants, err := h.store.WarnExpiringGrants(ctx, now, now.Add(warning), expiryBatchSize)
+		if err != nil {
+			log.Printf("Failed to warn of expiring grants: %v", err)
+			return
+		}
+		for _, grant := range grants {
+			log.Printf("Grant %s of %s to %s expires at %s", grant.ID, grant.ResourceID, grant.UserID, grant.ExpiresAt.Format(time.RFC3339))
+		}
+		if len(grants) < expiryBatchSize {
+			return
+		}
+	}
+}
+
+// publishRevokeJob publishes the job the store created to revoke an ended grant;
+// without a bus, it wakes the job streams that send it
+func (h *Handler) publishRevokeJob(ctx context.Context, grantID string) {
+	if h.bus == nil {
+		h.jobsDispatched.notify()
+		return
+	}
+	job, err := h.store.GetJob(ctx, store.RevokeJobID(grantID))
+	if err != nil {
+		log.Printf("Failed to look up the revoke job of grant %s: %v", grantID, err)
+		return
+	}
+	if err := h.publishJob(ctx, job); err != nil {
+		log.Printf("Failed to publish the revoke job of grant %s: %v", grantID, err)
+	}
+}
+
+// expireStaleRequests expires the requests still pending past their deadline
+// and records a lapsed event for each, which notifies its requester
+func (h *Handler) expireStaleRequests(ctx context.Context, now time.Time) {
+	requests, err := h.store.ListRequests(ctx, store.RequestFilter{Status: store.RequestStatusPending})
+	if err != nil {
+		log.Printf("Failed to list pending requests: %v", err)
+		return
+	}
+	for _, request := range requests {
+		if !h.lapsed(request, now) {
+			continue
+		}
+		expired, err := h.store.UpdateRequest(ctx, request.ID, func(request *models.PrivilegeRequest, approvals []string) ([]string, error) {
+			// The request may have been decided since it was listed
+			if h.lapsed(request, now) {
+				request.Status = store.RequestStatusExpired
+			}
+			return approvals, nil
+		})
+		if err != nil {
+			log.Printf("Failed to expire request %s: %v", request.ID, err)
+			continue
+		}
+		if expired.Status == store.RequestStatusExpired {
+			log.Printf("Request %s of %s for %s expired without a decision", request.ID, request.UserID, request.ResourceID)
+		}
+	}
+}
+
+// markInactiveOperators marks the operators that stopped sending health checks as inactive
+func (h *Handler) markInactiveOperators(ctx context.Context, timeout time.Duration) {
+	for _, module := range h.modules {
+		tracker, ok := module.(operatorTracker)
+		if !ok {
+			continue
+		}
+		ids, err := tracker.GetInactiveOperators(ctx, timeout)
+		if err != nil {
+			log.Printf("Failed to look up inactive %s operators: %v", module.Name(), err)
+			continue
+		}
+		for _, id := range ids {
+			if err := tracker.MarkOperatorInactive(ctx, id); err != nil {
+				log.Printf("Failed to mark operator %s inactive: %v", id, err)
+				continue
+			}
+			log.Printf("Operator %s marked inactive after %s without a health check", id, timeout)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// SetTrustedProxies configures the proxies whose X-Forwarded-For headers are trusted
+// when determining the client address
+func (h *Handler) SetTrustedProxies(cidrs []string) error {
+	prefixes := make([]netip.Prefix, 0, len(cidrs))
+	for _, cidr := range cidrs {
+		prefix, err := netip.ParsePrefix(cidr)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q: %v", cidr, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	h.trustedProxies = prefixes
+	return nil
+}
+
+// clientIP returns the address of the client that sent the request. X-Forwarded-For
+// is only honoured when the request arrives through a trusted proxy; the header is
+// then walked from the right, skipping trusted proxies, so that clients cannot
+// spoof their address by sending the header themselves.
+func (h *Handler) clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	remote, err := netip.ParseAddr(host)
+	if err != nil {
+		return host
+	}
+	remote = remote.Unmap()
+	if !h.isTrustedProxy(remote) {
+		return remote.String()
+	}
+
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		hop = hop.Unmap()
+		if !h.isTrustedProxy(hop) {
+			return hop.String()
+		}
+		remote = hop
+	}
+	return remote.String()
+}
+
+// isTrustedProxy reports whether the address belongs to a trusted proxy
+func (h *Handler) isTrustedProxy(addr netip.Addr) bool {
+	for _, prefix := range h.trustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
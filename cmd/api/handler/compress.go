@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// compressMinSize is the smallest body worth compressing; smaller ones are
+// sent as they are
+const compressMinSize = 1024
+
+// Content encodings responses are compressed with, in order of preference
+const (
+	encodingGzip    = "gzip"
+	encodingDeflate = "deflate"
+)
+
+var (
+	gzipWriters = sync.Pool{New: func() interface{} { return gzip.NewWriter(io.Discard) }}
+	// Deflate in HTTP is the zlib format
+	zlibWriters = sync.Pool{New: func() interface{} { return zlib.NewWriter(io.Discard) }}
+)
+
+// Compress compresses responses with gzip or deflate for clients that accept
+// either. Bodies smaller than a kilobyte, and content that is compressed
+// already or streamed as events, are sent as they are.
+func Compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Add("Vary", "Accept-Encoding")
+		encoding := acceptedEncoding(r.Header.Get("Accept-Encoding"))
+		if encoding == "" || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, encoding: encoding}
+		defer cw.Close()
+		next.ServeHTTP(cw, r)
+	})
+}
+
+// acceptedEncoding picks the encoding to compress with from an
+// Accept-Encoding header, empty when the client accepts none
+func acceptedEncoding(header string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		name = strings.ToLower(strings.TrimSpace(name))
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			if parsed, err := strconv.ParseFloat(value, 64); err == nil {
+				q = parsed
+			}
+		}
+		accepted[name] = q > 0
+	}
+	for _, encoding := range []string{encodingGzip, encodingDeflate} {
+		if accepted[encoding] {
+			return encoding
+		}
+	}
+	return ""
+}
+
+// compressible reports whether content of a type is worth compressing
+func compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mediaType == "text/event-stream":
+		return false
+	case strings.HasPrefix(mediaType, "text/"):
+		return true
+	case mediaType == "image/svg+xml":
+		return true
+	case strings.HasPrefix(mediaType, "application/"):
+		sub := strings.TrimPrefix(mediaType, "application/")
+		return sub == "json" || sub == "xml" || sub == "javascript" || sub == "x-ndjson" ||
+			strings.HasSuffix(sub, "+json") || strings.HasSuffix(sub, "+xml")
+	}
+	return false
+}
+
+// compressWriter holds back the start of a response until it knows whether
+// the body is large enough to compress, then compresses the rest as it is
+// written
+type compressWriter struct {
+	http.ResponseWriter
+	encoding string
+	status   int
+	buf      []byte
+	decided  bool
+	encoder  io.WriteCloser
+}
+
+// WriteHeader records the status until the body is known
+func (w *compressWriter) WriteHeader(status int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	// Informational responses go out right away
+	if status < http.StatusOK {
+		w.ResponseWriter.WriteHeader(status)
+		return
+	}
+	w.status = status
+}
+
+// Write compresses the body once enough of it is known
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= compressMinSize {
+			if err := w.decide(true); err != nil {
+				return 0, err
+			}
+		}
+		return len(b), nil
+	}
+	if w.encoder != nil {
+		return w.encoder.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush sends what was written so far, compressing streamed responses such
+// as audit exports as they go
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide(len(w.buf) > 0)
+	}
+	if f, ok := w.encoder.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying writer for http.ResponseController
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// Close sends a body too small to compress, or finishes the compressed one
+func (w *compressWriter) Close() error {
+	if !w.decided {
+		return w.decide(false)
+	}
+	if w.encoder == nil {
+		return nil
+	}
+	err := w.encoder.Close()
+	switch encoder := w.encoder.(type) {
+	case *gzip.Writer:
+		encoder.Reset(io.Discard)
+		gzipWriters.Put(encoder)
+	case *zlib.Writer:
+		encoder.Reset(io.Discard)
+		zlibWriters.Put(encoder)
+	}
+	w.encoder = nil
+	return err
+}
+
+// decide sends the header, compressing the body if asked to and the response
+// allows it, then writes what was held back
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+	header := w.Header()
+	if header.Get("Content-Type") == "" && len(w.buf) > 0 {
+		header.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+	status := w.status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	if compress && header.Get("Content-Encoding") == "" && status != http.StatusNoContent &&
+		status != http.StatusNotModified && compressible(header.Get("Content-Type")) {
+		header.Del("Content-Length")
+		header.Set("Content-Encoding", w.encoding)
+		if w.encoding == encodingGzip {
+			encoder := gzipWriters.Get().(*gzip.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		} else {
+			encoder := zlibWriters.Get().(*zlib.Writer)
+			encoder.Reset(w.ResponseWriter)
+			w.encoder = encoder
+		}
+	}
+	w.ResponseWriter.WriteHeader(status)
+
+	buf := w.buf
+	w.buf = nil
+	if len(buf) == 0 {
+		return nil
+	}
+	var err error
+	if w.encoder != nil {
+		_, err = w.encoder.Write(buf)
+	} else {
+		_, err = w.ResponseWriter.Write(buf)
+	}
+	return err
+}
@@ -0,0 +1,244 @@
+package handler
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/features"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/auth"
+)
+
+// Cookies of a browser session
+const (
+	// sessionCookie holds the session's secret, out of reach of scripts
+	sessionCookie = "apollo_session"
+	// csrfCookie holds the CSRF token the dashboard echoes in csrfHeader
+	csrfCookie = "apollo_csrf"
+)
+
+// csrfHeader carries the CSRF token on requests that change state
+const csrfHeader = "X-CSRF-Token"
+
+// DefaultCookieSessionTTL is how long a browser session lasts when no
+// lifetime is configured
+const DefaultCookieSessionTTL = 8 * time.Hour
+
+// CookieSessionPolicy configures the sessions browsers authenticate with
+type CookieSessionPolicy struct {
+	// TTL is how long a session lasts, DefaultCookieSessionTTL when zero
+	TTL time.Duration
+	// Insecure lets the cookies be sent over plain HTTP, for local development
+	Insecure bool
+	// Origins are the dashboard origins allowed to make requests that change
+	// state; the Origin header isn't checked when empty
+	Origins []string
+}
+
+// SetCookieSessions lets the dashboard trade a bearer token for a cookie
+// session. Requests authenticated by cookie must echo the session's CSRF
+// token to change anything, and come from one of the origins.
+func (h *Handler) SetCookieSessions(policy CookieSessionPolicy) {
+	if policy.TTL <= 0 {
+		policy.TTL = DefaultCookieSessionTTL
+	}
+	h.cookieSessions = &policy
+}
+
+// csrfToken derives a session's CSRF token from its secret. Only a page that
+// can read the CSRF cookie, which other origins can't, knows it; the
+// session cookie is sent along by the browser regardless.
+func csrfToken(secret string) string {
+	hash := sha256.Sum256([]byte("apollo-csrf\n" + secret))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// safeMethod reports whether a request with the method changes nothing
+func safeMethod(method string) bool {
+	return method == http.MethodGet || method == http.MethodHead || method == http.MethodOptions
+}
+
+// AuthenticateCookies authenticates browser requests by their session
+// cookie. Requests with an Authorization header take the bearer token path
+// instead, with cookies ignored, so CLI and operator requests are never
+// subject to CSRF checks and browsers never to token handling. It goes in
+// front of the bearer token middleware, which keeps the principal it sets.
+func (h *Handler) AuthenticateCookies(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cookie, err := r.Cookie(sessionCookie)
+		if h.cookieSessions == nil || r.Header.Get("Authorization") != "" || err != nil || cookie.Value == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !safeMethod(r.Method) {
+			if err := h.checkCSRF(r, cookie.Value); err != nil {
+				log.Printf("Rejected %s %s for CSRF: %v", r.Method, r.URL.Path, err)
+				http.Error(w, "Invalid CSRF token", http.StatusForbidden)
+				return
+			}
+		}
+
+		session, err := h.store.GetSession(r.Context(), auth.SessionID(cookie.Value))
+		if err == nil && (session.Kind != store.SessionCookie || !session.Active(time.Now())) {
+			err = fmt.Errorf("session %s is revoked or expired", session.ID)
+		}
+		if err != nil {
+			log.Printf("Rejected session cookie for %s %s: %v", r.Method, r.URL.Path, err)
+			h.clearSessionCookies(w)
+			http.Error(w, "Session expired", http.StatusUnauthorized)
+			return
+		}
+		if !h.features.EnabledFor(features.Dashboard, session.UserID) {
+			h.clearSessionCookies(w)
+			http.Error(w, "The dashboard is disabled", http.StatusForbidden)
+			return
+		}
+
+		principal := &auth.Principal{
+			ID:        session.UserID,
+			Subject:   session.Subject,
+			Groups:    session.Groups,
+			Issuer:    session.Issuer,
+			ExpiresAt: session.ExpiresAt,
+			IssuedAt:  session.CreatedAt,
+			SessionID: session.ID,
+		}
+		r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+		r.Header.Set(auth.UserHeader, principal.ID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// checkCSRF checks that a request changing state echoes the session's CSRF
+// token and, when origins are configured, comes from one of them
+func (h *Handler) checkCSRF(r *http.Request, secret string) error {
+	if origin := r.Header.Get("Origin"); origin != "" && len(h.cookieSessions.Origins) > 0 && !slices.Contains(h.cookieSessions.Origins, origin) {
+		return fmt.Errorf("origin %s is not allowed", origin)
+	}
+	token := r.Header.Get(csrfHeader)
+	if token == "" {
+		return fmt.Errorf("no %s header", csrfHeader)
+	}
+	if subtle.ConstantTimeCompare([]byte(token), []byte(csrfToken(secret))) != 1 {
+		return fmt.Errorf("%s header doesn't match the session", csrfHeader)
+	}
+	return nil
+}
+
+// setSessionCookies sets the cookies of a new browser session
+func (h *Handler) setSessionCookies(w http.ResponseWriter, secret string, expiresAt time.Time) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     sessionCookie,
+		Value:    secret,
+		Path:     "/",
+		Expires:  expiresAt,
+		HttpOnly: true,
+		Secure:   !h.cookieSessions.Insecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     csrfCookie,
+		Value:    csrfToken(secret),
+		Path:     "/",
+		Expires:  expiresAt,
+		Secure:   !h.cookieSessions.Insecure,
+		SameSite: http.SameSiteStrictMode,
+	})
+}
+
+// clearSessionCookies tells the browser to drop the session's cookies
+func (h *Handler) clearSessionCookies(w http.ResponseWriter) {
+	for _, name := range []string{sessionCookie, csrfCookie} {
+		http.SetCookie(w, &http.Cookie{
+			Name:     name,
+			Path:     "/",
+			MaxAge:   -1,
+			HttpOnly: name == sessionCookie,
+			Secure:   !h.cookieSessions.Insecure,
+			SameSite: http.SameSiteStrictMode,
+		})
+	}
+}
+
+// handleCookieSession starts a browser session on POST, for a request
+// authenticated with a bearer token, and ends the calling one on DELETE
+func (h *Handler) handleCookieSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.cookieSessions == nil {
+		http.Error(w, "Cookie sessions are not enabled", http.StatusNotFound)
+		return
+	}
+	principal, ok := auth.PrincipalFrom(r.Context())
+	if !ok || principal.SessionID == "" {
+		http.Error(w, "Authentication required", http.StatusUnauthorized)
+		return
+	}
+	if r.Method == http.MethodPost && !h.features.EnabledFor(features.Dashboard, principal.ID) {
+		http.Error(w, "The dashboard is disabled", http.StatusForbidden)
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		err := h.store.RevokeSession(r.Context(), principal.SessionID, principal.ID, time.Now())
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		h.clearSessionCookies(w)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+
+	// A session is only started with a token, not renewed with a cookie
+	if r.Header.Get("Authorization") == "" {
+		http.Error(w, "Start a session with a bearer token", http.StatusBadRequest)
+		return
+	}
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		http.Error(w, "Failed to generate session", http.StatusInternalServerError)
+		return
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+	now := time.Now().UTC()
+	session := &store.Session{
+		ID:         auth.SessionID(secret),
+		Kind:       store.SessionCookie,
+		UserID:     principal.ID,
+		Issuer:     principal.Issuer,
+		Subject:    principal.Subject,
+		Groups:     principal.Groups,
+		UserAgent:  truncate(r.UserAgent(), 512),
+		SourceIP:   h.clientIP(r),
+		CreatedAt:  now,
+		LastSeenAt: now,
+		ExpiresAt:  now.Add(h.cookieSessions.TTL),
+	}
+	if err := h.store.TouchSession(r.Context(), session); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Started browser session %s for %s", session.ID, session.UserID)
+
+	h.setSessionCookies(w, secret, session.ExpiresAt)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"id":         session.ID,
+		"csrf_token": csrfToken(secret),
+		"expires_at": session.ExpiresAt,
+	})
+}
@@ -0,0 +1,433 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/netip"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/envelope"
+	"github.com/petermein/apollo/cmd/api/secrets"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+const (
+	// rewrapBatchSize is the number of credentials rewrapped at a time when rotating keys
+	rewrapBatchSize = 100
+	// DefaultReferenceTTL is how long a reference to the credentials of a grant can be redeemed
+	DefaultReferenceTTL = 5 * time.Minute
+)
+
+// SetEncrypter seals the credentials of grants with the encrypter
+func (h *Handler) SetEncrypter(encrypter *envelope.Encrypter) {
+	h.encrypter = encrypter
+}
+
+// SetSecretStore keeps the sealed credentials of grants in an external secret
+// store instead of the database
+func (h *Handler) SetSecretStore(s secrets.Store) {
+	h.secrets = s
+}
+
+// SetReferences issues the references grant holders redeem for their credentials
+func (h *Handler) SetReferences(references *secrets.References) {
+	h.references = references
+}
+
+// newEphemeralReferences returns references signed with a random key, so
+// references issued before a restart or by another server are rejected
+func newEphemeralReferences() (*secrets.References, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate reference key: %v", err)
+	}
+	return secrets.NewReferences(key, DefaultReferenceTTL)
+}
+
+// newEphemeralEncrypter returns an encrypter with a random master key, so
+// credentials stored before a restart can't be opened after it
+func newEphemeralEncrypter() (*envelope.Encrypter, error) {
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate master key: %v", err)
+	}
+	keys, err := envelope.NewLocalKeys(map[string]string{"ephemeral": base64.StdEncoding.EncodeToString(key)}, "ephemeral")
+	if err != nil {
+		return nil, err
+	}
+	return envelope.New(keys), nil
+}
+
+// handleGrantCredentials stores the credentials of a grant or hands its holder
+// a reference to them
+func (h *Handler) handleGrantCredentials(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodPut:
+		h.handlePutCredentials(w, r)
+	case http.MethodGet:
+		h.handleCredentialReference(w, r)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// putCredentialsRequest carries the credentials an operator created for a grant
+type putCredentialsRequest struct {
+	OperatorID  string            `json:"operator_id"`
+	Credentials map[string]string `json:"credentials"`
+}
+
+// handlePutCredentials stores the credentials an operator created for a grant.
+// They are sealed bound to the grant and replace any stored earlier.
+func (h *Handler) handlePutCredentials(w http.ResponseWriter, r *http.Request) {
+	var req putCredentialsRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.Credentials) == 0 {
+		http.Error(w, "Credentials are required", http.StatusBadRequest)
+		return
+	}
+	if !checkSignedOperator(w, r, req.OperatorID) {
+		return
+	}
+	active, err := h.isActiveOperator(r.Context(), req.OperatorID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if !active {
+		http.Error(w, "Only active operators can store credentials", http.StatusForbidden)
+		return
+	}
+
+	grantID := r.PathValue("id")
+	plaintext, err := json.Marshal(req.Credentials)
+	if err != nil {
+		http.Error(w, "Failed to marshal credentials", http.StatusInternalServerError)
+		return
+	}
+	sealed, err := h.encrypter.Seal(r.Context(), plaintext, []byte(grantID))
+	clear(plaintext)
+	if err != nil {
+		log.Printf("Failed to seal credentials of grant %s: %v", grantID, err)
+		http.Error(w, "Failed to encrypt credentials", http.StatusInternalServerError)
+		return
+	}
+	data, err := json.Marshal(sealed)
+	if err != nil {
+		http.Error(w, "Failed to marshal sealed credentials", http.StatusInternalServerError)
+		return
+	}
+
+	credential := &store.Credential{GrantID: grantID, KeyID: sealed.KeyID, Sealed: data}
+	if h.secrets != nil {
+		name := secrets.GrantSecretName(grantID)
+		if err := h.secrets.Put(r.Context(), name, data); err != nil {
+			log.Printf("Failed to store credentials of grant %s in the secret store: %v", grantID, err)
+			http.Error(w, "Failed to store credentials", http.StatusInternalServerError)
+			return
+		}
+		credential.Sealed, credential.Ref = nil, name
+	}
+	if err := h.store.PutCredential(r.Context(), credential, req.OperatorID); err != nil {
+		if credential.Ref != "" {
+			h.discardGrantSecret(r.Context(), grantID)
+		}
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Grant not found", http.StatusNotFound)
+			return
+		}
+		writeRuleError(w, err)
+		return
+	}
+	log.Printf("Operator %s stored credentials of grant %s", req.OperatorID, grantID)
+	h.recordAudit(r.Context(), audit.Entry{
+		Action:  audit.ActionCredentialsDeposited,
+		Actor:   req.OperatorID,
+		Details: map[string]string{"grant_id": grantID},
+	})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleCredentialReference hands the holder of a grant a short-lived
+// reference to its credentials, to be redeemed at /api/v1/credentials/{reference}
+func (h *Handler) handleCredentialReference(w http.ResponseWriter, r *http.Request) {
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	grant, ok := h.getGrant(w, r)
+	if !ok {
+		return
+	}
+	if grant.UserID != userID {
+		http.Error(w, "Only the grant holder can retrieve its credentials", http.StatusForbidden)
+		return
+	}
+	if grant.RevokedAt != nil || !grant.ExpiresAt.After(time.Now()) {
+		writeRuleError(w, store.ErrGrantEnded)
+		return
+	}
+	if !h.checkBoundSource(w, r, grant) {
+		return
+	}
+
+	reference, expiresAt := h.references.Issue(grant.ID, userID, time.Now())
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"grant_id":   grant.ID,
+		"reference":  reference,
+		"expires_at": expiresAt,
+	})
+}
+
+// checkBoundSource rejects requests for the credentials of a grant bound to
+// another source network than the request comes from
+func (h *Handler) checkBoundSource(w http.ResponseWriter, r *http.Request, grant *models.PrivilegeGrant) bool {
+	if grant.BoundTo == "" {
+		return true
+	}
+	prefix, err := netip.ParsePrefix(grant.BoundTo)
+	if err != nil {
+		log.Printf("Grant %s is bound to invalid network %q: %v", grant.ID, grant.BoundTo, err)
+		http.Error(w, "Failed to check the grant's source binding", http.StatusInternalServerError)
+		return false
+	}
+	source := h.clientIP(r)
+	addr, err := netip.ParseAddr(source)
+	if err != nil || !prefix.Contains(addr.Unmap()) {
+		log.Printf("Refused credentials of grant %s bound to %s to %s", grant.ID, grant.BoundTo, source)
+		http.Error(w, "Credentials of this grant can only be retrieved from "+grant.BoundTo, http.StatusForbidden)
+		return false
+	}
+	return true
+}
+
+// handleRedeemCredentials hands the credentials of a grant to its holder in
+// exchange for a reference. They are deleted as they are retrieved, so they
+// can be retrieved only once; this is the only place they are decrypted.
+func (h *Handler) handleRedeemCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	grantID, holder, err := h.references.Resolve(r.PathValue("reference"), time.Now())
+	if err != nil {
+		http.Error(w, "Credential reference is invalid or has expired", http.StatusNotFound)
+		return
+	}
+	if holder != userID {
+		http.Error(w, "Only the grant holder can retrieve its credentials", http.StatusForbidden)
+		return
+	}
+	grant, err := h.store.GetGrant(r.Context(), grantID)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	if grant.RevokedAt != nil || !grant.ExpiresAt.After(time.Now()) {
+		writeRuleError(w, store.ErrGrantEnded)
+		return
+	}
+	if !h.checkBoundSource(w, r, grant) {
+		return
+	}
+
+	// The credentials are only deleted once they were decrypted, so a
+	// failure to decrypt them leaves them to be retrieved again
+	var plaintext []byte
+	err = h.store.TakeCredential(r.Context(), grant.ID, func(credential *store.Credential) error {
+		opened, err := h.openCredentials(r.Context(), credential)
+		if err != nil {
+			return err
+		}
+		plaintext = opened
+		return nil
+	})
+	defer clear(plaintext)
+	if errors.Is(err, store.ErrNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "credentials not provisioned or already retrieved"})
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to retrieve credentials of grant %s: %v", grant.ID, err)
+		http.Error(w, "Failed to retrieve credentials", http.StatusInternalServerError)
+		return
+	}
+	h.discardGrantSecret(r.Context(), grant.ID)
+	log.Printf("Credentials of grant %s retrieved by %s", grant.ID, userID)
+	// Usage reports count the grants whose credentials were never retrieved
+	h.recordAudit(r.Context(), audit.Entry{
+		Action:     audit.ActionCredentialsRetrieved,
+		Actor:      userID,
+		UserID:     grant.UserID,
+		ResourceID: grant.ResourceID,
+		RequestID:  grant.RequestID,
+		Details:    map[string]string{"grant_id": grant.ID},
+	})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"grant_id":    grant.ID,
+		"expires_at":  grant.ExpiresAt,
+		"credentials": json.RawMessage(plaintext),
+	})
+}
+
+// handleRotateCredentialKeys rewraps the data keys of stored credentials with
+// the current master key, so retired master keys are no longer needed
+func (h *Handler) handleRotateCredentialKeys(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	keyID := h.encrypter.KeyID()
+	rewrapped, failed := 0, 0
+	for {
+		credentials, err := h.store.CredentialsNotUnder(r.Context(), keyID, rewrapBatchSize)
+		if err != nil {
+			writeRuleError(w, err)
+			return
+		}
+		progress := 0
+		for _, credential := range credentials {
+			if err := h.rewrapCredential(r.Context(), credential); err != nil {
+				log.Printf("Failed to rewrap credentials of grant %s: %v", credential.GrantID, err)
+				failed++
+				continue
+			}
+			progress++
+		}
+		rewrapped += progress
+		// Stop when done or when a batch only holds credentials that can't be rewrapped
+		if len(credentials) < rewrapBatchSize || progress == 0 {
+			break
+		}
+	}
+	log.Printf("Credential keys rotated to %s by %s: %d rewrapped, %d failed", keyID, userID, rewrapped, failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"key_id":    keyID,
+		"rewrapped": rewrapped,
+		"failed":    failed,
+	})
+}
+
+// rewrapCredential wraps the data key of stored credentials with the current
+// master key; credentials taken meanwhile are skipped
+func (h *Handler) rewrapCredential(ctx context.Context, credential *store.Credential) error {
+	data, err := h.sealedCredentials(ctx, credential)
+	if err != nil {
+		return err
+	}
+	var sealed envelope.Sealed
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return fmt.Errorf("failed to decode sealed credentials: %v", err)
+	}
+	rewrapped, err := h.encrypter.Rewrap(ctx, &sealed)
+	if err != nil {
+		return err
+	}
+	if data, err = json.Marshal(rewrapped); err != nil {
+		return fmt.Errorf("failed to marshal sealed credentials: %v", err)
+	}
+	previousKeyID := credential.KeyID
+	credential.KeyID = rewrapped.KeyID
+	if credential.Ref != "" {
+		if err := h.secrets.Put(ctx, credential.Ref, data); err != nil {
+			return fmt.Errorf("failed to store rewrapped credentials: %v", err)
+		}
+	} else {
+		credential.Sealed = data
+	}
+	err = h.store.RewrapCredential(ctx, credential, previousKeyID)
+	if errors.Is(err, store.ErrNotFound) {
+		// Taken or ended meanwhile; don't leave the rewrapped secret behind
+		if credential.Ref != "" {
+			h.discardGrantSecret(ctx, credential.GrantID)
+		}
+		return nil
+	}
+	return err
+}
+
+// openCredentials decrypts the credentials of a grant
+func (h *Handler) openCredentials(ctx context.Context, credential *store.Credential) ([]byte, error) {
+	data, err := h.sealedCredentials(ctx, credential)
+	if err != nil {
+		return nil, err
+	}
+	var sealed envelope.Sealed
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to decode credentials: %v", err)
+	}
+	plaintext, err := h.encrypter.Open(ctx, &sealed, []byte(credential.GrantID))
+	if err != nil {
+		return nil, fmt.Errorf("failed to open credentials: %v", err)
+	}
+	return plaintext, nil
+}
+
+// sealedCredentials returns the sealed credentials, reading them from the
+// secret store when they are kept there
+func (h *Handler) sealedCredentials(ctx context.Context, credential *store.Credential) ([]byte, error) {
+	if credential.Ref == "" {
+		return credential.Sealed, nil
+	}
+	if h.secrets == nil {
+		return nil, fmt.Errorf("credentials are kept in a secret store that is not configured")
+	}
+	return h.secrets.Get(ctx, credential.Ref)
+}
+
+// discardGrantSecret deletes the secret holding a grant's credentials from the
+// secret store, if one is configured
+func (h *Handler) discardGrantSecret(ctx context.Context, grantID string) {
+	if h.secrets == nil {
+		return
+	}
+	if err := h.secrets.Delete(ctx, secrets.GrantSecretName(grantID)); err != nil {
+		log.Printf("Failed to delete the credentials of grant %s from the secret store: %v", grantID, err)
+	}
+}
+
+// isActiveOperator reports whether the operator is registered and active
+func (h *Handler) isActiveOperator(ctx context.Context, operatorID string) (bool, error) {
+	if operatorID == "" {
+		return false, nil
+	}
+	operator, err := h.store.GetOperator(ctx, operatorID)
+	if errors.Is(err, store.ErrNotFound) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	return operator.Status == "active", nil
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// degradedRetryAfter is the Retry-After, in seconds, sent while the database
+// is unavailable
+const degradedRetryAfter = 5
+
+// DegradeGracefully keeps the API answering while its database is
+// unavailable. Reads still run, as the store may answer them from its cache,
+// but those that fail get a 503 instead of a 500; writes get a 503 right away
+// rather than piling up on a database that can't take them. Both tell the
+// client when to retry.
+func (h *Handler) DegradeGracefully(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if store.Available(h.store) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			writeUnavailable(w)
+			return
+		}
+		next.ServeHTTP(&degradedWriter{ResponseWriter: w}, r)
+	})
+}
+
+// writeUnavailable tells the client the database is unavailable
+func writeUnavailable(w http.ResponseWriter) {
+	w.Header().Set("Retry-After", strconv.Itoa(degradedRetryAfter))
+	http.Error(w, "Database temporarily unavailable, try again later", http.StatusServiceUnavailable)
+}
+
+// degradedWriter turns internal server errors into 503s
+type degradedWriter struct {
+	http.ResponseWriter
+	// discard drops the body of a replaced error
+	discard bool
+}
+
+// WriteHeader replaces an internal server error with a 503
+func (w *degradedWriter) WriteHeader(status int) {
+	if status == http.StatusInternalServerError {
+		w.discard = true
+		writeUnavailable(w.ResponseWriter)
+		return
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// Write writes the body unless it belongs to a replaced error
+func (w *degradedWriter) Write(b []byte) (int, error) {
+	if w.discard {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush flushes streamed responses such as event streams
+func (w *degradedWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
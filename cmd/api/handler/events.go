@@ -0,0 +1,315 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/outbox"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/events"
+)
+
+// replayBatch is the number of events queued for replay at a time
+const replayBatch = 500
+
+// Default and largest number of delivery attempts and dead letters listed
+const (
+	defaultDeliveryLimit = 100
+	maxDeliveryLimit     = 1000
+)
+
+// SetEventDispatcher configures the dispatcher delivering events, which
+// replays go through
+func (h *Handler) SetEventDispatcher(dispatcher *outbox.Dispatcher) {
+	h.dispatcher = dispatcher
+}
+
+// replayRequest selects recorded events to deliver again
+type replayRequest struct {
+	From         time.Time `json:"from"`
+	To           time.Time `json:"to"`
+	Types        []string  `json:"types"`
+	Subscription string    `json:"subscription"`
+}
+
+// handleReplayEvents queues the events recorded in a time range, optionally
+// of some types only, for delivery again, so a consumer that missed them
+// catches up. The subscription names the publisher to replay them to, e.g.
+// webhook:https://siem.example.com/apollo; they go to every publisher without
+// one. Receivers already drop duplicates by event ID.
+func (h *Handler) handleReplayEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.dispatcher == nil {
+		http.Error(w, "Event delivery is not configured", http.StatusNotFound)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req replayRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.From.IsZero() {
+		http.Error(w, "from is required", http.StatusBadRequest)
+		return
+	}
+	if !req.To.IsZero() && !req.To.After(req.From) {
+		http.Error(w, "to must be after from", http.StatusBadRequest)
+		return
+	}
+	for _, eventType := range req.Types {
+		if _, ok := events.Lookup(eventType); !ok {
+			http.Error(w, fmt.Sprintf("Unknown event type %q", eventType), http.StatusBadRequest)
+			return
+		}
+	}
+	if req.Subscription != "" && !slices.Contains(h.dispatcher.Publishers(), req.Subscription) {
+		http.Error(w, fmt.Sprintf("Unknown subscription %q", req.Subscription), http.StatusBadRequest)
+		return
+	}
+
+	replayed := 0
+	var batch []string
+	flush := func() error {
+		if err := h.store.ReplayOutbox(r.Context(), batch, req.Subscription); err != nil {
+			return err
+		}
+		replayed += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+	query := store.EventQuery{From: req.From, To: req.To, Types: req.Types, Limit: replayBatch}
+	err := store.ReplayEvents(r.Context(), h.store, query, func(event store.Event) error {
+		if batch = append(batch, event.ID); len(batch) < replayBatch {
+			return nil
+		}
+		return flush()
+	})
+	if err == nil && len(batch) > 0 {
+		err = flush()
+	}
+	if err != nil {
+		log.Printf("Failed to replay events: %v", err)
+		http.Error(w, "Failed to replay events", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s replayed %d events to %s", userID, replayed, subscriptionName(req.Subscription))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"replayed": replayed})
+}
+
+// subscriptionName describes the publishers a replay goes to
+func subscriptionName(subscription string) string {
+	if subscription == "" {
+		return "every subscription"
+	}
+	return subscription
+}
+
+// handleListDeliveries lists delivery attempts, newest first, optionally of
+// one event, to one publisher or with one status (delivered or failed), so an
+// admin can see whether and why a notification didn't arrive
+func (h *Handler) handleListDeliveries(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	query := store.DeliveryQuery{
+		EventID:   params.Get("event_id"),
+		Publisher: params.Get("publisher"),
+		Status:    params.Get("status"),
+	}
+	if query.Status != "" && query.Status != store.DeliveryDelivered && query.Status != store.DeliveryFailed {
+		http.Error(w, fmt.Sprintf("Unknown status %q", query.Status), http.StatusBadRequest)
+		return
+	}
+	limit, ok := deliveryLimit(w, r)
+	if !ok {
+		return
+	}
+	query.Limit = limit
+
+	deliveries, err := h.store.ListDeliveries(r.Context(), query)
+	if err != nil {
+		log.Printf("Failed to list deliveries: %v", err)
+		http.Error(w, "Failed to list deliveries", http.StatusInternalServerError)
+		return
+	}
+	if deliveries == nil {
+		deliveries = []store.Delivery{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"deliveries": deliveries})
+}
+
+// deadLetter is an outbox entry that failed too often, as listed to admins
+type deadLetter struct {
+	ID        string      `json:"id"`
+	Event     store.Event `json:"event"`
+	Attempts  int         `json:"attempts"`
+	LastError string      `json:"last_error"`
+	// Delivered lists the publishers that did accept the event
+	Delivered []string  `json:"delivered"`
+	Publisher string    `json:"publisher,omitempty"`
+	DeadAt    time.Time `json:"dead_at"`
+}
+
+// handleListDeadLetters lists the events the dispatcher gave up delivering, oldest first
+func (h *Handler) handleListDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+	limit, ok := deliveryLimit(w, r)
+	if !ok {
+		return
+	}
+
+	entries, err := h.store.ListDeadLetters(r.Context(), limit)
+	if err != nil {
+		log.Printf("Failed to list dead letters: %v", err)
+		http.Error(w, "Failed to list dead letters", http.StatusInternalServerError)
+		return
+	}
+	letters := make([]deadLetter, 0, len(entries))
+	for _, entry := range entries {
+		letter := deadLetter{
+			ID:        entry.ID,
+			Event:     entry.Event,
+			Attempts:  entry.Attempts,
+			LastError: entry.LastError,
+			Delivered: entry.Delivered,
+			Publisher: entry.Publisher,
+		}
+		if entry.DeadAt != nil {
+			letter.DeadAt = *entry.DeadAt
+		}
+		if letter.Delivered == nil {
+			letter.Delivered = []string{}
+		}
+		letters = append(letters, letter)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"dead_letters": letters})
+}
+
+// redeliverRequest names dead letters to deliver again
+type redeliverRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// handleRedeliverDeadLetters queues dead letters for delivery again with
+// their attempts reset. Publishers that accepted an event before aren't sent
+// it again.
+func (h *Handler) handleRedeliverDeadLetters(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	var req redeliverRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) == 0 {
+		http.Error(w, "ids is required", http.StatusBadRequest)
+		return
+	}
+	if len(req.IDs) > maxDeliveryLimit {
+		http.Error(w, fmt.Sprintf("At most %d dead letters can be redelivered at a time", maxDeliveryLimit), http.StatusBadRequest)
+		return
+	}
+
+	if err := h.store.RedeliverOutbox(r.Context(), req.IDs, time.Now().UTC()); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			http.Error(w, "Dead letter not found", http.StatusNotFound)
+			return
+		}
+		log.Printf("Failed to redeliver dead letters: %v", err)
+		http.Error(w, "Failed to redeliver dead letters", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s redelivered %d dead letters", userID, len(req.IDs))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"redelivered": len(req.IDs)})
+}
+
+// deliveryLimit reads the limit query parameter, writing an error when it's invalid
+func deliveryLimit(w http.ResponseWriter, r *http.Request) (int, bool) {
+	value := r.URL.Query().Get("limit")
+	if value == "" {
+		return defaultDeliveryLimit, true
+	}
+	limit, err := strconv.Atoi(value)
+	if err != nil || limit <= 0 || limit > maxDeliveryLimit {
+		http.Error(w, fmt.Sprintf("limit must be between 1 and %d", maxDeliveryLimit), http.StatusBadRequest)
+		return 0, false
+	}
+	return limit, true
+}
+
+// handleEventSchemas lists the event types published to webhooks and the bus,
+// with the JSON schema of each
+func (h *Handler) handleEventSchemas(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	type entry struct {
+		events.Schema
+		JSONSchema map[string]interface{} `json:"schema"`
+	}
+	var entries []entry
+	for _, schema := range events.Schemas() {
+		entries = append(entries, entry{Schema: schema, JSONSchema: schema.JSONSchema()})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{"events": entries})
+}
+
+// handleEventSchema returns the JSON schema of the events of a type
+func (h *Handler) handleEventSchema(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	schema, ok := events.Lookup(r.PathValue("type"))
+	if !ok {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "unknown event type"})
+		return
+	}
+	w.Header().Set("Content-Type", "application/schema+json")
+	json.NewEncoder(w).Encode(schema.JSONSchema())
+}
@@ -0,0 +1,33 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/petermein/apollo/cmd/api/features"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SetFeatures sets the feature flags gating subsystems; every feature is on
+// without them
+func (h *Handler) SetFeatures(set *features.Set) {
+	h.features = set
+}
+
+// holdAutoApproval makes a request the policy would approve by itself wait
+// for an approver while auto-approval is off for the requester
+func (h *Handler) holdAutoApproval(request *models.PrivilegeRequest) {
+	if request.RequiredApprovals == 0 && !h.features.EnabledFor(features.AutoApproval, request.UserID) {
+		request.RequiredApprovals = 1
+	}
+}
+
+// handleListFeatures lists the feature flags and where each is set
+func (h *Handler) handleListFeatures(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.features.List())
+}
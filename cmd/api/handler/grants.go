@@ -0,0 +1,341 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// extensionResponse describes a request to extend a grant
+type extensionResponse struct {
+	ID           string     `json:"id"`
+	GrantID      string     `json:"grant_id"`
+	Duration     string     `json:"duration"`
+	Reason       string     `json:"reason"`
+	Status       string     `json:"status"`
+	AutoApproved bool       `json:"auto_approved"`
+	Approvers    []string   `json:"approvers,omitempty"`
+	ExpiresAt    *time.Time `json:"expires_at,omitempty"`
+}
+
+// handoverResponse describes a request to hand a grant over to another user
+type handoverResponse struct {
+	ID           string   `json:"id"`
+	GrantID      string   `json:"grant_id"`
+	To           string   `json:"to"`
+	Reason       string   `json:"reason"`
+	Status       string   `json:"status"`
+	AutoApproved bool     `json:"auto_approved"`
+	Approvers    []string `json:"approvers,omitempty"`
+	// NewGrantID is the new holder's grant once the handover is approved
+	NewGrantID string     `json:"new_grant_id,omitempty"`
+	ExpiresAt  *time.Time `json:"expires_at,omitempty"`
+}
+
+// handleListGrants lists the caller's active grants. Administrators may list
+// another user's with ?user= or everyone's with ?all_users=true.
+func (h *Handler) handleListGrants(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	holder, ok := h.listedUser(w, r)
+	if !ok {
+		return
+	}
+
+	grants, err := h.GetActiveGrants(r.Context(), holder)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grants)
+}
+
+// handleRevokeGrant ends a grant early; holders may revoke their own grants and
+// administrators anyone's
+func (h *Handler) handleRevokeGrant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	grant, ok := h.getGrant(w, r)
+	if !ok {
+		return
+	}
+	if grant.UserID != userID && !slices.Contains(h.admins, userID) {
+		http.Error(w, "Only the grant holder or an administrator can revoke a grant", http.StatusForbidden)
+		return
+	}
+
+	grant, err := h.RevokePrivilege(r.Context(), grant.ID, userID)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(grant)
+}
+
+// extendGrantRequest asks for more time on a grant
+type extendGrantRequest struct {
+	Duration string `json:"duration"`
+	Reason   string `json:"reason"`
+}
+
+// handleExtendGrant requests more time for an active grant. The extension is
+// evaluated against the rules like a new request for the same access and
+// either applied immediately or routed to approvers; approving it extends the
+// grant instead of creating a new one.
+func (h *Handler) handleExtendGrant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req extendGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	grant, ok := h.getGrant(w, r)
+	if !ok {
+		return
+	}
+	if grant.UserID != userID {
+		http.Error(w, "Only the grant holder can extend a grant", http.StatusForbidden)
+		return
+	}
+
+	now := time.Now().UTC()
+	request := &models.PrivilegeRequest{
+		UserID:       userID,
+		Module:       grant.Module,
+		ResourceID:   grant.ResourceID,
+		Level:        grant.Level,
+		Reason:       req.Reason,
+		SourceIP:     h.clientIP(r),
+		RequestedAt:  now,
+		ExpiresAt:    now.Add(duration),
+		PendingUntil: h.pendingDeadline(now),
+		Status:       store.RequestStatusPending,
+		ExtendsGrant: grant.ID,
+	}
+	if err := h.ruleEngine.EvaluateRequest(request); err != nil {
+		log.Printf("Extension of grant %s by %s rejected: %v", grant.ID, userID, err)
+		writeRuleError(w, err)
+		return
+	}
+	h.holdAutoApproval(request)
+	autoApproved := request.RequiredApprovals == 0 && request.StepUp.Satisfied()
+	if autoApproved {
+		h.recordApproval(r.Context(), request, policyApprover, models.ApprovalChannelPolicy, nil)
+		approve(request, "policy")
+	}
+	if err := h.store.CreateRequest(r.Context(), request); err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	log.Printf("Extension %s of grant %s by %s is %s", request.ID, grant.ID, userID, request.Status)
+	h.auditCreated(r.Context(), userID, request)
+
+	response := extensionResponse{
+		ID:           request.ID,
+		GrantID:      grant.ID,
+		Duration:     req.Duration,
+		Reason:       req.Reason,
+		Status:       request.Status,
+		AutoApproved: autoApproved,
+		Approvers:    request.Approvers,
+	}
+	if autoApproved {
+		if extended, err := h.store.GetGrant(r.Context(), grant.ID); err == nil {
+			response.ExpiresAt = &extended.ExpiresAt
+			h.auditGrant(r.Context(), audit.ActionGrantExtended, policyApprover.ID, extended)
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handOverGrantRequest hands a grant's remaining time to another user
+type handOverGrantRequest struct {
+	To     string `json:"to"`
+	Reason string `json:"reason"`
+}
+
+// handleHandOverGrant hands the rest of an active grant over to another
+// user, e.g. at a shift change during an incident. Holders may hand over their
+// own grants and administrators anyone's. The handover is evaluated against
+// the rules like a request of the new holder for the same access and either
+// applied immediately or routed to approvers; approving it ends the grant,
+// revoking its credentials, and creates the new holder's grant until the same
+// time, for which fresh credentials are provisioned.
+func (h *Handler) handleHandOverGrant(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req handOverGrantRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.To == "" {
+		http.Error(w, "The user to hand the grant over to is required", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	grant, ok := h.getGrant(w, r)
+	if !ok {
+		return
+	}
+	if grant.UserID != userID && !slices.Contains(h.admins, userID) {
+		http.Error(w, "Only the grant holder or an administrator can hand over a grant", http.StatusForbidden)
+		return
+	}
+	if req.To == grant.UserID {
+		http.Error(w, "The grant is already held by "+req.To, http.StatusBadRequest)
+		return
+	}
+	now := time.Now().UTC()
+	if grant.RevokedAt != nil || !grant.ExpiresAt.After(now) {
+		writeRuleError(w, store.ErrGrantEnded)
+		return
+	}
+
+	request := &models.PrivilegeRequest{
+		UserID:         req.To,
+		Module:         grant.Module,
+		ResourceID:     grant.ResourceID,
+		Level:          grant.Level,
+		Reason:         req.Reason,
+		SourceIP:       h.clientIP(r),
+		RequestedAt:    now,
+		ExpiresAt:      grant.ExpiresAt,
+		PendingUntil:   h.pendingDeadline(now),
+		Status:         store.RequestStatusPending,
+		HandsOverGrant: grant.ID,
+		HandedOverBy:   userID,
+	}
+	if err := h.ruleEngine.EvaluateRequest(request); err != nil {
+		log.Printf("Handover of grant %s from %s to %s rejected: %v", grant.ID, grant.UserID, req.To, err)
+		writeRuleError(w, err)
+		return
+	}
+	h.holdAutoApproval(request)
+	autoApproved := request.RequiredApprovals == 0 && request.StepUp.Satisfied()
+	if autoApproved {
+		h.recordApproval(r.Context(), request, policyApprover, models.ApprovalChannelPolicy, nil)
+		approve(request, "policy")
+	}
+	if err := h.store.CreateRequest(r.Context(), request); err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	log.Printf("Handover %s of grant %s from %s to %s by %s is %s", request.ID, grant.ID, grant.UserID, req.To, userID, request.Status)
+	h.auditCreated(r.Context(), userID, request)
+	h.logGrant(r.Context(), request)
+
+	response := handoverResponse{
+		ID:           request.ID,
+		GrantID:      grant.ID,
+		To:           req.To,
+		Reason:       req.Reason,
+		Status:       request.Status,
+		AutoApproved: autoApproved,
+		Approvers:    request.Approvers,
+	}
+	if autoApproved {
+		if handedOver, err := h.store.GetRequestGrant(r.Context(), request.ID); err == nil {
+			response.NewGrantID = handedOver.ID
+			response.ExpiresAt = &handedOver.ExpiresAt
+		}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(response)
+}
+
+// handlePrivilegeEvents returns the lifecycle events of a privilege request and
+// the state projected from them, to its requester and administrators
+func (h *Handler) handlePrivilegeEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, err := h.store.GetRequest(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	if !h.requireOwnerOrAdmin(w, r, request.UserID) {
+		return
+	}
+	events, err := h.store.StreamEvents(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	state, err := store.Project(events)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"state":  state,
+		"events": events,
+	})
+}
+
+// getGrant reads the grant named in the path, writing an error response when it can't
+func (h *Handler) getGrant(w http.ResponseWriter, r *http.Request) (*models.PrivilegeGrant, bool) {
+	grant, err := h.store.GetGrant(r.Context(), r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusNotFound)
+		json.NewEncoder(w).Encode(map[string]string{"error": "grant not found"})
+		return nil, false
+	}
+	if err != nil {
+		writeRuleError(w, err)
+		return nil, false
+	}
+	return grant, true
+}
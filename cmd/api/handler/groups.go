@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/directory"
+	"github.com/petermein/apollo/internal/rules"
+)
+
+// GroupSyncReport is the outcome of syncing groups from the identity provider
+type GroupSyncReport struct {
+	// Trigger is schedule, or the user who started the sync
+	Trigger    string          `json:"trigger"`
+	StartedAt  time.Time       `json:"started_at"`
+	FinishedAt time.Time       `json:"finished_at"`
+	Groups     int             `json:"groups"`
+	Drift      directory.Drift `json:"drift"`
+	Error      string          `json:"error,omitempty"`
+}
+
+// SetGroupSource syncs groups from the identity provider, for policies and
+// approval routes to refer to by name
+func (h *Handler) SetGroupSource(source directory.Source) {
+	h.groupSource = source
+}
+
+// StartGroupSync loads the groups synced before, then syncs them from the
+// identity provider at once and at the given interval until the context is
+// cancelled
+func (h *Handler) StartGroupSync(ctx context.Context, interval time.Duration) {
+	if err := h.loadGroups(ctx); err != nil {
+		log.Printf("Failed to load synced groups: %v", err)
+	}
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			if _, err := h.syncGroups(ctx, "schedule"); err != nil {
+				log.Printf("Failed to sync groups: %v", err)
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+}
+
+// loadGroups resolves group names with the groups stored by the last sync
+func (h *Handler) loadGroups(ctx context.Context) error {
+	stored, err := h.store.ListGroups(ctx)
+	if err != nil {
+		return err
+	}
+	members := make(map[string][]string, len(stored))
+	var syncedAt time.Time
+	for _, group := range stored {
+		members[group.Name] = group.Members
+		if group.SyncedAt.After(syncedAt) {
+			syncedAt = group.SyncedAt
+		}
+	}
+	h.groups.Set(members, syncedAt)
+	return nil
+}
+
+// syncGroups replaces the stored groups with those of the identity provider
+// and reports how they drifted since the last sync. An empty answer from a
+// provider that had groups before is refused, so a misconfigured filter or
+// permission can't silently empty every group policies refer to.
+func (h *Handler) syncGroups(ctx context.Context, trigger string) (*GroupSyncReport, error) {
+	h.groupSyncMu.Lock()
+	defer h.groupSyncMu.Unlock()
+
+	report := &GroupSyncReport{Trigger: trigger, StartedAt: time.Now().UTC()}
+	err := h.replaceGroups(ctx, report)
+	report.FinishedAt = time.Now().UTC()
+	if err != nil {
+		report.Error = err.Error()
+	}
+	h.lastGroupSync = report
+	return report, err
+}
+
+// replaceGroups fetches and stores the groups, recording the drift on the report
+func (h *Handler) replaceGroups(ctx context.Context, report *GroupSyncReport) error {
+	current, err := h.groupSource.Groups(ctx)
+	if err != nil {
+		return err
+	}
+	previous, _ := h.groups.Snapshot()
+	if len(current) == 0 && len(previous) > 0 {
+		return fmt.Errorf("identity provider returned no groups, keeping the %d synced before", len(previous))
+	}
+
+	groups := make([]*store.DirectoryGroup, 0, len(current))
+	for name, members := range current {
+		groups = append(groups, &store.DirectoryGroup{Name: name, Members: members, SyncedAt: report.StartedAt})
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	if err := h.store.ReplaceGroups(ctx, groups); err != nil {
+		return fmt.Errorf("failed to store groups: %v", err)
+	}
+	h.groups.Set(current, report.StartedAt)
+
+	report.Groups = len(current)
+	report.Drift = directory.Compare(previous, current)
+	if !report.Drift.Empty() {
+		log.Printf("Groups drifted since the last sync: %d added, %d removed, %d changed",
+			len(report.Drift.AddedGroups), len(report.Drift.RemovedGroups), len(report.Drift.Changed))
+	}
+	if missing := h.missingGroups(); len(missing) > 0 {
+		log.Printf("Policies refer to groups the identity provider doesn't have: %v", missing)
+	}
+	return nil
+}
+
+// missingGroups returns the groups the policies refer to that weren't synced
+func (h *Handler) missingGroups() []string {
+	engine, ok := h.ruleEngine.(*rules.DefaultRuleEngine)
+	if !ok {
+		return nil
+	}
+	var missing []string
+	for _, name := range engine.Rules().ReferencedGroups() {
+		if _, ok := h.groups.GroupMembers(name); !ok {
+			missing = append(missing, name)
+		}
+	}
+	return missing
+}
+
+// groupSummary describes a synced group
+type groupSummary struct {
+	Name     string    `json:"name"`
+	Members  []string  `json:"members"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// handleListGroups lists the groups synced from the identity provider
+func (h *Handler) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	stored, err := h.store.ListGroups(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	groups := make([]groupSummary, 0, len(stored))
+	for _, group := range stored {
+		groups = append(groups, groupSummary{Name: group.Name, Members: group.Members, SyncedAt: group.SyncedAt})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(groups)
+}
+
+// handleSyncGroups syncs the groups from the identity provider right away
+func (h *Handler) handleSyncGroups(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	if h.groupSource == nil {
+		http.Error(w, "Group sync is not configured", http.StatusNotFound)
+		return
+	}
+
+	report, err := h.syncGroups(r.Context(), userID)
+	if err != nil {
+		log.Printf("Group sync started by %s failed: %v", userID, err)
+	} else {
+		log.Printf("Group sync started by %s synced %d groups", userID, report.Groups)
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if err != nil {
+		w.WriteHeader(http.StatusBadGateway)
+	}
+	json.NewEncoder(w).Encode(report)
+}
+
+// groupDrift reports the drift found by the last sync and the groups
+// policies refer to that the identity provider doesn't have
+type groupDrift struct {
+	LastSync      *GroupSyncReport `json:"last_sync,omitempty"`
+	MissingGroups []string         `json:"missing_groups"`
+}
+
+// handleGroupDrift reports how the groups drifted at the last sync
+func (h *Handler) handleGroupDrift(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	h.groupSyncMu.Lock()
+	drift := groupDrift{LastSync: h.lastGroupSync, MissingGroups: h.missingGroups()}
+	h.groupSyncMu.Unlock()
+	if drift.MissingGroups == nil {
+		drift.MissingGroups = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(drift)
+}
@@ -1,45 +1,290 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"log"
 	"net/http"
+	"net/netip"
+	"slices"
+	"sync"
 	"time"
 
+	"github.com/petermein/apollo/cmd/api/attest"
+	"github.com/petermein/apollo/cmd/api/digest"
+	"github.com/petermein/apollo/cmd/api/envelope"
+	"github.com/petermein/apollo/cmd/api/features"
+	"github.com/petermein/apollo/cmd/api/mattermost"
 	"github.com/petermein/apollo/cmd/api/modules"
 	"github.com/petermein/apollo/cmd/api/modules/mysql"
+	"github.com/petermein/apollo/cmd/api/outbox"
+	"github.com/petermein/apollo/cmd/api/report"
+	"github.com/petermein/apollo/cmd/api/retention"
+	"github.com/petermein/apollo/cmd/api/secrets"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/directory"
+	"github.com/petermein/apollo/internal/rules"
+	"github.com/petermein/apollo/internal/webauthn"
 )
 
 // Handler handles API requests
 type Handler struct {
-	modules []modules.Module
+	modules           []modules.Module
+	trustedProxies    []netip.Prefix
+	stepUpVerifier    *auth.Verifier
+	ruleEngine        rules.RuleEngine
+	policyVersions    *rules.VersionStore
+	policyReloader    *rules.Reloader
+	store             store.Store
+	retention         *retention.Worker
+	reports           *report.Generator
+	features          *features.Set
+	admins            []string
+	adminGroups       []string
+	exports           *auditExporter
+	auditRecorder     audit.Recorder
+	bus               bus.Bus
+	resultSub         bus.Subscription
+	dispatcher        *outbox.Dispatcher
+	encrypter         *envelope.Encrypter
+	secrets           secrets.Store
+	references        *secrets.References
+	approvalLinks     *digest.Links
+	mattermostActions *mattermost.Actions
+	approvalSigner    *attest.Signer
+	region            string
+	// pendingTTL is how long new requests wait for a decision before they
+	// expire; they wait indefinitely when zero
+	pendingTTL time.Duration
+
+	// groups resolves the identity provider groups policies refer to
+	groups        *directory.Groups
+	groupSource   directory.Source
+	groupSyncMu   sync.Mutex
+	lastGroupSync *GroupSyncReport
+
+	// webauthn verifies the hardware keys approvers confirm approvals with
+	webauthn *webauthn.RelyingParty
+
+	// cookieSessions configures the sessions browsers authenticate with,
+	// which are off when nil
+	cookieSessions *CookieSessionPolicy
+
+	operatorSigning         *auth.HMACVerifier
+	operatorSigningRequired bool
+	operatorTokenTTL        time.Duration
+	operatorTokensRequired  bool
+
+	// jobsDispatched wakes the job streams of operators connected over gRPC
+	jobsDispatched jobNotifier
+
+	// routes is the mux the routes are registered on
+	routes *http.ServeMux
 }
 
-// NewHandler creates a new API handler
-func NewHandler(modules []modules.Module) *Handler {
+// NewHandler creates a new API handler keeping its state in the given store
+func NewHandler(modules []modules.Module, st store.Store) *Handler {
 	log.Printf("Initializing API handler with %d modules", len(modules))
 	for _, m := range modules {
 		log.Printf("- Module enabled: %s (%s)", m.Name(), m.Description())
 	}
-	return &Handler{
+	h := &Handler{
 		modules: modules,
+		store:   st,
+		groups:  directory.NewGroups(),
 	}
+	h.SetRuleEngine(rules.NewDefaultRuleEngine(rules.DefaultRules()))
+	if err := h.SetAuditExport("", nil, 0); err != nil {
+		log.Printf("Audit export is unavailable: %v", err)
+	}
+	encrypter, err := newEphemeralEncrypter()
+	if err != nil {
+		log.Fatalf("Failed to create credential encrypter: %v", err)
+	}
+	h.encrypter = encrypter
+	references, err := newEphemeralReferences()
+	if err != nil {
+		log.Fatalf("Failed to create credential references: %v", err)
+	}
+	h.references = references
+	signer, err := attest.NewEphemeralSigner()
+	if err != nil {
+		log.Fatalf("Failed to create approval signer: %v", err)
+	}
+	h.approvalSigner = signer
+	return h
+}
+
+// SetAdmins sets the users allowed to run administrative operations
+func (h *Handler) SetAdmins(admins []string) {
+	h.admins = admins
+}
+
+// SetAdminGroups sets the groups whose members, as named by their bearer
+// token, are administrators
+func (h *Handler) SetAdminGroups(groups []string) {
+	h.adminGroups = groups
+}
+
+// PublicPaths are served without a bearer token: health checks, the API's
+// description, and links and callbacks that carry their own signature
+var PublicPaths = []string{
+	"/api/v1/health",
+	"/api/v1/openapi.json",
+	"/api/v1/approvals/",
+	"/api/v1/mattermost/actions",
+}
+
+// SetRegion sets the region the server runs in. Operators and servers that
+// register without naming their region are taken to run in it.
+func (h *Handler) SetRegion(region string) {
+	h.region = region
+}
+
+// requireAdmin returns the requesting user, writing an error response unless
+// the user is an administrator
+func (h *Handler) requireAdmin(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return "", false
+	}
+	// Service accounts only reach admin endpoints their scopes allow
+	if _, ok := serviceAccountFrom(r.Context()); ok {
+		return userID, true
+	}
+	principal, authenticated := auth.PrincipalFrom(r.Context())
+	if !slices.Contains(h.admins, userID) && !(authenticated && principal.InGroup(h.adminGroups...)) {
+		http.Error(w, "Only administrators can do this", http.StatusForbidden)
+		return "", false
+	}
+	return userID, true
+}
+
+// listedUser returns the user whose records a listing shows: the caller's
+// own, another user's with user or, with all_users=true, everyone's, as an
+// empty user. Only administrators list other users' records.
+func (h *Handler) listedUser(w http.ResponseWriter, r *http.Request) (string, bool) {
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return "", false
+	}
+	query := r.URL.Query()
+	listed := userID
+	if query.Get("all_users") == "true" {
+		listed = ""
+	} else if user := query.Get("user"); user != "" {
+		listed = user
+	}
+	if listed != userID {
+		if _, ok := h.requireAdmin(w, r); !ok {
+			return "", false
+		}
+	}
+	return listed, true
+}
+
+// requireOwnerOrAdmin writes an error response unless the caller is the user
+// or an administrator
+func (h *Handler) requireOwnerOrAdmin(w http.ResponseWriter, r *http.Request, owner string) bool {
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return false
+	}
+	if userID == owner {
+		return true
+	}
+	_, ok := h.requireAdmin(w, r)
+	return ok
 }
 
 // RegisterRoutes registers all API routes
 func (h *Handler) RegisterRoutes(mux *http.ServeMux) {
 	log.Println("Registering API routes...")
+	h.routes = mux
 	mux.HandleFunc("/api/v1/ping", h.handlePing)
 	mux.HandleFunc("/api/v1/health", h.handleHealth)
+	mux.HandleFunc("/api/v1/health/tree", h.handleHealthTree)
+	mux.HandleFunc("/api/v1/features", h.handleListFeatures)
+	mux.HandleFunc("/api/v1/openapi.json", h.handleOpenAPI)
 	mux.HandleFunc("/api/v1/mysql/servers", h.handleListMySQLServers)
 	mux.HandleFunc("/api/v1/mysql/servers/register", h.handleRegisterMySQLServer)
 	mux.HandleFunc("/api/v1/mysql/servers/inactive", h.handleMarkMySQLServerInactive)
 	mux.HandleFunc("/api/v1/operators/register", h.handleRegisterOperator)
 	mux.HandleFunc("/api/v1/operators/health", h.handleOperatorHealth)
 	mux.HandleFunc("/api/v1/operators", h.handleListOperators)
+	mux.HandleFunc("/api/v1/operators/token", h.handleRefreshOperatorToken)
+	mux.HandleFunc("/api/v1/operators/{id}/tokens", h.handleRevokeOperatorTokens)
+	mux.HandleFunc("/api/v1/jobs", h.handleGetJob)
+	mux.HandleFunc("/api/v1/jobs/ping", h.handleCreatePingJob)
+	mux.HandleFunc("/api/v1/jobs/pending", h.handleListPendingJobs)
+	mux.HandleFunc("/api/v1/jobs/{id}", h.handleUpdateJob)
+	mux.HandleFunc("/api/v1/privileges/request", h.handleCreatePrivilegeRequest)
+	mux.HandleFunc("/api/v1/privileges/requests", h.handleListPrivilegeRequests)
+	mux.HandleFunc("/api/v1/privileges/{id}", h.handleGetPrivilegeRequest)
+	mux.HandleFunc("/api/v1/privileges/{id}/approve", h.handleApprovePrivilegeRequest)
+	mux.HandleFunc("/api/v1/privileges/{id}/approve/options", h.handleApprovalOptions)
+	mux.HandleFunc("/api/v1/privileges/{id}/deny", h.handleDenyPrivilegeRequest)
+	mux.HandleFunc("/api/v1/privileges/{id}/step-up", h.handlePrivilegeStepUp)
+	mux.HandleFunc("/api/v1/privileges/{id}/events", h.handlePrivilegeEvents)
+	mux.HandleFunc("/api/v1/approvals/{token}", h.handleApprovalLink)
+	mux.HandleFunc("/api/v1/mattermost/actions", h.handleMattermostAction)
+	mux.HandleFunc("/api/v1/events/stream", h.handleStreamEvents)
+	mux.HandleFunc("/api/v1/events/replay", h.handleReplayEvents)
+	mux.HandleFunc("/api/v1/events/deliveries", h.handleListDeliveries)
+	mux.HandleFunc("/api/v1/events/dead-letters", h.handleListDeadLetters)
+	mux.HandleFunc("/api/v1/events/dead-letters/redeliver", h.handleRedeliverDeadLetters)
+	mux.HandleFunc("/api/v1/events/schemas", h.handleEventSchemas)
+	mux.HandleFunc("/api/v1/events/schemas/{type}", h.handleEventSchema)
+	mux.HandleFunc("/api/v1/grants", h.handleListGrants)
+	mux.HandleFunc("/api/v1/grants/{id}/revoke", h.handleRevokeGrant)
+	mux.HandleFunc("/api/v1/grants/{id}/extend", h.handleExtendGrant)
+	mux.HandleFunc("/api/v1/grants/{id}/handover", h.handleHandOverGrant)
+	mux.HandleFunc("/api/v1/grants/{id}/credentials", h.handleGrantCredentials)
+	mux.HandleFunc("/api/v1/grants/{id}/approvals", h.handleVerifyGrantApprovals)
+	mux.HandleFunc("/api/v1/credentials/rotate", h.handleRotateCredentialKeys)
+	mux.HandleFunc("/api/v1/credentials/{reference}", h.handleRedeemCredentials)
+	mux.HandleFunc("/api/v1/policies/simulate", h.handleSimulatePolicy)
+	mux.HandleFunc("/api/v1/policies/test", h.handleTestPolicy)
+	mux.HandleFunc("/api/v1/policies/versions", h.handlePolicyVersions)
+	mux.HandleFunc("/api/v1/policies/versions/{version}/shadow", h.handleShadowPolicyVersion)
+	mux.HandleFunc("/api/v1/policies/versions/{version}/promote", h.handlePromotePolicyVersion)
+	mux.HandleFunc("/api/v1/retention/run", h.handleRunRetention)
+	mux.HandleFunc("/api/v1/reports", h.handleListReports)
+	mux.HandleFunc("/api/v1/reports/run", h.handleRunReport)
+	mux.HandleFunc("/api/v1/reports/{id}", h.handleDownloadReport)
+	mux.HandleFunc("/api/v1/audit", h.handleListAudit)
+	mux.HandleFunc("/api/v1/audit/export", h.handleAuditExport)
+	mux.HandleFunc("/api/v1/audit/exports/{id}", h.handleGetAuditExport)
+	mux.HandleFunc("/api/v1/audit/exports/{id}/download", h.handleDownloadAuditExport)
+	mux.HandleFunc("/api/v1/audit/approval-keys", h.handleApprovalKeys)
+	mux.HandleFunc("/api/v1/service-accounts", h.handleServiceAccounts)
+	mux.HandleFunc("/api/v1/service-accounts/{id}", h.handleServiceAccount)
+	mux.HandleFunc("/api/v1/service-accounts/{id}/tokens", h.handleServiceTokens)
+	mux.HandleFunc("/api/v1/service-accounts/{id}/tokens/{token}", h.handleRevokeServiceToken)
+	mux.HandleFunc("/api/v1/groups", h.handleListGroups)
+	mux.HandleFunc("/api/v1/groups/sync", h.handleSyncGroups)
+	mux.HandleFunc("/api/v1/groups/drift", h.handleGroupDrift)
+	mux.HandleFunc("/api/v1/webauthn/credentials", h.handleWebAuthnCredentials)
+	mux.HandleFunc("/api/v1/webauthn/credentials/options", h.handleWebAuthnCredentialOptions)
+	mux.HandleFunc("/api/v1/webauthn/credentials/{id}", h.handleDeleteWebAuthnCredential)
+	mux.HandleFunc("/api/v1/sessions", h.handleSessions)
+	mux.HandleFunc("/api/v1/sessions/{id}", h.handleRevokeSession)
+	mux.HandleFunc("/api/v1/auth/session", h.handleCookieSession)
 	log.Println("API routes registered successfully")
 }
 
+// pingRequest asks an operator to ping a server
+type pingRequest struct {
+	Module string `json:"module"`
+	Server string `json:"server"`
+}
+
 // handlePing handles ping requests
 func (h *Handler) handlePing(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -47,14 +292,14 @@ func (h *Handler) handlePing(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		Module string `json:"module"`
-		Server string `json:"server"`
-	}
+	var req pingRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if !h.allowModule(w, r, ActionCreateJobs, req.Module) {
+		return
+	}
 
 	// Find the appropriate module
 	var module modules.Module
@@ -104,16 +349,30 @@ func (h *Handler) handleHealth(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
+	// Report degraded rather than failing while the database is unavailable,
+	// as reads are still served from the cache
+	status, database := "ok", "available"
+	if !store.Available(h.store) {
+		status, database = "degraded", "unavailable"
+	}
+
+	response := map[string]interface{}{
+		"status":   status,
+		"time":     time.Now().UTC(),
+		"database": database,
+		"modules":  health,
+	}
+	if h.resultSub != nil {
+		response["bus"] = []bus.SubscriptionStats{h.resultSub.Stats()}
+	}
+
 	// Return health status
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"status":  "ok",
-		"time":    time.Now().UTC(),
-		"modules": health,
-	})
+	json.NewEncoder(w).Encode(response)
 }
 
-// handleListMySQLServers handles requests to list MySQL servers
+// handleListMySQLServers handles requests to list MySQL servers, the active
+// ones unless a status is given, optionally in one region and paged
 func (h *Handler) handleListMySQLServers(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodGet {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
@@ -134,14 +393,35 @@ func (h *Handler) handleListMySQLServers(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	// Get list of servers
-	servers, err := mysqlModule.ListServers(r.Context())
+	params := r.URL.Query()
+	filter := store.ServerFilter{Status: params.Get("status"), Region: params.Get("region")}
+	switch filter.Status {
+	case "":
+		filter.Status = "active"
+	case "active", "inactive":
+	default:
+		http.Error(w, "Status must be active or inactive", http.StatusBadRequest)
+		return
+	}
+	page, err := parsePage(params, store.ServerSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Page = page
+
+	// Get list of servers; the module keeps them in the handler's store
+	servers, err := h.store.ListServers(r.Context(), mysqlModule.Name(), filter)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
+	if servers == nil {
+		servers = []modules.ServerInfo{}
+	}
 
 	// Return the servers list
+	setNextLink(w, r, page, len(servers))
 	w.Header().Set("Content-Type", "application/json")
 	json.NewEncoder(w).Encode(servers)
 }
@@ -158,6 +438,9 @@ func (h *Handler) handleRegisterMySQLServer(w http.ResponseWriter, r *http.Reque
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
 	}
+	if server.Region == "" {
+		server.Region = h.region
+	}
 
 	// Find MySQL module
 	var mysqlModule modules.Module
@@ -182,6 +465,11 @@ func (h *Handler) handleRegisterMySQLServer(w http.ResponseWriter, r *http.Reque
 	w.WriteHeader(http.StatusCreated)
 }
 
+// markServerInactiveRequest names a server that is no longer available
+type markServerInactiveRequest struct {
+	Name string `json:"name"`
+}
+
 // handleMarkMySQLServerInactive handles requests to mark a MySQL server as inactive
 func (h *Handler) handleMarkMySQLServerInactive(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
@@ -189,9 +477,7 @@ func (h *Handler) handleMarkMySQLServerInactive(w http.ResponseWriter, r *http.R
 		return
 	}
 
-	var req struct {
-		Name string `json:"name"`
-	}
+	var req markServerInactiveRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
 		return
@@ -225,9 +511,34 @@ func (h *Handler) handleMarkMySQLServerInactive(w http.ResponseWriter, r *http.R
 	w.WriteHeader(http.StatusOK)
 }
 
+// operatorRegistry is implemented by modules that register operators and
+// record their health checks
+type operatorRegistry interface {
+	modules.Module
+	RegisterOperator(ctx context.Context, id, region string) error
+	UpdateOperatorHealth(ctx context.Context, id string, timestamp time.Time, health []modules.ModuleHealth) error
+}
+
+// operatorRegistry returns the first enabled module that keeps track of
+// operators, or nil. They all keep them in the same store.
+func (h *Handler) operatorRegistry() operatorRegistry {
+	for _, m := range h.modules {
+		if registry, ok := m.(operatorRegistry); ok {
+			return registry
+		}
+	}
+	return nil
+}
+
+// registerOperatorRequest registers an operator
+type registerOperatorRequest struct {
+	ID     string `json:"id"`
+	Region string `json:"region"`
+}
+
 // handleRegisterOperator handles requests to register a new operator
 func (h *Handler) handleRegisterOperator(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received operator registration request from %s", r.RemoteAddr)
+	log.Printf("Received operator registration request from %s", h.clientIP(r))
 
 	if r.Method != http.MethodPost {
 		log.Printf("Method not allowed: %s", r.Method)
@@ -235,9 +546,7 @@ func (h *Handler) handleRegisterOperator(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	var req struct {
-		ID string `json:"id"`
-	}
+	var req registerOperatorRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid request body: %v", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -250,37 +559,57 @@ func (h *Handler) handleRegisterOperator(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	log.Printf("Processing registration for operator: %s", req.ID)
+	if !checkSignedOperator(w, r, req.ID) {
+		return
+	}
 
-	// Find MySQL module
-	var mysqlModule modules.Module
-	for _, m := range h.modules {
-		if m.Name() == "mysql" {
-			mysqlModule = m
-			break
-		}
+	if req.Region == "" {
+		req.Region = h.region
 	}
+	log.Printf("Processing registration for operator: %s", req.ID)
 
-	if mysqlModule == nil {
-		log.Printf("MySQL module not found in enabled modules")
-		http.Error(w, "MySQL module not found", http.StatusNotFound)
+	registry := h.operatorRegistry()
+	if registry == nil {
+		log.Printf("No enabled module keeps track of operators")
+		http.Error(w, "No module keeps track of operators", http.StatusNotFound)
 		return
 	}
 
 	// Register the operator
-	if err := mysqlModule.(*mysql.Module).RegisterOperator(r.Context(), req.ID); err != nil {
+	if err := registry.RegisterOperator(r.Context(), req.ID, req.Region); err != nil {
 		log.Printf("Error registering operator %s: %v", req.ID, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	token, err := h.issueOperatorToken(r.Context(), req.ID)
+	if err != nil {
+		log.Printf("Error issuing token to operator %s: %v", req.ID, err)
+		http.Error(w, "Failed to issue operator token", http.StatusInternalServerError)
+		return
+	}
+
 	log.Printf("Successfully registered operator: %s", req.ID)
+	h.recordAudit(r.Context(), audit.Entry{
+		Action:  audit.ActionOperatorRegistered,
+		Actor:   req.ID,
+		Details: map[string]string{"region": req.Region},
+	})
+	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(token)
+}
+
+// operatorHealthRequest reports an operator's health
+type operatorHealthRequest struct {
+	ID        string                 `json:"id"`
+	Timestamp time.Time              `json:"timestamp"`
+	Modules   []modules.ModuleHealth `json:"modules"`
 }
 
 // handleOperatorHealth handles operator health check requests
 func (h *Handler) handleOperatorHealth(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received operator health check from %s", r.RemoteAddr)
+	log.Printf("Received operator health check from %s", h.clientIP(r))
 
 	if r.Method != http.MethodPost {
 		log.Printf("Method not allowed: %s", r.Method)
@@ -288,10 +617,7 @@ func (h *Handler) handleOperatorHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	var req struct {
-		ID        string    `json:"id"`
-		Timestamp time.Time `json:"timestamp"`
-	}
+	var req operatorHealthRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
 		log.Printf("Invalid request body: %v", err)
 		http.Error(w, "Invalid request body", http.StatusBadRequest)
@@ -304,25 +630,21 @@ func (h *Handler) handleOperatorHealth(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Processing health check for operator: %s (timestamp: %s)", req.ID, req.Timestamp)
-
-	// Find MySQL module
-	var mysqlModule modules.Module
-	for _, m := range h.modules {
-		if m.Name() == "mysql" {
-			mysqlModule = m
-			break
-		}
+	if !checkSignedOperator(w, r, req.ID) {
+		return
 	}
 
-	if mysqlModule == nil {
-		log.Printf("MySQL module not found in enabled modules")
-		http.Error(w, "MySQL module not found", http.StatusNotFound)
+	log.Printf("Processing health check for operator: %s (timestamp: %s)", req.ID, req.Timestamp)
+
+	registry := h.operatorRegistry()
+	if registry == nil {
+		log.Printf("No enabled module keeps track of operators")
+		http.Error(w, "No module keeps track of operators", http.StatusNotFound)
 		return
 	}
 
 	// Update operator health
-	if err := mysqlModule.(*mysql.Module).UpdateOperatorHealth(r.Context(), req.ID, req.Timestamp); err != nil {
+	if err := registry.UpdateOperatorHealth(r.Context(), req.ID, req.Timestamp, req.Modules); err != nil {
 		log.Printf("Error updating operator health for %s: %v", req.ID, err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
@@ -332,9 +654,10 @@ func (h *Handler) handleOperatorHealth(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 }
 
-// handleListOperators handles requests to list operators
+// handleListOperators handles requests to list operators, optionally only
+// those with a status or in a region, sorted and paged
 func (h *Handler) handleListOperators(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request to list operators from %s", r.RemoteAddr)
+	log.Printf("Received request to list operators from %s", h.clientIP(r))
 
 	if r.Method != http.MethodGet {
 		log.Printf("Method not allowed: %s", r.Method)
@@ -342,24 +665,31 @@ func (h *Handler) handleListOperators(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// Find MySQL module
-	var mysqlModule modules.Module
-	for _, m := range h.modules {
-		if m.Name() == "mysql" {
-			mysqlModule = m
-			break
-		}
+	registry := h.operatorRegistry()
+	if registry == nil {
+		log.Printf("No enabled module keeps track of operators")
+		http.Error(w, "No module keeps track of operators", http.StatusNotFound)
+		return
 	}
 
-	if mysqlModule == nil {
-		log.Printf("MySQL module not found in enabled modules")
-		http.Error(w, "MySQL module not found", http.StatusNotFound)
+	params := r.URL.Query()
+	filter := store.OperatorFilter{Status: params.Get("status"), Region: params.Get("region")}
+	switch filter.Status {
+	case "", "active", "inactive":
+	default:
+		http.Error(w, "Status must be active or inactive", http.StatusBadRequest)
+		return
+	}
+	page, err := parsePage(params, store.OperatorSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
 		return
 	}
+	filter.Page = page
 
 	// Get list of operators
-	log.Printf("Fetching operators list from MySQL module")
-	operators, err := mysqlModule.(*mysql.Module).ListOperators(r.Context())
+	log.Printf("Fetching operators list from %s module", registry.Name())
+	operators, err := h.store.ListOperators(r.Context(), filter)
 	if err != nil {
 		log.Printf("Error listing operators: %v", err)
 		http.Error(w, err.Error(), http.StatusInternalServerError)
@@ -372,6 +702,7 @@ func (h *Handler) handleListOperators(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Return the operators list
+	setNextLink(w, r, page, len(operators))
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(operators); err != nil {
 		log.Printf("Error encoding operators response: %v", err)
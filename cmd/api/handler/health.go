@@ -0,0 +1,179 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// Health statuses, from best to worst
+const (
+	healthHealthy   = "healthy"
+	healthUnknown   = "unknown"
+	healthDegraded  = "degraded"
+	healthUnhealthy = "unhealthy"
+)
+
+// healthRank orders the statuses a node rolls up from its children
+var healthRank = map[string]int{
+	healthHealthy:   0,
+	healthUnknown:   1,
+	healthDegraded:  2,
+	healthUnhealthy: 3,
+}
+
+// healthCheckInterval is how often operators report their health
+const healthCheckInterval = 30 * time.Second
+
+// healthNode is a component in the health tree with the components it depends on
+type healthNode struct {
+	Name    string `json:"name"`
+	Kind    string `json:"kind"`
+	Status  string `json:"status"`
+	Message string `json:"message,omitempty"`
+	// Critical components make the component depending on them unhealthy
+	// when they are; others only degrade it
+	Critical bool          `json:"critical"`
+	Children []*healthNode `json:"children,omitempty"`
+}
+
+// rollUp sets a node's status to the worst of its own and its children's.
+// An unhealthy child that isn't critical degrades the node; an unknown child
+// doesn't change it.
+func (n *healthNode) rollUp() {
+	for _, child := range n.Children {
+		status := child.Status
+		switch {
+		case status == healthUnknown:
+			continue
+		case status == healthUnhealthy && !child.Critical:
+			status = healthDegraded
+		}
+		if healthRank[status] > healthRank[n.Status] {
+			n.Status = status
+		}
+	}
+}
+
+// handleHealthTree reports the health of the API server as a tree of the
+// components it depends on: its store, its own modules and the operators
+// with their modules
+func (h *Handler) handleHealthTree(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	root := &healthNode{Name: "api", Kind: "api", Status: healthHealthy, Critical: true}
+
+	// Reads are served from the cache while the database is unavailable
+	database := &healthNode{Name: "store", Kind: "store", Status: healthHealthy}
+	if !store.Available(h.store) {
+		database.Status = healthUnhealthy
+		database.Message = "database unavailable; reads are served from the cache"
+	}
+	root.Children = append(root.Children, database)
+
+	if h.resultSub != nil {
+		stats := h.resultSub.Stats()
+		node := &healthNode{Name: stats.Subject, Kind: "bus", Status: healthHealthy}
+		if !stats.Active {
+			node.Status = healthUnhealthy
+			node.Message = "subscription to job results ended"
+		}
+		root.Children = append(root.Children, node)
+	}
+
+	for _, module := range h.modules {
+		node := &healthNode{Name: module.Name(), Kind: "module", Status: healthHealthy}
+		if err := module.HealthCheck(r.Context()); err != nil {
+			node.Status = healthUnhealthy
+			node.Message = err.Error()
+		}
+		root.Children = append(root.Children, node)
+	}
+
+	root.Children = append(root.Children, h.operatorsHealth(r, time.Now()))
+	root.rollUp()
+
+	w.Header().Set("Content-Type", "application/json")
+	if root.Status == healthUnhealthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	json.NewEncoder(w).Encode(root)
+}
+
+// operatorsHealth returns the node of the registered operators. Operators
+// stand in for each other, so the node is unhealthy only when none of them
+// is available and degraded while some aren't.
+func (h *Handler) operatorsHealth(r *http.Request, now time.Time) *healthNode {
+	group := &healthNode{Name: "operators", Kind: "operators", Status: healthHealthy, Critical: true}
+
+	operators, err := h.store.ListOperators(r.Context(), store.OperatorFilter{})
+	if err != nil {
+		group.Status = healthUnknown
+		group.Message = "failed to list operators: " + err.Error()
+		return group
+	}
+	if len(operators) == 0 {
+		group.Status = healthUnknown
+		group.Message = "no operators registered"
+		return group
+	}
+
+	available := 0
+	for _, operator := range operators {
+		node := operatorHealth(operator, now)
+		if node.Status != healthUnhealthy {
+			available++
+		}
+		group.Children = append(group.Children, node)
+	}
+	switch {
+	case available == 0:
+		group.Status = healthUnhealthy
+		group.Message = "no operator is available"
+	case available < len(operators):
+		group.Status = healthDegraded
+	default:
+		for _, child := range group.Children {
+			if child.Status == healthDegraded {
+				group.Status = healthDegraded
+			}
+		}
+	}
+	return group
+}
+
+// operatorHealth returns the node of an operator and its modules. An operator
+// that missed its health checks is unhealthy, and the modules it last
+// reported are unknown.
+func operatorHealth(operator modules.OperatorInfo, now time.Time) *healthNode {
+	node := &healthNode{Name: operator.ID, Kind: "operator", Status: healthHealthy}
+	stale := now.Sub(operator.LastSeen) > 3*healthCheckInterval
+	switch {
+	case operator.Status != "active":
+		node.Status = healthUnhealthy
+		node.Message = "operator is " + operator.Status
+	case stale:
+		node.Status = healthUnhealthy
+		node.Message = "no health check since " + operator.LastSeen.UTC().Format(time.RFC3339)
+	}
+
+	for _, module := range operator.Modules {
+		child := &healthNode{Name: module.Name, Kind: "module", Status: healthHealthy}
+		switch {
+		case operator.Status != "active" || stale:
+			child.Status = healthUnknown
+		case !module.Healthy:
+			child.Status = healthUnhealthy
+			child.Message = module.Error
+		}
+		node.Children = append(node.Children, child)
+	}
+	node.rollUp()
+	return node
+}
@@ -0,0 +1,302 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/redact"
+)
+
+const (
+	// resultQueue is the queue group API servers share, so each result is stored once
+	resultQueue = "apollo-api"
+	// archiveBatchSize is the number of finished jobs archived at a time
+	archiveBatchSize = 500
+)
+
+// StartJobArchival moves jobs that finished more than after ago to the job
+// archive, checking at the given interval until the context is cancelled
+func (h *Handler) StartJobArchival(ctx context.Context, interval, after time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				before := time.Now().UTC().Add(-after)
+				archived := 0
+				for {
+					n, err := h.store.ArchiveJobs(ctx, before, archiveBatchSize)
+					if err != nil {
+						log.Printf("Failed to archive jobs: %v", err)
+						break
+					}
+					archived += n
+					if n < archiveBatchSize {
+						break
+					}
+				}
+				if archived > 0 {
+					log.Printf("Archived %d finished jobs", archived)
+				}
+			}
+		}
+	}()
+}
+
+// SetJobBus publishes new jobs to operators over the bus and stores the results
+// they publish back. Without a bus, operators poll for pending jobs over HTTP.
+func (h *Handler) SetJobBus(b bus.Bus) error {
+	sub, err := b.Subscribe(bus.ResultSubject, bus.SubscribeOptions{Queue: resultQueue}, h.handleJobResult)
+	if err != nil {
+		return err
+	}
+	h.bus, h.resultSub = b, sub
+	return nil
+}
+
+// handleJobResult stores a job result published by an operator
+func (h *Handler) handleJobResult(data []byte) {
+	var result bus.ResultMessage
+	if err := json.Unmarshal(data, &result); err != nil {
+		log.Printf("Ignoring malformed job result: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	// Operators redact their results too; older ones may not
+	if err := h.store.UpdateJob(ctx, result.ID, result.Status, redact.String(result.Result), redact.String(result.Error)); err != nil {
+		log.Printf("Failed to store result of job %s from %s: %v", result.ID, result.OperatorID, err)
+		return
+	}
+	log.Printf("Job %s %s on operator %s", result.ID, result.Status, result.OperatorID)
+}
+
+// dispatchJob stores a job and, with a bus configured, publishes it to the
+// operators of its module. A job that can't be published stays pending for
+// operators polling over HTTP.
+func (h *Handler) dispatchJob(ctx context.Context, job *store.Job) error {
+	if err := h.store.CreateJob(ctx, job); err != nil {
+		return err
+	}
+	return h.publishJob(ctx, job)
+}
+
+// publishJob hands a stored job to the operators over the bus, if one is
+// configured, and wakes the job streams of operators connected over gRPC.
+// Operators polling over HTTP find it among the pending jobs.
+func (h *Handler) publishJob(ctx context.Context, job *store.Job) error {
+	h.jobsDispatched.notify()
+	if h.bus == nil {
+		return nil
+	}
+
+	data, err := json.Marshal(bus.JobMessage{ID: job.ID, Module: job.Module, Type: job.Type, Request: job.Request})
+	if err != nil {
+		return err
+	}
+	if err := h.bus.Publish(ctx, bus.JobSubject(job.Module), data); err != nil {
+		log.Printf("Failed to publish job %s: %v", job.ID, err)
+	}
+	return nil
+}
+
+// handleCreatePingJob creates a job pinging a server through its operator
+func (h *Handler) handleCreatePingJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req pingRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if req.Server == "" {
+		http.Error(w, "Server name is required", http.StatusBadRequest)
+		return
+	}
+	if req.Module == "" {
+		req.Module = "mysql"
+	}
+	if !h.allowModule(w, r, ActionCreateJobs, req.Module) {
+		return
+	}
+
+	request, err := json.Marshal(modules.PingRequest{Server: req.Server})
+	if err != nil {
+		http.Error(w, "Failed to marshal request", http.StatusInternalServerError)
+		return
+	}
+	job := &store.Job{Module: req.Module, Type: "ping", Request: request, Status: store.JobStatusPending}
+	if err := h.dispatchJob(r.Context(), job); err != nil {
+		log.Printf("Failed to create ping job: %v", err)
+		http.Error(w, "Failed to create job", http.StatusInternalServerError)
+		return
+	}
+	// Only operators need the job's request as given, from the pending jobs
+	job.Request = redact.JSON(job.Request)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleGetJob returns the job named by the id query parameter, or lists the
+// active jobs without one
+func (h *Handler) handleGetJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	jobID := r.URL.Query().Get("id")
+	if jobID == "" {
+		h.handleListJobs(w, r)
+		return
+	}
+	job, err := h.store.GetJob(r.Context(), jobID)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(job)
+}
+
+// handleListJobs lists the active jobs to administrators, optionally only
+// those with a status or of a module, sorted and paged
+func (h *Handler) handleListJobs(w http.ResponseWriter, r *http.Request) {
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	params := r.URL.Query()
+	filter := store.JobFilter{Status: params.Get("status"), Module: params.Get("module")}
+	switch filter.Status {
+	case "", store.JobStatusPending, store.JobStatusCompleted, store.JobStatusFailed:
+	default:
+		http.Error(w, "Status must be pending, completed or failed", http.StatusBadRequest)
+		return
+	}
+	page, err := parsePage(params, store.JobSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	filter.Page = page
+
+	jobs, err := h.store.ListJobs(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []*store.Job{}
+	}
+
+	setNextLink(w, r, page, len(jobs))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// handleListPendingJobs returns the pending jobs, optionally for one module
+// and paged, for operators that poll over HTTP
+func (h *Handler) handleListPendingJobs(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	page, err := parsePage(r.URL.Query(), store.JobSortFields)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	jobs, err := h.store.ListJobs(r.Context(), store.JobFilter{
+		Status: store.JobStatusPending,
+		Module: r.URL.Query().Get("module"),
+		Page:   page,
+	})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if jobs == nil {
+		jobs = []*store.Job{}
+	}
+
+	setNextLink(w, r, page, len(jobs))
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(jobs)
+}
+
+// jobUpdate is the result of a job an operator reports
+type jobUpdate struct {
+	Status string `json:"status"`
+	Result string `json:"result"`
+	Error  string `json:"error"`
+}
+
+// handleUpdateJob records the result of a job reported over HTTP
+func (h *Handler) handleUpdateJob(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var update jobUpdate
+	if err := json.NewDecoder(r.Body).Decode(&update); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if update.Status != store.JobStatusCompleted && update.Status != store.JobStatusFailed {
+		http.Error(w, "Status must be completed or failed", http.StatusBadRequest)
+		return
+	}
+
+	err := h.completeJob(r.Context(), operatorActor(r), r.PathValue("id"), update.Status, update.Result, update.Error)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// completeJob stores the result an operator reported for a job and records
+// it in the audit log
+func (h *Handler) completeJob(ctx context.Context, actor, jobID, status, result, errMsg string) error {
+	if err := h.store.UpdateJob(ctx, jobID, status, redact.String(result), redact.String(errMsg)); err != nil {
+		return err
+	}
+	details := map[string]string{"job_id": jobID, "status": status}
+	if errMsg != "" {
+		details["error"] = redact.String(errMsg)
+	}
+	h.recordAudit(ctx, audit.Entry{
+		Action:  audit.ActionJobCompleted,
+		Actor:   actor,
+		Details: details,
+	})
+	return nil
+}
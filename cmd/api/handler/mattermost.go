@@ -0,0 +1,84 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/petermein/apollo/cmd/api/mattermost"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SetMattermostActions configures the approve and deny buttons posted to Mattermost
+func (h *Handler) SetMattermostActions(actions *mattermost.Actions) {
+	h.mattermostActions = actions
+}
+
+// handleMattermostAction approves or denies a request when a Mattermost user
+// clicks a button on the message about it, acting as that user. Mattermost
+// only shows an error page for non-200 answers, so refusals are explained in
+// an ephemeral message instead.
+func (h *Handler) handleMattermostAction(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.mattermostActions == nil {
+		http.Error(w, "Mattermost actions are not configured", http.StatusNotFound)
+		return
+	}
+
+	var click mattermost.ActionRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&click); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	requestID, action, err := h.mattermostActions.Resolve(&click)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+	if click.UserName == "" {
+		writeMattermostResponse(w, mattermost.ActionResponse{EphemeralText: "Apollo could not tell who you are; approve the request with the apollo CLI."})
+		return
+	}
+
+	approver := &models.Approver{ID: click.UserName, Kind: models.ApproverKindUser}
+	var request *models.PrivilegeRequest
+	if action == mattermost.ActionApprove {
+		request, err = h.approveRequest(r.Context(), requestID, approver, models.ApprovalChannelMattermost, nil)
+	} else {
+		request, err = h.denyRequest(r.Context(), requestID, approver)
+	}
+	if err != nil {
+		text := "The request could not be updated: " + err.Error() + "."
+		if ruleErrorStatus(err) == http.StatusInternalServerError {
+			log.Printf("Failed to %s request %s from Mattermost: %v", action, requestID, err)
+			text = "The request could not be updated, try again later."
+		}
+		writeMattermostResponse(w, mattermost.ActionResponse{EphemeralText: text})
+		return
+	}
+
+	if request.Status == store.RequestStatusPending {
+		// Keep the buttons for the other approvers
+		writeMattermostResponse(w, mattermost.ActionResponse{EphemeralText: "Thanks, your approval was recorded. The request needs more approvals."})
+		return
+	}
+	outcome := fmt.Sprintf(":white_check_mark: %s approved %s's %s access to %s %s", click.UserName, request.UserID, request.Level, request.Module, request.ResourceID)
+	if action == mattermost.ActionDeny {
+		outcome = fmt.Sprintf(":no_entry: %s denied %s's %s access to %s %s", click.UserName, request.UserID, request.Level, request.Module, request.ResourceID)
+	}
+	writeMattermostResponse(w, mattermost.ActionResponse{Update: &mattermost.PostUpdate{
+		Message: outcome,
+		Props:   map[string]interface{}{"attachments": []mattermost.Attachment{}},
+	}})
+}
+
+// writeMattermostResponse answers a button click
+func writeMattermostResponse(w http.ResponseWriter, response mattermost.ActionResponse) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
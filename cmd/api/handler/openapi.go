@@ -0,0 +1,331 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/petermein/apollo/cmd/api/mattermost"
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/internal/jsonschema"
+)
+
+// maxValidatedBody is the largest request body validated; larger ones are refused
+const maxValidatedBody = 1 << 20
+
+// operation documents a method of a route
+type operation struct {
+	Method string
+	// Path is the route's pattern as registered
+	Path    string
+	Summary string
+	// Query names the query parameters the operation reads
+	Query []string
+	// Body is a value of the type the handler decodes the JSON body into, or
+	// nil when it takes none
+	Body interface{}
+	// Required names the body's fields that must be set
+	Required []string
+	// OptionalBody allows requests without a body
+	OptionalBody bool
+	// Media is the media type of a body the handler parses itself, which
+	// isn't validated
+	Media string
+}
+
+// pageParams are the query parameters of a paged listing
+var pageParams = []string{"sort", "offset", "limit"}
+
+// operations are the API's operations, as documented and validated
+var operations = []operation{
+	{Method: http.MethodPost, Path: "/api/v1/ping", Summary: "Ping a server through its operator", Body: pingRequest{}, Required: []string{"server"}},
+	{Method: http.MethodGet, Path: "/api/v1/health", Summary: "Report whether the server is healthy"},
+	{Method: http.MethodGet, Path: "/api/v1/health/tree", Summary: "Report the health of the server and every component it depends on"},
+	{Method: http.MethodGet, Path: "/api/v1/features", Summary: "List feature flags"},
+	{Method: http.MethodGet, Path: "/api/v1/openapi.json", Summary: "Describe the API"},
+	{Method: http.MethodGet, Path: "/api/v1/mysql/servers", Summary: "List MySQL servers", Query: append([]string{"status", "region"}, pageParams...)},
+	{Method: http.MethodPost, Path: "/api/v1/mysql/servers/register", Summary: "Register a MySQL server", Body: modules.ServerInfo{}, Required: []string{"name", "host"}},
+	{Method: http.MethodPost, Path: "/api/v1/mysql/servers/inactive", Summary: "Mark a MySQL server inactive", Body: markServerInactiveRequest{}, Required: []string{"name"}},
+	{Method: http.MethodPost, Path: "/api/v1/operators/register", Summary: "Register an operator and issue it a token", Body: registerOperatorRequest{}, Required: []string{"id"}},
+	{Method: http.MethodPost, Path: "/api/v1/operators/health", Summary: "Report an operator's health", Body: operatorHealthRequest{}, Required: []string{"id"}},
+	{Method: http.MethodGet, Path: "/api/v1/operators", Summary: "List operators", Query: append([]string{"status", "region"}, pageParams...)},
+	{Method: http.MethodPost, Path: "/api/v1/operators/token", Summary: "Issue an operator a new token"},
+	{Method: http.MethodDelete, Path: "/api/v1/operators/{id}/tokens", Summary: "Revoke all tokens of an operator"},
+	{Method: http.MethodGet, Path: "/api/v1/jobs", Summary: "Get a job, or list jobs without an ID", Query: append([]string{"id", "status", "module"}, pageParams...)},
+	{Method: http.MethodPost, Path: "/api/v1/jobs/ping", Summary: "Create a job pinging a server", Body: pingRequest{}, Required: []string{"server"}},
+	{Method: http.MethodGet, Path: "/api/v1/jobs/pending", Summary: "List pending jobs", Query: append([]string{"module"}, pageParams...)},
+	{Method: http.MethodPut, Path: "/api/v1/jobs/{id}", Summary: "Report the result of a job", Body: jobUpdate{}, Required: []string{"status"}},
+	{Method: http.MethodPost, Path: "/api/v1/privileges/request", Summary: "Request access to a resource", Body: createPrivilegeRequest{}, Required: []string{"resource_id", "level", "duration"}},
+	{Method: http.MethodGet, Path: "/api/v1/privileges/requests", Summary: "List the caller's privilege requests, or as an administrator another user's or everyone's", Query: []string{"user", "all_users", "status"}},
+	{Method: http.MethodGet, Path: "/api/v1/privileges/{id}", Summary: "Get a privilege request"},
+	{Method: http.MethodPost, Path: "/api/v1/privileges/{id}/approve", Summary: "Approve a privilege request", Body: approvalRequest{}, OptionalBody: true},
+	{Method: http.MethodPost, Path: "/api/v1/privileges/{id}/approve/options", Summary: "Get the hardware key challenge of an approval"},
+	{Method: http.MethodPost, Path: "/api/v1/privileges/{id}/deny", Summary: "Deny a privilege request"},
+	{Method: http.MethodPost, Path: "/api/v1/privileges/{id}/step-up", Summary: "Attach a step-up ID token to a privilege request", Body: stepUpRequest{}, Required: []string{"id_token"}},
+	{Method: http.MethodGet, Path: "/api/v1/privileges/{id}/events", Summary: "List the events of a privilege request"},
+	{Method: http.MethodGet, Path: "/api/v1/approvals/{token}", Summary: "Show the request an approval link decides"},
+	{Method: http.MethodPost, Path: "/api/v1/approvals/{token}", Summary: "Decide a request with an approval link"},
+	{Method: http.MethodPost, Path: "/api/v1/mattermost/actions", Summary: "Handle a Mattermost button click", Body: mattermost.ActionRequest{}},
+	{Method: http.MethodGet, Path: "/api/v1/events/stream", Summary: "Stream grant lifecycle events as server-sent events", Query: []string{"type", "after"}},
+	{Method: http.MethodPost, Path: "/api/v1/events/replay", Summary: "Deliver recorded events again", Body: replayRequest{}, Required: []string{"from"}},
+	{Method: http.MethodGet, Path: "/api/v1/events/deliveries", Summary: "List event deliveries", Query: []string{"event_id", "publisher", "status", "limit"}},
+	{Method: http.MethodGet, Path: "/api/v1/events/dead-letters", Summary: "List events that failed to be delivered"},
+	{Method: http.MethodPost, Path: "/api/v1/events/dead-letters/redeliver", Summary: "Deliver dead letters again", Body: redeliverRequest{}, Required: []string{"ids"}},
+	{Method: http.MethodGet, Path: "/api/v1/events/schemas", Summary: "List the JSON schemas of all event types"},
+	{Method: http.MethodGet, Path: "/api/v1/events/schemas/{type}", Summary: "Get the JSON schema of an event type"},
+	{Method: http.MethodGet, Path: "/api/v1/grants", Summary: "List the caller's active grants, or as an administrator another user's or everyone's", Query: []string{"user", "all_users"}},
+	{Method: http.MethodPost, Path: "/api/v1/grants/{id}/revoke", Summary: "Revoke a grant"},
+	{Method: http.MethodPost, Path: "/api/v1/grants/{id}/extend", Summary: "Request an extension of a grant", Body: extendGrantRequest{}, Required: []string{"duration"}},
+	{Method: http.MethodPost, Path: "/api/v1/grants/{id}/handover", Summary: "Hand a grant's remaining time over to another user", Body: handOverGrantRequest{}, Required: []string{"to"}},
+	{Method: http.MethodPut, Path: "/api/v1/grants/{id}/credentials", Summary: "Store the credentials an operator created for a grant", Body: putCredentialsRequest{}, Required: []string{"credentials"}},
+	{Method: http.MethodGet, Path: "/api/v1/grants/{id}/credentials", Summary: "Get a one-time reference to a grant's credentials"},
+	{Method: http.MethodGet, Path: "/api/v1/grants/{id}/approvals", Summary: "Verify the signed approvals of a grant"},
+	{Method: http.MethodPost, Path: "/api/v1/credentials/rotate", Summary: "Rotate the keys credentials are sealed with"},
+	{Method: http.MethodGet, Path: "/api/v1/credentials/{reference}", Summary: "Redeem a credential reference"},
+	{Method: http.MethodPost, Path: "/api/v1/policies/simulate", Summary: "Evaluate a hypothetical request against the rules", Body: simulatePolicyRequest{}, Required: []string{"resource_id", "level", "duration"}},
+	{Method: http.MethodPost, Path: "/api/v1/policies/test", Summary: "Run a policy test suite against the rules", Media: "application/yaml"},
+	{Method: http.MethodGet, Path: "/api/v1/policies/versions", Summary: "List policy versions"},
+	{Method: http.MethodPost, Path: "/api/v1/policies/versions", Summary: "Store a draft policy version", Query: []string{"comment"}, Media: "application/yaml"},
+	{Method: http.MethodPost, Path: "/api/v1/policies/versions/{version}/shadow", Summary: "Evaluate a policy version alongside the active one"},
+	{Method: http.MethodPost, Path: "/api/v1/policies/versions/{version}/promote", Summary: "Make a policy version the active one"},
+	{Method: http.MethodPost, Path: "/api/v1/retention/run", Summary: "Apply the retention policy now"},
+	{Method: http.MethodGet, Path: "/api/v1/reports", Summary: "List usage reports", Query: []string{"period"}},
+	{Method: http.MethodPost, Path: "/api/v1/reports/run", Summary: "Generate a usage report now", Query: []string{"period"}},
+	{Method: http.MethodGet, Path: "/api/v1/reports/{id}", Summary: "Download a usage report", Query: []string{"format"}},
+	{Method: http.MethodGet, Path: "/api/v1/audit", Summary: "List audit log entries", Query: []string{"user", "action", "resource", "from", "to", "after", "limit"}},
+	{Method: http.MethodGet, Path: "/api/v1/audit/export", Summary: "Export the audit log", Query: []string{"format", "from", "to", "async"}},
+	{Method: http.MethodGet, Path: "/api/v1/audit/exports/{id}", Summary: "Get the status of an audit log export"},
+	{Method: http.MethodGet, Path: "/api/v1/audit/exports/{id}/download", Summary: "Download an audit log export", Query: []string{"expires", "signature"}},
+	{Method: http.MethodGet, Path: "/api/v1/audit/approval-keys", Summary: "List the keys approvals are signed with"},
+	{Method: http.MethodGet, Path: "/api/v1/service-accounts", Summary: "List service accounts"},
+	{Method: http.MethodPost, Path: "/api/v1/service-accounts", Summary: "Create a service account", Body: createServiceAccountRequest{}, Required: []string{"name"}},
+	{Method: http.MethodGet, Path: "/api/v1/service-accounts/{id}", Summary: "Get a service account"},
+	{Method: http.MethodPut, Path: "/api/v1/service-accounts/{id}", Summary: "Replace a service account's scopes", Body: updateServiceAccountRequest{}, Required: []string{"scopes"}},
+	{Method: http.MethodDelete, Path: "/api/v1/service-accounts/{id}", Summary: "Delete a service account"},
+	{Method: http.MethodGet, Path: "/api/v1/service-accounts/{id}/tokens", Summary: "List a service account's tokens"},
+	{Method: http.MethodPost, Path: "/api/v1/service-accounts/{id}/tokens", Summary: "Issue a token to a service account", Body: createServiceTokenRequest{}},
+	{Method: http.MethodDelete, Path: "/api/v1/service-accounts/{id}/tokens/{token}", Summary: "Revoke a service account token"},
+	{Method: http.MethodGet, Path: "/api/v1/groups", Summary: "List synced groups"},
+	{Method: http.MethodPost, Path: "/api/v1/groups/sync", Summary: "Sync groups from the directory now"},
+	{Method: http.MethodGet, Path: "/api/v1/groups/drift", Summary: "List grants whose holders left the groups they were granted through"},
+	{Method: http.MethodGet, Path: "/api/v1/webauthn/credentials", Summary: "List your hardware keys"},
+	{Method: http.MethodPost, Path: "/api/v1/webauthn/credentials", Summary: "Register a hardware key", Body: registerWebAuthnRequest{}, Required: []string{"client_data_json", "attestation_object"}},
+	{Method: http.MethodPost, Path: "/api/v1/webauthn/credentials/options", Summary: "Get the challenge to register a hardware key with"},
+	{Method: http.MethodDelete, Path: "/api/v1/webauthn/credentials/{id}", Summary: "Remove a hardware key"},
+	{Method: http.MethodGet, Path: "/api/v1/sessions", Summary: "List your sessions"},
+	{Method: http.MethodDelete, Path: "/api/v1/sessions", Summary: "Revoke your sessions", Query: []string{"all"}},
+	{Method: http.MethodDelete, Path: "/api/v1/sessions/{id}", Summary: "Revoke a session"},
+	{Method: http.MethodPost, Path: "/api/v1/auth/session", Summary: "Start a cookie session with a bearer token"},
+	{Method: http.MethodDelete, Path: "/api/v1/auth/session", Summary: "End the cookie session"},
+}
+
+// operationsByRoute indexes the operations by method and route pattern
+var operationsByRoute = func() map[string]*operation {
+	byRoute := make(map[string]*operation, len(operations))
+	for i := range operations {
+		byRoute[operations[i].Method+" "+operations[i].Path] = &operations[i]
+	}
+	return byRoute
+}()
+
+// bodySchema returns the JSON schema of an operation's body, requiring the
+// fields it names rather than those encoding/json always writes
+func (op *operation) bodySchema() jsonschema.Schema {
+	schema := jsonschema.For(reflect.TypeOf(op.Body))
+	required := op.Required
+	if required == nil {
+		required = []string{}
+	}
+	schema["required"] = required
+	return schema
+}
+
+// openAPIDocument describes the API in OpenAPI 3.1
+func openAPIDocument() map[string]interface{} {
+	paths := map[string]interface{}{}
+	for i := range operations {
+		op := &operations[i]
+		item, ok := paths[op.Path].(map[string]interface{})
+		if !ok {
+			item = map[string]interface{}{}
+			paths[op.Path] = item
+		}
+
+		var parameters []interface{}
+		for _, segment := range strings.Split(op.Path, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				parameters = append(parameters, map[string]interface{}{
+					"name": strings.Trim(segment, "{}"), "in": "path", "required": true,
+					"schema": map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+		for _, name := range op.Query {
+			paramType := "string"
+			if name == "limit" || name == "offset" {
+				paramType = "integer"
+			}
+			parameters = append(parameters, map[string]interface{}{
+				"name": name, "in": "query",
+				"schema": map[string]interface{}{"type": paramType},
+			})
+		}
+
+		responses := map[string]interface{}{
+			"default": map[string]interface{}{"description": "Success, or an error described in plain text"},
+		}
+		doc := map[string]interface{}{
+			"operationId": operationID(op),
+			"summary":     op.Summary,
+			"responses":   responses,
+		}
+		if len(parameters) > 0 {
+			doc["parameters"] = parameters
+		}
+		switch {
+		case op.Body != nil:
+			doc["requestBody"] = map[string]interface{}{
+				"required": !op.OptionalBody,
+				"content":  map[string]interface{}{"application/json": map[string]interface{}{"schema": op.bodySchema()}},
+			}
+			responses["400"] = map[string]interface{}{"$ref": "#/components/responses/InvalidRequestBody"}
+		case op.Media != "":
+			doc["requestBody"] = map[string]interface{}{
+				"required": true,
+				"content":  map[string]interface{}{op.Media: map[string]interface{}{"schema": map[string]interface{}{"type": "string"}}},
+			}
+		}
+		if isPublicPath(op.Path) {
+			doc["security"] = []interface{}{}
+		}
+		item[strings.ToLower(op.Method)] = doc
+	}
+
+	return map[string]interface{}{
+		"openapi":           "3.1.0",
+		"jsonSchemaDialect": jsonschema.Dialect,
+		"info": map[string]interface{}{
+			"title":       "Apollo API",
+			"version":     "v1",
+			"description": "Requests for temporary privileges, their approval and the operators that grant them",
+		},
+		"paths":    paths,
+		"security": []interface{}{map[string]interface{}{"bearer": []string{}}},
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				"bearer": map[string]interface{}{"type": "http", "scheme": "bearer"},
+			},
+			"schemas": map[string]interface{}{
+				"ValidationError": jsonschema.For(reflect.TypeOf(validationError{})),
+			},
+			"responses": map[string]interface{}{
+				"InvalidRequestBody": map[string]interface{}{
+					"description": "The request body doesn't match the operation's schema",
+					"content": map[string]interface{}{"application/json": map[string]interface{}{
+						"schema": map[string]interface{}{"$ref": "#/components/schemas/ValidationError"},
+					}},
+				},
+			},
+		},
+	}
+}
+
+// operationID names an operation uniquely, like post_api_v1_jobs_ping
+func operationID(op *operation) string {
+	id := strings.ToLower(op.Method) + "_" + strings.Trim(op.Path, "/")
+	return strings.NewReplacer("/", "_", "-", "_", "{", "", "}", "", ".", "_").Replace(id)
+}
+
+// isPublicPath reports whether a route is served without a bearer token
+func isPublicPath(path string) bool {
+	for _, public := range PublicPaths {
+		if path == public || strings.HasSuffix(public, "/") && strings.HasPrefix(path, public) {
+			return true
+		}
+	}
+	return false
+}
+
+// handleOpenAPI serves the OpenAPI document describing the API
+func (h *Handler) handleOpenAPI(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(openAPIDocument())
+}
+
+// validationError is the response to a request body that doesn't match its
+// operation's schema
+type validationError struct {
+	Error   string             `json:"error"`
+	Details []jsonschema.Error `json:"details"`
+}
+
+// ValidateRequests checks the JSON bodies of requests against the schemas
+// of their operations before they reach the handlers, answering those that
+// don't match with a 400 listing every problem. Bodies the API doesn't
+// document, and fields their schemas don't name, pass through.
+func (h *Handler) ValidateRequests(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var pattern string
+		if h.routes != nil {
+			_, pattern = h.routes.Handler(r)
+		}
+		op, ok := operationsByRoute[r.Method+" "+pattern]
+		if !ok || op.Body == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, maxValidatedBody))
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			if errors.As(err, &tooLarge) {
+				http.Error(w, "Request body too large", http.StatusRequestEntityTooLarge)
+				return
+			}
+			http.Error(w, "Failed to read request body", http.StatusBadRequest)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(data))
+		if len(bytes.TrimSpace(data)) == 0 && op.OptionalBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if details := validateBody(op, data); len(details) > 0 {
+			w.Header().Set("Content-Type", "application/json")
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(validationError{Error: "Invalid request body", Details: details})
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// validateBody returns every way a request body doesn't match the schema of
+// its operation
+func validateBody(op *operation, data []byte) []jsonschema.Error {
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.UseNumber()
+	var body interface{}
+	if err := decoder.Decode(&body); err != nil {
+		if errors.Is(err, io.EOF) {
+			return []jsonschema.Error{{Message: "a JSON body is required"}}
+		}
+		return []jsonschema.Error{{Message: "body is not valid JSON: " + err.Error()}}
+	}
+	if decoder.More() {
+		return []jsonschema.Error{{Message: "body must hold a single JSON value"}}
+	}
+	if body == nil {
+		return []jsonschema.Error{{Message: "body must be an object, not null"}}
+	}
+	return jsonschema.Validate(op.bodySchema(), body)
+}
@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/auth"
+)
+
+// operatorKey is the context key of the operator that signed a request
+type operatorKey struct{}
+
+// SetOperatorSigning checks requests signed with the operators' shared
+// secrets. With required set, the endpoints operators call reject requests
+// that are neither signed nor carry an operator token.
+func (h *Handler) SetOperatorSigning(verifier *auth.HMACVerifier, required bool) {
+	h.operatorSigning = verifier
+	h.operatorSigningRequired = required
+}
+
+// operatorUser is the user ID a signed operator request acts as
+func operatorUser(operatorID string) string {
+	return "operator:" + operatorID
+}
+
+// AuthenticateOperators verifies operator tokens and signed requests before
+// they reach the handlers, rejecting bad tokens, bad signatures and replays.
+// An authenticated request acts as operator:<id> and reaches only the
+// endpoints operators call; handlers compare the operator named in the body
+// with the one that authenticated.
+func (h *Handler) AuthenticateOperators(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		operatorID, err := h.authenticateOperator(r)
+		if err != nil {
+			log.Printf("Rejected operator request for %s %s from %s: %v", r.Method, r.URL.Path, h.clientIP(r), err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="apollo", error="invalid_token"`)
+			http.Error(w, "Invalid operator token or signature", http.StatusUnauthorized)
+			return
+		}
+		if operatorID == "" {
+			if h.requiresOperatorAuth(r) {
+				http.Error(w, "Operator requests must carry an operator token or signature", http.StatusUnauthorized)
+				return
+			}
+			// Only a token or signature makes a request act as an operator
+			if strings.HasPrefix(r.Header.Get(auth.UserHeader), "operator:") {
+				r.Header.Del(auth.UserHeader)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+		if !h.isOperatorRoute(r) {
+			http.Error(w, "Operators can't use this endpoint", http.StatusForbidden)
+			return
+		}
+
+		principal := &auth.Principal{ID: operatorUser(operatorID), Subject: operatorID, Issuer: "apollo"}
+		ctx := context.WithValue(auth.WithPrincipal(r.Context(), principal), operatorKey{}, operatorID)
+		r = r.WithContext(ctx)
+		r.Header.Set(auth.UserHeader, principal.ID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateOperator returns the operator whose token the request carries
+// or that signed it, or an empty ID for requests with neither
+func (h *Handler) authenticateOperator(r *http.Request) (string, error) {
+	scheme, rawToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+	if strings.EqualFold(scheme, "Bearer") && strings.HasPrefix(rawToken, operatorTokenPrefix) {
+		return h.authenticateOperatorToken(r.Context(), rawToken)
+	}
+	if h.operatorSigning == nil {
+		return "", nil
+	}
+	operatorID, err := h.operatorSigning.Verify(r, time.Now())
+	if errors.Is(err, auth.ErrUnsigned) {
+		return "", nil
+	}
+	return operatorID, err
+}
+
+// requiresOperatorAuth reports whether the request must carry an operator
+// token or signature. Registration hands out the first token, so only
+// required signing covers it.
+func (h *Handler) requiresOperatorAuth(r *http.Request) bool {
+	if !h.isOperatorRoute(r) {
+		return false
+	}
+	if h.operatorSigningRequired {
+		return true
+	}
+	return h.operatorTokensRequired && r.URL.Path != "/api/v1/operators/register"
+}
+
+// isOperatorRoute reports whether the request is for an endpoint operators call
+func (h *Handler) isOperatorRoute(r *http.Request) bool {
+	var pattern string
+	if h.routes != nil {
+		_, pattern = h.routes.Handler(r)
+	}
+	if pattern == "/api/v1/grants/{id}/credentials" {
+		return r.Method == http.MethodPut
+	}
+	return routeActions[pattern] == ActionOperate
+}
+
+// checkSignedOperator rejects requests signed by another operator than the
+// one they speak for. Unsigned requests pass when signing isn't required.
+func checkSignedOperator(w http.ResponseWriter, r *http.Request, operatorID string) bool {
+	signer, ok := r.Context().Value(operatorKey{}).(string)
+	if ok && signer != operatorID {
+		log.Printf("Operator %s tried to act as operator %s", signer, operatorID)
+		http.Error(w, "Request is signed by another operator", http.StatusForbidden)
+		return false
+	}
+	return true
+}
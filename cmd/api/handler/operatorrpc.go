@@ -0,0 +1,226 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/operatorrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// jobStreamBatch is the most pending jobs of a module a stream reads at once
+const jobStreamBatch = 500
+
+// jobNotifier wakes job streams when a job is dispatched
+type jobNotifier struct {
+	mu sync.Mutex
+	ch chan struct{}
+}
+
+// wait returns a channel closed at the next notify
+func (n *jobNotifier) wait() <-chan struct{} {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch == nil {
+		n.ch = make(chan struct{})
+	}
+	return n.ch
+}
+
+// notify wakes everything waiting
+func (n *jobNotifier) notify() {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if n.ch != nil {
+		close(n.ch)
+		n.ch = nil
+	}
+}
+
+// NewOperatorRPCServer returns a gRPC server of the operator service.
+// Operators authenticate every call with an operator token and may only act
+// as themselves. Job streams wake when this server dispatches a job; with a
+// poll interval they also check the store that often for jobs dispatched by
+// other API servers sharing it.
+func (h *Handler) NewOperatorRPCServer(pollInterval time.Duration) *grpc.Server {
+	s := grpc.NewServer(
+		grpc.UnaryInterceptor(func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := h.authenticateOperatorRPC(ctx, info.FullMethod)
+			if err != nil {
+				return nil, err
+			}
+			return handler(ctx, req)
+		}),
+		grpc.StreamInterceptor(func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+			ctx, err := h.authenticateOperatorRPC(ss.Context(), info.FullMethod)
+			if err != nil {
+				return err
+			}
+			return handler(srv, &operatorStream{ServerStream: ss, ctx: ctx})
+		}),
+	)
+	operatorrpc.RegisterOperatorServer(s, &operatorService{h: h, pollInterval: pollInterval})
+	return s
+}
+
+// operatorStream is a server stream carrying the authenticated operator
+type operatorStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *operatorStream) Context() context.Context {
+	return s.ctx
+}
+
+// authenticateOperatorRPC checks the operator token of a call and returns a
+// context acting as its operator
+func (h *Handler) authenticateOperatorRPC(ctx context.Context, method string) (context.Context, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	var header string
+	if values := md.Get(operatorrpc.Authorization); len(values) > 0 {
+		header = values[0]
+	}
+	scheme, rawToken, _ := strings.Cut(header, " ")
+	if !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(rawToken, operatorTokenPrefix) {
+		return nil, status.Error(codes.Unauthenticated, "an operator token is required")
+	}
+	operatorID, err := h.authenticateOperatorToken(ctx, rawToken)
+	if err != nil {
+		log.Printf("Rejected operator call to %s: %v", method, err)
+		return nil, status.Error(codes.Unauthenticated, "invalid operator token")
+	}
+	principal := &auth.Principal{ID: operatorUser(operatorID), Subject: operatorID, Issuer: "apollo"}
+	return context.WithValue(auth.WithPrincipal(ctx, principal), operatorKey{}, operatorID), nil
+}
+
+// checkOperatorRPC rejects calls naming an operator other than the one that
+// authenticated
+func checkOperatorRPC(ctx context.Context, operatorID string) error {
+	if operatorID == "" {
+		return status.Error(codes.InvalidArgument, "operator ID is required")
+	}
+	if authenticated, _ := ctx.Value(operatorKey{}).(string); authenticated != operatorID {
+		return status.Errorf(codes.PermissionDenied, "authenticated as operator %s, not %s", authenticated, operatorID)
+	}
+	return nil
+}
+
+// operatorService serves operators over gRPC
+type operatorService struct {
+	operatorrpc.UnimplementedOperatorServer
+	h            *Handler
+	pollInterval time.Duration
+}
+
+// Jobs streams the pending jobs of the operator's modules, then every job
+// dispatched to them. Jobs dispatched by this server are sent right away;
+// those of other API servers sharing the store at the next poll.
+func (s *operatorService) Jobs(req *operatorrpc.JobsRequest, stream operatorrpc.Operator_JobsServer) error {
+	ctx := stream.Context()
+	if err := checkOperatorRPC(ctx, req.OperatorId); err != nil {
+		return err
+	}
+	if len(req.Modules) == 0 {
+		return status.Error(codes.InvalidArgument, "at least one module is required")
+	}
+	log.Printf("Operator %s is streaming jobs of %s", req.OperatorId, strings.Join(req.Modules, ", "))
+	defer log.Printf("Operator %s stopped streaming jobs", req.OperatorId)
+
+	// sent holds the pending jobs already sent, so each is sent once
+	sent := map[string]bool{}
+	// Without polling, the nil channel never fires
+	var poll <-chan time.Time
+	if s.pollInterval > 0 {
+		ticker := time.NewTicker(s.pollInterval)
+		defer ticker.Stop()
+		poll = ticker.C
+	}
+	for {
+		wake := s.h.jobsDispatched.wait()
+		pending := map[string]bool{}
+		for _, module := range req.Modules {
+			jobs, err := s.h.store.ListJobs(ctx, store.JobFilter{
+				Status: store.JobStatusPending,
+				Module: module,
+				Page:   store.Page{Limit: jobStreamBatch},
+			})
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				log.Printf("Failed to list jobs for operator %s: %v", req.OperatorId, err)
+				return status.Error(codes.Unavailable, "failed to list jobs")
+			}
+			for _, job := range jobs {
+				pending[job.ID] = true
+				if sent[job.ID] {
+					continue
+				}
+				if err := stream.Send(&operatorrpc.Job{Id: job.ID, Module: job.Module, Type: job.Type, Request: job.Request}); err != nil {
+					return err
+				}
+			}
+		}
+		sent = pending
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-wake:
+		case <-poll:
+		}
+	}
+}
+
+// Heartbeat records the operator's health, like the HTTP health check
+func (s *operatorService) Heartbeat(ctx context.Context, req *operatorrpc.HeartbeatRequest) (*operatorrpc.Ack, error) {
+	if err := checkOperatorRPC(ctx, req.OperatorId); err != nil {
+		return nil, err
+	}
+	if req.Timestamp == nil {
+		return nil, status.Error(codes.InvalidArgument, "timestamp is required")
+	}
+	registry := s.h.operatorRegistry()
+	if registry == nil {
+		return nil, status.Error(codes.FailedPrecondition, "no module keeps track of operators")
+	}
+	health := make([]modules.ModuleHealth, 0, len(req.Modules))
+	for _, m := range req.Modules {
+		health = append(health, modules.ModuleHealth{Name: m.Name, Healthy: m.Healthy, Error: m.Error})
+	}
+	if err := registry.UpdateOperatorHealth(ctx, req.OperatorId, req.Timestamp.AsTime(), health); err != nil {
+		log.Printf("Error updating operator health for %s: %v", req.OperatorId, err)
+		return nil, status.Error(codes.Internal, "failed to update operator health")
+	}
+	return &operatorrpc.Ack{}, nil
+}
+
+// ReportResult records the result of a job, like a job update over HTTP
+func (s *operatorService) ReportResult(ctx context.Context, req *operatorrpc.Result) (*operatorrpc.Ack, error) {
+	if err := checkOperatorRPC(ctx, req.OperatorId); err != nil {
+		return nil, err
+	}
+	if req.Status != store.JobStatusCompleted && req.Status != store.JobStatusFailed {
+		return nil, status.Error(codes.InvalidArgument, "status must be completed or failed")
+	}
+	err := s.h.completeJob(ctx, operatorUser(req.OperatorId), req.Id, req.Status, req.Result, req.Error)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, status.Errorf(codes.NotFound, "job %s not found", req.Id)
+	}
+	if err != nil {
+		log.Printf("Failed to store result of job %s from %s: %v", req.Id, req.OperatorId, err)
+		return nil, status.Error(codes.Internal, "failed to store job result")
+	}
+	return &operatorrpc.Ack{}, nil
+}
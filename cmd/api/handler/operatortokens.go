@@ -0,0 +1,152 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// DefaultOperatorTokenTTL is how long an operator token is valid when no
+// lifetime is configured
+const DefaultOperatorTokenTTL = 15 * time.Minute
+
+// operatorTokenPrefix starts every operator token, so they are told apart
+// from JWTs and service account tokens
+const operatorTokenPrefix = "apollo_op."
+
+// operatorTokenRetention is how long expired operator tokens are kept before
+// the cleanup worker deletes them
+const operatorTokenRetention = time.Hour
+
+// operatorTokenResponse hands an operator a new token
+type operatorTokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// SetOperatorTokens sets how long the tokens issued to operators are valid.
+// With required set, the endpoints operators call, except registration,
+// reject requests that carry neither an operator token nor a signature.
+func (h *Handler) SetOperatorTokens(ttl time.Duration, required bool) {
+	if ttl <= 0 {
+		ttl = DefaultOperatorTokenTTL
+	}
+	h.operatorTokenTTL = ttl
+	h.operatorTokensRequired = required
+}
+
+// issueOperatorToken creates a token for the operator
+func (h *Handler) issueOperatorToken(ctx context.Context, operatorID string) (*operatorTokenResponse, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, fmt.Errorf("failed to generate token: %v", err)
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(encoded))
+	ttl := h.operatorTokenTTL
+	if ttl <= 0 {
+		ttl = DefaultOperatorTokenTTL
+	}
+	token := &store.OperatorToken{
+		OperatorID: operatorID,
+		Hash:       hash[:],
+		ExpiresAt:  time.Now().Add(ttl).UTC().Truncate(time.Second),
+	}
+	if err := h.store.CreateOperatorToken(ctx, token); err != nil {
+		return nil, err
+	}
+	return &operatorTokenResponse{Token: operatorTokenPrefix + token.ID + "." + encoded, ExpiresAt: token.ExpiresAt}, nil
+}
+
+// authenticateOperatorToken returns the operator of a valid, active token
+func (h *Handler) authenticateOperatorToken(ctx context.Context, rawToken string) (string, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(rawToken, operatorTokenPrefix), ".")
+	if !ok || id == "" || secret == "" {
+		return "", fmt.Errorf("malformed operator token")
+	}
+	token, err := h.store.GetOperatorToken(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return "", fmt.Errorf("unknown operator token %s", id)
+	}
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], token.Hash) != 1 {
+		return "", fmt.Errorf("operator token %s does not match", id)
+	}
+	if !token.Active(time.Now()) {
+		return "", fmt.Errorf("operator token %s is expired or revoked", id)
+	}
+	return token.OperatorID, nil
+}
+
+// handleRefreshOperatorToken hands an authenticated operator a new token. The
+// token it used stays valid until it expires.
+func (h *Handler) handleRefreshOperatorToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	operatorID, ok := r.Context().Value(operatorKey{}).(string)
+	if !ok {
+		http.Error(w, "An operator token or signature is required", http.StatusUnauthorized)
+		return
+	}
+
+	token, err := h.issueOperatorToken(r.Context(), operatorID)
+	if err != nil {
+		log.Printf("Failed to refresh token of operator %s: %v", operatorID, err)
+		http.Error(w, "Failed to issue token", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(token)
+}
+
+// handleRevokeOperatorTokens revokes all active tokens of an operator at
+// once, cutting it off until it registers again
+func (h *Handler) handleRevokeOperatorTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	operatorID := r.PathValue("id")
+	revoked, err := h.store.RevokeOperatorTokens(r.Context(), operatorID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Revoked %d tokens of operator %s by %s", revoked, operatorID, userID)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+}
+
+// pruneOperatorTokens deletes operator tokens that expired a while ago
+func (h *Handler) pruneOperatorTokens(ctx context.Context, now time.Time) {
+	pruned, err := h.store.PruneOperatorTokens(ctx, now.Add(-operatorTokenRetention))
+	if err != nil {
+		log.Printf("Failed to prune operator tokens: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d expired operator tokens", pruned)
+	}
+}
@@ -0,0 +1,50 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// maxListLimit is the largest number of items a listing returns at once
+const maxListLimit = 1000
+
+// parsePage reads the limit, offset and sort parameters of a listing sorted
+// by one of the given fields. Without a limit everything is listed, as
+// clients written before listings were paged expect.
+func parsePage(params url.Values, fields []string) (store.Page, error) {
+	page := store.Page{Sort: params.Get("sort")}
+	if value := params.Get("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxListLimit {
+			return page, fmt.Errorf("limit must be between 1 and %d", maxListLimit)
+		}
+		page.Limit = limit
+	}
+	if value := params.Get("offset"); value != "" {
+		offset, err := strconv.Atoi(value)
+		if err != nil || offset < 0 {
+			return page, fmt.Errorf("offset must be a number of at least 0")
+		}
+		page.Offset = offset
+	}
+	if err := page.Validate(fields); err != nil {
+		return page, err
+	}
+	return page, nil
+}
+
+// setNextLink points clients at the next page of a listing in a Link header
+// when the page listed is full
+func setNextLink(w http.ResponseWriter, r *http.Request, page store.Page, listed int) {
+	if page.Limit == 0 || listed < page.Limit {
+		return
+	}
+	params := r.URL.Query()
+	params.Set("offset", strconv.Itoa(page.Offset+page.Limit))
+	next := url.URL{Path: r.URL.Path, RawQuery: params.Encode()}
+	w.Header().Set("Link", fmt.Sprintf("<%s>; rel=\"next\"", next.String()))
+}
@@ -0,0 +1,236 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+	"github.com/petermein/apollo/internal/rules"
+)
+
+// SetRuleEngine configures the rule engine used to evaluate privilege requests.
+// Engines that classify resources by tag read the tags of registered servers, and
+// engines that enforce rate limits read the handler's request history.
+func (h *Handler) SetRuleEngine(engine rules.RuleEngine) {
+	if e, ok := engine.(interface {
+		SetResourceCatalog(rules.ResourceCatalog)
+	}); ok {
+		e.SetResourceCatalog(moduleCatalog{modules: h.modules})
+	}
+	if e, ok := engine.(interface {
+		SetRequestHistory(rules.RequestHistory)
+	}); ok {
+		e.SetRequestHistory(h.store)
+	}
+	if e, ok := engine.(interface {
+		SetGroupDirectory(rules.GroupDirectory)
+	}); ok {
+		e.SetGroupDirectory(h.groups)
+	}
+	h.ruleEngine = engine
+}
+
+// simulatePolicyRequest describes a request to evaluate the rules against
+type simulatePolicyRequest struct {
+	UserID      string                `json:"user_id"`
+	Module      string                `json:"module"`
+	ResourceID  string                `json:"resource_id"`
+	Level       models.PrivilegeLevel `json:"level"`
+	Reason      string                `json:"reason"`
+	Duration    string                `json:"duration"`
+	SourceIP    string                `json:"source_ip"`
+	RequestedAt time.Time             `json:"requested_at"`
+}
+
+// handleSimulatePolicy evaluates a hypothetical privilege request and returns the
+// decision trace without creating anything
+func (h *Handler) handleSimulatePolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req simulatePolicyRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	if req.ResourceID == "" || req.Level == "" {
+		http.Error(w, "Resource ID and level are required", http.StatusBadRequest)
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	simulator, ok := h.ruleEngine.(rules.Simulator)
+	if !ok {
+		http.Error(w, "Policy simulation is not supported by the configured rule engine", http.StatusNotImplemented)
+		return
+	}
+
+	// Admins may simulate on behalf of another user or address; default to the caller
+	if req.UserID == "" {
+		req.UserID = r.Header.Get("X-Apollo-User")
+	}
+	if req.SourceIP == "" {
+		req.SourceIP = h.clientIP(r)
+	}
+	if req.RequestedAt.IsZero() {
+		req.RequestedAt = time.Now().UTC()
+	}
+
+	decision, err := simulator.Simulate(&models.PrivilegeRequest{
+		UserID:      req.UserID,
+		Module:      req.Module,
+		ResourceID:  req.ResourceID,
+		Level:       req.Level,
+		Reason:      req.Reason,
+		SourceIP:    req.SourceIP,
+		RequestedAt: req.RequestedAt,
+		ExpiresAt:   req.RequestedAt.Add(duration),
+	})
+	if err != nil {
+		log.Printf("Error simulating policy decision: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(decision)
+}
+
+// handleTestPolicy runs a suite of declarative policy test cases against the
+// rules currently in effect
+func (h *Handler) handleTestPolicy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	simulator, ok := h.ruleEngine.(rules.Simulator)
+	if !ok {
+		http.Error(w, "Policy tests are not supported by the configured rule engine", http.StatusNotImplemented)
+		return
+	}
+
+	data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+	if err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	suite, err := rules.ParseTestSuite(data)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	report, err := rules.RunTests(simulator, suite)
+	if err != nil {
+		log.Printf("Error running policy tests: %v", err)
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Policy tests: %d passed, %d failed", report.Passed, report.Failed)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
+
+// SetPolicyVersions enables the policy version endpoints. The reloader is
+// triggered after a version is shadowed or promoted so the change applies immediately.
+func (h *Handler) SetPolicyVersions(store *rules.VersionStore, reloader *rules.Reloader) {
+	h.policyVersions = store
+	h.policyReloader = reloader
+}
+
+// handlePolicyVersions lists policy versions or stores a new draft version
+func (h *Handler) handlePolicyVersions(w http.ResponseWriter, r *http.Request) {
+	if h.policyVersions == nil {
+		http.Error(w, "Policy versioning is not enabled", http.StatusNotFound)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		versions, err := h.policyVersions.List(r.Context())
+		if err != nil {
+			log.Printf("Error listing policy versions: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(versions)
+
+	case http.MethodPost:
+		data, err := io.ReadAll(io.LimitReader(r.Body, 1<<20))
+		if err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		version, err := h.policyVersions.Create(r.Context(), data, r.Header.Get("X-Apollo-User"), r.URL.Query().Get("comment"))
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusUnprocessableEntity)
+			return
+		}
+		log.Printf("Stored policy version %d", version.Version)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(version)
+
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// handleShadowPolicyVersion puts a policy version in shadow mode
+func (h *Handler) handleShadowPolicyVersion(w http.ResponseWriter, r *http.Request) {
+	h.setPolicyVersionStatus(w, r, rules.VersionStatusShadow)
+}
+
+// handlePromotePolicyVersion makes a policy version the active one
+func (h *Handler) handlePromotePolicyVersion(w http.ResponseWriter, r *http.Request) {
+	h.setPolicyVersionStatus(w, r, rules.VersionStatusActive)
+}
+
+func (h *Handler) setPolicyVersionStatus(w http.ResponseWriter, r *http.Request, status string) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.policyVersions == nil {
+		http.Error(w, "Policy versioning is not enabled", http.StatusNotFound)
+		return
+	}
+
+	number, err := strconv.Atoi(r.PathValue("version"))
+	if err != nil {
+		http.Error(w, "Invalid policy version", http.StatusBadRequest)
+		return
+	}
+
+	version, err := h.policyVersions.SetStatus(r.Context(), number, status)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+	log.Printf("Policy version %d is now %s", version.Version, version.Status)
+
+	if h.policyReloader != nil {
+		if err := h.policyReloader.Reload(r.Context()); err != nil {
+			log.Printf("Error reloading rules: %v", err)
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(version)
+}
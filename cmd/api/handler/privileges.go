@@ -0,0 +1,478 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/attest"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/core/models"
+	"github.com/petermein/apollo/internal/redact"
+	"github.com/petermein/apollo/internal/rules"
+)
+
+// conflictError marks a request that cannot change in its current state
+type conflictError struct {
+	message string
+}
+
+func (e *conflictError) Error() string {
+	return e.message
+}
+
+// errRequestLapsed is returned when deciding a request that waited past its
+// deadline; the cleanup worker expires it shortly
+var errRequestLapsed = &conflictError{"request expired without a decision"}
+
+// createPrivilegeRequest asks for access to a resource
+type createPrivilegeRequest struct {
+	Module     string                `json:"module"`
+	ResourceID string                `json:"resource_id"`
+	Level      models.PrivilegeLevel `json:"level"`
+	Reason     string                `json:"reason"`
+	Duration   string                `json:"duration"`
+}
+
+// handleCreatePrivilegeRequest evaluates a privilege request against the rules and
+// stores it. Requests that need no approvals are approved immediately.
+func (h *Handler) handleCreatePrivilegeRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req createPrivilegeRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	if req.ResourceID == "" || req.Level == "" {
+		http.Error(w, "Resource ID and level are required", http.StatusBadRequest)
+		return
+	}
+	if !h.allowModule(w, r, ActionRequestPrivileges, req.Module) {
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil {
+		http.Error(w, "Invalid duration", http.StatusBadRequest)
+		return
+	}
+
+	now := time.Now().UTC()
+	request, err := h.RequestPrivilege(r.Context(), &models.PrivilegeRequest{
+		UserID:      userID,
+		Module:      req.Module,
+		ResourceID:  req.ResourceID,
+		Level:       req.Level,
+		Reason:      req.Reason,
+		SourceIP:    h.clientIP(r),
+		RequestedAt: now,
+		ExpiresAt:   now.Add(duration),
+	})
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(request)
+}
+
+// handleListPrivilegeRequests lists the caller's privilege requests, optionally
+// filtered by status; administrators list another user's or everyone's
+func (h *Handler) handleListPrivilegeRequests(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	requester, ok := h.listedUser(w, r)
+	if !ok {
+		return
+	}
+
+	requests, err := h.store.ListRequests(r.Context(), store.RequestFilter{
+		UserID: requester,
+		Status: r.URL.Query().Get("status"),
+	})
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	if r.URL.Query().Get("status") == store.RequestStatusPending {
+		requests = h.waiting(requests, time.Now())
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(requests)
+}
+
+// handleGetPrivilegeRequest returns a single privilege request to its
+// requester and administrators
+func (h *Handler) handleGetPrivilegeRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, err := h.store.GetRequest(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	if !h.requireOwnerOrAdmin(w, r, request.UserID) {
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(request)
+}
+
+// approvalRequest is the optional body of an approval
+type approvalRequest struct {
+	WebAuthn *webAuthnResponse `json:"webauthn"`
+}
+
+// handleApprovePrivilegeRequest records an approval after checking it against the
+// rules; the request is approved once it has all the approvals it requires
+func (h *Handler) handleApprovePrivilegeRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	approver := &models.Approver{ID: r.Header.Get("X-Apollo-User"), Kind: models.ApproverKindUser}
+	if approver.ID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+
+	// The body is optional; it carries the hardware key assertion when the
+	// request needs one
+	var req approvalRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil && !errors.Is(err, io.EOF) {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+
+	request, err := h.approveRequest(r.Context(), r.PathValue("id"), approver, models.ApprovalChannelAPI, req.WebAuthn)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(request)
+}
+
+// approveRequest records an approval of a pending request, approving it once
+// it has the approvals it needs
+func (h *Handler) approveRequest(ctx context.Context, id string, approver *models.Approver, channel string, response *webAuthnResponse) (*models.PrivilegeRequest, error) {
+	request, err := h.store.GetRequest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ruleEngine.ValidateApproval(request, approver); err != nil {
+		log.Printf("Approval of privilege request %s by %s rejected: %v", request.ID, approver.ID, err)
+		return nil, err
+	}
+	var assertion *models.WebAuthnAssertion
+	if request.WebAuthnApproval {
+		if assertion, err = h.verifyApprovalAssertion(ctx, request, approver, response); err != nil {
+			log.Printf("Approval of privilege request %s by %s rejected: %v", request.ID, approver.ID, err)
+			return nil, err
+		}
+	}
+
+	request, err = h.store.UpdateRequest(ctx, request.ID, func(request *models.PrivilegeRequest, approvals []string) ([]string, error) {
+		if request.Status != store.RequestStatusPending {
+			return nil, &conflictError{fmt.Sprintf("request is %s", request.Status)}
+		}
+		if h.lapsed(request, time.Now()) {
+			return nil, errRequestLapsed
+		}
+		if !request.StepUp.Satisfied() {
+			return nil, &conflictError{"request is awaiting step-up authentication by the requester"}
+		}
+		if slices.Contains(approvals, approver.ID) {
+			return nil, &conflictError{fmt.Sprintf("%s already approved this request", approver.ID)}
+		}
+		// Another approval may have completed the step since it was validated
+		if step := request.CurrentStep(); step != nil && !slices.Contains(step.Approvers, approver.ID) {
+			return nil, &conflictError{fmt.Sprintf("request is awaiting approval of the %s step", step.Name)}
+		}
+
+		h.recordApproval(ctx, request, approver, channel, assertion)
+		approvals = append(approvals, approver.ID)
+		if len(approvals) >= request.RequiredApprovals && request.CurrentStep() == nil {
+			approve(request, approver.ID)
+		}
+		return approvals, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Privilege request %s approved by %s; status %s", request.ID, approver.ID, request.Status)
+	details := map[string]string{"channel": channel, "status": request.Status}
+	if last := request.Approvals[len(request.Approvals)-1]; last.Step != "" {
+		details["step"] = last.Step
+	}
+	h.auditRequest(ctx, audit.ActionRequestApproved, approver.ID, request, details)
+	h.logGrant(ctx, request)
+	return request, nil
+}
+
+// handleDenyPrivilegeRequest denies a pending privilege request
+func (h *Handler) handleDenyPrivilegeRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	approver := &models.Approver{ID: r.Header.Get("X-Apollo-User"), Kind: models.ApproverKindUser}
+	if approver.ID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+
+	request, err := h.denyRequest(r.Context(), r.PathValue("id"), approver)
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(request)
+}
+
+// denyRequest denies a pending request
+func (h *Handler) denyRequest(ctx context.Context, id string, approver *models.Approver) (*models.PrivilegeRequest, error) {
+	request, err := h.store.GetRequest(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := h.ruleEngine.ValidateApproval(request, approver); err != nil {
+		return nil, err
+	}
+
+	request, err = h.store.UpdateRequest(ctx, request.ID, func(request *models.PrivilegeRequest, approvals []string) ([]string, error) {
+		if request.Status != store.RequestStatusPending {
+			return nil, &conflictError{fmt.Sprintf("request is %s", request.Status)}
+		}
+		if h.lapsed(request, time.Now()) {
+			return nil, errRequestLapsed
+		}
+		request.Status = store.RequestStatusDenied
+		request.DeniedBy = approver.ID
+		return approvals, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Privilege request %s denied by %s", request.ID, approver.ID)
+	h.auditRequest(ctx, audit.ActionRequestDenied, approver.ID, request, nil)
+	return request, nil
+}
+
+// stepUpRequest carries a fresh ID token proving the requester authenticated again
+type stepUpRequest struct {
+	IDToken string `json:"id_token"`
+}
+
+// handlePrivilegeStepUp attaches a step-up ID token to a request; requests that
+// need no approvals are approved once the step-up is verified
+func (h *Handler) handlePrivilegeStepUp(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var req stepUpRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil || req.IDToken == "" {
+		http.Error(w, "ID token is required", http.StatusBadRequest)
+		return
+	}
+
+	request, err := h.store.GetRequest(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	if request.StepUp == nil {
+		http.Error(w, "Request does not require step-up authentication", http.StatusConflict)
+		return
+	}
+
+	stepUp := *request.StepUp
+	request.StepUp = &stepUp
+	if err := h.verifyStepUp(r.Context(), request, req.IDToken); err != nil {
+		log.Printf("Step-up for privilege request %s failed: %v", request.ID, err)
+		writeStepUpChallenge(w, request.StepUp, err.Error())
+		return
+	}
+
+	request, err = h.store.UpdateRequest(r.Context(), request.ID, func(stored *models.PrivilegeRequest, approvals []string) ([]string, error) {
+		if stored.Status != store.RequestStatusPending {
+			return nil, &conflictError{fmt.Sprintf("request is %s", stored.Status)}
+		}
+		if h.lapsed(stored, time.Now()) {
+			return nil, errRequestLapsed
+		}
+		stored.StepUp.VerifiedAt = stepUp.VerifiedAt
+		if len(approvals) >= stored.RequiredApprovals && stored.CurrentStep() == nil {
+			if stored.RequiredApprovals == 0 {
+				h.recordApproval(r.Context(), stored, policyApprover, models.ApprovalChannelPolicy, nil)
+			}
+			approve(stored, "policy")
+		}
+		return approvals, nil
+	})
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	log.Printf("Step-up verified for privilege request %s", request.ID)
+	if request.Status == store.RequestStatusApproved && request.ApprovedBy == policyApprover.ID {
+		h.auditRequest(r.Context(), audit.ActionRequestApproved, policyApprover.ID, request,
+			map[string]string{"channel": models.ApprovalChannelPolicy, "status": request.Status})
+	}
+	h.logGrant(r.Context(), request)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(request)
+}
+
+// auditCreated records the creation of a request and, when the policy
+// approved it right away, its approval
+func (h *Handler) auditCreated(ctx context.Context, actor string, request *models.PrivilegeRequest) {
+	details := map[string]string{"module": request.Module, "level": string(request.Level), "status": request.Status}
+	if request.ExtendsGrant != "" {
+		details["extends_grant"] = request.ExtendsGrant
+	}
+	if request.HandsOverGrant != "" {
+		details["hands_over_grant"] = request.HandsOverGrant
+	}
+	h.auditRequest(ctx, audit.ActionRequestCreated, actor, request, details)
+	if request.Status == store.RequestStatusApproved {
+		h.auditRequest(ctx, audit.ActionRequestApproved, policyApprover.ID, request,
+			map[string]string{"channel": models.ApprovalChannelPolicy, "status": request.Status})
+	}
+}
+
+// logGrant logs and audits the grant the store created, or extended, for an
+// approved request. For a handover it also publishes the job revoking the
+// grant that was handed over.
+func (h *Handler) logGrant(ctx context.Context, request *models.PrivilegeRequest) {
+	if request.Status != store.RequestStatusApproved {
+		return
+	}
+	if request.HandsOverGrant != "" {
+		log.Printf("Grant %s of %s handed over to %s by %s", request.HandsOverGrant, request.ResourceID, request.UserID, request.HandedOverBy)
+		if grant, err := h.store.GetGrant(ctx, request.HandsOverGrant); err == nil {
+			h.auditGrant(ctx, audit.ActionGrantRevoked, request.HandedOverBy, grant)
+		}
+		h.publishRevokeJob(ctx, request.HandsOverGrant)
+		h.discardGrantSecret(ctx, request.HandsOverGrant)
+	}
+	if request.ExtendsGrant != "" {
+		grant, err := h.store.GetGrant(ctx, request.ExtendsGrant)
+		if err != nil {
+			log.Printf("Failed to read grant extended by privilege request %s: %v", request.ID, err)
+			return
+		}
+		log.Printf("Extended grant %s of %s to %s until %s", grant.ID, grant.ResourceID, grant.UserID, grant.ExpiresAt.Format(time.RFC3339))
+		h.auditGrant(ctx, audit.ActionGrantExtended, request.ApprovedBy, grant)
+		return
+	}
+	grant, err := h.store.GetRequestGrant(ctx, request.ID)
+	if err != nil {
+		log.Printf("Failed to read grant for privilege request %s: %v", request.ID, err)
+		return
+	}
+	log.Printf("Granted %s access to %s to %s until %s (grant %s)", grant.Level, grant.ResourceID, grant.UserID, grant.ExpiresAt.Format(time.RFC3339), grant.ID)
+	h.auditGrant(ctx, audit.ActionGrantCreated, request.ApprovedBy, grant)
+}
+
+// policyApprover approves requests the policy requires no approvals for
+var policyApprover = &models.Approver{ID: "policy", Kind: models.ApproverKindPolicy}
+
+// recordApproval adds the signed record of the approver approving the request
+// through the channel. The bearer token's subject is recorded along with the
+// approver when they authenticated with one, and so are the hardware key
+// assertion they confirmed with and the approval step their approval counts
+// towards.
+func (h *Handler) recordApproval(ctx context.Context, request *models.PrivilegeRequest, approver *models.Approver, channel string, assertion *models.WebAuthnAssertion) {
+	record := attest.NewRecord(request, approver, channel, time.Now())
+	record.WebAuthn = assertion
+	if step := request.CurrentStep(); step != nil && approver.Kind == models.ApproverKindUser {
+		record.Step = step.Name
+	}
+	if principal, ok := auth.PrincipalFrom(ctx); ok && principal.ID == approver.ID {
+		record.Issuer = principal.Issuer
+		record.Subject = principal.Subject
+	}
+	h.approvalSigner.Sign(&record)
+	request.Approvals = append(request.Approvals, record)
+}
+
+// approve marks the request as approved
+func approve(request *models.PrivilegeRequest, approverID string) {
+	approvedAt := time.Now().UTC()
+	request.Status = store.RequestStatusApproved
+	request.ApprovedBy = approverID
+	request.ApprovedAt = &approvedAt
+}
+
+// writeRuleError writes the error response for a failed privilege operation. Policy
+// violations are returned as 422 with the violated rules.
+func writeRuleError(w http.ResponseWriter, err error) {
+	status := ruleErrorStatus(err)
+	body := map[string]interface{}{"error": redact.Error(err)}
+
+	var violationErr *rules.ViolationError
+	switch {
+	case errors.As(err, &violationErr):
+		body["violations"] = violationErr.Violations
+	case status == http.StatusNotFound:
+		body["error"] = "privilege request not found"
+	case status == http.StatusInternalServerError:
+		log.Printf("Error handling privilege request: %v", err)
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(body)
+}
+
+// ruleErrorStatus returns the status of the response to a failed privilege operation
+func ruleErrorStatus(err error) int {
+	var violationErr *rules.ViolationError
+	var conflictErr *conflictError
+	var webAuthnErr *webAuthnError
+	switch {
+	case errors.As(err, &violationErr):
+		return http.StatusUnprocessableEntity
+	case errors.As(err, &conflictErr), errors.Is(err, store.ErrGrantEnded):
+		return http.StatusConflict
+	case errors.As(err, &webAuthnErr):
+		return http.StatusForbidden
+	case errors.Is(err, store.ErrNotFound):
+		return http.StatusNotFound
+	default:
+		return http.StatusInternalServerError
+	}
+}
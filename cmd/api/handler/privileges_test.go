@@ -0,0 +1,121 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// newAccessTestHandler returns the routes of a handler with bob as its
+// administrator and a pending request each of alice and carol
+func newAccessTestHandler(t *testing.T) (http.Handler, map[string]string) {
+	t.Helper()
+	st := store.NewMemoryStore()
+	h := NewHandler(nil, st)
+	h.SetAdmins([]string{"bob"})
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+
+	ids := make(map[string]string)
+	now := time.Now().UTC()
+	for _, user := range []string{"alice", "carol"} {
+		request := &models.PrivilegeRequest{
+			UserID:      user,
+			Module:      "mysql",
+			ResourceID:  "orders",
+			Level:       models.PrivilegeLevelRead,
+			Status:      store.RequestStatusPending,
+			RequestedAt: now,
+			ExpiresAt:   now.Add(time.Hour),
+		}
+		if err := st.CreateRequest(context.Background(), request); err != nil {
+			t.Fatal(err)
+		}
+		ids[user] = request.ID
+	}
+	return mux, ids
+}
+
+// get serves a GET request of the path as the user
+func get(routes http.Handler, path, user string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(http.MethodGet, path, nil)
+	r.Header.Set("X-Apollo-User", user)
+	w := httptest.NewRecorder()
+	routes.ServeHTTP(w, r)
+	return w
+}
+
+func TestListPrivilegeRequestsAccess(t *testing.T) {
+	routes, ids := newAccessTestHandler(t)
+	tests := []struct {
+		user   string
+		query  string
+		status int
+		want   []string
+	}{
+		// Users list their own requests, whatever they ask for
+		{user: "alice", want: []string{ids["alice"]}},
+		{user: "alice", query: "?user=alice", want: []string{ids["alice"]}},
+		{user: "alice", query: "?user=carol", status: http.StatusForbidden},
+		{user: "alice", query: "?all_users=true", status: http.StatusForbidden},
+		{user: "dave", want: []string{}},
+		// Administrators list anyone's
+		{user: "bob", query: "?user=carol", want: []string{ids["carol"]}},
+		{user: "bob", query: "?all_users=true&status=pending", want: []string{ids["carol"], ids["alice"]}},
+		{user: "", status: http.StatusUnauthorized},
+	}
+	for _, tt := range tests {
+		w := get(routes, "/api/v1/privileges/requests"+tt.query, tt.user)
+		status := tt.status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		if w.Code != status {
+			t.Errorf("%s%s: status %d, want %d", tt.user, tt.query, w.Code, status)
+			continue
+		}
+		if status != http.StatusOK {
+			continue
+		}
+		var requests []*models.PrivilegeRequest
+		if err := json.NewDecoder(w.Body).Decode(&requests); err != nil {
+			t.Fatal(err)
+		}
+		got := []string{}
+		for _, request := range requests {
+			got = append(got, request.ID)
+		}
+		slices.Sort(got)
+		slices.Sort(tt.want)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("%s%s: listed %v, want %v", tt.user, tt.query, got, tt.want)
+		}
+	}
+}
+
+func TestGetPrivilegeRequestAccess(t *testing.T) {
+	routes, ids := newAccessTestHandler(t)
+	tests := []struct {
+		user   string
+		status int
+	}{
+		{user: "alice", status: http.StatusOK},
+		{user: "bob", status: http.StatusOK},
+		{user: "carol", status: http.StatusForbidden},
+		{user: "", status: http.StatusUnauthorized},
+	}
+	for _, path := range []string{"/api/v1/privileges/" + ids["alice"], "/api/v1/privileges/" + ids["alice"] + "/events"} {
+		for _, tt := range tests {
+			if w := get(routes, path, tt.user); w.Code != tt.status {
+				t.Errorf("%s as %q: status %d, want %d", path, tt.user, w.Code, tt.status)
+			}
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/report"
+)
+
+// SetReports configures the generator of usage reports
+func (h *Handler) SetReports(generator *report.Generator) {
+	h.reports = generator
+}
+
+// handleListReports lists the generated reports, newest first
+func (h *Handler) handleListReports(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.reports == nil {
+		http.Error(w, "Reports are not configured", http.StatusNotFound)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	reports, err := h.reports.List()
+	if err != nil {
+		log.Printf("Failed to list reports: %v", err)
+		http.Error(w, "Failed to list reports", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(reports)
+}
+
+// handleRunReport generates the report of the last complete week or month
+// immediately, e.g. ?period=monthly
+func (h *Handler) handleRunReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.reports == nil {
+		http.Error(w, "Reports are not configured", http.StatusNotFound)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	period := r.URL.Query().Get("period")
+	if period != "" && period != report.PeriodWeekly && period != report.PeriodMonthly {
+		http.Error(w, "Period must be weekly or monthly", http.StatusBadRequest)
+		return
+	}
+	log.Printf("Report run triggered by %s", userID)
+	generated, err := h.reports.Run(r.Context(), period, time.Now())
+	if err != nil {
+		log.Printf("Failed to generate report: %v", err)
+		http.Error(w, "Failed to generate report", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(generated)
+}
+
+// handleDownloadReport returns a report as JSON, or as CSV with ?format=csv
+func (h *Handler) handleDownloadReport(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.reports == nil {
+		http.Error(w, "Reports are not configured", http.StatusNotFound)
+		return
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return
+	}
+
+	id := r.PathValue("id")
+	format := r.URL.Query().Get("format")
+	if format == "" {
+		format = "json"
+	}
+	if format != "json" && format != "csv" {
+		http.Error(w, "Format must be json or csv", http.StatusBadRequest)
+		return
+	}
+	data, err := h.reports.Read(id, format)
+	if errors.Is(err, report.ErrNotFound) {
+		http.Error(w, "Report not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		log.Printf("Failed to read report %s: %v", id, err)
+		http.Error(w, "Failed to read report", http.StatusInternalServerError)
+		return
+	}
+
+	contentType := "application/json"
+	if format == "csv" {
+		contentType = "text/csv"
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s.%s", id, format))
+	w.Write(data)
+}
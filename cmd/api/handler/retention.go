@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+
+	"github.com/petermein/apollo/cmd/api/retention"
+)
+
+// SetRetention configures the retention worker
+func (h *Handler) SetRetention(worker *retention.Worker) {
+	h.retention = worker
+}
+
+// handleRunRetention runs the retention policy immediately and returns its report
+func (h *Handler) handleRunRetention(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if h.retention == nil {
+		http.Error(w, "Retention is not configured", http.StatusNotFound)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	log.Printf("Retention run triggered by %s", userID)
+	report := h.retention.Run(r.Context())
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(report)
+}
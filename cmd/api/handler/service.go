@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+	"github.com/petermein/apollo/internal/core/service"
+)
+
+// The handler is the privilege service: it keeps requests and grants in its
+// store and decides them with its rule engine, and the privilege endpoints
+// go through it. Operators provision approved grants from the approved
+// events, and remove revoked ones through revoke jobs.
+var _ service.PrivilegeService = (*Handler)(nil)
+
+// RequestPrivilege evaluates a privilege request against the rules and stores
+// it. Requests that need no approvals are approved immediately. The request
+// names the user, the access and when it was requested and expires.
+func (h *Handler) RequestPrivilege(ctx context.Context, request *models.PrivilegeRequest) (*models.PrivilegeRequest, error) {
+	request.PendingUntil = h.pendingDeadline(request.RequestedAt)
+	request.Status = store.RequestStatusPending
+	if err := h.ruleEngine.EvaluateRequest(request); err != nil {
+		log.Printf("Privilege request from %s for %s rejected: %v", request.UserID, request.ResourceID, err)
+		return nil, err
+	}
+	h.holdAutoApproval(request)
+	if request.RequiredApprovals == 0 && request.StepUp.Satisfied() {
+		h.recordApproval(ctx, request, policyApprover, models.ApprovalChannelPolicy, nil)
+		approve(request, "policy")
+	}
+
+	if err := h.store.CreateRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	log.Printf("Privilege request %s from %s for %s access to %s is %s", request.ID, request.UserID, request.Level, request.ResourceID, request.Status)
+	h.auditCreated(ctx, request.UserID, request)
+	h.logGrant(ctx, request)
+	return request, nil
+}
+
+// ApproveRequest records an approval given through the API; the request is
+// approved once it has all the approvals it requires. Requests that need a
+// hardware key are approved from the approval endpoint, which takes the
+// assertion.
+func (h *Handler) ApproveRequest(ctx context.Context, requestID string, approver *models.Approver) (*models.PrivilegeRequest, error) {
+	return h.approveRequest(ctx, requestID, approver, models.ApprovalChannelAPI, nil)
+}
+
+// RevokePrivilege ends a grant early and publishes the job that removes its
+// access from the target
+func (h *Handler) RevokePrivilege(ctx context.Context, grantID, revokedBy string) (*models.PrivilegeGrant, error) {
+	grant, err := h.store.RevokeGrant(ctx, grantID, revokedBy)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("Grant %s of %s to %s revoked by %s", grant.ID, grant.ResourceID, grant.UserID, revokedBy)
+	h.auditGrant(ctx, audit.ActionGrantRevoked, revokedBy, grant)
+	h.publishRevokeJob(ctx, grant.ID)
+	h.discardGrantSecret(ctx, grant.ID)
+	return grant, nil
+}
+
+// GetActiveGrants retrieves the user's active grants, or everyone's when
+// userID is empty
+func (h *Handler) GetActiveGrants(ctx context.Context, userID string) ([]*models.PrivilegeGrant, error) {
+	grants, err := h.store.ListGrants(ctx, userID, time.Now().UTC())
+	if err != nil {
+		return nil, err
+	}
+	if grants == nil {
+		grants = []*models.PrivilegeGrant{}
+	}
+	return grants, nil
+}
+
+// GetPendingRequests retrieves the requests waiting for approvals
+func (h *Handler) GetPendingRequests(ctx context.Context) ([]*models.PrivilegeRequest, error) {
+	requests, err := h.store.ListRequests(ctx, store.RequestFilter{Status: store.RequestStatusPending})
+	if err != nil {
+		return nil, err
+	}
+	return h.waiting(requests, time.Now()), nil
+}
+
+// waiting leaves out the pending requests past their deadline; they are no
+// longer waiting on approvers
+func (h *Handler) waiting(requests []*models.PrivilegeRequest, now time.Time) []*models.PrivilegeRequest {
+	waiting := requests[:0]
+	for _, request := range requests {
+		if !h.lapsed(request, now) {
+			waiting = append(waiting, request)
+		}
+	}
+	return waiting
+}
+
+// ValidateAccess reports whether the user holds an active grant for the
+// module's resource at the required level or a higher one
+func (h *Handler) ValidateAccess(ctx context.Context, userID, module, resourceID string, requiredLevel models.PrivilegeLevel) (bool, error) {
+	if userID == "" {
+		return false, errors.New("user is required")
+	}
+	if levelRank(requiredLevel) == 0 {
+		return false, fmt.Errorf("unknown privilege level %q", requiredLevel)
+	}
+	grants, err := h.GetActiveGrants(ctx, userID)
+	if err != nil {
+		return false, err
+	}
+	for _, grant := range grants {
+		if grant.Module == module && grant.ResourceID == resourceID && levelRank(grant.Level) >= levelRank(requiredLevel) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// levelRank orders the privilege levels, each including the ones below it;
+// unknown levels rank 0
+func levelRank(level models.PrivilegeLevel) int {
+	switch level {
+	case models.PrivilegeLevelRead:
+		return 1
+	case models.PrivilegeLevelWrite:
+		return 2
+	case models.PrivilegeLevelAdmin:
+		return 3
+	case models.PrivilegeLevelRoot:
+		return 4
+	}
+	return 0
+}
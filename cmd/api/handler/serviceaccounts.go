@@ -0,0 +1,496 @@
+package handler
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/auth"
+)
+
+// Actions service account scopes allow
+const (
+	ActionCreateJobs        = "create_jobs"
+	ActionReadJobs          = "read_jobs"
+	ActionRequestPrivileges = "request_privileges"
+	ActionReadPrivileges    = "read_privileges"
+	ActionReadGrants        = "read_grants"
+	ActionReadAudit         = "read_audit"
+	ActionReadEvents        = "read_events"
+	ActionManageEvents      = "manage_events"
+	ActionSimulatePolicies  = "simulate_policies"
+	ActionReadInventory     = "read_inventory"
+	// ActionOperate lets an operator register, report health and servers,
+	// and take and complete jobs
+	ActionOperate = "operate"
+)
+
+// moduleActions are the actions a scope can limit to one module
+var moduleActions = map[string]bool{
+	ActionCreateJobs:        true,
+	ActionRequestPrivileges: true,
+}
+
+// routeActions maps the routes service accounts may call to the action a
+// token's scopes must allow; an empty action only needs a valid token.
+// Approving, denying and managing service accounts are left to people.
+var routeActions = map[string]string{
+	"/api/v1/ping":                          ActionCreateJobs,
+	"/api/v1/jobs/ping":                     ActionCreateJobs,
+	"/api/v1/jobs":                          ActionReadJobs,
+	"/api/v1/privileges/request":            ActionRequestPrivileges,
+	"/api/v1/privileges/requests":           ActionReadPrivileges,
+	"/api/v1/privileges/{id}":               ActionReadPrivileges,
+	"/api/v1/privileges/{id}/events":        ActionReadPrivileges,
+	"/api/v1/grants":                        ActionReadGrants,
+	"/api/v1/audit":                         ActionReadAudit,
+	"/api/v1/audit/export":                  ActionReadAudit,
+	"/api/v1/audit/exports/{id}":            ActionReadAudit,
+	"/api/v1/audit/exports/{id}/download":   ActionReadAudit,
+	"/api/v1/audit/approval-keys":           ActionReadAudit,
+	"/api/v1/grants/{id}/approvals":         ActionReadAudit,
+	"/api/v1/events/stream":                 ActionReadEvents,
+	"/api/v1/events/replay":                 ActionManageEvents,
+	"/api/v1/events/deliveries":             ActionManageEvents,
+	"/api/v1/events/dead-letters":           ActionManageEvents,
+	"/api/v1/events/dead-letters/redeliver": ActionManageEvents,
+	"/api/v1/events/schemas":                "",
+	"/api/v1/events/schemas/{type}":         "",
+	"/api/v1/policies/simulate":             ActionSimulatePolicies,
+	"/api/v1/policies/test":                 ActionSimulatePolicies,
+	"/api/v1/mysql/servers":                 ActionReadInventory,
+	"/api/v1/operators":                     ActionReadInventory,
+	"/api/v1/health/tree":                   ActionReadInventory,
+	"/api/v1/operators/register":            ActionOperate,
+	"/api/v1/operators/health":              ActionOperate,
+	"/api/v1/operators/token":               ActionOperate,
+	"/api/v1/mysql/servers/register":        ActionOperate,
+	"/api/v1/mysql/servers/inactive":        ActionOperate,
+	"/api/v1/jobs/pending":                  ActionOperate,
+	"/api/v1/jobs/{id}":                     ActionOperate,
+	"/api/v1/health":                        "",
+	"/api/v1/features":                      "",
+	"/api/v1/openapi.json":                  "",
+}
+
+// serviceTokenPrefix starts every service account token, so they are told
+// apart from JWTs and found by secret scanners
+const serviceTokenPrefix = "apollo_sat."
+
+// serviceTokenTouchInterval limits how often a token's last use is recorded
+const serviceTokenTouchInterval = time.Minute
+
+// serviceAccountNamePattern keeps names usable in user IDs, e.g. sa:deploy-ci
+var serviceAccountNamePattern = regexp.MustCompile(`^[a-z0-9][a-z0-9-]{0,62}$`)
+
+// serviceAccountKey is the context key of the service account a request is made by
+type serviceAccountKey struct{}
+
+// serviceAccountUser is the user ID a service account acts as
+func serviceAccountUser(account *store.ServiceAccount) string {
+	return "sa:" + account.Name
+}
+
+// serviceAccountFrom returns the service account a request is made by
+func serviceAccountFrom(ctx context.Context) (*store.ServiceAccount, bool) {
+	account, ok := ctx.Value(serviceAccountKey{}).(*store.ServiceAccount)
+	return account, ok
+}
+
+// allows reports whether the account's scopes allow the action on the
+// module, or on some module when module is empty
+func allows(account *store.ServiceAccount, action, module string) bool {
+	for _, scope := range account.Scopes {
+		if scope.Action == action && (module == "" || scope.Module == "" || scope.Module == module) {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthenticateServiceAccounts authenticates requests carrying a service
+// account token and rejects those its scopes don't allow. Authenticated
+// requests act as sa:<name>, the principal other authentication leaves alone.
+// It must wrap the mux the routes were registered on.
+func (h *Handler) AuthenticateServiceAccounts(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scheme, rawToken, _ := strings.Cut(r.Header.Get("Authorization"), " ")
+		if !strings.EqualFold(scheme, "Bearer") || !strings.HasPrefix(rawToken, serviceTokenPrefix) {
+			// Only a token makes a request act as a service account
+			if strings.HasPrefix(r.Header.Get(auth.UserHeader), "sa:") {
+				r.Header.Del(auth.UserHeader)
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		account, err := h.authenticateServiceToken(r.Context(), rawToken)
+		if err != nil {
+			log.Printf("Rejected service account token for %s %s: %v", r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="apollo", error="invalid_token"`)
+			http.Error(w, "Invalid token", http.StatusUnauthorized)
+			return
+		}
+
+		var pattern string
+		if h.routes != nil {
+			_, pattern = h.routes.Handler(r)
+		}
+		action, ok := routeActions[pattern]
+		if !ok {
+			http.Error(w, "Service accounts can't use this endpoint", http.StatusForbidden)
+			return
+		}
+		if action != "" && !allows(account, action, "") {
+			http.Error(w, fmt.Sprintf("Service account %s is not allowed to %s", account.Name, action), http.StatusForbidden)
+			return
+		}
+
+		principal := &auth.Principal{ID: serviceAccountUser(account), Subject: account.ID, Issuer: "apollo"}
+		ctx := context.WithValue(auth.WithPrincipal(r.Context(), principal), serviceAccountKey{}, account)
+		r = r.WithContext(ctx)
+		r.Header.Set(auth.UserHeader, principal.ID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// authenticateServiceToken returns the account of a valid, active token
+func (h *Handler) authenticateServiceToken(ctx context.Context, rawToken string) (*store.ServiceAccount, error) {
+	id, secret, ok := strings.Cut(strings.TrimPrefix(rawToken, serviceTokenPrefix), ".")
+	if !ok || id == "" || secret == "" {
+		return nil, fmt.Errorf("malformed token")
+	}
+	token, err := h.store.GetServiceToken(ctx, id)
+	if errors.Is(err, store.ErrNotFound) {
+		return nil, fmt.Errorf("unknown token %s", id)
+	}
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(secret))
+	if subtle.ConstantTimeCompare(hash[:], token.Hash) != 1 {
+		return nil, fmt.Errorf("wrong secret for token %s", id)
+	}
+	now := time.Now().UTC()
+	if !token.Active(now) {
+		return nil, fmt.Errorf("token %s is revoked or expired", id)
+	}
+	account, err := h.store.GetServiceAccount(ctx, token.AccountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get service account of token %s: %v", id, err)
+	}
+	if token.LastUsedAt == nil || now.Sub(*token.LastUsedAt) >= serviceTokenTouchInterval {
+		if err := h.store.TouchServiceToken(ctx, token.ID, now); err != nil {
+			log.Printf("Failed to record use of service token %s: %v", token.ID, err)
+		}
+	}
+	return account, nil
+}
+
+// allowModule writes an error response unless the request is made by a
+// person or by a service account allowed to take the action on the module.
+// Only unlimited scopes allow requests that name no module.
+func (h *Handler) allowModule(w http.ResponseWriter, r *http.Request, action, module string) bool {
+	account, ok := serviceAccountFrom(r.Context())
+	if !ok {
+		return true
+	}
+	for _, scope := range account.Scopes {
+		if scope.Action == action && (scope.Module == "" || scope.Module == module) {
+			return true
+		}
+	}
+	http.Error(w, fmt.Sprintf("Service account %s is not allowed to %s for module %s", account.Name, action, module), http.StatusForbidden)
+	return false
+}
+
+// validateScopes checks that scopes name known actions and only limit
+// module actions to a module
+func validateScopes(scopes []store.Scope) error {
+	for i, scope := range scopes {
+		modular, ok := moduleActions[scope.Action]
+		if !ok && !knownAction(scope.Action) {
+			return fmt.Errorf("scope %d: unknown action %q", i, scope.Action)
+		}
+		if scope.Module != "" && !modular {
+			return fmt.Errorf("scope %d: %s can't be limited to a module", i, scope.Action)
+		}
+	}
+	return nil
+}
+
+// knownAction reports whether a route needs the action
+func knownAction(action string) bool {
+	if action == "" {
+		return false
+	}
+	for _, routeAction := range routeActions {
+		if routeAction == action {
+			return true
+		}
+	}
+	return false
+}
+
+// serviceAccountResponse is a service account with its tokens
+type serviceAccountResponse struct {
+	store.ServiceAccount
+	Tokens []store.ServiceToken `json:"tokens"`
+}
+
+// createServiceAccountRequest creates a service account
+type createServiceAccountRequest struct {
+	Name        string        `json:"name"`
+	Description string        `json:"description"`
+	Scopes      []store.Scope `json:"scopes"`
+}
+
+// handleServiceAccounts lists the service accounts or creates one
+func (h *Handler) handleServiceAccounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodGet {
+		accounts, err := h.store.ListServiceAccounts(r.Context())
+		if err != nil {
+			log.Printf("Failed to list service accounts: %v", err)
+			http.Error(w, "Failed to list service accounts", http.StatusInternalServerError)
+			return
+		}
+		if accounts == nil {
+			accounts = []store.ServiceAccount{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"service_accounts": accounts})
+		return
+	}
+
+	var req createServiceAccountRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	if !serviceAccountNamePattern.MatchString(req.Name) {
+		http.Error(w, "Name must be lowercase letters, digits and dashes", http.StatusBadRequest)
+		return
+	}
+	if err := validateScopes(req.Scopes); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	account := &store.ServiceAccount{Name: req.Name, Description: req.Description, Scopes: req.Scopes, CreatedBy: userID}
+	if account.Scopes == nil {
+		account.Scopes = []store.Scope{}
+	}
+	if err := h.store.CreateServiceAccount(r.Context(), account); err != nil {
+		if errors.Is(err, store.ErrExists) {
+			http.Error(w, fmt.Sprintf("Service account %s already exists", req.Name), http.StatusConflict)
+			return
+		}
+		log.Printf("Failed to create service account: %v", err)
+		http.Error(w, "Failed to create service account", http.StatusInternalServerError)
+		return
+	}
+
+	log.Printf("%s created service account %s", userID, account.Name)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(account)
+}
+
+// updateServiceAccountRequest replaces a service account's scopes
+type updateServiceAccountRequest struct {
+	Scopes []store.Scope `json:"scopes"`
+}
+
+// handleServiceAccount returns a service account with its tokens, replaces
+// its scopes or deletes it with its tokens
+func (h *Handler) handleServiceAccount(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+
+	switch r.Method {
+	case http.MethodGet:
+		account, err := h.store.GetServiceAccount(r.Context(), id)
+		if err != nil {
+			writeServiceAccountError(w, "get service account", err)
+			return
+		}
+		tokens, err := h.store.ListServiceTokens(r.Context(), id)
+		if err != nil {
+			writeServiceAccountError(w, "list service tokens", err)
+			return
+		}
+		if tokens == nil {
+			tokens = []store.ServiceToken{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(serviceAccountResponse{ServiceAccount: *account, Tokens: tokens})
+
+	case http.MethodPut:
+		var req updateServiceAccountRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+		if err := validateScopes(req.Scopes); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if req.Scopes == nil {
+			req.Scopes = []store.Scope{}
+		}
+		if err := h.store.SetServiceAccountScopes(r.Context(), id, req.Scopes); err != nil {
+			writeServiceAccountError(w, "update service account", err)
+			return
+		}
+		account, err := h.store.GetServiceAccount(r.Context(), id)
+		if err != nil {
+			writeServiceAccountError(w, "get service account", err)
+			return
+		}
+		log.Printf("%s changed the scopes of service account %s", userID, account.Name)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(account)
+
+	case http.MethodDelete:
+		if err := h.store.DeleteServiceAccount(r.Context(), id); err != nil {
+			writeServiceAccountError(w, "delete service account", err)
+			return
+		}
+		log.Printf("%s deleted service account %s", userID, id)
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
+
+// createServiceTokenRequest issues a token to a service account
+type createServiceTokenRequest struct {
+	ExpiresIn string `json:"expires_in"`
+}
+
+// handleServiceTokens lists the tokens of a service account or issues a new
+// one, optionally expiring after expires_in. The token is only shown here.
+func (h *Handler) handleServiceTokens(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+	id := r.PathValue("id")
+
+	if r.Method == http.MethodGet {
+		if _, err := h.store.GetServiceAccount(r.Context(), id); err != nil {
+			writeServiceAccountError(w, "get service account", err)
+			return
+		}
+		tokens, err := h.store.ListServiceTokens(r.Context(), id)
+		if err != nil {
+			writeServiceAccountError(w, "list service tokens", err)
+			return
+		}
+		if tokens == nil {
+			tokens = []store.ServiceToken{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]interface{}{"tokens": tokens})
+		return
+	}
+
+	var req createServiceTokenRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, "Invalid request body", http.StatusBadRequest)
+			return
+		}
+	}
+	token := &store.ServiceToken{AccountID: id, CreatedBy: userID}
+	if req.ExpiresIn != "" {
+		expiresIn, err := time.ParseDuration(req.ExpiresIn)
+		if err != nil || expiresIn <= 0 {
+			http.Error(w, "Invalid expires_in", http.StatusBadRequest)
+			return
+		}
+		expiresAt := time.Now().UTC().Add(expiresIn)
+		token.ExpiresAt = &expiresAt
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		log.Printf("Failed to generate service token: %v", err)
+		http.Error(w, "Failed to create token", http.StatusInternalServerError)
+		return
+	}
+	encoded := base64.RawURLEncoding.EncodeToString(secret)
+	hash := sha256.Sum256([]byte(encoded))
+	token.Hash = hash[:]
+	if err := h.store.CreateServiceToken(r.Context(), token); err != nil {
+		writeServiceAccountError(w, "create service token", err)
+		return
+	}
+
+	log.Printf("%s issued token %s for service account %s", userID, token.ID, id)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(struct {
+		store.ServiceToken
+		Token string `json:"token"`
+	}{ServiceToken: *token, Token: serviceTokenPrefix + token.ID + "." + encoded})
+}
+
+// handleRevokeServiceToken revokes a token of a service account
+func (h *Handler) handleRevokeServiceToken(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	id, tokenID := r.PathValue("id"), r.PathValue("token")
+	if err := h.store.RevokeServiceToken(r.Context(), id, tokenID, time.Now().UTC()); err != nil {
+		writeServiceAccountError(w, "revoke service token", err)
+		return
+	}
+	log.Printf("%s revoked token %s of service account %s", userID, tokenID, id)
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writeServiceAccountError writes the response for a failed service account operation
+func writeServiceAccountError(w http.ResponseWriter, operation string, err error) {
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Service account or token not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Failed to %s: %v", operation, err)
+	http.Error(w, "Failed to "+operation, http.StatusInternalServerError)
+}
@@ -0,0 +1,213 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/auth"
+)
+
+// sessionTouchInterval is how often the last use of a session is written
+const sessionTouchInterval = time.Minute
+
+// sessionRetention is how long expired sessions are kept before the cleanup
+// worker deletes them
+const sessionRetention = 24 * time.Hour
+
+// currentSession names the session of the calling token in session paths
+const currentSession = "current"
+
+// TrackSessions records the tokens users authenticate with as sessions and
+// rejects those that were revoked. It goes behind the bearer token
+// middleware, which sets the principal it reads.
+func (h *Handler) TrackSessions(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok || principal.SessionID == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if err := h.trackSession(r, principal); err != nil {
+			log.Printf("Rejected session %s of %s for %s %s: %v", principal.SessionID, principal.ID, r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", `Bearer realm="apollo", error="invalid_token"`)
+			http.Error(w, "Session revoked", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// trackSession checks the principal's session wasn't revoked, storing it when
+// it is new and updating when it was last seen at most once a sessionTouchInterval
+func (h *Handler) trackSession(r *http.Request, principal *auth.Principal) error {
+	ctx, now := r.Context(), time.Now().UTC()
+	session, err := h.store.GetSession(ctx, principal.SessionID)
+	switch {
+	case errors.Is(err, store.ErrNotFound):
+		// A token first seen after its user's sessions were revoked must
+		// have been issued after the revocation
+		revokedAt, err := h.store.SessionsRevokedAt(ctx, principal.ID)
+		if err != nil {
+			return err
+		}
+		if !revokedAt.IsZero() && !principal.IssuedAt.After(revokedAt) {
+			return fmt.Errorf("sessions of %s were revoked at %s", principal.ID, revokedAt.Format(time.RFC3339))
+		}
+		session = &store.Session{
+			ID:        principal.SessionID,
+			Kind:      store.SessionToken,
+			UserID:    principal.ID,
+			Groups:    principal.Groups,
+			Issuer:    principal.Issuer,
+			Subject:   principal.Subject,
+			CreatedAt: now,
+			ExpiresAt: principal.ExpiresAt,
+		}
+	case err != nil:
+		return err
+	case session.RevokedAt != nil:
+		return fmt.Errorf("session was revoked by %s", session.RevokedBy)
+	case now.Sub(session.LastSeenAt) < sessionTouchInterval:
+		return nil
+	}
+
+	session.LastSeenAt = now
+	session.UserAgent = truncate(r.UserAgent(), 512)
+	session.SourceIP = h.clientIP(r)
+	if err := h.store.TouchSession(ctx, session); err != nil {
+		// The request is let through; only when it was last seen is missed
+		log.Printf("Failed to record use of session %s: %v", session.ID, err)
+	}
+	return nil
+}
+
+// truncate shortens a string to at most n bytes
+func truncate(s string, n int) string {
+	if len(s) > n {
+		return s[:n]
+	}
+	return s
+}
+
+// sessionsUser returns the user whose sessions the request is about: the
+// caller, or for administrators the user named in the query
+func (h *Handler) sessionsUser(w http.ResponseWriter, r *http.Request) (callerID, userID string, ok bool) {
+	callerID = r.Header.Get("X-Apollo-User")
+	if callerID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return "", "", false
+	}
+	userID = r.URL.Query().Get("user")
+	if userID == "" || userID == callerID {
+		return callerID, callerID, true
+	}
+	if _, ok := h.requireAdmin(w, r); !ok {
+		return "", "", false
+	}
+	return callerID, userID, true
+}
+
+// handleSessions lists the sessions of a user on GET, only the active ones
+// unless all=true, and revokes all of them on DELETE
+func (h *Handler) handleSessions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	callerID, userID, ok := h.sessionsUser(w, r)
+	if !ok {
+		return
+	}
+
+	if r.Method == http.MethodDelete {
+		revoked, err := h.store.RevokeUserSessions(r.Context(), userID, callerID, time.Now())
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Revoked %d sessions of %s by %s", revoked, userID, callerID)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"revoked": revoked})
+		return
+	}
+
+	filter := store.SessionFilter{UserID: userID, ActiveAt: time.Now()}
+	if r.URL.Query().Get("all") == "true" {
+		filter.ActiveAt = time.Time{}
+	}
+	sessions, err := h.store.ListSessions(r.Context(), filter)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if sessions == nil {
+		sessions = []*store.Session{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(sessions)
+}
+
+// handleRevokeSession revokes one session, which callers may do for their
+// own and administrators for anyone's; current names the calling token's
+func (h *Handler) handleRevokeSession(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+
+	id := r.PathValue("id")
+	if id == currentSession {
+		principal, ok := auth.PrincipalFrom(r.Context())
+		if !ok || principal.SessionID == "" {
+			http.Error(w, "Request was not made with a session token", http.StatusBadRequest)
+			return
+		}
+		id = principal.SessionID
+	}
+	session, err := h.store.GetSession(r.Context(), id)
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Session not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if session.UserID != userID {
+		if _, ok := h.requireAdmin(w, r); !ok {
+			return
+		}
+	}
+	if session.RevokedAt == nil {
+		err := h.store.RevokeSession(r.Context(), session.ID, userID, time.Now())
+		if err != nil && !errors.Is(err, store.ErrNotFound) {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		log.Printf("Revoked session %s of %s by %s", session.ID, session.UserID, userID)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// pruneSessions deletes sessions that expired a while ago
+func (h *Handler) pruneSessions(ctx context.Context, now time.Time) {
+	pruned, err := h.store.PruneSessions(ctx, now.Add(-sessionRetention))
+	if err != nil {
+		log.Printf("Failed to prune sessions: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d expired sessions", pruned)
+	}
+}
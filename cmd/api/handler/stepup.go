@@ -0,0 +1,60 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SetStepUpVerifier configures the verifier for step-up ID tokens
+func (h *Handler) SetStepUpVerifier(verifier *auth.Verifier) {
+	h.stepUpVerifier = verifier
+}
+
+// verifyStepUp checks a step-up ID token against the request's requirement and
+// records the verification on success
+func (h *Handler) verifyStepUp(ctx context.Context, request *models.PrivilegeRequest, idToken string) error {
+	if request.StepUp == nil {
+		return fmt.Errorf("request does not require step-up authentication")
+	}
+	if h.stepUpVerifier == nil {
+		return fmt.Errorf("step-up authentication is not configured")
+	}
+
+	claims, err := h.stepUpVerifier.Verify(ctx, idToken)
+	if err != nil {
+		return fmt.Errorf("invalid step-up token: %v", err)
+	}
+	if claims.Subject != request.UserID && claims.Email != request.UserID {
+		return fmt.Errorf("step-up token was issued to a different user")
+	}
+	if err := auth.CheckStepUp(claims, request.StepUp.ACRValues, time.Duration(request.StepUp.MaxAge)*time.Second); err != nil {
+		return err
+	}
+
+	verifiedAt := time.Now().UTC()
+	request.StepUp.VerifiedAt = &verifiedAt
+	return nil
+}
+
+// writeStepUpChallenge tells the client that a fresh MFA assertion is required,
+// following the OAuth step-up challenge format of RFC 9470
+func writeStepUpChallenge(w http.ResponseWriter, requirement *models.StepUpRequirement, description string) {
+	challenge := fmt.Sprintf(`Bearer error="insufficient_user_authentication", error_description=%q, max_age="%d"`, description, requirement.MaxAge)
+	if len(requirement.ACRValues) > 0 {
+		challenge += fmt.Sprintf(`, acr_values="%s"`, strings.Join(requirement.ACRValues, " "))
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusUnauthorized)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"error":   description,
+		"step_up": requirement,
+	})
+}
@@ -0,0 +1,137 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/events"
+)
+
+const (
+	// streamPollInterval is how often event streams check the store for new events
+	streamPollInterval = time.Second
+	// streamSettle holds events back for a moment, so an event written by a
+	// transaction that committed after a later one isn't skipped
+	streamSettle = 2 * time.Second
+	// streamKeepAlive is how often an idle stream sends a comment, so proxies
+	// don't close it
+	streamKeepAlive = 15 * time.Second
+	// streamRetry is how long clients wait before reconnecting, in milliseconds
+	streamRetry = 3000
+)
+
+// handleStreamEvents streams grant lifecycle events to administrators as
+// server-sent events, optionally of some types only. Events are read from the
+// store rather than from this server's deliveries, so every API server streams
+// all of them. Each event's ID is a cursor; clients reconnecting with it in
+// Last-Event-ID, or in the after parameter, continue after the event, and
+// new streams start with the events recorded from then on.
+func (h *Handler) handleStreamEvents(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID, ok := h.requireAdmin(w, r)
+	if !ok {
+		return
+	}
+
+	query := store.EventQuery{Limit: replayBatch}
+	for _, value := range r.URL.Query()["type"] {
+		for _, eventType := range strings.Split(value, ",") {
+			if _, ok := events.Lookup(eventType); !ok {
+				http.Error(w, fmt.Sprintf("Unknown event type %q", eventType), http.StatusBadRequest)
+				return
+			}
+			query.Types = append(query.Types, eventType)
+		}
+	}
+	cursor := r.Header.Get("Last-Event-ID")
+	if cursor == "" {
+		cursor = r.URL.Query().Get("after")
+	}
+	if cursor != "" {
+		after, id, ok := strings.Cut(cursor, ",")
+		var err error
+		if query.AfterTime, err = time.Parse(time.RFC3339Nano, after); err != nil || !ok || id == "" {
+			http.Error(w, "Invalid after cursor", http.StatusBadRequest)
+			return
+		}
+		query.AfterID = id
+	} else {
+		query.From = time.Now().UTC().Add(-streamSettle)
+	}
+
+	controller := http.NewResponseController(w)
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	// Keeps nginx from buffering the stream
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	fmt.Fprintf(w, "retry: %d\n\n", streamRetry)
+	if err := controller.Flush(); err != nil {
+		log.Printf("Event stream for %s can't be flushed: %v", userID, err)
+		return
+	}
+	log.Printf("%s subscribed to %s", userID, streamName(query.Types))
+	defer log.Printf("%s unsubscribed from %s", userID, streamName(query.Types))
+
+	ticker := time.NewTicker(streamPollInterval)
+	defer ticker.Stop()
+	lastWrite := time.Now()
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case <-ticker.C:
+		}
+
+		query.To = time.Now().UTC().Add(-streamSettle)
+		sent := false
+		err := store.ReplayEvents(r.Context(), h.store, query, func(event store.Event) error {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+			if _, err := fmt.Fprintf(w, "id: %s,%s\nevent: %s\ndata: %s\n\n",
+				event.Time.UTC().Format(time.RFC3339Nano), event.ID, event.Type, data); err != nil {
+				return err
+			}
+			query.AfterTime, query.AfterID = event.Time, event.ID
+			sent = true
+			return nil
+		})
+		if err != nil {
+			if r.Context().Err() == nil {
+				log.Printf("Event stream for %s failed: %v", userID, err)
+			}
+			return
+		}
+		if !sent && time.Since(lastWrite) >= streamKeepAlive {
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+			sent = true
+		}
+		if !sent {
+			continue
+		}
+		if err := controller.Flush(); err != nil {
+			return
+		}
+		lastWrite = time.Now()
+	}
+}
+
+// streamName describes the events a stream carries
+func streamName(types []string) string {
+	if len(types) == 0 {
+		return "all events"
+	}
+	return strings.Join(types, ", ") + " events"
+}
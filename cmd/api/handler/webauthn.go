@@ -0,0 +1,297 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+	"github.com/petermein/apollo/internal/webauthn"
+)
+
+// webAuthnError marks an approval that wasn't confirmed with a valid
+// hardware key assertion
+type webAuthnError struct {
+	message string
+}
+
+func (e *webAuthnError) Error() string {
+	return e.message
+}
+
+// webAuthnResponse is the assertion the dashboard got from
+// navigator.credentials.get, with binary values base64url encoded
+type webAuthnResponse struct {
+	CredentialID      string `json:"credential_id"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AuthenticatorData string `json:"authenticator_data"`
+	Signature         string `json:"signature"`
+}
+
+// SetWebAuthn configures the relying party approvers confirm approvals of
+// requests that need a hardware key with
+func (h *Handler) SetWebAuthn(rp *webauthn.RelyingParty) {
+	h.webauthn = rp
+}
+
+// approvalSubject is what the challenge to approve a request is issued for
+func approvalSubject(requestID string) string {
+	return "approve " + requestID
+}
+
+// verifyApprovalAssertion checks that the approver confirmed the approval of
+// the request with one of their hardware keys, and returns the assertion to
+// record with the approval
+func (h *Handler) verifyApprovalAssertion(ctx context.Context, request *models.PrivilegeRequest, approver *models.Approver, response *webAuthnResponse) (*models.WebAuthnAssertion, error) {
+	if h.webauthn == nil {
+		return nil, &webAuthnError{"approving this request requires a hardware key, but WebAuthn is not configured"}
+	}
+	if response == nil {
+		return nil, &webAuthnError{"approving this request requires confirming with a hardware key from the dashboard"}
+	}
+	credentialID, errID := webauthn.Decode(response.CredentialID)
+	clientData, errClient := webauthn.Decode(response.ClientDataJSON)
+	authenticatorData, errAuth := webauthn.Decode(response.AuthenticatorData)
+	signature, errSig := webauthn.Decode(response.Signature)
+	if err := errors.Join(errID, errClient, errAuth, errSig); err != nil {
+		return nil, &webAuthnError{fmt.Sprintf("invalid hardware key assertion: %v", err)}
+	}
+
+	credentials, err := h.store.ListWebAuthnCredentials(ctx, approver.ID)
+	if err != nil {
+		return nil, err
+	}
+	var credential *store.WebAuthnCredential
+	for _, registered := range credentials {
+		if bytes.Equal(registered.CredentialID, credentialID) {
+			credential = registered
+			break
+		}
+	}
+	if credential == nil {
+		return nil, &webAuthnError{fmt.Sprintf("hardware key is not registered to %s", approver.ID)}
+	}
+
+	now := time.Now()
+	assertion, err := h.webauthn.VerifyAssertion(&webauthn.Credential{
+		ID:        credential.CredentialID,
+		PublicKey: credential.PublicKey,
+		SignCount: credential.SignCount,
+	}, approver.ID, approvalSubject(request.ID), clientData, authenticatorData, signature, now)
+	if errors.Is(err, webauthn.ErrSignCount) {
+		log.Printf("Hardware key %s of %s reported a stale signature counter; it may have been cloned", credential.ID, approver.ID)
+	}
+	if err != nil {
+		return nil, &webAuthnError{fmt.Sprintf("invalid hardware key assertion: %v", err)}
+	}
+	// A concurrent approval with the same assertion loses the race here
+	err = h.store.UseWebAuthnCredential(ctx, credential.ID, assertion.SignCount, now)
+	if errors.Is(err, store.ErrStaleSignCount) || errors.Is(err, store.ErrNotFound) {
+		return nil, &webAuthnError{"hardware key assertion was already used"}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &models.WebAuthnAssertion{
+		CredentialID:      webauthn.Encode(credentialID),
+		AuthenticatorData: webauthn.Encode(authenticatorData),
+		ClientDataJSON:    webauthn.Encode(clientData),
+		Signature:         webauthn.Encode(signature),
+		SignCount:         assertion.SignCount,
+		UserVerified:      assertion.UserVerified,
+	}, nil
+}
+
+// credentialIDs returns the IDs the authenticators assigned to the credentials
+func credentialIDs(credentials []*store.WebAuthnCredential) [][]byte {
+	ids := make([][]byte, 0, len(credentials))
+	for _, credential := range credentials {
+		ids = append(ids, credential.CredentialID)
+	}
+	return ids
+}
+
+// handleApprovalOptions returns the options for the approver to confirm the
+// approval of a request with one of their hardware keys
+func (h *Handler) handleApprovalOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	if h.webauthn == nil {
+		http.Error(w, "WebAuthn is not configured", http.StatusNotFound)
+		return
+	}
+
+	request, err := h.store.GetRequest(r.Context(), r.PathValue("id"))
+	if err != nil {
+		writeRuleError(w, err)
+		return
+	}
+	credentials, err := h.store.ListWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if len(credentials) == 0 {
+		http.Error(w, "Register a hardware key before approving with one", http.StatusConflict)
+		return
+	}
+	challenge, err := h.webauthn.NewChallenge(userID, approvalSubject(request.ID), time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.webauthn.RequestOptions(challenge, credentialIDs(credentials)))
+}
+
+// registerWebAuthnRequest registers a hardware key
+type registerWebAuthnRequest struct {
+	Name              string `json:"name"`
+	ClientDataJSON    string `json:"client_data_json"`
+	AttestationObject string `json:"attestation_object"`
+}
+
+// handleWebAuthnCredentials lists the hardware keys the user registered on
+// GET and registers a new one on POST
+func (h *Handler) handleWebAuthnCredentials(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	if h.webauthn == nil {
+		http.Error(w, "WebAuthn is not configured", http.StatusNotFound)
+		return
+	}
+
+	credentials, err := h.store.ListWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	if r.Method == http.MethodGet {
+		if credentials == nil {
+			credentials = []*store.WebAuthnCredential{}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(credentials)
+		return
+	}
+
+	var req registerWebAuthnRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 64<<10)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		return
+	}
+	clientData, errClient := webauthn.Decode(req.ClientDataJSON)
+	attestation, errAttestation := webauthn.Decode(req.AttestationObject)
+	if err := errors.Join(errClient, errAttestation); err != nil {
+		http.Error(w, fmt.Sprintf("Invalid registration: %v", err), http.StatusBadRequest)
+		return
+	}
+	registered, err := h.webauthn.Register(userID, clientData, attestation, time.Now())
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Invalid registration: %v", err), http.StatusBadRequest)
+		return
+	}
+	for _, credential := range credentials {
+		if bytes.Equal(credential.CredentialID, registered.ID) {
+			http.Error(w, "Hardware key is already registered", http.StatusConflict)
+			return
+		}
+	}
+	if req.Name == "" {
+		req.Name = "Hardware key"
+	}
+
+	credential := &store.WebAuthnCredential{
+		UserID:       userID,
+		Name:         req.Name,
+		CredentialID: registered.ID,
+		PublicKey:    registered.PublicKey,
+		SignCount:    registered.SignCount,
+	}
+	if err := h.store.CreateWebAuthnCredential(r.Context(), credential); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Registered hardware key %s (%s) for %s", credential.ID, credential.Name, userID)
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	json.NewEncoder(w).Encode(credential)
+}
+
+// handleWebAuthnCredentialOptions returns the options for the user to
+// register a new hardware key with
+func (h *Handler) handleWebAuthnCredentialOptions(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+	if h.webauthn == nil {
+		http.Error(w, "WebAuthn is not configured", http.StatusNotFound)
+		return
+	}
+
+	credentials, err := h.store.ListWebAuthnCredentials(r.Context(), userID)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	challenge, err := h.webauthn.NewRegistrationChallenge(userID, time.Now())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(h.webauthn.CreationOptions(userID, challenge, credentialIDs(credentials)))
+}
+
+// handleDeleteWebAuthnCredential removes one of the user's hardware keys
+func (h *Handler) handleDeleteWebAuthnCredential(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodDelete {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	userID := r.Header.Get("X-Apollo-User")
+	if userID == "" {
+		http.Error(w, "User is required", http.StatusUnauthorized)
+		return
+	}
+
+	err := h.store.DeleteWebAuthnCredential(r.Context(), userID, r.PathValue("id"))
+	if errors.Is(err, store.ErrNotFound) {
+		http.Error(w, "Hardware key not found", http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	log.Printf("Removed hardware key %s of %s", r.PathValue("id"), userID)
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,104 @@
+package mattermost
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Button actions
+const (
+	ActionApprove = "approve"
+	ActionDeny    = "deny"
+)
+
+// ErrInvalidAction is returned for button clicks on messages Apollo didn't post
+var ErrInvalidAction = errors.New("invalid mattermost action")
+
+// Actions signs the context of approve and deny buttons, so the action
+// endpoint only acts on clicks of buttons Apollo posted. The approver is the
+// Mattermost user who clicked.
+type Actions struct {
+	key []byte
+	url string
+}
+
+// NewActions creates buttons signed with the key that post clicks to the
+// API at baseURL
+func NewActions(key []byte, baseURL string) (*Actions, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("action key must be at least 32 bytes")
+	}
+	if _, err := url.ParseRequestURI(baseURL); err != nil {
+		return nil, fmt.Errorf("invalid mattermost actions base URL %q", baseURL)
+	}
+	return &Actions{key: key, url: strings.TrimSuffix(baseURL, "/") + "/api/v1/mattermost/actions"}, nil
+}
+
+// ActionRequest is what Mattermost posts when a button is clicked
+type ActionRequest struct {
+	UserID   string            `json:"user_id"`
+	UserName string            `json:"user_name"`
+	PostID   string            `json:"post_id"`
+	Context  map[string]string `json:"context"`
+}
+
+// ActionResponse answers a button click, replacing the post's buttons with
+// the outcome or telling only the user who clicked why nothing happened
+type ActionResponse struct {
+	Update        *PostUpdate `json:"update,omitempty"`
+	EphemeralText string      `json:"ephemeral_text,omitempty"`
+}
+
+// PostUpdate replaces the message and properties of a post
+type PostUpdate struct {
+	Message string                 `json:"message"`
+	Props   map[string]interface{} `json:"props"`
+}
+
+// Resolve returns the request and action of a signed button click
+func (a *Actions) Resolve(click *ActionRequest) (string, string, error) {
+	requestID, action := click.Context["request_id"], click.Context["action"]
+	signature, err := base64.RawURLEncoding.DecodeString(click.Context["signature"])
+	if err != nil || !hmac.Equal(signature, a.sign(requestID, action)) {
+		return "", "", ErrInvalidAction
+	}
+	if action != ActionApprove && action != ActionDeny {
+		return "", "", ErrInvalidAction
+	}
+	return requestID, action, nil
+}
+
+// buttons returns the approve and deny buttons of a request
+func (a *Actions) buttons(requestID string) []Button {
+	button := func(action, name, style string) Button {
+		return Button{
+			ID:    action,
+			Name:  name,
+			Style: style,
+			Integration: Integration{
+				URL: a.url,
+				Context: map[string]string{
+					"request_id": requestID,
+					"action":     action,
+					"signature":  base64.RawURLEncoding.EncodeToString(a.sign(requestID, action)),
+				},
+			},
+		}
+	}
+	return []Button{
+		button(ActionApprove, "Approve", "success"),
+		button(ActionDeny, "Deny", "danger"),
+	}
+}
+
+// sign returns the signature of a button's context
+func (a *Actions) sign(requestID, action string) []byte {
+	mac := hmac.New(sha256.New, a.key)
+	mac.Write([]byte("apollo-mattermost-action\n" + requestID + "\n" + action))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,94 @@
+// Package mattermost posts request lifecycle events to Mattermost through an
+// incoming webhook, with buttons to approve or deny new requests
+package mattermost
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Message is a post to an incoming webhook
+type Message struct {
+	// Channel overrides the webhook's channel, which the webhook must allow
+	Channel     string       `json:"channel,omitempty"`
+	Text        string       `json:"text"`
+	Attachments []Attachment `json:"attachments,omitempty"`
+}
+
+// Attachment holds the buttons of a message
+type Attachment struct {
+	Text    string   `json:"text,omitempty"`
+	Color   string   `json:"color,omitempty"`
+	Actions []Button `json:"actions,omitempty"`
+}
+
+// Button is an interactive message button. Mattermost posts the integration's
+// context to its URL when the button is clicked and never shows the context
+// to users.
+type Button struct {
+	ID          string      `json:"id"`
+	Name        string      `json:"name"`
+	Style       string      `json:"style,omitempty"`
+	Integration Integration `json:"integration"`
+}
+
+// Integration is where a button click is posted to
+type Integration struct {
+	URL     string            `json:"url"`
+	Context map[string]string `json:"context"`
+}
+
+// Client posts messages to an incoming webhook
+type Client struct {
+	webhookURL string
+	client     *http.Client
+}
+
+// NewClient creates a client posting to the incoming webhook at webhookURL
+func NewClient(webhookURL string) (*Client, error) {
+	if _, err := url.ParseRequestURI(webhookURL); err != nil {
+		return nil, fmt.Errorf("invalid mattermost webhook URL")
+	}
+	return &Client{
+		webhookURL: webhookURL,
+		client:     &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Post posts a message
+func (c *Client) Post(ctx context.Context, message Message) error {
+	body, err := json.Marshal(message)
+	if err != nil {
+		return fmt.Errorf("failed to marshal message: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.webhookURL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		// The error names the URL, which holds the webhook's key
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			err = urlErr.Err
+		}
+		return fmt.Errorf("failed to post to mattermost: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("mattermost returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	return nil
+}
@@ -0,0 +1,232 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/simulate"
+)
+
+// Config represents the mock module configuration
+type Config struct {
+	// Servers are the simulated servers users can request access to
+	Servers   []modules.ServerInfo
+	Simulator simulate.Config
+}
+
+// Module implements a module for a simulated target system. Its servers are
+// registered from the configuration, pings take the configured latency and
+// fail at the configured rate, and the operator's mock module provisions and
+// revokes grants the same way. It needs no database or cluster, for demos
+// and for exercising the pipeline end to end.
+type Module struct {
+	config *Config
+	store  store.Store
+}
+
+// NewModule creates a new mock module
+func NewModule() *Module {
+	return &Module{}
+}
+
+// SetStore configures where the module keeps its servers and operators
+func (m *Module) SetStore(s store.Store) {
+	m.store = s
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "mock"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Simulated target system for demos and testing without real servers"
+}
+
+// Initialize registers the configured servers
+func (m *Module) Initialize(config interface{}) error {
+	log.Printf("Initializing mock module...")
+
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for _, server := range cfg.Servers {
+		if err := m.store.RegisterServer(ctx, m.Name(), server); err != nil {
+			return fmt.Errorf("failed to register server %s: %v", server.Name, err)
+		}
+	}
+
+	log.Printf("Mock module initialized with %d servers (latency %s±%s, failure rate %.0f%%)",
+		len(cfg.Servers), cfg.Simulator.Latency, cfg.Simulator.Jitter, cfg.Simulator.FailureRate*100)
+	return nil
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found. Servers are names or maps with a name,
+// tags and a region.
+func parseConfig(config interface{}) (*Config, error) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for mock module")
+	}
+
+	cfg := &Config{}
+	var errs []error
+	simulator, err := simulate.Parse(configMap)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.Simulator = simulator
+
+	servers, _ := configMap["servers"].([]interface{})
+	seen := make(map[string]bool)
+	for i, entry := range servers {
+		var server modules.ServerInfo
+		switch entry := entry.(type) {
+		case string:
+			server.Name = entry
+		case map[string]interface{}:
+			server.Name, _ = entry["name"].(string)
+			server.Region, _ = entry["region"].(string)
+			if tags, ok := entry["tags"].([]interface{}); ok {
+				for _, tag := range tags {
+					if s, ok := tag.(string); ok {
+						server.Tags = append(server.Tags, s)
+					}
+				}
+			}
+		}
+		if server.Name == "" {
+			errs = append(errs, fmt.Errorf("servers[%d]: name is required", i))
+			continue
+		}
+		if seen[server.Name] {
+			errs = append(errs, fmt.Errorf("servers[%d]: duplicate server %s", i, server.Name))
+			continue
+		}
+		seen[server.Name] = true
+		server.Host = server.Name + ".mock"
+		server.Database = "mock"
+		cfg.Servers = append(cfg.Servers, server)
+	}
+	if len(cfg.Servers) == 0 {
+		errs = append(errs, fmt.Errorf("servers is required"))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// HandlePingRequest pings a simulated server
+func (m *Module) HandlePingRequest(ctx context.Context, request *modules.PingRequest) (string, error) {
+	if m.store == nil || m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	servers, err := m.store.ListServers(ctx, m.Name(), store.ServerFilter{Status: "active"})
+	if err != nil {
+		return "", err
+	}
+	for _, server := range servers {
+		if server.Name != request.Server || server.Status != "active" {
+			continue
+		}
+		took, err := m.config.Simulator.Run(ctx, "ping "+server.Name)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("pong from %s in %s", server.Host, took.Round(time.Millisecond)), nil
+	}
+	return "", fmt.Errorf("server %s is not registered", request.Server)
+}
+
+// HealthCheck reports the module healthy once it is initialized; the
+// simulated servers are always reachable
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.store == nil || m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// ListServers returns the simulated servers
+func (m *Module) ListServers(ctx context.Context) ([]modules.ServerInfo, error) {
+	if m.store == nil {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	return m.store.ListServers(ctx, m.Name(), store.ServerFilter{Status: "active"})
+}
+
+// RegisterOperator registers a new operator running in the given region
+func (m *Module) RegisterOperator(ctx context.Context, id, region string) error {
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
+	}
+
+	return m.store.RegisterOperator(ctx, id, region)
+}
+
+// UpdateOperatorHealth updates the health status of an operator and its modules
+func (m *Module) UpdateOperatorHealth(ctx context.Context, id string, timestamp time.Time, health []modules.ModuleHealth) error {
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
+	}
+
+	if err := m.store.UpdateOperatorHealth(ctx, id, timestamp, health); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			return fmt.Errorf("operator not found: %s", id)
+		}
+		return err
+	}
+	return nil
+}
+
+// MarkOperatorInactive marks an operator as inactive
+func (m *Module) MarkOperatorInactive(ctx context.Context, id string) error {
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
+	}
+
+	return m.store.MarkOperatorInactive(ctx, id)
+}
+
+// GetInactiveOperators returns a list of operators that haven't sent a health check in the last timeout period
+func (m *Module) GetInactiveOperators(ctx context.Context, timeout time.Duration) ([]string, error) {
+	if m.store == nil {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	return m.store.StaleOperators(ctx, time.Now().UTC().Add(-timeout))
+}
+
+// ListOperators returns a list of registered operators
+func (m *Module) ListOperators(ctx context.Context) ([]modules.OperatorInfo, error) {
+	if m.store == nil {
+		return nil, fmt.Errorf("store not initialized")
+	}
+
+	return m.store.ListOperators(ctx, store.OperatorFilter{})
+}
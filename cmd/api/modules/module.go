@@ -14,6 +14,10 @@ type ServerInfo struct {
 	User     string `json:"user"`
 	Database string `json:"database"`
 	Status   string `json:"status"`
+	// Tags classifies the data held by the server, e.g. pii, pci or gdpr
+	Tags []string `json:"tags,omitempty"`
+	// Region is the region the server runs in
+	Region string `json:"region,omitempty"`
 }
 
 // OperatorInfo represents information about an operator
@@ -23,6 +27,17 @@ type OperatorInfo struct {
 	LastSeen  time.Time `json:"last_seen"`
 	CreatedAt time.Time `json:"created_at"`
 	UpdatedAt time.Time `json:"updated_at"`
+	// Region is the region the operator runs in
+	Region string `json:"region,omitempty"`
+	// Modules is the health of the operator's modules as of its last health check
+	Modules []ModuleHealth `json:"modules,omitempty"`
+}
+
+// ModuleHealth is the outcome of a module's health check on an operator
+type ModuleHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
 }
 
 // Module represents a module that can be registered with the API
@@ -49,11 +64,26 @@ type Module interface {
 	ListOperators(ctx context.Context) ([]OperatorInfo, error)
 }
 
+// ConfigValidator is implemented by modules that can check their
+// configuration without connecting to anything
+type ConfigValidator interface {
+	// ValidateConfig returns every problem with the configuration
+	ValidateConfig(config interface{}) error
+}
+
 // PingRequest represents a ping request
 type PingRequest struct {
 	Server string `json:"server"`
 }
 
+// RevokeRequest asks the operator of a module to remove an ended grant from its target
+type RevokeRequest struct {
+	GrantID    string `json:"grant_id"`
+	UserID     string `json:"user_id"`
+	ResourceID string `json:"resource_id"`
+	Level      string `json:"level"`
+}
+
 // Registry manages a collection of modules
 type Registry struct {
 	modules map[string]Module
@@ -3,12 +3,14 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"log"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/store"
 )
 
 // Config represents the MySQL module configuration
@@ -26,6 +28,9 @@ type Config struct {
 type Module struct {
 	config *Config
 	db     *sql.DB
+	store  store.Store
+	// fake answers pings from the store instead of a database, for apollo dev
+	fake bool
 }
 
 // NewModule creates a new MySQL module
@@ -33,6 +38,18 @@ func NewModule() *Module {
 	return &Module{}
 }
 
+// NewFakeModule creates a MySQL module that needs no database: servers are
+// kept in the store as usual, and pings of registered servers are answered
+// without connecting to them
+func NewFakeModule() *Module {
+	return &Module{fake: true}
+}
+
+// SetStore configures where the module keeps its servers and operators
+func (m *Module) SetStore(s store.Store) {
+	m.store = s
+}
+
 // Name returns the module name
 func (m *Module) Name() string {
 	return "mysql"
@@ -46,66 +63,22 @@ func (m *Module) Description() string {
 // Initialize initializes the MySQL module
 func (m *Module) Initialize(config interface{}) error {
 	log.Printf("Initializing MySQL module...")
-
-	// Convert config map to our Config struct
-	configMap, ok := config.(map[string]interface{})
-	if !ok {
-		return fmt.Errorf("invalid config type for MySQL module")
-	}
-
-	cfg := &Config{}
-
-	// Extract values from the map
-	if host, ok := configMap["host"].(string); ok {
-		cfg.Host = host
-	}
-	if port, ok := configMap["port"].(int); ok {
-		cfg.Port = port
-	}
-	if user, ok := configMap["user"].(string); ok {
-		cfg.User = user
-	}
-	if password, ok := configMap["password"].(string); ok {
-		cfg.Password = password
-	}
-	if maxConn, ok := configMap["max_connections"].(int); ok {
-		cfg.MaxConnections = maxConn
-	}
-	if connTimeout, ok := configMap["connection_timeout"].(string); ok {
-		cfg.ConnectionTimeout = connTimeout
-	}
-	if idleTimeout, ok := configMap["idle_timeout"].(string); ok {
-		cfg.IdleTimeout = idleTimeout
+	if m.fake {
+		log.Printf("MySQL module is fake; no database is connected")
+		return nil
 	}
 
-	// Validate required fields
-	if cfg.Host == "" {
-		return fmt.Errorf("host is required")
-	}
-	if cfg.Port == 0 {
-		return fmt.Errorf("port is required")
-	}
-	if cfg.User == "" {
-		return fmt.Errorf("user is required")
-	}
-	if cfg.Password == "" {
-		return fmt.Errorf("password is required")
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
 	}
-
 	m.config = cfg
 
 	log.Printf("MySQL configuration loaded: host=%s:%d, user=%s, maxConn=%d", cfg.Host, cfg.Port, cfg.User, cfg.MaxConnections)
 
-	// Parse timeouts
-	connTimeout, err := time.ParseDuration(cfg.ConnectionTimeout)
-	if err != nil {
-		return fmt.Errorf("invalid connection timeout: %v", err)
-	}
-
-	idleTimeout, err := time.ParseDuration(cfg.IdleTimeout)
-	if err != nil {
-		return fmt.Errorf("invalid idle timeout: %v", err)
-	}
+	// The timeouts were checked when parsing
+	connTimeout, _ := time.ParseDuration(cfg.ConnectionTimeout)
+	idleTimeout, _ := time.ParseDuration(cfg.IdleTimeout)
 
 	// Create DSN for initial connection
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s",
@@ -156,54 +129,84 @@ func (m *Module) Initialize(config interface{}) error {
 	db.SetMaxIdleConns(cfg.MaxConnections)
 	db.SetConnMaxLifetime(idleTimeout)
 
-	// Create tables
-	log.Printf("Creating required tables...")
-	if err := m.createTables(db); err != nil {
-		return fmt.Errorf("failed to create tables: %v", err)
-	}
-
 	m.db = db
 	log.Printf("MySQL module initialized successfully")
 	return nil
 }
 
-// createTables creates the necessary tables for storing server information
-func (m *Module) createTables(db *sql.DB) error {
-	// Create mysql_servers table
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS mysql_servers (
-			name VARCHAR(255) PRIMARY KEY,
-			host VARCHAR(255) NOT NULL,
-			port INT NOT NULL,
-			user VARCHAR(255) NOT NULL,
-			db_name VARCHAR(255) NOT NULL,
-			status VARCHAR(50) NOT NULL DEFAULT 'inactive',
-			last_seen TIMESTAMP NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-		)
-	`); err != nil {
-		return fmt.Errorf("failed to create mysql_servers table: %v", err)
-	}
-
-	// Create operators table
-	if _, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS operators (
-			id VARCHAR(255) PRIMARY KEY,
-			status VARCHAR(50) NOT NULL DEFAULT 'active',
-			last_seen TIMESTAMP NULL,
-			created_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
-			updated_at TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP
-		)
-	`); err != nil {
-		return fmt.Errorf("failed to create operators table: %v", err)
+// ValidateConfig checks the module's configuration without connecting to the database
+func (m *Module) ValidateConfig(config interface{}) error {
+	if m.fake {
+		return nil
 	}
+	_, err := parseConfig(config)
+	return err
+}
 
-	return nil
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found
+func parseConfig(config interface{}) (*Config, error) {
+	// Convert config map to our Config struct
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for MySQL module")
+	}
+
+	cfg := &Config{}
+
+	// Extract values from the map
+	if host, ok := configMap["host"].(string); ok {
+		cfg.Host = host
+	}
+	if port, ok := configMap["port"].(int); ok {
+		cfg.Port = port
+	}
+	if user, ok := configMap["user"].(string); ok {
+		cfg.User = user
+	}
+	if password, ok := configMap["password"].(string); ok {
+		cfg.Password = password
+	}
+	if maxConn, ok := configMap["max_connections"].(int); ok {
+		cfg.MaxConnections = maxConn
+	}
+	if connTimeout, ok := configMap["connection_timeout"].(string); ok {
+		cfg.ConnectionTimeout = connTimeout
+	}
+	if idleTimeout, ok := configMap["idle_timeout"].(string); ok {
+		cfg.IdleTimeout = idleTimeout
+	}
+
+	var errs []error
+	if cfg.Host == "" {
+		errs = append(errs, fmt.Errorf("host is required"))
+	}
+	if cfg.Port == 0 {
+		errs = append(errs, fmt.Errorf("port is required"))
+	}
+	if cfg.User == "" {
+		errs = append(errs, fmt.Errorf("user is required"))
+	}
+	if cfg.Password == "" {
+		errs = append(errs, fmt.Errorf("password is required"))
+	}
+	if _, err := time.ParseDuration(cfg.ConnectionTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid connection timeout: %v", err))
+	}
+	if _, err := time.ParseDuration(cfg.IdleTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid idle timeout: %v", err))
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
 }
 
 // HandlePingRequest handles a MySQL ping request
 func (m *Module) HandlePingRequest(ctx context.Context, request *modules.PingRequest) (string, error) {
+	if m.fake {
+		return m.fakePing(ctx, request.Server)
+	}
 	if m.db == nil {
 		return "", fmt.Errorf("database not initialized")
 	}
@@ -220,6 +223,9 @@ func (m *Module) HandlePingRequest(ctx context.Context, request *modules.PingReq
 
 // HealthCheck performs a health check on the MySQL module
 func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.fake {
+		return nil
+	}
 	if m.db == nil {
 		return fmt.Errorf("database not initialized")
 	}
@@ -229,244 +235,120 @@ func (m *Module) HealthCheck(ctx context.Context) error {
 
 // ListServers returns a list of registered MySQL servers
 func (m *Module) ListServers(ctx context.Context) ([]modules.ServerInfo, error) {
-	if m.db == nil {
-		return nil, fmt.Errorf("database not initialized")
-	}
-
-	rows, err := m.db.QueryContext(ctx, `
-		SELECT name, host, port, user, db_name, status
-		FROM mysql_servers
-		WHERE status = 'active'
-	`)
-	if err != nil {
-		return nil, fmt.Errorf("failed to query servers: %v", err)
+	if m.store == nil {
+		return nil, fmt.Errorf("store not initialized")
 	}
-	defer rows.Close()
 
-	var servers []modules.ServerInfo
-	for rows.Next() {
-		var server modules.ServerInfo
-		if err := rows.Scan(&server.Name, &server.Host, &server.Port, &server.User, &server.Database, &server.Status); err != nil {
-			return nil, fmt.Errorf("failed to scan server: %v", err)
-		}
-		servers = append(servers, server)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating servers: %v", err)
-	}
-
-	return servers, nil
+	return m.store.ListServers(ctx, m.Name(), store.ServerFilter{Status: "active"})
 }
 
 // RegisterServer registers a new MySQL server
 func (m *Module) RegisterServer(ctx context.Context, server modules.ServerInfo) error {
-	if m.db == nil {
-		return fmt.Errorf("database not initialized")
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
 	}
 
-	_, err := m.db.ExecContext(ctx, `
-		INSERT INTO mysql_servers (name, host, port, user, db_name, status, last_seen)
-		VALUES (?, ?, ?, ?, ?, 'active', CURRENT_TIMESTAMP)
-		ON DUPLICATE KEY UPDATE
-			host = VALUES(host),
-			port = VALUES(port),
-			user = VALUES(user),
-			db_name = VALUES(db_name),
-			status = 'active',
-			last_seen = CURRENT_TIMESTAMP
-	`, server.Name, server.Host, server.Port, server.User, server.Database)
-
-	return err
+	return m.store.RegisterServer(ctx, m.Name(), server)
 }
 
 // MarkServerInactive marks a MySQL server as inactive
 func (m *Module) MarkServerInactive(ctx context.Context, name string) error {
-	if m.db == nil {
-		return fmt.Errorf("database not initialized")
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
 	}
 
-	_, err := m.db.ExecContext(ctx, `
-		UPDATE mysql_servers
-		SET status = 'inactive'
-		WHERE name = ?
-	`, name)
-
-	return err
+	return m.store.MarkServerInactive(ctx, m.Name(), name)
 }
 
-// RegisterOperator registers a new operator
-func (m *Module) RegisterOperator(ctx context.Context, id string) error {
-	log.Printf("Registering operator with ID: %s", id)
+// RegisterOperator registers a new operator running in the given region
+func (m *Module) RegisterOperator(ctx context.Context, id, region string) error {
+	log.Printf("Registering operator with ID: %s (region %q)", id, region)
 
-	if m.db == nil {
-		return fmt.Errorf("database not initialized")
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
 	}
 
-	result, err := m.db.ExecContext(ctx, `
-		INSERT INTO operators (id, status, last_seen)
-		VALUES (?, 'active', CURRENT_TIMESTAMP)
-		ON DUPLICATE KEY UPDATE
-			status = 'active',
-			last_seen = CURRENT_TIMESTAMP
-	`, id)
-
-	if err != nil {
+	if err := m.store.RegisterOperator(ctx, id, region); err != nil {
 		log.Printf("Error registering operator %s: %v", id, err)
 		return err
 	}
 
-	affected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected for operator %s: %v", id, err)
-		return err
-	}
-
-	log.Printf("Successfully registered operator %s (rows affected: %d)", id, affected)
+	log.Printf("Successfully registered operator %s", id)
 	return nil
 }
 
-// UpdateOperatorHealth updates the health status of an operator
-func (m *Module) UpdateOperatorHealth(ctx context.Context, id string, timestamp time.Time) error {
+// UpdateOperatorHealth updates the health status of an operator and its modules
+func (m *Module) UpdateOperatorHealth(ctx context.Context, id string, timestamp time.Time, health []modules.ModuleHealth) error {
 	log.Printf("Updating health for operator %s (timestamp: %s)", id, timestamp)
 
-	if m.db == nil {
-		return fmt.Errorf("database not initialized")
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
 	}
 
-	result, err := m.db.ExecContext(ctx, `
-		UPDATE operators
-		SET status = 'active',
-			last_seen = ?
-		WHERE id = ?
-	`, timestamp, id)
-
-	if err != nil {
+	if err := m.store.UpdateOperatorHealth(ctx, id, timestamp, health); err != nil {
+		if errors.Is(err, store.ErrNotFound) {
+			log.Printf("No operator found with ID %s for health update", id)
+			return fmt.Errorf("operator not found: %s", id)
+		}
 		log.Printf("Error updating operator health for %s: %v", id, err)
 		return err
 	}
 
-	affected, err := result.RowsAffected()
-	if err != nil {
-		log.Printf("Error getting rows affected for operator %s health update: %v", id, err)
-		return err
-	}
-
-	if affected == 0 {
-		log.Printf("No operator found with ID %s for health update", id)
-		return fmt.Errorf("operator not found: %s", id)
-	}
-
 	log.Printf("Successfully updated health for operator %s", id)
 	return nil
 }
 
 // MarkOperatorInactive marks an operator as inactive
 func (m *Module) MarkOperatorInactive(ctx context.Context, id string) error {
-	if m.db == nil {
-		return fmt.Errorf("database not initialized")
+	if m.store == nil {
+		return fmt.Errorf("store not initialized")
 	}
 
-	_, err := m.db.ExecContext(ctx, `
-		UPDATE operators
-		SET status = 'inactive'
-		WHERE id = ?
-	`, id)
-
-	return err
+	return m.store.MarkOperatorInactive(ctx, id)
 }
 
 // GetInactiveOperators returns a list of operators that haven't sent a health check in the last timeout period
 func (m *Module) GetInactiveOperators(ctx context.Context, timeout time.Duration) ([]string, error) {
-	if m.db == nil {
-		return nil, fmt.Errorf("database not initialized")
-	}
-
-	rows, err := m.db.QueryContext(ctx, `
-		SELECT id
-		FROM operators
-		WHERE status = 'active'
-		AND last_seen < DATE_SUB(NOW(), INTERVAL ? SECOND)
-	`, timeout.Seconds())
-	if err != nil {
-		return nil, fmt.Errorf("failed to query inactive operators: %v", err)
+	if m.store == nil {
+		return nil, fmt.Errorf("store not initialized")
 	}
-	defer rows.Close()
 
-	var ids []string
-	for rows.Next() {
-		var id string
-		if err := rows.Scan(&id); err != nil {
-			return nil, fmt.Errorf("failed to scan operator ID: %v", err)
-		}
-		ids = append(ids, id)
-	}
-
-	if err := rows.Err(); err != nil {
-		return nil, fmt.Errorf("error iterating inactive operators: %v", err)
-	}
-
-	return ids, nil
+	return m.store.StaleOperators(ctx, time.Now().UTC().Add(-timeout))
 }
 
 // ListOperators returns a list of registered operators
 func (m *Module) ListOperators(ctx context.Context) ([]modules.OperatorInfo, error) {
-	log.Printf("Listing operators from database...")
+	log.Printf("Listing operators from store...")
 
-	if m.db == nil {
-		return nil, fmt.Errorf("database not initialized")
+	if m.store == nil {
+		return nil, fmt.Errorf("store not initialized")
 	}
 
-	rows, err := m.db.QueryContext(ctx, `
-		SELECT id, status, 
-		       COALESCE(last_seen, '0001-01-01 00:00:00') as last_seen,
-		       COALESCE(created_at, '0001-01-01 00:00:00') as created_at,
-		       COALESCE(updated_at, '0001-01-01 00:00:00') as updated_at
-		FROM operators
-		ORDER BY created_at DESC
-	`)
+	operators, err := m.store.ListOperators(ctx, store.OperatorFilter{})
 	if err != nil {
-		log.Printf("Error querying operators: %v", err)
-		return nil, fmt.Errorf("failed to query operators: %v", err)
-	}
-	defer rows.Close()
-
-	var operators []modules.OperatorInfo
-	for rows.Next() {
-		var op modules.OperatorInfo
-		var lastSeen, createdAt, updatedAt string
-		if err := rows.Scan(&op.ID, &op.Status, &lastSeen, &createdAt, &updatedAt); err != nil {
-			log.Printf("Error scanning operator row: %v", err)
-			return nil, fmt.Errorf("failed to scan operator: %v", err)
-		}
-
-		// Parse timestamps
-		op.LastSeen, err = time.Parse("2006-01-02 15:04:05", lastSeen)
-		if err != nil {
-			log.Printf("Error parsing last_seen timestamp: %v", err)
-			return nil, fmt.Errorf("failed to parse last_seen timestamp: %v", err)
-		}
-
-		op.CreatedAt, err = time.Parse("2006-01-02 15:04:05", createdAt)
-		if err != nil {
-			log.Printf("Error parsing created_at timestamp: %v", err)
-			return nil, fmt.Errorf("failed to parse created_at timestamp: %v", err)
-		}
+		log.Printf("Error listing operators: %v", err)
+		return nil, err
+	}
 
-		op.UpdatedAt, err = time.Parse("2006-01-02 15:04:05", updatedAt)
-		if err != nil {
-			log.Printf("Error parsing updated_at timestamp: %v", err)
-			return nil, fmt.Errorf("failed to parse updated_at timestamp: %v", err)
-		}
+	log.Printf("Found %d operators in store", len(operators))
+	return operators, nil
+}
 
-		operators = append(operators, op)
+// fakePing answers a ping of a fake module with the host of the server, as
+// a database reports its hostname
+func (m *Module) fakePing(ctx context.Context, name string) (string, error) {
+	if m.store == nil {
+		return "", fmt.Errorf("store not initialized")
 	}
 
-	if err := rows.Err(); err != nil {
-		log.Printf("Error iterating operators: %v", err)
-		return nil, fmt.Errorf("error iterating operators: %v", err)
+	servers, err := m.store.ListServers(ctx, m.Name(), store.ServerFilter{Status: "active"})
+	if err != nil {
+		return "", err
 	}
-
-	log.Printf("Found %d operators in database", len(operators))
-	return operators, nil
+	for _, server := range servers {
+		if server.Name == name && server.Status == "active" {
+			return server.Host, nil
+		}
+	}
+	return "", fmt.Errorf("server %s is not registered", name)
 }
@@ -0,0 +1,152 @@
+// Package notify holds what the chat notifiers share: the data messages are
+// formatted from, message templates and the routing of events to channels
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"text/template"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Severities of a request, derived from its risk score
+const (
+	SeverityLow    = "low"
+	SeverityMedium = "medium"
+	SeverityHigh   = "high"
+)
+
+// Risk scores at which a request is of medium and high severity
+const (
+	mediumSeverityScore = 40
+	highSeverityScore   = 70
+)
+
+// MessageData is what message templates are executed against
+type MessageData struct {
+	Event   store.Event
+	Request *models.PrivilegeRequest
+	// Grant is set for approved, extended, revoked, expired, expiring and
+	// handed_over events
+	Grant       *store.GrantEventData
+	Environment string
+	Severity    string
+	// OnCall mentions the on-call users who can approve a new request
+	OnCall []string
+}
+
+// NewMessageData collects what templates and routes need to know about an event
+func NewMessageData(ctx context.Context, s store.Store, event store.Event) (*MessageData, error) {
+	request, err := s.GetRequest(ctx, event.RequestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get request %s: %v", event.RequestID, err)
+	}
+	data := &MessageData{
+		Event:    event,
+		Request:  request,
+		Severity: Severity(request.RiskScore),
+	}
+	switch event.Type {
+	case store.EventApproved, store.EventExtended, store.EventRevoked, store.EventExpired, store.EventExpiring, store.EventHandedOver:
+		var grant store.GrantEventData
+		if err := json.Unmarshal(event.Data, &grant); err == nil && grant.GrantID != "" {
+			data.Grant = &grant
+		}
+	}
+	return data, nil
+}
+
+// Route sends the events of matching requests to a channel. A route matches
+// when every non-empty list contains the request's value; the first matching
+// route wins.
+//
+//	modules: [mysql]
+//	environments: [production]
+//	severities: [high]
+//	channel: "#db-prod-access"
+type Route struct {
+	Modules      []string `yaml:"modules"`
+	Environments []string `yaml:"environments"`
+	Severities   []string `yaml:"severities"`
+	// Events limits the route to some event types, e.g. requested and approved
+	Events  []string `yaml:"events"`
+	Channel string   `yaml:"channel"`
+}
+
+// ValidateRoutes checks that every route has a channel and known severities
+func ValidateRoutes(routes []Route) error {
+	for i, route := range routes {
+		if route.Channel == "" {
+			return fmt.Errorf("route %d: channel is required", i)
+		}
+		for _, severity := range route.Severities {
+			if severity != SeverityLow && severity != SeverityMedium && severity != SeverityHigh {
+				return fmt.Errorf("route %d: unknown severity %q", i, severity)
+			}
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the route covers the event
+func (r *Route) Matches(data *MessageData) bool {
+	if len(r.Modules) > 0 && !slices.Contains(r.Modules, data.Request.Module) {
+		return false
+	}
+	if len(r.Environments) > 0 && !slices.Contains(r.Environments, data.Environment) {
+		return false
+	}
+	if len(r.Severities) > 0 && !slices.Contains(r.Severities, data.Severity) {
+		return false
+	}
+	if len(r.Events) > 0 && !slices.Contains(r.Events, data.Event.Type) {
+		return false
+	}
+	return true
+}
+
+// Channel returns the channel of the first route matching the event, or the
+// default channel, which may be empty
+func Channel(routes []Route, defaultChannel string, data *MessageData) string {
+	for i := range routes {
+		if routes[i].Matches(data) {
+			return routes[i].Channel
+		}
+	}
+	return defaultChannel
+}
+
+// ParseTemplates parses the default templates with the overrides applied
+func ParseTemplates(defaults, overrides map[string]string) (map[string]*template.Template, error) {
+	templates := make(map[string]*template.Template)
+	for eventType, text := range defaults {
+		templates[eventType] = template.Must(template.New(eventType).Parse(text))
+	}
+	for eventType, text := range overrides {
+		if _, ok := defaults[eventType]; !ok {
+			return nil, fmt.Errorf("template for unsupported event type %q", eventType)
+		}
+		tmpl, err := template.New(eventType).Option("missingkey=error").Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("invalid template for %s events: %v", eventType, err)
+		}
+		templates[eventType] = tmpl
+	}
+	return templates, nil
+}
+
+// Severity returns the severity of a request with the given risk score
+func Severity(riskScore int) string {
+	switch {
+	case riskScore >= highSeverityScore:
+		return SeverityHigh
+	case riskScore >= mediumSeverityScore:
+		return SeverityMedium
+	default:
+		return SeverityLow
+	}
+}
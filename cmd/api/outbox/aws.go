@@ -0,0 +1,237 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/sigv4"
+)
+
+// AWSConfig locates an AWS service and holds the credentials to call it
+type AWSConfig struct {
+	Region string
+	// Endpoint overrides the service's regional endpoint, e.g. for a VPC endpoint
+	Endpoint    string
+	Credentials sigv4.Credentials
+}
+
+// validate checks the configuration, filling in the service's regional endpoint
+func (c *AWSConfig) validate(service string) error {
+	if c.Region == "" {
+		return fmt.Errorf("region is required")
+	}
+	if c.Credentials.AccessKeyID == "" || c.Credentials.SecretAccessKey == "" {
+		return fmt.Errorf("access key ID and secret access key are required")
+	}
+	if c.Endpoint == "" {
+		c.Endpoint = "https://" + service + "." + c.Region + ".amazonaws.com"
+	}
+	c.Endpoint = strings.TrimSuffix(c.Endpoint, "/") + "/"
+	return nil
+}
+
+// SNSPublisher publishes events to an SNS topic. The event type and request
+// ID are message attributes, so subscriptions can filter on them. FIFO topics
+// get the request as message group, keeping each request's events in order.
+type SNSPublisher struct {
+	topicARN string
+	aws      AWSConfig
+	format   Format
+	client   *http.Client
+}
+
+// NewSNSPublisher creates a publisher for the topic. The region defaults to
+// the topic's. SNS messages have no headers, so CloudEvents are always sent
+// in structured mode.
+func NewSNSPublisher(topicARN string, aws AWSConfig, format Format) (*SNSPublisher, error) {
+	// arn:aws:sns:<region>:<account>:<topic>
+	parts := strings.Split(topicARN, ":")
+	if len(parts) != 6 || parts[0] != "arn" || parts[2] != "sns" {
+		return nil, fmt.Errorf("invalid SNS topic ARN %q", topicARN)
+	}
+	if aws.Region == "" {
+		aws.Region = parts[3]
+	}
+	if err := aws.validate("sns"); err != nil {
+		return nil, err
+	}
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	if format.Mode == ModeBinary {
+		return nil, fmt.Errorf("SNS only carries CloudEvents in structured mode")
+	}
+	return &SNSPublisher{topicARN: topicARN, aws: aws, format: format, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (p *SNSPublisher) Name() string {
+	return "sns:" + p.topicARN
+}
+
+// Publish publishes an event to the topic
+func (p *SNSPublisher) Publish(ctx context.Context, event store.Event) error {
+	message, _, err := p.format.encode(event)
+	if err != nil {
+		return err
+	}
+	form := url.Values{}
+	form.Set("Action", "Publish")
+	form.Set("Version", "2010-03-31")
+	form.Set("TopicArn", p.topicARN)
+	form.Set("Message", string(message))
+	attributes := [][2]string{{"type", event.Type}, {"request_id", event.RequestID}}
+	for i, attribute := range attributes {
+		prefix := fmt.Sprintf("MessageAttributes.entry.%d.", i+1)
+		form.Set(prefix+"Name", attribute[0])
+		form.Set(prefix+"Value.DataType", "String")
+		form.Set(prefix+"Value.StringValue", attribute[1])
+	}
+	if strings.HasSuffix(p.topicARN, ".fifo") {
+		form.Set("MessageGroupId", event.RequestID)
+		form.Set("MessageDeduplicationId", event.ID)
+	}
+
+	body := []byte(form.Encode())
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.aws.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	sigv4.Sign(req, body, p.aws.Credentials, p.aws.Region, "sns", time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call SNS Publish: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		var apiErr struct {
+			Code    string `xml:"Error>Code"`
+			Message string `xml:"Error>Message"`
+		}
+		if xml.Unmarshal(detail, &apiErr) == nil && apiErr.Code != "" {
+			return fmt.Errorf("SNS Publish failed: %s: %s", apiErr.Code, apiErr.Message)
+		}
+		return fmt.Errorf("SNS Publish returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+	return nil
+}
+
+// DefaultEventBridgeSource is the source of events put on EventBridge when none is configured
+const DefaultEventBridgeSource = "apollo"
+
+// EventBridgePublisher puts events on an EventBridge bus with detail type
+// apollo.<type> and the encoded event as detail, so rules can match on both
+type EventBridgePublisher struct {
+	eventBus string
+	source   string
+	aws      AWSConfig
+	format   Format
+	client   *http.Client
+}
+
+// NewEventBridgePublisher creates a publisher for the bus, given by name or
+// ARN, putting events from source, DefaultEventBridgeSource when empty. The
+// detail must be a JSON object, so CloudEvents are sent in structured mode.
+func NewEventBridgePublisher(eventBus, source string, aws AWSConfig, format Format) (*EventBridgePublisher, error) {
+	if eventBus == "" {
+		return nil, fmt.Errorf("event bus is required")
+	}
+	if source == "" {
+		source = DefaultEventBridgeSource
+	}
+	if strings.HasPrefix(source, "aws.") {
+		return nil, fmt.Errorf("EventBridge sources starting with aws. are reserved: %q", source)
+	}
+	if err := aws.validate("events"); err != nil {
+		return nil, err
+	}
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	if format.Mode == ModeBinary {
+		return nil, fmt.Errorf("EventBridge only carries CloudEvents in structured mode")
+	}
+	return &EventBridgePublisher{
+		eventBus: eventBus,
+		source:   source,
+		aws:      aws,
+		format:   format,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (p *EventBridgePublisher) Name() string {
+	return "eventbridge:" + p.eventBus
+}
+
+// Publish puts an event on the bus; an entry EventBridge rejects fails it
+func (p *EventBridgePublisher) Publish(ctx context.Context, event store.Event) error {
+	detail, _, err := p.format.encode(event)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"Entries": []map[string]interface{}{{
+			"EventBusName": p.eventBus,
+			"Source":       p.source,
+			"DetailType":   "apollo." + event.Type,
+			"Detail":       string(detail),
+			"Time":         event.Time.Unix(),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("failed to marshal PutEvents request: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.aws.Endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "AWSEvents.PutEvents")
+	sigv4.Sign(req, body, p.aws.Credentials, p.aws.Region, "events", time.Now().UTC())
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call EventBridge PutEvents: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		detail, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("EventBridge PutEvents returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(detail)))
+	}
+
+	// PutEvents succeeds as a call even when it rejects the entry
+	var result struct {
+		FailedEntryCount int
+		Entries          []struct {
+			ErrorCode    string
+			ErrorMessage string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode PutEvents response: %v", err)
+	}
+	if result.FailedEntryCount > 0 {
+		for _, entry := range result.Entries {
+			if entry.ErrorCode != "" {
+				return fmt.Errorf("EventBridge rejected event %s: %s: %s", event.ID, entry.ErrorCode, entry.ErrorMessage)
+			}
+		}
+		return fmt.Errorf("EventBridge rejected event %s", event.ID)
+	}
+	return nil
+}
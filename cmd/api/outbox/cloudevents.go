@@ -0,0 +1,166 @@
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// Event encodings
+const (
+	// EncodingApollo sends events in Apollo's envelope
+	EncodingApollo = "apollo"
+	// EncodingCloudEvents sends events as CloudEvents 1.0
+	EncodingCloudEvents = "cloudevents"
+)
+
+// CloudEvents content modes
+const (
+	// ModeStructured sends the attributes and the payload together as JSON
+	ModeStructured = "structured"
+	// ModeBinary sends the attributes as ce- headers and the payload as the body
+	ModeBinary = "binary"
+)
+
+// DefaultCloudEventSource is the source attribute of events when none is configured
+const DefaultCloudEventSource = "/apollo"
+
+// Format selects how a publisher encodes events
+type Format struct {
+	// Encoding is apollo or cloudevents; apollo when empty
+	Encoding string
+	// Mode is structured or binary; structured when empty. It only applies
+	// to CloudEvents.
+	Mode string
+	// Source is the CloudEvents source; DefaultCloudEventSource when empty
+	Source string
+}
+
+// validate checks the format, filling in the defaults
+func (f *Format) validate() error {
+	if f.Encoding == "" {
+		f.Encoding = EncodingApollo
+	}
+	if f.Encoding != EncodingApollo && f.Encoding != EncodingCloudEvents {
+		return fmt.Errorf("unknown event encoding %q", f.Encoding)
+	}
+	if f.Mode == "" {
+		f.Mode = ModeStructured
+	}
+	if f.Mode != ModeStructured && f.Mode != ModeBinary {
+		return fmt.Errorf("unknown CloudEvents mode %q", f.Mode)
+	}
+	if f.Mode == ModeBinary && f.Encoding != EncodingCloudEvents {
+		return fmt.Errorf("binary mode requires the cloudevents encoding")
+	}
+	if f.Source == "" {
+		f.Source = DefaultCloudEventSource
+	}
+	return nil
+}
+
+// encode returns the body and headers, including the content type, an event
+// is sent with
+func (f Format) encode(event store.Event) ([]byte, map[string]string, error) {
+	if f.Encoding != EncodingCloudEvents {
+		body, err := encodeEvent(event)
+		return body, map[string]string{"Content-Type": "application/json"}, err
+	}
+
+	cloudEvent, err := NewCloudEvent(event, f.Source)
+	if err != nil {
+		return nil, nil, err
+	}
+	if f.Mode == ModeStructured {
+		body, err := json.Marshal(cloudEvent)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to marshal event: %v", err)
+		}
+		return body, map[string]string{"Content-Type": "application/cloudevents+json"}, nil
+	}
+
+	body, err := json.Marshal(cloudEvent.Data)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+	headers := map[string]string{
+		"Content-Type":     cloudEvent.DataContentType,
+		"ce-specversion":   cloudEvent.SpecVersion,
+		"ce-id":            cloudEvent.ID,
+		"ce-source":        cloudEvent.Source,
+		"ce-type":          cloudEvent.Type,
+		"ce-subject":       cloudEvent.Subject,
+		"ce-time":          cloudEvent.Time.Format(time.RFC3339Nano),
+		"ce-schemaversion": strconv.Itoa(cloudEvent.SchemaVersion),
+		"ce-version":       strconv.Itoa(cloudEvent.Version),
+	}
+	if cloudEvent.Actor != "" {
+		headers["ce-actor"] = cloudEvent.Actor
+	}
+	for name, value := range headers {
+		if strings.HasPrefix(name, "ce-") {
+			headers[name] = percentEncode(value)
+		}
+	}
+	return body, headers, nil
+}
+
+// percentEncode encodes a ce- header value as the CloudEvents HTTP binding
+// requires: spaces, double quotes, percent signs and anything outside
+// printable ASCII become %XX
+func percentEncode(value string) string {
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		c := value[i]
+		if c <= ' ' || c > '~' || c == '"' || c == '%' {
+			fmt.Fprintf(&b, "%%%02X", c)
+			continue
+		}
+		b.WriteByte(c)
+	}
+	return b.String()
+}
+
+// CloudEvent is an event as a CloudEvents 1.0 structured mode message. The
+// subject is the request the event belongs to; schemaversion, version and
+// actor are extension attributes with the same meaning as in Apollo's envelope.
+type CloudEvent struct {
+	SpecVersion     string    `json:"specversion"`
+	ID              string    `json:"id"`
+	Source          string    `json:"source"`
+	Type            string    `json:"type"`
+	Subject         string    `json:"subject"`
+	Time            time.Time `json:"time"`
+	DataContentType string    `json:"datacontenttype"`
+	SchemaVersion   int       `json:"schemaversion"`
+	// Version orders the events of a request
+	Version int         `json:"version"`
+	Actor   string      `json:"actor,omitempty"`
+	Data    interface{} `json:"data"`
+}
+
+// NewCloudEvent returns an event as a CloudEvent of type apollo.<type>
+// coming from source
+func NewCloudEvent(event store.Event, source string) (*CloudEvent, error) {
+	envelope, err := NewEnvelope(event)
+	if err != nil {
+		return nil, err
+	}
+	return &CloudEvent{
+		SpecVersion:     "1.0",
+		ID:              envelope.ID,
+		Source:          source,
+		Type:            "apollo." + envelope.Type,
+		Subject:         envelope.RequestID,
+		Time:            envelope.Time,
+		DataContentType: "application/json",
+		SchemaVersion:   envelope.SchemaVersion,
+		Version:         envelope.Version,
+		Actor:           envelope.Actor,
+		Data:            envelope.Data,
+	}, nil
+}
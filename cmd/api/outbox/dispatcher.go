@@ -0,0 +1,230 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+const (
+	// batchSize is the number of entries claimed at a time
+	batchSize = 100
+	// lease is how long claimed entries are hidden from other dispatchers
+	lease = time.Minute
+	// maxBackoff caps the delay before retrying a failed delivery
+	maxBackoff = time.Hour
+	// pruneInterval is how often delivery attempts past their history are removed
+	pruneInterval = time.Hour
+)
+
+const (
+	// DefaultMaxAttempts is how often delivering an entry fails before it
+	// becomes a dead letter; with the backoff capped at an hour that's about
+	// half a day of retries
+	DefaultMaxAttempts = 20
+	// DefaultDeliveryHistory is how long delivery attempts are kept
+	DefaultDeliveryHistory = 7 * 24 * time.Hour
+)
+
+// Publisher delivers events to one destination. Events may be delivered more
+// than once, so receivers should use the event ID to drop duplicates.
+type Publisher interface {
+	// Name identifies the publisher in the outbox; it must stay the same across restarts
+	Name() string
+	// Publish delivers an event
+	Publish(ctx context.Context, event store.Event) error
+}
+
+// Dispatcher delivers the events in the store's outbox to the publishers.
+// Several API servers may run dispatchers against the same store; claimed
+// entries are hidden from the others while they are delivered.
+type Dispatcher struct {
+	store      store.Store
+	publishers []Publisher
+	backoff    time.Duration
+	// maxAttempts is how often an entry may fail before it becomes a dead letter
+	maxAttempts int
+	// history is how long delivery attempts are kept
+	history time.Duration
+
+	// mu keeps runs from overlapping
+	mu        sync.Mutex
+	lastPrune time.Time
+}
+
+// NewDispatcher creates a dispatcher. Without publishers, delivered events
+// are simply removed from the outbox.
+func NewDispatcher(s store.Store, publishers ...Publisher) *Dispatcher {
+	return &Dispatcher{
+		store:       s,
+		publishers:  publishers,
+		backoff:     5 * time.Second,
+		maxAttempts: DefaultMaxAttempts,
+		history:     DefaultDeliveryHistory,
+	}
+}
+
+// SetMaxAttempts sets how often delivering an entry may fail before it
+// becomes a dead letter, which is only delivered again when redelivered
+func (d *Dispatcher) SetMaxAttempts(attempts int) {
+	d.maxAttempts = attempts
+}
+
+// SetDeliveryHistory sets how long delivery attempts are kept
+func (d *Dispatcher) SetDeliveryHistory(history time.Duration) {
+	d.history = history
+}
+
+// Publishers returns the names of the publishers events are delivered to
+func (d *Dispatcher) Publishers() []string {
+	names := make([]string, 0, len(d.publishers))
+	for _, publisher := range d.publishers {
+		names = append(names, publisher.Name())
+	}
+	return names
+}
+
+// Start delivers pending events at the given interval until the context is cancelled
+func (d *Dispatcher) Start(ctx context.Context, interval time.Duration) {
+	d.backoff = interval
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if _, err := d.Run(ctx); err != nil {
+					log.Printf("Failed to dispatch events: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Run delivers the events that are due and returns how many were delivered
+// to every publisher
+func (d *Dispatcher) Run(ctx context.Context) (int, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	delivered := 0
+	for {
+		now := time.Now().UTC()
+		entries, err := d.store.ClaimOutbox(ctx, now, lease, batchSize)
+		if err != nil {
+			return delivered, err
+		}
+
+		var completed []string
+		var deliveries []store.Delivery
+		for _, entry := range entries {
+			ok, attempts := d.deliver(ctx, &entry)
+			deliveries = append(deliveries, attempts...)
+			if ok {
+				completed = append(completed, entry.ID)
+				continue
+			}
+			if entry.Attempts >= d.maxAttempts {
+				log.Printf("Giving up on %s event %s after %d attempts: %s",
+					entry.Event.Type, entry.Event.ID, entry.Attempts, entry.LastError)
+				if err := d.store.DeadLetterOutbox(ctx, entry, now); err != nil {
+					log.Printf("Failed to dead-letter event %s: %v", entry.Event.ID, err)
+				}
+				continue
+			}
+			retryAt := now.Add(d.retryDelay(entry.Attempts))
+			if err := d.store.RetryOutbox(ctx, entry, retryAt); err != nil {
+				log.Printf("Failed to reschedule event %s: %v", entry.Event.ID, err)
+			}
+		}
+		// The batch is already delivered; failing to record it mustn't deliver it again
+		if err := d.store.RecordDeliveries(ctx, deliveries); err != nil {
+			log.Printf("Failed to record deliveries: %v", err)
+		}
+		if err := d.store.CompleteOutbox(ctx, completed); err != nil {
+			return delivered, err
+		}
+		delivered += len(completed)
+
+		if len(entries) < batchSize {
+			d.prune(ctx, now)
+			return delivered, nil
+		}
+	}
+}
+
+// prune removes the delivery attempts past their history, at most once per
+// pruneInterval; the caller holds mu
+func (d *Dispatcher) prune(ctx context.Context, now time.Time) {
+	if d.history <= 0 || now.Sub(d.lastPrune) < pruneInterval {
+		return
+	}
+	d.lastPrune = now
+	pruned, err := d.store.PruneDeliveries(ctx, now.Add(-d.history))
+	if err != nil {
+		log.Printf("Failed to prune delivery attempts: %v", err)
+		return
+	}
+	if pruned > 0 {
+		log.Printf("Pruned %d delivery attempts older than %s", pruned, d.history)
+	}
+}
+
+// deliver publishes an entry's event to the publishers it is for that haven't
+// accepted it yet and reports whether all of them have now, along with the
+// attempts made
+func (d *Dispatcher) deliver(ctx context.Context, entry *store.OutboxEntry) (bool, []store.Delivery) {
+	ok := true
+	var deliveries []store.Delivery
+	for _, publisher := range d.publishers {
+		if entry.Publisher != "" && entry.Publisher != publisher.Name() {
+			continue
+		}
+		if slices.Contains(entry.Delivered, publisher.Name()) {
+			continue
+		}
+		delivery := store.Delivery{
+			EventID:   entry.Event.ID,
+			EventType: entry.Event.Type,
+			Publisher: publisher.Name(),
+			Attempt:   entry.Attempts + 1,
+			Status:    store.DeliveryDelivered,
+			Time:      time.Now().UTC(),
+		}
+		if err := publisher.Publish(ctx, entry.Event); err != nil {
+			ok = false
+			entry.LastError = fmt.Sprintf("%s: %v", publisher.Name(), err)
+			delivery.Status = store.DeliveryFailed
+			delivery.Error = err.Error()
+			deliveries = append(deliveries, delivery)
+			log.Printf("Failed to deliver %s event %s to %s (attempt %d): %v",
+				entry.Event.Type, entry.Event.ID, publisher.Name(), entry.Attempts+1, err)
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		entry.Delivered = append(entry.Delivered, publisher.Name())
+	}
+	if !ok {
+		entry.Attempts++
+	}
+	return ok, deliveries
+}
+
+// retryDelay doubles the delay with every failed attempt up to maxBackoff
+func (d *Dispatcher) retryDelay(attempts int) time.Duration {
+	delay := d.backoff
+	for i := 1; i < attempts && delay < maxBackoff; i++ {
+		delay *= 2
+	}
+	if delay > maxBackoff {
+		delay = maxBackoff
+	}
+	return delay
+}
@@ -0,0 +1,164 @@
+package outbox
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/events"
+)
+
+// NewEnvelope returns the envelope an event is published in, with its data
+// decoded into the typed payload the events catalog describes so consumers
+// get the same fields whatever the store keeps
+func NewEnvelope(event store.Event) (*events.Envelope, error) {
+	schema, ok := events.Lookup(event.Type)
+	if !ok {
+		return nil, fmt.Errorf("event %s has unknown type %q", event.ID, event.Type)
+	}
+	data, err := events.Decode(event.Type, event.Data)
+	if err != nil {
+		return nil, err
+	}
+	return &events.Envelope{
+		ID:            event.ID,
+		Type:          event.Type,
+		SchemaVersion: schema.Version,
+		RequestID:     event.RequestID,
+		Version:       event.Version,
+		Actor:         event.Actor,
+		Time:          event.Time,
+		Data:          data,
+	}, nil
+}
+
+// encodeEvent encodes an event in its envelope
+func encodeEvent(event store.Event) ([]byte, error) {
+	envelope, err := NewEnvelope(event)
+	if err != nil {
+		return nil, err
+	}
+	body, err := json.Marshal(envelope)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal event: %v", err)
+	}
+	return body, nil
+}
+
+// BusPublisher publishes events to the message bus on apollo.events.<type>
+type BusPublisher struct {
+	bus    bus.Bus
+	format Format
+}
+
+// NewBusPublisher creates a publisher for the bus. Bus messages have no
+// headers, so CloudEvents are always sent in structured mode.
+func NewBusPublisher(b bus.Bus, format Format) (*BusPublisher, error) {
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	if format.Mode == ModeBinary {
+		return nil, fmt.Errorf("the bus only carries CloudEvents in structured mode")
+	}
+	return &BusPublisher{bus: b, format: format}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (p *BusPublisher) Name() string {
+	return "bus"
+}
+
+// Publish delivers an event to the bus
+func (p *BusPublisher) Publish(ctx context.Context, event store.Event) error {
+	data, _, err := p.format.encode(event)
+	if err != nil {
+		return err
+	}
+	return p.bus.Publish(ctx, bus.EventSubject(event.Type), data)
+}
+
+// WebhookPublisher posts events as JSON to a URL. With a secret, the body is
+// signed with HMAC-SHA256 in the X-Apollo-Signature header as sha256=<hex>.
+// The schema of the payload is served at /api/v1/events/schemas/<type>.
+type WebhookPublisher struct {
+	url    string
+	secret []byte
+	format Format
+	client *http.Client
+}
+
+// NewWebhookPublisher creates a publisher posting to the URL in the format
+func NewWebhookPublisher(url, secret string, format Format) (*WebhookPublisher, error) {
+	if !strings.HasPrefix(url, "https://") && !strings.HasPrefix(url, "http://") {
+		return nil, fmt.Errorf("webhook URL must use http or https: %q", url)
+	}
+	if err := format.validate(); err != nil {
+		return nil, err
+	}
+	return &WebhookPublisher{url: url, secret: []byte(secret), format: format, client: &http.Client{Timeout: 10 * time.Second}}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (p *WebhookPublisher) Name() string {
+	return "webhook:" + p.url
+}
+
+// Publish posts an event to the webhook; any status other than 2xx fails
+func (p *WebhookPublisher) Publish(ctx context.Context, event store.Event) error {
+	body, headers, err := p.format.encode(event)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	for name, value := range headers {
+		req.Header.Set(name, value)
+	}
+	req.Header.Set("X-Apollo-Event-ID", event.ID)
+	req.Header.Set("X-Apollo-Event-Type", event.Type)
+	if len(p.secret) > 0 {
+		mac := hmac.New(sha256.New, p.secret)
+		mac.Write(body)
+		req.Header.Set("X-Apollo-Signature", "sha256="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to post event: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned status %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// GatedPublisher delivers events only while its gate is open. Events
+// published while it's closed are dropped, as if the publisher weren't
+// configured.
+type GatedPublisher struct {
+	Publisher
+	Open func() bool
+}
+
+// Publish delivers an event when the gate is open
+func (p GatedPublisher) Publish(ctx context.Context, event store.Event) error {
+	if !p.Open() {
+		return nil
+	}
+	return p.Publisher.Publish(ctx, event)
+}
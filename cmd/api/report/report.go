@@ -0,0 +1,410 @@
+// Package report summarizes how access was used over a week or a month, per
+// team owning the resources: the grants issued, how long they lasted, how
+// many were break-glass grants and how many went unused. Reports are kept as
+// JSON and CSV files to download and can be mailed to the teams.
+package report
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/digest"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Report periods
+const (
+	PeriodWeekly  = "weekly"
+	PeriodMonthly = "monthly"
+)
+
+// Unowned is the team of grants for resources without an owner
+const Unowned = "unowned"
+
+// auditBatchSize is the number of audit entries read from the store at a time
+const auditBatchSize = 1000
+
+// ErrNotFound is returned for reports that don't exist
+var ErrNotFound = errors.New("report not found")
+
+// validID matches the IDs of reports, which name their files
+var validID = regexp.MustCompile(`^report_(weekly|monthly)_\d{4}-\d{2}-\d{2}$`)
+
+// Report summarizes the grants issued in a period by team
+type Report struct {
+	ID          string        `json:"id"`
+	Period      string        `json:"period"`
+	From        time.Time     `json:"from"`
+	To          time.Time     `json:"to"`
+	GeneratedAt time.Time     `json:"generated_at"`
+	Teams       []TeamSummary `json:"teams"`
+}
+
+// TeamSummary summarizes the grants for the resources a team owns
+type TeamSummary struct {
+	Team         string `json:"team"`
+	GrantsIssued int    `json:"grants_issued"`
+	Users        int    `json:"users"`
+	// AverageDuration is how long the grants lasted on average, until they
+	// expired or were revoked, or until the report was generated
+	AverageDuration string `json:"average_duration"`
+	// BreakGlass counts the grants given without a human approver, by
+	// policy or to the engineer on call
+	BreakGlass int `json:"break_glass"`
+	// Unused counts the grants that ended before their credentials were retrieved
+	Unused       int      `json:"unused"`
+	UnusedGrants []string `json:"unused_grants,omitempty"`
+}
+
+// Config configures the reports
+type Config struct {
+	// Period is weekly, generated on Mondays, or monthly, generated on the
+	// first of the month
+	Period string
+	// Timezone is the IANA timezone periods start at midnight in; UTC when empty
+	Timezone string
+	// Dir is where reports are written
+	Dir string
+	// Recipients maps teams to the addresses their report is mailed to
+	Recipients map[string][]string
+}
+
+// Generator generates reports from the grants in the store
+type Generator struct {
+	store    store.Store
+	owner    func(resourceID string) string
+	mailer   digest.Mailer
+	config   Config
+	location *time.Location
+}
+
+// New creates a generator. owner returns the team owning a resource; reports
+// are mailed with the mailer when it isn't nil.
+func New(s store.Store, owner func(resourceID string) string, mailer digest.Mailer, config Config) (*Generator, error) {
+	if config.Period == "" {
+		config.Period = PeriodWeekly
+	}
+	if config.Period != PeriodWeekly && config.Period != PeriodMonthly {
+		return nil, fmt.Errorf("invalid report period %q: expected weekly or monthly", config.Period)
+	}
+	location := time.UTC
+	if config.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+	}
+	if config.Dir == "" {
+		config.Dir = filepath.Join(os.TempDir(), "apollo-reports")
+	}
+	return &Generator{store: s, owner: owner, mailer: mailer, config: config, location: location}, nil
+}
+
+// Start generates the report of the period that just ended whenever a new
+// period starts, until the context is cancelled
+func (g *Generator) Start(ctx context.Context) {
+	go func() {
+		for {
+			_, next := g.bounds(time.Now())
+			timer := time.NewTimer(time.Until(next))
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+			report, err := g.Run(ctx, g.config.Period, time.Now())
+			if err != nil {
+				log.Printf("Failed to generate %s report: %v", g.config.Period, err)
+				continue
+			}
+			log.Printf("Generated report %s covering %d teams", report.ID, len(report.Teams))
+		}
+	}()
+}
+
+// Run generates, saves and mails the report of the last complete period
+// before now
+func (g *Generator) Run(ctx context.Context, period string, now time.Time) (*Report, error) {
+	if period == "" {
+		period = g.config.Period
+	}
+	if period != PeriodWeekly && period != PeriodMonthly {
+		return nil, fmt.Errorf("invalid report period %q: expected weekly or monthly", period)
+	}
+	generator := *g
+	generator.config.Period = period
+	to, _ := generator.bounds(now)
+	from, _ := generator.bounds(to.Add(-time.Nanosecond))
+
+	report, err := generator.Generate(ctx, from, to, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.save(report); err != nil {
+		return nil, err
+	}
+	if g.mailer != nil {
+		g.mail(ctx, report)
+	}
+	return report, nil
+}
+
+// bounds returns the start of the period containing t and the start of the next one
+func (g *Generator) bounds(t time.Time) (time.Time, time.Time) {
+	local := t.In(g.location)
+	if g.config.Period == PeriodMonthly {
+		start := time.Date(local.Year(), local.Month(), 1, 0, 0, 0, 0, g.location)
+		return start, start.AddDate(0, 1, 0)
+	}
+	// Weeks start on Mondays
+	offset := (int(local.Weekday()) + 6) % 7
+	start := time.Date(local.Year(), local.Month(), local.Day()-offset, 0, 0, 0, 0, g.location)
+	return start, start.AddDate(0, 0, 7)
+}
+
+// Generate summarizes the grants issued in [from, to) as of now
+func (g *Generator) Generate(ctx context.Context, from, to, now time.Time) (*Report, error) {
+	grants, err := g.store.ListGrants(ctx, "", time.Time{})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %v", err)
+	}
+	retrieved, err := g.retrievedGrants(ctx, from, now)
+	if err != nil {
+		return nil, err
+	}
+
+	type totals struct {
+		summary  TeamSummary
+		users    map[string]bool
+		duration time.Duration
+	}
+	teams := make(map[string]*totals)
+	for _, grant := range grants {
+		if grant.GrantedAt.Before(from) || !grant.GrantedAt.Before(to) {
+			continue
+		}
+		team := g.owner(grant.ResourceID)
+		if team == "" {
+			team = Unowned
+		}
+		t, ok := teams[team]
+		if !ok {
+			t = &totals{summary: TeamSummary{Team: team}, users: make(map[string]bool)}
+			teams[team] = t
+		}
+		t.summary.GrantsIssued++
+		t.users[grant.UserID] = true
+
+		end := endOf(grant, now)
+		t.duration += end.Sub(grant.GrantedAt)
+		if breakGlass(grant) {
+			t.summary.BreakGlass++
+		}
+		if end.Before(now) && !retrieved[grant.ID] {
+			t.summary.Unused++
+			t.summary.UnusedGrants = append(t.summary.UnusedGrants, grant.ID)
+		}
+	}
+
+	report := &Report{
+		ID:          fmt.Sprintf("report_%s_%s", g.config.Period, from.In(g.location).Format("2006-01-02")),
+		Period:      g.config.Period,
+		From:        from.UTC(),
+		To:          to.UTC(),
+		GeneratedAt: now.UTC(),
+		Teams:       []TeamSummary{},
+	}
+	for _, t := range teams {
+		t.summary.Users = len(t.users)
+		average := t.duration / time.Duration(t.summary.GrantsIssued)
+		t.summary.AverageDuration = average.Round(time.Second).String()
+		sort.Strings(t.summary.UnusedGrants)
+		report.Teams = append(report.Teams, t.summary)
+	}
+	sort.Slice(report.Teams, func(i, j int) bool {
+		return report.Teams[i].Team < report.Teams[j].Team
+	})
+	return report, nil
+}
+
+// retrievedGrants returns the grants whose credentials were retrieved in [from, to)
+func (g *Generator) retrievedGrants(ctx context.Context, from, to time.Time) (map[string]bool, error) {
+	retrieved := make(map[string]bool)
+	query := store.AuditQuery{From: from, To: to, Limit: auditBatchSize}
+	for {
+		entries, err := g.store.ListAudit(ctx, query)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read audit log: %v", err)
+		}
+		for _, entry := range entries {
+			if entry.Action == audit.ActionCredentialsRetrieved {
+				retrieved[entry.Details["grant_id"]] = true
+			}
+		}
+		if len(entries) < query.Limit {
+			return retrieved, nil
+		}
+		last := entries[len(entries)-1]
+		query.AfterTime, query.AfterID = last.Time, last.ID
+	}
+}
+
+// endOf returns when a grant ended, or now while it lasts
+func endOf(grant *models.PrivilegeGrant, now time.Time) time.Time {
+	end := grant.ExpiresAt
+	if grant.RevokedAt != nil && grant.RevokedAt.Before(end) {
+		end = *grant.RevokedAt
+	}
+	if now.Before(end) {
+		return now
+	}
+	return end
+}
+
+// breakGlass reports whether a grant was given without a human approver
+func breakGlass(grant *models.PrivilegeGrant) bool {
+	if len(grant.Approvals) == 0 {
+		return grant.GrantedBy == "policy"
+	}
+	for _, approval := range grant.Approvals {
+		if approval.ApproverKind != models.ApproverKindPolicy {
+			return false
+		}
+	}
+	return true
+}
+
+// save writes a report as JSON and CSV
+func (g *Generator) save(report *Report) error {
+	if err := os.MkdirAll(g.config.Dir, 0o700); err != nil {
+		return fmt.Errorf("failed to create report directory: %v", err)
+	}
+	data, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode report: %v", err)
+	}
+	if err := os.WriteFile(g.path(report.ID, "json"), data, 0o600); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+
+	file, err := os.OpenFile(g.path(report.ID, "csv"), os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	defer file.Close()
+	w := csv.NewWriter(file)
+	w.Write([]string{"team", "grants_issued", "users", "average_duration", "break_glass", "unused"})
+	for _, team := range report.Teams {
+		w.Write([]string{
+			team.Team,
+			strconv.Itoa(team.GrantsIssued),
+			strconv.Itoa(team.Users),
+			team.AverageDuration,
+			strconv.Itoa(team.BreakGlass),
+			strconv.Itoa(team.Unused),
+		})
+	}
+	w.Flush()
+	if err := w.Error(); err != nil {
+		return fmt.Errorf("failed to write report: %v", err)
+	}
+	return file.Close()
+}
+
+// path returns the file of a report in the given format
+func (g *Generator) path(id, format string) string {
+	return filepath.Join(g.config.Dir, id+"."+format)
+}
+
+// List returns the saved reports, newest first
+func (g *Generator) List() ([]*Report, error) {
+	paths, err := filepath.Glob(filepath.Join(g.config.Dir, "report_*.json"))
+	if err != nil {
+		return nil, err
+	}
+	reports := []*Report{}
+	for _, path := range paths {
+		report, err := g.Get(strings.TrimSuffix(filepath.Base(path), ".json"))
+		if err != nil {
+			log.Printf("Skipping report %s: %v", path, err)
+			continue
+		}
+		reports = append(reports, report)
+	}
+	sort.Slice(reports, func(i, j int) bool {
+		return reports[i].From.After(reports[j].From)
+	})
+	return reports, nil
+}
+
+// Get returns a saved report
+func (g *Generator) Get(id string) (*Report, error) {
+	data, err := g.Read(id, "json")
+	if err != nil {
+		return nil, err
+	}
+	var report Report
+	if err := json.Unmarshal(data, &report); err != nil {
+		return nil, fmt.Errorf("failed to decode report %s: %v", id, err)
+	}
+	return &report, nil
+}
+
+// Read returns the file of a saved report in the given format, json or csv
+func (g *Generator) Read(id, format string) ([]byte, error) {
+	if !validID.MatchString(id) || (format != "json" && format != "csv") {
+		return nil, ErrNotFound
+	}
+	data, err := os.ReadFile(g.path(id, format))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// mail sends each team with recipients its summary. A team that can't be
+// mailed doesn't keep the others from getting theirs.
+func (g *Generator) mail(ctx context.Context, report *Report) {
+	for _, team := range report.Teams {
+		recipients := g.config.Recipients[team.Team]
+		if len(recipients) == 0 {
+			continue
+		}
+		subject, body := compose(report, team)
+		for _, to := range recipients {
+			if err := g.mailer.Send(ctx, to, subject, body); err != nil {
+				log.Printf("Failed to mail report %s to %s: %v", report.ID, to, err)
+			}
+		}
+	}
+}
+
+// compose writes the email of a team's summary
+func compose(report *Report, team TeamSummary) (string, string) {
+	subject := fmt.Sprintf("Apollo %s access report for %s", report.Period, team.Team)
+	var body strings.Builder
+	fmt.Fprintf(&body, "Access to resources owned by %s from %s to %s:\n\n",
+		team.Team, report.From.Format("2006-01-02"), report.To.Format("2006-01-02"))
+	fmt.Fprintf(&body, "Grants issued:    %d to %d users\n", team.GrantsIssued, team.Users)
+	fmt.Fprintf(&body, "Average duration: %s\n", team.AverageDuration)
+	fmt.Fprintf(&body, "Break-glass:      %d granted without a human approver\n", team.BreakGlass)
+	fmt.Fprintf(&body, "Unused:           %d ended before their credentials were retrieved\n", team.Unused)
+	for _, id := range team.UnusedGrants {
+		fmt.Fprintf(&body, "  - %s\n", id)
+	}
+	return subject, body.String()
+}
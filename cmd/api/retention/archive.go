@@ -0,0 +1,106 @@
+package retention
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/sigv4"
+)
+
+// Archiver exports records before they are purged
+type Archiver interface {
+	// Archive stores data under the given key
+	Archive(ctx context.Context, key string, data []byte) error
+}
+
+// FileArchiver writes archives below a local directory
+type FileArchiver struct {
+	Dir string
+}
+
+// Archive writes data to a file named after the key
+func (a *FileArchiver) Archive(ctx context.Context, key string, data []byte) error {
+	name := filepath.Join(a.Dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(name), 0o750); err != nil {
+		return fmt.Errorf("failed to create archive directory: %v", err)
+	}
+	if err := os.WriteFile(name, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	return nil
+}
+
+// S3Archiver uploads archives to an S3-compatible object store. Requests are
+// signed with AWS Signature Version 4, which Google Cloud Storage also accepts
+// with HMAC keys at https://storage.googleapis.com.
+type S3Archiver struct {
+	// Endpoint is the object store URL, e.g. https://s3.eu-west-1.amazonaws.com
+	Endpoint        string
+	Region          string
+	Bucket          string
+	Prefix          string
+	AccessKeyID     string
+	SecretAccessKey string
+
+	client *http.Client
+}
+
+// NewS3Archiver creates an archiver uploading to the given bucket
+func NewS3Archiver(endpoint, region, bucket, prefix, accessKeyID, secretAccessKey string) (*S3Archiver, error) {
+	if endpoint == "" || bucket == "" {
+		return nil, fmt.Errorf("endpoint and bucket are required")
+	}
+	if accessKeyID == "" || secretAccessKey == "" {
+		return nil, fmt.Errorf("access key ID and secret access key are required")
+	}
+	if region == "" {
+		region = "us-east-1"
+	}
+	return &S3Archiver{
+		Endpoint:        strings.TrimSuffix(endpoint, "/"),
+		Region:          region,
+		Bucket:          bucket,
+		Prefix:          strings.Trim(prefix, "/"),
+		AccessKeyID:     accessKeyID,
+		SecretAccessKey: secretAccessKey,
+		client:          &http.Client{Timeout: time.Minute},
+	}, nil
+}
+
+// Archive uploads data as an object named after the key
+func (a *S3Archiver) Archive(ctx context.Context, key string, data []byte) error {
+	objectPath := "/" + a.Bucket + "/" + path.Join(a.Prefix, key)
+	endpoint, err := url.Parse(a.Endpoint)
+	if err != nil {
+		return fmt.Errorf("invalid endpoint: %v", err)
+	}
+	endpoint.Path = objectPath
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, endpoint.String(), bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-ndjson")
+	sigv4.Sign(req, data, sigv4.Credentials{AccessKeyID: a.AccessKeyID, SecretAccessKey: a.SecretAccessKey}, a.Region, "s3", time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to upload archive: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("object store returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(body)))
+	}
+	return nil
+}
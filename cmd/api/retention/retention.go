@@ -0,0 +1,232 @@
+package retention
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// Record kinds covered by retention
+const (
+	KindJobs   = "jobs"
+	KindGrants = "grants"
+	KindAudit  = "audit"
+)
+
+// Actions applied to records older than their retention window
+const (
+	ActionPurge   = "purge"
+	ActionArchive = "archive"
+)
+
+// batchSize is the number of records archived and deleted at a time
+const batchSize = 500
+
+// Rule is the retention of a single kind of record
+type Rule struct {
+	// MaxAge is how long records are kept; zero keeps them forever
+	MaxAge time.Duration
+	// Action is purge or archive; archived records are exported before they are deleted
+	Action string
+}
+
+// Policy configures the retention of each kind of record
+type Policy struct {
+	Jobs   Rule
+	Grants Rule
+	Audit  Rule
+}
+
+// Result describes what a run did with one kind of record
+type Result struct {
+	Kind     string `json:"kind"`
+	Archived int    `json:"archived"`
+	Purged   int    `json:"purged"`
+	Error    string `json:"error,omitempty"`
+}
+
+// Report is the outcome of a retention run
+type Report struct {
+	StartedAt  time.Time `json:"started_at"`
+	FinishedAt time.Time `json:"finished_at"`
+	Results    []Result  `json:"results"`
+}
+
+// Worker enforces the retention policy on the store
+type Worker struct {
+	store    store.Store
+	policy   Policy
+	archiver Archiver
+
+	// mu makes sure scheduled and manually triggered runs don't overlap
+	mu sync.Mutex
+}
+
+// NewWorker creates a worker; archiver may be nil when no rule archives records
+func NewWorker(s store.Store, policy Policy, archiver Archiver) (*Worker, error) {
+	for kind, rule := range map[string]Rule{KindJobs: policy.Jobs, KindGrants: policy.Grants, KindAudit: policy.Audit} {
+		switch rule.Action {
+		case "", ActionPurge:
+		case ActionArchive:
+			if archiver == nil {
+				return nil, fmt.Errorf("retention %s: archiving requires an archive destination", kind)
+			}
+		default:
+			return nil, fmt.Errorf("retention %s: unknown action %q", kind, rule.Action)
+		}
+		if rule.MaxAge < 0 {
+			return nil, fmt.Errorf("retention %s: max age must not be negative", kind)
+		}
+	}
+	return &Worker{store: s, policy: policy, archiver: archiver}, nil
+}
+
+// Start runs the worker at the given interval until the context is cancelled
+func (w *Worker) Start(ctx context.Context, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				report := w.Run(ctx)
+				for _, result := range report.Results {
+					if result.Error != "" {
+						log.Printf("Retention of %s failed: %s", result.Kind, result.Error)
+					}
+				}
+			}
+		}
+	}()
+}
+
+// Run archives or purges every kind of record older than its retention window
+func (w *Worker) Run(ctx context.Context) *Report {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	now := time.Now().UTC()
+	report := &Report{StartedAt: now}
+	report.Results = append(report.Results,
+		w.enforce(ctx, KindJobs, w.policy.Jobs, now, w.jobBatch),
+		w.enforce(ctx, KindGrants, w.policy.Grants, now, w.grantBatch),
+		w.enforce(ctx, KindAudit, w.policy.Audit, now, w.auditBatch),
+	)
+	report.FinishedAt = time.Now().UTC()
+	return report
+}
+
+// batch reads up to batchSize records older than the cutoff and returns their
+// IDs, their JSON encodings and a function deleting them
+type batch func(ctx context.Context, before time.Time) (ids []string, records []interface{}, remove func([]string) error, err error)
+
+// enforce applies a rule to one kind of record, a batch at a time
+func (w *Worker) enforce(ctx context.Context, kind string, rule Rule, now time.Time, next batch) Result {
+	result := Result{Kind: kind}
+	if rule.MaxAge == 0 {
+		return result
+	}
+	before := now.Add(-rule.MaxAge)
+
+	for {
+		ids, records, remove, err := next(ctx, before)
+		if err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		if len(ids) == 0 {
+			break
+		}
+
+		if rule.Action == ActionArchive {
+			if err := w.archive(ctx, kind, now, records); err != nil {
+				result.Error = err.Error()
+				return result
+			}
+			result.Archived += len(ids)
+		}
+		if err := remove(ids); err != nil {
+			result.Error = err.Error()
+			return result
+		}
+		result.Purged += len(ids)
+
+		if len(ids) < batchSize {
+			break
+		}
+	}
+	if result.Purged > 0 {
+		log.Printf("Retention removed %d %s records (%d archived)", result.Purged, kind, result.Archived)
+	}
+	return result
+}
+
+// archive exports records as a JSON Lines object
+func (w *Worker) archive(ctx context.Context, kind string, now time.Time, records []interface{}) error {
+	var data []byte
+	for _, record := range records {
+		line, err := json.Marshal(record)
+		if err != nil {
+			return fmt.Errorf("failed to marshal %s record: %v", kind, err)
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+	key := fmt.Sprintf("%s/%s/%s-%d.jsonl", kind, now.Format("2006/01/02"), kind, time.Now().UnixNano())
+	if err := w.archiver.Archive(ctx, key, data); err != nil {
+		return fmt.Errorf("failed to archive %s records: %v", kind, err)
+	}
+	return nil
+}
+
+// jobBatch reads finished jobs
+func (w *Worker) jobBatch(ctx context.Context, before time.Time) ([]string, []interface{}, func([]string) error, error) {
+	jobs, err := w.store.FinishedJobsBefore(ctx, before, batchSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids := make([]string, 0, len(jobs))
+	records := make([]interface{}, 0, len(jobs))
+	for _, job := range jobs {
+		ids = append(ids, job.ID)
+		records = append(records, job)
+	}
+	return ids, records, func(ids []string) error { return w.store.DeleteJobs(ctx, ids) }, nil
+}
+
+// grantBatch reads expired grants
+func (w *Worker) grantBatch(ctx context.Context, before time.Time) ([]string, []interface{}, func([]string) error, error) {
+	grants, err := w.store.ExpiredGrantsBefore(ctx, before, batchSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids := make([]string, 0, len(grants))
+	records := make([]interface{}, 0, len(grants))
+	for _, grant := range grants {
+		ids = append(ids, grant.ID)
+		records = append(records, grant)
+	}
+	return ids, records, func(ids []string) error { return w.store.DeleteGrants(ctx, ids) }, nil
+}
+
+// auditBatch reads old audit entries
+func (w *Worker) auditBatch(ctx context.Context, before time.Time) ([]string, []interface{}, func([]string) error, error) {
+	entries, err := w.store.AuditBefore(ctx, before, batchSize)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	ids := make([]string, 0, len(entries))
+	records := make([]interface{}, 0, len(entries))
+	for _, entry := range entries {
+		ids = append(ids, entry.ID)
+		records = append(records, entry)
+	}
+	return ids, records, func(ids []string) error { return w.store.DeleteAudit(ctx, ids) }, nil
+}
@@ -0,0 +1,132 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/sigv4"
+)
+
+// AWSSecretsManager keeps secrets in AWS Secrets Manager
+type AWSSecretsManager struct {
+	region      string
+	endpoint    string
+	credentials sigv4.Credentials
+	client      *http.Client
+}
+
+// awsError is the error body of the Secrets Manager API
+type awsError struct {
+	Type    string `json:"__type"`
+	Message string `json:"message"`
+}
+
+// NewAWSSecretsManager creates a store for Secrets Manager in the given region
+func NewAWSSecretsManager(region, endpoint string, credentials sigv4.Credentials) (*AWSSecretsManager, error) {
+	if region == "" {
+		return nil, fmt.Errorf("region is required")
+	}
+	if credentials.AccessKeyID == "" || credentials.SecretAccessKey == "" {
+		return nil, fmt.Errorf("access key ID and secret access key are required")
+	}
+	if endpoint == "" {
+		endpoint = "https://secretsmanager." + region + ".amazonaws.com"
+	}
+	return &AWSSecretsManager{
+		region:      region,
+		endpoint:    strings.TrimSuffix(endpoint, "/") + "/",
+		credentials: credentials,
+		client:      &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Put creates the named secret, or stores a new version when it exists
+func (a *AWSSecretsManager) Put(ctx context.Context, name string, value []byte) error {
+	err := a.call(ctx, "CreateSecret", map[string]interface{}{"Name": name, "SecretBinary": value}, nil)
+	if apiErr, ok := err.(*awsError); ok && apiErr.Type == "ResourceExistsException" {
+		err = a.call(ctx, "PutSecretValue", map[string]interface{}{"SecretId": name, "SecretBinary": value}, nil)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to store secret %s: %v", name, err)
+	}
+	return nil
+}
+
+// Get returns the current version of the named secret
+func (a *AWSSecretsManager) Get(ctx context.Context, name string) ([]byte, error) {
+	var resp struct {
+		SecretBinary []byte
+	}
+	err := a.call(ctx, "GetSecretValue", map[string]interface{}{"SecretId": name}, &resp)
+	if apiErr, ok := err.(*awsError); ok && apiErr.Type == "ResourceNotFoundException" {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %v", name, err)
+	}
+	return resp.SecretBinary, nil
+}
+
+// Delete removes the named secret right away, without a recovery window
+func (a *AWSSecretsManager) Delete(ctx context.Context, name string) error {
+	err := a.call(ctx, "DeleteSecret", map[string]interface{}{"SecretId": name, "ForceDeleteWithoutRecovery": true}, nil)
+	if apiErr, ok := err.(*awsError); ok && apiErr.Type == "ResourceNotFoundException" {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to delete secret %s: %v", name, err)
+	}
+	return nil
+}
+
+// Error describes an error returned by the Secrets Manager API
+func (e *awsError) Error() string {
+	return e.Type + ": " + e.Message
+}
+
+// call invokes a Secrets Manager API action; byte slices travel base64 encoded both ways
+func (a *AWSSecretsManager) call(ctx context.Context, action string, input, output interface{}) error {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return fmt.Errorf("failed to marshal %s request: %v", action, err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", "secretsmanager."+action)
+	sigv4.Sign(req, body, a.credentials, a.region, "secretsmanager", time.Now().UTC())
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call Secrets Manager %s: %v", action, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		apiErr := &awsError{}
+		if json.Unmarshal(message, apiErr) == nil && apiErr.Type != "" {
+			// Types may be qualified, e.g. com.amazonaws...#ResourceNotFoundException
+			if i := strings.LastIndex(apiErr.Type, "#"); i >= 0 {
+				apiErr.Type = apiErr.Type[i+1:]
+			}
+			return apiErr
+		}
+		return fmt.Errorf("Secrets Manager %s returned status %d: %s", action, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode %s response: %v", action, err)
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package secrets
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ErrInvalidReference is returned for references that were not issued by
+// the server or have expired
+var ErrInvalidReference = errors.New("invalid or expired credential reference")
+
+// References issues short-lived references to the credentials of a grant.
+// Holders exchange a reference for the credentials, so the credentials
+// themselves are only handed out in that one response. References are signed
+// rather than stored; API servers sharing the key accept each other's.
+type References struct {
+	key []byte
+	ttl time.Duration
+}
+
+// NewReferences creates references signed with the key and valid for ttl
+func NewReferences(key []byte, ttl time.Duration) (*References, error) {
+	if len(key) < 32 {
+		return nil, fmt.Errorf("reference key must be at least 32 bytes")
+	}
+	if ttl <= 0 {
+		return nil, fmt.Errorf("reference lifetime must be positive")
+	}
+	return &References{key: key, ttl: ttl}, nil
+}
+
+// Issue returns a reference to the credentials of a grant for its holder and when it expires
+func (r *References) Issue(grantID, holder string, now time.Time) (string, time.Time) {
+	expiresAt := now.Add(r.ttl).Truncate(time.Second)
+	payload := strings.Join([]string{grantID, holder, strconv.FormatInt(expiresAt.Unix(), 10)}, "\n")
+	encoded := base64.RawURLEncoding.EncodeToString([]byte(payload))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(r.sign(payload)), expiresAt
+}
+
+// Resolve returns the grant and holder a valid reference was issued for
+func (r *References) Resolve(reference string, now time.Time) (string, string, error) {
+	encoded, signature, ok := strings.Cut(reference, ".")
+	if !ok {
+		return "", "", ErrInvalidReference
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return "", "", ErrInvalidReference
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(signature)
+	if err != nil || !hmac.Equal(mac, r.sign(string(payload))) {
+		return "", "", ErrInvalidReference
+	}
+
+	fields := strings.Split(string(payload), "\n")
+	if len(fields) != 3 {
+		return "", "", ErrInvalidReference
+	}
+	expiresAt, err := strconv.ParseInt(fields[2], 10, 64)
+	if err != nil || !now.Before(time.Unix(expiresAt, 0)) {
+		return "", "", ErrInvalidReference
+	}
+	return fields[0], fields[1], nil
+}
+
+// sign returns the signature of a reference's payload
+func (r *References) sign(payload string) []byte {
+	mac := hmac.New(sha256.New, r.key)
+	mac.Write([]byte("apollo-credential-reference\n" + payload))
+	return mac.Sum(nil)
+}
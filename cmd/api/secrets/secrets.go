@@ -0,0 +1,26 @@
+package secrets
+
+import (
+	"context"
+	"errors"
+)
+
+// ErrNotFound is returned when a secret does not exist
+var ErrNotFound = errors.New("secret not found")
+
+// Store keeps secrets by name outside Apollo's database. The API server
+// writes the sealed credentials of grants to it, so neither the database nor
+// job payloads hold them.
+type Store interface {
+	// Put creates or replaces the named secret
+	Put(ctx context.Context, name string, value []byte) error
+	// Get returns the named secret
+	Get(ctx context.Context, name string) ([]byte, error)
+	// Delete removes the named secret; deleting a missing secret is not an error
+	Delete(ctx context.Context, name string) error
+}
+
+// GrantSecretName returns the name of the secret holding a grant's credentials
+func GrantSecretName(grantID string) string {
+	return "apollo/grants/" + grantID
+}
@@ -0,0 +1,114 @@
+package secrets
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Vault keeps secrets in a HashiCorp Vault KV version 2 secrets engine
+type Vault struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+}
+
+// NewVault creates a store for the KV engine mounted at mount, "secret" by default
+func NewVault(address, token, mount string) (*Vault, error) {
+	if address == "" || token == "" {
+		return nil, fmt.Errorf("vault address and token are required")
+	}
+	if mount == "" {
+		mount = "secret"
+	}
+	return &Vault{
+		address: strings.TrimSuffix(address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Put writes a new version of the named secret
+func (v *Vault) Put(ctx context.Context, name string, value []byte) error {
+	body := map[string]interface{}{
+		"data": map[string]string{"value": base64.StdEncoding.EncodeToString(value)},
+	}
+	return v.call(ctx, http.MethodPost, "data/"+name, body, nil)
+}
+
+// Get reads the latest version of the named secret
+func (v *Vault) Get(ctx context.Context, name string) ([]byte, error) {
+	var resp struct {
+		Data struct {
+			Data struct {
+				Value string `json:"value"`
+			} `json:"data"`
+		} `json:"data"`
+	}
+	if err := v.call(ctx, http.MethodGet, "data/"+name, nil, &resp); err != nil {
+		return nil, err
+	}
+	value, err := base64.StdEncoding.DecodeString(resp.Data.Data.Value)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode vault secret %s: %v", name, err)
+	}
+	return value, nil
+}
+
+// Delete removes all versions of the named secret
+func (v *Vault) Delete(ctx context.Context, name string) error {
+	err := v.call(ctx, http.MethodDelete, "metadata/"+name, nil, nil)
+	if err == ErrNotFound {
+		return nil
+	}
+	return err
+}
+
+// call sends a request to the KV engine
+func (v *Vault) call(ctx context.Context, method, path string, input, output interface{}) error {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return fmt.Errorf("failed to marshal vault request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+v.mount+"/"+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return ErrNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode vault response: %v", err)
+	}
+	return nil
+}
@@ -2,22 +2,64 @@ package main
 
 import (
 	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
 	"flag"
 	"fmt"
 	"log"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
 	"time"
 
+	"github.com/petermein/apollo/cmd/api/attest"
+	"github.com/petermein/apollo/cmd/api/cache"
 	"github.com/petermein/apollo/cmd/api/config"
+	"github.com/petermein/apollo/cmd/api/digest"
+	"github.com/petermein/apollo/cmd/api/discord"
+	"github.com/petermein/apollo/cmd/api/envelope"
+	"github.com/petermein/apollo/cmd/api/features"
 	"github.com/petermein/apollo/cmd/api/handler"
+	"github.com/petermein/apollo/cmd/api/mattermost"
 	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/modules/mock"
 	"github.com/petermein/apollo/cmd/api/modules/mysql"
+	"github.com/petermein/apollo/cmd/api/notify"
+	"github.com/petermein/apollo/cmd/api/outbox"
+	"github.com/petermein/apollo/cmd/api/report"
+	"github.com/petermein/apollo/cmd/api/retention"
+	"github.com/petermein/apollo/cmd/api/secrets"
+	"github.com/petermein/apollo/cmd/api/siem"
+	"github.com/petermein/apollo/cmd/api/slack"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/anomaly"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/directory"
+	"github.com/petermein/apollo/internal/oncall"
+	"github.com/petermein/apollo/internal/redact"
+	"github.com/petermein/apollo/internal/rules"
+	"github.com/petermein/apollo/internal/sigv4"
+	"github.com/petermein/apollo/internal/webauthn"
+	"google.golang.org/grpc"
+)
+
+// Keep-alive settings of the HTTP server
+const (
+	// readHeaderTimeout bounds how long a client may take to send a request's headers
+	readHeaderTimeout = 10 * time.Second
+	// defaultIdleTimeout is how long idle connections are kept open by default
+	defaultIdleTimeout = 2 * time.Minute
 )
 
 func main() {
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
 	// Parse command line flags
 	configPath := flag.String("config", "config.yaml", "Path to config file")
 	flag.Parse()
@@ -28,13 +70,60 @@ func main() {
 		log.Fatalf("Failed to load config: %v", err)
 	}
 
+	// Open the store
+	if cfg.Database.Driver == "" || cfg.Database.Driver == store.DriverMemory {
+		log.Printf("Using in-memory storage; state is lost when the server stops")
+	}
+	connectTimeout, reconnectDelay, err := databaseResilience(cfg)
+	if err != nil {
+		log.Fatalf("Invalid database configuration: %v", err)
+	}
+	st, err := store.Open(context.Background(), store.Config{
+		Driver:           cfg.Database.Driver,
+		DSN:              cfg.Database.DSN,
+		ReadDSN:          cfg.Database.ReadDSN,
+		DriverName:       cfg.Database.DriverName,
+		MaxOpenConns:     cfg.Database.MaxOpenConns,
+		ConnectTimeout:   connectTimeout,
+		BreakerThreshold: cfg.Database.BreakerThreshold,
+		ReconnectDelay:   reconnectDelay,
+	})
+	if err != nil {
+		log.Fatalf("Failed to open store: %v", err)
+	}
+	if cfg.Cache.Type != "" {
+		st, err = newCachedStore(cfg, st)
+		if err != nil {
+			log.Fatalf("Failed to set up cache: %v", err)
+		}
+		log.Printf("Caching reads in %s", cfg.Cache.Type)
+	}
+	defer st.Close()
+
+	// Audit entries are logged and kept in the store until retention removes them
+	var auditRecorder audit.Recorder = audit.MultiRecorder{audit.LogRecorder{}, st}
+	var authMiddleware *auth.Middleware
+	if len(cfg.Auth.JWT.Issuers) > 0 {
+		if authMiddleware, err = newAuthMiddleware(cfg); err != nil {
+			log.Fatalf("Failed to configure bearer token authentication: %v", err)
+		}
+		auditRecorder = auth.AuditRecorder(auditRecorder)
+		log.Printf("Authenticating requests by bearer tokens of %d issuers", len(cfg.Auth.JWT.Issuers))
+	}
+
 	// Create module registry
 	registry := modules.NewRegistry()
 
 	// Register MySQL module
 	mysqlModule := mysql.NewModule()
+	mysqlModule.SetStore(st)
 	registry.Register(mysqlModule)
 
+	// Register the mock module, which simulates a target system
+	mockModule := mock.NewModule()
+	mockModule.SetStore(st)
+	registry.Register(mockModule)
+
 	// Get enabled modules
 	enabledModules := registry.GetEnabledModules(cfg.Server.EnabledModules)
 	if len(enabledModules) == 0 {
@@ -55,12 +144,273 @@ func main() {
 
 	// Create HTTP server
 	mux := http.NewServeMux()
-	h := handler.NewHandler(enabledModules)
+	h := handler.NewHandler(enabledModules, st)
+	if err := h.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		log.Fatalf("Failed to configure trusted proxies: %v", err)
+	}
+	h.SetAdmins(cfg.Server.Admins)
+	h.SetAuditRecorder(auditRecorder)
+	h.SetRegion(cfg.Server.Region)
+	featureFlags, err := newFeatureFlags(cfg)
+	if err != nil {
+		log.Fatalf("Failed to load feature flags: %v", err)
+	}
+	h.SetFeatures(featureFlags)
+	var jobBus bus.Bus
+	if cfg.Bus.Type != "" {
+		jobBus, err = bus.Open(bus.Config{Type: cfg.Bus.Type, URL: cfg.Bus.URL}, "apollo-api")
+		if err != nil {
+			log.Fatalf("Failed to connect to the job bus: %v", err)
+		}
+		defer jobBus.Close()
+		if err := h.SetJobBus(jobBus); err != nil {
+			log.Fatalf("Failed to subscribe to job results: %v", err)
+		}
+		log.Printf("Dispatching jobs over %s", cfg.Bus.Type)
+	}
+	var exportThreshold time.Duration
+	if cfg.AuditExport.AsyncThreshold != "" {
+		if exportThreshold, err = time.ParseDuration(cfg.AuditExport.AsyncThreshold); err != nil {
+			log.Fatalf("Invalid audit export threshold: %v", err)
+		}
+	}
+	if err := h.SetAuditExport(cfg.AuditExport.Dir, []byte(cfg.AuditExport.SigningKey), exportThreshold); err != nil {
+		log.Fatalf("Failed to configure audit export: %v", err)
+	}
+	if len(cfg.Approvals.SigningKeys) > 0 {
+		signer, err := attest.NewSigner(cfg.Approvals.SigningKeys, cfg.Approvals.CurrentKey)
+		if err != nil {
+			log.Fatalf("Failed to configure approval signing: %v", err)
+		}
+		h.SetApprovalSigner(signer)
+		log.Printf("Signing approvals with key %s", signer.KeyID())
+	} else {
+		log.Printf("No approval signing keys configured; approvals can't be verified after a restart")
+	}
+	if cfg.Encryption.Provider != "" {
+		keys, err := newKeyManager(cfg)
+		if err != nil {
+			log.Fatalf("Failed to configure credential encryption: %v", err)
+		}
+		h.SetEncrypter(envelope.New(keys))
+		log.Printf("Encrypting grant credentials with %s key %s", cfg.Encryption.Provider, keys.KeyID())
+	} else {
+		log.Printf("No encryption provider configured; grant credentials don't survive a restart")
+	}
+	secretStore, err := newSecretStore(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure the secret store: %v", err)
+	}
+	if secretStore != nil {
+		h.SetSecretStore(secretStore)
+		log.Printf("Keeping grant credentials in %s", cfg.Secrets.Provider)
+	}
+	if cfg.Secrets.ReferenceKey != "" || cfg.Secrets.ReferenceTTL != "" {
+		references, err := newReferences(cfg)
+		if err != nil {
+			log.Fatalf("Failed to configure credential references: %v", err)
+		}
+		h.SetReferences(references)
+	}
+
+	// Create the rule engine
+	engineName := cfg.Rules.Engine
+	if engineName == "" {
+		engineName = rules.EngineDefault
+	}
+	var decodeEngineConfig func(interface{}) error
+	if cfg.Rules.EngineConfig.Kind != 0 {
+		decodeEngineConfig = cfg.Rules.EngineConfig.Decode
+	}
+	ruleEngine, err := rules.NewEngine(engineName, decodeEngineConfig)
+	if err != nil {
+		log.Fatalf("Failed to create rule engine: %v", err)
+	}
+	log.Printf("Using %s rule engine", engineName)
+	h.SetRuleEngine(ruleEngine)
+
+	// Load rule definitions and keep them up to date
+	engine, isDefault := ruleEngine.(*rules.DefaultRuleEngine)
+	if isDefault {
+		engine.SetAuditRecorder(auditRecorder)
+		if cfg.Rules.OnCallURL != "" {
+			engine.SetOnCallChecker(rules.NewScheduleAPIChecker(cfg.Rules.OnCallURL))
+		}
+	}
+	var schedule *oncall.Cache
+	if cfg.OnCall.Provider != "" {
+		if schedule, err = newOnCallSchedule(cfg); err != nil {
+			log.Fatalf("Failed to configure on-call schedule: %v", err)
+		}
+		log.Printf("Reading on-call schedules from %s", cfg.OnCall.Provider)
+		if isDefault {
+			engine.SetOnCallChecker(schedule)
+		}
+	}
+	if isDefault && (cfg.Rules.Path != "" || cfg.Rules.VersionsDir != "") {
+		interval := time.Minute
+		if cfg.Rules.ReloadInterval != "" {
+			if interval, err = time.ParseDuration(cfg.Rules.ReloadInterval); err != nil {
+				log.Fatalf("Invalid rules reload interval: %v", err)
+			}
+		}
+
+		var source rules.Source = &rules.FileSource{Path: cfg.Rules.Path}
+		var versions *rules.VersionStore
+		if cfg.Rules.VersionsDir != "" {
+			if versions, err = rules.NewVersionStore(cfg.Rules.VersionsDir); err != nil {
+				log.Fatalf("Failed to open policy versions: %v", err)
+			}
+			source = versions
+		}
+
+		reloader := rules.NewReloader(source, engine, interval)
+		if err := reloader.Reload(context.Background()); err != nil {
+			// A fresh version store has no active version yet; built-in rules apply until one is promoted
+			if versions == nil {
+				log.Fatalf("Failed to load rules: %v", err)
+			}
+			log.Printf("Using built-in rules: %v", err)
+		}
+		reloader.Start(context.Background())
+		if versions != nil {
+			h.SetPolicyVersions(versions, reloader)
+		}
+	}
+	if cfg.Auth.OIDC.Issuer != "" {
+		h.SetStepUpVerifier(auth.NewVerifier(cfg.Auth.OIDC.Issuer, cfg.Auth.OIDC.ClientID))
+	}
+	if cfg.Anomaly.Enabled {
+		anomalyConfig := anomaly.Config{
+			Timezone:        cfg.Anomaly.Timezone,
+			QuietHoursStart: cfg.Anomaly.QuietHoursStart,
+			QuietHoursEnd:   cfg.Anomaly.QuietHoursEnd,
+			SpikeThreshold:  cfg.Anomaly.SpikeThreshold,
+		}
+		if cfg.Anomaly.Interval != "" {
+			if anomalyConfig.Interval, err = time.ParseDuration(cfg.Anomaly.Interval); err != nil {
+				log.Fatalf("Invalid anomaly interval: %v", err)
+			}
+		}
+		if cfg.Anomaly.SpikeWindow != "" {
+			if anomalyConfig.SpikeWindow, err = time.ParseDuration(cfg.Anomaly.SpikeWindow); err != nil {
+				log.Fatalf("Invalid anomaly spike window: %v", err)
+			}
+		}
+		if err := h.StartAnomalyDetection(context.Background(), anomalyConfig, auditRecorder); err != nil {
+			log.Fatalf("Failed to start anomaly detection: %v", err)
+		}
+	}
+	cleanupInterval, pendingTTL, cleanup, err := cleanupPolicy(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure cleanup: %v", err)
+	}
+	h.SetPendingTTL(pendingTTL)
+	h.StartCleanup(context.Background(), cleanupInterval, cleanup)
+	archiveInterval, archiveAfter, err := jobArchival(cfg)
+	if err != nil {
+		log.Fatalf("Failed to configure job archival: %v", err)
+	}
+	h.StartJobArchival(context.Background(), archiveInterval, archiveAfter)
+	if cfg.Directory.Provider != "" {
+		source, interval, err := newGroupSource(cfg)
+		if err != nil {
+			log.Fatalf("Failed to configure group sync: %v", err)
+		}
+		h.SetGroupSource(source)
+		h.StartGroupSync(context.Background(), interval)
+		log.Printf("Syncing groups from %s every %s", cfg.Directory.Provider, interval)
+	}
+	if cfg.Retention.Enabled {
+		worker, interval, err := newRetentionWorker(cfg, st)
+		if err != nil {
+			log.Fatalf("Failed to configure retention: %v", err)
+		}
+		worker.Start(context.Background(), interval)
+		h.SetRetention(worker)
+	}
+	var mattermostActions *mattermost.Actions
+	if cfg.Mattermost.WebhookURL != "" && cfg.Mattermost.Actions.Enabled {
+		if mattermostActions, err = newMattermostActions(cfg); err != nil {
+			log.Fatalf("Failed to configure Mattermost actions: %v", err)
+		}
+		h.SetMattermostActions(mattermostActions)
+	}
+	dispatcher, interval, err := newEventDispatcher(cfg, st, jobBus, ruleEngine, schedule, mattermostActions, featureFlags)
+	if err != nil {
+		log.Fatalf("Failed to configure event delivery: %v", err)
+	}
+	dispatcher.Start(context.Background(), interval)
+	h.SetEventDispatcher(dispatcher)
+	if cfg.Digest.Enabled {
+		approvalDigest, links, err := newApprovalDigest(cfg, st)
+		if err != nil {
+			log.Fatalf("Failed to configure approval digest: %v", err)
+		}
+		approvalDigest.Start(context.Background())
+		h.SetApprovalLinks(links)
+	}
+	if cfg.Reports.Enabled {
+		owner := func(resourceID string) string { return "" }
+		if isDefault {
+			owner = func(resourceID string) string {
+				return engine.Rules().Resources[resourceID].Owner
+			}
+		}
+		generator, err := newReportGenerator(cfg, st, owner)
+		if err != nil {
+			log.Fatalf("Failed to configure reports: %v", err)
+		}
+		generator.Start(context.Background())
+		h.SetReports(generator)
+		log.Printf("Generating usage reports")
+	}
 	h.RegisterRoutes(mux)
 
-	srv := &http.Server{
-		Addr:    fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
-		Handler: mux,
+	var routes http.Handler = h.ValidateRequests(mux)
+	if authMiddleware != nil {
+		h.SetAdminGroups(cfg.Auth.JWT.AdminGroups)
+		routes = authMiddleware.Wrap(h.TrackSessions(routes))
+		if cfg.Auth.CookieSessions.Enabled {
+			policy, err := newCookieSessionPolicy(cfg)
+			if err != nil {
+				log.Fatalf("Failed to configure cookie sessions: %v", err)
+			}
+			h.SetCookieSessions(policy)
+			routes = h.AuthenticateCookies(routes)
+			log.Printf("Browsers can start cookie sessions lasting %s", policy.TTL)
+		}
+	} else if cfg.Auth.CookieSessions.Enabled {
+		log.Fatalf("Cookie sessions are started with bearer tokens and need auth.jwt issuers")
+	}
+	routes = h.AuthenticateServiceAccounts(routes)
+	if len(cfg.Auth.OperatorHMAC.Secrets) > 0 {
+		verifier, err := newOperatorVerifier(cfg)
+		if err != nil {
+			log.Fatalf("Failed to configure operator request signing: %v", err)
+		}
+		h.SetOperatorSigning(verifier, cfg.Auth.OperatorHMAC.Required)
+		log.Printf("Checking signed requests of %d operators", len(cfg.Auth.OperatorHMAC.Secrets))
+	}
+	var operatorTokenTTL time.Duration
+	if cfg.Auth.OperatorTokens.TTL != "" {
+		if operatorTokenTTL, err = time.ParseDuration(cfg.Auth.OperatorTokens.TTL); err != nil {
+			log.Fatalf("Invalid operator token TTL: %v", err)
+		}
+	}
+	h.SetOperatorTokens(operatorTokenTTL, cfg.Auth.OperatorTokens.Required)
+	routes = h.AuthenticateOperators(routes)
+	if cfg.Auth.WebAuthn.RPID != "" {
+		rp, err := newRelyingParty(cfg)
+		if err != nil {
+			log.Fatalf("Failed to configure WebAuthn: %v", err)
+		}
+		h.SetWebAuthn(rp)
+		log.Printf("Approvers confirm with hardware keys for %s", cfg.Auth.WebAuthn.RPID)
+	}
+	srv, err := newHTTPServer(cfg, h.DegradeGracefully(routes))
+	if err != nil {
+		log.Fatalf("Invalid server configuration: %v", err)
 	}
 
 	// Start server in a goroutine
@@ -71,6 +421,28 @@ func main() {
 		}
 	}()
 
+	var operatorRPC *grpc.Server
+	if cfg.Server.GRPCPort != 0 {
+		addr := fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.GRPCPort)
+		lis, err := net.Listen("tcp", addr)
+		if err != nil {
+			log.Fatalf("Failed to listen for operators on %s: %v", addr, err)
+		}
+		var pollInterval time.Duration
+		if cfg.Server.GRPCPollInterval != "" {
+			if pollInterval, err = time.ParseDuration(cfg.Server.GRPCPollInterval); err != nil {
+				log.Fatalf("Invalid gRPC poll interval: %v", err)
+			}
+		}
+		operatorRPC = h.NewOperatorRPCServer(pollInterval)
+		go func() {
+			log.Printf("Serving operators over gRPC on %s", addr)
+			if err := operatorRPC.Serve(lis); err != nil {
+				log.Fatalf("Failed to serve operators over gRPC: %v", err)
+			}
+		}()
+	}
+
 	// Wait for interrupt signal
 	quit := make(chan os.Signal, 1)
 	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
@@ -81,9 +453,718 @@ func main() {
 	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
 	defer cancel()
 
+	if operatorRPC != nil {
+		// Job streams only end when operators disconnect, so they are cut off
+		operatorRPC.Stop()
+	}
 	if err := srv.Shutdown(ctx); err != nil {
 		log.Fatalf("Server forced to shutdown: %v", err)
 	}
 
 	log.Println("Server exiting")
 }
+
+// newHTTPServer creates the server for the API's routes. It compresses
+// responses unless configured not to, and keeps connections open between
+// requests, over HTTP/1.1 or HTTP/2 without TLS for proxies that speak it.
+func newHTTPServer(cfg *config.Config, routes http.Handler) (*http.Server, error) {
+	idleTimeout := defaultIdleTimeout
+	if cfg.Server.IdleTimeout != "" {
+		var err error
+		if idleTimeout, err = time.ParseDuration(cfg.Server.IdleTimeout); err != nil {
+			return nil, fmt.Errorf("invalid idle timeout: %v", err)
+		}
+	}
+	if !cfg.Server.DisableCompression {
+		routes = handler.Compress(routes)
+	}
+	var protocols http.Protocols
+	protocols.SetHTTP1(true)
+	protocols.SetUnencryptedHTTP2(true)
+	return &http.Server{
+		Addr:              fmt.Sprintf("%s:%d", cfg.Server.Host, cfg.Server.Port),
+		Handler:           routes,
+		Protocols:         &protocols,
+		ReadHeaderTimeout: readHeaderTimeout,
+		IdleTimeout:       idleTimeout,
+	}, nil
+}
+
+// newAuthMiddleware creates the middleware authenticating requests by the
+// bearer tokens of the configured issuers
+func newAuthMiddleware(cfg *config.Config) (*auth.Middleware, error) {
+	jwt := cfg.Auth.JWT
+	middlewareConfig := auth.MiddlewareConfig{
+		Required: jwt.Required,
+		Public:   append(append([]string(nil), handler.PublicPaths...), jwt.Public...),
+	}
+	for _, issuer := range jwt.Issuers {
+		middlewareConfig.Issuers = append(middlewareConfig.Issuers, auth.IssuerConfig{
+			Issuer:      issuer.Issuer,
+			Audiences:   issuer.Audiences,
+			JWKSURL:     issuer.JWKSURL,
+			UserClaim:   issuer.UserClaim,
+			GroupsClaim: issuer.GroupsClaim,
+			Nonce:       issuer.Nonce,
+		})
+	}
+	var err error
+	if jwt.JWKSRefresh != "" {
+		if middlewareConfig.JWKSRefresh, err = time.ParseDuration(jwt.JWKSRefresh); err != nil {
+			return nil, fmt.Errorf("invalid JWKS refresh: %v", err)
+		}
+	}
+	if jwt.Leeway != "" {
+		if middlewareConfig.Leeway, err = time.ParseDuration(jwt.Leeway); err != nil {
+			return nil, fmt.Errorf("invalid leeway: %v", err)
+		}
+	}
+	return auth.NewMiddleware(middlewareConfig)
+}
+
+// newOperatorVerifier creates the verifier of requests signed by operators
+func newOperatorVerifier(cfg *config.Config) (*auth.HMACVerifier, error) {
+	signing := cfg.Auth.OperatorHMAC
+	secrets := make(map[string][]byte, len(signing.Secrets))
+	for id, encoded := range signing.Secrets {
+		secret, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid secret of operator %s: %v", id, err)
+		}
+		secrets[id] = secret
+	}
+	var maxSkew time.Duration
+	if signing.MaxSkew != "" {
+		var err error
+		if maxSkew, err = time.ParseDuration(signing.MaxSkew); err != nil {
+			return nil, fmt.Errorf("invalid max skew: %v", err)
+		}
+	}
+	return auth.NewHMACVerifier(secrets, maxSkew)
+}
+
+// databaseResilience returns how long to retry the database at startup and
+// the first delay between reconnection attempts after it became unavailable
+func databaseResilience(cfg *config.Config) (time.Duration, time.Duration, error) {
+	connectTimeout, reconnectDelay := 30*time.Second, store.DefaultReconnectDelay
+	var err error
+	if cfg.Database.ConnectTimeout != "" {
+		if connectTimeout, err = time.ParseDuration(cfg.Database.ConnectTimeout); err != nil {
+			return 0, 0, fmt.Errorf("invalid connect timeout: %v", err)
+		}
+	}
+	if cfg.Database.ReconnectDelay != "" {
+		if reconnectDelay, err = time.ParseDuration(cfg.Database.ReconnectDelay); err != nil {
+			return 0, 0, fmt.Errorf("invalid reconnect delay: %v", err)
+		}
+	}
+	return connectTimeout, reconnectDelay, nil
+}
+
+// newCachedStore wraps the store with the configured cache
+func newCachedStore(cfg *config.Config, st store.Store) (store.Store, error) {
+	var ttl time.Duration
+	if cfg.Cache.TTL != "" {
+		var err error
+		ttl, err = time.ParseDuration(cfg.Cache.TTL)
+		if err != nil {
+			return nil, fmt.Errorf("invalid cache ttl: %v", err)
+		}
+	}
+	c, err := cache.Open(cache.Config{Type: cfg.Cache.Type, URL: cfg.Cache.URL})
+	if err != nil {
+		return nil, err
+	}
+	return store.NewCachedStore(st, c, ttl), nil
+}
+
+// newKeyManager creates the key manager holding the master key for grant credentials
+func newKeyManager(cfg *config.Config) (envelope.KeyManager, error) {
+	encryption := cfg.Encryption
+	switch encryption.Provider {
+	case "local":
+		return envelope.NewLocalKeys(encryption.Local.Keys, encryption.Local.CurrentKey)
+	case "aws-kms":
+		kms := encryption.AWSKMS
+		return envelope.NewAWSKMS(kms.KeyID, kms.Region, kms.Endpoint, sigv4.Credentials{
+			AccessKeyID:     kms.AccessKeyID,
+			SecretAccessKey: kms.SecretAccessKey,
+			SessionToken:    kms.SessionToken,
+		})
+	case "gcp-kms":
+		return envelope.NewGCPKMS(encryption.GCPKMS.KeyName, encryption.GCPKMS.Endpoint)
+	default:
+		return nil, fmt.Errorf("unknown encryption provider %q", encryption.Provider)
+	}
+}
+
+// newSecretStore creates the store for sealed grant credentials; nil keeps them in the database
+func newSecretStore(cfg *config.Config) (secrets.Store, error) {
+	s := cfg.Secrets
+	switch s.Provider {
+	case "", "database":
+		return nil, nil
+	case "vault":
+		return secrets.NewVault(s.Vault.Address, s.Vault.Token, s.Vault.Mount)
+	case "aws-secrets-manager":
+		sm := s.AWSSecretsManager
+		return secrets.NewAWSSecretsManager(sm.Region, sm.Endpoint, sigv4.Credentials{
+			AccessKeyID:     sm.AccessKeyID,
+			SecretAccessKey: sm.SecretAccessKey,
+			SessionToken:    sm.SessionToken,
+		})
+	default:
+		return nil, fmt.Errorf("unknown secret store provider %q", s.Provider)
+	}
+}
+
+// newReferences creates the references holders redeem for grant credentials
+func newReferences(cfg *config.Config) (*secrets.References, error) {
+	ttl := handler.DefaultReferenceTTL
+	var err error
+	if cfg.Secrets.ReferenceTTL != "" {
+		if ttl, err = time.ParseDuration(cfg.Secrets.ReferenceTTL); err != nil {
+			return nil, fmt.Errorf("invalid reference lifetime: %v", err)
+		}
+	}
+	key := make([]byte, 32)
+	if cfg.Secrets.ReferenceKey != "" {
+		if key, err = base64.StdEncoding.DecodeString(cfg.Secrets.ReferenceKey); err != nil {
+			return nil, fmt.Errorf("invalid reference key: %v", err)
+		}
+	} else if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate reference key: %v", err)
+	}
+	return secrets.NewReferences(key, ttl)
+}
+
+// newRelyingParty creates the WebAuthn relying party approvers confirm
+// approvals with
+func newRelyingParty(cfg *config.Config) (*webauthn.RelyingParty, error) {
+	var ttl time.Duration
+	var err error
+	if cfg.Auth.WebAuthn.ChallengeTTL != "" {
+		if ttl, err = time.ParseDuration(cfg.Auth.WebAuthn.ChallengeTTL); err != nil {
+			return nil, fmt.Errorf("invalid challenge lifetime: %v", err)
+		}
+	}
+	var key []byte
+	if cfg.Auth.WebAuthn.ChallengeKey != "" {
+		if key, err = base64.StdEncoding.DecodeString(cfg.Auth.WebAuthn.ChallengeKey); err != nil {
+			return nil, fmt.Errorf("invalid challenge key: %v", err)
+		}
+	}
+	return webauthn.NewRelyingParty(cfg.Auth.WebAuthn.RPID, cfg.Auth.WebAuthn.RPName, cfg.Auth.WebAuthn.Origins, key, ttl)
+}
+
+// newCookieSessionPolicy reads the configuration of browser sessions
+func newCookieSessionPolicy(cfg *config.Config) (handler.CookieSessionPolicy, error) {
+	policy := handler.CookieSessionPolicy{
+		TTL:      handler.DefaultCookieSessionTTL,
+		Insecure: cfg.Auth.CookieSessions.Insecure,
+		Origins:  cfg.Auth.CookieSessions.Origins,
+	}
+	if cfg.Auth.CookieSessions.TTL != "" {
+		ttl, err := time.ParseDuration(cfg.Auth.CookieSessions.TTL)
+		if err != nil {
+			return policy, fmt.Errorf("invalid session lifetime: %v", err)
+		}
+		policy.TTL = ttl
+	}
+	if policy.Insecure {
+		log.Printf("Warning: session cookies are sent over plain HTTP")
+	}
+	return policy, nil
+}
+
+// newApprovalDigest creates the daily approval digest and the links it mails
+func newApprovalDigest(cfg *config.Config, st store.Store) (*digest.Digest, *digest.Links, error) {
+	ttl := 24 * time.Hour
+	var err error
+	if cfg.Digest.LinkTTL != "" {
+		if ttl, err = time.ParseDuration(cfg.Digest.LinkTTL); err != nil {
+			return nil, nil, fmt.Errorf("invalid link lifetime: %v", err)
+		}
+	}
+	key := make([]byte, 32)
+	if cfg.Digest.LinkKey != "" {
+		if key, err = base64.StdEncoding.DecodeString(cfg.Digest.LinkKey); err != nil {
+			return nil, nil, fmt.Errorf("invalid link key: %v", err)
+		}
+	} else if _, err := rand.Read(key); err != nil {
+		return nil, nil, fmt.Errorf("failed to generate link key: %v", err)
+	}
+	links, err := digest.NewLinks(key, ttl)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	smtp := cfg.Digest.SMTP
+	mailer, err := digest.NewSMTP(smtp.Address, smtp.From, smtp.Username, smtp.Password)
+	if err != nil {
+		return nil, nil, err
+	}
+	approvalDigest, err := digest.New(st, mailer, links, digest.Config{
+		At:               cfg.Digest.At,
+		Timezone:         cfg.Digest.Timezone,
+		BaseURL:          cfg.Digest.BaseURL,
+		DefaultApprovers: cfg.Digest.DefaultApprovers,
+		EmailDomain:      cfg.Digest.EmailDomain,
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+	return approvalDigest, links, nil
+}
+
+// newFeatureFlags loads the feature flags, reloading them from their file and
+// the environment while the server runs
+func newFeatureFlags(cfg *config.Config) (*features.Set, error) {
+	flags, err := features.New(cfg.Features.Flags, cfg.Features.Path)
+	if err != nil {
+		return nil, err
+	}
+	interval := 30 * time.Second
+	if cfg.Features.ReloadInterval != "" {
+		if interval, err = time.ParseDuration(cfg.Features.ReloadInterval); err != nil {
+			return nil, fmt.Errorf("invalid feature flags reload interval: %v", err)
+		}
+	}
+	flags.Start(context.Background(), interval)
+	for _, flag := range flags.List() {
+		if flag.Rollout < 100 {
+			log.Printf("Feature %s is on for %d%% of users (%s)", flag.Name, flag.Rollout, flag.Source)
+		}
+	}
+	return flags, nil
+}
+
+// newReportGenerator creates the generator of usage reports, mailing them when
+// an SMTP server is configured
+func newReportGenerator(cfg *config.Config, st store.Store, owner func(string) string) (*report.Generator, error) {
+	var mailer digest.Mailer
+	if smtp := cfg.Reports.SMTP; smtp.Address != "" {
+		m, err := digest.NewSMTP(smtp.Address, smtp.From, smtp.Username, smtp.Password)
+		if err != nil {
+			return nil, err
+		}
+		mailer = m
+	}
+	return report.New(st, owner, mailer, report.Config{
+		Period:     cfg.Reports.Period,
+		Timezone:   cfg.Reports.Timezone,
+		Dir:        cfg.Reports.Dir,
+		Recipients: cfg.Reports.Recipients,
+	})
+}
+
+// cleanupPolicy returns how often the cleanup worker runs, how long requests
+// may stay pending and what else it cleans up
+func cleanupPolicy(cfg *config.Config) (time.Duration, time.Duration, handler.CleanupPolicy, error) {
+	interval := time.Minute
+	policy := handler.CleanupPolicy{OperatorTimeout: 2 * time.Minute}
+	var err error
+	if cfg.Cleanup.Interval != "" {
+		if interval, err = time.ParseDuration(cfg.Cleanup.Interval); err != nil {
+			return 0, 0, policy, fmt.Errorf("invalid cleanup interval: %v", err)
+		}
+	}
+	var pendingTTL time.Duration
+	ttl := cfg.Cleanup.PendingTTL
+	if ttl == "" {
+		ttl = cfg.Cleanup.PendingSLA
+	}
+	if ttl != "" {
+		if pendingTTL, err = time.ParseDuration(ttl); err != nil {
+			return 0, 0, policy, fmt.Errorf("invalid pending request TTL: %v", err)
+		}
+	}
+	if cfg.Cleanup.OperatorTimeout != "" {
+		if policy.OperatorTimeout, err = time.ParseDuration(cfg.Cleanup.OperatorTimeout); err != nil {
+			return 0, 0, policy, fmt.Errorf("invalid operator timeout: %v", err)
+		}
+	}
+	if cfg.Cleanup.ExpiryWarning != "" {
+		if policy.ExpiryWarning, err = time.ParseDuration(cfg.Cleanup.ExpiryWarning); err != nil {
+			return 0, 0, policy, fmt.Errorf("invalid expiry warning: %v", err)
+		}
+	}
+	return interval, pendingTTL, policy, nil
+}
+
+// jobArchival returns how often finished jobs are archived and how long after they finished
+func jobArchival(cfg *config.Config) (time.Duration, time.Duration, error) {
+	interval, after := time.Minute, time.Hour
+	var err error
+	if cfg.Jobs.ArchiveInterval != "" {
+		if interval, err = time.ParseDuration(cfg.Jobs.ArchiveInterval); err != nil {
+			return 0, 0, fmt.Errorf("invalid job archive interval: %v", err)
+		}
+	}
+	if cfg.Jobs.ArchiveAfter != "" {
+		if after, err = time.ParseDuration(cfg.Jobs.ArchiveAfter); err != nil {
+			return 0, 0, fmt.Errorf("invalid job archive age: %v", err)
+		}
+	}
+	return interval, after, nil
+}
+
+// newEventDispatcher creates the outbox dispatcher delivering events to the
+// configured publishers and its schedule
+func newEventDispatcher(cfg *config.Config, st store.Store, eventBus bus.Bus, ruleEngine rules.RuleEngine, schedule *oncall.Cache, actions *mattermost.Actions, flags *features.Set) (*outbox.Dispatcher, time.Duration, error) {
+	interval := 5 * time.Second
+	if cfg.Events.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Events.Interval); err != nil {
+			return nil, 0, fmt.Errorf("invalid events interval: %v", err)
+		}
+	}
+
+	var publishers []outbox.Publisher
+	if cfg.Events.Bus {
+		if eventBus == nil {
+			return nil, 0, fmt.Errorf("publishing events to the bus requires a bus")
+		}
+		publisher, err := outbox.NewBusPublisher(eventBus, outbox.Format{Encoding: cfg.Events.Encoding, Source: cfg.Events.Source})
+		if err != nil {
+			return nil, 0, err
+		}
+		publishers = append(publishers, publisher)
+	}
+	for _, webhook := range cfg.Events.Webhooks {
+		format := outbox.Format{Encoding: cfg.Events.Encoding, Mode: webhook.Mode, Source: cfg.Events.Source}
+		if webhook.Encoding != "" {
+			format.Encoding = webhook.Encoding
+		}
+		publisher, err := outbox.NewWebhookPublisher(webhook.URL, webhook.Secret, format)
+		if err != nil {
+			return nil, 0, fmt.Errorf("webhook %s: %v", webhook.URL, err)
+		}
+		publishers = append(publishers, outbox.GatedPublisher{
+			Publisher: publisher,
+			Open:      func() bool { return flags.Enabled(features.Webhooks) },
+		})
+	}
+	if sns := cfg.Events.SNS; sns.TopicARN != "" {
+		publisher, err := outbox.NewSNSPublisher(sns.TopicARN, outbox.AWSConfig{
+			Region:   sns.Region,
+			Endpoint: sns.Endpoint,
+			Credentials: sigv4.Credentials{
+				AccessKeyID:     sns.AccessKeyID,
+				SecretAccessKey: sns.SecretAccessKey,
+				SessionToken:    sns.SessionToken,
+			},
+		}, outbox.Format{Encoding: cfg.Events.Encoding, Source: cfg.Events.Source})
+		if err != nil {
+			return nil, 0, fmt.Errorf("SNS: %v", err)
+		}
+		publishers = append(publishers, publisher)
+	}
+	if eventBridge := cfg.Events.EventBridge; eventBridge.EventBus != "" {
+		publisher, err := outbox.NewEventBridgePublisher(eventBridge.EventBus, eventBridge.Source, outbox.AWSConfig{
+			Region:   eventBridge.Region,
+			Endpoint: eventBridge.Endpoint,
+			Credentials: sigv4.Credentials{
+				AccessKeyID:     eventBridge.AccessKeyID,
+				SecretAccessKey: eventBridge.SecretAccessKey,
+				SessionToken:    eventBridge.SessionToken,
+			},
+		}, outbox.Format{Encoding: cfg.Events.Encoding, Source: cfg.Events.Source})
+		if err != nil {
+			return nil, 0, fmt.Errorf("EventBridge: %v", err)
+		}
+		publishers = append(publishers, publisher)
+	}
+	if cfg.Slack.Token != "" {
+		notifier, err := newSlackNotifier(cfg, st, ruleEngine, schedule)
+		if err != nil {
+			return nil, 0, err
+		}
+		publishers = append(publishers, notifier)
+	}
+	if cfg.Slack.Token != "" && cfg.Slack.DirectMessages.Enabled {
+		client, err := slack.NewClient(cfg.Slack.Token, "")
+		if err != nil {
+			return nil, 0, err
+		}
+		dms := cfg.Slack.DirectMessages
+		notifier, err := slack.NewDirectNotifier(client, st, dms.EmailDomain, dms.Templates)
+		if err != nil {
+			return nil, 0, err
+		}
+		publishers = append(publishers, notifier)
+	}
+	if cfg.Mattermost.WebhookURL != "" {
+		notifier, err := newMattermostNotifier(cfg, st, ruleEngine, actions)
+		if err != nil {
+			return nil, 0, err
+		}
+		publishers = append(publishers, notifier)
+	}
+	if cfg.Discord.Channel != "" || len(cfg.Discord.Routes) > 0 {
+		notifier, err := newDiscordNotifier(cfg, st, ruleEngine)
+		if err != nil {
+			return nil, 0, err
+		}
+		publishers = append(publishers, notifier)
+	}
+	siemPublishers, err := newSIEMPublishers(cfg, st)
+	if err != nil {
+		return nil, 0, err
+	}
+	publishers = append(publishers, siemPublishers...)
+	for _, publisher := range publishers {
+		log.Printf("Delivering events to %s", publisher.Name())
+	}
+	dispatcher := outbox.NewDispatcher(st, publishers...)
+	if cfg.Events.MaxAttempts < 0 {
+		return nil, 0, fmt.Errorf("events max attempts must not be negative")
+	}
+	if cfg.Events.MaxAttempts > 0 {
+		dispatcher.SetMaxAttempts(cfg.Events.MaxAttempts)
+	}
+	if cfg.Events.DeliveryHistory != "" {
+		history, err := time.ParseDuration(cfg.Events.DeliveryHistory)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid events delivery history: %v", err)
+		}
+		dispatcher.SetDeliveryHistory(history)
+	}
+	return dispatcher, interval, nil
+}
+
+// newSIEMPublishers creates the publishers shipping events to the configured SIEMs
+func newSIEMPublishers(cfg *config.Config, st store.Store) ([]outbox.Publisher, error) {
+	var publishers []outbox.Publisher
+	if splunk := cfg.SIEM.Splunk; splunk.URL != "" {
+		publisher, err := siem.NewSplunk(siem.SplunkConfig{
+			URL:    splunk.URL,
+			Token:  splunk.Token,
+			Index:  splunk.Index,
+			Source: splunk.Source,
+		}, st)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, publisher)
+	}
+	if syslog := cfg.SIEM.Syslog; syslog.Address != "" {
+		var tlsConfig *tls.Config
+		if syslog.CAFile != "" {
+			pem, err := os.ReadFile(syslog.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read syslog CA file: %v", err)
+			}
+			roots := x509.NewCertPool()
+			if !roots.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in syslog CA file %s", syslog.CAFile)
+			}
+			tlsConfig = &tls.Config{RootCAs: roots}
+		}
+		publisher, err := siem.NewSyslog(siem.SyslogConfig{
+			Address: syslog.Address,
+			Network: syslog.Network,
+			Format:  syslog.Format,
+			TLS:     tlsConfig,
+		}, st)
+		if err != nil {
+			return nil, err
+		}
+		publishers = append(publishers, publisher)
+	}
+	return publishers, nil
+}
+
+// newOnCallSchedule creates the on-call schedule of the configured provider
+func newOnCallSchedule(cfg *config.Config) (*oncall.Cache, error) {
+	var ttl time.Duration
+	if cfg.OnCall.CacheTTL != "" {
+		var err error
+		if ttl, err = time.ParseDuration(cfg.OnCall.CacheTTL); err != nil {
+			return nil, fmt.Errorf("invalid on-call cache TTL: %v", err)
+		}
+	}
+	return oncall.New(oncall.Config{
+		Provider:    cfg.OnCall.Provider,
+		Token:       cfg.OnCall.Token,
+		BaseURL:     cfg.OnCall.BaseURL,
+		Schedules:   cfg.OnCall.Schedules,
+		EmailDomain: cfg.OnCall.EmailDomain,
+		CacheTTL:    ttl,
+	})
+}
+
+// newGroupSource creates the source groups are synced from and returns how
+// often to sync them
+func newGroupSource(cfg *config.Config) (directory.Source, time.Duration, error) {
+	interval := 15 * time.Minute
+	if cfg.Directory.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Directory.Interval); err != nil {
+			return nil, 0, fmt.Errorf("invalid group sync interval: %v", err)
+		}
+	}
+	source, err := directory.New(directory.Config{
+		Provider:        cfg.Directory.Provider,
+		BaseURL:         cfg.Directory.BaseURL,
+		Token:           cfg.Directory.Token,
+		CredentialsFile: cfg.Directory.CredentialsFile,
+		Subject:         cfg.Directory.Subject,
+		Customer:        cfg.Directory.Customer,
+		TenantID:        cfg.Directory.TenantID,
+		ClientID:        cfg.Directory.ClientID,
+		ClientSecret:    cfg.Directory.ClientSecret,
+		Groups:          cfg.Directory.Groups,
+		EmailDomain:     cfg.Directory.EmailDomain,
+	})
+	return source, interval, err
+}
+
+// newSlackNotifier creates the publisher posting events to Slack, mentioning
+// the on-call approvers of new requests when a schedule is configured
+func newSlackNotifier(cfg *config.Config, st store.Store, ruleEngine rules.RuleEngine, schedule *oncall.Cache) (*slack.Notifier, error) {
+	client, err := slack.NewClient(cfg.Slack.Token, "")
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]slack.Route, len(cfg.Slack.Routes))
+	for i, route := range cfg.Slack.Routes {
+		routes[i] = slack.Route(route)
+	}
+	notifierConfig := slack.Config{
+		Channel:   cfg.Slack.Channel,
+		Routes:    routes,
+		Templates: cfg.Slack.Templates,
+	}
+	// Environments and services are declared with the resources in the rules
+	engine, isDefault := ruleEngine.(*rules.DefaultRuleEngine)
+	if isDefault {
+		notifierConfig.Environment = func(resourceID string) string {
+			return engine.Rules().Resources[resourceID].Environment
+		}
+	}
+	if schedule != nil {
+		notifierConfig.EmailDomain = cfg.OnCall.EmailDomain
+		notifierConfig.OnCall = func(ctx context.Context, resourceID string) ([]string, error) {
+			service := resourceID
+			if isDefault {
+				service = engine.Rules().Service(resourceID)
+			}
+			return schedule.OnCall(ctx, service)
+		}
+	}
+	return slack.NewNotifier(client, st, notifierConfig)
+}
+
+// newMattermostActions creates the approve and deny buttons posted to Mattermost
+func newMattermostActions(cfg *config.Config) (*mattermost.Actions, error) {
+	key := make([]byte, 32)
+	if cfg.Mattermost.Actions.Key != "" {
+		var err error
+		if key, err = base64.StdEncoding.DecodeString(cfg.Mattermost.Actions.Key); err != nil {
+			return nil, fmt.Errorf("invalid action key: %v", err)
+		}
+	} else if _, err := rand.Read(key); err != nil {
+		return nil, fmt.Errorf("failed to generate action key: %v", err)
+	}
+	return mattermost.NewActions(key, cfg.Mattermost.Actions.BaseURL)
+}
+
+// newMattermostNotifier creates the publisher posting events to Mattermost
+func newMattermostNotifier(cfg *config.Config, st store.Store, ruleEngine rules.RuleEngine, actions *mattermost.Actions) (*mattermost.Notifier, error) {
+	client, err := mattermost.NewClient(cfg.Mattermost.WebhookURL)
+	if err != nil {
+		return nil, err
+	}
+	routes := make([]notify.Route, len(cfg.Mattermost.Routes))
+	for i, route := range cfg.Mattermost.Routes {
+		routes[i] = notify.Route(route)
+	}
+	notifierConfig := mattermost.Config{
+		Channel:   cfg.Mattermost.Channel,
+		Routes:    routes,
+		Templates: cfg.Mattermost.Templates,
+		Actions:   actions,
+	}
+	if engine, ok := ruleEngine.(*rules.DefaultRuleEngine); ok {
+		notifierConfig.Environment = func(resourceID string) string {
+			return engine.Rules().Resources[resourceID].Environment
+		}
+	}
+	return mattermost.NewNotifier(client, st, notifierConfig)
+}
+
+// newDiscordNotifier creates the publisher posting events to Discord
+func newDiscordNotifier(cfg *config.Config, st store.Store, ruleEngine rules.RuleEngine) (*discord.Notifier, error) {
+	routes := make([]notify.Route, len(cfg.Discord.Routes))
+	for i, route := range cfg.Discord.Routes {
+		routes[i] = notify.Route(route)
+	}
+	notifierConfig := discord.Config{
+		Channel:   cfg.Discord.Channel,
+		Routes:    routes,
+		Templates: cfg.Discord.Templates,
+	}
+	if engine, ok := ruleEngine.(*rules.DefaultRuleEngine); ok {
+		notifierConfig.Environment = func(resourceID string) string {
+			return engine.Rules().Resources[resourceID].Environment
+		}
+	}
+	return discord.NewNotifier(discord.NewClient(cfg.Discord.BotToken, ""), st, notifierConfig)
+}
+
+// newRetentionWorker creates the retention worker and its schedule from the configuration
+func newRetentionWorker(cfg *config.Config, st store.Store) (*retention.Worker, time.Duration, error) {
+	interval := 24 * time.Hour
+	if cfg.Retention.Interval != "" {
+		var err error
+		if interval, err = time.ParseDuration(cfg.Retention.Interval); err != nil {
+			return nil, 0, fmt.Errorf("invalid interval: %v", err)
+		}
+	}
+
+	var policy retention.Policy
+	for _, r := range []struct {
+		name   string
+		config config.RetentionRule
+		rule   *retention.Rule
+	}{
+		{retention.KindJobs, cfg.Retention.Jobs, &policy.Jobs},
+		{retention.KindGrants, cfg.Retention.Grants, &policy.Grants},
+		{retention.KindAudit, cfg.Retention.Audit, &policy.Audit},
+	} {
+		r.rule.Action = r.config.Action
+		if r.config.MaxAge != "" {
+			maxAge, err := time.ParseDuration(r.config.MaxAge)
+			if err != nil {
+				return nil, 0, fmt.Errorf("invalid max age for %s: %v", r.name, err)
+			}
+			r.rule.MaxAge = maxAge
+		}
+	}
+
+	var archiver retention.Archiver
+	archive := cfg.Retention.Archive
+	switch archive.Type {
+	case "":
+	case "file":
+		if archive.Dir == "" {
+			return nil, 0, fmt.Errorf("archive dir is required")
+		}
+		archiver = &retention.FileArchiver{Dir: archive.Dir}
+	case "s3":
+		s3, err := retention.NewS3Archiver(archive.Endpoint, archive.Region, archive.Bucket, archive.Prefix, archive.AccessKeyID, archive.SecretAccessKey)
+		if err != nil {
+			return nil, 0, fmt.Errorf("invalid archive: %v", err)
+		}
+		archiver = s3
+	default:
+		return nil, 0, fmt.Errorf("unknown archive type %q", archive.Type)
+	}
+
+	worker, err := retention.NewWorker(st, policy, archiver)
+	if err != nil {
+		return nil, 0, err
+	}
+	return worker, interval, nil
+}
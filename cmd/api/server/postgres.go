@@ -0,0 +1,7 @@
+//go:build postgres
+
+package main
+
+// The postgres build tag links in the pgx PostgreSQL driver, registered as
+// "pgx", for the postgres storage backend
+import _ "github.com/jackc/pgx/v5/stdlib"
@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// The sqlite build tag links in a pure Go SQLite driver, registered as
+// "sqlite", for the single-node storage backend
+import _ "modernc.org/sqlite"
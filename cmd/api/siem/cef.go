@@ -0,0 +1,56 @@
+package siem
+
+import (
+	"strconv"
+	"strings"
+)
+
+// cefHeaderEscaper escapes the pipe separated CEF header fields
+var cefHeaderEscaper = strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+
+// cefValueEscaper escapes CEF extension values
+var cefValueEscaper = strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\r", `\r`, "\n", `\n`)
+
+// cef formats a record as a Common Event Format line. The device version is
+// the schema version of the event's payload; the event type is the signature
+// ID.
+//
+//	CEF:0|Apollo|Apollo|1|approved|A request was approved and its grant created|5|rt=... suser=... duser=...
+func cef(record *Record) string {
+	header := []string{
+		"CEF:0", "Apollo", "Apollo",
+		strconv.Itoa(record.SchemaVersion), record.Type, record.name(), strconv.Itoa(record.Severity),
+	}
+	for i := 1; i < len(header); i++ {
+		header[i] = cefHeaderEscaper.Replace(header[i])
+	}
+
+	var extension []string
+	add := func(key, value string) {
+		if value != "" {
+			extension = append(extension, key+"="+cefValueEscaper.Replace(value))
+		}
+	}
+	add("rt", strconv.FormatInt(record.Time.UnixMilli(), 10))
+	add("externalId", record.ID)
+	add("act", record.Type)
+	add("suser", record.Actor)
+	add("cs1Label", "requestId")
+	add("cs1", record.RequestID)
+	if record.GrantID != "" {
+		add("cs2Label", "grantId")
+		add("cs2", record.GrantID)
+	}
+	if request := record.Request; request != nil {
+		add("duser", request.UserID)
+		add("dhost", request.ResourceID)
+		add("src", request.SourceIP)
+		add("cs3Label", "module")
+		add("cs3", request.Module)
+		add("cs4Label", "level")
+		add("cs4", request.Level)
+		add("cn1Label", "riskScore")
+		add("cn1", strconv.Itoa(request.RiskScore))
+	}
+	return strings.Join(header, "|") + "|" + strings.Join(extension, " ")
+}
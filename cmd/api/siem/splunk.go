@@ -0,0 +1,116 @@
+package siem
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// splunkEventPath is the HTTP Event Collector endpoint for JSON events
+const splunkEventPath = "/services/collector/event"
+
+// SplunkConfig configures the HTTP Event Collector publisher
+type SplunkConfig struct {
+	// URL is the collector, e.g. https://splunk.example.com:8088; the event
+	// endpoint is used when it has no path
+	URL   string
+	Token string
+	// Index overrides the token's default index
+	Index string
+	// Source defaults to apollo
+	Source string
+}
+
+// Splunk publishes events to a Splunk HTTP Event Collector
+type Splunk struct {
+	url    string
+	token  string
+	index  string
+	source string
+	host   string
+	store  store.Store
+	client *http.Client
+}
+
+// NewSplunk creates a publisher for the collector, reading the requests
+// events refer to from the store
+func NewSplunk(config SplunkConfig, s store.Store) (*Splunk, error) {
+	u, err := url.Parse(config.URL)
+	if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+		return nil, fmt.Errorf("splunk URL must be an http or https URL: %q", config.URL)
+	}
+	if config.Token == "" {
+		return nil, fmt.Errorf("splunk token is required")
+	}
+	if u.Path == "" || u.Path == "/" {
+		u.Path = splunkEventPath
+	}
+	source := config.Source
+	if source == "" {
+		source = "apollo"
+	}
+	host, _ := os.Hostname()
+	return &Splunk{
+		url:    u.String(),
+		token:  config.Token,
+		index:  config.Index,
+		source: source,
+		host:   host,
+		store:  s,
+		client: &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (p *Splunk) Name() string {
+	return "splunk:" + p.url
+}
+
+// Publish sends an event to the collector; any status other than 2xx fails
+func (p *Splunk) Publish(ctx context.Context, event store.Event) error {
+	record, err := newRecord(ctx, p.store, event)
+	if err != nil {
+		return err
+	}
+	payload := map[string]interface{}{
+		"time":       float64(event.Time.UnixMicro()) / 1e6,
+		"host":       p.host,
+		"source":     p.source,
+		"sourcetype": "apollo:event",
+		"event":      record,
+	}
+	if p.index != "" {
+		payload["index"] = p.index
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to marshal event: %v", err)
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.url, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Splunk "+p.token)
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send event to splunk: %v", err)
+	}
+	defer resp.Body.Close()
+	reply, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("splunk returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(reply)))
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+// Package slack posts notifications about privilege requests to Slack
+package slack
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultBaseURL is the Slack Web API
+const DefaultBaseURL = "https://slack.com/api/"
+
+// Client calls the Slack Web API with a bot token
+type Client struct {
+	token   string
+	baseURL string
+	client  *http.Client
+}
+
+// NewClient creates a client for the Web API at baseURL, DefaultBaseURL when empty
+func NewClient(token, baseURL string) (*Client, error) {
+	if token == "" {
+		return nil, fmt.Errorf("slack token is required")
+	}
+	if baseURL == "" {
+		baseURL = DefaultBaseURL
+	}
+	return &Client{
+		token:   token,
+		baseURL: strings.TrimSuffix(baseURL, "/") + "/",
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// PostMessage posts a message formatted with Slack's mrkdwn to a channel
+func (c *Client) PostMessage(ctx context.Context, channel, text string) error {
+	return c.call(ctx, "chat.postMessage", map[string]interface{}{
+		"channel": channel,
+		"text":    text,
+	}, nil)
+}
+
+// LookupUserByEmail returns the ID of the Slack user with the email address;
+// an *Error with code users_not_found is returned when there is none
+func (c *Client) LookupUserByEmail(ctx context.Context, email string) (string, error) {
+	var resp struct {
+		User struct {
+			ID string `json:"id"`
+		} `json:"user"`
+	}
+	if err := c.call(ctx, "users.lookupByEmail", url.Values{"email": {email}}, &resp); err != nil {
+		return "", err
+	}
+	return resp.User.ID, nil
+}
+
+// call invokes a Web API method. Arguments are sent as JSON, or form encoded
+// when given as url.Values, which methods that only read require. Slack answers
+// errors with status 200 and ok set to false, so the body decides whether the
+// call succeeded.
+func (c *Client) call(ctx context.Context, method string, input, output interface{}) error {
+	contentType := "application/json; charset=utf-8"
+	var body []byte
+	if form, ok := input.(url.Values); ok {
+		contentType, body = "application/x-www-form-urlencoded", []byte(form.Encode())
+	} else {
+		var err error
+		if body, err = json.Marshal(input); err != nil {
+			return fmt.Errorf("failed to marshal %s request: %v", method, err)
+		}
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+method, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+c.token)
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call slack %s: %v", method, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("slack %s returned status %d", method, resp.StatusCode)
+	}
+	var raw json.RawMessage
+	if err := json.NewDecoder(resp.Body).Decode(&raw); err != nil {
+		return fmt.Errorf("failed to decode slack %s response: %v", method, err)
+	}
+	var result struct {
+		OK    bool   `json:"ok"`
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(raw, &result); err != nil {
+		return fmt.Errorf("failed to decode slack %s response: %v", method, err)
+	}
+	if !result.OK {
+		return &Error{Method: method, Code: result.Error}
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.Unmarshal(raw, output); err != nil {
+		return fmt.Errorf("failed to decode slack %s response: %v", method, err)
+	}
+	return nil
+}
+
+// Error is an error returned by the Slack Web API, e.g. channel_not_found
+type Error struct {
+	Method string
+	Code   string
+}
+
+// Error describes the failed call
+func (e *Error) Error() string {
+	return "slack " + e.Method + " failed: " + e.Code
+}
@@ -0,0 +1,82 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+
+	"github.com/petermein/apollo/cmd/api/notify"
+	"github.com/petermein/apollo/cmd/api/store"
+)
+
+// defaultDirectTemplates format the DMs sent to requesters about their own requests
+var defaultDirectTemplates = map[string]string{
+	store.EventApproved:    `:white_check_mark: Your {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} was approved{{if .Event.Actor}} by {{.Event.Actor}}{{end}}{{if .Grant}} and lasts until {{.Grant.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{end}}.`,
+	store.EventDenied:      `:no_entry: Your request for {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} was denied by {{.Event.Actor}}.`,
+	store.EventProvisioned: `:unlock: Your {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} is ready. Fetch your credentials with the apollo CLI.`,
+	store.EventExpiring:    `:hourglass: Your access to {{.Request.Module}} {{.Request.ResourceID}} expires at {{if .Grant}}{{.Grant.ExpiresAt.Format "15:04 MST"}}{{else}}soon{{end}}. Request an extension if you still need it.`,
+	store.EventLapsed:      `:hourglass: Your request for {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} expired without a decision. Request it again if you still need it.`,
+	store.EventHandedOver:  `:arrows_counterclockwise: Your access to {{.Request.Module}} {{.Request.ResourceID}} was handed over to {{if .Grant}}{{.Grant.ToUserID}}{{else}}another user{{end}}{{if .Event.Actor}} by {{.Event.Actor}}{{end}}. Your credentials no longer work.`,
+	store.EventRevoked:     `:lock: Your access to {{.Request.Module}} {{.Request.ResourceID}} was revoked{{if .Event.Actor}} by {{.Event.Actor}}{{end}}.`,
+}
+
+// DirectNotifier tells requesters about their own requests in a Slack DM.
+// Requesters are looked up by email; those without a Slack account are
+// skipped. It is a separate outbox publisher from the channel notifier, so a
+// failed DM is retried without posting to the channels again.
+type DirectNotifier struct {
+	client    *Client
+	users     *users
+	templates map[string]*template.Template
+	store     store.Store
+}
+
+// NewDirectNotifier creates a notifier sending DMs with the client. User IDs
+// that aren't email addresses get emailDomain appended to look them up.
+func NewDirectNotifier(client *Client, s store.Store, emailDomain string, templates map[string]string) (*DirectNotifier, error) {
+	parsed, err := notify.ParseTemplates(defaultDirectTemplates, templates)
+	if err != nil {
+		return nil, fmt.Errorf("slack %v", err)
+	}
+	return &DirectNotifier{
+		client:    client,
+		users:     newUsers(client, emailDomain),
+		templates: parsed,
+		store:     s,
+	}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (n *DirectNotifier) Name() string {
+	return "slack-dm"
+}
+
+// Publish sends an event to the requester it concerns
+func (n *DirectNotifier) Publish(ctx context.Context, event store.Event) error {
+	tmpl, ok := n.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	data, err := notify.NewMessageData(ctx, n.store, event)
+	if err != nil {
+		return err
+	}
+	userID, err := n.users.lookup(ctx, data.Request.UserID)
+	if err != nil {
+		return err
+	}
+	if userID == "" {
+		return nil
+	}
+
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		return fmt.Errorf("failed to format %s event: %v", event.Type, err)
+	}
+	// Posting to a user ID delivers the message in the app's DM with them
+	if err := n.client.PostMessage(ctx, userID, text.String()); err != nil {
+		return fmt.Errorf("failed to send %s event to %s: %v", event.Type, data.Request.UserID, err)
+	}
+	return nil
+}
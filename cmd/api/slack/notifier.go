@@ -0,0 +1,186 @@
+package slack
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/petermein/apollo/cmd/api/notify"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// defaultTemplates format the events that have no template configured
+var defaultTemplates = map[string]string{
+	store.EventRequested:   `:key: {{if .Request.HandedOverBy}}*{{.Request.HandedOverBy}}* asks to hand {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} over to *{{.Request.UserID}}*{{else}}*{{.Request.UserID}}* requested {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}{{end}}{{if .Environment}} ({{.Environment}}){{end}} - risk {{.Request.RiskScore}}: {{.Request.Reason}}{{if .OnCall}} - on call:{{range $i, $user := .OnCall}}{{if $i}},{{end}} {{$user}}{{end}}{{end}}`,
+	store.EventApproved:    `:white_check_mark: {{if .Event.Actor}}{{.Event.Actor}} approved{{else}}Auto-approved{{end}} {{.Request.UserID}}'s {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}{{if .Grant}} until {{.Grant.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{end}}`,
+	store.EventDenied:      `:no_entry: {{.Event.Actor}} denied {{.Request.UserID}}'s {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}}`,
+	store.EventProvisioned: `:unlock: {{.Request.UserID}}'s {{.Request.Level}} access to {{.Request.Module}} {{.Request.ResourceID}} is ready`,
+	store.EventExtended:    `:hourglass_flowing_sand: {{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}} was extended{{if .Grant}} until {{.Grant.ExpiresAt.Format "2006-01-02 15:04 MST"}}{{end}}`,
+	store.EventRevoked:     `:lock: {{if .Event.Actor}}{{.Event.Actor}} revoked {{end}}{{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}}{{if not .Event.Actor}} was revoked{{end}}`,
+	store.EventExpired:     `:lock: {{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}} expired`,
+	store.EventClosed:      `:wastebasket: {{.Request.UserID}}'s request for {{.Request.Module}} {{.Request.ResourceID}} was closed without a decision`,
+	store.EventLapsed:      `:hourglass: {{.Request.UserID}}'s request for {{.Request.Module}} {{.Request.ResourceID}} expired without a decision`,
+	store.EventHandedOver:  `:arrows_counterclockwise: {{.Event.Actor}} handed {{.Request.UserID}}'s access to {{.Request.Module}} {{.Request.ResourceID}} over to {{if .Grant}}{{.Grant.ToUserID}}{{else}}another user{{end}}`,
+}
+
+// Route sends the events of matching requests to a channel
+type Route = notify.Route
+
+// MessageData is what message templates are executed against
+type MessageData = notify.MessageData
+
+// Config configures the notifier
+type Config struct {
+	// Channel receives the events no route matches; they are dropped when empty
+	Channel string
+	Routes  []Route
+	// Templates override the default message per event type with a Go
+	// template executed against MessageData
+	Templates map[string]string
+	// Environment returns the environment of a resource, e.g. production;
+	// routes by environment never match without it
+	Environment func(resourceID string) string
+	// OnCall returns the users on call for a resource; messages about new
+	// requests mention those who can approve them
+	OnCall func(ctx context.Context, resourceID string) ([]string, error)
+	// EmailDomain is appended to the IDs of on-call users that aren't email
+	// addresses to look up their Slack accounts
+	EmailDomain string
+}
+
+// Notifier posts request lifecycle events to Slack channels. It is an outbox
+// publisher, so a message that fails to post is retried.
+type Notifier struct {
+	client      *Client
+	channel     string
+	routes      []Route
+	templates   map[string]*template.Template
+	environment func(resourceID string) string
+	onCall      func(ctx context.Context, resourceID string) ([]string, error)
+	users       *users
+	store       store.Store
+}
+
+// NewNotifier creates a notifier posting with the client and reading the
+// requests events refer to from the store
+func NewNotifier(client *Client, s store.Store, config Config) (*Notifier, error) {
+	if err := notify.ValidateRoutes(config.Routes); err != nil {
+		return nil, fmt.Errorf("slack %v", err)
+	}
+	templates, err := notify.ParseTemplates(defaultTemplates, config.Templates)
+	if err != nil {
+		return nil, fmt.Errorf("slack %v", err)
+	}
+
+	environment := config.Environment
+	if environment == nil {
+		environment = func(string) string { return "" }
+	}
+	return &Notifier{
+		client:      client,
+		channel:     config.Channel,
+		routes:      config.Routes,
+		templates:   templates,
+		environment: environment,
+		onCall:      config.OnCall,
+		users:       newUsers(client, config.EmailDomain),
+		store:       s,
+	}, nil
+}
+
+// Name identifies the publisher in the outbox
+func (n *Notifier) Name() string {
+	return "slack"
+}
+
+// Publish posts an event to the channels it is routed to
+func (n *Notifier) Publish(ctx context.Context, event store.Event) error {
+	tmpl, ok := n.templates[event.Type]
+	if !ok {
+		return nil
+	}
+	data, err := notify.NewMessageData(ctx, n.store, event)
+	if err != nil {
+		return err
+	}
+	data.Environment = n.environment(data.Request.ResourceID)
+	channels := n.channels(data)
+	if len(channels) == 0 {
+		return nil
+	}
+	if event.Type == store.EventRequested {
+		data.OnCall = n.mentions(ctx, data.Request)
+	}
+
+	var text bytes.Buffer
+	if err := tmpl.Execute(&text, data); err != nil {
+		return fmt.Errorf("failed to format %s event: %v", event.Type, err)
+	}
+	for _, channel := range channels {
+		if err := n.client.PostMessage(ctx, channel, text.String()); err != nil {
+			return fmt.Errorf("failed to post %s event to %s: %v", event.Type, channel, err)
+		}
+	}
+	return nil
+}
+
+// mentions returns the Slack mentions of the on-call users who can approve a
+// pending request. Users without a Slack account are named instead; a schedule
+// that can't be read only costs the mention.
+func (n *Notifier) mentions(ctx context.Context, request *models.PrivilegeRequest) []string {
+	if n.onCall == nil || request.Status != store.RequestStatusPending || request.RequiredApprovals == 0 {
+		return nil
+	}
+	onCall, err := n.onCall(ctx, request.ResourceID)
+	if err != nil {
+		log.Printf("Failed to look up who is on call for %s: %v", request.ResourceID, err)
+		return nil
+	}
+
+	var mentions []string
+	for _, user := range onCall {
+		if user == request.UserID || (len(request.Approvers) > 0 && !slices.Contains(request.Approvers, user)) {
+			continue
+		}
+		id, err := n.users.lookup(ctx, user)
+		if err != nil {
+			log.Printf("Failed to mention %s: %v", user, err)
+		}
+		if id == "" {
+			mentions = append(mentions, user)
+			continue
+		}
+		mentions = append(mentions, "<@"+id+">")
+	}
+	return mentions
+}
+
+// channels returns the channels an event goes to: those of the first matching
+// route, or the default channel, and the Slack channels the rules notify of
+// the request
+func (n *Notifier) channels(data *MessageData) []string {
+	var channels []string
+	add := func(channel string) {
+		for _, c := range channels {
+			if c == channel {
+				return
+			}
+		}
+		channels = append(channels, channel)
+	}
+
+	if channel := notify.Channel(n.routes, n.channel, data); channel != "" {
+		add(channel)
+	}
+	for _, target := range data.Request.Notify {
+		if strings.HasPrefix(target, "#") {
+			add(target)
+		}
+	}
+	return channels
+}
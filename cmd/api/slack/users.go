@@ -0,0 +1,64 @@
+package slack
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+)
+
+// users looks up the Slack accounts of Apollo users by email, caching them
+type users struct {
+	client      *Client
+	emailDomain string
+
+	// ids caches the Slack IDs by email; an empty ID means the user has no
+	// Slack account
+	mu  sync.Mutex
+	ids map[string]string
+}
+
+// newUsers creates a lookup appending emailDomain to user IDs that aren't
+// email addresses
+func newUsers(client *Client, emailDomain string) *users {
+	return &users{
+		client:      client,
+		emailDomain: strings.TrimPrefix(emailDomain, "@"),
+		ids:         make(map[string]string),
+	}
+}
+
+// lookup returns the Slack ID of a user, or an empty ID when they have no
+// Slack account
+func (u *users) lookup(ctx context.Context, userID string) (string, error) {
+	email := userID
+	if !strings.Contains(email, "@") {
+		if u.emailDomain == "" {
+			return "", nil
+		}
+		email += "@" + u.emailDomain
+	}
+
+	u.mu.Lock()
+	id, ok := u.ids[email]
+	u.mu.Unlock()
+	if ok {
+		return id, nil
+	}
+
+	id, err := u.client.LookupUserByEmail(ctx, email)
+	var apiErr *Error
+	if errors.As(err, &apiErr) && apiErr.Code == "users_not_found" {
+		log.Printf("No Slack account for %s", email)
+		id, err = "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to look up Slack user %s: %v", email, err)
+	}
+	u.mu.Lock()
+	u.ids[email] = id
+	u.mu.Unlock()
+	return id, nil
+}
@@ -0,0 +1,75 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrExists is returned when creating a record whose name is already taken
+var ErrExists = errors.New("already exists")
+
+// Scope allows a service account one action, on one module or on all of them
+// when Module is empty
+type Scope struct {
+	Action string `json:"action"`
+	Module string `json:"module,omitempty"`
+}
+
+// ServiceAccount is a client such as a CI pipeline that calls the API with
+// tokens the API issued, limited to the actions its scopes allow
+type ServiceAccount struct {
+	ID          string    `json:"id"`
+	Name        string    `json:"name"`
+	Description string    `json:"description,omitempty"`
+	Scopes      []Scope   `json:"scopes"`
+	CreatedBy   string    `json:"created_by"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+// ServiceToken is a token of a service account. Only the hash of its secret
+// is kept; the secret is shown once when the token is created.
+type ServiceToken struct {
+	ID        string     `json:"id"`
+	AccountID string     `json:"account_id"`
+	Hash      []byte     `json:"-"`
+	CreatedBy string     `json:"created_by"`
+	CreatedAt time.Time  `json:"created_at"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// LastUsedAt is updated at most once a minute
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the token can be used at the given time
+func (t *ServiceToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && (t.ExpiresAt == nil || now.Before(*t.ExpiresAt))
+}
+
+// ServiceAccountRepository persists service accounts and their tokens
+type ServiceAccountRepository interface {
+	// CreateServiceAccount stores a new service account and assigns its ID;
+	// ErrExists is returned when its name is taken
+	CreateServiceAccount(ctx context.Context, account *ServiceAccount) error
+	// GetServiceAccount returns a service account by ID
+	GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error)
+	// ListServiceAccounts returns all service accounts ordered by name
+	ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error)
+	// SetServiceAccountScopes replaces the scopes of a service account
+	SetServiceAccountScopes(ctx context.Context, id string, scopes []Scope) error
+	// DeleteServiceAccount removes a service account and its tokens
+	DeleteServiceAccount(ctx context.Context, id string) error
+
+	// CreateServiceToken stores a new token and assigns its ID; ErrNotFound is
+	// returned when its account doesn't exist
+	CreateServiceToken(ctx context.Context, token *ServiceToken) error
+	// GetServiceToken returns a token by ID
+	GetServiceToken(ctx context.Context, id string) (*ServiceToken, error)
+	// ListServiceTokens returns the tokens of a service account, oldest first
+	ListServiceTokens(ctx context.Context, accountID string) ([]ServiceToken, error)
+	// RevokeServiceToken revokes a token of a service account; ErrNotFound is
+	// returned when the account has no such active token
+	RevokeServiceToken(ctx context.Context, accountID, id string, at time.Time) error
+	// TouchServiceToken records that a token was used
+	TouchServiceToken(ctx context.Context, id string, at time.Time) error
+}
@@ -0,0 +1,201 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"log"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Circuit breaker defaults
+const (
+	// DefaultBreakerThreshold is the number of consecutive connection failures
+	// that open the circuit
+	DefaultBreakerThreshold = 5
+	// DefaultReconnectDelay is the first delay before trying to reconnect to
+	// a database whose circuit opened; it doubles up to maxReconnectDelay
+	DefaultReconnectDelay = time.Second
+	// maxReconnectDelay caps the delay between reconnection attempts
+	maxReconnectDelay = 30 * time.Second
+	// reconnectPingTimeout bounds a single reconnection attempt
+	reconnectPingTimeout = 5 * time.Second
+)
+
+// ErrUnavailable is returned without touching the database while its circuit
+// is open, i.e. after it failed repeatedly and before it answers a ping again
+var ErrUnavailable = errors.New("database unavailable")
+
+// Availability is implemented by stores that know whether their database is
+// reachable
+type Availability interface {
+	// Available reports whether the store's database is reachable
+	Available() bool
+}
+
+// Available reports whether the store's database is reachable; stores that
+// can't tell are assumed to be
+func Available(s Store) bool {
+	if a, ok := s.(Availability); ok {
+		return a.Available()
+	}
+	return true
+}
+
+// breaker is a circuit breaker around a connection pool. Consecutive
+// connection failures open it, after which calls fail fast with ErrUnavailable
+// while the pool is pinged in the background with exponential backoff; the
+// first successful ping closes it again. Errors that a reachable database
+// returns, such as constraint violations or missing rows, don't count.
+type breaker struct {
+	name      string
+	db        *sql.DB
+	threshold int
+	delay     time.Duration
+
+	mu       sync.Mutex
+	failures int
+	open     bool
+	done     chan struct{}
+}
+
+// newBreaker creates a breaker for the pool; zero values select the defaults
+func newBreaker(name string, db *sql.DB, threshold int, delay time.Duration) *breaker {
+	if threshold <= 0 {
+		threshold = DefaultBreakerThreshold
+	}
+	if delay <= 0 {
+		delay = DefaultReconnectDelay
+	}
+	return &breaker{name: name, db: db, threshold: threshold, delay: delay, done: make(chan struct{})}
+}
+
+// allow returns ErrUnavailable while the circuit is open
+func (b *breaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.open {
+		return ErrUnavailable
+	}
+	return nil
+}
+
+// available reports whether the circuit is closed
+func (b *breaker) available() bool {
+	return b.allow() == nil
+}
+
+// record counts the outcome of a call, opening the circuit once the
+// threshold of consecutive connection failures is reached
+func (b *breaker) record(err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if !isConnectionError(err) {
+		b.failures = 0
+		return
+	}
+	b.failures++
+	if b.open || b.failures < b.threshold {
+		return
+	}
+	b.open = true
+	log.Printf("Database %s unavailable after %d failed calls, failing fast until it reconnects: %v", b.name, b.failures, err)
+	go b.reconnect()
+}
+
+// reconnect pings the pool with exponential backoff until it answers, then
+// closes the circuit
+func (b *breaker) reconnect() {
+	delay := b.delay
+	for attempt := 1; ; attempt++ {
+		select {
+		case <-b.done:
+			return
+		case <-time.After(delay):
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), reconnectPingTimeout)
+		err := b.db.PingContext(ctx)
+		cancel()
+		if err == nil {
+			b.mu.Lock()
+			b.open, b.failures = false, 0
+			b.mu.Unlock()
+			log.Printf("Database %s reconnected after %d attempts", b.name, attempt)
+			return
+		}
+		log.Printf("Failed to reconnect to database %s (attempt %d): %v", b.name, attempt, err)
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
+
+// close stops reconnecting
+func (b *breaker) close() {
+	close(b.done)
+}
+
+// breakerRow records the outcome of scanning a single-row query
+type breakerRow struct {
+	row     *sql.Row
+	breaker *breaker
+}
+
+// Scan scans the row and records whether the database could be reached
+func (r *breakerRow) Scan(dest ...interface{}) error {
+	err := r.row.Scan(dest...)
+	r.breaker.record(err)
+	return err
+}
+
+// errRow is a row that fails to scan, returned while the circuit is open
+type errRow struct {
+	err error
+}
+
+// Scan returns the row's error
+func (r errRow) Scan(dest ...interface{}) error {
+	return r.err
+}
+
+// isConnectionError reports whether err means the database could not be
+// reached, as opposed to an error returned by a reachable database
+func isConnectionError(err error) bool {
+	if err == nil {
+		return false
+	}
+	var netErr net.Error
+	return errors.Is(err, driver.ErrBadConn) ||
+		errors.Is(err, mysql.ErrInvalidConn) ||
+		errors.Is(err, sql.ErrConnDone) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.As(err, &netErr)
+}
+
+// pingWithRetry pings the pool, retrying with exponential backoff for up to
+// timeout so the server survives starting before its database
+func pingWithRetry(ctx context.Context, db *sql.DB, timeout time.Duration) error {
+	deadline := time.Now().Add(timeout)
+	delay := DefaultReconnectDelay
+	for attempt := 1; ; attempt++ {
+		err := db.PingContext(ctx)
+		if err == nil || !time.Now().Add(delay).Before(deadline) {
+			return err
+		}
+		log.Printf("Failed to connect to database (attempt %d), retrying in %s: %v", attempt, delay, err)
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay):
+		}
+		if delay *= 2; delay > maxReconnectDelay {
+			delay = maxReconnectDelay
+		}
+	}
+}
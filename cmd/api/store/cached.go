@@ -0,0 +1,310 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/cache"
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// DefaultCacheTTL bounds how stale a cached read can be when a write made
+// through another API server isn't seen by this server's cache
+const DefaultCacheTTL = 30 * time.Second
+
+// staleTTL is how long the last value read is kept to serve while the
+// database is unavailable
+const staleTTL = time.Hour
+
+// Cache keys
+const (
+	cacheKeyOperators     = "operators"
+	cacheKeyServersPrefix = "servers:"
+	cacheKeyGrantsPrefix  = "grants:"
+	cacheKeyStalePrefix   = "stale:"
+)
+
+// cachedGrants is a cached active-grant lookup and the time it was made for
+type cachedGrants struct {
+	ActiveAt time.Time                `json:"active_at"`
+	Grants   []*models.PrivilegeGrant `json:"grants"`
+}
+
+// activeAt returns the cached grants that are still active at the given time
+func (c *cachedGrants) activeAt(at time.Time) []*models.PrivilegeGrant {
+	grants := make([]*models.PrivilegeGrant, 0, len(c.Grants))
+	for _, grant := range c.Grants {
+		if grant.ExpiresAt.After(at) {
+			grants = append(grants, grant)
+		}
+	}
+	return grants
+}
+
+// CachedStore serves the module servers, the operator list and active-grant
+// lookups from a cache, and drops the cached entries when writes through it
+// change them. Failing cache calls are logged and fall back to the store.
+// While the database is unavailable, these lookups are answered with the last
+// value read, even after it expired.
+type CachedStore struct {
+	Store
+	cache cache.Cache
+	ttl   time.Duration
+}
+
+// NewCachedStore wraps a store with a cache; a ttl of zero uses DefaultCacheTTL
+func NewCachedStore(s Store, c cache.Cache, ttl time.Duration) *CachedStore {
+	if ttl <= 0 {
+		ttl = DefaultCacheTTL
+	}
+	return &CachedStore{Store: s, cache: c, ttl: ttl}
+}
+
+// Close releases the cache and the store
+func (s *CachedStore) Close() error {
+	if err := s.cache.Close(); err != nil {
+		log.Printf("Failed to close cache: %v", err)
+	}
+	return s.Store.Close()
+}
+
+// Available reports whether the wrapped store's database is reachable
+func (s *CachedStore) Available() bool {
+	return Available(s.Store)
+}
+
+// load decodes the value cached under key into v and reports whether it was found
+func (s *CachedStore) load(ctx context.Context, key string, v interface{}) bool {
+	data, ok, err := s.cache.Get(ctx, key)
+	if err != nil {
+		log.Printf("Failed to read %s from cache: %v", key, err)
+		return false
+	}
+	if !ok {
+		return false
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		log.Printf("Failed to decode cached %s: %v", key, err)
+		return false
+	}
+	return true
+}
+
+// save caches v under key
+func (s *CachedStore) save(ctx context.Context, key string, v interface{}) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		log.Printf("Failed to encode %s for cache: %v", key, err)
+		return
+	}
+	if err := s.cache.Set(ctx, key, data, s.ttl); err != nil {
+		log.Printf("Failed to write %s to cache: %v", key, err)
+	}
+	if err := s.cache.Set(ctx, cacheKeyStalePrefix+key, data, staleTTL); err != nil {
+		log.Printf("Failed to write stale %s to cache: %v", key, err)
+	}
+}
+
+// loadStale decodes the last value read for key into v when the store failed
+// because its database is unavailable
+func (s *CachedStore) loadStale(ctx context.Context, key string, v interface{}) bool {
+	if Available(s.Store) || !s.load(ctx, cacheKeyStalePrefix+key, v) {
+		return false
+	}
+	log.Printf("Database unavailable, serving %s from cache", key)
+	return true
+}
+
+// invalidate drops the cached keys and the values kept for them while the
+// database is unavailable
+func (s *CachedStore) invalidate(ctx context.Context, keys ...string) {
+	all := make([]string, 0, 2*len(keys))
+	for _, key := range keys {
+		all = append(all, key, cacheKeyStalePrefix+key)
+	}
+	if err := s.cache.Delete(ctx, all...); err != nil {
+		log.Printf("Failed to invalidate %v in cache: %v", keys, err)
+	}
+}
+
+// invalidateGrants drops the cached grants of the users and the list of all grants
+func (s *CachedStore) invalidateGrants(ctx context.Context, userIDs ...string) {
+	keys := []string{cacheKeyGrantsPrefix}
+	for _, userID := range userIDs {
+		keys = append(keys, cacheKeyGrantsPrefix+userID)
+	}
+	s.invalidate(ctx, keys...)
+}
+
+// ListServers returns the servers of a module matching the filter. Only the
+// whole list of active servers, which modules look servers up in, is cached.
+func (s *CachedStore) ListServers(ctx context.Context, module string, filter ServerFilter) ([]modules.ServerInfo, error) {
+	if filter != (ServerFilter{Status: "active"}) {
+		return s.Store.ListServers(ctx, module, filter)
+	}
+	key := cacheKeyServersPrefix + module
+	var servers []modules.ServerInfo
+	if s.load(ctx, key, &servers) {
+		return servers, nil
+	}
+	servers, err := s.Store.ListServers(ctx, module, filter)
+	if err != nil {
+		if s.loadStale(ctx, key, &servers) {
+			return servers, nil
+		}
+		return nil, err
+	}
+	s.save(ctx, key, servers)
+	return servers, nil
+}
+
+// RegisterServer marks a module's server as active, creating it when it is new
+func (s *CachedStore) RegisterServer(ctx context.Context, module string, server modules.ServerInfo) error {
+	defer s.invalidate(ctx, cacheKeyServersPrefix+module)
+	return s.Store.RegisterServer(ctx, module, server)
+}
+
+// MarkServerInactive marks a module's server as inactive
+func (s *CachedStore) MarkServerInactive(ctx context.Context, module, name string) error {
+	defer s.invalidate(ctx, cacheKeyServersPrefix+module)
+	return s.Store.MarkServerInactive(ctx, module, name)
+}
+
+// ListOperators returns the operators matching the filter. Only the whole
+// list is cached.
+func (s *CachedStore) ListOperators(ctx context.Context, filter OperatorFilter) ([]modules.OperatorInfo, error) {
+	if filter != (OperatorFilter{}) {
+		return s.Store.ListOperators(ctx, filter)
+	}
+	var operators []modules.OperatorInfo
+	if s.load(ctx, cacheKeyOperators, &operators) {
+		return operators, nil
+	}
+	operators, err := s.Store.ListOperators(ctx, filter)
+	if err != nil {
+		if s.loadStale(ctx, cacheKeyOperators, &operators) {
+			return operators, nil
+		}
+		return nil, err
+	}
+	s.save(ctx, cacheKeyOperators, operators)
+	return operators, nil
+}
+
+// RegisterOperator marks an operator in the given region as active, creating it when it is new
+func (s *CachedStore) RegisterOperator(ctx context.Context, id, region string) error {
+	defer s.invalidate(ctx, cacheKeyOperators)
+	return s.Store.RegisterOperator(ctx, id, region)
+}
+
+// UpdateOperatorHealth records a health check of an operator and the health of its modules
+func (s *CachedStore) UpdateOperatorHealth(ctx context.Context, id string, at time.Time, health []modules.ModuleHealth) error {
+	defer s.invalidate(ctx, cacheKeyOperators)
+	return s.Store.UpdateOperatorHealth(ctx, id, at, health)
+}
+
+// MarkOperatorInactive marks an operator as inactive
+func (s *CachedStore) MarkOperatorInactive(ctx context.Context, id string) error {
+	defer s.invalidate(ctx, cacheKeyOperators)
+	return s.Store.MarkOperatorInactive(ctx, id)
+}
+
+// ListGrants returns the user's grants, or all grants when userID is empty.
+// Only lookups of active grants are cached; a cached lookup answers later
+// lookups by dropping the grants that have expired since.
+func (s *CachedStore) ListGrants(ctx context.Context, userID string, activeAt time.Time) ([]*models.PrivilegeGrant, error) {
+	if activeAt.IsZero() {
+		return s.Store.ListGrants(ctx, userID, activeAt)
+	}
+
+	key := cacheKeyGrantsPrefix + userID
+	var cached cachedGrants
+	if s.load(ctx, key, &cached) && !activeAt.Before(cached.ActiveAt) {
+		return cached.activeAt(activeAt), nil
+	}
+	grants, err := s.Store.ListGrants(ctx, userID, activeAt)
+	if err != nil {
+		if s.loadStale(ctx, key, &cached) && !activeAt.Before(cached.ActiveAt) {
+			return cached.activeAt(activeAt), nil
+		}
+		return nil, err
+	}
+	s.save(ctx, key, cachedGrants{ActiveAt: activeAt, Grants: grants})
+	return grants, nil
+}
+
+// CreateGrant stores a new privilege grant and assigns its ID
+func (s *CachedStore) CreateGrant(ctx context.Context, grant *models.PrivilegeGrant) error {
+	defer s.invalidateGrants(ctx, grant.UserID)
+	return s.Store.CreateGrant(ctx, grant)
+}
+
+// DeleteGrant removes a privilege grant
+func (s *CachedStore) DeleteGrant(ctx context.Context, id string) error {
+	grant, err := s.Store.GetGrant(ctx, id)
+	if err != nil {
+		return err
+	}
+	defer s.invalidateGrants(ctx, grant.UserID)
+	return s.Store.DeleteGrant(ctx, id)
+}
+
+// DeleteGrants removes the grants with the given IDs. Only expired grants are
+// deleted in bulk, which cached lookups already leave out, so the cache is kept.
+func (s *CachedStore) DeleteGrants(ctx context.Context, ids []string) error {
+	return s.Store.DeleteGrants(ctx, ids)
+}
+
+// RevokeGrant ends a grant before it expires
+func (s *CachedStore) RevokeGrant(ctx context.Context, id, actor string) (*models.PrivilegeGrant, error) {
+	grant, err := s.Store.RevokeGrant(ctx, id, actor)
+	if err != nil {
+		return nil, err
+	}
+	s.invalidateGrants(ctx, grant.UserID)
+	return grant, nil
+}
+
+// ExpireGrants records the grants that expired by the given time as expired
+func (s *CachedStore) ExpireGrants(ctx context.Context, now time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	grants, err := s.Store.ExpireGrants(ctx, now, limit)
+	if err != nil {
+		return nil, err
+	}
+	if len(grants) > 0 {
+		userIDs := make([]string, 0, len(grants))
+		for _, grant := range grants {
+			userIDs = append(userIDs, grant.UserID)
+		}
+		s.invalidateGrants(ctx, userIDs...)
+	}
+	return grants, nil
+}
+
+// CreateRequest stores a new privilege request, with its grant when it is
+// already approved
+func (s *CachedStore) CreateRequest(ctx context.Context, request *models.PrivilegeRequest) error {
+	if err := s.Store.CreateRequest(ctx, request); err != nil {
+		return err
+	}
+	if request.Status == RequestStatusApproved {
+		s.invalidateGrants(ctx, request.UserID)
+	}
+	return nil
+}
+
+// UpdateRequest applies fn to a privilege request atomically and creates or
+// extends the grant when fn approves the request
+func (s *CachedStore) UpdateRequest(ctx context.Context, id string, fn UpdateFunc) (*models.PrivilegeRequest, error) {
+	request, err := s.Store.UpdateRequest(ctx, id, fn)
+	if err != nil {
+		return nil, err
+	}
+	if request.Status == RequestStatusApproved {
+		s.invalidateGrants(ctx, request.UserID)
+	}
+	return request, nil
+}
@@ -0,0 +1,60 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"testing"
+)
+
+// TestTakeCredentialKeptUntilOpened checks credentials that fail to open are
+// kept, and are gone once they were opened
+func TestTakeCredentialKeptUntilOpened(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	s.credentials["grant_1"] = &Credential{GrantID: "grant_1", KeyID: "key_1", Sealed: []byte("sealed")}
+
+	errOpen := errors.New("master key unavailable")
+	err := s.TakeCredential(ctx, "grant_1", func(credential *Credential) error {
+		return errOpen
+	})
+	if !errors.Is(err, errOpen) {
+		t.Fatalf("failed open: error %v, want %v", err, errOpen)
+	}
+
+	var opened string
+	if err := s.TakeCredential(ctx, "grant_1", func(credential *Credential) error {
+		opened = string(credential.Sealed)
+		return nil
+	}); err != nil {
+		t.Fatalf("credentials were not kept after a failed open: %v", err)
+	}
+	if opened != "sealed" {
+		t.Errorf("opened %q, want the stored credentials", opened)
+	}
+
+	err = s.TakeCredential(ctx, "grant_1", func(credential *Credential) error {
+		t.Error("credentials were opened twice")
+		return nil
+	})
+	if !errors.Is(err, ErrNotFound) {
+		t.Errorf("second take: error %v, want ErrNotFound", err)
+	}
+}
+
+func TestGetOperator(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemoryStore()
+	if err := s.RegisterOperator(ctx, "operator-1", "eu"); err != nil {
+		t.Fatal(err)
+	}
+	operator, err := s.GetOperator(ctx, "operator-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if operator.Status != "active" || operator.Region != "eu" {
+		t.Errorf("operator %+v, want active in eu", operator)
+	}
+	if _, err := s.GetOperator(ctx, "operator-2"); !errors.Is(err, ErrNotFound) {
+		t.Errorf("unknown operator: error %v, want ErrNotFound", err)
+	}
+}
@@ -0,0 +1,136 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"hash/fnv"
+	"strconv"
+	"strings"
+)
+
+// dialect captures the SQL differences between the supported databases
+type dialect struct {
+	// driver is the default database/sql driver name
+	driver string
+	// timestamp is the column type used for times
+	timestamp string
+	// text is the column type used for JSON documents and other long strings
+	text string
+	// forUpdate locks selected rows for the rest of the transaction
+	forUpdate string
+	// numbered reports whether placeholders are written $1, $2, ... instead of ?
+	numbered bool
+	// duplicateKey reports whether upserts use ON DUPLICATE KEY UPDATE instead of ON CONFLICT
+	duplicateKey bool
+}
+
+// dialects maps storage drivers to their SQL dialect
+var dialects = map[string]dialect{
+	DriverMySQL: {
+		driver:       "mysql",
+		timestamp:    "DATETIME(6)",
+		text:         "MEDIUMTEXT",
+		forUpdate:    " FOR UPDATE",
+		duplicateKey: true,
+	},
+	DriverPostgres: {
+		driver:    "pgx",
+		timestamp: "TIMESTAMPTZ",
+		text:      "TEXT",
+		forUpdate: " FOR UPDATE",
+		numbered:  true,
+	},
+	// SQLite locks the whole database for the duration of a write transaction,
+	// so rows need no explicit locking
+	DriverSQLite: {
+		driver:    "sqlite",
+		timestamp: "TIMESTAMP",
+		text:      "TEXT",
+	},
+}
+
+// rebind rewrites ? placeholders into the dialect's placeholder syntax
+func (d dialect) rebind(query string) string {
+	if !d.numbered {
+		return query
+	}
+	var b strings.Builder
+	n := 0
+	for _, c := range query {
+		if c == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+// upsert builds a statement inserting a row into table, or updating the given
+// columns of the row that already has the same key
+func (d dialect) upsert(table string, keys, columns, update []string) string {
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(columns)), ", ")
+	query := fmt.Sprintf("INSERT INTO %s (%s) VALUES (%s)", table, strings.Join(columns, ", "), placeholders)
+
+	assignments := make([]string, 0, len(update))
+	for _, column := range update {
+		if d.duplicateKey {
+			assignments = append(assignments, fmt.Sprintf("%s = VALUES(%s)", column, column))
+		} else {
+			assignments = append(assignments, fmt.Sprintf("%s = excluded.%s", column, column))
+		}
+	}
+	if d.duplicateKey {
+		return query + " ON DUPLICATE KEY UPDATE " + strings.Join(assignments, ", ")
+	}
+	return query + fmt.Sprintf(" ON CONFLICT (%s) DO UPDATE SET %s", strings.Join(keys, ", "), strings.Join(assignments, ", "))
+}
+
+// lock acquires a named lock held by the connection's session until the
+// returned function releases it
+func (d dialect) lock(ctx context.Context, conn *sql.Conn, name string) (func(), error) {
+	switch {
+	case d.duplicateKey:
+		var acquired sql.NullInt64
+		if err := conn.QueryRowContext(ctx, `SELECT GET_LOCK(?, 60)`, name).Scan(&acquired); err != nil {
+			return nil, err
+		}
+		if acquired.Int64 != 1 {
+			return nil, fmt.Errorf("timed out waiting for lock %s", name)
+		}
+		return func() {
+			conn.ExecContext(context.Background(), `SELECT RELEASE_LOCK(?)`, name)
+		}, nil
+	case d.numbered:
+		key := lockKey(name)
+		if _, err := conn.ExecContext(ctx, `SELECT pg_advisory_lock($1)`, key); err != nil {
+			return nil, err
+		}
+		return func() {
+			conn.ExecContext(context.Background(), `SELECT pg_advisory_unlock($1)`, key)
+		}, nil
+	default:
+		// SQLite allows a single writer at a time; a concurrent runner fails to
+		// record an already applied version and rolls its migration back
+		return func() {}, nil
+	}
+}
+
+// lockKey derives the numeric advisory lock key PostgreSQL needs from a lock name
+func lockKey(name string) int64 {
+	h := fnv.New64a()
+	h.Write([]byte(name))
+	return int64(h.Sum64())
+}
+
+// driverRegistered reports whether a database/sql driver has been linked in
+func driverRegistered(name string) bool {
+	for _, driver := range sql.Drivers() {
+		if driver == name {
+			return true
+		}
+	}
+	return false
+}
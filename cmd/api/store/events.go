@@ -0,0 +1,299 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+	"github.com/petermein/apollo/internal/events"
+)
+
+// ErrGrantEnded is returned when changing a grant that was revoked or has expired
+var ErrGrantEnded = errors.New("grant has already ended")
+
+// Grant lifecycle event types; their payloads are described in the events catalog
+const (
+	EventRequested   = events.Requested
+	EventApproved    = events.Approved
+	EventDenied      = events.Denied
+	EventProvisioned = events.Provisioned
+	EventExtended    = events.Extended
+	EventRevoked     = events.Revoked
+	EventExpired     = events.Expired
+	EventClosed      = events.Closed
+	EventLapsed      = events.Lapsed
+	// EventExpiring warns ahead of a grant's expiry; it doesn't change its state
+	EventExpiring = events.Expiring
+	// EventHandedOver ends a grant whose remaining time went to another user
+	EventHandedOver = events.HandedOver
+)
+
+// Grant states derived from the event stream
+const (
+	GrantStatePending = "pending"
+	GrantStateDenied  = "denied"
+	GrantStateActive  = "active"
+	GrantStateRevoked = "revoked"
+	GrantStateExpired = "expired"
+	GrantStateClosed  = "closed"
+	GrantStateLapsed  = "lapsed"
+	// GrantStateHandedOver marks grants that ended when they were handed over
+	GrantStateHandedOver = "handed_over"
+)
+
+// Event is a change in the lifecycle of a privilege request and its grant.
+// The events of one request form a stream ordered by Version; the store writes
+// them in the same transaction as the change they describe.
+type Event struct {
+	ID        string          `json:"id"`
+	RequestID string          `json:"request_id"`
+	Version   int             `json:"version"`
+	Type      string          `json:"type"`
+	Actor     string          `json:"actor,omitempty"`
+	Time      time.Time       `json:"time"`
+	Data      json.RawMessage `json:"data,omitempty"`
+}
+
+// GrantEventData is the payload of approved, extended, revoked, expired and
+// handed_over events
+type GrantEventData struct {
+	GrantID   string    `json:"grant_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+	// ExtensionID is the approved request that extended the grant
+	ExtensionID string `json:"extension_id,omitempty"`
+	// HandoverID is the approved request that took the grant over, and
+	// ToGrantID and ToUserID the grant it created and its holder
+	HandoverID string `json:"handover_id,omitempty"`
+	ToGrantID  string `json:"to_grant_id,omitempty"`
+	ToUserID   string `json:"to_user_id,omitempty"`
+	// Module, ResourceID, UserID, Level and BoundTo describe the access an
+	// approved event grants, for the operators provisioning it
+	Module     string `json:"module,omitempty"`
+	ResourceID string `json:"resource_id,omitempty"`
+	UserID     string `json:"user_id,omitempty"`
+	Level      string `json:"level,omitempty"`
+	BoundTo    string `json:"bound_to,omitempty"`
+}
+
+// EventQuery pages through the events recorded in [From, To) ordered by time
+// and ID; zero bounds are open. Pages continue after the event identified by
+// AfterTime and AfterID, which are left empty for the first page.
+type EventQuery struct {
+	From      time.Time
+	To        time.Time
+	AfterTime time.Time
+	AfterID   string
+	Limit     int
+	// Types, when set, limits the events to those of the listed types
+	Types []string
+}
+
+// matches reports whether an event belongs to the query's page
+func (q EventQuery) matches(event Event) bool {
+	if event.Time.Before(q.From) || (!q.To.IsZero() && !event.Time.Before(q.To)) {
+		return false
+	}
+	if len(q.Types) > 0 && !slices.Contains(q.Types, event.Type) {
+		return false
+	}
+	if q.AfterID == "" {
+		return true
+	}
+	return event.Time.After(q.AfterTime) || (event.Time.Equal(q.AfterTime) && event.ID > q.AfterID)
+}
+
+// EventRepository persists the grant lifecycle events
+type EventRepository interface {
+	// AppendEvent adds an event that isn't the result of another store change,
+	// such as provisioned, to the end of a request's stream
+	AppendEvent(ctx context.Context, event *Event) error
+	// StreamEvents returns the events of a request, oldest first
+	StreamEvents(ctx context.Context, requestID string) ([]Event, error)
+	// ListEvents returns the events matching the query, ordered by time and ID
+	ListEvents(ctx context.Context, query EventQuery) ([]Event, error)
+}
+
+// GrantState is the current state of a request and its grant, derived from its events
+type GrantState struct {
+	RequestID   string                `json:"request_id"`
+	UserID      string                `json:"user_id"`
+	Module      string                `json:"module"`
+	ResourceID  string                `json:"resource_id"`
+	Level       models.PrivilegeLevel `json:"level"`
+	State       string                `json:"state"`
+	GrantID     string                `json:"grant_id,omitempty"`
+	ExpiresAt   *time.Time            `json:"expires_at,omitempty"`
+	Provisioned bool                  `json:"provisioned"`
+	Extensions  int                   `json:"extensions"`
+	ApprovedBy  string                `json:"approved_by,omitempty"`
+	EndedBy     string                `json:"ended_by,omitempty"`
+	Version     int                   `json:"version"`
+	UpdatedAt   time.Time             `json:"updated_at"`
+}
+
+// Project folds a request's events, oldest first, into its current state
+func Project(events []Event) (*GrantState, error) {
+	if len(events) == 0 {
+		return nil, ErrNotFound
+	}
+
+	state := &GrantState{RequestID: events[0].RequestID}
+	for _, event := range events {
+		switch event.Type {
+		case EventRequested:
+			var request models.PrivilegeRequest
+			if err := json.Unmarshal(event.Data, &request); err != nil {
+				return nil, fmt.Errorf("event %s: %v", event.ID, err)
+			}
+			state.UserID = request.UserID
+			state.Module = request.Module
+			state.ResourceID = request.ResourceID
+			state.Level = request.Level
+			state.State = GrantStatePending
+		case EventApproved, EventExtended:
+			var data GrantEventData
+			if err := json.Unmarshal(event.Data, &data); err != nil {
+				return nil, fmt.Errorf("event %s: %v", event.ID, err)
+			}
+			state.GrantID = data.GrantID
+			expiresAt := data.ExpiresAt
+			state.ExpiresAt = &expiresAt
+			if event.Type == EventApproved {
+				state.State = GrantStateActive
+				state.ApprovedBy = event.Actor
+			} else {
+				state.Extensions++
+			}
+		case EventDenied:
+			state.State = GrantStateDenied
+			state.EndedBy = event.Actor
+		case EventProvisioned:
+			state.Provisioned = true
+		case EventRevoked:
+			state.State = GrantStateRevoked
+			state.EndedBy = event.Actor
+		case EventExpired:
+			state.State = GrantStateExpired
+		case EventClosed:
+			state.State = GrantStateClosed
+		case EventLapsed:
+			state.State = GrantStateLapsed
+		case EventHandedOver:
+			state.State = GrantStateHandedOver
+			state.EndedBy = event.Actor
+		case EventExpiring:
+		default:
+			return nil, fmt.Errorf("event %s: unknown type %q", event.ID, event.Type)
+		}
+		state.Version = event.Version
+		state.UpdatedAt = event.Time
+	}
+	return state, nil
+}
+
+// ReplayEvents calls fn for every event after the query's position, a page at a time
+func ReplayEvents(ctx context.Context, repo EventRepository, query EventQuery, fn func(Event) error) error {
+	if query.Limit <= 0 {
+		query.Limit = 500
+	}
+	for {
+		events, err := repo.ListEvents(ctx, query)
+		if err != nil {
+			return err
+		}
+		for _, event := range events {
+			if err := fn(event); err != nil {
+				return err
+			}
+		}
+		if len(events) < query.Limit {
+			return nil
+		}
+		last := events[len(events)-1]
+		query.AfterTime, query.AfterID = last.Time, last.ID
+	}
+}
+
+// newEvent returns an event of the given type with its data encoded
+func newEvent(requestID, eventType, actor string, data interface{}) (*Event, error) {
+	event := &Event{RequestID: requestID, Type: eventType, Actor: actor}
+	if data != nil {
+		encoded, err := json.Marshal(data)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal %s event: %v", eventType, err)
+		}
+		event.Data = encoded
+	}
+	return event, nil
+}
+
+// approvalEvents returns the events written when a request is approved: the
+// approved event and, for an extension, the extended event of the extended
+// grant's request or, for a handover, the handed_over event of the request of
+// the grant that was handed over
+func approvalEvents(request *models.PrivilegeRequest, grant, handedOver *models.PrivilegeGrant) ([]*Event, error) {
+	approved, err := newEvent(request.ID, EventApproved, request.ApprovedBy, GrantEventData{
+		GrantID:    grant.ID,
+		ExpiresAt:  grant.ExpiresAt,
+		Module:     grant.Module,
+		ResourceID: grant.ResourceID,
+		UserID:     grant.UserID,
+		Level:      string(grant.Level),
+		BoundTo:    grant.BoundTo,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if handedOver != nil {
+		ended, err := newEvent(handedOver.RequestID, EventHandedOver, request.HandedOverBy, GrantEventData{
+			GrantID:    handedOver.ID,
+			ExpiresAt:  handedOver.ExpiresAt,
+			HandoverID: request.ID,
+			ToGrantID:  grant.ID,
+			ToUserID:   grant.UserID,
+		})
+		if err != nil {
+			return nil, err
+		}
+		return []*Event{approved, ended}, nil
+	}
+	if request.ExtendsGrant == "" {
+		return []*Event{approved}, nil
+	}
+	extended, err := newEvent(grant.RequestID, EventExtended, request.ApprovedBy, GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt, ExtensionID: request.ID})
+	if err != nil {
+		return nil, err
+	}
+	return []*Event{approved, extended}, nil
+}
+
+// extendGrant moves a grant's expiry out by the duration of the approved extension request
+func extendGrant(grant *models.PrivilegeGrant, request *models.PrivilegeRequest, now time.Time) error {
+	if grant.RevokedAt != nil || !grant.ExpiresAt.After(now) {
+		return ErrGrantEnded
+	}
+	grant.ExpiresAt = grant.ExpiresAt.Add(request.ExpiresAt.Sub(request.RequestedAt))
+	grant.Approvals = append(append([]models.ApprovalRecord(nil), grant.Approvals...), request.Approvals...)
+	grant.UpdatedAt = now
+	return nil
+}
+
+// handOverGrant ends the grant an approved handover request takes over and
+// returns the requester's grant for the rest of its time
+func handOverGrant(from *models.PrivilegeGrant, request *models.PrivilegeRequest, now time.Time) (*models.PrivilegeGrant, error) {
+	if from.RevokedAt != nil || !from.ExpiresAt.After(now) {
+		return nil, ErrGrantEnded
+	}
+	grant := grantFor(request)
+	grant.ExpiresAt = from.ExpiresAt
+	grant.HandedOverFrom = from.ID
+	from.RevokedAt = &now
+	from.RevokedBy = request.HandedOverBy
+	from.HandedOverTo = grant.ID
+	from.UpdatedAt = now
+	return grant, nil
+}
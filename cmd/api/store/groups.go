@@ -0,0 +1,22 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// DirectoryGroup is a group synced from the identity provider with its
+// members' user IDs
+type DirectoryGroup struct {
+	Name     string    `json:"name"`
+	Members  []string  `json:"members"`
+	SyncedAt time.Time `json:"synced_at"`
+}
+
+// GroupRepository persists the groups synced from the identity provider
+type GroupRepository interface {
+	// ReplaceGroups replaces all synced groups with the given ones at once
+	ReplaceGroups(ctx context.Context, groups []*DirectoryGroup) error
+	// ListGroups returns the synced groups ordered by name
+	ListGroups(ctx context.Context) ([]*DirectoryGroup, error)
+}
@@ -0,0 +1,85 @@
+//go:build sqlite
+
+package store
+
+import (
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	_ "modernc.org/sqlite"
+)
+
+// openBenchmarkSQLite opens a migrated SQLite store in a temporary directory
+// and seeds it with the benchmark jobs and operators in one transaction
+func openBenchmarkSQLite(b *testing.B, start, cutoff time.Time) *SQLStore {
+	ctx := context.Background()
+	s, err := OpenSQL(ctx, Config{Driver: DriverSQLite, DSN: filepath.Join(b.TempDir(), "apollo.db")})
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { s.db.Close() })
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer tx.Rollback()
+	for i := 0; i < benchmarkRows; i++ {
+		if err := s.insertJob(ctx, tx, benchmarkJob(i, start)); err != nil {
+			b.Fatal(err)
+		}
+		operator := benchmarkOperator(i, cutoff)
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO operators (id, status, last_seen, created_at, updated_at) VALUES (?, ?, ?, ?, ?)
+		`), operator.ID, operator.Status, operator.LastSeen, operator.CreatedAt, operator.UpdatedAt); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		b.Fatal(err)
+	}
+	if _, err := s.db.ExecContext(ctx, `ANALYZE`); err != nil {
+		b.Fatal(err)
+	}
+	return s
+}
+
+// dropIndex drops an index, so the lookup it serves falls back to another
+// index or scans the table
+func dropIndex(b *testing.B, s *SQLStore, name string) {
+	if _, err := s.db.ExecContext(context.Background(), `DROP INDEX `+name); err != nil {
+		b.Fatal(err)
+	}
+}
+
+func BenchmarkSQLiteListPendingJobs(b *testing.B) {
+	cutoff := time.Now().UTC()
+	s := openBenchmarkSQLite(b, cutoff.Add(-benchmarkRows*time.Second), cutoff)
+	b.Run("indexed", func(b *testing.B) {
+		benchmarkListPendingJobs(b, s)
+	})
+	// Without 0007's index the pending jobs of every module are read through
+	// the status index of migration 0002 and filtered
+	dropIndex(b, s, "idx_jobs_pending")
+	b.Run("status_index", func(b *testing.B) {
+		benchmarkListPendingJobs(b, s)
+	})
+	dropIndex(b, s, "idx_jobs_status")
+	b.Run("unindexed", func(b *testing.B) {
+		benchmarkListPendingJobs(b, s)
+	})
+}
+
+func BenchmarkSQLiteStaleOperators(b *testing.B) {
+	cutoff := time.Now().UTC()
+	s := openBenchmarkSQLite(b, cutoff.Add(-benchmarkRows*time.Second), cutoff)
+	b.Run("indexed", func(b *testing.B) {
+		benchmarkStaleOperators(b, s, cutoff)
+	})
+	dropIndex(b, s, "idx_operators_status")
+	b.Run("unindexed", func(b *testing.B) {
+		benchmarkStaleOperators(b, s, cutoff)
+	})
+}
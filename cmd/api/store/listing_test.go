@@ -0,0 +1,126 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+)
+
+// The listing benchmarks seed a store with as many jobs and operators as a
+// long-running deployment keeps, almost all of them finished or gone, and
+// look up the few that are pending or stale. Migration 0007 indexes these
+// lookups; the SQLite benchmarks run them with and without its indexes.
+const (
+	benchmarkRows = 100000
+	// benchmarkPendingEvery makes one job of each module in this many pending
+	benchmarkPendingEvery = 1000
+	// benchmarkActiveEvery makes one operator in this many active
+	benchmarkActiveEvery = 500
+)
+
+// benchmarkModules are the modules the seeded jobs are spread over
+var benchmarkModules = []string{"mysql", "kubernetes", "ssh", "github", "ldap", "snowflake", "elasticsearch", "mock"}
+
+// benchmarkJob returns the i-th seeded job, created a second after the one before
+func benchmarkJob(i int, start time.Time) *Job {
+	status := JobStatusCompleted
+	if (i/len(benchmarkModules))%benchmarkPendingEvery == 0 {
+		status = JobStatusPending
+	}
+	created := start.Add(time.Duration(i) * time.Second)
+	return &Job{
+		ID:        fmt.Sprintf("job_%06d", i),
+		Module:    benchmarkModules[i%len(benchmarkModules)],
+		Type:      "ping",
+		Request:   json.RawMessage(`{"server":"orders-db"}`),
+		Status:    status,
+		Result:    "pong in 1ms",
+		CreatedAt: created,
+		UpdatedAt: created,
+	}
+}
+
+// benchmarkOperator returns the i-th seeded operator. Half the active ones
+// were last seen before the stale cutoff.
+func benchmarkOperator(i int, cutoff time.Time) *modules.OperatorInfo {
+	status := "inactive"
+	lastSeen := cutoff.Add(-time.Duration(i) * time.Minute)
+	if i%benchmarkActiveEvery == 0 {
+		status = "active"
+		if i%(2*benchmarkActiveEvery) == 0 {
+			lastSeen = cutoff.Add(time.Minute)
+		}
+	}
+	return &modules.OperatorInfo{
+		ID:        fmt.Sprintf("operator-%06d", i),
+		Status:    status,
+		LastSeen:  lastSeen,
+		CreatedAt: lastSeen,
+		UpdatedAt: lastSeen,
+	}
+}
+
+// benchmarkListPendingJobs lists the pending jobs of one module, as operators
+// polling for work do
+func benchmarkListPendingJobs(b *testing.B, jobs JobRepository) {
+	ctx := context.Background()
+	want := 0
+	for i := 0; i < benchmarkRows; i++ {
+		if job := benchmarkJob(i, time.Time{}); job.Status == JobStatusPending && job.Module == "mysql" {
+			want++
+		}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		listed, err := jobs.ListJobs(ctx, JobFilter{Status: JobStatusPending, Module: "mysql"})
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(listed) != want {
+			b.Fatalf("listed %d pending jobs, want %d", len(listed), want)
+		}
+	}
+}
+
+// benchmarkStaleOperators looks up the active operators not seen since the
+// cutoff, as the API server's health check does
+func benchmarkStaleOperators(b *testing.B, store Store, cutoff time.Time) {
+	ctx := context.Background()
+	want := benchmarkRows / benchmarkActiveEvery / 2
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		ids, err := store.StaleOperators(ctx, cutoff)
+		if err != nil {
+			b.Fatal(err)
+		}
+		if len(ids) != want {
+			b.Fatalf("found %d stale operators, want %d", len(ids), want)
+		}
+	}
+}
+
+// seedMemoryStore fills a memory store with the benchmark jobs and operators
+func seedMemoryStore(start, cutoff time.Time) *MemoryStore {
+	s := NewMemoryStore()
+	for i := 0; i < benchmarkRows; i++ {
+		job := benchmarkJob(i, start)
+		s.jobs[job.ID] = job
+		operator := benchmarkOperator(i, cutoff)
+		s.operators[operator.ID] = operator
+	}
+	return s
+}
+
+func BenchmarkMemoryListPendingJobs(b *testing.B) {
+	start := time.Now().UTC().Add(-benchmarkRows * time.Second)
+	benchmarkListPendingJobs(b, seedMemoryStore(start, time.Now().UTC()))
+}
+
+func BenchmarkMemoryStaleOperators(b *testing.B) {
+	cutoff := time.Now().UTC()
+	benchmarkStaleOperators(b, seedMemoryStore(cutoff.Add(-benchmarkRows*time.Second), cutoff), cutoff)
+}
@@ -0,0 +1,1645 @@
+package store
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// MemoryStore keeps all state in memory; it is lost when the server stops
+type MemoryStore struct {
+	mu   sync.RWMutex
+	jobs map[string]*Job
+	// archivedJobs holds the finished jobs moved out of jobs
+	archivedJobs map[string]*Job
+	requests     map[string]*models.PrivilegeRequest
+	approvals    map[string][]string
+	grants       map[string]*models.PrivilegeGrant
+	operators    map[string]*modules.OperatorInfo
+	servers      map[string]map[string]*modules.ServerInfo
+	audit        map[string]audit.Entry
+	events       []Event
+	// expired holds the grants recorded as expired
+	expired map[string]bool
+	// warned holds the expiry time each grant's holder was last warned about
+	warned      map[string]time.Time
+	credentials map[string]*Credential
+	outbox      map[string]*outboxRow
+	deliveries  []Delivery
+	accounts    map[string]*ServiceAccount
+	tokens      map[string]*ServiceToken
+	// operatorTokens holds the tokens issued to operators by ID
+	operatorTokens map[string]*OperatorToken
+	// groups holds the groups synced from the identity provider by name
+	groups map[string]*DirectoryGroup
+	// webauthn holds the hardware keys users registered by ID
+	webauthn map[string]*WebAuthnCredential
+	// sessions holds the sessions users authenticated with by ID
+	sessions map[string]*Session
+	// sessionsRevoked holds when each user's sessions were last revoked at once
+	sessionsRevoked map[string]time.Time
+}
+
+// outboxRow is an outbox entry and when it is next due
+type outboxRow struct {
+	entry       OutboxEntry
+	availableAt time.Time
+}
+
+// NewMemoryStore creates an empty in-memory store
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		jobs:         make(map[string]*Job),
+		archivedJobs: make(map[string]*Job),
+		requests:     make(map[string]*models.PrivilegeRequest),
+		approvals:    make(map[string][]string),
+		grants:       make(map[string]*models.PrivilegeGrant),
+		operators:    make(map[string]*modules.OperatorInfo),
+		servers:      make(map[string]map[string]*modules.ServerInfo),
+		audit:        make(map[string]audit.Entry),
+		expired:      make(map[string]bool),
+		warned:       make(map[string]time.Time),
+		credentials:  make(map[string]*Credential),
+		outbox:       make(map[string]*outboxRow),
+		accounts:     make(map[string]*ServiceAccount),
+		tokens:       make(map[string]*ServiceToken),
+
+		operatorTokens: make(map[string]*OperatorToken),
+		groups:         make(map[string]*DirectoryGroup),
+		webauthn:       make(map[string]*WebAuthnCredential),
+
+		sessions:        make(map[string]*Session),
+		sessionsRevoked: make(map[string]time.Time),
+	}
+}
+
+// CreateJob stores a new job and assigns its ID
+func (s *MemoryStore) CreateJob(ctx context.Context, job *Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	job.ID = newID("job")
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	copied := *job
+	s.jobs[job.ID] = &copied
+	return nil
+}
+
+// GetJob returns the job with the given ID
+func (s *MemoryStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		if job, ok = s.archivedJobs[id]; !ok {
+			return nil, ErrNotFound
+		}
+	}
+	copied := *job
+	return &copied, nil
+}
+
+// ListJobs returns the jobs matching the filter
+func (s *MemoryStore) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var jobs []*Job
+	for _, job := range s.jobs {
+		if (filter.Status == "" || job.Status == filter.Status) && (filter.Module == "" || job.Module == filter.Module) {
+			copied := *job
+			jobs = append(jobs, &copied)
+		}
+	}
+	return paginate(jobs, filter.Page, JobSortFields, "id", compareJobs), nil
+}
+
+// UpdateJob records a job's status and result
+func (s *MemoryStore) UpdateJob(ctx context.Context, id, status, result, errMsg string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	job, ok := s.jobs[id]
+	if !ok {
+		return ErrNotFound
+	}
+	job.Status = status
+	job.Result = result
+	job.Error = errMsg
+	job.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// FinishedJobsBefore returns up to limit completed or failed jobs last updated before the given time
+func (s *MemoryStore) FinishedJobsBefore(ctx context.Context, before time.Time, limit int) ([]*Job, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var jobs []*Job
+	for _, table := range []map[string]*Job{s.jobs, s.archivedJobs} {
+		for _, job := range table {
+			if (job.Status == JobStatusCompleted || job.Status == JobStatusFailed) && job.UpdatedAt.Before(before) {
+				copied := *job
+				jobs = append(jobs, &copied)
+			}
+		}
+	}
+	sort.Slice(jobs, func(i, j int) bool {
+		return jobs[i].UpdatedAt.Before(jobs[j].UpdatedAt)
+	})
+	if len(jobs) > limit {
+		jobs = jobs[:limit]
+	}
+	return jobs, nil
+}
+
+// DeleteJobs removes the jobs with the given IDs
+func (s *MemoryStore) DeleteJobs(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.jobs, id)
+		delete(s.archivedJobs, id)
+	}
+	return nil
+}
+
+// ArchiveJobs moves up to limit finished jobs last updated before the given time to the archive
+func (s *MemoryStore) ArchiveJobs(ctx context.Context, before time.Time, limit int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var finished []*Job
+	for _, job := range s.jobs {
+		if (job.Status == JobStatusCompleted || job.Status == JobStatusFailed) && job.UpdatedAt.Before(before) {
+			finished = append(finished, job)
+		}
+	}
+	sort.Slice(finished, func(i, j int) bool {
+		return finished[i].UpdatedAt.Before(finished[j].UpdatedAt)
+	})
+	if len(finished) > limit {
+		finished = finished[:limit]
+	}
+	for _, job := range finished {
+		delete(s.jobs, job.ID)
+		s.archivedJobs[job.ID] = job
+	}
+	return len(finished), nil
+}
+
+// CreateRequest stores a new privilege request and assigns its ID
+func (s *MemoryStore) CreateRequest(ctx context.Context, request *models.PrivilegeRequest) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	request.ID = newID("req")
+	request.CreatedAt = now
+	request.UpdatedAt = now
+
+	requested, err := newEvent(request.ID, EventRequested, request.UserID, request)
+	if err != nil {
+		return err
+	}
+	events := []*Event{requested}
+	var grant, handedOver *models.PrivilegeGrant
+	if request.Status == RequestStatusApproved {
+		if grant, handedOver, err = s.approvedGrant(request, now); err != nil {
+			return err
+		}
+		approval, err := approvalEvents(request, grant, handedOver)
+		if err != nil {
+			return err
+		}
+		events = append(events, approval...)
+	}
+
+	if handedOver != nil {
+		if err := s.endHandedOverGrant(handedOver, now); err != nil {
+			return err
+		}
+	}
+	s.requests[request.ID] = copyRequest(request)
+	if grant != nil {
+		s.grants[grant.ID] = grant
+	}
+	s.appendEvents(events)
+	return nil
+}
+
+// approvedGrant returns the grant for an approved request: a new grant, a
+// copy of the grant it extends with the new expiry, or the requester's grant
+// of a handover along with a copy of the grant it ends
+func (s *MemoryStore) approvedGrant(request *models.PrivilegeRequest, now time.Time) (*models.PrivilegeGrant, *models.PrivilegeGrant, error) {
+	if request.HandsOverGrant != "" {
+		from, ok := s.grants[request.HandsOverGrant]
+		if !ok {
+			return nil, nil, ErrNotFound
+		}
+		if s.expired[from.ID] {
+			return nil, nil, ErrGrantEnded
+		}
+		handedOver := *from
+		grant, err := handOverGrant(&handedOver, request, now)
+		if err != nil {
+			return nil, nil, err
+		}
+		return grant, &handedOver, nil
+	}
+	if request.ExtendsGrant == "" {
+		return grantFor(request), nil, nil
+	}
+	grant, ok := s.grants[request.ExtendsGrant]
+	if !ok {
+		return nil, nil, ErrNotFound
+	}
+	extended := *grant
+	if err := extendGrant(&extended, request, now); err != nil {
+		return nil, nil, err
+	}
+	return &extended, nil, nil
+}
+
+// endHandedOverGrant stores a grant that ended when it was handed over,
+// discards its credentials and queues the job revoking it
+func (s *MemoryStore) endHandedOverGrant(grant *models.PrivilegeGrant, now time.Time) error {
+	job, err := revokeJob(grant, now)
+	if err != nil {
+		return err
+	}
+	s.grants[grant.ID] = grant
+	delete(s.credentials, grant.ID)
+	s.jobs[job.ID] = job
+	return nil
+}
+
+// GetRequest returns the privilege request with the given ID
+func (s *MemoryStore) GetRequest(ctx context.Context, id string) (*models.PrivilegeRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return copyRequest(request), nil
+}
+
+// GetApprovals returns who approved a privilege request so far
+func (s *MemoryStore) GetApprovals(ctx context.Context, id string) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	if _, ok := s.requests[id]; !ok {
+		return nil, ErrNotFound
+	}
+	return append([]string(nil), s.approvals[id]...), nil
+}
+
+// ListRequests returns the matching privilege requests, newest first
+func (s *MemoryStore) ListRequests(ctx context.Context, filter RequestFilter) ([]*models.PrivilegeRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	requests := make([]*models.PrivilegeRequest, 0, len(s.requests))
+	for _, request := range s.requests {
+		if (filter.UserID == "" || request.UserID == filter.UserID) && (filter.Status == "" || request.Status == filter.Status) {
+			requests = append(requests, copyRequest(request))
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.After(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+// UpdateRequest applies fn to a privilege request under the store's lock
+func (s *MemoryStore) UpdateRequest(ctx context.Context, id string, fn UpdateFunc) (*models.PrivilegeRequest, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	request, ok := s.requests[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+
+	working := copyRequest(request)
+	approvals, err := fn(working, append([]string(nil), s.approvals[id]...))
+	if err != nil {
+		return nil, err
+	}
+	working.UpdatedAt = time.Now().UTC()
+
+	var events []*Event
+	var grant, handedOver *models.PrivilegeGrant
+	switch {
+	case request.Status != RequestStatusApproved && working.Status == RequestStatusApproved:
+		if grant, handedOver, err = s.approvedGrant(working, working.UpdatedAt); err != nil {
+			return nil, err
+		}
+		if events, err = approvalEvents(working, grant, handedOver); err != nil {
+			return nil, err
+		}
+	case request.Status != RequestStatusDenied && working.Status == RequestStatusDenied:
+		denied, err := newEvent(id, EventDenied, working.DeniedBy, nil)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, denied)
+	case request.Status != RequestStatusExpired && working.Status == RequestStatusExpired:
+		lapsed, err := newEvent(id, EventLapsed, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, lapsed)
+	}
+
+	if handedOver != nil {
+		if err := s.endHandedOverGrant(handedOver, working.UpdatedAt); err != nil {
+			return nil, err
+		}
+	}
+	s.requests[id] = working
+	s.approvals[id] = approvals
+	if grant != nil {
+		s.grants[grant.ID] = grant
+	}
+	s.appendEvents(events)
+	return copyRequest(working), nil
+}
+
+// RequestsSince returns the requests created after the given time, oldest first
+func (s *MemoryStore) RequestsSince(ctx context.Context, since time.Time) ([]*models.PrivilegeRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var requests []*models.PrivilegeRequest
+	for _, request := range s.requests {
+		if request.CreatedAt.After(since) {
+			requests = append(requests, copyRequest(request))
+		}
+	}
+	sort.Slice(requests, func(i, j int) bool {
+		return requests[i].CreatedAt.Before(requests[j].CreatedAt)
+	})
+	return requests, nil
+}
+
+// UserRequests returns the user's requests created after the given time
+func (s *MemoryStore) UserRequests(ctx context.Context, userID string, since time.Time) ([]*models.PrivilegeRequest, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var requests []*models.PrivilegeRequest
+	for _, request := range s.requests {
+		if request.UserID == userID && request.CreatedAt.After(since) {
+			requests = append(requests, copyRequest(request))
+		}
+	}
+	return requests, nil
+}
+
+// CountRequests returns the number of requests the user submitted since the given time
+func (s *MemoryStore) CountRequests(ctx context.Context, userID string, since time.Time) (int, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	count := 0
+	for _, request := range s.requests {
+		if request.UserID == userID && !request.CreatedAt.Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// LastDenial returns when the user's most recent request was denied, or the zero time
+func (s *MemoryStore) LastDenial(ctx context.Context, userID string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var last time.Time
+	for _, request := range s.requests {
+		if request.UserID == userID && request.Status == RequestStatusDenied && request.UpdatedAt.After(last) {
+			last = request.UpdatedAt
+		}
+	}
+	return last, nil
+}
+
+// CreateGrant stores a new privilege grant and assigns its ID
+func (s *MemoryStore) CreateGrant(ctx context.Context, grant *models.PrivilegeGrant) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	grant.ID = newID("grant")
+	grant.CreatedAt = now
+	grant.UpdatedAt = now
+	copied := *grant
+	s.grants[grant.ID] = &copied
+	return nil
+}
+
+// GetGrant returns the privilege grant with the given ID
+func (s *MemoryStore) GetGrant(ctx context.Context, id string) (*models.PrivilegeGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	grant, ok := s.grants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *grant
+	return &copied, nil
+}
+
+// GetRequestGrant returns the grant created for the given privilege request
+func (s *MemoryStore) GetRequestGrant(ctx context.Context, requestID string) (*models.PrivilegeGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, grant := range s.grants {
+		if grant.RequestID == requestID {
+			copied := *grant
+			return &copied, nil
+		}
+	}
+	return nil, ErrNotFound
+}
+
+// ListGrants returns the user's grants, or all grants when userID is empty
+func (s *MemoryStore) ListGrants(ctx context.Context, userID string, activeAt time.Time) ([]*models.PrivilegeGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var grants []*models.PrivilegeGrant
+	for _, grant := range s.grants {
+		active := activeAt.IsZero() || (grant.ExpiresAt.After(activeAt) && (grant.RevokedAt == nil || grant.RevokedAt.After(activeAt)))
+		if (userID == "" || grant.UserID == userID) && active {
+			copied := *grant
+			grants = append(grants, &copied)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].GrantedAt.After(grants[j].GrantedAt)
+	})
+	return grants, nil
+}
+
+// DeleteGrant removes a privilege grant
+func (s *MemoryStore) DeleteGrant(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.grants[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.grants, id)
+	delete(s.credentials, id)
+	return nil
+}
+
+// ExpiredGrantsBefore returns up to limit grants that expired before the given time
+func (s *MemoryStore) ExpiredGrantsBefore(ctx context.Context, before time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var grants []*models.PrivilegeGrant
+	for _, grant := range s.grants {
+		if grant.ExpiresAt.Before(before) {
+			copied := *grant
+			grants = append(grants, &copied)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].ExpiresAt.Before(grants[j].ExpiresAt)
+	})
+	if len(grants) > limit {
+		grants = grants[:limit]
+	}
+	return grants, nil
+}
+
+// DeleteGrants removes the grants with the given IDs
+func (s *MemoryStore) DeleteGrants(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.grants, id)
+		delete(s.expired, id)
+		delete(s.credentials, id)
+	}
+	return nil
+}
+
+// RevokeGrant ends a grant before it expires
+func (s *MemoryStore) RevokeGrant(ctx context.Context, id, actor string) (*models.PrivilegeGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	now := time.Now().UTC()
+	if grant.RevokedAt != nil || s.expired[id] || !grant.ExpiresAt.After(now) {
+		return nil, ErrGrantEnded
+	}
+
+	revoked, err := newEvent(grant.RequestID, EventRevoked, actor, GrantEventData{GrantID: id, ExpiresAt: grant.ExpiresAt})
+	if err != nil {
+		return nil, err
+	}
+	job, err := revokeJob(grant, now)
+	if err != nil {
+		return nil, err
+	}
+	grant.RevokedAt = &now
+	grant.RevokedBy = actor
+	grant.UpdatedAt = now
+	delete(s.credentials, id)
+	s.jobs[job.ID] = job
+	s.appendEvents([]*Event{revoked})
+	copied := *grant
+	return &copied, nil
+}
+
+// ExpireGrants records up to limit grants that expired by the given time as expired
+func (s *MemoryStore) ExpireGrants(ctx context.Context, now time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var grants []*models.PrivilegeGrant
+	for id, grant := range s.grants {
+		if grant.RevokedAt == nil && !s.expired[id] && !grant.ExpiresAt.After(now) {
+			grants = append(grants, grant)
+		}
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].ExpiresAt.Before(grants[j].ExpiresAt)
+	})
+	if len(grants) > limit {
+		grants = grants[:limit]
+	}
+
+	expired := make([]*models.PrivilegeGrant, 0, len(grants))
+	for _, grant := range grants {
+		event, err := newEvent(grant.RequestID, EventExpired, "", GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt})
+		if err != nil {
+			return nil, err
+		}
+		job, err := revokeJob(grant, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		s.expired[grant.ID] = true
+		delete(s.credentials, grant.ID)
+		s.jobs[job.ID] = job
+		s.appendEvents([]*Event{event})
+		copied := *grant
+		expired = append(expired, &copied)
+	}
+	return expired, nil
+}
+
+// WarnExpiringGrants records an expiring event for the grants expiring by before
+func (s *MemoryStore) WarnExpiringGrants(ctx context.Context, now, before time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var grants []*models.PrivilegeGrant
+	for id, grant := range s.grants {
+		if grant.RevokedAt != nil || s.expired[id] || !grant.ExpiresAt.After(now) || grant.ExpiresAt.After(before) {
+			continue
+		}
+		if warned, ok := s.warned[id]; ok && warned.Equal(grant.ExpiresAt) {
+			continue
+		}
+		grants = append(grants, grant)
+	}
+	sort.Slice(grants, func(i, j int) bool {
+		return grants[i].ExpiresAt.Before(grants[j].ExpiresAt)
+	})
+	if len(grants) > limit {
+		grants = grants[:limit]
+	}
+
+	warned := make([]*models.PrivilegeGrant, 0, len(grants))
+	for _, grant := range grants {
+		event, err := newEvent(grant.RequestID, EventExpiring, "", GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt})
+		if err != nil {
+			return nil, err
+		}
+		s.warned[grant.ID] = grant.ExpiresAt
+		s.appendEvents([]*Event{event})
+		copied := *grant
+		warned = append(warned, &copied)
+	}
+	return warned, nil
+}
+
+// AppendEvent adds an event to the end of a request's stream
+func (s *MemoryStore) AppendEvent(ctx context.Context, event *Event) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.requests[event.RequestID]; !ok {
+		return ErrNotFound
+	}
+	s.appendEvents([]*Event{event})
+	return nil
+}
+
+// appendEvents assigns the events their IDs and versions and stores them; the
+// caller holds the lock
+func (s *MemoryStore) appendEvents(events []*Event) {
+	for _, event := range events {
+		version := 0
+		for _, existing := range s.events {
+			if existing.RequestID == event.RequestID && existing.Version > version {
+				version = existing.Version
+			}
+		}
+		event.ID = newID("evt")
+		event.Version = version + 1
+		event.Time = time.Now().UTC().Truncate(time.Microsecond)
+		s.events = append(s.events, *event)
+
+		id := newID("outbox")
+		s.outbox[id] = &outboxRow{entry: OutboxEntry{ID: id, Event: *event}, availableAt: event.Time}
+	}
+}
+
+// ClaimOutbox returns up to limit entries due by now and hides them until lease has passed
+func (s *MemoryStore) ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var due []*outboxRow
+	for _, row := range s.outbox {
+		if row.entry.DeadAt == nil && !row.availableAt.After(now) {
+			due = append(due, row)
+		}
+	}
+	sort.Slice(due, func(i, j int) bool {
+		if !due[i].availableAt.Equal(due[j].availableAt) {
+			return due[i].availableAt.Before(due[j].availableAt)
+		}
+		return due[i].entry.ID < due[j].entry.ID
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	entries := make([]OutboxEntry, 0, len(due))
+	for _, row := range due {
+		row.availableAt = now.Add(lease)
+		entry := row.entry
+		entry.Delivered = append([]string(nil), row.entry.Delivered...)
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// CompleteOutbox removes entries delivered to every publisher
+func (s *MemoryStore) CompleteOutbox(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.outbox, id)
+	}
+	return nil
+}
+
+// RetryOutbox records a failed delivery and when to try again
+func (s *MemoryStore) RetryOutbox(ctx context.Context, entry OutboxEntry, retryAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.outbox[entry.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	row.entry.Attempts = entry.Attempts
+	row.entry.LastError = entry.LastError
+	row.entry.Delivered = append([]string(nil), entry.Delivered...)
+	row.availableAt = retryAt
+	return nil
+}
+
+// ReplayOutbox queues the events again for the publisher, every publisher when empty
+func (s *MemoryStore) ReplayOutbox(ctx context.Context, eventIDs []string, publisher string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	byID := make(map[string]Event, len(s.events))
+	for _, event := range s.events {
+		byID[event.ID] = event
+	}
+	rows := make([]*outboxRow, 0, len(eventIDs))
+	now := time.Now().UTC()
+	for _, id := range eventIDs {
+		event, ok := byID[id]
+		if !ok {
+			return ErrNotFound
+		}
+		rows = append(rows, &outboxRow{entry: OutboxEntry{ID: newID("outbox"), Event: event, Publisher: publisher}, availableAt: now})
+	}
+	for _, row := range rows {
+		s.outbox[row.entry.ID] = row
+	}
+	return nil
+}
+
+// DeadLetterOutbox records the last failed delivery of an entry and parks it
+func (s *MemoryStore) DeadLetterOutbox(ctx context.Context, entry OutboxEntry, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	row, ok := s.outbox[entry.ID]
+	if !ok {
+		return ErrNotFound
+	}
+	deadAt := now.UTC()
+	row.entry.Attempts = entry.Attempts
+	row.entry.LastError = entry.LastError
+	row.entry.Delivered = append([]string(nil), entry.Delivered...)
+	row.entry.DeadAt = &deadAt
+	row.availableAt = deadAt
+	return nil
+}
+
+// ListDeadLetters returns up to limit dead letters, oldest first
+func (s *MemoryStore) ListDeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []OutboxEntry
+	for _, row := range s.outbox {
+		if row.entry.DeadAt == nil {
+			continue
+		}
+		entry := row.entry
+		entry.Delivered = append([]string(nil), row.entry.Delivered...)
+		deadAt := *row.entry.DeadAt
+		entry.DeadAt = &deadAt
+		entries = append(entries, entry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if !entries[i].DeadAt.Equal(*entries[j].DeadAt) {
+			return entries[i].DeadAt.Before(*entries[j].DeadAt)
+		}
+		return entries[i].ID < entries[j].ID
+	})
+	if limit > 0 && len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// RedeliverOutbox queues dead letters for delivery again with their attempts reset
+func (s *MemoryStore) RedeliverOutbox(ctx context.Context, ids []string, now time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		if row, ok := s.outbox[id]; !ok || row.entry.DeadAt == nil {
+			return ErrNotFound
+		}
+	}
+	for _, id := range ids {
+		row := s.outbox[id]
+		row.entry.Attempts = 0
+		row.entry.DeadAt = nil
+		row.availableAt = now.UTC()
+	}
+	return nil
+}
+
+// RecordDeliveries stores delivery attempts
+func (s *MemoryStore) RecordDeliveries(ctx context.Context, deliveries []Delivery) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, delivery := range deliveries {
+		if delivery.ID == "" {
+			delivery.ID = newID("dlv")
+		}
+		delivery.Time = delivery.Time.UTC()
+		s.deliveries = append(s.deliveries, delivery)
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery attempts matching the query, newest first
+func (s *MemoryStore) ListDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var deliveries []Delivery
+	for i := len(s.deliveries) - 1; i >= 0; i-- {
+		delivery := s.deliveries[i]
+		if query.EventID != "" && delivery.EventID != query.EventID {
+			continue
+		}
+		if query.Publisher != "" && delivery.Publisher != query.Publisher {
+			continue
+		}
+		if query.Status != "" && delivery.Status != query.Status {
+			continue
+		}
+		deliveries = append(deliveries, delivery)
+		if query.Limit > 0 && len(deliveries) == query.Limit {
+			break
+		}
+	}
+	return deliveries, nil
+}
+
+// PruneDeliveries removes the delivery attempts made before the time
+func (s *MemoryStore) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	kept := s.deliveries[:0]
+	for _, delivery := range s.deliveries {
+		if !delivery.Time.Before(before) {
+			kept = append(kept, delivery)
+		}
+	}
+	pruned := len(s.deliveries) - len(kept)
+	s.deliveries = kept
+	return pruned, nil
+}
+
+// StreamEvents returns the events of a request, oldest first
+func (s *MemoryStore) StreamEvents(ctx context.Context, requestID string) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []Event
+	for _, event := range s.events {
+		if event.RequestID == requestID {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		return events[i].Version < events[j].Version
+	})
+	return events, nil
+}
+
+// ListEvents returns the events matching the query, ordered by time and ID
+func (s *MemoryStore) ListEvents(ctx context.Context, query EventQuery) ([]Event, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var events []Event
+	for _, event := range s.events {
+		if query.matches(event) {
+			events = append(events, event)
+		}
+	}
+	sort.Slice(events, func(i, j int) bool {
+		if events[i].Time.Equal(events[j].Time) {
+			return events[i].ID < events[j].ID
+		}
+		return events[i].Time.Before(events[j].Time)
+	})
+	if query.Limit > 0 && len(events) > query.Limit {
+		events = events[:query.Limit]
+	}
+	return events, nil
+}
+
+// PutCredential stores the credentials of an active grant and records it as provisioned
+func (s *MemoryStore) PutCredential(ctx context.Context, credential *Credential, actor string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	grant, ok := s.grants[credential.GrantID]
+	if !ok {
+		return ErrNotFound
+	}
+	if grant.RevokedAt != nil || s.expired[grant.ID] || !grant.ExpiresAt.After(time.Now()) {
+		return ErrGrantEnded
+	}
+	provisioned, err := newEvent(grant.RequestID, EventProvisioned, actor, GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt})
+	if err != nil {
+		return err
+	}
+	credential.CreatedAt = time.Now().UTC()
+	copied := *credential
+	s.credentials[grant.ID] = &copied
+	s.appendEvents([]*Event{provisioned})
+	return nil
+}
+
+// TakeCredential has open read the credentials of a grant and deletes them
+// once it succeeded
+func (s *MemoryStore) TakeCredential(ctx context.Context, grantID string, open func(*Credential) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.credentials[grantID]
+	if !ok {
+		return ErrNotFound
+	}
+	copied := *credential
+	if err := open(&copied); err != nil {
+		return err
+	}
+	delete(s.credentials, grantID)
+	return nil
+}
+
+// CredentialsNotUnder returns up to limit credentials wrapped with another master key
+func (s *MemoryStore) CredentialsNotUnder(ctx context.Context, keyID string, limit int) ([]*Credential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var credentials []*Credential
+	for _, credential := range s.credentials {
+		if credential.KeyID != keyID {
+			copied := *credential
+			credentials = append(credentials, &copied)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].GrantID < credentials[j].GrantID
+	})
+	if len(credentials) > limit {
+		credentials = credentials[:limit]
+	}
+	return credentials, nil
+}
+
+// RewrapCredential replaces credentials still wrapped with the previous key
+func (s *MemoryStore) RewrapCredential(ctx context.Context, credential *Credential, previousKeyID string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored, ok := s.credentials[credential.GrantID]
+	if !ok || stored.KeyID != previousKeyID {
+		return ErrNotFound
+	}
+	stored.KeyID = credential.KeyID
+	stored.Sealed = credential.Sealed
+	stored.Ref = credential.Ref
+	return nil
+}
+
+// Record stores an audit entry and assigns its ID
+func (s *MemoryStore) Record(ctx context.Context, entry audit.Entry) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	entry.ID = newID("audit")
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	entry.Time = entry.Time.UTC().Truncate(time.Microsecond)
+	s.audit[entry.ID] = entry
+	return nil
+}
+
+// AuditBefore returns up to limit audit entries recorded before the given time, oldest first
+func (s *MemoryStore) AuditBefore(ctx context.Context, before time.Time, limit int) ([]audit.Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []audit.Entry
+	for _, entry := range s.audit {
+		if entry.Time.Before(before) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if len(entries) > limit {
+		entries = entries[:limit]
+	}
+	return entries, nil
+}
+
+// ListAudit returns the audit entries matching the query, ordered by time and ID
+func (s *MemoryStore) ListAudit(ctx context.Context, query AuditQuery) ([]audit.Entry, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var entries []audit.Entry
+	for _, entry := range s.audit {
+		if query.matches(entry) {
+			entries = append(entries, entry)
+		}
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Time.Equal(entries[j].Time) {
+			return entries[i].ID < entries[j].ID
+		}
+		return entries[i].Time.Before(entries[j].Time)
+	})
+	if query.Limit > 0 && len(entries) > query.Limit {
+		entries = entries[:query.Limit]
+	}
+	return entries, nil
+}
+
+// DeleteAudit removes the audit entries with the given IDs
+func (s *MemoryStore) DeleteAudit(ctx context.Context, ids []string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, id := range ids {
+		delete(s.audit, id)
+	}
+	return nil
+}
+
+// RegisterOperator marks an operator in the given region as active, creating it when it is new
+func (s *MemoryStore) RegisterOperator(ctx context.Context, id, region string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now().UTC()
+	operator, ok := s.operators[id]
+	if !ok {
+		operator = &modules.OperatorInfo{ID: id, CreatedAt: now}
+		s.operators[id] = operator
+	}
+	operator.Status = "active"
+	operator.Region = region
+	operator.LastSeen = now
+	operator.UpdatedAt = now
+	return nil
+}
+
+// UpdateOperatorHealth records a health check of an operator and the health of its modules
+func (s *MemoryStore) UpdateOperatorHealth(ctx context.Context, id string, at time.Time, health []modules.ModuleHealth) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	operator, ok := s.operators[id]
+	if !ok {
+		return ErrNotFound
+	}
+	operator.Status = "active"
+	operator.LastSeen = at
+	operator.Modules = append([]modules.ModuleHealth(nil), health...)
+	operator.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// MarkOperatorInactive marks an operator as inactive
+func (s *MemoryStore) MarkOperatorInactive(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	operator, ok := s.operators[id]
+	if !ok {
+		return ErrNotFound
+	}
+	operator.Status = "inactive"
+	operator.UpdatedAt = time.Now().UTC()
+	return nil
+}
+
+// GetOperator returns a registered operator
+func (s *MemoryStore) GetOperator(ctx context.Context, id string) (*modules.OperatorInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	operator, ok := s.operators[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *operator
+	copied.Modules = append([]modules.ModuleHealth(nil), operator.Modules...)
+	return &copied, nil
+}
+
+// ListOperators returns the operators matching the filter
+func (s *MemoryStore) ListOperators(ctx context.Context, filter OperatorFilter) ([]modules.OperatorInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	operators := make([]modules.OperatorInfo, 0, len(s.operators))
+	for _, operator := range s.operators {
+		if (filter.Status != "" && operator.Status != filter.Status) || (filter.Region != "" && operator.Region != filter.Region) {
+			continue
+		}
+		copied := *operator
+		copied.Modules = append([]modules.ModuleHealth(nil), operator.Modules...)
+		operators = append(operators, copied)
+	}
+	return paginate(operators, filter.Page, OperatorSortFields, "id", compareOperators), nil
+}
+
+// StaleOperators returns the active operators last seen before the given time
+func (s *MemoryStore) StaleOperators(ctx context.Context, before time.Time) ([]string, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var ids []string
+	for _, operator := range s.operators {
+		if operator.Status == "active" && operator.LastSeen.Before(before) {
+			ids = append(ids, operator.ID)
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// RegisterServer marks a module's server as active, creating it when it is new
+func (s *MemoryStore) RegisterServer(ctx context.Context, module string, server modules.ServerInfo) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.servers[module] == nil {
+		s.servers[module] = make(map[string]*modules.ServerInfo)
+	}
+	server.Status = "active"
+	server.Tags = append([]string(nil), server.Tags...)
+	s.servers[module][server.Name] = &server
+	return nil
+}
+
+// MarkServerInactive marks a module's server as inactive
+func (s *MemoryStore) MarkServerInactive(ctx context.Context, module, name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	server, ok := s.servers[module][name]
+	if !ok {
+		return ErrNotFound
+	}
+	server.Status = "inactive"
+	return nil
+}
+
+// ListServers returns the servers of a module matching the filter
+func (s *MemoryStore) ListServers(ctx context.Context, module string, filter ServerFilter) ([]modules.ServerInfo, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var servers []modules.ServerInfo
+	for _, server := range s.servers[module] {
+		if (filter.Status == "" || server.Status == filter.Status) && (filter.Region == "" || server.Region == filter.Region) {
+			copied := *server
+			copied.Tags = append([]string(nil), server.Tags...)
+			servers = append(servers, copied)
+		}
+	}
+	return paginate(servers, filter.Page, ServerSortFields, "name", compareServers), nil
+}
+
+// Close releases the store's resources
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// copyRequest returns a copy of the request that shares no mutable state with it
+func copyRequest(request *models.PrivilegeRequest) *models.PrivilegeRequest {
+	copied := *request
+	copied.Notify = append([]string(nil), request.Notify...)
+	copied.Approvers = append([]string(nil), request.Approvers...)
+	copied.ApprovalSteps = append([]models.ApprovalStep(nil), request.ApprovalSteps...)
+	copied.RiskFactors = append([]string(nil), request.RiskFactors...)
+	copied.Approvals = append([]models.ApprovalRecord(nil), request.Approvals...)
+	if request.StepUp != nil {
+		stepUp := *request.StepUp
+		copied.StepUp = &stepUp
+	}
+	if request.ApprovedAt != nil {
+		approvedAt := *request.ApprovedAt
+		copied.ApprovedAt = &approvedAt
+	}
+	return &copied
+}
+
+// CreateServiceAccount stores a new service account and assigns its ID
+func (s *MemoryStore) CreateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, existing := range s.accounts {
+		if existing.Name == account.Name {
+			return ErrExists
+		}
+	}
+	account.ID = newID("sa")
+	account.CreatedAt = time.Now().UTC()
+	copied := *account
+	copied.Scopes = append([]Scope(nil), account.Scopes...)
+	s.accounts[account.ID] = &copied
+	return nil
+}
+
+// GetServiceAccount returns a service account by ID
+func (s *MemoryStore) GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *account
+	copied.Scopes = append([]Scope(nil), account.Scopes...)
+	return &copied, nil
+}
+
+// ListServiceAccounts returns all service accounts ordered by name
+func (s *MemoryStore) ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	accounts := make([]ServiceAccount, 0, len(s.accounts))
+	for _, account := range s.accounts {
+		copied := *account
+		copied.Scopes = append([]Scope(nil), account.Scopes...)
+		accounts = append(accounts, copied)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Name < accounts[j].Name })
+	return accounts, nil
+}
+
+// SetServiceAccountScopes replaces the scopes of a service account
+func (s *MemoryStore) SetServiceAccountScopes(ctx context.Context, id string, scopes []Scope) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	account, ok := s.accounts[id]
+	if !ok {
+		return ErrNotFound
+	}
+	account.Scopes = append([]Scope(nil), scopes...)
+	return nil
+}
+
+// DeleteServiceAccount removes a service account and its tokens
+func (s *MemoryStore) DeleteServiceAccount(ctx context.Context, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[id]; !ok {
+		return ErrNotFound
+	}
+	delete(s.accounts, id)
+	for tokenID, token := range s.tokens {
+		if token.AccountID == id {
+			delete(s.tokens, tokenID)
+		}
+	}
+	return nil
+}
+
+// CreateServiceToken stores a new token and assigns its ID
+func (s *MemoryStore) CreateServiceToken(ctx context.Context, token *ServiceToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, ok := s.accounts[token.AccountID]; !ok {
+		return ErrNotFound
+	}
+	token.ID = newID("sat")
+	token.CreatedAt = time.Now().UTC()
+	copied := *token
+	s.tokens[token.ID] = &copied
+	return nil
+}
+
+// GetServiceToken returns a token by ID
+func (s *MemoryStore) GetServiceToken(ctx context.Context, id string) (*ServiceToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+// ListServiceTokens returns the tokens of a service account, oldest first
+func (s *MemoryStore) ListServiceTokens(ctx context.Context, accountID string) ([]ServiceToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var tokens []ServiceToken
+	for _, token := range s.tokens {
+		if token.AccountID == accountID {
+			tokens = append(tokens, *token)
+		}
+	}
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.Before(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID < tokens[j].ID
+	})
+	return tokens, nil
+}
+
+// RevokeServiceToken revokes an active token of a service account
+func (s *MemoryStore) RevokeServiceToken(ctx context.Context, accountID, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok || token.AccountID != accountID || token.RevokedAt != nil {
+		return ErrNotFound
+	}
+	revokedAt := at.UTC()
+	token.RevokedAt = &revokedAt
+	return nil
+}
+
+// TouchServiceToken records that a token was used
+func (s *MemoryStore) TouchServiceToken(ctx context.Context, id string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, ok := s.tokens[id]
+	if !ok {
+		return ErrNotFound
+	}
+	usedAt := at.UTC()
+	token.LastUsedAt = &usedAt
+	return nil
+}
+
+// CreateOperatorToken stores a new token and assigns its ID
+func (s *MemoryStore) CreateOperatorToken(ctx context.Context, token *OperatorToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token.ID = newID("opt")
+	token.CreatedAt = time.Now().UTC()
+	copied := *token
+	s.operatorTokens[token.ID] = &copied
+	return nil
+}
+
+// GetOperatorToken returns a token by ID
+func (s *MemoryStore) GetOperatorToken(ctx context.Context, id string) (*OperatorToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	token, ok := s.operatorTokens[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *token
+	return &copied, nil
+}
+
+// RevokeOperatorTokens revokes the operator's active tokens and returns how many
+func (s *MemoryStore) RevokeOperatorTokens(ctx context.Context, operatorID string, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	revokedAt := at.UTC()
+	for _, token := range s.operatorTokens {
+		if token.OperatorID == operatorID && token.Active(at) {
+			token.RevokedAt = &revokedAt
+			revoked++
+		}
+	}
+	return revoked, nil
+}
+
+// PruneOperatorTokens deletes the tokens that expired before the given time
+func (s *MemoryStore) PruneOperatorTokens(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for id, token := range s.operatorTokens {
+		if token.ExpiresAt.Before(before) {
+			delete(s.operatorTokens, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
+
+// ReplaceGroups replaces all synced groups with the given ones at once
+func (s *MemoryStore) ReplaceGroups(ctx context.Context, groups []*DirectoryGroup) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.groups = make(map[string]*DirectoryGroup, len(groups))
+	for _, group := range groups {
+		stored := *group
+		stored.Members = append([]string(nil), group.Members...)
+		s.groups[group.Name] = &stored
+	}
+	return nil
+}
+
+// ListGroups returns the synced groups ordered by name
+func (s *MemoryStore) ListGroups(ctx context.Context) ([]*DirectoryGroup, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	groups := make([]*DirectoryGroup, 0, len(s.groups))
+	for _, group := range s.groups {
+		listed := *group
+		listed.Members = append([]string(nil), group.Members...)
+		groups = append(groups, &listed)
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		return groups[i].Name < groups[j].Name
+	})
+	return groups, nil
+}
+
+// CreateWebAuthnCredential stores a new credential and assigns its ID
+func (s *MemoryStore) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential.ID = newID("wac")
+	credential.CreatedAt = time.Now().UTC()
+	copied := *credential
+	s.webauthn[credential.ID] = &copied
+	return nil
+}
+
+// ListWebAuthnCredentials returns the user's credentials ordered by creation
+func (s *MemoryStore) ListWebAuthnCredentials(ctx context.Context, userID string) ([]*WebAuthnCredential, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var credentials []*WebAuthnCredential
+	for _, credential := range s.webauthn {
+		if credential.UserID == userID {
+			copied := *credential
+			credentials = append(credentials, &copied)
+		}
+	}
+	sort.Slice(credentials, func(i, j int) bool {
+		return credentials[i].CreatedAt.Before(credentials[j].CreatedAt)
+	})
+	return credentials, nil
+}
+
+// UseWebAuthnCredential records a use of the credential with its signature counter
+func (s *MemoryStore) UseWebAuthnCredential(ctx context.Context, id string, signCount uint32, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webauthn[id]
+	if !ok {
+		return ErrNotFound
+	}
+	if (signCount != 0 || credential.SignCount != 0) && signCount <= credential.SignCount {
+		return ErrStaleSignCount
+	}
+	usedAt := at.UTC()
+	credential.SignCount = signCount
+	credential.LastUsedAt = &usedAt
+	return nil
+}
+
+// DeleteWebAuthnCredential deletes one of the user's credentials
+func (s *MemoryStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	credential, ok := s.webauthn[id]
+	if !ok || credential.UserID != userID {
+		return ErrNotFound
+	}
+	delete(s.webauthn, id)
+	return nil
+}
+
+// GetSession returns a session by ID
+func (s *MemoryStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, ok := s.sessions[id]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	copied := *session
+	return &copied, nil
+}
+
+// TouchSession stores a session the first time it is seen and updates when
+// it was last seen afterwards
+func (s *MemoryStore) TouchSession(ctx context.Context, session *Session) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if stored, ok := s.sessions[session.ID]; ok {
+		stored.LastSeenAt = session.LastSeenAt.UTC()
+		stored.UserAgent = session.UserAgent
+		stored.SourceIP = session.SourceIP
+		return nil
+	}
+	copied := *session
+	copied.Groups = append([]string(nil), session.Groups...)
+	copied.CreatedAt = session.CreatedAt.UTC()
+	copied.LastSeenAt = session.LastSeenAt.UTC()
+	copied.ExpiresAt = session.ExpiresAt.UTC()
+	s.sessions[session.ID] = &copied
+	return nil
+}
+
+// ListSessions returns the sessions matching the filter, most recently seen first
+func (s *MemoryStore) ListSessions(ctx context.Context, filter SessionFilter) ([]*Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	var sessions []*Session
+	for _, session := range s.sessions {
+		if filter.UserID != "" && session.UserID != filter.UserID {
+			continue
+		}
+		if !filter.ActiveAt.IsZero() && !session.Active(filter.ActiveAt) {
+			continue
+		}
+		copied := *session
+		sessions = append(sessions, &copied)
+	}
+	sort.Slice(sessions, func(i, j int) bool {
+		return sessions[i].LastSeenAt.After(sessions[j].LastSeenAt)
+	})
+	return sessions, nil
+}
+
+// RevokeSession revokes a session that isn't revoked yet
+func (s *MemoryStore) RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, ok := s.sessions[id]
+	if !ok || session.RevokedAt != nil {
+		return ErrNotFound
+	}
+	revokedAt := at.UTC()
+	session.RevokedAt = &revokedAt
+	session.RevokedBy = revokedBy
+	return nil
+}
+
+// RevokeUserSessions revokes the user's active sessions and returns how many
+func (s *MemoryStore) RevokeUserSessions(ctx context.Context, userID, revokedBy string, at time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	revoked := 0
+	revokedAt := at.UTC()
+	for _, session := range s.sessions {
+		if session.UserID == userID && session.Active(at) {
+			session.RevokedAt = &revokedAt
+			session.RevokedBy = revokedBy
+			revoked++
+		}
+	}
+	s.sessionsRevoked[userID] = revokedAt
+	return revoked, nil
+}
+
+// SessionsRevokedAt returns when the user's sessions were last revoked all at once
+func (s *MemoryStore) SessionsRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	return s.sessionsRevoked[userID], nil
+}
+
+// PruneSessions deletes the sessions that expired before the given time
+func (s *MemoryStore) PruneSessions(ctx context.Context, before time.Time) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for id, session := range s.sessions {
+		if session.ExpiresAt.Before(before) {
+			delete(s.sessions, id)
+			pruned++
+		}
+	}
+	return pruned, nil
+}
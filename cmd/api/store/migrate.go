@@ -0,0 +1,170 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"log"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// migrationLock names the lock held while migrations run, so that API servers
+// starting at the same time don't apply the same migration twice
+const migrationLock = "apollo_migrations"
+
+// migration is a versioned schema change. Migration files are named
+// NNNN_description.sql and may use {{timestamp}} and {{text}} for the column
+// types that differ between databases. Statements are separated by semicolons;
+// lines starting with -- are comments and may contain them.
+type migration struct {
+	version int
+	name    string
+	body    string
+}
+
+// loadMigrations reads the embedded migrations, ordered by version
+func loadMigrations() ([]migration, error) {
+	entries, err := migrationFiles.ReadDir("migrations")
+	if err != nil {
+		return nil, fmt.Errorf("failed to read migrations: %v", err)
+	}
+
+	var migrations []migration
+	seen := make(map[int]string)
+	for _, entry := range entries {
+		name := strings.TrimSuffix(entry.Name(), ".sql")
+		prefix, _, ok := strings.Cut(name, "_")
+		version, err := strconv.Atoi(prefix)
+		if !ok || err != nil || version <= 0 {
+			return nil, fmt.Errorf("migration %s: file name must start with a positive version number", entry.Name())
+		}
+		if other, ok := seen[version]; ok {
+			return nil, fmt.Errorf("migrations %s and %s have the same version", other, name)
+		}
+		seen[version] = name
+
+		body, err := migrationFiles.ReadFile(path.Join("migrations", entry.Name()))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read migration %s: %v", entry.Name(), err)
+		}
+		migrations = append(migrations, migration{version: version, name: name, body: string(body)})
+	}
+	sort.Slice(migrations, func(i, j int) bool {
+		return migrations[i].version < migrations[j].version
+	})
+	return migrations, nil
+}
+
+// statements returns the migration's statements for the dialect
+func (m migration) statements(d dialect) []string {
+	body := strings.NewReplacer("{{timestamp}}", d.timestamp, "{{text}}", d.text).Replace(m.body)
+
+	// Comments are dropped before splitting, as they may hold semicolons
+	var lines []string
+	for _, line := range strings.Split(body, "\n") {
+		if !strings.HasPrefix(strings.TrimSpace(line), "--") {
+			lines = append(lines, line)
+		}
+	}
+
+	var statements []string
+	for _, statement := range strings.Split(strings.Join(lines, "\n"), ";") {
+		if statement := strings.TrimSpace(statement); statement != "" {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// migrate applies the embedded migrations that have not been applied yet. It
+// holds a database-wide lock so that concurrently starting servers migrate
+// one after the other.
+func (s *SQLStore) migrate(ctx context.Context) error {
+	migrations, err := loadMigrations()
+	if err != nil {
+		return err
+	}
+
+	// Locks are held by a session, so everything runs on one connection
+	conn, err := s.db.Conn(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to get connection: %v", err)
+	}
+	defer conn.Close()
+
+	unlock, err := s.dialect.lock(ctx, conn, migrationLock)
+	if err != nil {
+		return fmt.Errorf("failed to acquire migration lock: %v", err)
+	}
+	defer unlock()
+
+	if _, err := conn.ExecContext(ctx, fmt.Sprintf(`
+		CREATE TABLE IF NOT EXISTS schema_migrations (
+			version INT PRIMARY KEY,
+			name VARCHAR(255) NOT NULL,
+			applied_at %s NOT NULL
+		)
+	`, s.dialect.timestamp)); err != nil {
+		return fmt.Errorf("failed to create schema_migrations table: %v", err)
+	}
+
+	applied := make(map[int]bool)
+	rows, err := conn.QueryContext(ctx, `SELECT version FROM schema_migrations`)
+	if err != nil {
+		return fmt.Errorf("failed to query applied migrations: %v", err)
+	}
+	for rows.Next() {
+		var version int
+		if err := rows.Scan(&version); err != nil {
+			rows.Close()
+			return fmt.Errorf("failed to scan migration version: %v", err)
+		}
+		applied[version] = true
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return fmt.Errorf("error iterating applied migrations: %v", err)
+	}
+
+	for _, m := range migrations {
+		if applied[m.version] {
+			continue
+		}
+		log.Printf("Applying migration %s", m.name)
+		if err := s.apply(ctx, conn, m); err != nil {
+			return fmt.Errorf("migration %s: %v", m.name, err)
+		}
+	}
+	return nil
+}
+
+// apply runs a single migration and records it. MySQL commits DDL statements
+// implicitly, so there a failed migration may be partially applied and has to
+// be repaired by hand; PostgreSQL and SQLite roll it back completely.
+func (s *SQLStore) apply(ctx context.Context, conn *sql.Conn, m migration) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, statement := range m.statements(s.dialect) {
+		if _, err := tx.ExecContext(ctx, statement); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, ?, ?)
+	`), m.version, m.name, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to record migration: %v", err)
+	}
+	return tx.Commit()
+}
@@ -0,0 +1,64 @@
+-- Jobs handed to operators
+CREATE TABLE IF NOT EXISTS jobs (
+	id VARCHAR(64) PRIMARY KEY,
+	module VARCHAR(64) NOT NULL,
+	type VARCHAR(64) NOT NULL,
+	request {{text}} NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	result {{text}} NOT NULL,
+	error {{text}} NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	updated_at {{timestamp}} NOT NULL
+);
+
+-- Privilege requests are stored as JSON documents next to the columns they are looked up by
+CREATE TABLE IF NOT EXISTS privilege_requests (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	resource_id VARCHAR(255) NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	data {{text}} NOT NULL,
+	approvals {{text}} NOT NULL,
+	expires_at {{timestamp}} NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	updated_at {{timestamp}} NOT NULL
+);
+
+CREATE INDEX idx_privilege_requests_user ON privilege_requests (user_id, created_at);
+CREATE INDEX idx_privilege_requests_created ON privilege_requests (created_at);
+CREATE INDEX idx_privilege_requests_status ON privilege_requests (user_id, status, expires_at);
+
+CREATE TABLE IF NOT EXISTS privilege_grants (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	resource_id VARCHAR(255) NOT NULL,
+	request_id VARCHAR(64) NOT NULL,
+	data {{text}} NOT NULL,
+	granted_at {{timestamp}} NOT NULL,
+	expires_at {{timestamp}} NOT NULL
+);
+
+CREATE INDEX idx_privilege_grants_user ON privilege_grants (user_id, expires_at);
+CREATE INDEX idx_privilege_grants_expires ON privilege_grants (expires_at);
+CREATE INDEX idx_privilege_grants_request ON privilege_grants (request_id);
+
+CREATE TABLE IF NOT EXISTS operators (
+	id VARCHAR(255) PRIMARY KEY,
+	status VARCHAR(50) NOT NULL,
+	last_seen {{timestamp}} NULL,
+	created_at {{timestamp}} NOT NULL,
+	updated_at {{timestamp}} NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS servers (
+	module VARCHAR(64) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	host VARCHAR(255) NOT NULL,
+	port INT NOT NULL,
+	user_name VARCHAR(255) NOT NULL,
+	db_name VARCHAR(255) NOT NULL,
+	status VARCHAR(50) NOT NULL,
+	tags VARCHAR(1024) NOT NULL,
+	last_seen {{timestamp}} NULL,
+	PRIMARY KEY (module, name)
+);
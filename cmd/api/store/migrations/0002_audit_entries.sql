@@ -0,0 +1,13 @@
+-- Audit records, kept until the retention policy archives or purges them
+CREATE TABLE IF NOT EXISTS audit_entries (
+	id VARCHAR(64) PRIMARY KEY,
+	recorded_at {{timestamp}} NOT NULL,
+	action VARCHAR(64) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	request_id VARCHAR(64) NOT NULL,
+	data {{text}} NOT NULL
+);
+
+CREATE INDEX idx_audit_entries_time ON audit_entries (recorded_at);
+
+CREATE INDEX idx_jobs_status ON jobs (status, updated_at);
@@ -0,0 +1,18 @@
+-- Grant lifecycle events; each privilege request has a stream ordered by version
+CREATE TABLE IF NOT EXISTS grant_events (
+	id VARCHAR(64) PRIMARY KEY,
+	request_id VARCHAR(64) NOT NULL,
+	version INT NOT NULL,
+	type VARCHAR(32) NOT NULL,
+	actor VARCHAR(255) NOT NULL,
+	recorded_at {{timestamp}} NOT NULL,
+	data {{text}} NOT NULL
+);
+
+-- The unique stream position makes concurrent appends to one stream fail instead of interleaving
+CREATE UNIQUE INDEX idx_grant_events_stream ON grant_events (request_id, version);
+CREATE INDEX idx_grant_events_time ON grant_events (recorded_at, id);
+
+-- Grants end when they are revoked or recorded as expired
+ALTER TABLE privilege_grants ADD COLUMN ended_at {{timestamp}} NULL;
+CREATE INDEX idx_privilege_grants_open ON privilege_grants (ended_at, expires_at);
@@ -0,0 +1,10 @@
+-- Sealed credentials of grants, deleted once retrieved or when the grant ends
+CREATE TABLE IF NOT EXISTS grant_credentials (
+	grant_id VARCHAR(64) PRIMARY KEY,
+	key_id VARCHAR(512) NOT NULL,
+	sealed {{text}} NOT NULL,
+	created_at {{timestamp}} NOT NULL
+);
+
+-- Rotation looks up the credentials wrapped with retired master keys
+CREATE INDEX idx_grant_credentials_key ON grant_credentials (key_id);
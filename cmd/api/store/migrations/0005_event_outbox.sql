@@ -0,0 +1,13 @@
+-- Grant lifecycle events waiting to be delivered to the event bus and webhooks.
+-- Rows are written with their event and deleted once delivered everywhere.
+CREATE TABLE IF NOT EXISTS event_outbox (
+	id VARCHAR(64) PRIMARY KEY,
+	event_id VARCHAR(64) NOT NULL,
+	attempts INT NOT NULL,
+	available_at {{timestamp}} NOT NULL,
+	last_error {{text}} NOT NULL,
+	delivered {{text}} NOT NULL,
+	created_at {{timestamp}} NOT NULL
+);
+
+CREATE INDEX idx_event_outbox_available ON event_outbox (available_at, id);
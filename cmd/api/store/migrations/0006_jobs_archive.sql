@@ -0,0 +1,16 @@
+-- Finished jobs are moved here so the jobs table only holds recent ones and
+-- the lookup of pending jobs stays fast however many jobs have run
+CREATE TABLE IF NOT EXISTS jobs_archive (
+	id VARCHAR(64) PRIMARY KEY,
+	module VARCHAR(64) NOT NULL,
+	type VARCHAR(64) NOT NULL,
+	request {{text}} NOT NULL,
+	status VARCHAR(32) NOT NULL,
+	result {{text}} NOT NULL,
+	error {{text}} NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	updated_at {{timestamp}} NOT NULL,
+	archived_at {{timestamp}} NOT NULL
+);
+
+CREATE INDEX idx_jobs_archive_updated ON jobs_archive (updated_at);
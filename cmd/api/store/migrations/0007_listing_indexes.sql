@@ -0,0 +1,5 @@
+-- Pending jobs are polled per module in creation order
+CREATE INDEX idx_jobs_pending ON jobs (status, module, created_at);
+
+-- Stale operators are looked up by status and the time they were last seen
+CREATE INDEX idx_operators_status ON operators (status, last_seen);
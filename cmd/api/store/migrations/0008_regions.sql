@@ -0,0 +1,4 @@
+-- Operators and servers record the region they run in, so API servers in
+-- several regions can share one database
+ALTER TABLE operators ADD COLUMN region VARCHAR(64) NOT NULL DEFAULT '';
+ALTER TABLE servers ADD COLUMN region VARCHAR(64) NOT NULL DEFAULT '';
@@ -0,0 +1,3 @@
+-- Credentials kept in an external secret store are referenced by name
+-- instead of being stored sealed in the database
+ALTER TABLE grant_credentials ADD COLUMN secret_ref VARCHAR(512) NOT NULL DEFAULT '';
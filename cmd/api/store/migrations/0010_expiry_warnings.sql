@@ -0,0 +1,3 @@
+-- Grants remember the expiry time their holder was last warned about, so the
+-- warning is sent once per expiry time and again after an extension
+ALTER TABLE privilege_grants ADD COLUMN warned_expires_at {{timestamp}} NULL;
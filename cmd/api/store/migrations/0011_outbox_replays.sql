@@ -0,0 +1,3 @@
+-- Replayed events are queued for the one publisher they are replayed to;
+-- entries written with their event go to every publisher
+ALTER TABLE event_outbox ADD COLUMN publisher VARCHAR(255) NOT NULL DEFAULT '';
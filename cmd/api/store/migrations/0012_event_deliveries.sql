@@ -0,0 +1,18 @@
+-- Every attempt to deliver an event to a publisher, kept for a while so
+-- missing notifications can be explained. Outbox entries that failed too
+-- often are parked as dead letters until an admin redelivers them.
+CREATE TABLE IF NOT EXISTS event_deliveries (
+	id VARCHAR(64) PRIMARY KEY,
+	event_id VARCHAR(64) NOT NULL,
+	event_type VARCHAR(64) NOT NULL,
+	publisher VARCHAR(255) NOT NULL,
+	attempt INT NOT NULL,
+	status VARCHAR(16) NOT NULL,
+	error {{text}} NOT NULL,
+	attempted_at {{timestamp}} NOT NULL
+);
+
+CREATE INDEX idx_event_deliveries_event ON event_deliveries (event_id, attempted_at);
+CREATE INDEX idx_event_deliveries_attempted ON event_deliveries (attempted_at, id);
+
+ALTER TABLE event_outbox ADD COLUMN dead_at {{timestamp}} NULL;
@@ -0,0 +1,25 @@
+-- Service accounts call the API with tokens it issued, limited to their scopes.
+-- Tokens keep only the hash of their secret.
+CREATE TABLE IF NOT EXISTS service_accounts (
+	id VARCHAR(64) PRIMARY KEY,
+	name VARCHAR(255) NOT NULL,
+	description {{text}} NOT NULL,
+	scopes {{text}} NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	created_at {{timestamp}} NOT NULL
+);
+
+CREATE UNIQUE INDEX idx_service_accounts_name ON service_accounts (name);
+
+CREATE TABLE IF NOT EXISTS service_tokens (
+	id VARCHAR(64) PRIMARY KEY,
+	account_id VARCHAR(64) NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	created_by VARCHAR(255) NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	expires_at {{timestamp}} NULL,
+	last_used_at {{timestamp}} NULL,
+	revoked_at {{timestamp}} NULL
+);
+
+CREATE INDEX idx_service_tokens_account ON service_tokens (account_id, created_at);
@@ -0,0 +1,13 @@
+-- Operators authenticate with short-lived tokens issued on registration and
+-- refreshed before they expire. Tokens keep only the hash of their secret.
+CREATE TABLE IF NOT EXISTS operator_tokens (
+	id VARCHAR(64) PRIMARY KEY,
+	operator_id VARCHAR(255) NOT NULL,
+	hash VARCHAR(64) NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	expires_at {{timestamp}} NOT NULL,
+	revoked_at {{timestamp}} NULL
+);
+
+CREATE INDEX idx_operator_tokens_operator ON operator_tokens (operator_id, expires_at);
+CREATE INDEX idx_operator_tokens_expires ON operator_tokens (expires_at);
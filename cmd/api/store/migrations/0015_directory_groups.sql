@@ -0,0 +1,7 @@
+-- Groups synced from the identity provider, which policies refer to by name.
+-- Members are kept as a JSON array of user IDs.
+CREATE TABLE IF NOT EXISTS directory_groups (
+	name VARCHAR(255) PRIMARY KEY,
+	members {{text}} NOT NULL,
+	synced_at {{timestamp}} NOT NULL
+);
@@ -0,0 +1,14 @@
+-- Hardware keys users registered to confirm approvals with. The credential
+-- ID and COSE public key are kept base64 encoded.
+CREATE TABLE IF NOT EXISTS webauthn_credentials (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	name VARCHAR(255) NOT NULL,
+	credential_id {{text}} NOT NULL,
+	public_key {{text}} NOT NULL,
+	sign_count BIGINT NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	last_used_at {{timestamp}} NULL
+);
+
+CREATE INDEX idx_webauthn_credentials_user ON webauthn_credentials (user_id, created_at);
@@ -0,0 +1,23 @@
+-- Sessions users authenticated the CLI and dashboard with, keyed by a hash
+-- of their token, and when each user's sessions were last revoked at once.
+CREATE TABLE IF NOT EXISTS sessions (
+	id VARCHAR(64) PRIMARY KEY,
+	user_id VARCHAR(255) NOT NULL,
+	issuer VARCHAR(255) NOT NULL,
+	subject VARCHAR(255) NOT NULL,
+	user_agent VARCHAR(512) NOT NULL,
+	source_ip VARCHAR(64) NOT NULL,
+	created_at {{timestamp}} NOT NULL,
+	last_seen_at {{timestamp}} NOT NULL,
+	expires_at {{timestamp}} NOT NULL,
+	revoked_at {{timestamp}} NULL,
+	revoked_by VARCHAR(255) NULL
+);
+
+CREATE INDEX idx_sessions_user ON sessions (user_id, last_seen_at);
+CREATE INDEX idx_sessions_expires ON sessions (expires_at);
+
+CREATE TABLE IF NOT EXISTS session_revocations (
+	user_id VARCHAR(255) PRIMARY KEY,
+	revoked_at {{timestamp}} NOT NULL
+);
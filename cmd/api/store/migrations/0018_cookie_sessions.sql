@@ -0,0 +1,4 @@
+-- Browser sessions the dashboard starts with a token are kept next to the
+-- tracked tokens, with the groups the user had when they started
+ALTER TABLE sessions ADD COLUMN kind VARCHAR(16) NOT NULL DEFAULT 'token';
+ALTER TABLE sessions ADD COLUMN user_groups {{text}} NULL;
@@ -0,0 +1,2 @@
+-- Operators report the health of their modules with each health check
+ALTER TABLE operators ADD COLUMN modules {{text}} NULL;
@@ -0,0 +1,6 @@
+-- Audit entries can be filtered by the user and resource they concern;
+-- entries recorded before only match filters on their actor
+ALTER TABLE audit_entries ADD COLUMN user_id VARCHAR(255) NOT NULL DEFAULT '';
+ALTER TABLE audit_entries ADD COLUMN resource_id VARCHAR(255) NOT NULL DEFAULT '';
+
+CREATE INDEX idx_audit_entries_action ON audit_entries (action, recorded_at);
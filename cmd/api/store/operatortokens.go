@@ -0,0 +1,36 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// OperatorToken is a short-lived token an operator authenticates with. It is
+// issued on registration and refreshed by the operator before it expires.
+// Only the hash of its secret is kept.
+type OperatorToken struct {
+	ID         string     `json:"id"`
+	OperatorID string     `json:"operator_id"`
+	Hash       []byte     `json:"-"`
+	CreatedAt  time.Time  `json:"created_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+}
+
+// Active reports whether the token can be used at the given time
+func (t *OperatorToken) Active(now time.Time) bool {
+	return t.RevokedAt == nil && now.Before(t.ExpiresAt)
+}
+
+// OperatorTokenRepository persists the tokens issued to operators
+type OperatorTokenRepository interface {
+	// CreateOperatorToken stores a new token and assigns its ID
+	CreateOperatorToken(ctx context.Context, token *OperatorToken) error
+	// GetOperatorToken returns a token by ID
+	GetOperatorToken(ctx context.Context, id string) (*OperatorToken, error)
+	// RevokeOperatorTokens revokes the operator's active tokens and returns how many
+	RevokeOperatorTokens(ctx context.Context, operatorID string, at time.Time) (int, error)
+	// PruneOperatorTokens deletes the tokens that expired before the given time
+	// and returns how many
+	PruneOperatorTokens(ctx context.Context, before time.Time) (int, error)
+}
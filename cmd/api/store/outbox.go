@@ -0,0 +1,81 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// OutboxEntry is an event waiting to be delivered to the event publishers.
+// Entries are written in the same transaction as their event, so an event is
+// delivered at least once even when the server stops right after the change.
+type OutboxEntry struct {
+	ID    string
+	Event Event
+	// Attempts counts the failed deliveries so far
+	Attempts  int
+	LastError string
+	// Delivered names the publishers that already accepted the event, so a
+	// retry only goes to the ones that failed
+	Delivered []string
+	// Publisher, set on replayed events, is the only publisher the entry is
+	// delivered to; entries without one go to every publisher
+	Publisher string
+	// DeadAt is when the entry became a dead letter after failing too often;
+	// dead letters are kept but never claimed
+	DeadAt *time.Time
+}
+
+// Delivery statuses
+const (
+	DeliveryDelivered = "delivered"
+	DeliveryFailed    = "failed"
+)
+
+// Delivery is one attempt to deliver an event to a publisher
+type Delivery struct {
+	ID        string    `json:"id"`
+	EventID   string    `json:"event_id"`
+	EventType string    `json:"event_type"`
+	Publisher string    `json:"publisher"`
+	Attempt   int       `json:"attempt"`
+	Status    string    `json:"status"`
+	Error     string    `json:"error,omitempty"`
+	Time      time.Time `json:"time"`
+}
+
+// DeliveryQuery selects delivery attempts; empty fields match every attempt
+type DeliveryQuery struct {
+	EventID   string
+	Publisher string
+	Status    string
+	Limit     int
+}
+
+// OutboxRepository hands outbox entries to the dispatcher
+type OutboxRepository interface {
+	// ClaimOutbox returns up to limit entries due by now, oldest first, and
+	// hides them from other claims until lease has passed
+	ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEntry, error)
+	// CompleteOutbox removes entries delivered to every publisher
+	CompleteOutbox(ctx context.Context, ids []string) error
+	// RetryOutbox records a failed delivery and when to try again
+	RetryOutbox(ctx context.Context, entry OutboxEntry, retryAt time.Time) error
+	// ReplayOutbox queues the events again, for the named publisher or every
+	// publisher when empty; ErrNotFound is returned when an event doesn't exist
+	ReplayOutbox(ctx context.Context, eventIDs []string, publisher string) error
+	// DeadLetterOutbox records the last failed delivery of an entry and parks it
+	DeadLetterOutbox(ctx context.Context, entry OutboxEntry, now time.Time) error
+	// ListDeadLetters returns up to limit dead letters, oldest first
+	ListDeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error)
+	// RedeliverOutbox queues dead letters for delivery again with their
+	// attempts reset; ErrNotFound is returned when an ID isn't a dead letter
+	RedeliverOutbox(ctx context.Context, ids []string, now time.Time) error
+
+	// RecordDeliveries stores delivery attempts
+	RecordDeliveries(ctx context.Context, deliveries []Delivery) error
+	// ListDeliveries returns the delivery attempts matching the query, newest first
+	ListDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error)
+	// PruneDeliveries removes the delivery attempts made before the time and
+	// returns how many were removed
+	PruneDeliveries(ctx context.Context, before time.Time) (int, error)
+}
@@ -0,0 +1,163 @@
+package store
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"strings"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+)
+
+// Page orders and bounds a listing. The zero Page lists everything in the
+// listing's default order.
+type Page struct {
+	// Sort names the field to order by, prefixed with - to order descending
+	Sort string
+	// Offset skips the first items of the listing
+	Offset int
+	// Limit is the most items listed; everything when 0
+	Limit int
+}
+
+// Fields each listing can be sorted by, its default order first
+var (
+	JobSortFields      = []string{"created_at", "updated_at", "id", "module", "type", "status"}
+	OperatorSortFields = []string{"id", "region", "status", "last_seen", "created_at", "updated_at"}
+	ServerSortFields   = []string{"name", "host", "region", "status"}
+)
+
+// OperatorFilter narrows down listed operators; empty fields match everything
+type OperatorFilter struct {
+	Status string
+	Region string
+	Page
+}
+
+// ServerFilter narrows down the listed servers of a module; empty fields match
+// everything
+type ServerFilter struct {
+	Status string
+	Region string
+	Page
+}
+
+// Validate checks the page against the fields its listing can be sorted by
+func (p Page) Validate(fields []string) error {
+	if p.Offset < 0 || p.Limit < 0 {
+		return fmt.Errorf("offset and limit must not be negative")
+	}
+	if p.Sort == "" {
+		return nil
+	}
+	field := strings.TrimPrefix(p.Sort, "-")
+	for _, f := range fields {
+		if f == field {
+			return nil
+		}
+	}
+	return fmt.Errorf("cannot sort by %q; use %s, prefixed with - to sort descending", field, strings.Join(fields, ", "))
+}
+
+// order returns the field to sort by, the listing's default when the page
+// names none or one it can't be sorted by, and whether to sort descending
+func (p Page) order(fields []string) (string, bool) {
+	if p.Validate(fields) != nil || p.Sort == "" {
+		return fields[0], false
+	}
+	return strings.TrimPrefix(p.Sort, "-"), strings.HasPrefix(p.Sort, "-")
+}
+
+// orderBy returns the ORDER BY and LIMIT clauses of the page and the
+// arguments of the latter. Items equal in the sorted field are ordered by
+// key, so pages don't overlap.
+func (p Page) orderBy(fields []string, key string) (string, []interface{}) {
+	field, desc := p.order(fields)
+	direction := ""
+	if desc {
+		direction = " DESC"
+	}
+	clause := " ORDER BY " + field + direction
+	if field != key {
+		clause += ", " + key + direction
+	}
+	switch {
+	case p.Limit > 0:
+		return clause + " LIMIT ? OFFSET ?", []interface{}{p.Limit, p.Offset}
+	case p.Offset > 0:
+		// Not every database takes an OFFSET without a LIMIT
+		return clause + " LIMIT ? OFFSET ?", []interface{}{math.MaxInt32, p.Offset}
+	}
+	return clause, nil
+}
+
+// paginate sorts items as the page asks, using compare to order two items by
+// a field, and returns the page's slice of them
+func paginate[T any](items []T, p Page, fields []string, key string, compare func(a, b T, field string) int) []T {
+	field, desc := p.order(fields)
+	sort.SliceStable(items, func(i, j int) bool {
+		c := compare(items[i], items[j], field)
+		if c == 0 {
+			c = compare(items[i], items[j], key)
+		}
+		if desc {
+			return c > 0
+		}
+		return c < 0
+	})
+	if p.Offset >= len(items) {
+		return items[:0]
+	}
+	items = items[p.Offset:]
+	if p.Limit > 0 && p.Limit < len(items) {
+		items = items[:p.Limit]
+	}
+	return items
+}
+
+// compareJobs orders two jobs by a field
+func compareJobs(a, b *Job, field string) int {
+	switch field {
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	case "module":
+		return strings.Compare(a.Module, b.Module)
+	case "type":
+		return strings.Compare(a.Type, b.Type)
+	case "status":
+		return strings.Compare(a.Status, b.Status)
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// compareOperators orders two operators by a field
+func compareOperators(a, b modules.OperatorInfo, field string) int {
+	switch field {
+	case "region":
+		return strings.Compare(a.Region, b.Region)
+	case "status":
+		return strings.Compare(a.Status, b.Status)
+	case "last_seen":
+		return a.LastSeen.Compare(b.LastSeen)
+	case "created_at":
+		return a.CreatedAt.Compare(b.CreatedAt)
+	case "updated_at":
+		return a.UpdatedAt.Compare(b.UpdatedAt)
+	}
+	return strings.Compare(a.ID, b.ID)
+}
+
+// compareServers orders two servers by a field
+func compareServers(a, b modules.ServerInfo, field string) int {
+	switch field {
+	case "host":
+		return strings.Compare(a.Host, b.Host)
+	case "region":
+		return strings.Compare(a.Region, b.Region)
+	case "status":
+		return strings.Compare(a.Status, b.Status)
+	}
+	return strings.Compare(a.Name, b.Name)
+}
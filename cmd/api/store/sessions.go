@@ -0,0 +1,70 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// Session kinds
+const (
+	// SessionToken is a bearer token, tracked from the first request it was seen on
+	SessionToken = "token"
+	// SessionCookie is a browser session the dashboard started with a token
+	SessionCookie = "cookie"
+)
+
+// Session is a bearer token or browser cookie a user authenticated the CLI
+// or dashboard with. Its ID is derived from the hash of the token or cookie;
+// neither is stored.
+type Session struct {
+	ID      string `json:"id"`
+	Kind    string `json:"kind"`
+	UserID  string `json:"user_id"`
+	Issuer  string `json:"issuer"`
+	Subject string `json:"subject"`
+	// Groups are the user's groups when the session started
+	Groups     []string   `json:"groups,omitempty"`
+	UserAgent  string     `json:"user_agent,omitempty"`
+	SourceIP   string     `json:"source_ip,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastSeenAt time.Time  `json:"last_seen_at"`
+	ExpiresAt  time.Time  `json:"expires_at"`
+	RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+	RevokedBy  string     `json:"revoked_by,omitempty"`
+}
+
+// Active reports whether the session can be used at the given time
+func (s *Session) Active(now time.Time) bool {
+	return s.RevokedAt == nil && now.Before(s.ExpiresAt)
+}
+
+// SessionFilter selects the sessions to list
+type SessionFilter struct {
+	// UserID limits the list to one user's sessions
+	UserID string
+	// ActiveAt limits the list to the sessions active at the time, unless zero
+	ActiveAt time.Time
+}
+
+// SessionRepository persists the sessions users authenticated with
+type SessionRepository interface {
+	// GetSession returns a session by ID
+	GetSession(ctx context.Context, id string) (*Session, error)
+	// TouchSession stores a session the first time it is seen, and afterwards
+	// updates when it was last seen and from where
+	TouchSession(ctx context.Context, session *Session) error
+	// ListSessions returns the sessions matching the filter, most recently seen first
+	ListSessions(ctx context.Context, filter SessionFilter) ([]*Session, error)
+	// RevokeSession revokes a session that isn't revoked yet
+	RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) error
+	// RevokeUserSessions revokes the user's active sessions and returns how
+	// many. Tokens of the user issued before the time are rejected from then
+	// on, including those no session was seen for yet.
+	RevokeUserSessions(ctx context.Context, userID, revokedBy string, at time.Time) (int, error)
+	// SessionsRevokedAt returns when the user's sessions were last revoked
+	// all at once, zero when they never were
+	SessionsRevokedAt(ctx context.Context, userID string) (time.Time, error)
+	// PruneSessions deletes the sessions that expired before the given time
+	// and returns how many
+	PruneSessions(ctx context.Context, before time.Time) (int, error)
+}
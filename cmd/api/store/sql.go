@@ -0,0 +1,2488 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SQLStore keeps the server's state in a MySQL, PostgreSQL or SQLite database.
+// Privilege requests and grants are stored as JSON documents next to the
+// columns they are looked up by.
+type SQLStore struct {
+	db *sql.DB
+	// reader serves reads outside transactions; it is a replica in the
+	// server's region when one is configured and db otherwise
+	reader  *sql.DB
+	dialect dialect
+	// writes and reads guard db and reader; they are the same breaker when
+	// reads aren't sent to a replica
+	writes *breaker
+	reads  *breaker
+}
+
+// OpenSQL connects to the configured database and migrates its schema.
+// Only the MySQL driver is linked into the API server by default; the
+// PostgreSQL and SQLite backends need a driver registered under DriverName
+// (by default "pgx" and "sqlite") through a blank import. Building the
+// server with the postgres or sqlite tag links in the pgx or a SQLite driver.
+func OpenSQL(ctx context.Context, config Config) (*SQLStore, error) {
+	d, ok := dialects[config.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unknown storage driver %q", config.Driver)
+	}
+	if config.DSN == "" && config.Driver == DriverSQLite {
+		config.DSN = DefaultSQLitePath
+	}
+	if config.DSN == "" {
+		return nil, fmt.Errorf("database dsn is required for the %s driver", config.Driver)
+	}
+
+	driverName := config.DriverName
+	if driverName == "" {
+		driverName = d.driver
+	}
+	if !driverRegistered(driverName) {
+		return nil, fmt.Errorf("database driver %q is not compiled into this binary", driverName)
+	}
+	if config.ReadDSN != "" && config.Driver == DriverSQLite {
+		return nil, fmt.Errorf("read replicas are not supported by the sqlite driver")
+	}
+
+	db, err := connect(ctx, config, driverName, config.DSN)
+	if err != nil {
+		return nil, err
+	}
+
+	s := &SQLStore{db: db, reader: db, dialect: d}
+	s.writes = newBreaker("primary", db, config.BreakerThreshold, config.ReconnectDelay)
+	s.reads = s.writes
+	if err := s.migrate(ctx); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to migrate database: %v", err)
+	}
+
+	if config.ReadDSN != "" {
+		reader, err := connect(ctx, config, driverName, config.ReadDSN)
+		if err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to connect to read replica: %v", err)
+		}
+		s.reader = reader
+		s.reads = newBreaker("replica", reader, config.BreakerThreshold, config.ReconnectDelay)
+		log.Printf("Reading from a %s replica, writing to the primary", config.Driver)
+	}
+
+	log.Printf("Using %s storage", config.Driver)
+	return s, nil
+}
+
+// connect opens and checks a connection pool to the given database
+func connect(ctx context.Context, config Config, driverName, dsn string) (*sql.DB, error) {
+	if config.Driver == DriverMySQL {
+		// Timestamps must be scanned into time.Time
+		cfg, err := mysql.ParseDSN(dsn)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mysql dsn: %v", err)
+		}
+		cfg.ParseTime = true
+		dsn = cfg.FormatDSN()
+	}
+	if config.Driver == DriverSQLite {
+		if err := prepareSQLiteFile(dsn); err != nil {
+			return nil, err
+		}
+	}
+
+	db, err := sql.Open(driverName, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open database connection: %v", err)
+	}
+	if config.MaxOpenConns > 0 {
+		db.SetMaxOpenConns(config.MaxOpenConns)
+		db.SetMaxIdleConns(config.MaxOpenConns)
+	}
+
+	if err := pingWithRetry(ctx, db, config.ConnectTimeout); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("failed to ping database: %v", err)
+	}
+	if config.Driver == DriverSQLite {
+		if err := configureSQLite(ctx, db); err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return db, nil
+}
+
+// Available reports whether the primary and the replica are reachable
+func (s *SQLStore) Available() bool {
+	return s.writes.available() && s.reads.available()
+}
+
+// exec runs a statement written with ? placeholders
+func (s *SQLStore) exec(ctx context.Context, query string, args ...interface{}) (sql.Result, error) {
+	if err := s.writes.allow(); err != nil {
+		return nil, err
+	}
+	result, err := s.db.ExecContext(ctx, s.dialect.rebind(query), args...)
+	s.writes.record(err)
+	return result, err
+}
+
+// query runs a query written with ? placeholders on the reader, so its
+// results may lag writes by the replication delay
+func (s *SQLStore) query(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error) {
+	if err := s.reads.allow(); err != nil {
+		return nil, err
+	}
+	rows, err := s.reader.QueryContext(ctx, s.dialect.rebind(query), args...)
+	s.reads.record(err)
+	return rows, err
+}
+
+// queryRow runs a single-row query written with ? placeholders on the reader
+func (s *SQLStore) queryRow(ctx context.Context, query string, args ...interface{}) scanner {
+	if err := s.reads.allow(); err != nil {
+		return errRow{err: err}
+	}
+	return &breakerRow{row: s.reader.QueryRowContext(ctx, s.dialect.rebind(query), args...), breaker: s.reads}
+}
+
+// begin starts a transaction on the primary
+func (s *SQLStore) begin(ctx context.Context) (*sql.Tx, error) {
+	if err := s.writes.allow(); err != nil {
+		return nil, err
+	}
+	tx, err := s.db.BeginTx(ctx, nil)
+	s.writes.record(err)
+	return tx, err
+}
+
+// execOne runs a statement that must affect a row, returning ErrNotFound otherwise
+func (s *SQLStore) execOne(ctx context.Context, query string, args ...interface{}) error {
+	result, err := s.exec(ctx, query, args...)
+	if err != nil {
+		return err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+// CreateJob stores a new job and assigns its ID
+func (s *SQLStore) CreateJob(ctx context.Context, job *Job) error {
+	now := time.Now().UTC()
+	job.ID = newID("job")
+	job.CreatedAt = now
+	job.UpdatedAt = now
+	return s.insertJob(ctx, s.db, job)
+}
+
+// insertJob inserts a job using the database or a transaction
+func (s *SQLStore) insertJob(ctx context.Context, db execer, job *Job) error {
+	if _, err := db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO jobs (id, module, type, request, status, result, error, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)
+	`), job.ID, job.Module, job.Type, string(job.Request), job.Status, job.Result, job.Error, job.CreatedAt, job.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to insert job: %v", err)
+	}
+	return nil
+}
+
+// GetJob returns the job with the given ID
+func (s *SQLStore) GetJob(ctx context.Context, id string) (*Job, error) {
+	job, err := scanJob(s.queryRow(ctx, `
+		SELECT id, module, type, request, status, result, error, created_at, updated_at
+		FROM jobs WHERE id = ?
+		UNION ALL
+		SELECT id, module, type, request, status, result, error, created_at, updated_at
+		FROM jobs_archive WHERE id = ?
+	`, id, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query job: %v", err)
+	}
+	return job, nil
+}
+
+// ListJobs returns the jobs matching the filter
+func (s *SQLStore) ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Module != "" {
+		conditions = append(conditions, "module = ?")
+		args = append(args, filter.Module)
+	}
+	order, pageArgs := filter.orderBy(JobSortFields, "id")
+	rows, err := s.query(ctx, `
+		SELECT id, module, type, request, status, result, error, created_at, updated_at FROM jobs
+	`+where(conditions)+order, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+// UpdateJob records a job's status and result
+func (s *SQLStore) UpdateJob(ctx context.Context, id, status, result, errMsg string) error {
+	err := s.execOne(ctx, `
+		UPDATE jobs SET status = ?, result = ?, error = ?, updated_at = ? WHERE id = ?
+	`, status, result, errMsg, time.Now().UTC(), id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update job: %v", err)
+	}
+	return err
+}
+
+// FinishedJobsBefore returns up to limit completed or failed jobs last updated before the given time
+func (s *SQLStore) FinishedJobsBefore(ctx context.Context, before time.Time, limit int) ([]*Job, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, module, type, request, status, result, error, created_at, updated_at
+		FROM jobs WHERE status IN (?, ?) AND updated_at < ?
+		UNION ALL
+		SELECT id, module, type, request, status, result, error, created_at, updated_at
+		FROM jobs_archive WHERE updated_at < ?
+		ORDER BY updated_at LIMIT ?
+	`, JobStatusCompleted, JobStatusFailed, before.UTC(), before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query jobs: %v", err)
+	}
+	defer rows.Close()
+
+	var jobs []*Job
+	for rows.Next() {
+		job, err := scanJob(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan job: %v", err)
+		}
+		jobs = append(jobs, job)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating jobs: %v", err)
+	}
+	return jobs, nil
+}
+
+// DeleteJobs removes the jobs with the given IDs
+func (s *SQLStore) DeleteJobs(ctx context.Context, ids []string) error {
+	for _, table := range []string{"jobs", "jobs_archive"} {
+		if err := s.deleteIDs(ctx, table, ids); err != nil {
+			return fmt.Errorf("failed to delete jobs: %v", err)
+		}
+	}
+	return nil
+}
+
+// ArchiveJobs moves up to limit finished jobs last updated before the given time to the archive
+func (s *SQLStore) ArchiveJobs(ctx context.Context, before time.Time, limit int) (int, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`
+		SELECT id FROM jobs WHERE status IN (?, ?) AND updated_at < ?
+		ORDER BY updated_at LIMIT ?`+s.dialect.forUpdate), JobStatusCompleted, JobStatusFailed, before.UTC(), limit)
+	if err != nil {
+		return 0, fmt.Errorf("failed to query jobs: %v", err)
+	}
+	var args []interface{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, fmt.Errorf("failed to scan job: %v", err)
+		}
+		args = append(args, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, fmt.Errorf("error iterating jobs: %v", err)
+	}
+	if len(args) == 0 {
+		return 0, nil
+	}
+
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(args)), ", ")
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO jobs_archive (id, module, type, request, status, result, error, created_at, updated_at, archived_at)
+		SELECT id, module, type, request, status, result, error, created_at, updated_at, ?
+		FROM jobs WHERE id IN (`+placeholders+`)`), append([]interface{}{time.Now().UTC()}, args...)...); err != nil {
+		return 0, fmt.Errorf("failed to archive jobs: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM jobs WHERE id IN (`+placeholders+`)`), args...); err != nil {
+		return 0, fmt.Errorf("failed to delete archived jobs: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return len(args), nil
+}
+
+// deleteIDs removes the rows of a table with the given IDs
+func (s *SQLStore) deleteIDs(ctx context.Context, table string, ids []string) error {
+	return s.deleteIn(ctx, table, "id", ids)
+}
+
+// deleteIn removes the rows of a table whose column holds one of the values
+func (s *SQLStore) deleteIn(ctx context.Context, table, column string, values []string) error {
+	if len(values) == 0 {
+		return nil
+	}
+	args := make([]interface{}, len(values))
+	for i, value := range values {
+		args[i] = value
+	}
+	placeholders := strings.TrimSuffix(strings.Repeat("?, ", len(values)), ", ")
+	_, err := s.exec(ctx, fmt.Sprintf("DELETE FROM %s WHERE %s IN (%s)", table, column, placeholders), args...)
+	return err
+}
+
+// where joins conditions into a WHERE clause, or returns nothing without conditions
+func where(conditions []string) string {
+	if len(conditions) == 0 {
+		return ""
+	}
+	return " WHERE " + strings.Join(conditions, " AND ")
+}
+
+// scanner is implemented by *sql.Row and *sql.Rows
+type scanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanJob reads a job from a row
+func scanJob(row scanner) (*Job, error) {
+	var job Job
+	var request string
+	if err := row.Scan(&job.ID, &job.Module, &job.Type, &request, &job.Status, &job.Result, &job.Error, &job.CreatedAt, &job.UpdatedAt); err != nil {
+		return nil, err
+	}
+	job.Request = json.RawMessage(request)
+	return &job, nil
+}
+
+// CreateRequest stores a new privilege request and assigns its ID
+func (s *SQLStore) CreateRequest(ctx context.Context, request *models.PrivilegeRequest) error {
+	now := time.Now().UTC()
+	request.ID = newID("req")
+	request.CreatedAt = now
+	request.UpdatedAt = now
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO privilege_requests (id, user_id, resource_id, status, data, approvals, expires_at, created_at, updated_at)
+		VALUES (?, ?, ?, ?, ?, '[]', ?, ?, ?)
+	`), request.ID, request.UserID, request.ResourceID, request.Status, string(data), request.ExpiresAt.UTC(), request.CreatedAt, request.UpdatedAt); err != nil {
+		return fmt.Errorf("failed to insert request: %v", err)
+	}
+	requested, err := newEvent(request.ID, EventRequested, request.UserID, request)
+	if err != nil {
+		return err
+	}
+	if err := s.appendEvents(ctx, tx, []*Event{requested}); err != nil {
+		return err
+	}
+	if request.Status == RequestStatusApproved {
+		if err := s.approve(ctx, tx, request); err != nil {
+			return err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// GetRequest returns the privilege request with the given ID
+func (s *SQLStore) GetRequest(ctx context.Context, id string) (*models.PrivilegeRequest, error) {
+	var data string
+	err := s.queryRow(ctx, `SELECT data FROM privilege_requests WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request: %v", err)
+	}
+	return decodeRequest(data)
+}
+
+// GetApprovals returns who approved a privilege request so far
+func (s *SQLStore) GetApprovals(ctx context.Context, id string) ([]string, error) {
+	var data string
+	err := s.queryRow(ctx, `SELECT approvals FROM privilege_requests WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query approvals: %v", err)
+	}
+	var approvals []string
+	if err := json.Unmarshal([]byte(data), &approvals); err != nil {
+		return nil, fmt.Errorf("failed to decode approvals: %v", err)
+	}
+	return approvals, nil
+}
+
+// ListRequests returns the matching privilege requests, newest first
+func (s *SQLStore) ListRequests(ctx context.Context, filter RequestFilter) ([]*models.PrivilegeRequest, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.UserID != "" {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, filter.UserID)
+	}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	return s.queryRequests(ctx, `SELECT data FROM privilege_requests`+where(conditions)+` ORDER BY created_at DESC`, args...)
+}
+
+// UpdateRequest applies fn to a privilege request inside a transaction that
+// holds a lock on its row
+func (s *SQLStore) UpdateRequest(ctx context.Context, id string, fn UpdateFunc) (*models.PrivilegeRequest, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var data, approvalsData string
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT data, approvals FROM privilege_requests WHERE id = ?`+s.dialect.forUpdate), id).Scan(&data, &approvalsData)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query request: %v", err)
+	}
+
+	request, err := decodeRequest(data)
+	if err != nil {
+		return nil, err
+	}
+	previousStatus := request.Status
+	var approvals []string
+	if err := json.Unmarshal([]byte(approvalsData), &approvals); err != nil {
+		return nil, fmt.Errorf("failed to decode approvals: %v", err)
+	}
+
+	approvals, err = fn(request, approvals)
+	if err != nil {
+		return nil, err
+	}
+	request.UpdatedAt = time.Now().UTC()
+
+	encoded, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	if approvals == nil {
+		approvals = []string{}
+	}
+	encodedApprovals, err := json.Marshal(approvals)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal approvals: %v", err)
+	}
+
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		UPDATE privilege_requests SET status = ?, data = ?, approvals = ?, updated_at = ? WHERE id = ?
+	`), request.Status, string(encoded), string(encodedApprovals), request.UpdatedAt, id); err != nil {
+		return nil, fmt.Errorf("failed to update request: %v", err)
+	}
+	switch {
+	case previousStatus != RequestStatusApproved && request.Status == RequestStatusApproved:
+		if err := s.approve(ctx, tx, request); err != nil {
+			return nil, err
+		}
+	case previousStatus != RequestStatusDenied && request.Status == RequestStatusDenied:
+		denied, err := newEvent(id, EventDenied, request.DeniedBy, nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.appendEvents(ctx, tx, []*Event{denied}); err != nil {
+			return nil, err
+		}
+	case previousStatus != RequestStatusExpired && request.Status == RequestStatusExpired:
+		lapsed, err := newEvent(id, EventLapsed, "", nil)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.appendEvents(ctx, tx, []*Event{lapsed}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return request, nil
+}
+
+// approve creates the grant of an approved request, extends the grant it
+// names or takes it over, and records the approval
+func (s *SQLStore) approve(ctx context.Context, tx *sql.Tx, request *models.PrivilegeRequest) error {
+	var grant, handedOver *models.PrivilegeGrant
+	switch {
+	case request.HandsOverGrant != "":
+		var err error
+		if handedOver, err = s.lockGrant(ctx, tx, request.HandsOverGrant); err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		if grant, err = handOverGrant(handedOver, request, now); err != nil {
+			return err
+		}
+		if err := s.updateGrant(ctx, tx, handedOver, &now); err != nil {
+			return err
+		}
+		if err := s.deleteCredential(ctx, tx, handedOver.ID); err != nil {
+			return err
+		}
+		job, err := revokeJob(handedOver, now)
+		if err != nil {
+			return err
+		}
+		if err := s.insertJob(ctx, tx, job); err != nil {
+			return err
+		}
+		if err := s.insertGrant(ctx, tx, grant); err != nil {
+			return err
+		}
+	case request.ExtendsGrant == "":
+		grant = grantFor(request)
+		if err := s.insertGrant(ctx, tx, grant); err != nil {
+			return err
+		}
+	default:
+		var err error
+		if grant, err = s.lockGrant(ctx, tx, request.ExtendsGrant); err != nil {
+			return err
+		}
+		if err := extendGrant(grant, request, time.Now().UTC()); err != nil {
+			return err
+		}
+		if err := s.updateGrant(ctx, tx, grant, nil); err != nil {
+			return err
+		}
+	}
+
+	events, err := approvalEvents(request, grant, handedOver)
+	if err != nil {
+		return err
+	}
+	return s.appendEvents(ctx, tx, events)
+}
+
+// RequestsSince returns the requests created after the given time, oldest first
+func (s *SQLStore) RequestsSince(ctx context.Context, since time.Time) ([]*models.PrivilegeRequest, error) {
+	return s.queryRequests(ctx, `
+		SELECT data FROM privilege_requests WHERE created_at > ? ORDER BY created_at
+	`, since.UTC())
+}
+
+// UserRequests returns the user's requests created after the given time
+func (s *SQLStore) UserRequests(ctx context.Context, userID string, since time.Time) ([]*models.PrivilegeRequest, error) {
+	return s.queryRequests(ctx, `
+		SELECT data FROM privilege_requests WHERE user_id = ? AND created_at > ?
+	`, userID, since.UTC())
+}
+
+// CountRequests returns the number of requests the user submitted since the given time
+func (s *SQLStore) CountRequests(ctx context.Context, userID string, since time.Time) (int, error) {
+	var count int
+	if err := s.queryRow(ctx, `
+		SELECT COUNT(*) FROM privilege_requests WHERE user_id = ? AND created_at >= ?
+	`, userID, since.UTC()).Scan(&count); err != nil {
+		return 0, fmt.Errorf("failed to count requests: %v", err)
+	}
+	return count, nil
+}
+
+// LastDenial returns when the user's most recent request was denied, or the zero time
+func (s *SQLStore) LastDenial(ctx context.Context, userID string) (time.Time, error) {
+	var last time.Time
+	err := s.queryRow(ctx, `
+		SELECT updated_at FROM privilege_requests
+		WHERE user_id = ? AND status = ?
+		ORDER BY updated_at DESC LIMIT 1
+	`, userID, RequestStatusDenied).Scan(&last)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query denials: %v", err)
+	}
+	return last, nil
+}
+
+// queryRequests runs a query selecting the data column of privilege requests
+func (s *SQLStore) queryRequests(ctx context.Context, query string, args ...interface{}) ([]*models.PrivilegeRequest, error) {
+	rows, err := s.query(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query requests: %v", err)
+	}
+	defer rows.Close()
+
+	var requests []*models.PrivilegeRequest
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan request: %v", err)
+		}
+		request, err := decodeRequest(data)
+		if err != nil {
+			return nil, err
+		}
+		requests = append(requests, request)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating requests: %v", err)
+	}
+	return requests, nil
+}
+
+// decodeRequest decodes a stored privilege request
+func decodeRequest(data string) (*models.PrivilegeRequest, error) {
+	var request models.PrivilegeRequest
+	if err := json.Unmarshal([]byte(data), &request); err != nil {
+		return nil, fmt.Errorf("failed to decode request: %v", err)
+	}
+	return &request, nil
+}
+
+// CreateGrant stores a new privilege grant and assigns its ID
+func (s *SQLStore) CreateGrant(ctx context.Context, grant *models.PrivilegeGrant) error {
+	now := time.Now().UTC()
+	grant.ID = newID("grant")
+	grant.CreatedAt = now
+	grant.UpdatedAt = now
+	return s.insertGrant(ctx, s.db, grant)
+}
+
+// execer is implemented by *sql.DB and *sql.Tx
+type execer interface {
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// insertGrant inserts a privilege grant using the database or a transaction
+func (s *SQLStore) insertGrant(ctx context.Context, db execer, grant *models.PrivilegeGrant) error {
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return fmt.Errorf("failed to marshal grant: %v", err)
+	}
+	if _, err := db.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO privilege_grants (id, user_id, resource_id, request_id, data, granted_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?, ?)
+	`), grant.ID, grant.UserID, grant.ResourceID, grant.RequestID, string(data), grant.GrantedAt.UTC(), grant.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("failed to insert grant: %v", err)
+	}
+	return nil
+}
+
+// GetGrant returns the privilege grant with the given ID
+func (s *SQLStore) GetGrant(ctx context.Context, id string) (*models.PrivilegeGrant, error) {
+	var data string
+	err := s.queryRow(ctx, `SELECT data FROM privilege_grants WHERE id = ?`, id).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grant: %v", err)
+	}
+	return decodeGrant(data)
+}
+
+// GetRequestGrant returns the grant created for the given privilege request
+func (s *SQLStore) GetRequestGrant(ctx context.Context, requestID string) (*models.PrivilegeGrant, error) {
+	var data string
+	err := s.queryRow(ctx, `SELECT data FROM privilege_grants WHERE request_id = ?`, requestID).Scan(&data)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grant: %v", err)
+	}
+	return decodeGrant(data)
+}
+
+// ListGrants returns the user's grants, or all grants when userID is empty
+func (s *SQLStore) ListGrants(ctx context.Context, userID string, activeAt time.Time) ([]*models.PrivilegeGrant, error) {
+	var conditions []string
+	var args []interface{}
+	if userID != "" {
+		conditions = append(conditions, "user_id = ?")
+		args = append(args, userID)
+	}
+	if !activeAt.IsZero() {
+		conditions = append(conditions, "expires_at > ?", "(ended_at IS NULL OR ended_at > ?)")
+		args = append(args, activeAt.UTC(), activeAt.UTC())
+	}
+
+	rows, err := s.query(ctx, `SELECT data FROM privilege_grants`+where(conditions)+` ORDER BY granted_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %v", err)
+	}
+	defer rows.Close()
+
+	var grants []*models.PrivilegeGrant
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %v", err)
+		}
+		grant, err := decodeGrant(data)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating grants: %v", err)
+	}
+	return grants, nil
+}
+
+// DeleteGrant removes a privilege grant
+func (s *SQLStore) DeleteGrant(ctx context.Context, id string) error {
+	if _, err := s.exec(ctx, `DELETE FROM grant_credentials WHERE grant_id = ?`, id); err != nil {
+		return fmt.Errorf("failed to delete grant credentials: %v", err)
+	}
+	err := s.execOne(ctx, `DELETE FROM privilege_grants WHERE id = ?`, id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to delete grant: %v", err)
+	}
+	return err
+}
+
+// ExpiredGrantsBefore returns up to limit grants that expired before the given time
+func (s *SQLStore) ExpiredGrantsBefore(ctx context.Context, before time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	rows, err := s.query(ctx, `
+		SELECT data FROM privilege_grants WHERE expires_at < ? ORDER BY expires_at LIMIT ?
+	`, before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %v", err)
+	}
+	defer rows.Close()
+
+	var grants []*models.PrivilegeGrant
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan grant: %v", err)
+		}
+		grant, err := decodeGrant(data)
+		if err != nil {
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating grants: %v", err)
+	}
+	return grants, nil
+}
+
+// DeleteGrants removes the grants with the given IDs
+func (s *SQLStore) DeleteGrants(ctx context.Context, ids []string) error {
+	if err := s.deleteIn(ctx, "grant_credentials", "grant_id", ids); err != nil {
+		return fmt.Errorf("failed to delete grant credentials: %v", err)
+	}
+	if err := s.deleteIDs(ctx, "privilege_grants", ids); err != nil {
+		return fmt.Errorf("failed to delete grants: %v", err)
+	}
+	return nil
+}
+
+// RevokeGrant ends a grant before it expires
+func (s *SQLStore) RevokeGrant(ctx context.Context, id, actor string) (*models.PrivilegeGrant, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	grant, err := s.lockGrant(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now().UTC()
+	if grant.RevokedAt != nil || !grant.ExpiresAt.After(now) {
+		return nil, ErrGrantEnded
+	}
+
+	grant.RevokedAt = &now
+	grant.RevokedBy = actor
+	grant.UpdatedAt = now
+	if err := s.updateGrant(ctx, tx, grant, &now); err != nil {
+		return nil, err
+	}
+	if err := s.deleteCredential(ctx, tx, id); err != nil {
+		return nil, err
+	}
+	job, err := revokeJob(grant, now)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.insertJob(ctx, tx, job); err != nil {
+		return nil, err
+	}
+	revoked, err := newEvent(grant.RequestID, EventRevoked, actor, GrantEventData{GrantID: id, ExpiresAt: grant.ExpiresAt})
+	if err != nil {
+		return nil, err
+	}
+	if err := s.appendEvents(ctx, tx, []*Event{revoked}); err != nil {
+		return nil, err
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return grant, nil
+}
+
+// ExpireGrants records up to limit grants that expired by the given time as expired
+func (s *SQLStore) ExpireGrants(ctx context.Context, now time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`
+		SELECT data FROM privilege_grants WHERE ended_at IS NULL AND expires_at <= ?
+		ORDER BY expires_at LIMIT ?`+s.dialect.forUpdate), now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %v", err)
+	}
+	var grants []*models.PrivilegeGrant
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan grant: %v", err)
+		}
+		grant, err := decodeGrant(data)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating grants: %v", err)
+	}
+
+	for _, grant := range grants {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			UPDATE privilege_grants SET ended_at = ? WHERE id = ?
+		`), grant.ExpiresAt.UTC(), grant.ID); err != nil {
+			return nil, fmt.Errorf("failed to update grant: %v", err)
+		}
+		if err := s.deleteCredential(ctx, tx, grant.ID); err != nil {
+			return nil, err
+		}
+		job, err := revokeJob(grant, time.Now().UTC())
+		if err != nil {
+			return nil, err
+		}
+		if err := s.insertJob(ctx, tx, job); err != nil {
+			return nil, err
+		}
+		expired, err := newEvent(grant.RequestID, EventExpired, "", GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.appendEvents(ctx, tx, []*Event{expired}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return grants, nil
+}
+
+// WarnExpiringGrants records an expiring event for the grants expiring by before
+func (s *SQLStore) WarnExpiringGrants(ctx context.Context, now, before time.Time, limit int) ([]*models.PrivilegeGrant, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`
+		SELECT data FROM privilege_grants
+		WHERE ended_at IS NULL AND expires_at > ? AND expires_at <= ?
+			AND (warned_expires_at IS NULL OR warned_expires_at <> expires_at)
+		ORDER BY expires_at LIMIT ?`+s.dialect.forUpdate), now.UTC(), before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grants: %v", err)
+	}
+	var grants []*models.PrivilegeGrant
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("failed to scan grant: %v", err)
+		}
+		grant, err := decodeGrant(data)
+		if err != nil {
+			rows.Close()
+			return nil, err
+		}
+		grants = append(grants, grant)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating grants: %v", err)
+	}
+
+	for _, grant := range grants {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			UPDATE privilege_grants SET warned_expires_at = expires_at WHERE id = ?
+		`), grant.ID); err != nil {
+			return nil, fmt.Errorf("failed to update grant: %v", err)
+		}
+		expiring, err := newEvent(grant.RequestID, EventExpiring, "", GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt})
+		if err != nil {
+			return nil, err
+		}
+		if err := s.appendEvents(ctx, tx, []*Event{expiring}); err != nil {
+			return nil, err
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return grants, nil
+}
+
+// lockGrant reads a grant inside a transaction that holds a lock on its row
+func (s *SQLStore) lockGrant(ctx context.Context, tx *sql.Tx, id string) (*models.PrivilegeGrant, error) {
+	var data string
+	var endedAt sql.NullTime
+	err := tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT data, ended_at FROM privilege_grants WHERE id = ?`+s.dialect.forUpdate), id).Scan(&data, &endedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grant: %v", err)
+	}
+	if endedAt.Valid {
+		return nil, ErrGrantEnded
+	}
+	return decodeGrant(data)
+}
+
+// updateGrant stores a changed grant; a non-nil endedAt ends it
+func (s *SQLStore) updateGrant(ctx context.Context, tx *sql.Tx, grant *models.PrivilegeGrant, endedAt *time.Time) error {
+	data, err := json.Marshal(grant)
+	if err != nil {
+		return fmt.Errorf("failed to marshal grant: %v", err)
+	}
+	var ended interface{}
+	if endedAt != nil {
+		ended = endedAt.UTC()
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		UPDATE privilege_grants SET data = ?, expires_at = ?, ended_at = ? WHERE id = ?
+	`), string(data), grant.ExpiresAt.UTC(), ended, grant.ID); err != nil {
+		return fmt.Errorf("failed to update grant: %v", err)
+	}
+	return nil
+}
+
+// decodeGrant decodes a stored privilege grant
+func decodeGrant(data string) (*models.PrivilegeGrant, error) {
+	var grant models.PrivilegeGrant
+	if err := json.Unmarshal([]byte(data), &grant); err != nil {
+		return nil, fmt.Errorf("failed to decode grant: %v", err)
+	}
+	return &grant, nil
+}
+
+// RegisterOperator marks an operator in the given region as active, creating it when it is new
+func (s *SQLStore) RegisterOperator(ctx context.Context, id, region string) error {
+	now := time.Now().UTC()
+	if _, err := s.exec(ctx, s.dialect.upsert("operators",
+		[]string{"id"},
+		[]string{"id", "status", "region", "last_seen", "created_at", "updated_at"},
+		[]string{"status", "region", "last_seen", "updated_at"},
+	), id, "active", region, now, now, now); err != nil {
+		return fmt.Errorf("failed to register operator: %v", err)
+	}
+	return nil
+}
+
+// UpdateOperatorHealth records a health check of an operator and the health of its modules
+func (s *SQLStore) UpdateOperatorHealth(ctx context.Context, id string, at time.Time, health []modules.ModuleHealth) error {
+	data, err := json.Marshal(health)
+	if err != nil {
+		return fmt.Errorf("failed to marshal module health: %v", err)
+	}
+	err = s.execOne(ctx, `
+		UPDATE operators SET status = 'active', last_seen = ?, modules = ?, updated_at = ? WHERE id = ?
+	`, at.UTC(), string(data), time.Now().UTC(), id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update operator health: %v", err)
+	}
+	return err
+}
+
+// MarkOperatorInactive marks an operator as inactive
+func (s *SQLStore) MarkOperatorInactive(ctx context.Context, id string) error {
+	if _, err := s.exec(ctx, `
+		UPDATE operators SET status = 'inactive', updated_at = ? WHERE id = ?
+	`, time.Now().UTC(), id); err != nil {
+		return fmt.Errorf("failed to mark operator inactive: %v", err)
+	}
+	return nil
+}
+
+// GetOperator returns a registered operator
+func (s *SQLStore) GetOperator(ctx context.Context, id string) (*modules.OperatorInfo, error) {
+	operator, err := scanOperator(s.queryRow(ctx, `
+		SELECT id, status, region, last_seen, modules, created_at, updated_at FROM operators WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operator: %v", err)
+	}
+	return operator, nil
+}
+
+// ListOperators returns the operators matching the filter
+func (s *SQLStore) ListOperators(ctx context.Context, filter OperatorFilter) ([]modules.OperatorInfo, error) {
+	var conditions []string
+	var args []interface{}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Region != "" {
+		conditions = append(conditions, "region = ?")
+		args = append(args, filter.Region)
+	}
+	order, pageArgs := filter.orderBy(OperatorSortFields, "id")
+	rows, err := s.query(ctx, `
+		SELECT id, status, region, last_seen, modules, created_at, updated_at FROM operators
+	`+where(conditions)+order, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operators: %v", err)
+	}
+	defer rows.Close()
+
+	operators := []modules.OperatorInfo{}
+	for rows.Next() {
+		op, err := scanOperator(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan operator: %v", err)
+		}
+		operators = append(operators, *op)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating operators: %v", err)
+	}
+	return operators, nil
+}
+
+// scanOperator reads an operator from a row
+func scanOperator(row scanner) (*modules.OperatorInfo, error) {
+	var op modules.OperatorInfo
+	var lastSeen sql.NullTime
+	var health sql.NullString
+	if err := row.Scan(&op.ID, &op.Status, &op.Region, &lastSeen, &health, &op.CreatedAt, &op.UpdatedAt); err != nil {
+		return nil, err
+	}
+	op.LastSeen = lastSeen.Time
+	if health.Valid && health.String != "" {
+		if err := json.Unmarshal([]byte(health.String), &op.Modules); err != nil {
+			return nil, fmt.Errorf("failed to decode health of operator %s: %v", op.ID, err)
+		}
+	}
+	return &op, nil
+}
+
+// StaleOperators returns the active operators last seen before the given time
+func (s *SQLStore) StaleOperators(ctx context.Context, before time.Time) ([]string, error) {
+	rows, err := s.query(ctx, `
+		SELECT id FROM operators WHERE status = 'active' AND last_seen < ? ORDER BY id
+	`, before.UTC())
+	if err != nil {
+		return nil, fmt.Errorf("failed to query inactive operators: %v", err)
+	}
+	defer rows.Close()
+
+	var ids []string
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, fmt.Errorf("failed to scan operator ID: %v", err)
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating inactive operators: %v", err)
+	}
+	return ids, nil
+}
+
+// RegisterServer marks a module's server as active, creating it when it is new
+func (s *SQLStore) RegisterServer(ctx context.Context, module string, server modules.ServerInfo) error {
+	if _, err := s.exec(ctx, s.dialect.upsert("servers",
+		[]string{"module", "name"},
+		[]string{"module", "name", "host", "port", "user_name", "db_name", "status", "tags", "region", "last_seen"},
+		[]string{"host", "port", "user_name", "db_name", "status", "tags", "region", "last_seen"},
+	), module, server.Name, server.Host, server.Port, server.User, server.Database, "active", strings.Join(server.Tags, ","), server.Region, time.Now().UTC()); err != nil {
+		return fmt.Errorf("failed to register server: %v", err)
+	}
+	return nil
+}
+
+// MarkServerInactive marks a module's server as inactive
+func (s *SQLStore) MarkServerInactive(ctx context.Context, module, name string) error {
+	if _, err := s.exec(ctx, `
+		UPDATE servers SET status = 'inactive' WHERE module = ? AND name = ?
+	`, module, name); err != nil {
+		return fmt.Errorf("failed to mark server inactive: %v", err)
+	}
+	return nil
+}
+
+// ListServers returns the servers of a module matching the filter
+func (s *SQLStore) ListServers(ctx context.Context, module string, filter ServerFilter) ([]modules.ServerInfo, error) {
+	conditions := []string{"module = ?"}
+	args := []interface{}{module}
+	if filter.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, filter.Status)
+	}
+	if filter.Region != "" {
+		conditions = append(conditions, "region = ?")
+		args = append(args, filter.Region)
+	}
+	order, pageArgs := filter.orderBy(ServerSortFields, "name")
+	rows, err := s.query(ctx, `
+		SELECT name, host, port, user_name, db_name, status, tags, region FROM servers
+	`+where(conditions)+order, append(args, pageArgs...)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query servers: %v", err)
+	}
+	defer rows.Close()
+
+	var servers []modules.ServerInfo
+	for rows.Next() {
+		var server modules.ServerInfo
+		var tags string
+		if err := rows.Scan(&server.Name, &server.Host, &server.Port, &server.User, &server.Database, &server.Status, &tags, &server.Region); err != nil {
+			return nil, fmt.Errorf("failed to scan server: %v", err)
+		}
+		if tags != "" {
+			server.Tags = strings.Split(tags, ",")
+		}
+		servers = append(servers, server)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating servers: %v", err)
+	}
+	return servers, nil
+}
+
+// PutCredential stores the credentials of an active grant and records it as provisioned
+func (s *SQLStore) PutCredential(ctx context.Context, credential *Credential, actor string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	grant, err := s.lockGrant(ctx, tx, credential.GrantID)
+	if err != nil {
+		return err
+	}
+	now := time.Now().UTC()
+	if grant.RevokedAt != nil || !grant.ExpiresAt.After(now) {
+		return ErrGrantEnded
+	}
+	if err := s.deleteCredential(ctx, tx, grant.ID); err != nil {
+		return err
+	}
+	credential.CreatedAt = now
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO grant_credentials (grant_id, key_id, sealed, secret_ref, created_at) VALUES (?, ?, ?, ?, ?)
+	`), credential.GrantID, credential.KeyID, string(credential.Sealed), credential.Ref, credential.CreatedAt); err != nil {
+		return fmt.Errorf("failed to insert grant credentials: %v", err)
+	}
+	provisioned, err := newEvent(grant.RequestID, EventProvisioned, actor, GrantEventData{GrantID: grant.ID, ExpiresAt: grant.ExpiresAt})
+	if err != nil {
+		return err
+	}
+	if err := s.appendEvents(ctx, tx, []*Event{provisioned}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// TakeCredential has open read the credentials of a grant and deletes them
+// once it succeeded. The row stays locked until then, so concurrent takes
+// wait and find the credentials gone.
+func (s *SQLStore) TakeCredential(ctx context.Context, grantID string, open func(*Credential) error) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	credential, err := scanCredential(tx.QueryRowContext(ctx, s.dialect.rebind(`
+		SELECT grant_id, key_id, sealed, secret_ref, created_at FROM grant_credentials WHERE grant_id = ?`+s.dialect.forUpdate), grantID))
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query grant credentials: %v", err)
+	}
+	if err := open(credential); err != nil {
+		return err
+	}
+	if err := s.deleteCredential(ctx, tx, grantID); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// CredentialsNotUnder returns up to limit credentials wrapped with another master key
+func (s *SQLStore) CredentialsNotUnder(ctx context.Context, keyID string, limit int) ([]*Credential, error) {
+	rows, err := s.query(ctx, `
+		SELECT grant_id, key_id, sealed, secret_ref, created_at FROM grant_credentials
+		WHERE key_id <> ? ORDER BY grant_id LIMIT ?
+	`, keyID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query grant credentials: %v", err)
+	}
+	defer rows.Close()
+
+	var credentials []*Credential
+	for rows.Next() {
+		credential, err := scanCredential(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan grant credentials: %v", err)
+		}
+		credentials = append(credentials, credential)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating grant credentials: %v", err)
+	}
+	return credentials, nil
+}
+
+// RewrapCredential replaces credentials still wrapped with the previous key
+func (s *SQLStore) RewrapCredential(ctx context.Context, credential *Credential, previousKeyID string) error {
+	err := s.execOne(ctx, `
+		UPDATE grant_credentials SET key_id = ?, sealed = ?, secret_ref = ? WHERE grant_id = ? AND key_id = ?
+	`, credential.KeyID, string(credential.Sealed), credential.Ref, credential.GrantID, previousKeyID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update grant credentials: %v", err)
+	}
+	return err
+}
+
+// deleteCredential removes the credentials of a grant inside a transaction
+func (s *SQLStore) deleteCredential(ctx context.Context, tx *sql.Tx, grantID string) error {
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM grant_credentials WHERE grant_id = ?`), grantID); err != nil {
+		return fmt.Errorf("failed to delete grant credentials: %v", err)
+	}
+	return nil
+}
+
+// scanCredential reads grant credentials from a row
+func scanCredential(row scanner) (*Credential, error) {
+	var credential Credential
+	var sealed string
+	if err := row.Scan(&credential.GrantID, &credential.KeyID, &sealed, &credential.Ref, &credential.CreatedAt); err != nil {
+		return nil, err
+	}
+	credential.Sealed = []byte(sealed)
+	return &credential, nil
+}
+
+// Record stores an audit entry and assigns its ID
+func (s *SQLStore) Record(ctx context.Context, entry audit.Entry) error {
+	entry.ID = newID("audit")
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	// Stored times have microsecond precision; truncating keeps the column and
+	// the document equal, which paging by time relies on
+	entry.Time = entry.Time.UTC().Truncate(time.Microsecond)
+
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return fmt.Errorf("failed to marshal audit entry: %v", err)
+	}
+	if _, err := s.exec(ctx, `
+		INSERT INTO audit_entries (id, recorded_at, action, actor, user_id, resource_id, request_id, data)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+	`, entry.ID, entry.Time.UTC(), entry.Action, entry.Actor, entry.UserID, entry.ResourceID, entry.RequestID, string(data)); err != nil {
+		return fmt.Errorf("failed to insert audit entry: %v", err)
+	}
+	return nil
+}
+
+// AuditBefore returns up to limit audit entries recorded before the given time, oldest first
+func (s *SQLStore) AuditBefore(ctx context.Context, before time.Time, limit int) ([]audit.Entry, error) {
+	rows, err := s.query(ctx, `
+		SELECT data FROM audit_entries WHERE recorded_at < ? ORDER BY recorded_at LIMIT ?
+	`, before.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %v", err)
+	}
+	return scanAudit(rows)
+}
+
+// ListAudit returns the audit entries matching the query, ordered by time and ID
+func (s *SQLStore) ListAudit(ctx context.Context, query AuditQuery) ([]audit.Entry, error) {
+	var conditions []string
+	var args []interface{}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "recorded_at >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "recorded_at < ?")
+		args = append(args, query.To.UTC())
+	}
+	if query.UserID != "" {
+		conditions = append(conditions, "(actor = ? OR user_id = ?)")
+		args = append(args, query.UserID, query.UserID)
+	}
+	if query.ResourceID != "" {
+		conditions = append(conditions, "resource_id = ?")
+		args = append(args, query.ResourceID)
+	}
+	if query.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, query.Action)
+	}
+	if query.AfterID != "" {
+		conditions = append(conditions, "(recorded_at > ? OR (recorded_at = ? AND id > ?))")
+		args = append(args, query.AfterTime.UTC(), query.AfterTime.UTC(), query.AfterID)
+	}
+	statement := `SELECT data FROM audit_entries` + where(conditions) + ` ORDER BY recorded_at, id`
+	if query.Limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.query(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query audit entries: %v", err)
+	}
+	return scanAudit(rows)
+}
+
+// scanAudit reads audit entries from the data column of the rows and closes them
+func scanAudit(rows *sql.Rows) ([]audit.Entry, error) {
+	defer rows.Close()
+
+	var entries []audit.Entry
+	for rows.Next() {
+		var data string
+		if err := rows.Scan(&data); err != nil {
+			return nil, fmt.Errorf("failed to scan audit entry: %v", err)
+		}
+		var entry audit.Entry
+		if err := json.Unmarshal([]byte(data), &entry); err != nil {
+			return nil, fmt.Errorf("failed to decode audit entry: %v", err)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating audit entries: %v", err)
+	}
+	return entries, nil
+}
+
+// DeleteAudit removes the audit entries with the given IDs
+func (s *SQLStore) DeleteAudit(ctx context.Context, ids []string) error {
+	if err := s.deleteIDs(ctx, "audit_entries", ids); err != nil {
+		return fmt.Errorf("failed to delete audit entries: %v", err)
+	}
+	return nil
+}
+
+// AppendEvent adds an event to the end of a request's stream
+func (s *SQLStore) AppendEvent(ctx context.Context, event *Event) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var id string
+	err = tx.QueryRowContext(ctx, s.dialect.rebind(`SELECT id FROM privilege_requests WHERE id = ?`+s.dialect.forUpdate), event.RequestID).Scan(&id)
+	if errors.Is(err, sql.ErrNoRows) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("failed to query request: %v", err)
+	}
+	if err := s.appendEvents(ctx, tx, []*Event{event}); err != nil {
+		return err
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// appendEvents assigns the events their IDs and versions and inserts them
+func (s *SQLStore) appendEvents(ctx context.Context, tx *sql.Tx, events []*Event) error {
+	for _, event := range events {
+		var version int
+		if err := tx.QueryRowContext(ctx, s.dialect.rebind(`
+			SELECT COALESCE(MAX(version), 0) FROM grant_events WHERE request_id = ?
+		`), event.RequestID).Scan(&version); err != nil {
+			return fmt.Errorf("failed to query event stream: %v", err)
+		}
+		event.ID = newID("evt")
+		event.Version = version + 1
+		event.Time = time.Now().UTC().Truncate(time.Microsecond)
+
+		data := string(event.Data)
+		if data == "" {
+			data = "null"
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO grant_events (id, request_id, version, type, actor, recorded_at, data)
+			VALUES (?, ?, ?, ?, ?, ?, ?)
+		`), event.ID, event.RequestID, event.Version, event.Type, event.Actor, event.Time, data); err != nil {
+			return fmt.Errorf("failed to insert %s event: %v", event.Type, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO event_outbox (id, event_id, attempts, available_at, last_error, delivered, created_at)
+			VALUES (?, ?, 0, ?, '', '[]', ?)
+		`), newID("outbox"), event.ID, event.Time, event.Time); err != nil {
+			return fmt.Errorf("failed to insert %s event into outbox: %v", event.Type, err)
+		}
+	}
+	return nil
+}
+
+// StreamEvents returns the events of a request, oldest first
+func (s *SQLStore) StreamEvents(ctx context.Context, requestID string) ([]Event, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, request_id, version, type, actor, recorded_at, data FROM grant_events
+		WHERE request_id = ? ORDER BY version
+	`, requestID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %v", err)
+	}
+	return scanEvents(rows)
+}
+
+// ListEvents returns the events matching the query, ordered by time and ID
+func (s *SQLStore) ListEvents(ctx context.Context, query EventQuery) ([]Event, error) {
+	var conditions []string
+	var args []interface{}
+	if query.AfterID != "" {
+		conditions = append(conditions, "(recorded_at > ? OR (recorded_at = ? AND id > ?))")
+		args = append(args, query.AfterTime.UTC(), query.AfterTime.UTC(), query.AfterID)
+	}
+	if !query.From.IsZero() {
+		conditions = append(conditions, "recorded_at >= ?")
+		args = append(args, query.From.UTC())
+	}
+	if !query.To.IsZero() {
+		conditions = append(conditions, "recorded_at < ?")
+		args = append(args, query.To.UTC())
+	}
+	if len(query.Types) > 0 {
+		conditions = append(conditions, "type IN ("+strings.TrimSuffix(strings.Repeat("?, ", len(query.Types)), ", ")+")")
+		for _, eventType := range query.Types {
+			args = append(args, eventType)
+		}
+	}
+	statement := `SELECT id, request_id, version, type, actor, recorded_at, data FROM grant_events` + where(conditions) + ` ORDER BY recorded_at, id`
+	if query.Limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.query(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query events: %v", err)
+	}
+	return scanEvents(rows)
+}
+
+// ClaimOutbox returns up to limit entries due by now and hides them until lease has passed
+func (s *SQLStore) ClaimOutbox(ctx context.Context, now time.Time, lease time.Duration, limit int) ([]OutboxEntry, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.QueryContext(ctx, s.dialect.rebind(`
+		SELECT `+outboxColumns+`
+		FROM event_outbox o JOIN grant_events e ON e.id = o.event_id
+		WHERE o.available_at <= ? AND o.dead_at IS NULL ORDER BY o.available_at, o.id LIMIT ?`+s.dialect.forUpdate), now.UTC(), limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query outbox: %v", err)
+	}
+	entries, err := scanOutboxEntries(rows)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, entry := range entries {
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			UPDATE event_outbox SET available_at = ? WHERE id = ?
+		`), now.Add(lease).UTC(), entry.ID); err != nil {
+			return nil, fmt.Errorf("failed to claim outbox entry: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return entries, nil
+}
+
+// CompleteOutbox removes entries delivered to every publisher
+func (s *SQLStore) CompleteOutbox(ctx context.Context, ids []string) error {
+	if err := s.deleteIDs(ctx, "event_outbox", ids); err != nil {
+		return fmt.Errorf("failed to delete outbox entries: %v", err)
+	}
+	return nil
+}
+
+// RetryOutbox records a failed delivery and when to try again
+func (s *SQLStore) RetryOutbox(ctx context.Context, entry OutboxEntry, retryAt time.Time) error {
+	delivered, err := json.Marshal(entry.Delivered)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivered publishers: %v", err)
+	}
+	if entry.Delivered == nil {
+		delivered = []byte("[]")
+	}
+	err = s.execOne(ctx, `
+		UPDATE event_outbox SET attempts = ?, last_error = ?, delivered = ?, available_at = ? WHERE id = ?
+	`, entry.Attempts, entry.LastError, string(delivered), retryAt.UTC(), entry.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update outbox entry: %v", err)
+	}
+	return err
+}
+
+// ReplayOutbox queues the events again for the publisher, every publisher when empty
+func (s *SQLStore) ReplayOutbox(ctx context.Context, eventIDs []string, publisher string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	now := time.Now().UTC()
+	for _, id := range eventIDs {
+		var count int
+		if err := tx.QueryRowContext(ctx, s.dialect.rebind(`
+			SELECT COUNT(*) FROM grant_events WHERE id = ?
+		`), id).Scan(&count); err != nil {
+			return fmt.Errorf("failed to query event %s: %v", id, err)
+		}
+		if count == 0 {
+			return ErrNotFound
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO event_outbox (id, event_id, attempts, available_at, last_error, delivered, created_at, publisher)
+			VALUES (?, ?, 0, ?, '', '[]', ?, ?)
+		`), newID("outbox"), id, now, now, publisher); err != nil {
+			return fmt.Errorf("failed to queue event %s: %v", id, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// DeadLetterOutbox records the last failed delivery of an entry and parks it
+func (s *SQLStore) DeadLetterOutbox(ctx context.Context, entry OutboxEntry, now time.Time) error {
+	delivered, err := json.Marshal(entry.Delivered)
+	if err != nil {
+		return fmt.Errorf("failed to marshal delivered publishers: %v", err)
+	}
+	if entry.Delivered == nil {
+		delivered = []byte("[]")
+	}
+	err = s.execOne(ctx, `
+		UPDATE event_outbox SET attempts = ?, last_error = ?, delivered = ?, available_at = ?, dead_at = ? WHERE id = ?
+	`, entry.Attempts, entry.LastError, string(delivered), now.UTC(), now.UTC(), entry.ID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update outbox entry: %v", err)
+	}
+	return err
+}
+
+// ListDeadLetters returns up to limit dead letters, oldest first
+func (s *SQLStore) ListDeadLetters(ctx context.Context, limit int) ([]OutboxEntry, error) {
+	statement := `
+		SELECT ` + outboxColumns + `
+		FROM event_outbox o JOIN grant_events e ON e.id = o.event_id
+		WHERE o.dead_at IS NOT NULL ORDER BY o.dead_at, o.id`
+	var args []interface{}
+	if limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, limit)
+	}
+	rows, err := s.query(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query dead letters: %v", err)
+	}
+	return scanOutboxEntries(rows)
+}
+
+// RedeliverOutbox queues dead letters for delivery again with their attempts reset
+func (s *SQLStore) RedeliverOutbox(ctx context.Context, ids []string, now time.Time) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, id := range ids {
+		result, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			UPDATE event_outbox SET attempts = 0, available_at = ?, dead_at = NULL WHERE id = ? AND dead_at IS NOT NULL
+		`), now.UTC(), id)
+		if err != nil {
+			return fmt.Errorf("failed to queue outbox entry %s: %v", id, err)
+		}
+		if affected, err := result.RowsAffected(); err != nil {
+			return fmt.Errorf("failed to queue outbox entry %s: %v", id, err)
+		} else if affected == 0 {
+			return ErrNotFound
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// RecordDeliveries stores delivery attempts
+func (s *SQLStore) RecordDeliveries(ctx context.Context, deliveries []Delivery) error {
+	if len(deliveries) == 0 {
+		return nil
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	for _, delivery := range deliveries {
+		if delivery.ID == "" {
+			delivery.ID = newID("dlv")
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO event_deliveries (id, event_id, event_type, publisher, attempt, status, error, attempted_at)
+			VALUES (?, ?, ?, ?, ?, ?, ?, ?)
+		`), delivery.ID, delivery.EventID, delivery.EventType, delivery.Publisher, delivery.Attempt,
+			delivery.Status, delivery.Error, delivery.Time.UTC()); err != nil {
+			return fmt.Errorf("failed to insert delivery: %v", err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// ListDeliveries returns the delivery attempts matching the query, newest first
+func (s *SQLStore) ListDeliveries(ctx context.Context, query DeliveryQuery) ([]Delivery, error) {
+	var conditions []string
+	var args []interface{}
+	if query.EventID != "" {
+		conditions = append(conditions, "event_id = ?")
+		args = append(args, query.EventID)
+	}
+	if query.Publisher != "" {
+		conditions = append(conditions, "publisher = ?")
+		args = append(args, query.Publisher)
+	}
+	if query.Status != "" {
+		conditions = append(conditions, "status = ?")
+		args = append(args, query.Status)
+	}
+	statement := `SELECT id, event_id, event_type, publisher, attempt, status, error, attempted_at FROM event_deliveries` +
+		where(conditions) + ` ORDER BY attempted_at DESC, id DESC`
+	if query.Limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.query(ctx, statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query deliveries: %v", err)
+	}
+	defer rows.Close()
+
+	var deliveries []Delivery
+	for rows.Next() {
+		var delivery Delivery
+		if err := rows.Scan(&delivery.ID, &delivery.EventID, &delivery.EventType, &delivery.Publisher, &delivery.Attempt,
+			&delivery.Status, &delivery.Error, &delivery.Time); err != nil {
+			return nil, fmt.Errorf("failed to scan delivery: %v", err)
+		}
+		delivery.Time = delivery.Time.UTC()
+		deliveries = append(deliveries, delivery)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating deliveries: %v", err)
+	}
+	return deliveries, nil
+}
+
+// PruneDeliveries removes the delivery attempts made before the time
+func (s *SQLStore) PruneDeliveries(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.exec(ctx, `DELETE FROM event_deliveries WHERE attempted_at < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete deliveries: %v", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted deliveries: %v", err)
+	}
+	return int(pruned), nil
+}
+
+// outboxColumns are the columns scanOutboxEntries reads, from event_outbox o
+// joined with grant_events e
+const outboxColumns = `o.id, o.attempts, o.last_error, o.delivered, o.publisher, o.dead_at,
+			e.id, e.request_id, e.version, e.type, e.actor, e.recorded_at, e.data`
+
+// scanOutboxEntries reads outbox entries with their events from the rows and closes them
+func scanOutboxEntries(rows *sql.Rows) ([]OutboxEntry, error) {
+	defer rows.Close()
+
+	var entries []OutboxEntry
+	for rows.Next() {
+		var entry OutboxEntry
+		var delivered, data string
+		var deadAt sql.NullTime
+		event := &entry.Event
+		if err := rows.Scan(&entry.ID, &entry.Attempts, &entry.LastError, &delivered, &entry.Publisher, &deadAt,
+			&event.ID, &event.RequestID, &event.Version, &event.Type, &event.Actor, &event.Time, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan outbox entry: %v", err)
+		}
+		if err := json.Unmarshal([]byte(delivered), &entry.Delivered); err != nil {
+			return nil, fmt.Errorf("failed to decode outbox entry %s: %v", entry.ID, err)
+		}
+		if deadAt.Valid {
+			t := deadAt.Time.UTC()
+			entry.DeadAt = &t
+		}
+		event.Time = event.Time.UTC()
+		if data != "null" {
+			event.Data = json.RawMessage(data)
+		}
+		entries = append(entries, entry)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating outbox: %v", err)
+	}
+	return entries, nil
+}
+
+// scanEvents reads events from the rows and closes them
+func scanEvents(rows *sql.Rows) ([]Event, error) {
+	defer rows.Close()
+
+	var events []Event
+	for rows.Next() {
+		var event Event
+		var data string
+		if err := rows.Scan(&event.ID, &event.RequestID, &event.Version, &event.Type, &event.Actor, &event.Time, &data); err != nil {
+			return nil, fmt.Errorf("failed to scan event: %v", err)
+		}
+		event.Time = event.Time.UTC()
+		if data != "null" {
+			event.Data = json.RawMessage(data)
+		}
+		events = append(events, event)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating events: %v", err)
+	}
+	return events, nil
+}
+
+// CreateServiceAccount stores a new service account and assigns its ID
+func (s *SQLStore) CreateServiceAccount(ctx context.Context, account *ServiceAccount) error {
+	scopes, err := json.Marshal(account.Scopes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scopes: %v", err)
+	}
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind(`
+		SELECT COUNT(*) FROM service_accounts WHERE name = ?
+	`), account.Name).Scan(&count); err != nil {
+		return fmt.Errorf("failed to query service accounts: %v", err)
+	}
+	if count > 0 {
+		return ErrExists
+	}
+	id, now := newID("sa"), time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO service_accounts (id, name, description, scopes, created_by, created_at) VALUES (?, ?, ?, ?, ?, ?)
+	`), id, account.Name, account.Description, string(scopes), account.CreatedBy, now); err != nil {
+		return fmt.Errorf("failed to insert service account: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	account.ID = id
+	account.CreatedAt = now
+	return nil
+}
+
+// GetServiceAccount returns a service account by ID
+func (s *SQLStore) GetServiceAccount(ctx context.Context, id string) (*ServiceAccount, error) {
+	account, err := scanServiceAccount(s.queryRow(ctx, `
+		SELECT id, name, description, scopes, created_by, created_at FROM service_accounts WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service account: %v", err)
+	}
+	return account, nil
+}
+
+// ListServiceAccounts returns all service accounts ordered by name
+func (s *SQLStore) ListServiceAccounts(ctx context.Context) ([]ServiceAccount, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, name, description, scopes, created_by, created_at FROM service_accounts ORDER BY name
+	`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service accounts: %v", err)
+	}
+	defer rows.Close()
+
+	var accounts []ServiceAccount
+	for rows.Next() {
+		account, err := scanServiceAccount(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service account: %v", err)
+		}
+		accounts = append(accounts, *account)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating service accounts: %v", err)
+	}
+	return accounts, nil
+}
+
+// SetServiceAccountScopes replaces the scopes of a service account
+func (s *SQLStore) SetServiceAccountScopes(ctx context.Context, id string, scopes []Scope) error {
+	data, err := json.Marshal(scopes)
+	if err != nil {
+		return fmt.Errorf("failed to marshal scopes: %v", err)
+	}
+	err = s.execOne(ctx, `UPDATE service_accounts SET scopes = ? WHERE id = ?`, string(data), id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update service account: %v", err)
+	}
+	return err
+}
+
+// DeleteServiceAccount removes a service account and its tokens
+func (s *SQLStore) DeleteServiceAccount(ctx context.Context, id string) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM service_tokens WHERE account_id = ?`), id); err != nil {
+		return fmt.Errorf("failed to delete service tokens: %v", err)
+	}
+	result, err := tx.ExecContext(ctx, s.dialect.rebind(`DELETE FROM service_accounts WHERE id = ?`), id)
+	if err != nil {
+		return fmt.Errorf("failed to delete service account: %v", err)
+	}
+	if affected, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to delete service account: %v", err)
+	} else if affected == 0 {
+		return ErrNotFound
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return nil
+}
+
+// CreateServiceToken stores a new token and assigns its ID
+func (s *SQLStore) CreateServiceToken(ctx context.Context, token *ServiceToken) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	var count int
+	if err := tx.QueryRowContext(ctx, s.dialect.rebind(`
+		SELECT COUNT(*) FROM service_accounts WHERE id = ?`+s.dialect.forUpdate), token.AccountID).Scan(&count); err != nil {
+		return fmt.Errorf("failed to query service account: %v", err)
+	}
+	if count == 0 {
+		return ErrNotFound
+	}
+	id, now := newID("sat"), time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		INSERT INTO service_tokens (id, account_id, hash, created_by, created_at, expires_at) VALUES (?, ?, ?, ?, ?, ?)
+	`), id, token.AccountID, hex.EncodeToString(token.Hash), token.CreatedBy, now, nullTime(token.ExpiresAt)); err != nil {
+		return fmt.Errorf("failed to insert service token: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	token.ID = id
+	token.CreatedAt = now
+	return nil
+}
+
+// GetServiceToken returns a token by ID
+func (s *SQLStore) GetServiceToken(ctx context.Context, id string) (*ServiceToken, error) {
+	token, err := scanServiceToken(s.queryRow(ctx, `
+		SELECT id, account_id, hash, created_by, created_at, expires_at, last_used_at, revoked_at
+		FROM service_tokens WHERE id = ?
+	`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service token: %v", err)
+	}
+	return token, nil
+}
+
+// ListServiceTokens returns the tokens of a service account, oldest first
+func (s *SQLStore) ListServiceTokens(ctx context.Context, accountID string) ([]ServiceToken, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, account_id, hash, created_by, created_at, expires_at, last_used_at, revoked_at
+		FROM service_tokens WHERE account_id = ? ORDER BY created_at, id
+	`, accountID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query service tokens: %v", err)
+	}
+	defer rows.Close()
+
+	var tokens []ServiceToken
+	for rows.Next() {
+		token, err := scanServiceToken(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan service token: %v", err)
+		}
+		tokens = append(tokens, *token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating service tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+// RevokeServiceToken revokes an active token of a service account
+func (s *SQLStore) RevokeServiceToken(ctx context.Context, accountID, id string, at time.Time) error {
+	err := s.execOne(ctx, `
+		UPDATE service_tokens SET revoked_at = ? WHERE id = ? AND account_id = ? AND revoked_at IS NULL
+	`, at.UTC(), id, accountID)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to revoke service token: %v", err)
+	}
+	return err
+}
+
+// TouchServiceToken records that a token was used
+func (s *SQLStore) TouchServiceToken(ctx context.Context, id string, at time.Time) error {
+	err := s.execOne(ctx, `UPDATE service_tokens SET last_used_at = ? WHERE id = ?`, at.UTC(), id)
+	if err != nil && !errors.Is(err, ErrNotFound) {
+		return fmt.Errorf("failed to update service token: %v", err)
+	}
+	return err
+}
+
+// scanServiceAccount reads a service account from a row
+func scanServiceAccount(row scanner) (*ServiceAccount, error) {
+	var account ServiceAccount
+	var scopes string
+	if err := row.Scan(&account.ID, &account.Name, &account.Description, &scopes, &account.CreatedBy, &account.CreatedAt); err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal([]byte(scopes), &account.Scopes); err != nil {
+		return nil, fmt.Errorf("failed to decode scopes of service account %s: %v", account.ID, err)
+	}
+	account.CreatedAt = account.CreatedAt.UTC()
+	return &account, nil
+}
+
+// scanServiceToken reads a service token from a row
+func scanServiceToken(row scanner) (*ServiceToken, error) {
+	var token ServiceToken
+	var hash string
+	var expiresAt, lastUsedAt, revokedAt sql.NullTime
+	if err := row.Scan(&token.ID, &token.AccountID, &hash, &token.CreatedBy, &token.CreatedAt,
+		&expiresAt, &lastUsedAt, &revokedAt); err != nil {
+		return nil, err
+	}
+	decoded, err := hex.DecodeString(hash)
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode hash of service token %s: %v", token.ID, err)
+	}
+	token.Hash = decoded
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = timePtr(expiresAt)
+	token.LastUsedAt = timePtr(lastUsedAt)
+	token.RevokedAt = timePtr(revokedAt)
+	return &token, nil
+}
+
+// nullTime converts an optional time to a nullable column value
+func nullTime(t *time.Time) sql.NullTime {
+	if t == nil {
+		return sql.NullTime{}
+	}
+	return sql.NullTime{Time: t.UTC(), Valid: true}
+}
+
+// timePtr converts a nullable column value to an optional time
+func timePtr(t sql.NullTime) *time.Time {
+	if !t.Valid {
+		return nil
+	}
+	utc := t.Time.UTC()
+	return &utc
+}
+
+// CreateOperatorToken stores a new token and assigns its ID
+func (s *SQLStore) CreateOperatorToken(ctx context.Context, token *OperatorToken) error {
+	id, now := newID("opt"), time.Now().UTC()
+	if _, err := s.exec(ctx, `
+		INSERT INTO operator_tokens (id, operator_id, hash, created_at, expires_at) VALUES (?, ?, ?, ?, ?)
+	`, id, token.OperatorID, hex.EncodeToString(token.Hash), now, token.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("failed to insert operator token: %v", err)
+	}
+	token.ID = id
+	token.CreatedAt = now
+	return nil
+}
+
+// GetOperatorToken returns a token by ID
+func (s *SQLStore) GetOperatorToken(ctx context.Context, id string) (*OperatorToken, error) {
+	var token OperatorToken
+	var hash string
+	var revokedAt sql.NullTime
+	err := s.queryRow(ctx, `
+		SELECT id, operator_id, hash, created_at, expires_at, revoked_at FROM operator_tokens WHERE id = ?
+	`, id).Scan(&token.ID, &token.OperatorID, &hash, &token.CreatedAt, &token.ExpiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query operator token: %v", err)
+	}
+	if token.Hash, err = hex.DecodeString(hash); err != nil {
+		return nil, fmt.Errorf("failed to decode hash of operator token %s: %v", token.ID, err)
+	}
+	token.CreatedAt = token.CreatedAt.UTC()
+	token.ExpiresAt = token.ExpiresAt.UTC()
+	token.RevokedAt = timePtr(revokedAt)
+	return &token, nil
+}
+
+// RevokeOperatorTokens revokes the operator's active tokens and returns how many
+func (s *SQLStore) RevokeOperatorTokens(ctx context.Context, operatorID string, at time.Time) (int, error) {
+	result, err := s.exec(ctx, `
+		UPDATE operator_tokens SET revoked_at = ? WHERE operator_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`, at.UTC(), operatorID, at.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke operator tokens: %v", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revoked operator tokens: %v", err)
+	}
+	return int(revoked), nil
+}
+
+// PruneOperatorTokens deletes the tokens that expired before the given time
+func (s *SQLStore) PruneOperatorTokens(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.exec(ctx, `DELETE FROM operator_tokens WHERE expires_at < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune operator tokens: %v", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pruned operator tokens: %v", err)
+	}
+	return int(pruned), nil
+}
+
+// ReplaceGroups replaces all synced groups with the given ones at once
+func (s *SQLStore) ReplaceGroups(ctx context.Context, groups []*DirectoryGroup) error {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, `DELETE FROM directory_groups`); err != nil {
+		return fmt.Errorf("failed to delete groups: %v", err)
+	}
+	for _, group := range groups {
+		members, err := json.Marshal(group.Members)
+		if err != nil {
+			return fmt.Errorf("failed to marshal members of group %s: %v", group.Name, err)
+		}
+		if _, err := tx.ExecContext(ctx, s.dialect.rebind(`
+			INSERT INTO directory_groups (name, members, synced_at) VALUES (?, ?, ?)
+		`), group.Name, string(members), group.SyncedAt.UTC()); err != nil {
+			return fmt.Errorf("failed to insert group %s: %v", group.Name, err)
+		}
+	}
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit groups: %v", err)
+	}
+	return nil
+}
+
+// ListGroups returns the synced groups ordered by name
+func (s *SQLStore) ListGroups(ctx context.Context) ([]*DirectoryGroup, error) {
+	rows, err := s.query(ctx, `SELECT name, members, synced_at FROM directory_groups ORDER BY name`)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query groups: %v", err)
+	}
+	defer rows.Close()
+
+	var groups []*DirectoryGroup
+	for rows.Next() {
+		var group DirectoryGroup
+		var members string
+		if err := rows.Scan(&group.Name, &members, &group.SyncedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan group: %v", err)
+		}
+		if err := json.Unmarshal([]byte(members), &group.Members); err != nil {
+			return nil, fmt.Errorf("failed to decode members of group %s: %v", group.Name, err)
+		}
+		group.SyncedAt = group.SyncedAt.UTC()
+		groups = append(groups, &group)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating groups: %v", err)
+	}
+	return groups, nil
+}
+
+// CreateWebAuthnCredential stores a new credential and assigns its ID
+func (s *SQLStore) CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error {
+	id, now := newID("wac"), time.Now().UTC()
+	if _, err := s.exec(ctx, `
+		INSERT INTO webauthn_credentials (id, user_id, name, credential_id, public_key, sign_count, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)
+	`, id, credential.UserID, credential.Name, base64.StdEncoding.EncodeToString(credential.CredentialID),
+		base64.StdEncoding.EncodeToString(credential.PublicKey), int64(credential.SignCount), now); err != nil {
+		return fmt.Errorf("failed to insert webauthn credential: %v", err)
+	}
+	credential.ID = id
+	credential.CreatedAt = now
+	return nil
+}
+
+// ListWebAuthnCredentials returns the user's credentials ordered by creation
+func (s *SQLStore) ListWebAuthnCredentials(ctx context.Context, userID string) ([]*WebAuthnCredential, error) {
+	rows, err := s.query(ctx, `
+		SELECT id, user_id, name, credential_id, public_key, sign_count, created_at, last_used_at
+		FROM webauthn_credentials WHERE user_id = ? ORDER BY created_at
+	`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query webauthn credentials: %v", err)
+	}
+	defer rows.Close()
+
+	var credentials []*WebAuthnCredential
+	for rows.Next() {
+		var credential WebAuthnCredential
+		var credentialID, publicKey string
+		var signCount int64
+		var lastUsedAt sql.NullTime
+		if err := rows.Scan(&credential.ID, &credential.UserID, &credential.Name, &credentialID, &publicKey,
+			&signCount, &credential.CreatedAt, &lastUsedAt); err != nil {
+			return nil, fmt.Errorf("failed to scan webauthn credential: %v", err)
+		}
+		if credential.CredentialID, err = base64.StdEncoding.DecodeString(credentialID); err != nil {
+			return nil, fmt.Errorf("failed to decode ID of webauthn credential %s: %v", credential.ID, err)
+		}
+		if credential.PublicKey, err = base64.StdEncoding.DecodeString(publicKey); err != nil {
+			return nil, fmt.Errorf("failed to decode public key of webauthn credential %s: %v", credential.ID, err)
+		}
+		credential.SignCount = uint32(signCount)
+		credential.CreatedAt = credential.CreatedAt.UTC()
+		credential.LastUsedAt = timePtr(lastUsedAt)
+		credentials = append(credentials, &credential)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating webauthn credentials: %v", err)
+	}
+	return credentials, nil
+}
+
+// UseWebAuthnCredential records a use of the credential with its signature
+// counter, only when the counter increased so a replayed or cloned assertion
+// racing the genuine one can't be recorded as well
+func (s *SQLStore) UseWebAuthnCredential(ctx context.Context, id string, signCount uint32, at time.Time) error {
+	result, err := s.exec(ctx, `
+		UPDATE webauthn_credentials SET sign_count = ?, last_used_at = ?
+		WHERE id = ? AND (sign_count < ? OR (sign_count = 0 AND ? = 0))
+	`, int64(signCount), at.UTC(), id, int64(signCount), int64(signCount))
+	if err != nil {
+		return fmt.Errorf("failed to update webauthn credential: %v", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count updated webauthn credentials: %v", err)
+	}
+	if updated == 0 {
+		return ErrStaleSignCount
+	}
+	return nil
+}
+
+// DeleteWebAuthnCredential deletes one of the user's credentials
+func (s *SQLStore) DeleteWebAuthnCredential(ctx context.Context, userID, id string) error {
+	return s.execOne(ctx, `DELETE FROM webauthn_credentials WHERE id = ? AND user_id = ?`, id, userID)
+}
+
+// sessionColumns are the columns scanned by scanSession
+const sessionColumns = `id, kind, user_id, issuer, subject, user_groups, user_agent, source_ip, created_at, last_seen_at, expires_at, revoked_at, revoked_by`
+
+// scanSession scans a row of sessionColumns
+func scanSession(row scanner) (*Session, error) {
+	var session Session
+	var groups, revokedBy sql.NullString
+	var revokedAt sql.NullTime
+	if err := row.Scan(&session.ID, &session.Kind, &session.UserID, &session.Issuer, &session.Subject, &groups, &session.UserAgent,
+		&session.SourceIP, &session.CreatedAt, &session.LastSeenAt, &session.ExpiresAt, &revokedAt, &revokedBy); err != nil {
+		return nil, err
+	}
+	if groups.String != "" {
+		if err := json.Unmarshal([]byte(groups.String), &session.Groups); err != nil {
+			return nil, fmt.Errorf("failed to decode groups of session %s: %v", session.ID, err)
+		}
+	}
+	session.CreatedAt = session.CreatedAt.UTC()
+	session.LastSeenAt = session.LastSeenAt.UTC()
+	session.ExpiresAt = session.ExpiresAt.UTC()
+	session.RevokedAt = timePtr(revokedAt)
+	session.RevokedBy = revokedBy.String
+	return &session, nil
+}
+
+// GetSession returns a session by ID
+func (s *SQLStore) GetSession(ctx context.Context, id string) (*Session, error) {
+	session, err := scanSession(s.queryRow(ctx, `SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to query session: %v", err)
+	}
+	return session, nil
+}
+
+// TouchSession stores a session the first time it is seen and updates when
+// it was last seen afterwards, leaving a revocation in place
+func (s *SQLStore) TouchSession(ctx context.Context, session *Session) error {
+	groups, err := json.Marshal(session.Groups)
+	if err != nil {
+		return fmt.Errorf("failed to marshal session groups: %v", err)
+	}
+	if _, err := s.exec(ctx, s.dialect.upsert("sessions",
+		[]string{"id"},
+		[]string{"id", "kind", "user_id", "issuer", "subject", "user_groups", "user_agent", "source_ip", "created_at", "last_seen_at", "expires_at"},
+		[]string{"user_agent", "source_ip", "last_seen_at"},
+	), session.ID, session.Kind, session.UserID, session.Issuer, session.Subject, string(groups), session.UserAgent, session.SourceIP,
+		session.CreatedAt.UTC(), session.LastSeenAt.UTC(), session.ExpiresAt.UTC()); err != nil {
+		return fmt.Errorf("failed to store session: %v", err)
+	}
+	return nil
+}
+
+// ListSessions returns the sessions matching the filter, most recently seen first
+func (s *SQLStore) ListSessions(ctx context.Context, filter SessionFilter) ([]*Session, error) {
+	query := `SELECT ` + sessionColumns + ` FROM sessions WHERE 1 = 1`
+	var args []interface{}
+	if filter.UserID != "" {
+		query += ` AND user_id = ?`
+		args = append(args, filter.UserID)
+	}
+	if !filter.ActiveAt.IsZero() {
+		query += ` AND revoked_at IS NULL AND expires_at > ?`
+		args = append(args, filter.ActiveAt.UTC())
+	}
+	rows, err := s.query(ctx, query+` ORDER BY last_seen_at DESC`, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query sessions: %v", err)
+	}
+	defer rows.Close()
+
+	var sessions []*Session
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, fmt.Errorf("failed to scan session: %v", err)
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("error iterating sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession revokes a session that isn't revoked yet
+func (s *SQLStore) RevokeSession(ctx context.Context, id, revokedBy string, at time.Time) error {
+	return s.execOne(ctx, `
+		UPDATE sessions SET revoked_at = ?, revoked_by = ? WHERE id = ? AND revoked_at IS NULL
+	`, at.UTC(), revokedBy, id)
+}
+
+// RevokeUserSessions revokes the user's active sessions and records the time,
+// so tokens issued before it are rejected even when no session was seen for them
+func (s *SQLStore) RevokeUserSessions(ctx context.Context, userID, revokedBy string, at time.Time) (int, error) {
+	tx, err := s.begin(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.ExecContext(ctx, s.dialect.rebind(`
+		UPDATE sessions SET revoked_at = ?, revoked_by = ? WHERE user_id = ? AND revoked_at IS NULL AND expires_at > ?
+	`), at.UTC(), revokedBy, userID, at.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to revoke sessions: %v", err)
+	}
+	revoked, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count revoked sessions: %v", err)
+	}
+	if _, err := tx.ExecContext(ctx, s.dialect.rebind(s.dialect.upsert("session_revocations",
+		[]string{"user_id"},
+		[]string{"user_id", "revoked_at"},
+		[]string{"revoked_at"},
+	)), userID, at.UTC()); err != nil {
+		return 0, fmt.Errorf("failed to record session revocation: %v", err)
+	}
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %v", err)
+	}
+	return int(revoked), nil
+}
+
+// SessionsRevokedAt returns when the user's sessions were last revoked all at once
+func (s *SQLStore) SessionsRevokedAt(ctx context.Context, userID string) (time.Time, error) {
+	var revokedAt time.Time
+	err := s.queryRow(ctx, `SELECT revoked_at FROM session_revocations WHERE user_id = ?`, userID).Scan(&revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to query session revocation: %v", err)
+	}
+	return revokedAt.UTC(), nil
+}
+
+// PruneSessions deletes the sessions that expired before the given time
+func (s *SQLStore) PruneSessions(ctx context.Context, before time.Time) (int, error) {
+	result, err := s.exec(ctx, `DELETE FROM sessions WHERE expires_at < ?`, before.UTC())
+	if err != nil {
+		return 0, fmt.Errorf("failed to prune sessions: %v", err)
+	}
+	pruned, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count pruned sessions: %v", err)
+	}
+	return int(pruned), nil
+}
+
+// Close closes the database connection
+func (s *SQLStore) Close() error {
+	s.writes.close()
+	if s.reader != s.db {
+		s.reads.close()
+		s.reader.Close()
+	}
+	return s.db.Close()
+}
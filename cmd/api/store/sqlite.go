@@ -0,0 +1,66 @@
+package store
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultSQLitePath is the database file used by the SQLite backend when no DSN is set
+const DefaultSQLitePath = "apollo.db"
+
+// sqlitePragmas configure every SQLite connection. WAL lets readers such as
+// backups run while the server writes, busy_timeout makes writers from other
+// processes wait instead of failing, and foreign_keys enforces the constraints
+// SQLite ignores by default.
+var sqlitePragmas = []string{
+	`PRAGMA journal_mode = WAL`,
+	`PRAGMA busy_timeout = 5000`,
+	`PRAGMA foreign_keys = ON`,
+	`PRAGMA synchronous = NORMAL`,
+}
+
+// sqliteFile returns the file a SQLite DSN such as apollo.db or
+// file:/var/lib/apollo/apollo.db?cache=shared refers to, or nothing for an
+// in-memory database
+func sqliteFile(dsn string) string {
+	path := strings.TrimPrefix(dsn, "file:")
+	if i := strings.IndexByte(path, '?'); i >= 0 {
+		path = path[:i]
+	}
+	if path == "" || path == ":memory:" || strings.Contains(dsn, "mode=memory") {
+		return ""
+	}
+	return path
+}
+
+// prepareSQLiteFile creates the directory of the database file
+func prepareSQLiteFile(dsn string) error {
+	path := sqliteFile(dsn)
+	if path == "" {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create database directory: %v", err)
+	}
+	return nil
+}
+
+// configureSQLite prepares a SQLite database for the server. SQLite allows one
+// writer at a time, so the server uses a single connection: its transactions
+// queue up instead of failing with SQLITE_BUSY, and an in-memory database
+// isn't lost when a pooled connection is closed.
+func configureSQLite(ctx context.Context, db *sql.DB) error {
+	db.SetMaxOpenConns(1)
+	db.SetMaxIdleConns(1)
+
+	for _, pragma := range sqlitePragmas {
+		if _, err := db.ExecContext(ctx, pragma); err != nil {
+			return fmt.Errorf("failed to run %s: %v", pragma, err)
+		}
+	}
+	return nil
+}
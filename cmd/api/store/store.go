@@ -0,0 +1,388 @@
+package store
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// ErrNotFound is returned when a record does not exist
+var ErrNotFound = errors.New("not found")
+
+// Supported storage backends
+const (
+	DriverMemory   = "memory"
+	DriverMySQL    = "mysql"
+	DriverPostgres = "postgres"
+	DriverSQLite   = "sqlite"
+)
+
+// Job statuses
+const (
+	JobStatusPending   = "pending"
+	JobStatusCompleted = "completed"
+	JobStatusFailed    = "failed"
+)
+
+// Privilege request statuses
+const (
+	RequestStatusPending  = "pending"
+	RequestStatusApproved = "approved"
+	RequestStatusDenied   = "denied"
+	// RequestStatusExpired marks requests that waited past their TTL without a decision
+	RequestStatusExpired = "expired"
+	// RequestStatusClosed marks requests that earlier versions closed
+	// unanswered after waiting past their SLA
+	RequestStatusClosed = "closed"
+)
+
+// JobTypeRevoke is the type of the jobs removing an ended grant from its target
+const JobTypeRevoke = "revoke"
+
+// Job represents a job handed to operators
+type Job struct {
+	ID        string          `json:"id"`
+	Module    string          `json:"module"`
+	Type      string          `json:"type"`
+	Request   json.RawMessage `json:"request"`
+	Status    string          `json:"status"`
+	Result    string          `json:"result"`
+	Error     string          `json:"error"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+}
+
+// JobFilter narrows down listed jobs; empty fields match everything
+type JobFilter struct {
+	Status string
+	Module string
+	Page
+}
+
+// RequestFilter narrows down listed privilege requests; empty fields match everything
+type RequestFilter struct {
+	UserID string
+	Status string
+}
+
+// UpdateFunc changes a privilege request and its approvals. Returning an error
+// aborts the update and is passed on to the caller unchanged.
+type UpdateFunc func(request *models.PrivilegeRequest, approvals []string) ([]string, error)
+
+// JobRepository persists jobs handed to operators. Finished jobs are moved
+// from the active jobs to an archive, keeping the lookup of pending jobs fast
+// however many jobs have run.
+type JobRepository interface {
+	// CreateJob stores a new job and assigns its ID
+	CreateJob(ctx context.Context, job *Job) error
+	// GetJob returns the active or archived job with the given ID
+	GetJob(ctx context.Context, id string) (*Job, error)
+	// ListJobs returns the active jobs matching the filter, oldest first
+	// unless its page sorts them otherwise
+	ListJobs(ctx context.Context, filter JobFilter) ([]*Job, error)
+	// UpdateJob records a job's status and result
+	UpdateJob(ctx context.Context, id, status, result, errMsg string) error
+	// FinishedJobsBefore returns up to limit completed or failed jobs last updated before the given time
+	FinishedJobsBefore(ctx context.Context, before time.Time, limit int) ([]*Job, error)
+	// DeleteJobs removes the jobs with the given IDs
+	DeleteJobs(ctx context.Context, ids []string) error
+	// ArchiveJobs moves up to limit completed or failed jobs last updated
+	// before the given time to the archive and returns how many it moved
+	ArchiveJobs(ctx context.Context, before time.Time, limit int) (int, error)
+}
+
+// RequestRepository persists privilege requests and their approvals. Requests
+// that are approved get their grant, or extend the grant named by ExtendsGrant,
+// in the same transaction.
+type RequestRepository interface {
+	// CreateRequest stores a new privilege request and assigns its ID; a request
+	// that is already approved is stored together with its grant
+	CreateRequest(ctx context.Context, request *models.PrivilegeRequest) error
+	// GetRequest returns the privilege request with the given ID
+	GetRequest(ctx context.Context, id string) (*models.PrivilegeRequest, error)
+	// ListRequests returns the matching privilege requests, newest first
+	ListRequests(ctx context.Context, filter RequestFilter) ([]*models.PrivilegeRequest, error)
+	// GetApprovals returns who approved a privilege request so far
+	GetApprovals(ctx context.Context, id string) ([]string, error)
+	// UpdateRequest applies fn to a privilege request atomically and creates the
+	// grant when fn approves the request
+	UpdateRequest(ctx context.Context, id string, fn UpdateFunc) (*models.PrivilegeRequest, error)
+	// RequestsSince returns the requests created after the given time, oldest first
+	RequestsSince(ctx context.Context, since time.Time) ([]*models.PrivilegeRequest, error)
+	// UserRequests returns the user's requests created after the given time
+	UserRequests(ctx context.Context, userID string, since time.Time) ([]*models.PrivilegeRequest, error)
+	// CountRequests returns the number of requests the user submitted since the given time
+	CountRequests(ctx context.Context, userID string, since time.Time) (int, error)
+	// LastDenial returns when the user's most recent request was denied, or the zero time
+	LastDenial(ctx context.Context, userID string) (time.Time, error)
+}
+
+// GrantRepository persists the privileges granted for approved requests
+type GrantRepository interface {
+	// CreateGrant stores a new privilege grant and assigns its ID
+	CreateGrant(ctx context.Context, grant *models.PrivilegeGrant) error
+	// GetGrant returns the privilege grant with the given ID
+	GetGrant(ctx context.Context, id string) (*models.PrivilegeGrant, error)
+	// GetRequestGrant returns the grant created for the given privilege request
+	GetRequestGrant(ctx context.Context, requestID string) (*models.PrivilegeGrant, error)
+	// ListGrants returns the user's grants, or all grants when userID is empty.
+	// A non-zero activeAt limits the result to grants not yet expired at that time.
+	ListGrants(ctx context.Context, userID string, activeAt time.Time) ([]*models.PrivilegeGrant, error)
+	// DeleteGrant removes a privilege grant
+	DeleteGrant(ctx context.Context, id string) error
+	// ExpiredGrantsBefore returns up to limit grants that expired before the given time
+	ExpiredGrantsBefore(ctx context.Context, before time.Time, limit int) ([]*models.PrivilegeGrant, error)
+	// DeleteGrants removes the grants with the given IDs
+	DeleteGrants(ctx context.Context, ids []string) error
+	// RevokeGrant ends a grant before it expires and creates the job revoking
+	// it on its target; ErrGrantEnded is returned when it was already revoked
+	// or has expired
+	RevokeGrant(ctx context.Context, id, actor string) (*models.PrivilegeGrant, error)
+	// ExpireGrants records up to limit grants that expired by the given time as
+	// expired, creates the jobs revoking them on their targets and returns them
+	ExpireGrants(ctx context.Context, now time.Time, limit int) ([]*models.PrivilegeGrant, error)
+	// WarnExpiringGrants records an expiring event for up to limit active
+	// grants expiring by the given time whose holders weren't warned about
+	// their current expiry time yet, and returns them
+	WarnExpiringGrants(ctx context.Context, now, before time.Time, limit int) ([]*models.PrivilegeGrant, error)
+}
+
+// Credential is the sealed credentials of a grant, such as the temporary
+// database user an operator created for it. Credentials can be taken once.
+type Credential struct {
+	GrantID string
+	// KeyID names the master key the credentials' data key is wrapped with
+	KeyID string
+	// Sealed is the encrypted credentials; it is empty when they are kept in
+	// an external secret store
+	Sealed []byte
+	// Ref names the secret holding the sealed credentials in an external secret store
+	Ref       string
+	CreatedAt time.Time
+}
+
+// CredentialRepository persists the sealed credentials of grants. Credentials
+// are deleted when their grant ends or is deleted.
+type CredentialRepository interface {
+	// PutCredential stores the credentials of an active grant, replacing earlier
+	// ones, and records the grant as provisioned by actor. ErrGrantEnded is
+	// returned when the grant was revoked or has expired.
+	PutCredential(ctx context.Context, credential *Credential, actor string) error
+	// TakeCredential has open read the credentials of a grant and deletes
+	// them once it succeeded; when open fails they are kept and its error
+	// returned. The credentials stay locked while open runs, so they are
+	// taken once; open must not use the store.
+	TakeCredential(ctx context.Context, grantID string, open func(*Credential) error) error
+	// CredentialsNotUnder returns up to limit credentials wrapped with another master key than keyID
+	CredentialsNotUnder(ctx context.Context, keyID string, limit int) ([]*Credential, error)
+	// RewrapCredential replaces credentials that are still wrapped with the
+	// previous key; ErrNotFound is returned when they were taken or replaced since
+	RewrapCredential(ctx context.Context, credential *Credential, previousKeyID string) error
+}
+
+// AuditRepository persists audit records; it implements audit.Recorder
+type AuditRepository interface {
+	// Record stores an audit entry and assigns its ID
+	Record(ctx context.Context, entry audit.Entry) error
+	// AuditBefore returns up to limit audit entries recorded before the given time, oldest first
+	AuditBefore(ctx context.Context, before time.Time, limit int) ([]audit.Entry, error)
+	// DeleteAudit removes the audit entries with the given IDs
+	DeleteAudit(ctx context.Context, ids []string) error
+	// ListAudit returns the audit entries matching the query, ordered by time and ID
+	ListAudit(ctx context.Context, query AuditQuery) ([]audit.Entry, error)
+}
+
+// AuditQuery selects audit entries recorded in [From, To), optionally only
+// those concerning a user, as actor or otherwise, a resource or with an
+// action. Pages continue after the entry identified by AfterTime and AfterID,
+// which are left empty for the first page.
+type AuditQuery struct {
+	From       time.Time
+	To         time.Time
+	UserID     string
+	ResourceID string
+	Action     string
+	AfterTime  time.Time
+	AfterID    string
+	Limit      int
+}
+
+// matches reports whether an entry belongs to the query's page
+func (q AuditQuery) matches(entry audit.Entry) bool {
+	if entry.Time.Before(q.From) || (!q.To.IsZero() && !entry.Time.Before(q.To)) {
+		return false
+	}
+	if q.UserID != "" && entry.Actor != q.UserID && entry.UserID != q.UserID {
+		return false
+	}
+	if q.ResourceID != "" && entry.ResourceID != q.ResourceID {
+		return false
+	}
+	if q.Action != "" && entry.Action != q.Action {
+		return false
+	}
+	if q.AfterID != "" {
+		return entry.Time.After(q.AfterTime) || (entry.Time.Equal(q.AfterTime) && entry.ID > q.AfterID)
+	}
+	return true
+}
+
+// OperatorRepository persists the registered operators
+type OperatorRepository interface {
+	// RegisterOperator marks an operator in the given region as active,
+	// creating it when it is new
+	RegisterOperator(ctx context.Context, id, region string) error
+	// UpdateOperatorHealth records a health check of an operator and the
+	// health of its modules
+	UpdateOperatorHealth(ctx context.Context, id string, at time.Time, health []modules.ModuleHealth) error
+	// MarkOperatorInactive marks an operator as inactive
+	MarkOperatorInactive(ctx context.Context, id string) error
+	// GetOperator returns a registered operator
+	GetOperator(ctx context.Context, id string) (*modules.OperatorInfo, error)
+	// ListOperators returns the operators matching the filter, ordered by ID
+	// unless its page sorts them otherwise
+	ListOperators(ctx context.Context, filter OperatorFilter) ([]modules.OperatorInfo, error)
+	// StaleOperators returns the active operators last seen before the given time
+	StaleOperators(ctx context.Context, before time.Time) ([]string, error)
+}
+
+// ServerRepository persists the servers registered by each module
+type ServerRepository interface {
+	// RegisterServer marks a module's server as active, creating it when it is new
+	RegisterServer(ctx context.Context, module string, server modules.ServerInfo) error
+	// MarkServerInactive marks a module's server as inactive
+	MarkServerInactive(ctx context.Context, module, name string) error
+	// ListServers returns the servers of a module matching the filter,
+	// ordered by name unless its page sorts them otherwise
+	ListServers(ctx context.Context, module string, filter ServerFilter) ([]modules.ServerInfo, error)
+}
+
+// Store persists the state of the API server
+type Store interface {
+	JobRepository
+	RequestRepository
+	GrantRepository
+	OperatorRepository
+	ServerRepository
+	AuditRepository
+	EventRepository
+	CredentialRepository
+	OutboxRepository
+	ServiceAccountRepository
+	OperatorTokenRepository
+	GroupRepository
+	WebAuthnRepository
+	SessionRepository
+
+	// Close releases the store's resources
+	Close() error
+}
+
+// Config selects and configures the storage backend
+type Config struct {
+	// Driver is one of memory, mysql, postgres or sqlite
+	Driver string `yaml:"driver"`
+	// DSN is the data source name passed to the database driver
+	DSN string `yaml:"dsn"`
+	// ReadDSN optionally points reads at a replica in the server's region;
+	// writes and transactions always go to the primary at DSN
+	ReadDSN string `yaml:"read_dsn"`
+	// DriverName overrides the database/sql driver name, e.g. postgres or sqlite3
+	DriverName string `yaml:"driver_name"`
+	// MaxOpenConns limits the number of open database connections
+	MaxOpenConns int `yaml:"max_open_conns"`
+	// ConnectTimeout is how long to keep retrying the initial connection;
+	// the server gives up after the first failed attempt when zero
+	ConnectTimeout time.Duration `yaml:"connect_timeout"`
+	// BreakerThreshold is the number of consecutive connection failures after
+	// which calls fail fast; defaults to DefaultBreakerThreshold
+	BreakerThreshold int `yaml:"breaker_threshold"`
+	// ReconnectDelay is the first delay before pinging a database that became
+	// unavailable; defaults to DefaultReconnectDelay and doubles per attempt
+	ReconnectDelay time.Duration `yaml:"reconnect_delay"`
+}
+
+// Open creates the store selected by the configuration
+func Open(ctx context.Context, config Config) (Store, error) {
+	switch config.Driver {
+	case "", DriverMemory:
+		return NewMemoryStore(), nil
+	case DriverMySQL, DriverPostgres, DriverSQLite:
+		s, err := OpenSQL(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		return s, nil
+	default:
+		return nil, fmt.Errorf("unknown storage driver %q", config.Driver)
+	}
+}
+
+// grantFor returns the grant for an approved request. The granted duration
+// starts when the request is approved rather than when it was submitted.
+func grantFor(request *models.PrivilegeRequest) *models.PrivilegeGrant {
+	grantedAt := time.Now().UTC()
+	if request.ApprovedAt != nil {
+		grantedAt = *request.ApprovedAt
+	}
+	return &models.PrivilegeGrant{
+		ID:         newID("grant"),
+		UserID:     request.UserID,
+		Module:     request.Module,
+		ResourceID: request.ResourceID,
+		Level:      request.Level,
+		GrantedAt:  grantedAt,
+		ExpiresAt:  grantedAt.Add(request.ExpiresAt.Sub(request.RequestedAt)),
+		GrantedBy:  request.ApprovedBy,
+		RequestID:  request.ID,
+		Approvals:  append([]models.ApprovalRecord(nil), request.Approvals...),
+		BoundTo:    request.BoundTo,
+		CreatedAt:  grantedAt,
+		UpdatedAt:  grantedAt,
+	}
+}
+
+// RevokeJobID returns the ID of the job revoking the given grant. The store
+// creates it together with the change that ends the grant.
+func RevokeJobID(grantID string) string {
+	return "job_revoke_" + grantID
+}
+
+// revokeJob returns the job removing an ended grant from its target
+func revokeJob(grant *models.PrivilegeGrant, now time.Time) (*Job, error) {
+	request, err := json.Marshal(modules.RevokeRequest{
+		GrantID:    grant.ID,
+		UserID:     grant.UserID,
+		ResourceID: grant.ResourceID,
+		Level:      string(grant.Level),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal revoke request: %v", err)
+	}
+	return &Job{
+		ID:        RevokeJobID(grant.ID),
+		Module:    grant.Module,
+		Type:      JobTypeRevoke,
+		Request:   request,
+		Status:    JobStatusPending,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}, nil
+}
+
+// newID generates an ID with the given prefix. IDs sort by creation time and
+// carry a random suffix, so API servers in different regions writing to the
+// same database never generate the same ID.
+func newID(prefix string) string {
+	suffix := make([]byte, 4)
+	rand.Read(suffix)
+	return fmt.Sprintf("%s_%d_%s", prefix, time.Now().UnixNano(), hex.EncodeToString(suffix))
+}
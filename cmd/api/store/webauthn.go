@@ -0,0 +1,41 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// ErrStaleSignCount is returned when recording a use of a WebAuthn credential
+// whose signature counter didn't increase
+var ErrStaleSignCount = errors.New("signature counter did not increase")
+
+// WebAuthnCredential is a hardware key a user registered to confirm
+// approvals with
+type WebAuthnCredential struct {
+	ID     string `json:"id"`
+	UserID string `json:"user_id"`
+	Name   string `json:"name"`
+	// CredentialID is the ID the authenticator assigned to the credential
+	CredentialID []byte `json:"-"`
+	// PublicKey is the COSE_Key encoded public key
+	PublicKey  []byte     `json:"-"`
+	SignCount  uint32     `json:"sign_count"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+}
+
+// WebAuthnRepository persists the hardware keys users registered
+type WebAuthnRepository interface {
+	// CreateWebAuthnCredential stores a new credential and assigns its ID
+	CreateWebAuthnCredential(ctx context.Context, credential *WebAuthnCredential) error
+	// ListWebAuthnCredentials returns the user's credentials ordered by creation
+	ListWebAuthnCredentials(ctx context.Context, userID string) ([]*WebAuthnCredential, error)
+	// UseWebAuthnCredential records a use of the credential with the
+	// signature counter the authenticator reported. It returns
+	// ErrStaleSignCount unless the counter increased, or both are zero for
+	// authenticators without one.
+	UseWebAuthnCredential(ctx context.Context, id string, signCount uint32, at time.Time) error
+	// DeleteWebAuthnCredential deletes one of the user's credentials
+	DeleteWebAuthnCredential(ctx context.Context, userID, id string) error
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/petermein/apollo/cmd/api/config"
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/modules/mock"
+	"github.com/petermein/apollo/cmd/api/modules/mysql"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/configref"
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Work with API configuration files",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate [path]",
+	Short: "Check an API configuration file without starting the server",
+	Long: `Validate loads an API configuration file, resolves its ${VAR}, ${VAR:-default}
+and ${file:/path} references, and checks the settings and the configuration of
+every enabled module. Nothing is connected to. Every problem found is reported,
+not only the first.
+
+Check operator configuration files with apollo-operator --validate.
+Example:
+  apollo config validate configs/config.yaml`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := config.GetConfigPath()
+		if err != nil {
+			return err
+		}
+		if len(args) == 1 {
+			path = args[0]
+		}
+
+		errs := validateAPIConfig(path)
+		for _, err := range errs {
+			fmt.Fprintf(cmd.ErrOrStderr(), "%s: %v\n", path, err)
+		}
+		if len(errs) > 0 {
+			return fmt.Errorf("%s is not valid", path)
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "%s: OK\n", path)
+		return nil
+	},
+}
+
+func init() {
+	configCmd.AddCommand(configValidateCmd)
+}
+
+// validateAPIConfig checks an API configuration file and the configuration of
+// every enabled module, returning every problem found
+func validateAPIConfig(path string) []error {
+	cfg, err := config.Parse(path)
+	if cfg == nil {
+		return []error{err}
+	}
+	var errs []error
+	var refErr *configref.Error
+	if errors.As(err, &refErr) {
+		errs = append(errs, refErr.Errs...)
+	}
+	errs = append(errs, cfg.Validate()...)
+
+	switch cfg.Database.Driver {
+	case "", store.DriverMemory, store.DriverSQLite:
+	case store.DriverMySQL, store.DriverPostgres:
+		if cfg.Database.DSN == "" {
+			errs = append(errs, fmt.Errorf("database.dsn is required for the %s driver", cfg.Database.Driver))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("database.driver %q is not supported", cfg.Database.Driver))
+	}
+
+	registry := modules.NewRegistry()
+	registry.Register(mysql.NewModule())
+	registry.Register(mock.NewModule())
+	for _, name := range strings.Split(cfg.Server.EnabledModules, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		module := registry.GetModule(name)
+		if module == nil {
+			errs = append(errs, fmt.Errorf("server.enabled_modules: module %s not found", name))
+			continue
+		}
+		moduleConfig, err := cfg.GetModuleConfig(name)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		validator, ok := module.(modules.ConfigValidator)
+		if !ok {
+			continue
+		}
+		for _, err := range configref.Flatten(validator.ValidateConfig(moduleConfig)) {
+			errs = append(errs, fmt.Errorf("modules.%s: %v", name, err))
+		}
+	}
+	return errs
+}
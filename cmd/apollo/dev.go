@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/petermein/apollo/cmd/api/handler"
+	"github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/modules/mysql"
+	"github.com/petermein/apollo/cmd/api/outbox"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/rules"
+	"github.com/spf13/cobra"
+)
+
+var (
+	devAddr  string
+	devDB    string
+	devAdmin string
+)
+
+var devCmd = &cobra.Command{
+	Use:   "dev",
+	Short: "Run the API, a mock operator and a fake MySQL module in one process",
+	Long: `Dev runs everything needed to try the request, approve and grant flow on one
+machine: the API with a SQLite store, a fake MySQL module, and a mock operator
+managing three sample servers over an in-process bus.
+
+  analytics-db  public, requests are approved by policy
+  orders-db     internal, requests need one approval
+  payments-db   restricted, requests need two approvals
+
+Users are not authenticated: a request acts as the user named by its bearer
+token or X-Apollo-User header, so apollo-cli login --token alice logs the CLI
+in as alice. The --admin user may approve anything and administer the API.
+Without the sqlite build tag, state is kept in memory instead. mysql grant
+prints the ID of the request while it waits for approval:
+  apollo dev
+  apollo-cli login --token alice
+  apollo-cli mysql grant --server orders-db --level read --reason "debug order 42" --duration 1h
+  curl -X POST -H "X-Apollo-User: bob" localhost:8080/api/v1/privileges/<request-id>/approve`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return runDev(devAddr, devDB, devAdmin)
+	},
+}
+
+func init() {
+	devCmd.Flags().StringVar(&devAddr, "addr", "localhost:8080", "Address to serve the API on")
+	devCmd.Flags().StringVar(&devDB, "db", "apollo-dev.db", "SQLite database file, empty to keep state in memory")
+	devCmd.Flags().StringVar(&devAdmin, "admin", "admin", "User with administrator rights")
+}
+
+// runDev serves the API with a fake MySQL module and a mock operator until interrupted
+func runDev(addr, dbPath, admin string) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	st, err := openDevStore(ctx, dbPath)
+	if err != nil {
+		return err
+	}
+	defer st.Close()
+
+	// The API and the mock operator share a bus within the process
+	jobBus := bus.NewMemory()
+	defer jobBus.Close()
+
+	mysqlModule := mysql.NewFakeModule()
+	mysqlModule.SetStore(st)
+	if err := mysqlModule.Initialize(nil); err != nil {
+		return fmt.Errorf("failed to initialize module mysql: %v", err)
+	}
+
+	h := handler.NewHandler([]modules.Module{mysqlModule}, st)
+	h.SetAdmins([]string{admin})
+	if err := h.SetJobBus(jobBus); err != nil {
+		return fmt.Errorf("failed to subscribe to job results: %v", err)
+	}
+	engine := rules.NewDefaultRuleEngine(devRules())
+	engine.SetAuditRecorder(audit.MultiRecorder{audit.LogRecorder{}, st})
+	h.SetRuleEngine(engine)
+
+	publisher, err := outbox.NewBusPublisher(jobBus, outbox.Format{})
+	if err != nil {
+		return err
+	}
+	dispatcher := outbox.NewDispatcher(st, publisher)
+	dispatcher.Start(ctx, time.Second)
+	h.SetEventDispatcher(dispatcher)
+	h.StartCleanup(ctx, 10*time.Second, handler.CleanupPolicy{OperatorTimeout: 2 * time.Minute})
+
+	mux := http.NewServeMux()
+	h.RegisterRoutes(mux)
+	h.SetOperatorTokens(0, false)
+	routes := h.AuthenticateOperators(h.AuthenticateServiceAccounts(devIdentity(h.ValidateRequests(mux))))
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %v", addr, err)
+	}
+	srv := &http.Server{
+		Handler:           handler.Compress(h.DegradeGracefully(routes)),
+		ReadHeaderTimeout: 10 * time.Second,
+		IdleTimeout:       2 * time.Minute,
+	}
+	go func() {
+		if err := srv.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Fatalf("Failed to serve: %v", err)
+		}
+	}()
+
+	apiURL := "http://" + listener.Addr().String()
+	if err := newDevOperator(apiURL, jobBus).Start(ctx); err != nil {
+		return fmt.Errorf("failed to start the mock operator: %v", err)
+	}
+	log.Printf("Apollo dev is serving on %s; point apollo-cli at it with --api %s", apiURL, apiURL)
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, syscall.SIGINT, syscall.SIGTERM)
+	<-quit
+
+	log.Println("Shutting down...")
+	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer shutdownCancel()
+	return srv.Shutdown(shutdownCtx)
+}
+
+// openDevStore opens the SQLite database at the path, or keeps state in
+// memory when the path is empty or SQLite isn't linked in
+func openDevStore(ctx context.Context, path string) (store.Store, error) {
+	if path != "" && !sqliteLinked() {
+		log.Printf("Built without the sqlite tag; keeping state in memory instead of %s", path)
+		path = ""
+	}
+	if path == "" {
+		log.Printf("Using in-memory storage; state is lost when apollo dev stops")
+		return store.NewMemoryStore(), nil
+	}
+	st, err := store.Open(ctx, store.Config{Driver: store.DriverSQLite, DSN: path})
+	if err != nil {
+		return nil, fmt.Errorf("failed to open store: %v", err)
+	}
+	return st, nil
+}
+
+// sqliteLinked reports whether a SQLite driver was linked in
+func sqliteLinked() bool {
+	for _, driver := range sql.Drivers() {
+		if driver == "sqlite" {
+			return true
+		}
+	}
+	return false
+}
+
+// devIdentity takes the user of a request from its bearer token, so the CLI
+// can act as any user; requests without one keep their X-Apollo-User header.
+// It goes behind the operator and service account middleware, whose tokens
+// set the principal already.
+func devIdentity(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, authenticated := auth.PrincipalFrom(r.Context())
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		// Operator and service account users are only taken from their tokens
+		reserved := strings.HasPrefix(token, "operator:") || strings.HasPrefix(token, "sa:")
+		if ok && token != "" && !authenticated && !reserved {
+			principal := &auth.Principal{ID: token, Subject: token}
+			r = r.WithContext(auth.WithPrincipal(r.Context(), principal))
+			r.Header.Set(auth.UserHeader, token)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
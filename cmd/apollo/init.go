@@ -0,0 +1,565 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/config"
+	operatormodules "github.com/petermein/apollo/cmd/operator/modules"
+	operatorelasticsearch "github.com/petermein/apollo/cmd/operator/modules/elasticsearch"
+	operatorgithub "github.com/petermein/apollo/cmd/operator/modules/github"
+	operatorldap "github.com/petermein/apollo/cmd/operator/modules/ldap"
+	operatormock "github.com/petermein/apollo/cmd/operator/modules/mock"
+	operatormysql "github.com/petermein/apollo/cmd/operator/modules/mysql"
+	operatorsnowflake "github.com/petermein/apollo/cmd/operator/modules/snowflake"
+	operatorssh "github.com/petermein/apollo/cmd/operator/modules/ssh"
+	"github.com/petermein/apollo/internal/configref"
+	"github.com/spf13/cobra"
+)
+
+// checkTimeout bounds each connectivity check of the wizard
+const checkTimeout = 5 * time.Second
+
+var (
+	initProfile  string
+	initServer   bool
+	initOperator bool
+	initDir      string
+	initForce    bool
+)
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Set up configuration files by answering a few questions",
+	Long: `Init asks for the API endpoint and the OIDC issuer and client the CLI logs in
+with, checks that they answer and writes the CLI configuration to
+~/.apollo-cli.yaml, or ~/.apollo-cli-<profile>.yaml with --profile.
+
+With --server and --operator it also writes an API server and an operator
+configuration to --dir, with a MySQL module stanza each. Passwords aren't
+written to the files: they refer to an environment variable or a secret file
+instead. The MySQL server and database are checked to be reachable, and the
+files written are validated.
+
+Press enter to accept the default shown in brackets.
+Example:
+  apollo init
+  apollo init --profile staging
+  apollo init --server --operator --dir /etc/apollo`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		w := &wizard{in: bufio.NewReader(cmd.InOrStdin()), out: cmd.OutOrStdout()}
+		return w.run(cmd.Context())
+	},
+}
+
+func init() {
+	initCmd.Flags().StringVar(&initProfile, "profile", "", "Name of the CLI profile, written to ~/.apollo-cli-<profile>.yaml")
+	initCmd.Flags().BoolVar(&initServer, "server", false, "Also write an API server configuration")
+	initCmd.Flags().BoolVar(&initOperator, "operator", false, "Also write an operator configuration")
+	initCmd.Flags().StringVar(&initDir, "dir", ".", "Directory the server and operator configurations are written to")
+	initCmd.Flags().BoolVar(&initForce, "force", false, "Overwrite existing files without asking")
+}
+
+// wizard asks questions on the terminal and writes the answers as configuration files
+type wizard struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+// run asks for the settings of the CLI and, when asked to, of an API server
+// and an operator, and writes their configuration files
+func (w *wizard) run(ctx context.Context) error {
+	if ctx == nil {
+		ctx = context.Background()
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return err
+	}
+	cliPath := filepath.Join(home, ".apollo-cli.yaml")
+	if initProfile != "" {
+		cliPath = filepath.Join(home, ".apollo-cli-"+initProfile+".yaml")
+	}
+
+	fmt.Fprintln(w.out, "CLI")
+	endpoint, err := w.ask("API endpoint", "http://localhost:8080")
+	if err != nil {
+		return err
+	}
+	endpoint = strings.TrimSuffix(endpoint, "/")
+	if err := w.check(ctx, "API", func(ctx context.Context) error { return checkAPI(ctx, endpoint) }); err != nil {
+		return err
+	}
+	issuer, err := w.ask("OIDC issuer, empty to log in with tokens", "")
+	if err != nil {
+		return err
+	}
+	var clientID string
+	if issuer != "" {
+		issuer = strings.TrimSuffix(issuer, "/")
+		if err := w.check(ctx, "OIDC issuer", func(ctx context.Context) error { return checkIssuer(ctx, issuer) }); err != nil {
+			return err
+		}
+		if clientID, err = w.askRequired("OIDC client ID"); err != nil {
+			return err
+		}
+	}
+	if err := w.write(cliPath, cliConfig(endpoint, issuer, clientID)); err != nil {
+		return err
+	}
+	if initProfile != "" {
+		fmt.Fprintf(w.out, "Use the profile with apollo-cli --config %s\n", cliPath)
+	}
+
+	if !initServer && !initOperator {
+		return nil
+	}
+	fmt.Fprintln(w.out, "\nMySQL server managed by the mysql module")
+	target, err := w.askMySQL(ctx)
+	if err != nil {
+		return err
+	}
+
+	if initServer {
+		fmt.Fprintln(w.out, "\nAPI server")
+		if err := w.server(ctx, target); err != nil {
+			return err
+		}
+	}
+	if initOperator {
+		fmt.Fprintln(w.out, "\nOperator")
+		if err := w.operator(endpoint, target); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// mysqlTarget is a MySQL server and the secret reference to its password
+type mysqlTarget struct {
+	host     string
+	port     int
+	user     string
+	password string
+}
+
+// askMySQL asks for the MySQL server the module manages and checks it's reachable
+func (w *wizard) askMySQL(ctx context.Context) (mysqlTarget, error) {
+	var target mysqlTarget
+	var err error
+	if target.host, err = w.ask("Host", "localhost"); err != nil {
+		return target, err
+	}
+	if target.port, err = w.askPort("Port", 3306); err != nil {
+		return target, err
+	}
+	address := net.JoinHostPort(target.host, strconv.Itoa(target.port))
+	if err := w.check(ctx, "MySQL at "+address, func(ctx context.Context) error { return checkTCP(ctx, address) }); err != nil {
+		return target, err
+	}
+	if target.user, err = w.ask("User", "apollo"); err != nil {
+		return target, err
+	}
+	if target.password, err = w.askSecret("Password", "APOLLO_MYSQL_PASSWORD"); err != nil {
+		return target, err
+	}
+	return target, nil
+}
+
+// server asks for the settings of an API server and writes its configuration
+func (w *wizard) server(ctx context.Context, target mysqlTarget) error {
+	port, err := w.askPort("Port to listen on", 8080)
+	if err != nil {
+		return err
+	}
+	admins, err := w.ask("Administrators, comma separated", "admin")
+	if err != nil {
+		return err
+	}
+	driver, err := w.askChoice("Database driver", "sqlite", "sqlite", "mysql", "postgres", "memory")
+	if err != nil {
+		return err
+	}
+
+	var dsn string
+	switch driver {
+	case "sqlite":
+		if dsn, err = w.ask("Database file", "/var/lib/apollo/apollo.db"); err != nil {
+			return err
+		}
+	case "mysql", "postgres":
+		defaultPort := 3306
+		if driver == "postgres" {
+			defaultPort = 5432
+		}
+		host, err := w.ask("Database host", target.host)
+		if err != nil {
+			return err
+		}
+		dbPort, err := w.askPort("Database port", defaultPort)
+		if err != nil {
+			return err
+		}
+		address := net.JoinHostPort(host, strconv.Itoa(dbPort))
+		if err := w.check(ctx, "database at "+address, func(ctx context.Context) error { return checkTCP(ctx, address) }); err != nil {
+			return err
+		}
+		user, err := w.ask("Database user", "apollo")
+		if err != nil {
+			return err
+		}
+		password, err := w.askSecret("Database password", "APOLLO_DB_PASSWORD")
+		if err != nil {
+			return err
+		}
+		if driver == "mysql" {
+			dsn = fmt.Sprintf("%s:%s@tcp(%s)/apollo", user, password, address)
+		} else {
+			dsn = fmt.Sprintf("postgres://%s:%s@%s/apollo", user, password, address)
+		}
+	}
+
+	path := filepath.Join(initDir, "apollo-api.yaml")
+	if err := w.write(path, serverConfig(port, splitList(admins), driver, dsn, target)); err != nil {
+		return err
+	}
+	w.report(path, validateAPIConfig(path))
+	if driver == "sqlite" || driver == "postgres" {
+		// Only the MySQL driver is linked into the API server by default
+		fmt.Fprintf(w.out, "Build the API server with make build-api-%s to use the %s driver\n", driver, driver)
+	}
+	return nil
+}
+
+// operator asks for the settings of an operator and writes its configuration
+func (w *wizard) operator(endpoint string, target mysqlTarget) error {
+	id, err := w.ask("Operator ID", "operator-1")
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(initDir, "apollo-operator.yaml")
+	if err := w.write(path, operatorConfig(id, endpoint, target)); err != nil {
+		return err
+	}
+	w.report(path, validateOperatorConfig(path))
+	return nil
+}
+
+// ask asks a question and returns the answer, or the default when empty
+func (w *wizard) ask(question, def string) (string, error) {
+	if def != "" {
+		fmt.Fprintf(w.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(w.out, "%s: ", question)
+	}
+	line, err := w.in.ReadString('\n')
+	if err != nil && (!errors.Is(err, io.EOF) || line == "") {
+		if errors.Is(err, io.EOF) {
+			return "", fmt.Errorf("no answer to %q", question)
+		}
+		return "", err
+	}
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer, nil
+	}
+	return def, nil
+}
+
+// askRequired asks until the question is answered
+func (w *wizard) askRequired(question string) (string, error) {
+	for {
+		answer, err := w.ask(question, "")
+		if err != nil || answer != "" {
+			return answer, err
+		}
+		fmt.Fprintln(w.out, "An answer is required.")
+	}
+}
+
+// askPort asks for a TCP port
+func (w *wizard) askPort(question string, def int) (int, error) {
+	for {
+		answer, err := w.ask(question, strconv.Itoa(def))
+		if err != nil {
+			return 0, err
+		}
+		port, err := strconv.Atoi(answer)
+		if err == nil && port > 0 && port < 65536 {
+			return port, nil
+		}
+		fmt.Fprintln(w.out, "Enter a port from 1 to 65535.")
+	}
+}
+
+// askChoice asks until one of the choices is picked
+func (w *wizard) askChoice(question, def string, choices ...string) (string, error) {
+	for {
+		answer, err := w.ask(fmt.Sprintf("%s (%s)", question, strings.Join(choices, ", ")), def)
+		if err != nil {
+			return "", err
+		}
+		for _, choice := range choices {
+			if answer == choice {
+				return answer, nil
+			}
+		}
+		fmt.Fprintf(w.out, "Pick one of %s.\n", strings.Join(choices, ", "))
+	}
+}
+
+// askSecret asks where a secret is kept and returns the reference to it: an
+// environment variable name, or the path of a secret file
+func (w *wizard) askSecret(question, defaultVar string) (string, error) {
+	answer, err := w.ask(question+" from environment variable or secret file", defaultVar)
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(answer, "/") {
+		return "${file:" + answer + "}", nil
+	}
+	return "${" + answer + "}", nil
+}
+
+// confirm asks a yes or no question, defaulting to no
+func (w *wizard) confirm(question string) (bool, error) {
+	answer, err := w.ask(question+" [y/N]", "")
+	if err != nil {
+		return false, err
+	}
+	answer = strings.ToLower(answer)
+	return answer == "y" || answer == "yes", nil
+}
+
+// check runs a connectivity check and asks whether to go on when it fails
+func (w *wizard) check(ctx context.Context, name string, check func(context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+	fmt.Fprintf(w.out, "  Checking %s... ", name)
+	err := check(ctx)
+	if err == nil {
+		fmt.Fprintln(w.out, "ok")
+		return nil
+	}
+	fmt.Fprintf(w.out, "failed: %v\n", err)
+	ok, confirmErr := w.confirm("  Continue anyway?")
+	if confirmErr != nil {
+		return confirmErr
+	}
+	if !ok {
+		return fmt.Errorf("%s is not reachable", name)
+	}
+	return nil
+}
+
+// write writes a configuration file, asking before overwriting one
+func (w *wizard) write(path, content string) error {
+	if _, err := os.Stat(path); err == nil && !initForce {
+		ok, err := w.confirm(fmt.Sprintf("%s exists. Overwrite?", path))
+		if err != nil {
+			return err
+		}
+		if !ok {
+			fmt.Fprintf(w.out, "Kept %s\n", path)
+			return nil
+		}
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		return err
+	}
+	fmt.Fprintf(w.out, "Wrote %s\n", path)
+	return nil
+}
+
+// report prints the problems validating a written file found
+func (w *wizard) report(path string, errs []error) {
+	if len(errs) == 0 {
+		fmt.Fprintf(w.out, "%s: OK\n", path)
+		return
+	}
+	fmt.Fprintf(w.out, "%s needs attention before it's used:\n", path)
+	for _, err := range errs {
+		fmt.Fprintf(w.out, "  %v\n", err)
+	}
+}
+
+// checkAPI checks that the API answers its health check
+func checkAPI(ctx context.Context, endpoint string) error {
+	return checkGet(ctx, endpoint+"/api/v1/health")
+}
+
+// checkIssuer checks that the issuer serves its OIDC discovery document
+func checkIssuer(ctx context.Context, issuer string) error {
+	return checkGet(ctx, issuer+"/.well-known/openid-configuration")
+}
+
+// checkGet checks that a URL answers a GET with success
+func checkGet(ctx context.Context, url string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s answered %s", url, resp.Status)
+	}
+	return nil
+}
+
+// checkTCP checks that something accepts connections at the address
+func checkTCP(ctx context.Context, address string) error {
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", address)
+	if err != nil {
+		return err
+	}
+	return conn.Close()
+}
+
+// splitList splits a comma separated list, dropping empty entries
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+// quoteList writes a list as a YAML flow sequence of quoted strings
+func quoteList(items []string) string {
+	quoted := make([]string, len(items))
+	for i, item := range items {
+		quoted[i] = strconv.Quote(item)
+	}
+	return "[" + strings.Join(quoted, ", ") + "]"
+}
+
+// cliConfig returns the CLI configuration
+func cliConfig(endpoint, issuer, clientID string) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "api:\n  endpoint: %q\n  retry_attempts: 3\n  retry_delay: \"5s\"\n", endpoint)
+	if issuer != "" {
+		fmt.Fprintf(&b, "\n# apollo-cli login signs in with the issuer\nauth:\n  oidc:\n    issuer: %q\n    client_id: %q\n", issuer, clientID)
+	} else {
+		b.WriteString("\n# Log in with a token from your administrator: apollo-cli login --token <token>\n")
+	}
+	return b.String()
+}
+
+// serverConfig returns the API server configuration
+func serverConfig(port int, admins []string, driver, dsn string, target mysqlTarget) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, `server:
+  host: "0.0.0.0"
+  port: %d
+  enabled_modules: "mysql"
+  # Users allowed to run retention and export the audit log
+  admins: %s
+
+modules:
+  mysql:
+    host: %q
+    port: %d
+    user: %q
+    password: %q
+    max_connections: 10
+    connection_timeout: "5s"
+    idle_timeout: "30s"
+
+database:
+  driver: %q
+`, port, quoteList(admins), target.host, target.port, target.user, target.password, driver)
+	if dsn != "" {
+		fmt.Fprintf(&b, "  dsn: %q\n", dsn)
+	}
+	b.WriteString(`
+cleanup:
+  interval: "1m"
+  pending_ttl: "72h"
+  operator_timeout: "2m"
+
+# See configs/api.yaml.template for authentication, notifications and the
+# other settings
+`)
+	return b.String()
+}
+
+// operatorConfig returns the operator configuration
+func operatorConfig(id, endpoint string, target mysqlTarget) string {
+	return fmt.Sprintf(`operator_id: %q
+enabled_modules: "mysql"
+
+modules:
+  mysql:
+    host: %q
+    port: %d
+    user: %q
+    password: %q
+    max_connections: 10
+    connection_timeout: "5s"
+    idle_timeout: "30s"
+
+api:
+  endpoint: %q
+
+# See configs/operator.yaml.template for tokens, request signing and the bus
+`, id, target.host, target.port, target.user, target.password, endpoint)
+}
+
+// validateOperatorConfig checks an operator configuration file and the
+// configuration of its enabled modules, as apollo-operator --validate does
+func validateOperatorConfig(path string) []error {
+	cfg, err := config.Parse(path)
+	if cfg == nil {
+		return []error{err}
+	}
+	var errs []error
+	var refErr *configref.Error
+	if errors.As(err, &refErr) {
+		errs = append(errs, refErr.Errs...)
+	}
+	errs = append(errs, cfg.Validate()...)
+
+	registry := operatormodules.NewRegistry()
+	registry.Register(operatormysql.NewModule(nil))
+	registry.Register(operatormock.NewModule())
+	registry.Register(operatorssh.NewModule())
+	registry.Register(operatorldap.NewModule())
+	registry.Register(operatorgithub.NewModule())
+	registry.Register(operatorsnowflake.NewModule())
+	registry.Register(operatorelasticsearch.NewModule())
+	for _, name := range splitList(cfg.EnabledModules) {
+		module, err := registry.GetModule(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("enabled_modules: %v", err))
+			continue
+		}
+		validator, ok := module.(operatormodules.ConfigValidator)
+		if !ok {
+			continue
+		}
+		for _, err := range configref.Flatten(validator.ValidateConfig(cfg.Modules[name])) {
+			errs = append(errs, fmt.Errorf("modules.%s: %v", name, err))
+		}
+	}
+	return errs
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/petermein/apollo/internal/redact"
+	"github.com/spf13/cobra"
+)
+
+// rootCmd represents the base command when called without any subcommands
+var rootCmd = &cobra.Command{
+	Use:   "apollo",
+	Short: "Apollo - Privilege Management",
+	Long: `Apollo runs and sets up the privilege management services. The API server,
+operator and CLI are separate binaries; this one holds the commands that work
+across them.`,
+	SilenceUsage:  true,
+	SilenceErrors: true,
+}
+
+func init() {
+	rootCmd.AddCommand(devCmd)
+	rootCmd.AddCommand(configCmd)
+	rootCmd.AddCommand(initCmd)
+}
+
+func main() {
+	log.SetOutput(redact.NewWriter(os.Stderr))
+
+	if err := rootCmd.Execute(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+
+	apimodules "github.com/petermein/apollo/cmd/api/modules"
+	"github.com/petermein/apollo/cmd/api/store"
+	"github.com/petermein/apollo/cmd/operator/api"
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/credential"
+	"github.com/petermein/apollo/internal/events"
+)
+
+// devOperatorID identifies the mock operator of apollo dev
+const devOperatorID = "dev-operator"
+
+// devHealthInterval is how often the mock operator reports its health
+const devHealthInterval = 30 * time.Second
+
+// devOperator stands in for an operator managing the sample servers. It talks
+// to the API the way a real operator does, over HTTP and the bus, but
+// provisions grants by making up credentials instead of creating users.
+type devOperator struct {
+	client *api.Client
+	bus    bus.Bus
+}
+
+// newDevOperator creates a mock operator for the API at the URL
+func newDevOperator(apiURL string, b bus.Bus) *devOperator {
+	return &devOperator{client: api.NewClient(apiURL, devOperatorID, ""), bus: b}
+}
+
+// Start registers the operator and its servers, and handles jobs and
+// approvals until the context ends
+func (o *devOperator) Start(ctx context.Context) error {
+	if err := o.client.RegisterOperator(ctx); err != nil {
+		return err
+	}
+	for _, server := range devServers {
+		if err := o.client.RegisterServer(ctx, server); err != nil {
+			return fmt.Errorf("failed to register server %s: %v", server.Name, err)
+		}
+	}
+	if _, err := o.bus.Subscribe(bus.JobSubject("mysql"), bus.SubscribeOptions{}, o.handleJob); err != nil {
+		return err
+	}
+	if _, err := o.bus.Subscribe(bus.EventSubject(events.Approved), bus.SubscribeOptions{}, o.handleApproved); err != nil {
+		return err
+	}
+	log.Printf("Mock operator %s manages %d sample servers", devOperatorID, len(devServers))
+
+	go o.client.KeepTokenFresh(ctx)
+	go func() {
+		ticker := time.NewTicker(devHealthInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				health := []modules.ModuleHealth{{Name: "mysql", Healthy: true}}
+				if err := o.client.SendHealthCheck(ctx, health); err != nil {
+					log.Printf("Mock operator failed to send health check: %v", err)
+				}
+			}
+		}
+	}()
+	return nil
+}
+
+// handleApproved provisions the grant of an approved request by depositing
+// made up credentials for it
+func (o *devOperator) handleApproved(data []byte) {
+	var event struct {
+		RequestID string       `json:"request_id"`
+		Data      events.Grant `json:"data"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Data.GrantID == "" {
+		log.Printf("Mock operator ignoring malformed approved event: %v", err)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	grantID := event.Data.GrantID
+	password, err := credential.Generate()
+	if err != nil {
+		log.Printf("Mock operator failed to provision grant %s: %v", grantID, err)
+		return
+	}
+	hash := sha256.Sum256([]byte(grantID))
+	credentials := map[string]string{
+		"username": "apollo_" + hex.EncodeToString(hash[:8]),
+		"password": password,
+	}
+	if err := o.client.DepositCredentials(ctx, grantID, credentials); err != nil {
+		log.Printf("Mock operator failed to provision grant %s: %v", grantID, err)
+		return
+	}
+	log.Printf("Mock operator provisioned grant %s of request %s as %s", grantID, event.RequestID, credentials["username"])
+}
+
+// handleJob answers pings of the sample servers and completes revocations
+func (o *devOperator) handleJob(data []byte) {
+	var job bus.JobMessage
+	if err := json.Unmarshal(data, &job); err != nil {
+		log.Printf("Mock operator ignoring malformed job: %v", err)
+		return
+	}
+
+	result := bus.ResultMessage{ID: job.ID, OperatorID: devOperatorID, Status: "completed"}
+	switch job.Type {
+	case "ping":
+		var ping apimodules.PingRequest
+		if err := json.Unmarshal(job.Request, &ping); err != nil || !isDevServer(ping.Server) {
+			result.Status, result.Error = "failed", fmt.Sprintf("server %q is not managed by %s", ping.Server, devOperatorID)
+		} else {
+			result.Result = "pong in 0s"
+		}
+	case store.JobTypeRevoke:
+		var revoke apimodules.RevokeRequest
+		if err := json.Unmarshal(job.Request, &revoke); err != nil {
+			result.Status, result.Error = "failed", fmt.Sprintf("invalid revoke request: %v", err)
+		} else {
+			result.Result = fmt.Sprintf("revoked %s access of %s to %s", revoke.Level, revoke.UserID, revoke.ResourceID)
+		}
+	default:
+		result.Status, result.Error = "failed", fmt.Sprintf("unsupported job type %q", job.Type)
+	}
+
+	payload, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("Failed to marshal result of job %s: %v", job.ID, err)
+		return
+	}
+	if err := o.bus.Publish(context.Background(), bus.ResultSubject, payload); err != nil {
+		log.Printf("Failed to publish result of job %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Mock operator ran job %s (%s %s): %s", job.ID, job.Module, job.Type, result.Status)
+}
+
+// isDevServer reports whether the server is one of the sample servers
+func isDevServer(name string) bool {
+	for _, server := range devServers {
+		if server.Name == name {
+			return true
+		}
+	}
+	return false
+}
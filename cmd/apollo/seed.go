@@ -0,0 +1,26 @@
+package main
+
+import (
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/rules"
+)
+
+// devServers are the sample MySQL servers the mock operator registers. None
+// of them exists; the fake MySQL module answers for them.
+var devServers = []modules.ServerInfo{
+	{Name: "analytics-db", Host: "analytics-db.dev.internal", Port: 3306, User: "apollo", Database: "analytics", Tags: []string{"internal"}},
+	{Name: "orders-db", Host: "orders-db.dev.internal", Port: 3306, User: "apollo", Database: "orders", Tags: []string{"pii"}},
+	{Name: "payments-db", Host: "payments-db.dev.internal", Port: 3306, User: "apollo", Database: "payments", Tags: []string{"pci"}},
+}
+
+// devRules puts the sample servers in different tiers, so that one request
+// is approved by policy, one needs an approval and one needs two
+func devRules() *rules.Rules {
+	return &rules.Rules{
+		Resources: map[string]rules.Resource{
+			"analytics-db": {Tier: rules.TierPublic},
+			"orders-db":    {Tier: rules.TierInternal},
+			"payments-db":  {Tier: rules.TierRestricted},
+		},
+	}
+}
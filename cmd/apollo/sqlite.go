@@ -0,0 +1,7 @@
+//go:build sqlite
+
+package main
+
+// The sqlite build tag links in a pure Go SQLite driver, registered as
+// "sqlite", so apollo dev keeps its state in a file
+import _ "modernc.org/sqlite"
@@ -4,9 +4,16 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"net/http"
+	"net/url"
+	"strings"
 	"time"
+
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/transport"
+	"github.com/spf13/viper"
 )
 
 // Job represents a job from the API
@@ -38,6 +45,114 @@ type OperatorInfo struct {
 	UpdatedAt time.Time `json:"updated_at"`
 }
 
+// Grant represents an active privilege grant
+type Grant struct {
+	ID         string    `json:"id"`
+	UserID     string    `json:"user_id"`
+	ResourceID string    `json:"resource_id"`
+	Level      string    `json:"level"`
+	GrantedAt  time.Time `json:"granted_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	GrantedBy  string    `json:"granted_by"`
+	RequestID  string    `json:"request_id"`
+}
+
+// PrivilegeRequest represents a privilege escalation request
+type PrivilegeRequest struct {
+	ID          string     `json:"id"`
+	UserID      string     `json:"user_id"`
+	ResourceID  string     `json:"resource_id"`
+	Level       string     `json:"level"`
+	Reason      string     `json:"reason"`
+	RequestedAt time.Time  `json:"requested_at"`
+	ExpiresAt   time.Time  `json:"expires_at"`
+	ApprovedBy  string     `json:"approved_by,omitempty"`
+	ApprovedAt  *time.Time `json:"approved_at,omitempty"`
+	Status      string     `json:"status"`
+	StepUp      *StepUp    `json:"step_up,omitempty"`
+	Approvers   []string   `json:"approvers,omitempty"`
+	RiskScore   int        `json:"risk_score"`
+	RiskFactors []string   `json:"risk_factors,omitempty"`
+}
+
+// PrivilegeRequestInput is a privilege request to submit to the API
+type PrivilegeRequestInput struct {
+	Module     string `json:"module,omitempty"`
+	ResourceID string `json:"resource_id"`
+	Level      string `json:"level"`
+	Reason     string `json:"reason"`
+	Duration   string `json:"duration"`
+}
+
+// GrantCredentials are the credentials an operator created for a grant
+type GrantCredentials struct {
+	GrantID     string            `json:"grant_id"`
+	ExpiresAt   time.Time         `json:"expires_at"`
+	Credentials map[string]string `json:"credentials"`
+}
+
+// StepUp describes the fresh MFA assertion a request needs before approval
+type StepUp struct {
+	ACRValues  []string   `json:"acr_values,omitempty"`
+	MaxAge     int        `json:"max_age"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// PolicySimulation is a hypothetical privilege request evaluated by the API
+type PolicySimulation struct {
+	Module     string `json:"module,omitempty"`
+	ResourceID string `json:"resource_id"`
+	Level      string `json:"level"`
+	Reason     string `json:"reason"`
+	Duration   string `json:"duration"`
+}
+
+// PolicyDecision is the API's decision trace for a simulated request
+type PolicyDecision struct {
+	Allowed    bool `json:"allowed"`
+	Violations []struct {
+		Rule    string `json:"rule"`
+		Message string `json:"message"`
+	} `json:"violations"`
+	RequiredApprovals int      `json:"required_approvals"`
+	MaxDuration       string   `json:"max_duration"`
+	Notify            []string `json:"notify"`
+	Approvers         []string `json:"approvers"`
+	RiskScore         int      `json:"risk_score"`
+	RiskFactors       []string `json:"risk_factors"`
+	StepUp            *StepUp  `json:"step_up"`
+	Trace             []struct {
+		Rule    string `json:"rule"`
+		Result  string `json:"result"`
+		Message string `json:"message"`
+	} `json:"trace"`
+}
+
+// ExtensionRequest represents a request to extend an active grant
+type ExtensionRequest struct {
+	ID           string    `json:"id"`
+	GrantID      string    `json:"grant_id"`
+	Duration     string    `json:"duration"`
+	Reason       string    `json:"reason"`
+	Status       string    `json:"status"`
+	AutoApproved bool      `json:"auto_approved"`
+	Approvers    []string  `json:"approvers,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
+// HandoverRequest represents a request to hand a grant over to another user
+type HandoverRequest struct {
+	ID           string    `json:"id"`
+	GrantID      string    `json:"grant_id"`
+	To           string    `json:"to"`
+	Reason       string    `json:"reason"`
+	Status       string    `json:"status"`
+	AutoApproved bool      `json:"auto_approved"`
+	Approvers    []string  `json:"approvers,omitempty"`
+	NewGrantID   string    `json:"new_grant_id,omitempty"`
+	ExpiresAt    time.Time `json:"expires_at"`
+}
+
 // APIClient handles communication with the API server
 type APIClient struct {
 	baseURL    string
@@ -46,12 +161,21 @@ type APIClient struct {
 
 // NewAPIClient creates a new API client
 func NewAPIClient(baseURL string) *APIClient {
-	return &APIClient{
+	client := &APIClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: time.Second * 10,
+			Timeout:   time.Second * 10,
+			Transport: transport.New(),
 		},
 	}
+	token := viper.GetString("auth.token")
+	if token == "" {
+		token = savedToken()
+	}
+	if token != "" {
+		client.httpClient.Transport = &auth.BearerTransport{Token: token, Base: client.httpClient.Transport}
+	}
+	return client
 }
 
 // CreatePingJob creates a new ping job
@@ -80,7 +204,7 @@ func (c *APIClient) CreatePingJob(ctx context.Context, server string) (*Job, err
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, apiError(resp)
 	}
 
 	var job Job
@@ -105,7 +229,7 @@ func (c *APIClient) GetJob(ctx context.Context, jobID string) (*Job, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, apiError(resp)
 	}
 
 	var job Job
@@ -135,7 +259,7 @@ func (c *APIClient) WaitForJobCompletion(ctx context.Context, jobID string, poll
 			case "completed":
 				return job, nil
 			case "failed":
-				return nil, fmt.Errorf("job failed: %s", job.Error)
+				return nil, withExitCode(ExitProvisioningFailed, fmt.Errorf("job failed: %s", job.Error))
 			}
 		}
 	}
@@ -155,7 +279,7 @@ func (c *APIClient) ListMySQLServers(ctx context.Context) ([]ServerInfo, error)
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, apiError(resp)
 	}
 
 	var servers []ServerInfo
@@ -173,7 +297,7 @@ func (c *APIClient) ListOperators(ctx context.Context) ([]OperatorInfo, error) {
 		return nil, fmt.Errorf("failed to create request: %v", err)
 	}
 
-	fmt.Printf("Sending request to %s\n", req.URL.String())
+	infof("Sending request to %s\n", req.URL.String())
 	resp, err := c.httpClient.Do(req)
 	if err != nil {
 		return nil, fmt.Errorf("failed to send request: %v", err)
@@ -181,13 +305,7 @@ func (c *APIClient) ListOperators(ctx context.Context) ([]OperatorInfo, error) {
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		var errBody struct {
-			Error string `json:"error"`
-		}
-		if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil && errBody.Error != "" {
-			return nil, fmt.Errorf("unexpected status code: %d, error: %s", resp.StatusCode, errBody.Error)
-		}
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return nil, apiError(resp)
 	}
 
 	var operators []OperatorInfo
@@ -195,6 +313,436 @@ func (c *APIClient) ListOperators(ctx context.Context) ([]OperatorInfo, error) {
 		return nil, fmt.Errorf("failed to decode response: %v", err)
 	}
 
-	fmt.Printf("Successfully retrieved %d operators\n", len(operators))
+	infof("Successfully retrieved %d operators\n", len(operators))
 	return operators, nil
 }
+
+// ExtendGrant requests an extension of an active grant
+func (c *APIClient) ExtendGrant(ctx context.Context, grantID, duration, reason string) (*ExtensionRequest, error) {
+	req := struct {
+		Duration string `json:"duration"`
+		Reason   string `json:"reason"`
+	}{
+		Duration: duration,
+		Reason:   reason,
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/grants/%s/extend", c.baseURL, url.PathEscape(grantID)), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, apiError(resp)
+	}
+
+	var extension ExtensionRequest
+	if err := json.NewDecoder(resp.Body).Decode(&extension); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &extension, nil
+}
+
+// HandOverGrant requests a handover of an active grant to another user
+func (c *APIClient) HandOverGrant(ctx context.Context, grantID, to, reason string) (*HandoverRequest, error) {
+	req := struct {
+		To     string `json:"to"`
+		Reason string `json:"reason"`
+	}{
+		To:     to,
+		Reason: reason,
+	}
+
+	body, err := json.Marshal(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/grants/%s/handover", c.baseURL, url.PathEscape(grantID)), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusCreated {
+		return nil, apiError(resp)
+	}
+
+	var handover HandoverRequest
+	if err := json.NewDecoder(resp.Body).Decode(&handover); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &handover, nil
+}
+
+// ListGrants retrieves the caller's active grants or, for administrators
+// with allUsers, everyone's
+func (c *APIClient) ListGrants(ctx context.Context, allUsers bool) ([]Grant, error) {
+	endpoint := fmt.Sprintf("%s/api/v1/grants", c.baseURL)
+	if allUsers {
+		endpoint += "?all_users=true"
+	}
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var grants []Grant
+	if err := json.NewDecoder(resp.Body).Decode(&grants); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return grants, nil
+}
+
+// ListRequests retrieves the privilege requests visible to the caller
+func (c *APIClient) ListRequests(ctx context.Context) ([]PrivilegeRequest, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v1/privileges/requests", c.baseURL), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var requests []PrivilegeRequest
+	if err := json.NewDecoder(resp.Body).Decode(&requests); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return requests, nil
+}
+
+// GetRequest retrieves a privilege request by ID
+func (c *APIClient) GetRequest(ctx context.Context, requestID string) (*PrivilegeRequest, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", fmt.Sprintf("%s/api/v1/privileges/%s", c.baseURL, url.PathEscape(requestID)), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var request PrivilegeRequest
+	if err := json.NewDecoder(resp.Body).Decode(&request); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &request, nil
+}
+
+// CreateRequest submits a privilege request
+func (c *APIClient) CreateRequest(ctx context.Context, input PrivilegeRequestInput) (*PrivilegeRequest, error) {
+	body, err := json.Marshal(input)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/privileges/request", c.baseURL), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusCreated {
+		return nil, apiError(resp)
+	}
+
+	var request PrivilegeRequest
+	if err := json.NewDecoder(resp.Body).Decode(&request); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &request, nil
+}
+
+// GetCredentials retrieves the credentials of a grant held by the caller. The
+// API hands them out only once; a 404 APIError means the operator hasn't
+// deposited them yet or they were retrieved already.
+func (c *APIClient) GetCredentials(ctx context.Context, grantID string) (*GrantCredentials, error) {
+	// The grant's credentials are redeemed with a short-lived reference
+	var reference struct {
+		Reference string `json:"reference"`
+	}
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/api/v1/grants/%s/credentials", c.baseURL, url.PathEscape(grantID)), &reference); err != nil {
+		return nil, err
+	}
+
+	var credentials GrantCredentials
+	if err := c.getJSON(ctx, fmt.Sprintf("%s/api/v1/credentials/%s", c.baseURL, url.PathEscape(reference.Reference)), &credentials); err != nil {
+		return nil, err
+	}
+
+	return &credentials, nil
+}
+
+// WaitForCredentials polls for the credentials of a grant until the operator
+// has deposited them
+func (c *APIClient) WaitForCredentials(ctx context.Context, grantID string, pollInterval time.Duration) (*GrantCredentials, error) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+
+	for {
+		credentials, err := c.GetCredentials(ctx, grantID)
+		var apiErr *APIError
+		if !errors.As(err, &apiErr) || apiErr.StatusCode != http.StatusNotFound {
+			return credentials, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+	}
+}
+
+// getJSON sends a GET request and decodes the JSON response into output
+func (c *APIClient) getJSON(ctx context.Context, target string, output interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return nil
+}
+
+// RevokeGrant revokes an active grant by ID
+func (c *APIClient) RevokeGrant(ctx context.Context, grantID string) error {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/grants/%s/revoke", c.baseURL, url.PathEscape(grantID)), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	return nil
+}
+
+// SimulateRequest evaluates a hypothetical request against the policies without creating it
+func (c *APIClient) SimulateRequest(ctx context.Context, simulation PolicySimulation) (*PolicyDecision, error) {
+	body, err := json.Marshal(simulation)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/policies/simulate", c.baseURL), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var decision PolicyDecision
+	if err := json.NewDecoder(resp.Body).Decode(&decision); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &decision, nil
+}
+
+// PolicyTestReport is the outcome of a policy test suite run by the API
+type PolicyTestReport struct {
+	PolicyVersion int `json:"policy_version"`
+	Passed        int `json:"passed"`
+	Failed        int `json:"failed"`
+	Results       []struct {
+		Name     string   `json:"name"`
+		Passed   bool     `json:"passed"`
+		Failures []string `json:"failures"`
+	} `json:"results"`
+}
+
+// RunPolicyTests runs a YAML or JSON policy test suite against the policies loaded by the API
+func (c *APIClient) RunPolicyTests(ctx context.Context, suite []byte) (*PolicyTestReport, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/policies/test", c.baseURL), bytes.NewReader(suite))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/yaml")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, apiError(resp)
+	}
+
+	var report PolicyTestReport
+	if err := json.NewDecoder(resp.Body).Decode(&report); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+
+	return &report, nil
+}
+
+// SubmitStepUp attaches a fresh ID token to a request as step-up proof
+func (c *APIClient) SubmitStepUp(ctx context.Context, requestID, idToken string) error {
+	body, err := json.Marshal(map[string]string{"id_token": idToken})
+	if err != nil {
+		return fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", fmt.Sprintf("%s/api/v1/privileges/%s/step-up", c.baseURL, url.PathEscape(requestID)), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return apiError(resp)
+	}
+
+	return nil
+}
+
+// RevokeCurrentSession revokes the session of the token the client authenticates with
+func (c *APIClient) RevokeCurrentSession(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", fmt.Sprintf("%s/api/v1/sessions/current", c.baseURL), nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return apiError(resp)
+	}
+
+	return nil
+}
+
+// APIError represents a non-successful response from the API server
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.StatusCode == http.StatusUnprocessableEntity && e.Message != "" {
+		return fmt.Sprintf("request rejected by policy: %s", e.Message)
+	}
+	if e.Message != "" {
+		return fmt.Sprintf("unexpected status code: %d, error: %s", e.StatusCode, e.Message)
+	}
+	return fmt.Sprintf("unexpected status code: %d", e.StatusCode)
+}
+
+// apiError builds an error from a non-successful API response, including the
+// error message returned by the server when there is one
+func apiError(resp *http.Response) error {
+	var errBody struct {
+		Error      string `json:"error"`
+		Violations []struct {
+			Rule    string `json:"rule"`
+			Message string `json:"message"`
+		} `json:"violations"`
+	}
+	apiErr := &APIError{StatusCode: resp.StatusCode}
+	if err := json.NewDecoder(resp.Body).Decode(&errBody); err == nil {
+		apiErr.Message = errBody.Error
+		// Policy violations carry one message per violated rule
+		if len(errBody.Violations) > 0 {
+			messages := make([]string, 0, len(errBody.Violations))
+			for _, v := range errBody.Violations {
+				messages = append(messages, v.Message)
+			}
+			apiErr.Message = strings.Join(messages, "; ")
+		}
+	}
+	return apiErr
+}
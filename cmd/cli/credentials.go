@@ -0,0 +1,289 @@
+package main
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// passphraseEnv names the environment variable holding the passphrase the
+// credentials file is encrypted with; the machine secret is used without it
+const passphraseEnv = "APOLLO_CLI_PASSPHRASE"
+
+// Key derivations of the credentials file
+const (
+	kdfPassphrase = "pbkdf2-sha256"
+	kdfMachine    = "machine-hkdf-sha256"
+)
+
+// passphraseIterations is the PBKDF2 work factor for passphrases
+const passphraseIterations = 600000
+
+// credentials is what the CLI keeps to authenticate with the API
+type credentials struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at,omitempty"`
+}
+
+// sealedCredentials is the credentials file on disk: the credentials
+// encrypted with AES-256-GCM under a key derived from a passphrase or from
+// the machine's ID and the user
+type sealedCredentials struct {
+	Version    int    `json:"version"`
+	KDF        string `json:"kdf"`
+	Salt       string `json:"salt"`
+	Iterations int    `json:"iterations,omitempty"`
+	Nonce      string `json:"nonce"`
+	Ciphertext string `json:"ciphertext"`
+}
+
+// credentialsPath returns ~/.apollo-cli/credentials.json
+func credentialsPath() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".apollo-cli", "credentials.json"), nil
+}
+
+// loadCredentials reads the credentials file, returning nil when there is
+// none. A file written in plaintext by an older version is encrypted in place.
+func loadCredentials() (*credentials, error) {
+	path, err := credentialsPath()
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read credentials: %v", err)
+	}
+
+	var sealed sealedCredentials
+	if err := json.Unmarshal(data, &sealed); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %v", err)
+	}
+	if sealed.Ciphertext == "" {
+		var plain credentials
+		if err := json.Unmarshal(data, &plain); err != nil || plain.Token == "" {
+			return nil, fmt.Errorf("credentials file %s is neither encrypted nor a token", path)
+		}
+		if err := saveCredentials(&plain); err != nil {
+			return nil, fmt.Errorf("failed to encrypt plaintext credentials: %v", err)
+		}
+		fmt.Fprintf(os.Stderr, "Encrypted the plaintext credentials in %s\n", path)
+		return &plain, nil
+	}
+	return openCredentials(&sealed)
+}
+
+// saveCredentials encrypts the credentials and writes them, readable only by the user
+func saveCredentials(creds *credentials) error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	sealed, err := sealCredentials(creds)
+	if err != nil {
+		return err
+	}
+	data, err := json.MarshalIndent(sealed, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create %s: %v", filepath.Dir(path), err)
+	}
+	// Replace the file at once so a crash can't leave it half written
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".credentials-*")
+	if err != nil {
+		return fmt.Errorf("failed to write credentials: %v", err)
+	}
+	defer os.Remove(tmp.Name())
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %v", err)
+	}
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("failed to write credentials: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to write credentials: %v", err)
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// removeCredentials deletes the credentials file
+func removeCredentials() error {
+	path, err := credentialsPath()
+	if err != nil {
+		return err
+	}
+	if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+// sealCredentials encrypts the credentials with a fresh salt and nonce
+func sealCredentials(creds *credentials) (*sealedCredentials, error) {
+	sealed := &sealedCredentials{Version: 1, KDF: kdfMachine}
+	if os.Getenv(passphraseEnv) != "" {
+		sealed.KDF = kdfPassphrase
+		sealed.Iterations = passphraseIterations
+	}
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return nil, fmt.Errorf("failed to generate salt: %v", err)
+	}
+	sealed.Salt = base64.StdEncoding.EncodeToString(salt)
+
+	aead, err := credentialsCipher(sealed)
+	if err != nil {
+		return nil, err
+	}
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	plaintext, err := json.Marshal(creds)
+	if err != nil {
+		return nil, err
+	}
+	sealed.Nonce = base64.StdEncoding.EncodeToString(nonce)
+	sealed.Ciphertext = base64.StdEncoding.EncodeToString(aead.Seal(nil, nonce, plaintext, sealed.additionalData()))
+	return sealed, nil
+}
+
+// openCredentials decrypts the credentials file
+func openCredentials(sealed *sealedCredentials) (*credentials, error) {
+	if sealed.Version != 1 {
+		return nil, fmt.Errorf("unsupported credentials file version %d", sealed.Version)
+	}
+	aead, err := credentialsCipher(sealed)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := base64.StdEncoding.DecodeString(sealed.Nonce)
+	if err != nil || len(nonce) != aead.NonceSize() {
+		return nil, fmt.Errorf("invalid credentials nonce")
+	}
+	ciphertext, err := base64.StdEncoding.DecodeString(sealed.Ciphertext)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credentials ciphertext: %v", err)
+	}
+	plaintext, err := aead.Open(nil, nonce, ciphertext, sealed.additionalData())
+	if err != nil {
+		if sealed.KDF == kdfPassphrase {
+			return nil, fmt.Errorf("failed to decrypt credentials: wrong passphrase in %s", passphraseEnv)
+		}
+		return nil, fmt.Errorf("failed to decrypt credentials: they were saved on another machine or by another user, run apollo-cli login")
+	}
+	var creds credentials
+	if err := json.Unmarshal(plaintext, &creds); err != nil {
+		return nil, fmt.Errorf("failed to parse credentials: %v", err)
+	}
+	return &creds, nil
+}
+
+// additionalData binds the ciphertext to the file's version and key derivation
+func (s *sealedCredentials) additionalData() []byte {
+	return []byte(fmt.Sprintf("apollo-cli-credentials-v%d\n%s\n%d", s.Version, s.KDF, s.Iterations))
+}
+
+// credentialsCipher derives the file's key and returns its AES-GCM cipher
+func credentialsCipher(sealed *sealedCredentials) (cipher.AEAD, error) {
+	salt, err := base64.StdEncoding.DecodeString(sealed.Salt)
+	if err != nil || len(salt) == 0 {
+		return nil, fmt.Errorf("invalid credentials salt")
+	}
+
+	var key []byte
+	switch sealed.KDF {
+	case kdfPassphrase:
+		passphrase := os.Getenv(passphraseEnv)
+		if passphrase == "" {
+			return nil, fmt.Errorf("credentials are encrypted with a passphrase, set %s", passphraseEnv)
+		}
+		if sealed.Iterations < 100000 {
+			return nil, fmt.Errorf("credentials file asks for too few iterations (%d)", sealed.Iterations)
+		}
+		key, err = pbkdf2.Key(sha256.New, passphrase, salt, sealed.Iterations, 32)
+	case kdfMachine:
+		var secret []byte
+		if secret, err = machineSecret(); err != nil {
+			return nil, fmt.Errorf("%v; set %s to encrypt credentials with a passphrase instead", err, passphraseEnv)
+		}
+		key, err = hkdf.Key(sha256.New, secret, salt, "apollo-cli credentials", 32)
+	default:
+		return nil, fmt.Errorf("unsupported credentials key derivation %q", sealed.KDF)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to derive credentials key: %v", err)
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// platformUUID finds the hardware UUID in ioreg output on macOS
+var platformUUID = regexp.MustCompile(`"IOPlatformUUID" = "([^"]+)"`)
+
+// machineGUID finds the machine GUID in reg output on Windows
+var machineGUID = regexp.MustCompile(`MachineGuid\s+REG_SZ\s+(\S+)`)
+
+// machineSecret returns the machine's ID together with the user's, so a
+// copied credentials file can't be decrypted by another user or elsewhere
+func machineSecret() ([]byte, error) {
+	var id string
+	switch runtime.GOOS {
+	case "darwin":
+		out, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+		if match := platformUUID.FindSubmatch(out); err == nil && match != nil {
+			id = string(match[1])
+		}
+	case "windows":
+		out, err := exec.Command("reg", "query", `HKLM\SOFTWARE\Microsoft\Cryptography`, "/v", "MachineGuid").Output()
+		if match := machineGUID.FindSubmatch(out); err == nil && match != nil {
+			id = string(match[1])
+		}
+	default:
+		for _, path := range []string{"/etc/machine-id", "/var/lib/dbus/machine-id"} {
+			if data, err := os.ReadFile(path); err == nil {
+				id = strings.TrimSpace(string(data))
+				break
+			}
+		}
+	}
+	if id == "" {
+		return nil, fmt.Errorf("failed to read the machine ID")
+	}
+
+	current, err := user.Current()
+	if err != nil {
+		return nil, fmt.Errorf("failed to look up the current user: %v", err)
+	}
+	return bytes.Join([][]byte{[]byte(id), []byte(current.Uid), []byte(current.Username)}, []byte("\n")), nil
+}
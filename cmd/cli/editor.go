@@ -0,0 +1,104 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// reasonHints are shown in the editor template to help users write justifications auditors can use
+var reasonHints = []string{
+	"Explain what you need to do and why elevated access is required.",
+	"Reference a ticket, incident or change request where possible.",
+	"Requests with vague reasons (e.g. \"debugging\") are likely to be denied.",
+}
+
+// resolveReason returns the given reason, or opens the user's editor to collect one when it is empty
+func resolveReason(reason string, details [][2]string) (string, error) {
+	if strings.TrimSpace(reason) != "" {
+		return reason, nil
+	}
+	return editReason(details)
+}
+
+// editReason opens $VISUAL or $EDITOR with a git-commit style template and returns the entered reason
+func editReason(details [][2]string) (string, error) {
+	file, err := os.CreateTemp("", "apollo-reason-*.txt")
+	if err != nil {
+		return "", fmt.Errorf("failed to create reason file: %v", err)
+	}
+	defer os.Remove(file.Name())
+
+	if _, err := file.WriteString(reasonTemplate(details)); err != nil {
+		file.Close()
+		return "", fmt.Errorf("failed to write reason template: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("failed to write reason template: %v", err)
+	}
+
+	editor := os.Getenv("VISUAL")
+	if editor == "" {
+		editor = os.Getenv("EDITOR")
+	}
+	if editor == "" {
+		editor = "vi"
+	}
+
+	parts := strings.Fields(editor)
+	cmd := exec.Command(parts[0], append(parts[1:], file.Name())...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("failed to run editor %q: %v (use --reason to provide a reason)", editor, err)
+	}
+
+	data, err := os.ReadFile(file.Name())
+	if err != nil {
+		return "", fmt.Errorf("failed to read reason file: %v", err)
+	}
+
+	reason := stripComments(string(data))
+	if reason == "" {
+		return "", fmt.Errorf("aborting request due to empty reason")
+	}
+	return reason, nil
+}
+
+// reasonTemplate builds the editor template with request details and policy hints as comments
+func reasonTemplate(details [][2]string) string {
+	var b strings.Builder
+	b.WriteString("\n")
+	b.WriteString("# Please enter the reason for this request. Lines starting\n")
+	b.WriteString("# with '#' will be ignored, and an empty reason aborts the request.\n")
+	b.WriteString("#\n")
+	if len(details) > 0 {
+		b.WriteString("# Request details:\n")
+		for _, detail := range details {
+			fmt.Fprintf(&b, "#   %-10s %s\n", detail[0]+":", detail[1])
+		}
+		b.WriteString("#\n")
+	}
+	b.WriteString("# Hints:\n")
+	for _, hint := range reasonHints {
+		fmt.Fprintf(&b, "#   %s\n", hint)
+	}
+	return b.String()
+}
+
+// stripComments removes comment lines and surrounding whitespace from editor output
+func stripComments(text string) string {
+	var lines []string
+	scanner := bufio.NewScanner(strings.NewReader(text))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.HasPrefix(strings.TrimSpace(line), "#") {
+			continue
+		}
+		lines = append(lines, strings.TrimRight(line, " \t"))
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+)
+
+// Exit codes returned by the CLI. These are part of the CLI's public contract
+// so that runbooks and CI jobs can branch on the outcome; do not renumber them.
+const (
+	// ExitOK indicates success
+	ExitOK = 0
+	// ExitError indicates a generic or unexpected failure, including usage errors
+	ExitError = 1
+	// ExitDenied indicates the request was denied by an approver
+	ExitDenied = 3
+	// ExitPolicyViolation indicates the request violates a security policy rule
+	ExitPolicyViolation = 4
+	// ExitApprovalTimeout indicates the CLI gave up waiting for approval
+	ExitApprovalTimeout = 5
+	// ExitProvisioningFailed indicates the grant was approved but provisioning on the target failed
+	ExitProvisioningFailed = 6
+	// ExitAuthFailed indicates authentication with the API failed
+	ExitAuthFailed = 7
+	// ExitForbidden indicates the API refused the caller the operation
+	ExitForbidden = 8
+)
+
+// exitError attaches an exit code to an error
+type exitError struct {
+	code int
+	err  error
+}
+
+func (e *exitError) Error() string {
+	return e.err.Error()
+}
+
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// withExitCode wraps an error so the CLI exits with the given code
+func withExitCode(code int, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &exitError{code: code, err: err}
+}
+
+// exitCode determines the process exit code for an error returned by a command
+func exitCode(err error) int {
+	if err == nil {
+		return ExitOK
+	}
+
+	var exitErr *exitError
+	if errors.As(err, &exitErr) {
+		return exitErr.code
+	}
+
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		switch apiErr.StatusCode {
+		case http.StatusUnauthorized:
+			return ExitAuthFailed
+		case http.StatusForbidden:
+			return ExitForbidden
+		case http.StatusUnprocessableEntity:
+			return ExitPolicyViolation
+		}
+	}
+
+	return ExitError
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	extendDuration string
+	extendReason   string
+)
+
+var extendCmd = &cobra.Command{
+	Use:   "extend [grant-id]",
+	Short: "Request an extension of an active grant",
+	Long: `Extend requests additional time on an active grant.
+Depending on policy the extension is either approved automatically or routed to approvers.
+Example:
+  apollo-cli extend grant_123 --duration 1h --reason "Incident still ongoing"`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		grantID := args[0]
+
+		if err := validateDuration(extendDuration); err != nil {
+			return fmt.Errorf("invalid duration format: %v", err)
+		}
+
+		// Collect the reason in the editor when not given on the command line
+		reason, err := resolveReason(extendReason, [][2]string{
+			{"Grant", grantID},
+			{"Duration", extendDuration},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		// Request the extension
+		extension, err := client.ExtendGrant(cmd.Context(), grantID, extendDuration, reason)
+		if err != nil {
+			return fmt.Errorf("failed to extend grant: %w", err)
+		}
+
+		printID(extension.ID)
+		infof("Extension request %s for grant %s\n", extension.ID, extension.GrantID)
+		infof("Duration: %s\n", extension.Duration)
+
+		switch {
+		case extension.AutoApproved:
+			infof("Status:   %s (auto-approved by policy)\n", extension.Status)
+			if !extension.ExpiresAt.IsZero() {
+				infof("Expires:  %s\n", formatExpiry(extension.ExpiresAt))
+			}
+		case len(extension.Approvers) > 0:
+			infof("Status:   %s (routed to approvers)\n", extension.Status)
+			infof("Approvers: %s\n", strings.Join(extension.Approvers, ", "))
+		default:
+			infof("Status:   %s\n", extension.Status)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	extendCmd.Flags().StringVar(&extendDuration, "duration", "1h", "Additional duration to request (e.g., 1h, 30m)")
+	extendCmd.Flags().StringVar(&extendReason, "reason", "", "Reason for the extension (opens $EDITOR when omitted)")
+}
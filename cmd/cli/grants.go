@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+)
+
+// grantsAllUsers lists the grants of every user
+var grantsAllUsers bool
+
+var grantsCmd = &cobra.Command{
+	Use:   "grants",
+	Short: "List active grants",
+	Long: `List your active grants with their expiration shown in local time and relative to now.
+Administrators list everyone's with --all-users.
+Example:
+  apollo-cli grants
+  apollo-cli grants --all-users --utc`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		grants, err := client.ListGrants(cmd.Context(), grantsAllUsers)
+		if err != nil {
+			return fmt.Errorf("failed to list grants: %w", err)
+		}
+
+		// Print grants in a table format
+		infof("\nActive Grants:\n")
+		infof("--------------\n")
+		for _, grant := range grants {
+			printID(grant.ID)
+			infof("ID:       %s\n", grant.ID)
+			infof("User:     %s\n", grant.UserID)
+			infof("Resource: %s\n", grant.ResourceID)
+			infof("Level:    %s\n", grant.Level)
+			infof("Granted:  %s\n", formatSince(grant.GrantedAt))
+			infof("Expires:  %s\n", formatExpiry(grant.ExpiresAt))
+			infof("--------------\n")
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	grantsCmd.Flags().BoolVar(&grantsAllUsers, "all-users", false, "List the grants of every user (administrators only)")
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/spf13/cobra"
+)
+
+var handoverReason string
+
+var handoverCmd = &cobra.Command{
+	Use:   "handover [grant-id] [user]",
+	Short: "Hand an active grant over to another user",
+	Long: `Handover passes the rest of an active grant to another user, e.g. at a shift change during an incident.
+Once the handover is approved, by policy or by approvers, the current credentials are revoked and
+fresh ones are issued to the new holder until the grant's original expiry.
+Example:
+  apollo-cli handover grant_123 bob --reason "Shift change, bob takes over the incident"`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		grantID, to := args[0], args[1]
+
+		// Collect the reason in the editor when not given on the command line
+		reason, err := resolveReason(handoverReason, [][2]string{
+			{"Grant", grantID},
+			{"To", to},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		// Request the handover
+		handover, err := client.HandOverGrant(cmd.Context(), grantID, to, reason)
+		if err != nil {
+			return fmt.Errorf("failed to hand over grant: %w", err)
+		}
+
+		printID(handover.ID)
+		infof("Handover request %s of grant %s to %s\n", handover.ID, handover.GrantID, handover.To)
+
+		switch {
+		case handover.AutoApproved:
+			infof("Status:   %s (auto-approved by policy)\n", handover.Status)
+			if handover.NewGrantID != "" {
+				infof("Grant:    %s\n", handover.NewGrantID)
+			}
+			if !handover.ExpiresAt.IsZero() {
+				infof("Expires:  %s\n", formatExpiry(handover.ExpiresAt))
+			}
+		case len(handover.Approvers) > 0:
+			infof("Status:   %s (routed to approvers)\n", handover.Status)
+			infof("Approvers: %s\n", strings.Join(handover.Approvers, ", "))
+		default:
+			infof("Status:   %s\n", handover.Status)
+		}
+
+		return nil
+	},
+}
+
+func init() {
+	handoverCmd.Flags().StringVar(&handoverReason, "reason", "", "Reason for the handover (opens $EDITOR when omitted)")
+}
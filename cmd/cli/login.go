@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/transport"
+	"github.com/spf13/cobra"
+)
+
+var loginToken string
+
+var loginCmd = &cobra.Command{
+	Use:   "login",
+	Short: "Log in and keep the token in the encrypted credentials file",
+	Long: `Login authenticates with your identity provider and keeps the ID token in
+~/.apollo-cli/credentials.json, encrypted with a key derived from the machine
+and your user, or from a passphrase when APOLLO_CLI_PASSPHRASE is set. A token
+can be stored instead with --token, or read from stdin with --token -.
+Example:
+  apollo-cli login
+  apollo-cli login --token - < token.txt`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		token := loginToken
+		if token == "-" {
+			line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+			if err != nil && line == "" {
+				return fmt.Errorf("failed to read token from stdin: %w", err)
+			}
+			token = line
+		}
+		token = strings.TrimSpace(token)
+		if token == "" {
+			idToken, err := oidcLogin(cmd.Context(), oidcLoginOptions{})
+			if err != nil {
+				return withExitCode(ExitAuthFailed, fmt.Errorf("login failed: %w", err))
+			}
+			token = idToken
+		}
+
+		creds := &credentials{Token: token, ExpiresAt: tokenExpiry(token)}
+		if err := saveCredentials(creds); err != nil {
+			return fmt.Errorf("failed to save credentials: %w", err)
+		}
+		path, _ := credentialsPath()
+		if creds.ExpiresAt.IsZero() {
+			infof("Logged in; credentials saved to %s\n", path)
+		} else {
+			infof("Logged in until %s; credentials saved to %s\n", formatTime(creds.ExpiresAt), path)
+		}
+		return nil
+	},
+}
+
+var logoutCmd = &cobra.Command{
+	Use:   "logout",
+	Short: "Revoke the session on the server and remove the saved credentials",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if creds, err := loadCredentials(); err == nil && creds != nil {
+			// The file is removed even when the server can't be reached
+			client := NewAPIClient(apiEndpoint)
+			client.httpClient.Transport = &auth.BearerTransport{Token: creds.Token, Base: transport.New()}
+			if err := client.RevokeCurrentSession(cmd.Context()); err != nil {
+				fmt.Fprintf(os.Stderr, "Warning: failed to revoke the session on the server: %v\n", err)
+			}
+		}
+		if err := removeCredentials(); err != nil {
+			return fmt.Errorf("failed to remove credentials: %w", err)
+		}
+		infoln("Logged out")
+		return nil
+	},
+}
+
+func init() {
+	loginCmd.Flags().StringVar(&loginToken, "token", "", "Token to save instead of logging in with the identity provider, - reads it from stdin")
+}
+
+// savedToken returns the token from the credentials file, if there is one
+// that hasn't expired
+func savedToken() string {
+	creds, err := loadCredentials()
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Warning: %v\n", err)
+		return ""
+	}
+	if creds == nil {
+		return ""
+	}
+	if !creds.ExpiresAt.IsZero() && time.Now().After(creds.ExpiresAt) {
+		fmt.Fprintln(os.Stderr, "Warning: saved credentials have expired, run apollo-cli login")
+		return ""
+	}
+	return creds.Token
+}
+
+// tokenExpiry returns the expiry of a JWT, or zero for other tokens
+func tokenExpiry(token string) time.Time {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return time.Time{}
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return time.Time{}
+	}
+	var claims struct {
+		Exp int64 `json:"exp"`
+	}
+	if json.Unmarshal(payload, &claims) != nil || claims.Exp == 0 {
+		return time.Time{}
+	}
+	return time.Unix(claims.Exp, 0)
+}
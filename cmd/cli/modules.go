@@ -1,6 +1,8 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"time"
 
@@ -18,9 +20,83 @@ var mysqlGrantCmd = &cobra.Command{
 	Use:   "grant",
 	Short: "Grant MySQL database access",
 	Long: `Grant temporary access to a MySQL database with specified privileges.
+The server is named with --server or found among the registered servers by --host and --port;
+access is requested to the server, and --database only sets the database to connect to.
+The request is submitted and, unless --no-wait is given, followed until it is approved
+and the operator has created the user, whose credentials are then printed once.
 Example: apollo-cli mysql grant --host db.example.com --database mydb --level read --duration 1h`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// TODO: Implement MySQL grant logic
+		if err := validateAccessLevel(mysqlLevel); err != nil {
+			return err
+		}
+		parsedDuration, err := time.ParseDuration(mysqlDuration)
+		if err != nil {
+			return fmt.Errorf("invalid duration format: %v", err)
+		}
+
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		server, err := resolveMySQLServer(cmd.Context(), client, mysqlServer, mysqlHost, mysqlPort)
+		if err != nil {
+			return err
+		}
+
+		// Collect the reason in the editor when not given on the command line
+		reason, err := resolveReason(mysqlReason, [][2]string{
+			{"Server", server.Name},
+			{"Database", mysqlDatabase},
+			{"Level", mysqlLevel},
+			{"Duration", parsedDuration.String()},
+		})
+		if err != nil {
+			return err
+		}
+
+		request, err := client.CreateRequest(cmd.Context(), PrivilegeRequestInput{
+			Module:     "mysql",
+			ResourceID: server.Name,
+			Level:      mysqlLevel,
+			Reason:     reason,
+			Duration:   parsedDuration.String(),
+		})
+		if err != nil {
+			return fmt.Errorf("failed to submit request: %w", err)
+		}
+		infof("Submitted request %s for %s access to %s\n", request.ID, request.Level, request.ResourceID)
+		printRequest(request)
+		if mysqlNoWait {
+			printID(request.ID)
+			return nil
+		}
+
+		ctx, cancel := context.WithTimeout(cmd.Context(), mysqlTimeout)
+		defer cancel()
+		if request.Status == "pending" {
+			infof("Waiting for approval, up to %s\n", humanDuration(mysqlTimeout))
+		}
+		grant, err := waitForGrant(ctx, client, request)
+		if err != nil {
+			return err
+		}
+		infof("Grant %s is active until %s, waiting for the operator to create the user\n", grant.ID, formatTime(grant.ExpiresAt))
+
+		provisionCtx, cancelProvision := context.WithTimeout(cmd.Context(), provisionTimeout)
+		defer cancelProvision()
+		credentials, err := client.WaitForCredentials(provisionCtx, grant.ID, grantPollInterval)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return withExitCode(ExitProvisioningFailed, fmt.Errorf("no credentials for grant %s after %s; the operator may have failed to create the user", grant.ID, humanDuration(provisionTimeout)))
+		}
+		if err != nil {
+			return fmt.Errorf("failed to retrieve credentials: %w", err)
+		}
+
+		dsn := printMySQLCredentials(server, mysqlDatabase, credentials)
+		if dsn != "" {
+			printID(dsn)
+		} else {
+			printID(grant.ID)
+		}
 		return nil
 	},
 }
@@ -28,9 +104,79 @@ Example: apollo-cli mysql grant --host db.example.com --database mydb --level re
 var mysqlRevokeCmd = &cobra.Command{
 	Use:   "revoke",
 	Short: "Revoke MySQL database access",
-	Long:  `Revoke previously granted MySQL database access.`,
+	Long: `Revoke previously granted MySQL database access.
+The grant is named with --grant-id; without one, your active grants on the server named
+with --server, or found by --host and --port, are revoked after a confirmation summary.
+Administrators revoke every user's grants on it with --all-users.
+The operator drops the grants' users once they are revoked.
+Examples:
+  apollo-cli mysql revoke --grant-id grant_123
+  apollo-cli mysql revoke --server orders-db --all-users`,
 	RunE: func(cmd *cobra.Command, args []string) error {
-		// TODO: Implement MySQL revoke logic
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		if mysqlGrantID != "" {
+			if err := client.RevokeGrant(cmd.Context(), mysqlGrantID); err != nil {
+				return fmt.Errorf("failed to revoke grant: %w", err)
+			}
+			infof("Revoked %s\n", mysqlGrantID)
+			printID(mysqlGrantID)
+			return nil
+		}
+
+		server, err := resolveMySQLServer(cmd.Context(), client, mysqlServer, mysqlHost, mysqlPort)
+		if err != nil {
+			return err
+		}
+		grants, err := client.ListGrants(cmd.Context(), mysqlRevokeAllUsers)
+		if err != nil {
+			return fmt.Errorf("failed to list grants: %w", err)
+		}
+		selected := selectGrants(grants, nil, server.Name, time.Time{})
+		if len(selected) == 0 {
+			infof("No active grants on %s\n", server.Name)
+			return nil
+		}
+
+		// Print confirmation summary
+		infof("\nGrants on %s to revoke:\n", server.Name)
+		infof("-----------------\n")
+		for _, grant := range selected {
+			infof("ID:       %s\n", grant.ID)
+			infof("User:     %s\n", grant.UserID)
+			infof("Level:    %s\n", grant.Level)
+			infof("Granted:  %s\n", formatSince(grant.GrantedAt))
+			infof("Expires:  %s\n", formatExpiry(grant.ExpiresAt))
+			infof("-----------------\n")
+		}
+
+		if !mysqlRevokeYes {
+			confirmed, err := confirm(fmt.Sprintf("Revoke %d grant(s)?", len(selected)))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				infoln("Aborted")
+				return nil
+			}
+		}
+
+		var failed int
+		for _, grant := range selected {
+			if err := client.RevokeGrant(cmd.Context(), grant.ID); err != nil {
+				infof("Failed to revoke %s of %s: %v\n", grant.ID, grant.UserID, err)
+				failed++
+				continue
+			}
+			infof("Revoked %s of %s\n", grant.ID, grant.UserID)
+			printID(grant.ID)
+		}
+
+		infof("Revoked %d of %d grant(s) on %s\n", len(selected)-failed, len(selected), server.Name)
+		if failed > 0 {
+			return fmt.Errorf("failed to revoke %d of %d grant(s)", failed, len(selected))
+		}
 		return nil
 	},
 }
@@ -51,18 +197,19 @@ Example:
 		// Create ping job
 		job, err := client.CreatePingJob(cmd.Context(), server)
 		if err != nil {
-			return fmt.Errorf("failed to create ping job: %v", err)
+			return fmt.Errorf("failed to create ping job: %w", err)
 		}
 
-		fmt.Printf("Created ping job %s\n", job.ID)
+		infof("Created ping job %s\n", job.ID)
+		printID(job.ID)
 
 		// Wait for job completion
 		job, err = client.WaitForJobCompletion(cmd.Context(), job.ID, time.Second*2)
 		if err != nil {
-			return fmt.Errorf("failed to complete ping job: %v", err)
+			return fmt.Errorf("failed to complete ping job: %w", err)
 		}
 
-		fmt.Printf("Server hostname: %s\n", job.Result)
+		infof("Server hostname: %s\n", job.Result)
 		return nil
 	},
 }
@@ -80,19 +227,20 @@ Example:
 		// Get list of servers
 		servers, err := client.ListMySQLServers(cmd.Context())
 		if err != nil {
-			return fmt.Errorf("failed to list servers: %v", err)
+			return fmt.Errorf("failed to list servers: %w", err)
 		}
 
 		// Print servers in a table format
-		fmt.Printf("\nRegistered MySQL Servers:\n")
-		fmt.Printf("------------------------\n")
+		infof("\nRegistered MySQL Servers:\n")
+		infof("------------------------\n")
 		for _, server := range servers {
-			fmt.Printf("Name:     %s\n", server.Name)
-			fmt.Printf("Host:     %s\n", server.Host)
-			fmt.Printf("Port:     %d\n", server.Port)
-			fmt.Printf("User:     %s\n", server.User)
-			fmt.Printf("Database: %s\n", server.Database)
-			fmt.Printf("------------------------\n")
+			printID(server.Name)
+			infof("Name:     %s\n", server.Name)
+			infof("Host:     %s\n", server.Host)
+			infof("Port:     %d\n", server.Port)
+			infof("User:     %s\n", server.User)
+			infof("Database: %s\n", server.Database)
+			infof("------------------------\n")
 		}
 
 		return nil
@@ -101,13 +249,19 @@ Example:
 
 // MySQL command flags
 var (
-	mysqlHost     string
-	mysqlPort     int
-	mysqlDatabase string
-	mysqlLevel    string
-	mysqlDuration string
-	mysqlReason   string
-	mysqlServer   string
+	mysqlHost      string
+	mysqlPort      int
+	mysqlDatabase  string
+	mysqlLevel     string
+	mysqlDuration  string
+	mysqlReason    string
+	mysqlServer    string
+	mysqlTimeout   time.Duration
+	mysqlNoWait    bool
+	mysqlGrantID   string
+	mysqlRevokeYes bool
+	// mysqlRevokeAllUsers revokes the grants of every user on the server
+	mysqlRevokeAllUsers bool
 )
 
 // Kubernetes Commands
@@ -166,18 +320,19 @@ Example:
 		// Get list of operators
 		operators, err := client.ListOperators(cmd.Context())
 		if err != nil {
-			return fmt.Errorf("failed to list operators: %v", err)
+			return fmt.Errorf("failed to list operators: %w", err)
 		}
 
 		// Print operators in a table format
-		fmt.Printf("\nRegistered Operators:\n")
-		fmt.Printf("--------------------\n")
+		infof("\nRegistered Operators:\n")
+		infof("--------------------\n")
 		for _, operator := range operators {
-			fmt.Printf("ID:        %s\n", operator.ID)
-			fmt.Printf("Status:    %s\n", operator.Status)
-			fmt.Printf("Last Seen: %s\n", operator.LastSeen.Format(time.RFC3339))
-			fmt.Printf("Created:   %s\n", operator.CreatedAt.Format(time.RFC3339))
-			fmt.Printf("--------------------\n")
+			printID(operator.ID)
+			infof("ID:        %s\n", operator.ID)
+			infof("Status:    %s\n", operator.Status)
+			infof("Last Seen: %s\n", formatSince(operator.LastSeen))
+			infof("Created:   %s\n", formatTime(operator.CreatedAt))
+			infof("--------------------\n")
 		}
 
 		return nil
@@ -203,14 +358,23 @@ func init() {
 	mysqlPingCmd.Flags().StringVar(&mysqlServer, "server", "", "Name of the registered MySQL server")
 	mysqlPingCmd.MarkFlagRequired("server")
 
-	mysqlGrantCmd.Flags().StringVar(&mysqlHost, "host", "", "MySQL server host")
+	mysqlGrantCmd.Flags().StringVar(&mysqlServer, "server", "", "Name of the registered MySQL server")
+	mysqlGrantCmd.Flags().StringVar(&mysqlHost, "host", "", "MySQL server host, when --server is not given")
 	mysqlGrantCmd.Flags().IntVar(&mysqlPort, "port", 3306, "MySQL server port")
 	mysqlGrantCmd.Flags().StringVar(&mysqlDatabase, "database", "", "Target database name")
 	mysqlGrantCmd.Flags().StringVar(&mysqlLevel, "level", "", "Access level (read/write/admin)")
 	mysqlGrantCmd.Flags().StringVar(&mysqlDuration, "duration", "1h", "Access duration (e.g., 1h, 30m)")
-	mysqlGrantCmd.Flags().StringVar(&mysqlReason, "reason", "", "Reason for access request")
-
-	mysqlRevokeCmd.Flags().String("grant-id", "", "ID of the grant to revoke")
+	mysqlGrantCmd.Flags().StringVar(&mysqlReason, "reason", "", "Reason for access request (opens $EDITOR when omitted)")
+	mysqlGrantCmd.Flags().DurationVar(&mysqlTimeout, "timeout", time.Hour, "How long to wait for approval")
+	mysqlGrantCmd.Flags().BoolVar(&mysqlNoWait, "no-wait", false, "Only submit the request; follow it with apollo-cli watch")
+
+	mysqlRevokeCmd.Flags().StringVar(&mysqlGrantID, "grant-id", "", "ID of the grant to revoke")
+	mysqlRevokeCmd.Flags().StringVar(&mysqlServer, "server", "", "Revoke the grants on this registered MySQL server")
+	mysqlRevokeCmd.Flags().StringVar(&mysqlHost, "host", "", "Revoke the grants on the server at this host, when --server is not given")
+	mysqlRevokeCmd.Flags().IntVar(&mysqlPort, "port", 3306, "MySQL server port")
+	mysqlRevokeCmd.Flags().BoolVar(&mysqlRevokeAllUsers, "all-users", false, "Revoke the grants of every user on the server (administrators only)")
+	mysqlRevokeCmd.Flags().BoolVarP(&mysqlRevokeYes, "yes", "y", false, "Skip the confirmation prompt")
+	mysqlRevokeCmd.MarkFlagsMutuallyExclusive("grant-id", "server", "host")
 
 	// Kubernetes command setup
 	kubernetesCmd.AddCommand(kubernetesGrantCmd)
@@ -224,10 +388,8 @@ func init() {
 	kubernetesRevokeCmd.Flags().String("grant-id", "", "ID of the grant to revoke")
 
 	// Mark required flags
-	mysqlGrantCmd.MarkFlagRequired("host")
-	mysqlGrantCmd.MarkFlagRequired("database")
+	mysqlGrantCmd.MarkFlagsMutuallyExclusive("server", "host")
 	mysqlGrantCmd.MarkFlagRequired("level")
-	mysqlGrantCmd.MarkFlagRequired("reason")
 
 	kubernetesGrantCmd.MarkFlagRequired("namespace")
 	kubernetesGrantCmd.MarkFlagRequired("level")
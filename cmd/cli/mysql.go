@@ -0,0 +1,156 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mysqldriver "github.com/go-sql-driver/mysql"
+)
+
+// grantPollInterval is how often a submitted request and its grant's
+// credentials are checked
+const grantPollInterval = 2 * time.Second
+
+// provisionTimeout bounds the wait for an operator to create the user of an
+// approved grant
+const provisionTimeout = 5 * time.Minute
+
+// resolveMySQLServer finds the registered server named server or, without a
+// name, the one at host and port
+func resolveMySQLServer(ctx context.Context, client *APIClient, server, host string, port int) (*ServerInfo, error) {
+	if server == "" && host == "" {
+		return nil, fmt.Errorf("either --server or --host is required")
+	}
+	servers, err := client.ListMySQLServers(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list servers: %w", err)
+	}
+	for i := range servers {
+		if server != "" && servers[i].Name == server {
+			return &servers[i], nil
+		}
+		if server == "" && strings.EqualFold(servers[i].Host, host) && servers[i].Port == port {
+			return &servers[i], nil
+		}
+	}
+	if server != "" {
+		return nil, fmt.Errorf("no MySQL server named %s is registered, see apollo-cli mysql list", server)
+	}
+	return nil, fmt.Errorf("no MySQL server at %s is registered, see apollo-cli mysql list", net.JoinHostPort(host, strconv.Itoa(port)))
+}
+
+// waitForGrant polls a request until it is decided, printing every status
+// change, and returns the grant created when it is approved
+func waitForGrant(ctx context.Context, client *APIClient, request *PrivilegeRequest) (*Grant, error) {
+	ticker := time.NewTicker(grantPollInterval)
+	defer ticker.Stop()
+
+	lastStatus := request.Status
+	for {
+		switch request.Status {
+		case "approved":
+			return findRequestGrant(ctx, client, request.ID)
+		case "denied":
+			return nil, withExitCode(ExitDenied, fmt.Errorf("request %s was denied", request.ID))
+		case "expired":
+			return nil, withExitCode(ExitApprovalTimeout, fmt.Errorf("request %s expired before it was approved", request.ID))
+		case "pending":
+		default:
+			return nil, fmt.Errorf("request %s is %s", request.ID, request.Status)
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return nil, withExitCode(ExitApprovalTimeout, fmt.Errorf("gave up waiting for approval of request %s, run: apollo-cli watch %s", request.ID, request.ID))
+			}
+			return nil, ctx.Err()
+		case <-ticker.C:
+		}
+
+		updated, err := client.GetRequest(ctx, request.ID)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get request: %w", err)
+		}
+		request = updated
+		if request.Status != lastStatus {
+			infof("[%s] Request %s is %s\n", formatTime(time.Now()), request.ID, request.Status)
+			lastStatus = request.Status
+		}
+	}
+}
+
+// findRequestGrant returns the active grant of an approved request
+func findRequestGrant(ctx context.Context, client *APIClient, requestID string) (*Grant, error) {
+	grants, err := client.ListGrants(ctx, false)
+	if err != nil {
+		return nil, fmt.Errorf("failed to list grants: %w", err)
+	}
+	for i := range grants {
+		if grants[i].RequestID == requestID {
+			return &grants[i], nil
+		}
+	}
+	return nil, fmt.Errorf("request %s was approved but has no active grant", requestID)
+}
+
+// printMySQLCredentials prints the credentials of a grant on a server, with
+// a command line and DSN to connect with, and returns the DSN
+func printMySQLCredentials(server *ServerInfo, database string, credentials *GrantCredentials) string {
+	values := credentials.Credentials
+	host, port := server.Host, strconv.Itoa(server.Port)
+	if values["host"] != "" {
+		host = values["host"]
+	}
+	if values["port"] != "" {
+		port = values["port"]
+	}
+
+	infof("\nCredentials for grant %s:\n", credentials.GrantID)
+	infof("Host:     %s\n", host)
+	infof("Port:     %s\n", port)
+	if database != "" {
+		infof("Database: %s\n", database)
+	}
+	infof("Username: %s\n", values["username"])
+	infof("Password: %s\n", values["password"])
+	// Anything else the operator handed out, e.g. a CA certificate
+	keys := make([]string, 0, len(values))
+	for key := range values {
+		switch key {
+		case "", "host", "port", "username", "password":
+		default:
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		infof("%-9s %s\n", strings.ToUpper(key[:1])+key[1:]+":", values[key])
+	}
+	infof("Expires:  %s\n", formatExpiry(credentials.ExpiresAt))
+
+	if values["username"] == "" {
+		return ""
+	}
+	config := mysqldriver.NewConfig()
+	config.User = values["username"]
+	config.Passwd = values["password"]
+	config.Net = "tcp"
+	config.Addr = net.JoinHostPort(host, port)
+	config.DBName = database
+	dsn := config.FormatDSN()
+
+	command := fmt.Sprintf("mysql -h %s -P %s -u %s -p", host, port, values["username"])
+	if database != "" {
+		command += " " + database
+	}
+	infof("\nConnect:  %s\n", command)
+	infof("DSN:      %s\n", dsn)
+	return dsn
+}
@@ -0,0 +1,186 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+// oidcLoginOptions controls the authentication requested from the identity provider
+type oidcLoginOptions struct {
+	// ACRValues requests specific authentication context classes, e.g. MFA
+	ACRValues []string
+	// MaxAge forces re-authentication when the last login is older than this
+	MaxAge time.Duration
+}
+
+// oidcLogin runs an OIDC authorization code flow with PKCE through the browser and
+// returns the ID token. The redirect is received on a loopback listener.
+func oidcLogin(ctx context.Context, opts oidcLoginOptions) (string, error) {
+	issuer := strings.TrimSuffix(viper.GetString("auth.oidc.issuer"), "/")
+	clientID := viper.GetString("auth.oidc.client_id")
+	if issuer == "" || clientID == "" {
+		return "", fmt.Errorf("auth.oidc.issuer and auth.oidc.client_id must be configured")
+	}
+
+	var provider struct {
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+	}
+	if err := getJSON(ctx, issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return "", fmt.Errorf("failed to discover identity provider: %v", err)
+	}
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		return "", fmt.Errorf("failed to start callback listener: %v", err)
+	}
+	defer listener.Close()
+	redirectURI := fmt.Sprintf("http://%s/callback", listener.Addr())
+
+	state := randomString()
+	verifier := randomString()
+	challenge := sha256.Sum256([]byte(verifier))
+
+	params := url.Values{}
+	params.Set("response_type", "code")
+	params.Set("client_id", clientID)
+	params.Set("redirect_uri", redirectURI)
+	params.Set("scope", "openid email")
+	params.Set("state", state)
+	params.Set("nonce", randomString())
+	params.Set("code_challenge", base64.RawURLEncoding.EncodeToString(challenge[:]))
+	params.Set("code_challenge_method", "S256")
+	if len(opts.ACRValues) > 0 {
+		params.Set("acr_values", strings.Join(opts.ACRValues, " "))
+	}
+	if opts.MaxAge > 0 {
+		params.Set("max_age", strconv.Itoa(int(opts.MaxAge.Seconds())))
+	}
+	authURL := provider.AuthorizationEndpoint + "?" + params.Encode()
+
+	codes := make(chan string, 1)
+	errs := make(chan error, 1)
+	srv := &http.Server{
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			query := r.URL.Query()
+			switch {
+			case query.Get("state") != state:
+				http.Error(w, "Invalid state", http.StatusBadRequest)
+				errs <- fmt.Errorf("invalid state in callback")
+			case query.Get("error") != "":
+				http.Error(w, "Authentication failed", http.StatusBadRequest)
+				errs <- fmt.Errorf("authentication failed: %s %s", query.Get("error"), query.Get("error_description"))
+			default:
+				fmt.Fprintln(w, "Authentication complete. You can close this window.")
+				codes <- query.Get("code")
+			}
+		}),
+	}
+	go srv.Serve(listener)
+	defer srv.Close()
+
+	infof("Opening browser to authenticate. If it does not open, visit:\n%s\n", authURL)
+	openBrowser(authURL)
+
+	ctx, cancel := context.WithTimeout(ctx, 5*time.Minute)
+	defer cancel()
+
+	var code string
+	select {
+	case code = <-codes:
+	case err := <-errs:
+		return "", err
+	case <-ctx.Done():
+		return "", fmt.Errorf("timed out waiting for authentication")
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "authorization_code")
+	form.Set("code", code)
+	form.Set("redirect_uri", redirectURI)
+	form.Set("client_id", clientID)
+	form.Set("code_verifier", verifier)
+	if secret := viper.GetString("auth.oidc.client_secret"); secret != "" {
+		form.Set("client_secret", secret)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", provider.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to exchange authorization code: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to exchange authorization code: status %d", resp.StatusCode)
+	}
+
+	var token struct {
+		IDToken string `json:"id_token"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode token response: %v", err)
+	}
+	if token.IDToken == "" {
+		return "", fmt.Errorf("identity provider did not return an ID token")
+	}
+	return token.IDToken, nil
+}
+
+// getJSON fetches and decodes a JSON document
+func getJSON(ctx context.Context, target string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, "GET", target, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// randomString returns a URL-safe random string for state, nonce and PKCE values
+func randomString() string {
+	b := make([]byte, 32)
+	rand.Read(b)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// openBrowser tries to open the URL in the user's browser
+func openBrowser(target string) {
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		cmd = exec.Command("open", target)
+	case "windows":
+		cmd = exec.Command("rundll32", "url.dll,FileProtocolHandler", target)
+	default:
+		cmd = exec.Command("xdg-open", target)
+	}
+	cmd.Start()
+}
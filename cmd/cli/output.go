@@ -0,0 +1,35 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+)
+
+// quiet makes the CLI print only essential identifiers on stdout
+var quiet bool
+
+// humanOutput returns the writer for human-readable output, which is stderr in quiet mode
+func humanOutput() io.Writer {
+	if quiet {
+		return os.Stderr
+	}
+	return os.Stdout
+}
+
+// infof prints human-readable output
+func infof(format string, args ...interface{}) {
+	fmt.Fprintf(humanOutput(), format, args...)
+}
+
+// infoln prints a line of human-readable output
+func infoln(args ...interface{}) {
+	fmt.Fprintln(humanOutput(), args...)
+}
+
+// printID prints an essential identifier on stdout when running in quiet mode
+func printID(id string) {
+	if quiet {
+		fmt.Fprintln(os.Stdout, id)
+	}
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/spf13/cobra"
+)
+
+var policyTestFile string
+
+var policyCmd = &cobra.Command{
+	Use:   "policy",
+	Short: "Work with access policies",
+}
+
+var policyTestCmd = &cobra.Command{
+	Use:   "test",
+	Short: "Run policy test cases against the policies loaded by the API",
+	Long: `Test runs a suite of declarative policy test cases against the policies currently
+loaded by the API server, so policy changes can be validated before rollout.
+Example:
+  apollo-cli policy test -f policy-tests.yaml
+
+A test suite lists requests and the expected decision:
+  tests:
+    - name: root on production is denied
+      request:
+        user_id: alice@example.com
+        resource_id: prod-mysql-1
+        level: root
+        reason: "incident 1234"
+        duration: 30m
+      expect:
+        allowed: false
+        violations: [allowed_levels]`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if policyTestFile == "" {
+			return fmt.Errorf("file is required")
+		}
+		suite, err := os.ReadFile(policyTestFile)
+		if err != nil {
+			return fmt.Errorf("failed to read test suite: %v", err)
+		}
+
+		client := NewAPIClient(apiEndpoint)
+		report, err := client.RunPolicyTests(cmd.Context(), suite)
+		if err != nil {
+			return fmt.Errorf("failed to run policy tests: %w", err)
+		}
+
+		for _, result := range report.Results {
+			if result.Passed {
+				infof("PASS  %s\n", result.Name)
+				continue
+			}
+			infof("FAIL  %s\n", result.Name)
+			for _, failure := range result.Failures {
+				infof("      %s\n", failure)
+			}
+		}
+		infof("\n%d passed, %d failed\n", report.Passed, report.Failed)
+
+		if report.Failed > 0 {
+			return fmt.Errorf("%d of %d policy tests failed", report.Failed, report.Passed+report.Failed)
+		}
+		return nil
+	},
+}
+
+func init() {
+	policyTestCmd.Flags().StringVarP(&policyTestFile, "file", "f", "", "Test suite file (YAML or JSON)")
+	policyCmd.AddCommand(policyTestCmd)
+}
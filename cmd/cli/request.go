@@ -1,17 +1,22 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"net/http"
+	"strings"
 	"time"
 
 	"github.com/spf13/cobra"
 )
 
 var (
+	module     string
 	resourceID string
 	level      string
 	duration   string
 	reason     string
+	dryRun     bool
 )
 
 var requestCmd = &cobra.Command{
@@ -30,9 +35,6 @@ It will be reviewed by an operator.`,
 		if duration == "" {
 			return fmt.Errorf("duration is required")
 		}
-		if reason == "" {
-			return fmt.Errorf("reason is required")
-		}
 
 		// Parse duration
 		parsedDuration, err := time.ParseDuration(duration)
@@ -40,25 +42,96 @@ It will be reviewed by an operator.`,
 			return fmt.Errorf("invalid duration format: %v", err)
 		}
 
-		fmt.Printf("Requesting privilege escalation:\n")
-		fmt.Printf("Resource: %s\n", resourceID)
-		fmt.Printf("Level: %s\n", level)
-		fmt.Printf("Duration: %s\n", parsedDuration)
-		fmt.Printf("Reason: %s\n", reason)
+		// Collect the reason in the editor when not given on the command line
+		reason, err = resolveReason(reason, [][2]string{
+			{"Resource", resourceID},
+			{"Level", level},
+			{"Duration", parsedDuration.String()},
+		})
+		if err != nil {
+			return err
+		}
+
+		// Pre-validate the request against the policies
+		client := NewAPIClient(apiEndpoint)
+		decision, err := client.SimulateRequest(cmd.Context(), PolicySimulation{
+			Module:     module,
+			ResourceID: resourceID,
+			Level:      level,
+			Reason:     reason,
+			Duration:   parsedDuration.String(),
+		})
+		var apiErr *APIError
+		switch {
+		case errors.As(err, &apiErr) && (apiErr.StatusCode == http.StatusNotFound || apiErr.StatusCode == http.StatusNotImplemented):
+			infof("Policy pre-validation is not available on this server\n")
+		case err != nil:
+			return fmt.Errorf("failed to validate request: %w", err)
+		default:
+			printDecision(decision)
+			if !decision.Allowed {
+				messages := make([]string, 0, len(decision.Violations))
+				for _, v := range decision.Violations {
+					messages = append(messages, v.Message)
+				}
+				return withExitCode(ExitPolicyViolation, fmt.Errorf("request rejected by policy: %s", strings.Join(messages, "; ")))
+			}
+		}
+
+		if dryRun {
+			return nil
+		}
+
+		infof("Requesting privilege escalation:\n")
+		infof("Resource: %s\n", resourceID)
+		infof("Level: %s\n", level)
+		infof("Duration: %s\n", parsedDuration)
+		infof("Reason: %s\n", reason)
 
 		return nil
 	},
 }
 
+// printDecision prints a policy decision and its trace
+func printDecision(decision *PolicyDecision) {
+	if decision.Allowed {
+		infof("Policy:    allowed\n")
+	} else {
+		infof("Policy:    rejected\n")
+	}
+	infof("Approvals: %d required\n", decision.RequiredApprovals)
+	infof("Risk:      %s\n", formatRisk(decision.RiskScore, decision.RiskFactors))
+	if decision.MaxDuration != "" {
+		infof("Max time:  %s\n", decision.MaxDuration)
+	}
+	if len(decision.Notify) > 0 {
+		infof("Notifies:  %s\n", strings.Join(decision.Notify, ", "))
+	}
+	if len(decision.Approvers) > 0 {
+		infof("Approvers: %s\n", strings.Join(decision.Approvers, ", "))
+	}
+	if decision.StepUp != nil {
+		infof("Step-up:   MFA required before approval\n")
+	}
+	for _, step := range decision.Trace {
+		if step.Message != "" {
+			infof("  %-9s %s: %s\n", step.Result, step.Rule, step.Message)
+		} else {
+			infof("  %-9s %s\n", step.Result, step.Rule)
+		}
+	}
+}
+
 func init() {
+	requestCmd.Flags().StringVar(&module, "module", "", "Module of the resource (e.g., mysql)")
 	requestCmd.Flags().StringVar(&resourceID, "resource-id", "", "ID of the resource requiring access")
 	requestCmd.Flags().StringVar(&level, "level", "", "Required privilege level")
 	requestCmd.Flags().StringVar(&duration, "duration", "", "Duration of the privilege grant (e.g., 1h, 30m)")
-	requestCmd.Flags().StringVar(&reason, "reason", "", "Reason for privilege escalation")
+	requestCmd.Flags().StringVar(&reason, "reason", "", "Reason for privilege escalation (opens $EDITOR when omitted)")
+	requestCmd.Flags().BoolVar(&dryRun, "dry-run", false, "Only show the policy decision without submitting the request")
 
 	// Mark required flags
 	requestCmd.MarkFlagRequired("resource-id")
 	requestCmd.MarkFlagRequired("level")
 	requestCmd.MarkFlagRequired("duration")
-	requestCmd.MarkFlagRequired("reason")
 }
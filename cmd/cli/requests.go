@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var watchInterval time.Duration
+
+var requestsCmd = &cobra.Command{
+	Use:   "requests",
+	Short: "List privilege requests",
+	Long: `List your privilege requests and their status.
+Example:
+  apollo-cli requests`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		requests, err := client.ListRequests(cmd.Context())
+		if err != nil {
+			return fmt.Errorf("failed to list requests: %w", err)
+		}
+
+		// Print requests in a table format
+		infof("\nPrivilege Requests:\n")
+		infof("-------------------\n")
+		for _, request := range requests {
+			printID(request.ID)
+			printRequest(&request)
+			infof("-------------------\n")
+		}
+
+		return nil
+	},
+}
+
+var watchCmd = &cobra.Command{
+	Use:   "watch [request-id]",
+	Short: "Watch a privilege request until it is resolved",
+	Long: `Watch polls a privilege request and prints every status change until it is approved, denied or expired.
+Example:
+  apollo-cli watch req_123`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		requestID := args[0]
+
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		ticker := time.NewTicker(watchInterval)
+		defer ticker.Stop()
+
+		var lastStatus string
+		for {
+			request, err := client.GetRequest(cmd.Context(), requestID)
+			if err != nil {
+				return fmt.Errorf("failed to get request: %w", err)
+			}
+
+			if request.Status != lastStatus {
+				infof("[%s] Request %s is %s\n", formatTime(time.Now()), request.ID, request.Status)
+				if !request.ExpiresAt.IsZero() {
+					infof("  Expires: %s\n", formatExpiry(request.ExpiresAt))
+				}
+				lastStatus = request.Status
+			}
+
+			switch request.Status {
+			case "approved", "granted", "denied", "expired", "revoked", "closed":
+				printID(request.ID)
+				return nil
+			}
+
+			select {
+			case <-cmd.Context().Done():
+				return cmd.Context().Err()
+			case <-ticker.C:
+			}
+		}
+	},
+}
+
+// printRequest prints the details of a privilege request
+func printRequest(request *PrivilegeRequest) {
+	infof("ID:        %s\n", request.ID)
+	infof("Resource:  %s\n", request.ResourceID)
+	infof("Level:     %s\n", request.Level)
+	infof("Status:    %s\n", request.Status)
+	infof("Requested: %s\n", formatSince(request.RequestedAt))
+	infof("Expires:   %s\n", formatExpiry(request.ExpiresAt))
+	infof("Risk:      %s\n", formatRisk(request.RiskScore, request.RiskFactors))
+	if request.ApprovedBy != "" {
+		infof("Approver:  %s\n", request.ApprovedBy)
+	} else if len(request.Approvers) > 0 {
+		infof("Approvers: %s\n", strings.Join(request.Approvers, ", "))
+	}
+	if request.StepUp != nil && request.StepUp.VerifiedAt == nil {
+		infof("Step-up:   required, run: apollo-cli step-up %s\n", request.ID)
+	}
+}
+
+// formatRisk formats a risk score with its contributing factors
+func formatRisk(score int, factors []string) string {
+	if len(factors) == 0 {
+		return fmt.Sprintf("%d", score)
+	}
+	return fmt.Sprintf("%d (%s)", score, strings.Join(factors, ", "))
+}
+
+func init() {
+	watchCmd.Flags().DurationVar(&watchInterval, "interval", 5*time.Second, "Polling interval")
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var (
+	revokeAll      bool
+	revokeAllUsers bool
+	revokeResource string
+	revokeBefore   string
+	revokeYes      bool
+)
+
+var revokeCmd = &cobra.Command{
+	Use:   "revoke [grant-id...]",
+	Short: "Revoke one or more active grants",
+	Long: `Revoke active grants by ID or in bulk using selectors.
+Selectors can be combined; a summary of the affected grants is shown before anything is revoked.
+Only your own grants are selected unless an administrator passes --all-users.
+Examples:
+  apollo-cli revoke grant_123
+  apollo-cli revoke --all
+  apollo-cli revoke --all-users --resource prod-mysql
+  apollo-cli revoke --resource prod-mysql --before 2024-01-02T15:04:05Z`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if len(args) == 0 && !revokeAll && revokeResource == "" && revokeBefore == "" {
+			return fmt.Errorf("specify grant IDs or at least one of --all, --resource, --before")
+		}
+		if len(args) > 0 && revokeAll {
+			return fmt.Errorf("grant IDs cannot be combined with --all")
+		}
+
+		var before time.Time
+		if revokeBefore != "" {
+			t, err := parseTimeSelector(revokeBefore)
+			if err != nil {
+				return err
+			}
+			before = t
+		}
+
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		grants, err := client.ListGrants(cmd.Context(), revokeAllUsers)
+		if err != nil {
+			return fmt.Errorf("failed to list grants: %w", err)
+		}
+
+		selected := selectGrants(grants, args, revokeResource, before)
+		if len(selected) == 0 {
+			infoln("No matching grants found")
+			return nil
+		}
+
+		// Print confirmation summary
+		infof("\nGrants to revoke:\n")
+		infof("-----------------\n")
+		for _, grant := range selected {
+			infof("ID:       %s\n", grant.ID)
+			infof("User:     %s\n", grant.UserID)
+			infof("Resource: %s\n", grant.ResourceID)
+			infof("Level:    %s\n", grant.Level)
+			infof("Granted:  %s\n", formatSince(grant.GrantedAt))
+			infof("Expires:  %s\n", formatExpiry(grant.ExpiresAt))
+			infof("-----------------\n")
+		}
+
+		if !revokeYes {
+			confirmed, err := confirm(fmt.Sprintf("Revoke %d grant(s)?", len(selected)))
+			if err != nil {
+				return err
+			}
+			if !confirmed {
+				infoln("Aborted")
+				return nil
+			}
+		}
+
+		var failed int
+		for _, grant := range selected {
+			if err := client.RevokeGrant(cmd.Context(), grant.ID); err != nil {
+				infof("Failed to revoke %s: %v\n", grant.ID, err)
+				failed++
+				continue
+			}
+			infof("Revoked %s\n", grant.ID)
+			printID(grant.ID)
+		}
+
+		if failed > 0 {
+			return fmt.Errorf("failed to revoke %d of %d grant(s)", failed, len(selected))
+		}
+		return nil
+	},
+}
+
+// selectGrants filters grants by explicit IDs, resource and grant time
+func selectGrants(grants []Grant, ids []string, resource string, before time.Time) []Grant {
+	wanted := make(map[string]bool, len(ids))
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	var selected []Grant
+	for _, grant := range grants {
+		if len(wanted) > 0 && !wanted[grant.ID] {
+			continue
+		}
+		if resource != "" && grant.ResourceID != resource {
+			continue
+		}
+		if !before.IsZero() && !grant.GrantedAt.Before(before) {
+			continue
+		}
+		selected = append(selected, grant)
+	}
+	return selected
+}
+
+// parseTimeSelector parses an absolute timestamp or a duration relative to now
+func parseTimeSelector(value string) (time.Time, error) {
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("2006-01-02 15:04", value, time.Local); err == nil {
+		return t, nil
+	}
+	if d, err := time.ParseDuration(value); err == nil {
+		return time.Now().Add(-d), nil
+	}
+	return time.Time{}, fmt.Errorf("invalid time %q: use RFC3339, \"2006-01-02 15:04\" or a duration such as 2h", value)
+}
+
+// confirm asks the user a yes/no question on the terminal
+func confirm(question string) (bool, error) {
+	infof("%s [y/N]: ", question)
+	answer, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && answer == "" {
+		return false, fmt.Errorf("failed to read confirmation: %v", err)
+	}
+	answer = strings.ToLower(strings.TrimSpace(answer))
+	return answer == "y" || answer == "yes", nil
+}
+
+func init() {
+	revokeCmd.Flags().BoolVar(&revokeAll, "all", false, "Revoke all active grants")
+	revokeCmd.Flags().BoolVar(&revokeAllUsers, "all-users", false, "Select the grants of every user (administrators only)")
+	revokeCmd.Flags().StringVar(&revokeResource, "resource", "", "Only revoke grants for this resource ID")
+	revokeCmd.Flags().StringVar(&revokeBefore, "before", "", "Only revoke grants issued before this time (RFC3339 or duration ago, e.g. 2h)")
+	revokeCmd.Flags().BoolVarP(&revokeYes, "yes", "y", false, "Skip the confirmation prompt")
+}
@@ -18,14 +18,24 @@ var rootCmd = &cobra.Command{
 	Use:   "apollo-cli",
 	Short: "Apollo CLI - Privilege Management Tool",
 	Long: `Apollo CLI is a tool for managing privileged access across different systems.
-It provides a unified interface for requesting and revoking access to various resources.`,
+It provides a unified interface for requesting and revoking access to various resources.
+
+Exit codes:
+  0  success
+  1  general error
+  3  request denied
+  4  policy violation
+  5  timed out waiting for approval
+  6  provisioning failed
+  7  authentication failed
+  8  operation forbidden`,
 }
 
 // Execute adds all child commands to the root command and sets flags appropriately.
 func Execute() {
 	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
 	}
 }
 
@@ -36,11 +46,23 @@ func init() {
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.apollo-cli.yaml)")
 	rootCmd.PersistentFlags().StringVar(&apiEndpoint, "api", "http://localhost:8080", "API server endpoint")
 	rootCmd.PersistentFlags().StringP("output", "o", "text", "Output format (text/json)")
+	rootCmd.PersistentFlags().BoolVar(&useUTC, "utc", false, "Display timestamps in UTC instead of local time")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Print only the essential identifier on stdout, everything else on stderr")
 
 	// Add commands
 	rootCmd.AddCommand(requestCmd)
+	rootCmd.AddCommand(extendCmd)
+	rootCmd.AddCommand(handoverCmd)
+	rootCmd.AddCommand(revokeCmd)
+	rootCmd.AddCommand(grantsCmd)
+	rootCmd.AddCommand(requestsCmd)
+	rootCmd.AddCommand(watchCmd)
+	rootCmd.AddCommand(stepUpCmd)
+	rootCmd.AddCommand(policyCmd)
 	rootCmd.AddCommand(mysqlCmd)
 	rootCmd.AddCommand(operatorCmd)
+	rootCmd.AddCommand(loginCmd)
+	rootCmd.AddCommand(logoutCmd)
 }
 
 // initConfig reads in config file and ENV variables if set.
@@ -50,7 +72,7 @@ func initConfig() {
 	} else {
 		home, err := os.UserHomeDir()
 		if err != nil {
-			fmt.Println(err)
+			fmt.Fprintln(os.Stderr, err)
 			os.Exit(1)
 		}
 		viper.AddConfigPath(home)
@@ -64,7 +86,7 @@ func initConfig() {
 
 	// Read config
 	if err := viper.ReadInConfig(); err == nil {
-		fmt.Println("Using config file:", viper.ConfigFileUsed())
+		infoln("Using config file:", viper.ConfigFileUsed())
 	}
 
 	// Bind flags to viper
@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var stepUpCmd = &cobra.Command{
+	Use:   "step-up [request-id]",
+	Short: "Re-authenticate with MFA to unlock approval of a high-privilege request",
+	Long: `Step-up runs a fresh login with your identity provider using the authentication
+requirements demanded by policy and attaches the proof to the request. Admin and
+root requests can only be approved once this is done.
+Example:
+  apollo-cli step-up req_123`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		requestID := args[0]
+
+		// Create API client
+		client := NewAPIClient(apiEndpoint)
+
+		request, err := client.GetRequest(cmd.Context(), requestID)
+		if err != nil {
+			return fmt.Errorf("failed to get request: %w", err)
+		}
+		if request.StepUp == nil {
+			infof("Request %s does not require step-up authentication\n", requestID)
+			return nil
+		}
+		if request.StepUp.VerifiedAt != nil {
+			infof("Step-up authentication already completed at %s\n", formatTime(*request.StepUp.VerifiedAt))
+			return nil
+		}
+
+		idToken, err := oidcLogin(cmd.Context(), oidcLoginOptions{
+			ACRValues: request.StepUp.ACRValues,
+			MaxAge:    time.Duration(request.StepUp.MaxAge) * time.Second,
+		})
+		if err != nil {
+			return withExitCode(ExitAuthFailed, fmt.Errorf("step-up authentication failed: %w", err))
+		}
+
+		if err := client.SubmitStepUp(cmd.Context(), requestID, idToken); err != nil {
+			return fmt.Errorf("failed to attach step-up proof: %w", err)
+		}
+
+		printID(requestID)
+		infof("Step-up authentication attached to request %s\n", requestID)
+		return nil
+	},
+}
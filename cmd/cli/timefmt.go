@@ -0,0 +1,71 @@
+package main
+
+import (
+	"fmt"
+	"time"
+)
+
+// useUTC displays timestamps in UTC instead of the local timezone
+var useUTC bool
+
+// displayTimeLayout is the layout used for absolute timestamps
+const displayTimeLayout = "2006-01-02 15:04:05 MST"
+
+// formatTime formats a timestamp in the local timezone (or UTC with --utc)
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	if useUTC {
+		return t.UTC().Format(displayTimeLayout)
+	}
+	return t.Local().Format(displayTimeLayout)
+}
+
+// formatExpiry formats an expiration time as absolute time followed by a relative hint,
+// e.g. "2024-01-02 15:04:05 CET (expires in 42m)"
+func formatExpiry(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	remaining := time.Until(t)
+	if remaining <= 0 {
+		return fmt.Sprintf("%s (expired %s ago)", formatTime(t), humanDuration(-remaining))
+	}
+	return fmt.Sprintf("%s (expires in %s)", formatTime(t), humanDuration(remaining))
+}
+
+// formatSince formats a past timestamp as absolute time followed by how long ago it was
+func formatSince(t time.Time) string {
+	if t.IsZero() {
+		return "-"
+	}
+	return fmt.Sprintf("%s (%s ago)", formatTime(t), humanDuration(time.Since(t)))
+}
+
+// humanDuration renders a duration in a compact, human-friendly form such as 42m or 2h5m
+func humanDuration(d time.Duration) string {
+	if d < 0 {
+		d = -d
+	}
+	switch {
+	case d < time.Minute:
+		return fmt.Sprintf("%ds", int(d.Seconds()))
+	case d < time.Hour:
+		return fmt.Sprintf("%dm", int(d.Minutes()))
+	case d < 24*time.Hour:
+		hours := int(d.Hours())
+		minutes := int(d.Minutes()) % 60
+		if minutes == 0 {
+			return fmt.Sprintf("%dh", hours)
+		}
+		return fmt.Sprintf("%dh%dm", hours, minutes)
+	default:
+		days := int(d.Hours()) / 24
+		hours := int(d.Hours()) % 24
+		if hours == 0 {
+			return fmt.Sprintf("%dd", days)
+		}
+		return fmt.Sprintf("%dd%dh", days, hours)
+	}
+}
@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"net/http"
 	"time"
+
+	"github.com/petermein/apollo/internal/transport"
 )
 
 // Job represents a job from the API
@@ -31,7 +33,8 @@ func NewAPIClient(baseURL string) *APIClient {
 	return &APIClient{
 		baseURL: baseURL,
 		httpClient: &http.Client{
-			Timeout: time.Second * 10,
+			Timeout:   time.Second * 10,
+			Transport: transport.New(),
 		},
 	}
 }
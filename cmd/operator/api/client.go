@@ -4,11 +4,18 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
+	"log"
 	"net/http"
+	"net/url"
+	"sync"
 	"time"
 
 	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/auth"
+	"github.com/petermein/apollo/internal/transport"
 )
 
 // Client represents an API client
@@ -16,25 +23,107 @@ type Client struct {
 	baseURL    string
 	httpClient *http.Client
 	operatorID string
+	region     string
+
+	// token is the short-lived operator token the API issued
+	mu             sync.Mutex
+	token          string
+	tokenExpiresAt time.Time
 }
 
-// NewClient creates a new API client
-func NewClient(baseURL, operatorID string) *Client {
-	return &Client{
-		baseURL: baseURL,
-		httpClient: &http.Client{
-			Timeout: 10 * time.Second,
-		},
+// NewClient creates a new API client for an operator running in the given region
+func NewClient(baseURL, operatorID, region string) *Client {
+	c := &Client{
+		baseURL:    baseURL,
 		operatorID: operatorID,
+		region:     region,
+	}
+	c.httpClient = &http.Client{
+		Timeout:   10 * time.Second,
+		Transport: &operatorTokenTransport{client: c, base: transport.New()},
 	}
+	return c
+}
+
+// ErrTokenRejected is returned when the API no longer accepts the operator token
+var ErrTokenRejected = errors.New("operator token was rejected")
+
+// operatorTokenTransport sends requests with the client's operator token once
+// the API issued one, in place of any configured bearer token
+type operatorTokenTransport struct {
+	client *Client
+	base   http.RoundTripper
 }
 
-// RegisterOperator registers the operator with the API
+// RoundTrip sends the request with the operator token
+func (t *operatorTokenTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if token, _ := t.client.currentToken(); token != "" {
+		req = req.Clone(req.Context())
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	return t.base.RoundTrip(req)
+}
+
+// currentToken returns the operator token and when it expires
+func (c *Client) currentToken() (string, time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.token, c.tokenExpiresAt
+}
+
+// Token returns the operator token the API issued, empty before registration
+func (c *Client) Token() string {
+	token, _ := c.currentToken()
+	return token
+}
+
+// setToken replaces the operator token
+func (c *Client) setToken(token string, expiresAt time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.token, c.tokenExpiresAt = token, expiresAt
+}
+
+// tokenResponse is an operator token as issued by the API
+type tokenResponse struct {
+	Token     string    `json:"token"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// readToken keeps the operator token in the response, if the API issued one
+func (c *Client) readToken(body io.Reader) error {
+	var token tokenResponse
+	if err := json.NewDecoder(body).Decode(&token); err != nil {
+		if err == io.EOF {
+			return nil
+		}
+		return fmt.Errorf("failed to decode token: %v", err)
+	}
+	if token.Token != "" {
+		c.setToken(token.Token, token.ExpiresAt)
+	}
+	return nil
+}
+
+// SetToken authenticates the client's requests with a bearer token
+func (c *Client) SetToken(token string) {
+	c.httpClient.Transport = &auth.BearerTransport{Token: token, Base: c.httpClient.Transport}
+}
+
+// SetSigningSecret signs the client's requests with the operator's shared secret
+func (c *Client) SetSigningSecret(secret []byte) {
+	c.httpClient.Transport = &auth.HMACTransport{OperatorID: c.operatorID, Secret: secret, Base: c.httpClient.Transport}
+}
+
+// RegisterOperator registers the operator with the API and keeps the
+// operator token it issues
 func (c *Client) RegisterOperator(ctx context.Context) error {
 	req := struct {
-		ID string `json:"id"`
+		ID     string `json:"id"`
+		Region string `json:"region,omitempty"`
 	}{
-		ID: c.operatorID,
+		ID:     c.operatorID,
+		Region: c.region,
 	}
 
 	data, err := json.Marshal(req)
@@ -42,6 +131,8 @@ func (c *Client) RegisterOperator(ctx context.Context) error {
 		return fmt.Errorf("failed to marshal request: %v", err)
 	}
 
+	// A revoked token would fail registration, so register without it
+	c.setToken("", time.Time{})
 	resp, err := c.httpClient.Post(c.baseURL+"/api/v1/operators/register", "application/json", bytes.NewBuffer(data))
 	if err != nil {
 		return fmt.Errorf("failed to register operator: %v", err)
@@ -52,11 +143,77 @@ func (c *Client) RegisterOperator(ctx context.Context) error {
 		return fmt.Errorf("failed to register operator: status %d", resp.StatusCode)
 	}
 
-	return nil
+	return c.readToken(resp.Body)
+}
+
+// RefreshToken replaces the operator token with a new one before it expires
+func (c *Client) RefreshToken(ctx context.Context) error {
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, c.baseURL+"/api/v1/operators/token", nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to refresh token: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		return ErrTokenRejected
+	}
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to refresh token: status %d", resp.StatusCode)
+	}
+
+	return c.readToken(resp.Body)
+}
+
+// KeepTokenFresh refreshes the operator token halfway through its lifetime
+// until the context is done. A rejected token, e.g. after the API revoked it,
+// is replaced by registering again. It returns at once if the API issued no
+// token at registration.
+func (c *Client) KeepTokenFresh(ctx context.Context) {
+	const retryDelay = 10 * time.Second
+	if token, _ := c.currentToken(); token == "" {
+		return
+	}
+	for {
+		delay := retryDelay
+		if token, expiresAt := c.currentToken(); token != "" {
+			delay = time.Until(expiresAt) / 2
+		}
+		if delay < time.Second {
+			delay = time.Second
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		var err error
+		if token, _ := c.currentToken(); token != "" {
+			err = c.RefreshToken(ctx)
+		} else {
+			err = ErrTokenRejected
+		}
+		if errors.Is(err, ErrTokenRejected) {
+			log.Printf("Operator token was rejected, registering again")
+			err = c.RegisterOperator(ctx)
+		}
+		if err != nil {
+			log.Printf("Failed to refresh operator token: %v", err)
+		}
+	}
 }
 
 // RegisterServer registers a MySQL server with the API
 func (c *Client) RegisterServer(ctx context.Context, server modules.ServerInfo) error {
+	if server.Region == "" {
+		server.Region = c.region
+	}
 	data, err := json.Marshal(server)
 	if err != nil {
 		return fmt.Errorf("failed to marshal server info: %v", err)
@@ -101,14 +258,52 @@ func (c *Client) MarkServerInactive(ctx context.Context, name string) error {
 	return nil
 }
 
-// SendHealthCheck sends a health check to the API
-func (c *Client) SendHealthCheck(ctx context.Context) error {
+// DepositCredentials hands the credentials of a provisioned grant to the API,
+// which keeps them sealed until the grant holder redeems them
+func (c *Client) DepositCredentials(ctx context.Context, grantID string, credentials map[string]string) error {
+	req := struct {
+		OperatorID  string            `json:"operator_id"`
+		Credentials map[string]string `json:"credentials"`
+	}{
+		OperatorID:  c.operatorID,
+		Credentials: credentials,
+	}
+
+	data, err := json.Marshal(req)
+	if err != nil {
+		return fmt.Errorf("failed to marshal credentials: %v", err)
+	}
+
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPut, c.baseURL+"/api/v1/grants/"+url.PathEscape(grantID)+"/credentials", bytes.NewReader(data))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(httpReq)
+	if err != nil {
+		return fmt.Errorf("failed to deposit credentials: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusNoContent {
+		return fmt.Errorf("failed to deposit credentials: status %d", resp.StatusCode)
+	}
+
+	return nil
+}
+
+// SendHealthCheck sends a health check to the API with the health of the
+// operator's modules
+func (c *Client) SendHealthCheck(ctx context.Context, health []modules.ModuleHealth) error {
 	req := struct {
-		ID        string    `json:"id"`
-		Timestamp time.Time `json:"timestamp"`
+		ID        string                 `json:"id"`
+		Timestamp time.Time              `json:"timestamp"`
+		Modules   []modules.ModuleHealth `json:"modules"`
 	}{
 		ID:        c.operatorID,
 		Timestamp: time.Now().UTC(),
+		Modules:   health,
 	}
 
 	data, err := json.Marshal(req)
@@ -1,11 +1,14 @@
 package config
 
 import (
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"net"
 	"os"
-	"strings"
 
-	"gopkg.in/yaml.v3"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/configref"
 )
 
 // Config represents the operator configuration
@@ -14,79 +17,116 @@ type Config struct {
 	API            APIConfig              `yaml:"api"`
 	EnabledModules string                 `yaml:"enabled_modules"`
 	Modules        map[string]interface{} `yaml:"modules"`
+	Bus            BusConfig              `yaml:"bus"`
+
+	// Region is the region the operator and its servers run in
+	Region string `yaml:"region"`
 }
 
 // APIConfig represents the API configuration
 type APIConfig struct {
 	Endpoint string `yaml:"endpoint"`
+	// Endpoints maps regions to the API endpoint serving them, so operators
+	// talk to the API servers nearest to them
+	Endpoints map[string]string `yaml:"endpoints"`
+	// Token is a bearer token sent to API servers that require one
+	Token string `yaml:"token"`
+	// SigningSecret is the base64 encoded secret the operator signs its requests
+	// with, shared with the API servers
+	SigningSecret string `yaml:"signing_secret"`
+	// GRPCEndpoint is the host:port API servers serve operators over gRPC
+	// on. When set, the operator receives jobs and sends heartbeats and
+	// results over it instead of the bus and HTTP.
+	GRPCEndpoint string `yaml:"grpc_endpoint"`
+	// GRPCInsecure connects to GRPCEndpoint without TLS
+	GRPCInsecure bool `yaml:"grpc_insecure"`
 }
 
-// expandEnvVars expands environment variables in the format ${VAR:-default}
-func expandEnvVars(input string) string {
-	// Split on ${ to find all potential env vars
-	parts := strings.Split(input, "${")
-	if len(parts) == 1 {
-		return input
+// EndpointFor returns the API endpoint for the given region, falling back to
+// Endpoint when no API servers run in the region
+func (c APIConfig) EndpointFor(region string) string {
+	if endpoint, ok := c.Endpoints[region]; ok && endpoint != "" {
+		return endpoint
 	}
+	return c.Endpoint
+}
 
-	var result strings.Builder
-	result.WriteString(parts[0])
-
-	for _, part := range parts[1:] {
-		// Find the closing brace
-		closeBrace := strings.Index(part, "}")
-		if closeBrace == -1 {
-			result.WriteString("${")
-			result.WriteString(part)
-			continue
-		}
-
-		// Extract the env var expression and the rest of the string
-		envVar := part[:closeBrace]
-		rest := part[closeBrace+1:]
-
-		// Check if there's a default value
-		var defaultVal string
-		if idx := strings.Index(envVar, ":-"); idx != -1 {
-			defaultVal = envVar[idx+2:]
-			envVar = envVar[:idx]
-		}
-
-		// Get the environment variable value
-		val := os.Getenv(envVar)
-		if val == "" {
-			val = defaultVal
-		}
+// BusConfig configures receiving jobs over a message bus; jobs aren't received
+// when Type is empty
+type BusConfig struct {
+	Type string `yaml:"type"`
+	URL  string `yaml:"url"`
+}
 
-		result.WriteString(val)
-		result.WriteString(rest)
+// Load loads the configuration from a file and validates it
+func Load(path string) (*Config, error) {
+	cfg, err := Parse(path)
+	if err != nil {
+		return nil, err
 	}
-
-	return result.String()
+	if errs := cfg.Validate(); len(errs) > 0 {
+		return nil, fmt.Errorf("invalid config: %v", errors.Join(errs...))
+	}
+	return cfg, nil
 }
 
-// Load loads the configuration from a file
-func Load(path string) (*Config, error) {
+// Parse reads the configuration from a file, resolving its references to
+// environment variables and secret files, without validating it. When only
+// references fail to resolve, the rest of the configuration is returned with
+// the error so it can still be validated.
+func Parse(path string) (*Config, error) {
 	data, err := os.ReadFile(path)
 	if err != nil {
 		return nil, fmt.Errorf("failed to read config file: %v", err)
 	}
 
-	// Expand environment variables in the config file
-	configStr := expandEnvVars(string(data))
-
 	var cfg Config
-	if err := yaml.Unmarshal([]byte(configStr), &cfg); err != nil {
+	if err := configref.Unmarshal(data, &cfg); err != nil {
+		var refErr *configref.Error
+		if errors.As(err, &refErr) {
+			return &cfg, fmt.Errorf("failed to resolve config references: %w", err)
+		}
 		return nil, fmt.Errorf("failed to parse config file: %v", err)
 	}
+	return &cfg, nil
+}
 
-	// Validate required fields
-	if cfg.OperatorID == "" {
-		return nil, fmt.Errorf("operator_id is required")
+// Validate checks the configuration, apart from the modules', returning
+// every problem found rather than only the first
+func (c *Config) Validate() []error {
+	var errs []error
+	if c.OperatorID == "" {
+		errs = append(errs, fmt.Errorf("operator_id is required"))
 	}
-	if cfg.API.Endpoint == "" {
-		return nil, fmt.Errorf("api.endpoint is required")
+	if c.API.Endpoint == "" {
+		errs = append(errs, fmt.Errorf("api.endpoint is required"))
 	}
-
-	return &cfg, nil
+	for region, endpoint := range c.API.Endpoints {
+		if endpoint == "" {
+			errs = append(errs, fmt.Errorf("api.endpoints.%s is empty", region))
+		}
+	}
+	if c.API.SigningSecret != "" {
+		if _, err := base64.StdEncoding.DecodeString(c.API.SigningSecret); err != nil {
+			errs = append(errs, fmt.Errorf("api.signing_secret is not base64: %v", err))
+		}
+	}
+	if c.API.GRPCEndpoint != "" {
+		if _, _, err := net.SplitHostPort(c.API.GRPCEndpoint); err != nil {
+			errs = append(errs, fmt.Errorf("api.grpc_endpoint must be host:port: %v", err))
+		}
+	}
+	if c.EnabledModules == "" {
+		errs = append(errs, fmt.Errorf("enabled_modules is required"))
+	}
+	switch c.Bus.Type {
+	case "":
+	case bus.TypeNATS:
+		if c.Bus.URL == "" {
+			errs = append(errs, fmt.Errorf("bus.url is required for the nats bus"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("bus.type %q is not supported by operators", c.Bus.Type))
+	}
+	return errs
 }
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/redact"
+)
+
+// jobTimeout bounds how long a single job may run
+const jobTimeout = time.Minute
+
+// consumeJobs subscribes to the jobs of every enabled module that runs jobs and
+// publishes their results. Every operator of a module receives its jobs; the
+// one the job is meant for answers.
+func consumeJobs(b bus.Bus, operatorID string, enabled []modules.Module) error {
+	publish := func(ctx context.Context, result bus.ResultMessage) error {
+		payload, err := json.Marshal(result)
+		if err != nil {
+			return err
+		}
+		return b.Publish(ctx, bus.ResultSubject, payload)
+	}
+	for _, module := range enabled {
+		handler, ok := module.(modules.JobHandler)
+		if !ok {
+			continue
+		}
+		name := module.Name()
+		if _, err := b.Subscribe(bus.JobSubject(name), bus.SubscribeOptions{}, func(data []byte) {
+			var job bus.JobMessage
+			if err := json.Unmarshal(data, &job); err != nil {
+				log.Printf("Ignoring malformed job: %v", err)
+				return
+			}
+			runJob(operatorID, handler, job, publish)
+		}); err != nil {
+			return err
+		}
+		log.Printf("Receiving %s jobs over the bus", name)
+	}
+	return nil
+}
+
+// runJob runs a job and reports its result, unless the job is meant for
+// another operator
+func runJob(operatorID string, handler modules.JobHandler, job bus.JobMessage, report func(context.Context, bus.ResultMessage) error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+	defer cancel()
+
+	result := bus.ResultMessage{ID: job.ID, OperatorID: operatorID, Status: "completed"}
+	output, err := handler.HandleJob(ctx, job.Type, job.Request)
+	if errors.Is(err, modules.ErrNotHandled) {
+		return
+	}
+	if err != nil {
+		result.Status = "failed"
+		result.Error = redact.Error(err)
+	}
+	result.Result = redact.String(output)
+
+	if err := report(ctx, result); err != nil {
+		log.Printf("Failed to report result of job %s: %v", job.ID, err)
+		return
+	}
+	log.Printf("Job %s (%s %s) %s", job.ID, job.Module, job.Type, result.Status)
+}
@@ -2,7 +2,9 @@ package main
 
 import (
 	"context"
+	"encoding/base64"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -12,17 +14,40 @@ import (
 	"github.com/petermein/apollo/cmd/operator/api"
 	"github.com/petermein/apollo/cmd/operator/config"
 	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/cmd/operator/modules/elasticsearch"
+	"github.com/petermein/apollo/cmd/operator/modules/github"
+	"github.com/petermein/apollo/cmd/operator/modules/ldap"
+	"github.com/petermein/apollo/cmd/operator/modules/mock"
 	"github.com/petermein/apollo/cmd/operator/modules/mysql"
+	"github.com/petermein/apollo/cmd/operator/modules/snowflake"
+	"github.com/petermein/apollo/cmd/operator/modules/ssh"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/operatorrpc"
+	"github.com/petermein/apollo/internal/redact"
 )
 
 func main() {
 	log.SetFlags(log.LstdFlags | log.Lmsgprefix)
 	log.SetPrefix("[OPERATOR] ")
+	log.SetOutput(redact.NewWriter(os.Stderr))
 
 	// Parse command line flags
 	configPath := flag.String("config", "configs/operator.yaml", "Path to config file")
+	validate := flag.Bool("validate", false, "Check the config file and exit")
 	flag.Parse()
 
+	if *validate {
+		errs := validateConfig(*configPath)
+		for _, err := range errs {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", *configPath, err)
+		}
+		if len(errs) > 0 {
+			os.Exit(1)
+		}
+		fmt.Printf("%s: OK\n", *configPath)
+		return
+	}
+
 	log.Printf("Starting operator with config file: %s", *configPath)
 
 	// Load configuration
@@ -33,8 +58,19 @@ func main() {
 	log.Printf("Loaded configuration for operator: %s", cfg.OperatorID)
 
 	// Create API client
-	apiClient := api.NewClient(cfg.API.Endpoint, cfg.OperatorID)
-	log.Printf("Created API client with endpoint: %s", cfg.API.Endpoint)
+	endpoint := cfg.API.EndpointFor(cfg.Region)
+	apiClient := api.NewClient(endpoint, cfg.OperatorID, cfg.Region)
+	if cfg.API.Token != "" {
+		apiClient.SetToken(cfg.API.Token)
+	}
+	if cfg.API.SigningSecret != "" {
+		secret, err := base64.StdEncoding.DecodeString(cfg.API.SigningSecret)
+		if err != nil {
+			log.Fatalf("Invalid signing secret: %v", err)
+		}
+		apiClient.SetSigningSecret(secret)
+	}
+	log.Printf("Created API client with endpoint: %s", endpoint)
 
 	// Register operator with API
 	if err := apiClient.RegisterOperator(context.Background()); err != nil {
@@ -51,6 +87,30 @@ func main() {
 	registry.Register(mysqlModule)
 	log.Printf("Registered MySQL module")
 
+	// Register the mock module, which simulates a target system
+	registry.Register(mock.NewModule())
+	log.Printf("Registered mock module")
+
+	// Register the SSH module, which grants shell access to this host
+	registry.Register(ssh.NewModule())
+	log.Printf("Registered SSH module")
+
+	// Register the LDAP module, which grants directory group membership
+	registry.Register(ldap.NewModule())
+	log.Printf("Registered LDAP module")
+
+	// Register the GitHub module, which grants repository and team access
+	registry.Register(github.NewModule())
+	log.Printf("Registered GitHub module")
+
+	// Register the Snowflake module, which grants Snowflake roles
+	registry.Register(snowflake.NewModule())
+	log.Printf("Registered Snowflake module")
+
+	// Register the Elasticsearch module, which grants index access
+	registry.Register(elasticsearch.NewModule())
+	log.Printf("Registered Elasticsearch module")
+
 	// Initialize enabled modules
 	enabledModules := registry.GetEnabledModules(cfg.EnabledModules)
 	log.Printf("Enabled modules: %s", cfg.EnabledModules)
@@ -74,6 +134,39 @@ func main() {
 		log.Printf("Started monitoring for module: %s", module.Name())
 	}
 
+	// Receive jobs and send heartbeats and results over gRPC
+	var rpcClient operatorrpc.OperatorClient
+	if cfg.API.GRPCEndpoint != "" {
+		conn, err := dialOperatorRPC(cfg.API, apiClient)
+		if err != nil {
+			log.Fatalf("Failed to connect to %s: %v", cfg.API.GRPCEndpoint, err)
+		}
+		defer conn.Close()
+		rpcClient = operatorrpc.NewOperatorClient(conn)
+		go streamJobs(ctx, rpcClient, cfg.OperatorID, enabledModules)
+		log.Printf("Connected to the API over gRPC at %s", cfg.API.GRPCEndpoint)
+	}
+
+	// Receive jobs over the message bus, unless they arrive over gRPC
+	if cfg.Bus.Type != "" {
+		jobBus, err := bus.Open(bus.Config{Type: cfg.Bus.Type, URL: cfg.Bus.URL}, cfg.OperatorID)
+		if err != nil {
+			log.Fatalf("Failed to connect to the job bus: %v", err)
+		}
+		defer jobBus.Close()
+		if rpcClient == nil {
+			if err := consumeJobs(jobBus, cfg.OperatorID, enabledModules); err != nil {
+				log.Fatalf("Failed to subscribe to jobs: %v", err)
+			}
+		}
+		if err := consumeApprovals(jobBus, apiClient, enabledModules); err != nil {
+			log.Fatalf("Failed to subscribe to approved grants: %v", err)
+		}
+	}
+
+	// Refresh the operator token before it expires
+	go apiClient.KeepTokenFresh(ctx)
+
 	// Start health check loop
 	go func() {
 		ticker := time.NewTicker(30 * time.Second)
@@ -84,7 +177,14 @@ func main() {
 			case <-ctx.Done():
 				return
 			case <-ticker.C:
-				if err := apiClient.SendHealthCheck(ctx); err != nil {
+				health := modules.CheckHealth(ctx, enabledModules)
+				var err error
+				if rpcClient != nil {
+					err = sendHeartbeat(ctx, rpcClient, cfg.OperatorID, health)
+				} else {
+					err = apiClient.SendHealthCheck(ctx, health)
+				}
+				if err != nil {
 					log.Printf("Failed to send health check: %v", err)
 				} else {
 					log.Printf("Health check sent successfully")
@@ -0,0 +1,496 @@
+package elasticsearch
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/credential"
+)
+
+// Flavors of the security API
+const (
+	flavorElasticsearch = "elasticsearch"
+	flavorOpenSearch    = "opensearch"
+)
+
+// defaultPrivileges are the index privileges of each level in Elasticsearch
+// and the action groups of each level in OpenSearch
+var defaultPrivileges = map[string]map[string][]string{
+	flavorElasticsearch: {
+		"read":  {"read", "view_index_metadata"},
+		"write": {"read", "write", "view_index_metadata"},
+		"admin": {"all"},
+		"root":  {"all"},
+	},
+	flavorOpenSearch: {
+		"read":  {"read"},
+		"write": {"crud"},
+		"admin": {"indices_all"},
+		"root":  {"indices_all"},
+	},
+}
+
+// grantIDPattern matches the grant IDs safe to use in user and role names
+var grantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// errNotFound is returned for security objects that don't exist
+var errNotFound = errors.New("not found")
+
+// Config represents the Elasticsearch module configuration
+type Config struct {
+	// URL is the cluster's address
+	URL string
+	// Flavor is elasticsearch or opensearch, whose security APIs differ
+	Flavor string
+	// Username and Password, or APIKey on Elasticsearch, authenticate the
+	// module, which needs to manage users, roles and role mappings
+	Username string
+	Password string
+	APIKey   string
+	// CAFile holds the certificates the cluster's certificate is checked
+	// against, for clusters with their own CA
+	CAFile string
+	// CreateUsers creates a user for every grant and returns its password;
+	// otherwise grants map the role to the user's existing username, e.g.
+	// from single sign-on
+	CreateUsers bool
+	// PasswordPolicy sets how the passwords of created users are generated
+	PasswordPolicy credential.Policy
+	// Resources maps resource IDs to the index patterns they grant access to
+	Resources map[string][]string
+	// Privileges maps privilege levels to the index privileges, or
+	// OpenSearch action groups, granted on the patterns
+	Privileges map[string][]string
+}
+
+// Module grants temporary access to indices of Elasticsearch and OpenSearch
+// clusters through their security APIs. Every grant gets a role allowing its
+// level's privileges on the resource's index patterns, and either a user of
+// its own or a role mapping to the user's existing username. Revoking the
+// grant at expiry deletes them.
+type Module struct {
+	config *Config
+	client *http.Client
+}
+
+// NewModule creates a new Elasticsearch module
+func NewModule() *Module {
+	return &Module{}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "elasticsearch"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Temporary Elasticsearch and OpenSearch index access"
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// Initialize initializes the Elasticsearch module
+func (m *Module) Initialize(config interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if cfg.CAFile != "" {
+		pem, err := os.ReadFile(cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("failed to read ca_file: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return fmt.Errorf("ca_file holds no certificates")
+		}
+		transport.TLSClientConfig = &tls.Config{RootCAs: pool}
+	}
+	m.config = cfg
+	m.client = &http.Client{Timeout: 15 * time.Second, Transport: transport}
+	log.Printf("[ELASTICSEARCH] Granting access to %d resources of %s cluster %s", len(cfg.Resources), cfg.Flavor, cfg.URL)
+	return nil
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found
+func parseConfig(config interface{}) (*Config, error) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for Elasticsearch module")
+	}
+
+	cfg := &Config{
+		Flavor:         flavorElasticsearch,
+		CreateUsers:    true,
+		PasswordPolicy: credential.Default,
+		Resources:      make(map[string][]string),
+		Privileges:     make(map[string][]string),
+	}
+	var errs []error
+	cfg.URL, _ = configMap["url"].(string)
+	cfg.URL = strings.TrimSuffix(cfg.URL, "/")
+	if u, err := url.Parse(cfg.URL); cfg.URL == "" || err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("url must be an https URL"))
+	}
+	if flavor, ok := configMap["flavor"].(string); ok && flavor != "" {
+		cfg.Flavor = flavor
+	}
+	if _, ok := defaultPrivileges[cfg.Flavor]; !ok {
+		errs = append(errs, fmt.Errorf("flavor must be elasticsearch or opensearch"))
+		cfg.Flavor = flavorElasticsearch
+	}
+	cfg.Username, _ = configMap["username"].(string)
+	cfg.Password, _ = configMap["password"].(string)
+	cfg.APIKey, _ = configMap["api_key"].(string)
+	switch {
+	case cfg.APIKey != "" && cfg.Flavor == flavorOpenSearch:
+		errs = append(errs, fmt.Errorf("api_key is not supported by OpenSearch; use username and password"))
+	case cfg.APIKey == "" && (cfg.Username == "" || cfg.Password == ""):
+		errs = append(errs, fmt.Errorf("username and password or api_key are required"))
+	}
+	cfg.CAFile, _ = configMap["ca_file"].(string)
+	if createUsers, ok := configMap["create_users"].(bool); ok {
+		cfg.CreateUsers = createUsers
+	}
+	if policy, ok := configMap["password_policy"].(map[string]interface{}); ok {
+		var err error
+		if cfg.PasswordPolicy, err = credential.Parse(policy); err != nil {
+			errs = append(errs, fmt.Errorf("password_policy: %v", err))
+		}
+	}
+
+	for level, privileges := range defaultPrivileges[cfg.Flavor] {
+		cfg.Privileges[level] = privileges
+	}
+	privileges, _ := configMap["privileges"].(map[string]interface{})
+	for level, value := range privileges {
+		if _, ok := cfg.Privileges[level]; !ok {
+			errs = append(errs, fmt.Errorf("privileges: unknown privilege level %q", level))
+			continue
+		}
+		list := stringList(value)
+		if len(list) == 0 {
+			errs = append(errs, fmt.Errorf("privileges.%s: privileges are required", level))
+			continue
+		}
+		cfg.Privileges[level] = list
+	}
+
+	resources, _ := configMap["resources"].(map[string]interface{})
+	for resourceID, value := range resources {
+		patterns := stringList(value)
+		if len(patterns) == 0 {
+			errs = append(errs, fmt.Errorf("resources.%s: index patterns are required", resourceID))
+			continue
+		}
+		cfg.Resources[resourceID] = patterns
+	}
+	if len(cfg.Resources) == 0 {
+		errs = append(errs, fmt.Errorf("resources is required"))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// stringList reads a list of strings, or a single string, from YAML
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// StartMonitoring does nothing; the cluster is checked by HealthCheck
+func (m *Module) StartMonitoring(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// StopMonitoring does nothing; access lasts until it is revoked
+func (m *Module) StopMonitoring(ctx context.Context) error {
+	return nil
+}
+
+// HealthCheck checks that the cluster accepts the module's credentials
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	path := "/_security/_authenticate"
+	if m.config.Flavor == flavorOpenSearch {
+		path = "/_plugins/_security/authinfo"
+	}
+	return m.call(ctx, http.MethodGet, path, nil, nil)
+}
+
+// name returns the name of the role, user and role mapping of a grant
+func name(grantID string) string {
+	return "apollo-" + grantID
+}
+
+// Provision creates the grant's role on the resource's index patterns and
+// gives it to a new user, returning its password, or to the user's existing
+// username
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	patterns, ok := m.config.Resources[grant.ResourceID]
+	if !ok {
+		return nil, modules.ErrNotHandled
+	}
+	privileges, ok := m.config.Privileges[grant.Level]
+	if !ok {
+		return nil, fmt.Errorf("unknown privilege level %q", grant.Level)
+	}
+	if !grantIDPattern.MatchString(grant.GrantID) {
+		return nil, fmt.Errorf("invalid grant ID %q", grant.GrantID)
+	}
+
+	var password string
+	if m.config.CreateUsers {
+		var err error
+		if password, err = m.config.PasswordPolicy.Generate(); err != nil {
+			return nil, err
+		}
+	}
+
+	role := name(grant.GrantID)
+	if err := m.putRole(ctx, role, patterns, privileges, grant); err != nil {
+		return nil, fmt.Errorf("failed to create role %s: %v", role, err)
+	}
+	credentials := map[string]string{"url": m.config.URL, "role": role}
+	var err error
+	if m.config.CreateUsers {
+		credentials["username"] = name(grant.GrantID)
+		credentials["password"] = password
+		err = m.putUser(ctx, credentials["username"], credentials["password"], role, grant)
+	} else {
+		credentials["username"] = grant.UserID
+		err = m.putRoleMapping(ctx, role, grant.UserID)
+	}
+	if err != nil {
+		// The role alone gives nobody access, but shouldn't be left behind
+		if rerr := m.revoke(ctx, grant.GrantID); rerr != nil {
+			log.Printf("[ELASTICSEARCH] Failed to clean up grant %s: %v", grant.GrantID, rerr)
+		}
+		return nil, fmt.Errorf("failed to give role %s to %s: %v", role, credentials["username"], err)
+	}
+
+	log.Printf("[ELASTICSEARCH] Granted %s %s access to %s until %s",
+		grant.UserID, grant.Level, strings.Join(patterns, ","), grant.ExpiresAt.Format(time.RFC3339))
+	return credentials, nil
+}
+
+// metadata describes the grant on the objects created for it
+func metadata(grant modules.GrantRequest) map[string]string {
+	return map[string]string{
+		"apollo_grant_id":   grant.GrantID,
+		"apollo_user":       grant.UserID,
+		"apollo_expires_at": grant.ExpiresAt.UTC().Format(time.RFC3339),
+	}
+}
+
+// putRole creates the role allowing the privileges on the index patterns
+func (m *Module) putRole(ctx context.Context, role string, patterns, privileges []string, grant modules.GrantRequest) error {
+	if m.config.Flavor == flavorOpenSearch {
+		return m.call(ctx, http.MethodPut, "/_plugins/_security/api/roles/"+url.PathEscape(role), map[string]interface{}{
+			"description": fmt.Sprintf("Apollo grant %s for %s", grant.GrantID, grant.UserID),
+			"index_permissions": []map[string]interface{}{{
+				"index_patterns":  patterns,
+				"allowed_actions": privileges,
+			}},
+		}, nil)
+	}
+	return m.call(ctx, http.MethodPut, "/_security/role/"+url.PathEscape(role), map[string]interface{}{
+		"indices": []map[string]interface{}{{
+			"names":      patterns,
+			"privileges": privileges,
+		}},
+		"metadata": metadata(grant),
+	}, nil)
+}
+
+// putUser creates a user with the password and role
+func (m *Module) putUser(ctx context.Context, username, password, role string, grant modules.GrantRequest) error {
+	if m.config.Flavor == flavorOpenSearch {
+		if err := m.call(ctx, http.MethodPut, "/_plugins/_security/api/internalusers/"+url.PathEscape(username), map[string]interface{}{
+			"password":   password,
+			"attributes": metadata(grant),
+		}, nil); err != nil {
+			return err
+		}
+		return m.putRoleMapping(ctx, role, username)
+	}
+	return m.call(ctx, http.MethodPut, "/_security/user/"+url.PathEscape(username), map[string]interface{}{
+		"password":  password,
+		"roles":     []string{role},
+		"full_name": grant.UserID,
+		"metadata":  metadata(grant),
+	}, nil)
+}
+
+// putRoleMapping maps the role to the username
+func (m *Module) putRoleMapping(ctx context.Context, role, username string) error {
+	if m.config.Flavor == flavorOpenSearch {
+		return m.call(ctx, http.MethodPut, "/_plugins/_security/api/rolesmapping/"+url.PathEscape(role), map[string]interface{}{
+			"users": []string{username},
+		}, nil)
+	}
+	return m.call(ctx, http.MethodPut, "/_security/role_mapping/"+url.PathEscape(role), map[string]interface{}{
+		"enabled": true,
+		"roles":   []string{role},
+		"rules":   map[string]interface{}{"field": map[string]string{"username": username}},
+	}, nil)
+}
+
+// revoke deletes the user, role mapping and role of a grant, those giving
+// access first; deleting objects that are gone already does nothing
+func (m *Module) revoke(ctx context.Context, grantID string) error {
+	if !grantIDPattern.MatchString(grantID) {
+		return fmt.Errorf("invalid grant ID %q", grantID)
+	}
+	object := url.PathEscape(name(grantID))
+	paths := []string{"/_security/user/" + object, "/_security/role_mapping/" + object, "/_security/role/" + object}
+	if m.config.Flavor == flavorOpenSearch {
+		paths = []string{
+			"/_plugins/_security/api/internalusers/" + object,
+			"/_plugins/_security/api/rolesmapping/" + object,
+			"/_plugins/_security/api/roles/" + object,
+		}
+	}
+	for _, path := range paths {
+		if err := m.call(ctx, http.MethodDelete, path, nil, nil); err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("failed to delete %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// call sends a request to the cluster's API
+func (m *Module) call(ctx context.Context, method, path string, input, output interface{}) error {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return fmt.Errorf("failed to marshal request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, m.config.URL+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	if m.config.APIKey != "" {
+		req.Header.Set("Authorization", "ApiKey "+m.config.APIKey)
+	} else {
+		req.SetBasicAuth(m.config.Username, m.config.Password)
+	}
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call %s: %v", m.config.Flavor, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("%s returned status %d: %s", m.config.Flavor, resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode response: %v", err)
+	}
+	return nil
+}
+
+// HandleJob runs a job dispatched by the API: pings of the resources and
+// revocations of the access granted to them
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	if m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	switch jobType {
+	case "ping":
+		var ping struct {
+			Server string `json:"server"`
+		}
+		if err := json.Unmarshal(request, &ping); err != nil {
+			return "", fmt.Errorf("invalid ping request: %v", err)
+		}
+		if _, ok := m.config.Resources[ping.Server]; !ok {
+			return "", modules.ErrNotHandled
+		}
+		if err := m.HealthCheck(ctx); err != nil {
+			return "", err
+		}
+		return "pong", nil
+
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			UserID     string `json:"user_id"`
+			ResourceID string `json:"resource_id"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		if _, ok := m.config.Resources[revoke.ResourceID]; !ok {
+			return "", modules.ErrNotHandled
+		}
+		if err := m.revoke(ctx, revoke.GrantID); err != nil {
+			return "", err
+		}
+		log.Printf("[ELASTICSEARCH] Revoked grant %s of %s", revoke.GrantID, revoke.UserID)
+		return fmt.Sprintf("revoked access of %s to %s", revoke.UserID, revoke.ResourceID), nil
+
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
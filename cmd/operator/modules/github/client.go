@@ -0,0 +1,108 @@
+package github
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// errNotFound is returned for API resources that don't exist
+var errNotFound = errors.New("not found")
+
+// nextLink matches the URL of the next page in a Link header
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// client calls the GitHub REST API
+type client struct {
+	baseURL string
+	token   string
+	http    *http.Client
+}
+
+// newClient creates a client for the API at baseURL
+func newClient(baseURL, token string) *client {
+	return &client{
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		token:   token,
+		http:    &http.Client{Timeout: 15 * time.Second},
+	}
+}
+
+// call sends a request to the API path, or to a full URL such as a next page,
+// decodes the response into output and returns its headers
+func (c *client) call(ctx context.Context, method, path string, input, output interface{}) (http.Header, error) {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal GitHub request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	target := path
+	if !strings.HasPrefix(path, "https://") && !strings.HasPrefix(path, "http://") {
+		target = c.baseURL + path
+	}
+	req, err := http.NewRequestWithContext(ctx, method, target, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Bearer "+c.token)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	req.Header.Set("X-GitHub-Api-Version", "2022-11-28")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call GitHub: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return resp.Header, errNotFound
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return resp.Header, fmt.Errorf("GitHub returned status %d for %s %s: %s", resp.StatusCode, method, path, strings.TrimSpace(string(message)))
+	}
+	if output == nil || resp.StatusCode == http.StatusNoContent {
+		return resp.Header, nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return resp.Header, fmt.Errorf("failed to decode GitHub response: %v", err)
+	}
+	return resp.Header, nil
+}
+
+// each calls visit with every page of the list at path until visit returns
+// false or there are no more pages
+func each[T any](ctx context.Context, c *client, path string, visit func(page []T) bool) error {
+	for path != "" {
+		var page []T
+		header, err := c.call(ctx, http.MethodGet, path, nil, &page)
+		if err != nil {
+			return err
+		}
+		if !visit(page) {
+			return nil
+		}
+		path = ""
+		if match := nextLink.FindStringSubmatch(header.Get("Link")); match != nil {
+			// The token is only ever sent to the configured API
+			if !strings.HasPrefix(match[1], c.baseURL+"/") {
+				return fmt.Errorf("GitHub returned a next page outside %s", c.baseURL)
+			}
+			path = match[1]
+		}
+	}
+	return nil
+}
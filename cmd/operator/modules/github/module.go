@@ -0,0 +1,453 @@
+package github
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+)
+
+// defaultAPIURL is the API of github.com; GitHub Enterprise Server serves it
+// at https://<host>/api/v3
+const defaultAPIURL = "https://api.github.com"
+
+// loginPattern matches GitHub logins
+var loginPattern = regexp.MustCompile(`^[A-Za-z0-9](?:[A-Za-z0-9-]{0,38})$`)
+
+// namePattern matches the owners, repositories and team slugs of resources
+var namePattern = regexp.MustCompile(`^[A-Za-z0-9._-]+$`)
+
+// defaultPermissions are the repository permissions granted at each level
+var defaultPermissions = map[string]string{
+	"read":  "pull",
+	"write": "push",
+	"admin": "admin",
+	"root":  "admin",
+}
+
+// Resource is a repository or team users request access to
+type Resource struct {
+	// Owner is the user or organization owning the repository, or the
+	// organization of the team
+	Owner string
+	// Repository grants collaborator access to the repository
+	Repository string
+	// Team grants membership of the team with the slug
+	Team string
+}
+
+// String returns the resource as owner/repository or owner/team
+func (r Resource) String() string {
+	if r.Team != "" {
+		return r.Owner + "/" + r.Team
+	}
+	return r.Owner + "/" + r.Repository
+}
+
+// Config represents the GitHub module configuration
+type Config struct {
+	APIURL string
+	// Token is a token of an organization owner or a GitHub App installation
+	// allowed to manage the collaborators and teams of the resources
+	Token string
+	// Users maps Apollo users to their GitHub logins; users not listed have
+	// the login of their own name
+	Users map[string]string
+	// Resources maps resource IDs to the repositories and teams they grant
+	Resources map[string]Resource
+	// Permissions maps privilege levels to repository permissions: pull,
+	// triage, push, maintain or admin
+	Permissions map[string]string
+}
+
+// Module grants temporary access to GitHub repositories, as a collaborator,
+// and to organizations, as a team member. Revoking the grant at expiry removes
+// the collaborator or membership, and any invitation not yet accepted.
+type Module struct {
+	config *Config
+	client *client
+}
+
+// NewModule creates a new GitHub module
+func NewModule() *Module {
+	return &Module{}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "github"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Temporary GitHub repository collaborator and team access"
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// Initialize initializes the GitHub module
+func (m *Module) Initialize(config interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	m.client = newClient(cfg.APIURL, cfg.Token)
+	log.Printf("[GITHUB] Managing %d resources through %s", len(cfg.Resources), cfg.APIURL)
+	return nil
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found. Resources name a repository as
+// repository: owner/name or a team as team: org/slug.
+func parseConfig(config interface{}) (*Config, error) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for GitHub module")
+	}
+
+	cfg := &Config{
+		APIURL:      defaultAPIURL,
+		Users:       make(map[string]string),
+		Resources:   make(map[string]Resource),
+		Permissions: make(map[string]string),
+	}
+	for level, permission := range defaultPermissions {
+		cfg.Permissions[level] = permission
+	}
+	var errs []error
+	if apiURL, ok := configMap["api_url"].(string); ok && apiURL != "" {
+		cfg.APIURL = strings.TrimSuffix(apiURL, "/")
+	}
+	if u, err := url.Parse(cfg.APIURL); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("api_url must be an https URL"))
+	}
+	cfg.Token, _ = configMap["token"].(string)
+	if cfg.Token == "" {
+		errs = append(errs, fmt.Errorf("token is required"))
+	}
+
+	users, _ := configMap["users"].(map[string]interface{})
+	for userID, value := range users {
+		login, _ := value.(string)
+		if !loginPattern.MatchString(login) {
+			errs = append(errs, fmt.Errorf("users.%s: invalid GitHub login %q", userID, login))
+			continue
+		}
+		cfg.Users[userID] = login
+	}
+
+	permissions, _ := configMap["permissions"].(map[string]interface{})
+	for level, value := range permissions {
+		permission, _ := value.(string)
+		if _, ok := defaultPermissions[level]; !ok {
+			errs = append(errs, fmt.Errorf("permissions: unknown privilege level %q", level))
+			continue
+		}
+		switch permission {
+		case "pull", "triage", "push", "maintain", "admin":
+			cfg.Permissions[level] = permission
+		default:
+			errs = append(errs, fmt.Errorf("permissions.%s: unknown repository permission %q", level, permission))
+		}
+	}
+
+	resources, _ := configMap["resources"].(map[string]interface{})
+	for resourceID, value := range resources {
+		entry, _ := value.(map[string]interface{})
+		repository, _ := entry["repository"].(string)
+		team, _ := entry["team"].(string)
+		if (repository == "") == (team == "") {
+			errs = append(errs, fmt.Errorf("resources.%s: either repository or team is required", resourceID))
+			continue
+		}
+		name := repository + team
+		owner, rest, ok := strings.Cut(name, "/")
+		if !ok || !namePattern.MatchString(owner) || !namePattern.MatchString(rest) {
+			errs = append(errs, fmt.Errorf("resources.%s: %q is not owner/name", resourceID, name))
+			continue
+		}
+		resource := Resource{Owner: owner}
+		if team != "" {
+			resource.Team = rest
+		} else {
+			resource.Repository = rest
+		}
+		cfg.Resources[resourceID] = resource
+	}
+	if len(cfg.Resources) == 0 {
+		errs = append(errs, fmt.Errorf("resources is required"))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// StartMonitoring does nothing; GitHub is checked by HealthCheck
+func (m *Module) StartMonitoring(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// StopMonitoring does nothing; access lasts until it is revoked
+func (m *Module) StopMonitoring(ctx context.Context) error {
+	return nil
+}
+
+// HealthCheck checks that GitHub accepts the module's token
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	_, err := m.client.call(ctx, http.MethodGet, "/rate_limit", nil, nil)
+	return err
+}
+
+// login returns the GitHub login of the user
+func (m *Module) login(userID string) (string, error) {
+	login, ok := m.config.Users[userID]
+	if !ok {
+		login = userID
+	}
+	if !loginPattern.MatchString(login) {
+		return "", fmt.Errorf("user %s has no valid GitHub login; map it in users", userID)
+	}
+	return login, nil
+}
+
+// resource returns the resource with the ID; ErrNotHandled for resources the
+// module doesn't manage
+func (m *Module) resource(resourceID string) (Resource, error) {
+	resource, ok := m.config.Resources[resourceID]
+	if !ok {
+		return Resource{}, modules.ErrNotHandled
+	}
+	return resource, nil
+}
+
+// repositoryPath returns the API path of the resource's repository, followed by rest
+func repositoryPath(resource Resource, rest string) string {
+	return "/repos/" + url.PathEscape(resource.Owner) + "/" + url.PathEscape(resource.Repository) + rest
+}
+
+// membershipPath returns the API path of the login's membership of the resource's team
+func membershipPath(resource Resource, login string) string {
+	return "/orgs/" + url.PathEscape(resource.Owner) + "/teams/" + url.PathEscape(resource.Team) + "/memberships/" + url.PathEscape(login)
+}
+
+// Provision makes the user a collaborator on the grant's repository, or a
+// member of its team. Users with that access already are refused, as revoking
+// the grant would take away access Apollo didn't give.
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	resource, err := m.resource(grant.ResourceID)
+	if err != nil {
+		return nil, err
+	}
+	login, err := m.login(grant.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	var credentials map[string]string
+	if resource.Team != "" {
+		credentials, err = m.addTeamMember(ctx, resource, login, grant.Level)
+	} else {
+		credentials, err = m.addCollaborator(ctx, resource, login, grant.Level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[GITHUB] Granted %s %s access to %s until %s", login, grant.Level, resource, grant.ExpiresAt.Format(time.RFC3339))
+	return credentials, nil
+}
+
+// addCollaborator adds the login as a direct collaborator with the level's
+// permission; GitHub invites users outside the organization
+func (m *Module) addCollaborator(ctx context.Context, resource Resource, login, level string) (map[string]string, error) {
+	permission, ok := m.config.Permissions[level]
+	if !ok {
+		return nil, fmt.Errorf("unknown privilege level %q", level)
+	}
+
+	var collaborator bool
+	err := each(ctx, m.client, repositoryPath(resource, "/collaborators?affiliation=direct&per_page=100"), func(page []struct {
+		Login string `json:"login"`
+	}) bool {
+		for _, user := range page {
+			if strings.EqualFold(user.Login, login) {
+				collaborator = true
+				return false
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to list collaborators of %s: %v", resource, err)
+	}
+	if collaborator {
+		return nil, fmt.Errorf("%s is a collaborator on %s already", login, resource)
+	}
+
+	var invitation struct {
+		ID int64 `json:"id"`
+	}
+	if _, err := m.client.call(ctx, http.MethodPut, repositoryPath(resource, "/collaborators/"+url.PathEscape(login)),
+		map[string]string{"permission": permission}, &invitation); err != nil {
+		return nil, fmt.Errorf("failed to add %s to %s: %v", login, resource, err)
+	}
+	status := "added"
+	if invitation.ID != 0 {
+		status = "invited"
+	}
+	return map[string]string{
+		"repository": resource.String(),
+		"login":      login,
+		"permission": permission,
+		"status":     status,
+	}, nil
+}
+
+// addTeamMember adds the login to the team, as a maintainer for admin and root
+func (m *Module) addTeamMember(ctx context.Context, resource Resource, login, level string) (map[string]string, error) {
+	path := membershipPath(resource, login)
+	_, err := m.client.call(ctx, http.MethodGet, path, nil, nil)
+	if err == nil {
+		return nil, fmt.Errorf("%s is a member of %s already", login, resource)
+	}
+	if !errors.Is(err, errNotFound) {
+		return nil, fmt.Errorf("failed to look up membership of %s in %s: %v", login, resource, err)
+	}
+
+	role := "member"
+	if level == "admin" || level == "root" {
+		role = "maintainer"
+	}
+	var membership struct {
+		State string `json:"state"`
+	}
+	if _, err := m.client.call(ctx, http.MethodPut, path, map[string]string{"role": role}, &membership); err != nil {
+		return nil, fmt.Errorf("failed to add %s to %s: %v", login, resource, err)
+	}
+	return map[string]string{
+		"team":   resource.String(),
+		"login":  login,
+		"role":   role,
+		"status": membership.State,
+	}, nil
+}
+
+// revoke removes the login's access to the resource, and any invitation it
+// hasn't accepted; removing access that is gone already does nothing
+func (m *Module) revoke(ctx context.Context, resource Resource, login string) error {
+	if resource.Team != "" {
+		_, err := m.client.call(ctx, http.MethodDelete, membershipPath(resource, login), nil, nil)
+		if err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("failed to remove %s from %s: %v", login, resource, err)
+		}
+		return nil
+	}
+
+	_, err := m.client.call(ctx, http.MethodDelete, repositoryPath(resource, "/collaborators/"+url.PathEscape(login)), nil, nil)
+	if err != nil && !errors.Is(err, errNotFound) {
+		return fmt.Errorf("failed to remove %s from %s: %v", login, resource, err)
+	}
+	var invitations []int64
+	err = each(ctx, m.client, repositoryPath(resource, "/invitations?per_page=100"), func(page []struct {
+		ID      int64 `json:"id"`
+		Invitee struct {
+			Login string `json:"login"`
+		} `json:"invitee"`
+	}) bool {
+		for _, invitation := range page {
+			if strings.EqualFold(invitation.Invitee.Login, login) {
+				invitations = append(invitations, invitation.ID)
+			}
+		}
+		return true
+	})
+	if err != nil {
+		return fmt.Errorf("failed to list invitations of %s: %v", resource, err)
+	}
+	for _, id := range invitations {
+		_, err := m.client.call(ctx, http.MethodDelete, repositoryPath(resource, fmt.Sprintf("/invitations/%d", id)), nil, nil)
+		if err != nil && !errors.Is(err, errNotFound) {
+			return fmt.Errorf("failed to cancel invitation of %s to %s: %v", login, resource, err)
+		}
+	}
+	return nil
+}
+
+// HandleJob runs a job dispatched by the API: pings of the resources and
+// revocations of the access granted to them
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	if m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	switch jobType {
+	case "ping":
+		var ping struct {
+			Server string `json:"server"`
+		}
+		if err := json.Unmarshal(request, &ping); err != nil {
+			return "", fmt.Errorf("invalid ping request: %v", err)
+		}
+		resource, err := m.resource(ping.Server)
+		if err != nil {
+			return "", err
+		}
+		path := repositoryPath(resource, "")
+		if resource.Team != "" {
+			path = "/orgs/" + url.PathEscape(resource.Owner) + "/teams/" + url.PathEscape(resource.Team)
+		}
+		if _, err := m.client.call(ctx, http.MethodGet, path, nil, nil); err != nil {
+			return "", fmt.Errorf("failed to reach %s: %v", resource, err)
+		}
+		return "pong", nil
+
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			UserID     string `json:"user_id"`
+			ResourceID string `json:"resource_id"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		resource, err := m.resource(revoke.ResourceID)
+		if err != nil {
+			return "", err
+		}
+		login, err := m.login(revoke.UserID)
+		if err != nil {
+			return "", err
+		}
+		if err := m.revoke(ctx, resource, login); err != nil {
+			return "", err
+		}
+		log.Printf("[GITHUB] Revoked access of %s to %s for grant %s", login, resource, revoke.GrantID)
+		return fmt.Sprintf("revoked access of %s to %s", login, resource), nil
+
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
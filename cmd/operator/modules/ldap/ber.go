@@ -0,0 +1,185 @@
+package ldap
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// BER tags of the LDAP messages and fields the module uses (RFC 4511)
+const (
+	tagBoolean     = 0x01
+	tagInteger     = 0x02
+	tagOctetString = 0x04
+	tagEnumerated  = 0x0a
+	tagSequence    = 0x30
+	tagSet         = 0x31
+
+	tagBindRequest      = 0x60
+	tagBindResponse     = 0x61
+	tagUnbindRequest    = 0x42
+	tagSearchRequest    = 0x63
+	tagSearchEntry      = 0x64
+	tagSearchDone       = 0x65
+	tagSearchReference  = 0x73
+	tagModifyRequest    = 0x66
+	tagModifyResponse   = 0x67
+	tagExtendedRequest  = 0x77
+	tagExtendedResponse = 0x78
+	tagSimpleAuth       = 0x80
+	tagExtendedName     = 0x80
+	tagEqualityMatch    = 0xa3
+
+	// constructed marks the tags of elements that contain elements
+	constructed = 0x20
+)
+
+// Limits of the BER lengths the module reads
+const (
+	// longForm marks lengths given in the octets that follow
+	longForm = 0x80
+	// maxLengthOctets is the most octets of a long form length
+	maxLengthOctets = 4
+	// maxMessageSize is the largest LDAP message the module reads
+	maxMessageSize = 1 << 20
+)
+
+// element is a decoded BER element: its tag, its content and, for
+// constructed elements, the elements it contains
+type element struct {
+	tag      byte
+	content  []byte
+	children []element
+}
+
+// encode returns the element with the tag and content in BER
+func encode(tag byte, content []byte) []byte {
+	length := len(content)
+	var header []byte
+	switch {
+	case length < longForm:
+		header = []byte{tag, byte(length)}
+	default:
+		var octets []byte
+		for n := length; n > 0; n >>= 8 {
+			octets = append([]byte{byte(n)}, octets...)
+		}
+		header = append([]byte{tag, longForm | byte(len(octets))}, octets...)
+	}
+	return append(header, content...)
+}
+
+// sequence encodes the elements as a constructed element with the tag
+func sequence(tag byte, elements ...[]byte) []byte {
+	var content []byte
+	for _, e := range elements {
+		content = append(content, e...)
+	}
+	return encode(tag, content)
+}
+
+// octetString encodes a string
+func octetString(s string) []byte {
+	return encode(tagOctetString, []byte(s))
+}
+
+// integer encodes a non-negative integer with the tag, e.g. tagEnumerated
+func integer(tag byte, n int) []byte {
+	content := []byte{byte(n)}
+	for n >>= 8; n > 0; n >>= 8 {
+		content = append([]byte{byte(n)}, content...)
+	}
+	// A leading 1 bit would make the integer negative
+	if content[0]&0x80 != 0 {
+		content = append([]byte{0}, content...)
+	}
+	return encode(tag, content)
+}
+
+// boolean encodes a boolean
+func boolean(b bool) []byte {
+	if b {
+		return encode(tagBoolean, []byte{0xff})
+	}
+	return encode(tagBoolean, []byte{0})
+}
+
+// readElement reads one BER element, such as an LDAP message, from r
+func readElement(r *bufio.Reader) (element, error) {
+	tag, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	first, err := r.ReadByte()
+	if err != nil {
+		return element{}, err
+	}
+	length := int(first)
+	if first&longForm != 0 {
+		count := int(first &^ longForm)
+		if count == 0 || count > maxLengthOctets {
+			return element{}, fmt.Errorf("unsupported BER length of %d octets", count)
+		}
+		length = 0
+		for i := 0; i < count; i++ {
+			b, err := r.ReadByte()
+			if err != nil {
+				return element{}, err
+			}
+			length = length<<8 | int(b)
+		}
+	}
+	if length > maxMessageSize {
+		return element{}, fmt.Errorf("LDAP message of %d bytes is too large", length)
+	}
+	content := make([]byte, length)
+	if _, err := io.ReadFull(r, content); err != nil {
+		return element{}, err
+	}
+	return parseElement(tag, content)
+}
+
+// parseElement decodes the elements contained in constructed content
+func parseElement(tag byte, content []byte) (element, error) {
+	e := element{tag: tag, content: content}
+	if tag&constructed == 0 {
+		return e, nil
+	}
+	for rest := content; len(rest) > 0; {
+		if len(rest) < 2 {
+			return element{}, fmt.Errorf("truncated BER element")
+		}
+		childTag, first := rest[0], rest[1]
+		offset, length := 2, int(first)
+		if first&longForm != 0 {
+			count := int(first &^ longForm)
+			if count == 0 || count > maxLengthOctets || len(rest) < 2+count {
+				return element{}, fmt.Errorf("invalid BER length")
+			}
+			length = 0
+			for _, b := range rest[2 : 2+count] {
+				length = length<<8 | int(b)
+			}
+			offset += count
+		}
+		if length < 0 || len(rest) < offset+length {
+			return element{}, fmt.Errorf("truncated BER element")
+		}
+		child, err := parseElement(childTag, rest[offset:offset+length])
+		if err != nil {
+			return element{}, err
+		}
+		e.children = append(e.children, child)
+		rest = rest[offset+length:]
+	}
+	return e, nil
+}
+
+// int decodes an integer or enumerated element
+func (e element) int() int {
+	n := 0
+	for _, b := range e.content {
+		n = n<<8 | int(b)
+	}
+	return n
+}
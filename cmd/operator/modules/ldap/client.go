@@ -0,0 +1,223 @@
+package ldap
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"net/url"
+	"time"
+)
+
+// Result codes of LDAP operations the module tells apart (RFC 4511)
+const (
+	resultSuccess                = 0
+	resultSizeLimitExceeded      = 4
+	resultNoSuchAttribute        = 16
+	resultAttributeOrValueExists = 20
+)
+
+// Operations of a modify request
+const (
+	modifyAdd    = 0
+	modifyDelete = 1
+)
+
+// startTLSOID names the StartTLS extended operation
+const startTLSOID = "1.3.6.1.4.1.1466.20037"
+
+// operationTimeout bounds each connection to the directory when the context
+// has no deadline
+const operationTimeout = 10 * time.Second
+
+// resultError is an LDAP operation that didn't succeed
+type resultError struct {
+	operation string
+	code      int
+	message   string
+}
+
+func (e *resultError) Error() string {
+	if e.message == "" {
+		return fmt.Sprintf("%s failed with LDAP result %d", e.operation, e.code)
+	}
+	return fmt.Sprintf("%s failed with LDAP result %d: %s", e.operation, e.code, e.message)
+}
+
+// conn is an authenticated connection to the directory. It sends one request
+// at a time.
+type conn struct {
+	conn   net.Conn
+	reader *bufio.Reader
+	nextID int
+}
+
+// dial connects to the directory over LDAPS or, with StartTLS, LDAP, and binds
+// as the module's account
+func dial(ctx context.Context, cfg *Config) (*conn, error) {
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid url: %v", err)
+	}
+	address := u.Host
+	if u.Port() == "" {
+		port := "389"
+		if u.Scheme == "ldaps" {
+			port = "636"
+		}
+		address = net.JoinHostPort(u.Hostname(), port)
+	}
+	tlsConfig := &tls.Config{ServerName: u.Hostname(), InsecureSkipVerify: cfg.InsecureSkipVerify}
+
+	dialer := &net.Dialer{Timeout: operationTimeout}
+	var raw net.Conn
+	if u.Scheme == "ldaps" {
+		raw, err = (&tls.Dialer{NetDialer: dialer, Config: tlsConfig}).DialContext(ctx, "tcp", address)
+	} else {
+		raw, err = dialer.DialContext(ctx, "tcp", address)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %v", address, err)
+	}
+	deadline, ok := ctx.Deadline()
+	if !ok {
+		deadline = time.Now().Add(operationTimeout)
+	}
+	raw.SetDeadline(deadline)
+
+	c := &conn{conn: raw, reader: bufio.NewReader(raw)}
+	if u.Scheme == "ldap" && cfg.StartTLS {
+		if err := c.startTLS(tlsConfig); err != nil {
+			raw.Close()
+			return nil, err
+		}
+	}
+	if err := c.bind(cfg.BindDN, cfg.BindPassword); err != nil {
+		c.close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// request sends an operation and returns the operation of the response
+func (c *conn) request(operation []byte) (element, error) {
+	c.nextID++
+	if _, err := c.conn.Write(sequence(tagSequence, integer(tagInteger, c.nextID), operation)); err != nil {
+		return element{}, fmt.Errorf("failed to send LDAP request: %v", err)
+	}
+	return c.response()
+}
+
+// response reads the operation of the next response to the last request
+func (c *conn) response() (element, error) {
+	message, err := readElement(c.reader)
+	if err != nil {
+		return element{}, fmt.Errorf("failed to read LDAP response: %v", err)
+	}
+	if message.tag != tagSequence || len(message.children) < 2 {
+		return element{}, fmt.Errorf("malformed LDAP response")
+	}
+	if id := message.children[0].int(); id != c.nextID {
+		return element{}, fmt.Errorf("LDAP response to message %d while waiting for %d", id, c.nextID)
+	}
+	return message.children[1], nil
+}
+
+// result returns the error of a response's LDAP result, nil on success
+func result(operation string, response element, tag byte) error {
+	if response.tag != tag || len(response.children) < 3 {
+		return fmt.Errorf("unexpected response to %s", operation)
+	}
+	code := response.children[0].int()
+	if code == resultSuccess {
+		return nil
+	}
+	return &resultError{operation: operation, code: code, message: string(response.children[2].content)}
+}
+
+// startTLS upgrades the connection to TLS
+func (c *conn) startTLS(config *tls.Config) error {
+	response, err := c.request(sequence(tagExtendedRequest, encode(tagExtendedName, []byte(startTLSOID))))
+	if err != nil {
+		return err
+	}
+	if err := result("StartTLS", response, tagExtendedResponse); err != nil {
+		return err
+	}
+	tlsConn := tls.Client(c.conn, config)
+	if err := tlsConn.Handshake(); err != nil {
+		return fmt.Errorf("StartTLS handshake failed: %v", err)
+	}
+	c.conn = tlsConn
+	c.reader = bufio.NewReader(tlsConn)
+	return nil
+}
+
+// bind authenticates with a simple bind
+func (c *conn) bind(dn, password string) error {
+	response, err := c.request(sequence(tagBindRequest,
+		integer(tagInteger, 3),
+		octetString(dn),
+		encode(tagSimpleAuth, []byte(password)),
+	))
+	if err != nil {
+		return err
+	}
+	return result("bind", response, tagBindResponse)
+}
+
+// searchDNs returns the DNs of the entries below base whose attribute has the
+// value, at most limit of them
+func (c *conn) searchDNs(base, attribute, value string, limit int) ([]string, error) {
+	response, err := c.request(sequence(tagSearchRequest,
+		octetString(base),
+		integer(tagEnumerated, 2), // wholeSubtree
+		integer(tagEnumerated, 0), // neverDerefAliases
+		integer(tagInteger, limit),
+		integer(tagInteger, int(operationTimeout.Seconds())),
+		boolean(false),
+		sequence(tagEqualityMatch, octetString(attribute), octetString(value)),
+		// No attributes; the DN is all the module needs
+		sequence(tagSequence, octetString("1.1")),
+	))
+	var dns []string
+	for err == nil {
+		switch response.tag {
+		case tagSearchEntry:
+			if len(response.children) > 0 {
+				dns = append(dns, string(response.children[0].content))
+			}
+		case tagSearchReference:
+			// Referrals to other servers are not followed
+		default:
+			return dns, result("search", response, tagSearchDone)
+		}
+		response, err = c.response()
+	}
+	return nil, err
+}
+
+// modify adds or deletes a value of an entry's attribute
+func (c *conn) modify(dn string, operation int, attribute, value string) error {
+	response, err := c.request(sequence(tagModifyRequest,
+		octetString(dn),
+		sequence(tagSequence,
+			sequence(tagSequence,
+				integer(tagEnumerated, operation),
+				sequence(tagSequence, octetString(attribute), sequence(tagSet, octetString(value))),
+			),
+		),
+	))
+	if err != nil {
+		return err
+	}
+	return result("modify", response, tagModifyResponse)
+}
+
+// close unbinds and closes the connection
+func (c *conn) close() {
+	c.nextID++
+	c.conn.Write(sequence(tagSequence, integer(tagInteger, c.nextID), encode(tagUnbindRequest, nil)))
+	c.conn.Close()
+}
@@ -0,0 +1,353 @@
+package ldap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+)
+
+// Defaults of the module's configuration
+const (
+	defaultUserAttribute   = "sAMAccountName"
+	defaultMemberAttribute = "member"
+)
+
+// anyLevel keys the group of a resource granted at every level
+const anyLevel = "*"
+
+// Config represents the LDAP module configuration
+type Config struct {
+	// URL is the directory's address, ldaps://host or ldap://host
+	URL string
+	// StartTLS upgrades ldap:// connections to TLS before binding
+	StartTLS bool
+	// InsecureSkipVerify accepts any certificate of the directory, for testing
+	InsecureSkipVerify bool
+	// BindDN and BindPassword are the account the module manages groups as
+	BindDN       string
+	BindPassword string
+	// UserBaseDN is where users are looked up by UserAttribute, which holds
+	// their Apollo user ID
+	UserBaseDN    string
+	UserAttribute string
+	// MemberAttribute is the group attribute listing members: member or
+	// uniqueMember hold their DNs, memberUid their user IDs
+	MemberAttribute string
+	// Groups maps resources to the DNs of the groups granted at each level;
+	// anyLevel keys a group granted at every level
+	Groups map[string]map[string]string
+}
+
+// Module grants temporary membership of LDAP and Active Directory groups, for
+// systems that decide access by directory group. Approved grants add the user
+// to the group of the resource and level, and revoking them at expiry removes
+// the user again.
+type Module struct {
+	config *Config
+}
+
+// NewModule creates a new LDAP module
+func NewModule() *Module {
+	return &Module{}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "ldap"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Temporary membership of LDAP and Active Directory groups"
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// Initialize initializes the LDAP module
+func (m *Module) Initialize(config interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	log.Printf("[LDAP] Managing %d group resources in %s", len(cfg.Groups), cfg.URL)
+	return nil
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found. A resource's group is a DN for every
+// level or a map of levels to DNs.
+func parseConfig(config interface{}) (*Config, error) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for LDAP module")
+	}
+
+	cfg := &Config{
+		UserAttribute:   defaultUserAttribute,
+		MemberAttribute: defaultMemberAttribute,
+		Groups:          make(map[string]map[string]string),
+	}
+	var errs []error
+	cfg.URL, _ = configMap["url"].(string)
+	if u, err := url.Parse(cfg.URL); cfg.URL == "" || err != nil || (u.Scheme != "ldap" && u.Scheme != "ldaps") || u.Host == "" {
+		errs = append(errs, fmt.Errorf("url must be ldap://host or ldaps://host"))
+	}
+	cfg.StartTLS, _ = configMap["start_tls"].(bool)
+	cfg.InsecureSkipVerify, _ = configMap["insecure_skip_verify"].(bool)
+	if strings.HasPrefix(cfg.URL, "ldap://") && !cfg.StartTLS {
+		errs = append(errs, fmt.Errorf("ldap:// needs start_tls; the bind password would be sent in the clear"))
+	}
+	cfg.BindDN, _ = configMap["bind_dn"].(string)
+	cfg.BindPassword, _ = configMap["bind_password"].(string)
+	if cfg.BindDN == "" || cfg.BindPassword == "" {
+		errs = append(errs, fmt.Errorf("bind_dn and bind_password are required"))
+	}
+	if attribute, ok := configMap["member_attribute"].(string); ok && attribute != "" {
+		cfg.MemberAttribute = attribute
+	}
+	if attribute, ok := configMap["user_attribute"].(string); ok && attribute != "" {
+		cfg.UserAttribute = attribute
+	}
+	cfg.UserBaseDN, _ = configMap["user_base_dn"].(string)
+	if cfg.UserBaseDN == "" && !cfg.memberUID() {
+		errs = append(errs, fmt.Errorf("user_base_dn is required"))
+	}
+
+	groups, _ := configMap["groups"].(map[string]interface{})
+	for resourceID, value := range groups {
+		levels := make(map[string]string)
+		switch value := value.(type) {
+		case string:
+			levels[anyLevel] = value
+		case map[string]interface{}:
+			for level, dn := range value {
+				if !knownLevel(level) {
+					errs = append(errs, fmt.Errorf("groups.%s: unknown privilege level %q", resourceID, level))
+					continue
+				}
+				levels[level], _ = dn.(string)
+			}
+		}
+		if len(levels) == 0 {
+			errs = append(errs, fmt.Errorf("groups.%s: group DN is required", resourceID))
+		}
+		for level, dn := range levels {
+			if !strings.Contains(dn, "=") {
+				errs = append(errs, fmt.Errorf("groups.%s: invalid group DN %q for %s", resourceID, dn, level))
+			}
+		}
+		cfg.Groups[resourceID] = levels
+	}
+	if len(cfg.Groups) == 0 {
+		errs = append(errs, fmt.Errorf("groups is required"))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// memberUID reports whether groups list their members by user ID rather than DN
+func (c *Config) memberUID() bool {
+	return strings.EqualFold(c.MemberAttribute, "memberUid")
+}
+
+// knownLevel reports whether the level is one of Apollo's privilege levels
+func knownLevel(level string) bool {
+	switch level {
+	case "read", "write", "admin", "root":
+		return true
+	}
+	return false
+}
+
+// StartMonitoring does nothing; the directory is checked by HealthCheck
+func (m *Module) StartMonitoring(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// StopMonitoring does nothing; memberships last until they are revoked
+func (m *Module) StopMonitoring(ctx context.Context) error {
+	return nil
+}
+
+// HealthCheck checks that the module can bind to the directory
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	c, err := dial(ctx, m.config)
+	if err != nil {
+		return err
+	}
+	c.close()
+	return nil
+}
+
+// group returns the DN of the group granted for a resource at a level;
+// ErrNotHandled for resources the module doesn't manage
+func (m *Module) group(resourceID, level string) (string, error) {
+	levels, ok := m.config.Groups[resourceID]
+	if !ok {
+		return "", modules.ErrNotHandled
+	}
+	if dn, ok := levels[level]; ok {
+		return dn, nil
+	}
+	if dn, ok := levels[anyLevel]; ok {
+		return dn, nil
+	}
+	return "", fmt.Errorf("resource %s has no group for %s access", resourceID, level)
+}
+
+// member returns the value of the user in the groups' member attribute: the
+// DN of the one entry holding the user ID, or the user ID itself
+func (m *Module) member(c *conn, userID string) (string, error) {
+	if m.config.memberUID() {
+		return userID, nil
+	}
+	dns, err := c.searchDNs(m.config.UserBaseDN, m.config.UserAttribute, userID, 2)
+	var resultErr *resultError
+	if errors.As(err, &resultErr) && resultErr.code == resultSizeLimitExceeded {
+		return "", fmt.Errorf("several directory entries have %s %s", m.config.UserAttribute, userID)
+	}
+	if err != nil {
+		return "", err
+	}
+	switch len(dns) {
+	case 0:
+		return "", errUnknownUser
+	case 1:
+		return dns[0], nil
+	default:
+		return "", fmt.Errorf("several directory entries have %s %s", m.config.UserAttribute, userID)
+	}
+}
+
+// errUnknownUser is returned for users the directory doesn't have
+var errUnknownUser = errors.New("user not found in the directory")
+
+// Provision adds the user to the group of the grant's resource and level.
+// Users who are members already are refused, as revoking the grant would
+// take away a membership Apollo didn't give.
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	group, err := m.group(grant.ResourceID, grant.Level)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := dial(ctx, m.config)
+	if err != nil {
+		return nil, err
+	}
+	defer c.close()
+	member, err := m.member(c, grant.UserID)
+	if err != nil {
+		return nil, fmt.Errorf("user %s: %v", grant.UserID, err)
+	}
+	err = c.modify(group, modifyAdd, m.config.MemberAttribute, member)
+	var resultErr *resultError
+	if errors.As(err, &resultErr) && resultErr.code == resultAttributeOrValueExists {
+		return nil, fmt.Errorf("%s is a member of %s already", grant.UserID, group)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to add %s to %s: %v", grant.UserID, group, err)
+	}
+
+	log.Printf("[LDAP] Added %s to %s until %s", grant.UserID, group, grant.ExpiresAt.Format(time.RFC3339))
+	return map[string]string{"group": group}, nil
+}
+
+// revoke removes the user from the group; removing a user who isn't a member
+// does nothing
+func (m *Module) revoke(ctx context.Context, userID, group string) error {
+	c, err := dial(ctx, m.config)
+	if err != nil {
+		return err
+	}
+	defer c.close()
+	member, err := m.member(c, userID)
+	if errors.Is(err, errUnknownUser) {
+		// Deleting the user removed them from the group
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("user %s: %v", userID, err)
+	}
+	err = c.modify(group, modifyDelete, m.config.MemberAttribute, member)
+	var resultErr *resultError
+	if errors.As(err, &resultErr) && resultErr.code == resultNoSuchAttribute {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to remove %s from %s: %v", userID, group, err)
+	}
+	return nil
+}
+
+// HandleJob runs a job dispatched by the API: pings of the resources and
+// revocations of the memberships granted for them
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	if m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	switch jobType {
+	case "ping":
+		var ping struct {
+			Server string `json:"server"`
+		}
+		if err := json.Unmarshal(request, &ping); err != nil {
+			return "", fmt.Errorf("invalid ping request: %v", err)
+		}
+		if _, ok := m.config.Groups[ping.Server]; !ok {
+			return "", modules.ErrNotHandled
+		}
+		if err := m.HealthCheck(ctx); err != nil {
+			return "", err
+		}
+		return "pong", nil
+
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			UserID     string `json:"user_id"`
+			ResourceID string `json:"resource_id"`
+			Level      string `json:"level"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		group, err := m.group(revoke.ResourceID, revoke.Level)
+		if err != nil {
+			return "", err
+		}
+		if err := m.revoke(ctx, revoke.UserID, group); err != nil {
+			return "", err
+		}
+		log.Printf("[LDAP] Removed %s from %s for grant %s", revoke.UserID, group, revoke.GrantID)
+		return fmt.Sprintf("removed %s from %s", revoke.UserID, group), nil
+
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
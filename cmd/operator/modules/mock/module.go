@@ -0,0 +1,225 @@
+package mock
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/credential"
+	"github.com/petermein/apollo/internal/simulate"
+)
+
+// Config represents the mock module configuration
+type Config struct {
+	// Servers are the simulated servers this operator manages; every server
+	// of the module when empty
+	Servers   []string
+	Simulator simulate.Config
+}
+
+// Module implements a simulated target system. Pings, grants and revocations
+// take the configured latency and fail at the configured rate, and granted
+// users are only kept in memory, so the whole pipeline can run without a
+// database or cluster.
+type Module struct {
+	config *Config
+
+	mu     sync.Mutex
+	grants map[string]modules.GrantRequest
+}
+
+// NewModule creates a new mock module
+func NewModule() *Module {
+	return &Module{grants: make(map[string]modules.GrantRequest)}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "mock"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Simulated target system for demos and testing without real servers"
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// Initialize initializes the mock module
+func (m *Module) Initialize(config interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	log.Printf("[MOCK] Simulating latency %s±%s and a failure rate of %.0f%%",
+		cfg.Simulator.Latency, cfg.Simulator.Jitter, cfg.Simulator.FailureRate*100)
+	return nil
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found
+func parseConfig(config interface{}) (*Config, error) {
+	// The module runs with its defaults when it isn't configured
+	if config == nil {
+		return &Config{}, nil
+	}
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for mock module")
+	}
+
+	cfg := &Config{}
+	var errs []error
+	simulator, err := simulate.Parse(configMap)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	cfg.Simulator = simulator
+	if servers, ok := configMap["servers"].([]interface{}); ok {
+		for i, server := range servers {
+			name, ok := server.(string)
+			if !ok || name == "" {
+				errs = append(errs, fmt.Errorf("servers[%d]: expected a server name", i))
+				continue
+			}
+			cfg.Servers = append(cfg.Servers, name)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// manages reports whether the operator manages the simulated server
+func (m *Module) manages(server string) bool {
+	if len(m.config.Servers) == 0 {
+		return true
+	}
+	for _, name := range m.config.Servers {
+		if name == server {
+			return true
+		}
+	}
+	return false
+}
+
+// StartMonitoring does nothing; the API registers the simulated servers
+func (m *Module) StartMonitoring(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// StopMonitoring forgets the simulated grants
+func (m *Module) StopMonitoring(ctx context.Context) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if len(m.grants) > 0 {
+		log.Printf("[MOCK] Forgetting %d simulated grants", len(m.grants))
+	}
+	m.grants = make(map[string]modules.GrantRequest)
+	return nil
+}
+
+// HealthCheck reports the module healthy once it is initialized
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// Provision simulates creating a user for the grant and returns made up
+// credentials for it
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	if !m.manages(grant.ResourceID) {
+		return nil, modules.ErrNotHandled
+	}
+	if _, err := m.config.Simulator.Run(ctx, "grant "+grant.GrantID); err != nil {
+		return nil, err
+	}
+
+	password, err := credential.Generate()
+	if err != nil {
+		return nil, err
+	}
+	hash := sha256.Sum256([]byte(grant.GrantID))
+	username := "apollo_" + hex.EncodeToString(hash[:8])
+	m.mu.Lock()
+	m.grants[grant.GrantID] = grant
+	m.mu.Unlock()
+	log.Printf("[MOCK] Granted %s %s access to %s as %s until %s",
+		grant.UserID, grant.Level, grant.ResourceID, username, grant.ExpiresAt.Format(time.RFC3339))
+	return map[string]string{
+		"host":     grant.ResourceID + ".mock",
+		"username": username,
+		"password": password,
+	}, nil
+}
+
+// HandleJob runs a job dispatched by the API: pings of the simulated servers
+// and revocations of the grants on them
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	if m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	switch jobType {
+	case "ping":
+		var ping struct {
+			Server string `json:"server"`
+		}
+		if err := json.Unmarshal(request, &ping); err != nil {
+			return "", fmt.Errorf("invalid ping request: %v", err)
+		}
+		if !m.manages(ping.Server) {
+			return "", modules.ErrNotHandled
+		}
+		took, err := m.config.Simulator.Run(ctx, "ping "+ping.Server)
+		if err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("pong in %s", took.Round(time.Millisecond)), nil
+
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			UserID     string `json:"user_id"`
+			ResourceID string `json:"resource_id"`
+			Level      string `json:"level"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		if !m.manages(revoke.ResourceID) {
+			return "", modules.ErrNotHandled
+		}
+		if _, err := m.config.Simulator.Run(ctx, "revoke "+revoke.GrantID); err != nil {
+			return "", err
+		}
+		m.mu.Lock()
+		delete(m.grants, revoke.GrantID)
+		m.mu.Unlock()
+		return fmt.Sprintf("revoked %s access of %s to %s", revoke.Level, revoke.UserID, revoke.ResourceID), nil
+
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
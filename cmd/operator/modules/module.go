@@ -2,10 +2,17 @@ package modules
 
 import (
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 )
 
+// ErrNotHandled is returned by a JobHandler for jobs meant for another operator,
+// e.g. a ping of a server it doesn't manage
+var ErrNotHandled = errors.New("job is handled by another operator")
+
 // ServerInfo represents information about a registered server
 type ServerInfo struct {
 	Name     string `json:"name"`
@@ -14,6 +21,10 @@ type ServerInfo struct {
 	User     string `json:"user"`
 	Database string `json:"database"`
 	Status   string `json:"status"` // "active" or "inactive"
+	// Tags classifies the data held by the server, e.g. pii, pci or gdpr
+	Tags []string `json:"tags,omitempty"`
+	// Region is the region the server runs in
+	Region string `json:"region,omitempty"`
 }
 
 // Module defines the interface for all operator modules
@@ -34,6 +45,71 @@ type Module interface {
 	StopMonitoring(ctx context.Context) error
 }
 
+// JobHandler is implemented by modules that run jobs dispatched by the API
+type JobHandler interface {
+	// HandleJob runs a job and returns its result
+	HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error)
+}
+
+// GrantRequest is the access of an approved grant for a module to provision
+type GrantRequest struct {
+	GrantID    string    `json:"grant_id"`
+	UserID     string    `json:"user_id"`
+	ResourceID string    `json:"resource_id"`
+	Level      string    `json:"level"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	// BoundTo restricts the grant's credentials to this source network, e.g.
+	// 10.8.0.12/32; empty allows any source
+	BoundTo string `json:"bound_to,omitempty"`
+}
+
+// Provisioner is implemented by modules that provision approved grants on
+// their targets
+type Provisioner interface {
+	// Provision gives the grant's user access and returns the credentials
+	// they use; ErrNotHandled for resources of another operator
+	Provision(ctx context.Context, grant GrantRequest) (map[string]string, error)
+}
+
+// ConfigValidator is implemented by modules that can check their
+// configuration without connecting to anything
+type ConfigValidator interface {
+	// ValidateConfig returns every problem with the configuration
+	ValidateConfig(config interface{}) error
+}
+
+// HealthChecker is implemented by modules that can tell whether the targets
+// they manage are reachable
+type HealthChecker interface {
+	// HealthCheck returns why the module can't serve requests, or nil
+	HealthCheck(ctx context.Context) error
+}
+
+// ModuleHealth is the outcome of a module's health check, reported to the API
+// with the operator's health checks
+type ModuleHealth struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+}
+
+// CheckHealth runs the health checks of the modules. Modules without a health
+// check are healthy as long as the operator runs.
+func CheckHealth(ctx context.Context, modules []Module) []ModuleHealth {
+	health := make([]ModuleHealth, 0, len(modules))
+	for _, module := range modules {
+		result := ModuleHealth{Name: module.Name(), Healthy: true}
+		if checker, ok := module.(HealthChecker); ok {
+			if err := checker.HealthCheck(ctx); err != nil {
+				result.Healthy = false
+				result.Error = err.Error()
+			}
+		}
+		health = append(health, result)
+	}
+	return health
+}
+
 // Registry manages module registration and lookup
 type Registry struct {
 	modules map[string]Module
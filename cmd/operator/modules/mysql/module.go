@@ -3,31 +3,52 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
+	"sync"
 	"time"
 
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/petermein/apollo/cmd/operator/api"
 	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/credential"
+	"github.com/petermein/apollo/internal/operators"
+	mysqlgrants "github.com/petermein/apollo/internal/operators/mysql"
 )
 
 // Config represents the MySQL module configuration
 type Config struct {
-	Host              string `yaml:"host"`
-	Port              int    `yaml:"port"`
-	User              string `yaml:"user"`
-	Password          string `yaml:"password"`
-	MaxConnections    int    `yaml:"max_connections"`
-	ConnectionTimeout string `yaml:"connection_timeout"`
-	IdleTimeout       string `yaml:"idle_timeout"`
-	APIClient         *api.Client
+	Host              string   `yaml:"host"`
+	Port              int      `yaml:"port"`
+	User              string   `yaml:"user"`
+	Password          string   `yaml:"password"`
+	MaxConnections    int      `yaml:"max_connections"`
+	ConnectionTimeout string   `yaml:"connection_timeout"`
+	IdleTimeout       string   `yaml:"idle_timeout"`
+	Tags              []string `yaml:"tags"`
+	// Resources maps resource IDs to the objects privileges are granted on,
+	// e.g. orders: [orders.*]. Grants are only provisioned for the resources
+	// listed here or in Vault's roles.
+	Resources map[string][]string `yaml:"resources"`
+	// GrantDatabase keeps the records of provisioned grants; apollo when empty
+	GrantDatabase string `yaml:"grant_database"`
+	// Vault has Vault's database secrets engine issue the users of grants
+	Vault *mysqlgrants.VaultConfig `yaml:"vault"`
+	// PasswordPolicy sets how the passwords of temporary users are generated
+	PasswordPolicy *credential.Policy `yaml:"password_policy"`
+	APIClient      *api.Client
 }
 
 // Module implements the MySQL module
 type Module struct {
 	config *Config
 	db     *sql.DB
+	// grants provisions and revokes grants, quoting every name it puts in a
+	// statement; nil when neither resources nor Vault are configured
+	grants   *mysqlgrants.Module
+	deposits *heldCredentials
 }
 
 // NewModule creates a new MySQL module
@@ -36,9 +57,34 @@ func NewModule(apiClient *api.Client) *Module {
 		config: &Config{
 			APIClient: apiClient,
 		},
+		deposits: &heldCredentials{byGrant: make(map[string]map[string]string)},
 	}
 }
 
+// heldCredentials keeps the credentials the grant module deposits until
+// Provision returns them, as the operator deposits them with the API itself
+type heldCredentials struct {
+	mu      sync.Mutex
+	byGrant map[string]map[string]string
+}
+
+// DepositCredentials holds the credentials of a grant
+func (h *heldCredentials) DepositCredentials(ctx context.Context, grantID string, credentials map[string]string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.byGrant[grantID] = credentials
+	return nil
+}
+
+// take returns the credentials held for a grant and forgets them
+func (h *heldCredentials) take(grantID string) map[string]string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	credentials := h.byGrant[grantID]
+	delete(h.byGrant, grantID)
+	return credentials
+}
+
 // Name returns the module name
 func (m *Module) Name() string {
 	return "mysql"
@@ -49,14 +95,98 @@ func (m *Module) Description() string {
 	return "MySQL database module for managing database privileges"
 }
 
+// ValidateConfig checks the module's configuration without connecting to the server
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
 // Initialize initializes the MySQL module
 func (m *Module) Initialize(config interface{}) error {
 	log.Printf("[MYSQL] Initializing MySQL module")
 
-	// Convert config map to our Config struct
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+
+	// Set the API client from the module's config
+	cfg.APIClient = m.config.APIClient
+	m.config = cfg
+
+	log.Printf("[MYSQL] Configuration loaded for server %s:%d", cfg.Host, cfg.Port)
+
+	// The timeouts were checked when parsing
+	connTimeout, _ := time.ParseDuration(cfg.ConnectionTimeout)
+	idleTimeout, _ := time.ParseDuration(cfg.IdleTimeout)
+
+	// Create DSN
+	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s",
+		cfg.User, cfg.Password, cfg.Host, cfg.Port, connTimeout)
+
+	log.Printf("[MYSQL] Connecting to MySQL server at %s:%d", cfg.Host, cfg.Port)
+
+	// Open database connection
+	db, err := sql.Open("mysql", dsn)
+	if err != nil {
+		return fmt.Errorf("failed to open database connection: %v", err)
+	}
+
+	// Configure connection pool
+	db.SetMaxOpenConns(cfg.MaxConnections)
+	db.SetMaxIdleConns(cfg.MaxConnections)
+	db.SetConnMaxLifetime(idleTimeout)
+
+	log.Printf("[MYSQL] Testing connection to MySQL server")
+
+	// Test connection
+	if err := db.Ping(); err != nil {
+		return fmt.Errorf("failed to ping database: %v", err)
+	}
+
+	log.Printf("[MYSQL] Successfully connected to MySQL server")
+
+	m.db = db
+	if len(cfg.Resources) == 0 && cfg.Vault == nil {
+		return nil
+	}
+
+	// Grants are provisioned by the grant module, which keeps its records in
+	// the grant database
+	m.grants = mysqlgrants.NewModule()
+	m.grants.SetCredentialDepositor(m.deposits)
+	ctx, cancel := context.WithTimeout(context.Background(), connTimeout)
+	defer cancel()
+	if err := m.grants.Initialize(ctx, grantConfig(cfg, connTimeout, idleTimeout)); err != nil {
+		return fmt.Errorf("failed to initialize grants: %v", err)
+	}
+	log.Printf("[MYSQL] Provisioning grants of the configured resources")
+	return nil
+}
+
+// grantConfig returns the configuration of the grant module
+func grantConfig(cfg *Config, connTimeout, idleTimeout time.Duration) *mysqlgrants.Config {
+	return &mysqlgrants.Config{
+		Host:              cfg.Host,
+		Port:              cfg.Port,
+		User:              cfg.User,
+		Password:          cfg.Password,
+		MaxConnections:    cfg.MaxConnections,
+		ConnectionTimeout: connTimeout,
+		IdleTimeout:       idleTimeout,
+		Resources:         cfg.Resources,
+		GrantDatabase:     cfg.GrantDatabase,
+		Vault:             cfg.Vault,
+		PasswordPolicy:    cfg.PasswordPolicy,
+	}
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found
+func parseConfig(config interface{}) (*Config, error) {
 	configMap, ok := config.(map[string]interface{})
 	if !ok {
-		return fmt.Errorf("invalid config type for MySQL module")
+		return nil, fmt.Errorf("invalid config type for MySQL module")
 	}
 
 	cfg := &Config{}
@@ -83,66 +213,141 @@ func (m *Module) Initialize(config interface{}) error {
 	if idleTimeout, ok := configMap["idle_timeout"].(string); ok {
 		cfg.IdleTimeout = idleTimeout
 	}
+	if tags, ok := configMap["tags"].([]interface{}); ok {
+		for _, tag := range tags {
+			if s, ok := tag.(string); ok {
+				cfg.Tags = append(cfg.Tags, s)
+			}
+		}
+	}
+
+	var errs []error
+	resources, _ := configMap["resources"].(map[string]interface{})
+	for resourceID, value := range resources {
+		if cfg.Resources == nil {
+			cfg.Resources = make(map[string][]string)
+		}
+		cfg.Resources[resourceID] = stringList(value)
+	}
+	cfg.GrantDatabase, _ = configMap["grant_database"].(string)
+	if vault, ok := configMap["vault"].(map[string]interface{}); ok {
+		cfg.Vault = parseVaultConfig(vault)
+	}
+	if policy, ok := configMap["password_policy"].(map[string]interface{}); ok {
+		parsed, err := credential.Parse(policy)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("password_policy: %v", err))
+		} else {
+			cfg.PasswordPolicy = &parsed
+		}
+	}
 
-	// Validate required fields
 	if cfg.Host == "" {
-		return fmt.Errorf("host is required")
+		errs = append(errs, fmt.Errorf("host is required"))
 	}
 	if cfg.Port == 0 {
-		return fmt.Errorf("port is required")
+		errs = append(errs, fmt.Errorf("port is required"))
 	}
 	if cfg.User == "" {
-		return fmt.Errorf("user is required")
+		errs = append(errs, fmt.Errorf("user is required"))
 	}
 	if cfg.Password == "" {
-		return fmt.Errorf("password is required")
+		errs = append(errs, fmt.Errorf("password is required"))
 	}
-
-	// Set the API client from the module's config
-	cfg.APIClient = m.config.APIClient
-	m.config = cfg
-
-	log.Printf("[MYSQL] Configuration loaded for server %s:%d", cfg.Host, cfg.Port)
-
-	// Parse timeouts
-	connTimeout, err := time.ParseDuration(cfg.ConnectionTimeout)
-	if err != nil {
-		return fmt.Errorf("invalid connection timeout: %v", err)
+	if _, err := time.ParseDuration(cfg.ConnectionTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid connection timeout: %v", err))
 	}
-
-	idleTimeout, err := time.ParseDuration(cfg.IdleTimeout)
-	if err != nil {
-		return fmt.Errorf("invalid idle timeout: %v", err)
+	if _, err := time.ParseDuration(cfg.IdleTimeout); err != nil {
+		errs = append(errs, fmt.Errorf("invalid idle timeout: %v", err))
 	}
-
-	// Create DSN
-	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s",
-		cfg.User, cfg.Password, cfg.Host, cfg.Port, connTimeout)
-
-	log.Printf("[MYSQL] Connecting to MySQL server at %s:%d", cfg.Host, cfg.Port)
-
-	// Open database connection
-	db, err := sql.Open("mysql", dsn)
-	if err != nil {
-		return fmt.Errorf("failed to open database connection: %v", err)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	// The grant module checks the objects of the resources can be quoted
+	if err := mysqlgrants.NewModule().ValidateConfig(grantConfig(cfg, 0, 0)); err != nil {
+		return nil, err
 	}
+	return cfg, nil
+}
 
-	// Configure connection pool
-	db.SetMaxOpenConns(cfg.MaxConnections)
-	db.SetMaxIdleConns(cfg.MaxConnections)
-	db.SetConnMaxLifetime(idleTimeout)
+// parseVaultConfig reads the Vault configuration from its YAML map
+func parseVaultConfig(configMap map[string]interface{}) *mysqlgrants.VaultConfig {
+	cfg := &mysqlgrants.VaultConfig{Roles: make(map[string]map[string]string)}
+	cfg.Address, _ = configMap["address"].(string)
+	cfg.Token, _ = configMap["token"].(string)
+	cfg.Mount, _ = configMap["mount"].(string)
+	roles, _ := configMap["roles"].(map[string]interface{})
+	for resourceID, value := range roles {
+		levels, _ := value.(map[string]interface{})
+		cfg.Roles[resourceID] = make(map[string]string)
+		for level, role := range levels {
+			cfg.Roles[resourceID][level], _ = role.(string)
+		}
+	}
+	return cfg
+}
 
-	log.Printf("[MYSQL] Testing connection to MySQL server")
+// stringList reads a list of strings, or a single string, from YAML
+func stringList(value interface{}) []string {
+	switch value := value.(type) {
+	case string:
+		if value != "" {
+			return []string{value}
+		}
+	case []interface{}:
+		var list []string
+		for _, item := range value {
+			if s, ok := item.(string); ok && s != "" {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
 
-	// Test connection
-	if err := db.Ping(); err != nil {
-		return fmt.Errorf("failed to ping database: %v", err)
+// manages reports whether grants of the resource are provisioned by this
+// module, rather than by the operator of another MySQL server
+func (m *Module) manages(resourceID string) bool {
+	if m.grants == nil {
+		return false
+	}
+	if _, ok := m.config.Resources[resourceID]; ok {
+		return true
+	}
+	if m.config.Vault != nil {
+		_, ok := m.config.Vault.Roles[resourceID]
+		return ok
 	}
+	return false
+}
 
-	log.Printf("[MYSQL] Successfully connected to MySQL server")
+// Provision creates the temporary user of a grant with the privileges of its
+// level on the objects the catalog lists for its resource, or has Vault issue
+// it, and returns its credentials. Resources not in the catalog are left to
+// other operators.
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if !m.manages(grant.ResourceID) {
+		return nil, modules.ErrNotHandled
+	}
+	duration := time.Until(grant.ExpiresAt).Round(time.Second)
+	if duration <= 0 {
+		return nil, fmt.Errorf("grant %s has expired", grant.GrantID)
+	}
 
-	m.db = db
-	return nil
+	request := &operators.PrivilegeRequest{
+		ID:         grant.GrantID,
+		UserID:     grant.UserID,
+		ResourceID: grant.ResourceID,
+		Level:      grant.Level,
+		Duration:   duration.String(),
+		BoundTo:    grant.BoundTo,
+	}
+	if err := m.grants.HandlePrivilegeRequest(ctx, request); err != nil {
+		return nil, err
+	}
+	log.Printf("[MYSQL] Provisioned grant %s of %s until %s", grant.GrantID, grant.ResourceID, grant.ExpiresAt.Format(time.RFC3339))
+	return m.deposits.take(grant.GrantID), nil
 }
 
 // StartMonitoring starts monitoring the MySQL server
@@ -158,6 +363,7 @@ func (m *Module) StartMonitoring(ctx context.Context) error {
 		Port:     m.config.Port,
 		User:     m.config.User,
 		Database: "apollo",
+		Tags:     m.config.Tags,
 	}
 
 	log.Printf("[MYSQL] Registering server %s with API", serverInfo.Name)
@@ -200,6 +406,66 @@ func (m *Module) StartMonitoring(ctx context.Context) error {
 	return nil
 }
 
+// HandleJob runs a job dispatched by the API: pings of this server and
+// revocations of the grants provisioned on it
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	switch jobType {
+	case "ping":
+		return m.ping(ctx, request)
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			ResourceID string `json:"resource_id"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		if !m.manages(revoke.ResourceID) {
+			return "", modules.ErrNotHandled
+		}
+		if err := m.grants.RevokePrivilege(ctx, revoke.GrantID); err != nil {
+			return "", err
+		}
+		log.Printf("[MYSQL] Revoked grant %s of %s", revoke.GrantID, revoke.ResourceID)
+		return fmt.Sprintf("revoked grant %s", revoke.GrantID), nil
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
+
+// ping pings the server when the job names it
+func (m *Module) ping(ctx context.Context, request json.RawMessage) (string, error) {
+	var ping struct {
+		Server string `json:"server"`
+	}
+	if err := json.Unmarshal(request, &ping); err != nil {
+		return "", fmt.Errorf("invalid ping request: %v", err)
+	}
+	if ping.Server != fmt.Sprintf("%s-%d", m.config.Host, m.config.Port) {
+		return "", modules.ErrNotHandled
+	}
+	if m.db == nil {
+		return "", fmt.Errorf("database not initialized")
+	}
+
+	start := time.Now()
+	if err := m.db.PingContext(ctx); err != nil {
+		return "", fmt.Errorf("failed to ping server: %v", err)
+	}
+	return fmt.Sprintf("pong in %s", time.Since(start).Round(time.Millisecond)), nil
+}
+
+// HealthCheck pings the MySQL server
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if err := m.db.PingContext(ctx); err != nil {
+		return fmt.Errorf("failed to ping server: %v", err)
+	}
+	return nil
+}
+
 // StopMonitoring stops monitoring the MySQL server
 func (m *Module) StopMonitoring(ctx context.Context) error {
 	if m.db == nil {
@@ -0,0 +1,210 @@
+package snowflake
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// pollInterval is how often statements still running are checked
+const pollInterval = 500 * time.Millisecond
+
+// statementTimeout bounds each statement
+const statementTimeout = 60 * time.Second
+
+// Snowflake's code for objects that don't exist or the role may not see
+const codeDoesNotExist = "002003"
+
+// apiError is a statement Snowflake refused or failed
+type apiError struct {
+	status  int
+	code    string
+	message string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("snowflake returned status %d: %s %s", e.status, e.code, e.message)
+}
+
+// result is the result set of a statement
+type result struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	Handle   string `json:"statementHandle"`
+	Metadata struct {
+		RowType []struct {
+			Name string `json:"name"`
+		} `json:"rowType"`
+	} `json:"resultSetMetaData"`
+	Data [][]*string `json:"data"`
+}
+
+// column returns the values of the named column, matched case-insensitively
+func (r *result) column(name string) []string {
+	index := -1
+	for i, column := range r.Metadata.RowType {
+		if strings.EqualFold(column.Name, name) {
+			index = i
+		}
+	}
+	var values []string
+	for _, row := range r.Data {
+		if index >= 0 && index < len(row) && row[index] != nil {
+			values = append(values, *row[index])
+		}
+	}
+	return values
+}
+
+// client runs statements through Snowflake's SQL API, authenticating with a
+// JWT signed by the module user's key pair
+type client struct {
+	baseURL string
+	// account and user are the uppercase account identifier and user the
+	// JWTs are issued for
+	account string
+	user    string
+	role    string
+	key     *rsa.PrivateKey
+	// fingerprint identifies the public key Snowflake checks signatures with
+	fingerprint string
+	http        *http.Client
+}
+
+// newClient creates a client for the account's SQL API
+func newClient(cfg *Config, key *rsa.PrivateKey) (*client, error) {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode public key: %v", err)
+	}
+	digest := sha256.Sum256(der)
+	return &client{
+		baseURL:     strings.TrimSuffix(cfg.URL, "/"),
+		account:     strings.ToUpper(strings.ReplaceAll(cfg.Account, ".", "-")),
+		user:        strings.ToUpper(cfg.User),
+		role:        cfg.Role,
+		key:         key,
+		fingerprint: "SHA256:" + base64.StdEncoding.EncodeToString(digest[:]),
+		http:        &http.Client{Timeout: 30 * time.Second},
+	}, nil
+}
+
+// token returns a JWT for the module's user, valid for an hour
+func (c *client) token(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": c.account + "." + c.user + "." + c.fingerprint,
+		"sub": c.account + "." + c.user,
+		"iat": now.Unix(),
+		"exp": now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, c.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign snowflake token: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
+
+// execute runs a statement as the module's role and waits for its result
+func (c *client) execute(ctx context.Context, statement string) (*result, error) {
+	// The request ID makes Snowflake run a retried request only once
+	requestID := make([]byte, 16)
+	rand.Read(requestID)
+	body := map[string]interface{}{
+		"statement": statement,
+		"timeout":   int(statementTimeout.Seconds()),
+	}
+	if c.role != "" {
+		body["role"] = c.role
+	}
+	res, err := c.call(ctx, http.MethodPost, "/api/v2/statements?requestId="+url.QueryEscape(uuid(requestID)), body)
+	for err == nil && res.Code == "333334" {
+		// Still running
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(pollInterval):
+		}
+		res, err = c.call(ctx, http.MethodGet, "/api/v2/statements/"+url.PathEscape(res.Handle), nil)
+	}
+	return res, err
+}
+
+// call sends a request to the SQL API
+func (c *client) call(ctx context.Context, method, path string, input interface{}) (*result, error) {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return nil, fmt.Errorf("failed to marshal snowflake request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	token, err := c.token(time.Now())
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("X-Snowflake-Authorization-Token-Type", "KEYPAIR_JWT")
+	req.Header.Set("Accept", "application/json")
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to call snowflake: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var res result
+	data, err := io.ReadAll(io.LimitReader(resp.Body, 10<<20))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snowflake response: %v", err)
+	}
+	// Errors usually come with a code and message; failed logins may not
+	if len(data) > 0 && json.Unmarshal(data, &res) != nil && resp.StatusCode == http.StatusOK {
+		return nil, fmt.Errorf("failed to decode snowflake response")
+	}
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusAccepted {
+		message := res.Message
+		if message == "" {
+			message = strings.TrimSpace(string(data[:min(len(data), 1024)]))
+		}
+		return nil, &apiError{status: resp.StatusCode, code: res.Code, message: message}
+	}
+	return &res, nil
+}
+
+// uuid formats 16 random bytes as a version 4 UUID
+func uuid(b []byte) string {
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	h := hex.EncodeToString(b)
+	return h[0:8] + "-" + h[8:12] + "-" + h[12:16] + "-" + h[16:20] + "-" + h[20:32]
+}
@@ -0,0 +1,365 @@
+package snowflake
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+)
+
+// accountPattern matches account identifiers: orgname-accountname or an
+// account locator, optionally with its region and cloud
+var accountPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Config represents the Snowflake module configuration
+type Config struct {
+	// Account is the account identifier, e.g. myorg-myaccount
+	Account string
+	// URL is the account's address; https://<account>.snowflakecomputing.com
+	// by default
+	URL string
+	// User is the module's Snowflake user, which authenticates with the
+	// key pair in PrivateKeyFile, an unencrypted PKCS #8 RSA key
+	User           string
+	PrivateKeyFile string
+	// Role is the role the module grants and revokes roles as, e.g.
+	// SECURITYADMIN; the user's default role when empty
+	Role string
+	// Users maps Apollo users to their Snowflake user names; users not listed
+	// have the upper case of their Apollo user ID
+	Users map[string]string
+	// Resources maps resource IDs to the Snowflake roles granted at each level
+	Resources map[string]map[string]string
+}
+
+// Module grants Snowflake roles to users for the duration of a grant. Each
+// resource maps the privilege levels to roles, so read, write and admin access
+// is whatever the roles allow; revoking the grant at expiry revokes the role.
+type Module struct {
+	config *Config
+	client *client
+}
+
+// NewModule creates a new Snowflake module
+func NewModule() *Module {
+	return &Module{}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "snowflake"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Temporary Snowflake role grants"
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// Initialize initializes the Snowflake module
+func (m *Module) Initialize(config interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	key, err := loadKey(cfg.PrivateKeyFile)
+	if err != nil {
+		return err
+	}
+	client, err := newClient(cfg, key)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	m.client = client
+	log.Printf("[SNOWFLAKE] Granting roles of %d resources in %s as %s", len(cfg.Resources), cfg.Account, cfg.User)
+	return nil
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found
+func parseConfig(config interface{}) (*Config, error) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid config type for Snowflake module")
+	}
+
+	cfg := &Config{
+		Users:     make(map[string]string),
+		Resources: make(map[string]map[string]string),
+	}
+	var errs []error
+	cfg.Account, _ = configMap["account"].(string)
+	if !accountPattern.MatchString(cfg.Account) {
+		errs = append(errs, fmt.Errorf("account is required, e.g. myorg-myaccount"))
+	}
+	cfg.URL, _ = configMap["url"].(string)
+	if cfg.URL == "" {
+		cfg.URL = "https://" + cfg.Account + ".snowflakecomputing.com"
+	}
+	if u, err := url.Parse(cfg.URL); err != nil || u.Scheme != "https" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("url must be an https URL"))
+	}
+	cfg.User, _ = configMap["user"].(string)
+	cfg.PrivateKeyFile, _ = configMap["private_key_file"].(string)
+	if cfg.User == "" || cfg.PrivateKeyFile == "" {
+		errs = append(errs, fmt.Errorf("user and private_key_file are required"))
+	}
+	cfg.Role, _ = configMap["role"].(string)
+
+	users, _ := configMap["users"].(map[string]interface{})
+	for userID, value := range users {
+		name, _ := value.(string)
+		if err := validateName(name); err != nil {
+			errs = append(errs, fmt.Errorf("users.%s: %v", userID, err))
+			continue
+		}
+		cfg.Users[userID] = name
+	}
+
+	resources, _ := configMap["resources"].(map[string]interface{})
+	for resourceID, value := range resources {
+		levels, _ := value.(map[string]interface{})
+		roles := make(map[string]string)
+		for level, value := range levels {
+			role, _ := value.(string)
+			if !knownLevel(level) {
+				errs = append(errs, fmt.Errorf("resources.%s: unknown privilege level %q", resourceID, level))
+				continue
+			}
+			if err := validateName(role); err != nil {
+				errs = append(errs, fmt.Errorf("resources.%s.%s: %v", resourceID, level, err))
+				continue
+			}
+			roles[level] = role
+		}
+		if len(levels) == 0 {
+			errs = append(errs, fmt.Errorf("resources.%s: roles are required", resourceID))
+		}
+		cfg.Resources[resourceID] = roles
+	}
+	if len(cfg.Resources) == 0 {
+		errs = append(errs, fmt.Errorf("resources is required"))
+	}
+
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// loadKey reads the module user's private key
+func loadKey(path string) (*rsa.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s holds no PEM private key", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse private key; it must be an unencrypted PKCS #8 key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("private key is not an RSA key")
+	}
+	return key, nil
+}
+
+// validateName checks a user or role name, which is quoted in statements
+func validateName(name string) error {
+	if name == "" || len(name) > 255 {
+		return fmt.Errorf("invalid name %q", name)
+	}
+	for _, c := range name {
+		if c < 0x20 || c == 0x7f {
+			return fmt.Errorf("invalid name %q", name)
+		}
+	}
+	return nil
+}
+
+// quote returns the name as a quoted identifier, which matches it exactly
+func quote(name string) string {
+	return `"` + strings.ReplaceAll(name, `"`, `""`) + `"`
+}
+
+// knownLevel reports whether the level is one of Apollo's privilege levels
+func knownLevel(level string) bool {
+	switch level {
+	case "read", "write", "admin", "root":
+		return true
+	}
+	return false
+}
+
+// StartMonitoring does nothing; Snowflake is checked by HealthCheck
+func (m *Module) StartMonitoring(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// StopMonitoring does nothing; roles stay granted until they are revoked
+func (m *Module) StopMonitoring(ctx context.Context) error {
+	return nil
+}
+
+// HealthCheck checks that the module can run statements
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	_, err := m.client.execute(ctx, "SELECT CURRENT_ROLE()")
+	return err
+}
+
+// user returns the Snowflake user name of the Apollo user
+func (m *Module) user(userID string) (string, error) {
+	name, ok := m.config.Users[userID]
+	if !ok {
+		name = strings.ToUpper(userID)
+	}
+	if err := validateName(name); err != nil {
+		return "", fmt.Errorf("user %s: %v", userID, err)
+	}
+	return name, nil
+}
+
+// role returns the role granted for a resource at a level; ErrNotHandled for
+// resources the module doesn't manage
+func (m *Module) role(resourceID, level string) (string, error) {
+	roles, ok := m.config.Resources[resourceID]
+	if !ok {
+		return "", modules.ErrNotHandled
+	}
+	role, ok := roles[level]
+	if !ok {
+		return "", fmt.Errorf("resource %s has no role for %s access", resourceID, level)
+	}
+	return role, nil
+}
+
+// Provision grants the role of the grant's resource and level to the user.
+// Users who hold the role already are refused, as revoking the grant would
+// take away a role Apollo didn't give.
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	role, err := m.role(grant.ResourceID, grant.Level)
+	if err != nil {
+		return nil, err
+	}
+	user, err := m.user(grant.UserID)
+	if err != nil {
+		return nil, err
+	}
+
+	grants, err := m.client.execute(ctx, "SHOW GRANTS TO USER "+quote(user))
+	if err != nil {
+		return nil, fmt.Errorf("failed to list roles of %s: %v", user, err)
+	}
+	for _, granted := range grants.column("role") {
+		if granted == role {
+			return nil, fmt.Errorf("%s holds role %s already", user, role)
+		}
+	}
+	if _, err := m.client.execute(ctx, "GRANT ROLE "+quote(role)+" TO USER "+quote(user)); err != nil {
+		return nil, fmt.Errorf("failed to grant role %s to %s: %v", role, user, err)
+	}
+
+	log.Printf("[SNOWFLAKE] Granted role %s to %s until %s", role, user, grant.ExpiresAt.Format(time.RFC3339))
+	return map[string]string{
+		"account": m.config.Account,
+		"user":    user,
+		"role":    role,
+	}, nil
+}
+
+// revoke revokes the role from the user; revoking a role the user doesn't
+// hold, or from a user that is gone, does nothing
+func (m *Module) revoke(ctx context.Context, user, role string) error {
+	_, err := m.client.execute(ctx, "REVOKE ROLE "+quote(role)+" FROM USER "+quote(user))
+	var apiErr *apiError
+	if errors.As(err, &apiErr) && apiErr.code == codeDoesNotExist {
+		log.Printf("[SNOWFLAKE] User %s or role %s no longer exists: %s", user, role, apiErr.message)
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to revoke role %s from %s: %v", role, user, err)
+	}
+	return nil
+}
+
+// HandleJob runs a job dispatched by the API: pings of the resources and
+// revocations of the roles granted for them
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	if m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	switch jobType {
+	case "ping":
+		var ping struct {
+			Server string `json:"server"`
+		}
+		if err := json.Unmarshal(request, &ping); err != nil {
+			return "", fmt.Errorf("invalid ping request: %v", err)
+		}
+		if _, ok := m.config.Resources[ping.Server]; !ok {
+			return "", modules.ErrNotHandled
+		}
+		if err := m.HealthCheck(ctx); err != nil {
+			return "", err
+		}
+		return "pong", nil
+
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			UserID     string `json:"user_id"`
+			ResourceID string `json:"resource_id"`
+			Level      string `json:"level"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		role, err := m.role(revoke.ResourceID, revoke.Level)
+		if err != nil {
+			return "", err
+		}
+		user, err := m.user(revoke.UserID)
+		if err != nil {
+			return "", err
+		}
+		if err := m.revoke(ctx, user, role); err != nil {
+			return "", err
+		}
+		log.Printf("[SNOWFLAKE] Revoked role %s from %s for grant %s", role, user, revoke.GrantID)
+		return fmt.Sprintf("revoked role %s from %s", role, user), nil
+
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
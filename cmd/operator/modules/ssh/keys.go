@@ -0,0 +1,84 @@
+package ssh
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+)
+
+// keyType is the type of the keys generated for grants
+const keyType = "ssh-ed25519"
+
+// keyPair is a key generated for a grant, encoded for OpenSSH
+type keyPair struct {
+	// authorizedKey is the public key as written to authorized_keys, without options
+	authorizedKey string
+	// privateKey is the private key in the OpenSSH PEM format
+	privateKey string
+}
+
+// generateKey generates an Ed25519 key with the comment
+func generateKey(comment string) (keyPair, error) {
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		return keyPair{}, fmt.Errorf("failed to generate key: %v", err)
+	}
+	blob := publicKeyBlob(public)
+	encoded, err := marshalPrivateKey(public, private, comment)
+	if err != nil {
+		return keyPair{}, err
+	}
+	return keyPair{
+		authorizedKey: keyType + " " + base64.StdEncoding.EncodeToString(blob) + " " + comment,
+		privateKey:    encoded,
+	}, nil
+}
+
+// publicKeyBlob returns the public key in the SSH wire format
+func publicKeyBlob(public ed25519.PublicKey) []byte {
+	var b bytes.Buffer
+	writeString(&b, []byte(keyType))
+	writeString(&b, public)
+	return b.Bytes()
+}
+
+// marshalPrivateKey encodes an unencrypted private key in the openssh-key-v1
+// format ssh reads from identity files
+func marshalPrivateKey(public ed25519.PublicKey, private ed25519.PrivateKey, comment string) (string, error) {
+	// The check bytes repeat, so ssh can tell a decryption succeeded
+	check := make([]byte, 4)
+	if _, err := rand.Read(check); err != nil {
+		return "", fmt.Errorf("failed to generate key check: %v", err)
+	}
+	var keys bytes.Buffer
+	keys.Write(check)
+	keys.Write(check)
+	writeString(&keys, []byte(keyType))
+	writeString(&keys, public)
+	writeString(&keys, private)
+	writeString(&keys, []byte(comment))
+	// Unencrypted keys are padded to the cipher block size of 8 with 1, 2, 3, ...
+	for i := byte(1); keys.Len()%8 != 0; i++ {
+		keys.WriteByte(i)
+	}
+
+	var b bytes.Buffer
+	b.WriteString("openssh-key-v1\x00")
+	writeString(&b, []byte("none"))
+	writeString(&b, []byte("none"))
+	writeString(&b, nil)
+	binary.Write(&b, binary.BigEndian, uint32(1))
+	writeString(&b, publicKeyBlob(public))
+	writeString(&b, keys.Bytes())
+	return string(pem.EncodeToMemory(&pem.Block{Type: "OPENSSH PRIVATE KEY", Bytes: b.Bytes()})), nil
+}
+
+// writeString writes an SSH wire format string: its length and its bytes
+func writeString(b *bytes.Buffer, s []byte) {
+	binary.Write(b, binary.BigEndian, uint32(len(s)))
+	b.Write(s)
+}
@@ -0,0 +1,483 @@
+package ssh
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"os/user"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+)
+
+// Defaults of the module's configuration
+const (
+	defaultPort               = 22
+	defaultAuthorizedKeysFile = "%h/.ssh/authorized_keys"
+	defaultSudoersDir         = "/etc/sudoers.d"
+	defaultVisudo             = "/usr/sbin/visudo"
+)
+
+// accountPattern matches the Unix account names the module manages
+var accountPattern = regexp.MustCompile(`^[a-z_][a-z0-9_-]{0,31}$`)
+
+// Config represents the SSH module configuration
+type Config struct {
+	// Host is the resource ID of the host the operator runs on
+	Host string
+	// Address and Port are where users connect to the host
+	Address string
+	Port    int
+	// AuthorizedKeysFile is where an account's keys are kept, as in sshd_config:
+	// %h is the account's home directory and %u its name
+	AuthorizedKeysFile string
+	// Accounts maps Apollo users to the accounts they log in as; users
+	// not listed log in as the account of their own name
+	Accounts map[string]string
+	// SudoersDir is where the sudoers entries of grants are written
+	SudoersDir string
+	// Visudo checks sudoers entries before they are installed
+	Visudo string
+	// Sudo maps the privilege levels that get sudo to the commands they may
+	// run, e.g. ALL; levels not listed only log in
+	Sudo map[string]string
+}
+
+// Module grants temporary shell access to the Linux host the operator runs
+// on. Every grant gets a fresh key, authorized for the user's account until
+// the grant expires, and levels configured for sudo a sudoers entry that
+// stops working at the same time. Revoking removes both. The operator runs as
+// root on each host it manages.
+type Module struct {
+	config *Config
+
+	// mu serializes the edits of authorized_keys files
+	mu sync.Mutex
+}
+
+// NewModule creates a new SSH module
+func NewModule() *Module {
+	return &Module{}
+}
+
+// Name returns the module name
+func (m *Module) Name() string {
+	return "ssh"
+}
+
+// Description returns the module description
+func (m *Module) Description() string {
+	return "Temporary SSH and sudo access to Linux hosts"
+}
+
+// ValidateConfig checks the module's configuration
+func (m *Module) ValidateConfig(config interface{}) error {
+	_, err := parseConfig(config)
+	return err
+}
+
+// Initialize initializes the SSH module
+func (m *Module) Initialize(config interface{}) error {
+	cfg, err := parseConfig(config)
+	if err != nil {
+		return err
+	}
+	m.config = cfg
+	log.Printf("[SSH] Granting access to host %s (%s:%d)", cfg.Host, cfg.Address, cfg.Port)
+	return nil
+}
+
+// parseConfig reads the module's configuration from its YAML map and checks
+// it, reporting every problem found
+func parseConfig(config interface{}) (*Config, error) {
+	configMap, ok := config.(map[string]interface{})
+	if !ok && config != nil {
+		return nil, fmt.Errorf("invalid config type for SSH module")
+	}
+
+	cfg := &Config{
+		Port:               defaultPort,
+		AuthorizedKeysFile: defaultAuthorizedKeysFile,
+		SudoersDir:         defaultSudoersDir,
+		Visudo:             defaultVisudo,
+		Accounts:           make(map[string]string),
+		Sudo:               map[string]string{"admin": "ALL", "root": "ALL"},
+	}
+	var errs []error
+	if host, ok := configMap["host"].(string); ok {
+		cfg.Host = host
+	}
+	if cfg.Host == "" {
+		hostname, err := os.Hostname()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("host is required: %v", err))
+		}
+		cfg.Host = hostname
+	}
+	cfg.Address = cfg.Host
+	if address, ok := configMap["address"].(string); ok && address != "" {
+		cfg.Address = address
+	}
+	if value, ok := configMap["port"]; ok {
+		if port, ok := value.(int); ok && port > 0 && port < 65536 {
+			cfg.Port = port
+		} else {
+			errs = append(errs, fmt.Errorf("port must be between 1 and 65535"))
+		}
+	}
+	if file, ok := configMap["authorized_keys_file"].(string); ok && file != "" {
+		cfg.AuthorizedKeysFile = file
+	}
+	if !strings.HasPrefix(cfg.AuthorizedKeysFile, "/") && !strings.HasPrefix(cfg.AuthorizedKeysFile, "%h") {
+		errs = append(errs, fmt.Errorf("authorized_keys_file must be an absolute path or start with %%h"))
+	}
+	if dir, ok := configMap["sudoers_dir"].(string); ok && dir != "" {
+		cfg.SudoersDir = dir
+	}
+	if visudo, ok := configMap["visudo"].(string); ok && visudo != "" {
+		cfg.Visudo = visudo
+	}
+	if accounts, ok := configMap["accounts"].(map[string]interface{}); ok {
+		for userID, value := range accounts {
+			account, _ := value.(string)
+			if !accountPattern.MatchString(account) {
+				errs = append(errs, fmt.Errorf("accounts.%s: invalid account name %q", userID, account))
+				continue
+			}
+			cfg.Accounts[userID] = account
+		}
+	}
+	if value, ok := configMap["sudo"]; ok {
+		sudo, ok := value.(map[string]interface{})
+		if !ok && value != nil {
+			errs = append(errs, fmt.Errorf("sudo must map privilege levels to commands"))
+		}
+		cfg.Sudo = make(map[string]string)
+		for level, value := range sudo {
+			commands, _ := value.(string)
+			if !knownLevel(level) {
+				errs = append(errs, fmt.Errorf("sudo: unknown privilege level %q", level))
+				continue
+			}
+			// The commands end up in a sudoers line of their own
+			if strings.TrimSpace(commands) == "" || strings.ContainsAny(commands, "\n\r#\\") {
+				errs = append(errs, fmt.Errorf("sudo.%s: invalid commands %q", level, commands))
+				continue
+			}
+			cfg.Sudo[level] = commands
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// knownLevel reports whether the level is one of Apollo's privilege levels
+func knownLevel(level string) bool {
+	switch level {
+	case "read", "write", "admin", "root":
+		return true
+	}
+	return false
+}
+
+// StartMonitoring does nothing; the host is the one the operator runs on
+func (m *Module) StartMonitoring(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	return nil
+}
+
+// StopMonitoring does nothing; grants outlive the operator until they expire
+func (m *Module) StopMonitoring(ctx context.Context) error {
+	return nil
+}
+
+// HealthCheck checks that sudoers entries can be checked and installed when
+// any level gets sudo
+func (m *Module) HealthCheck(ctx context.Context) error {
+	if m.config == nil {
+		return fmt.Errorf("module not initialized")
+	}
+	if len(m.config.Sudo) == 0 {
+		return nil
+	}
+	if _, err := os.Stat(m.config.SudoersDir); err != nil {
+		return fmt.Errorf("sudoers directory unavailable: %v", err)
+	}
+	if _, err := exec.LookPath(m.config.Visudo); err != nil {
+		return fmt.Errorf("visudo unavailable: %v", err)
+	}
+	return nil
+}
+
+// account returns the account the user logs in as
+func (m *Module) account(userID string) (string, error) {
+	account, ok := m.config.Accounts[userID]
+	if !ok {
+		account = userID
+	}
+	if !accountPattern.MatchString(account) {
+		return "", fmt.Errorf("user %s has no valid account name; map it in accounts", userID)
+	}
+	return account, nil
+}
+
+// marker identifies the key and sudoers entry of a grant
+func marker(grantID string) string {
+	return "apollo-grant-" + grantID
+}
+
+// grantIDPattern matches the grant IDs safe to use in file names and key comments
+var grantIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
+
+// Provision authorizes a fresh key for the user's account until the grant
+// expires, adds a sudoers entry for levels with sudo, and returns the key
+func (m *Module) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	if grant.ResourceID != m.config.Host {
+		return nil, modules.ErrNotHandled
+	}
+	if !grantIDPattern.MatchString(grant.GrantID) {
+		return nil, fmt.Errorf("invalid grant ID %q", grant.GrantID)
+	}
+	if !knownLevel(grant.Level) {
+		return nil, fmt.Errorf("unknown privilege level %q", grant.Level)
+	}
+	account, err := m.account(grant.UserID)
+	if err != nil {
+		return nil, err
+	}
+	local, err := user.Lookup(account)
+	if err != nil {
+		return nil, fmt.Errorf("account %s: %v", account, err)
+	}
+
+	key, err := generateKey(marker(grant.GrantID))
+	if err != nil {
+		return nil, err
+	}
+	// sshd reads expiry-time in the host's time zone
+	line := fmt.Sprintf(`expiry-time="%s" %s`, grant.ExpiresAt.Local().Format("200601021504"), key.authorizedKey)
+	if err := m.editAuthorizedKeys(local, func(lines []string) []string {
+		return append(lines, line)
+	}); err != nil {
+		return nil, fmt.Errorf("failed to authorize key: %v", err)
+	}
+
+	credentials := map[string]string{
+		"host":        m.config.Address,
+		"port":        strconv.Itoa(m.config.Port),
+		"username":    account,
+		"private_key": key.privateKey,
+	}
+	if commands, ok := m.config.Sudo[grant.Level]; ok {
+		if err := m.writeSudoers(ctx, grant, account, commands); err != nil {
+			// The key alone would give less than was granted; take it back
+			if rerr := m.removeKey(local, grant.GrantID); rerr != nil {
+				log.Printf("[SSH] Failed to remove key of grant %s: %v", grant.GrantID, rerr)
+			}
+			return nil, fmt.Errorf("failed to add sudoers entry: %v", err)
+		}
+		credentials["sudo"] = commands
+	}
+	log.Printf("[SSH] Granted %s %s access to %s as %s until %s",
+		grant.UserID, grant.Level, m.config.Host, account, grant.ExpiresAt.Format(time.RFC3339))
+	return credentials, nil
+}
+
+// authorizedKeysPath returns the authorized_keys file of the account
+func (m *Module) authorizedKeysPath(local *user.User) string {
+	return strings.NewReplacer("%h", local.HomeDir, "%u", local.Username, "%%", "%").Replace(m.config.AuthorizedKeysFile)
+}
+
+// editAuthorizedKeys rewrites the account's authorized_keys file with the
+// lines edit returns. The file is replaced atomically and owned by the
+// account, as sshd insists on.
+func (m *Module) editAuthorizedKeys(local *user.User, edit func(lines []string) []string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	path := m.authorizedKeysPath(local)
+	uid, _ := strconv.Atoi(local.Uid)
+	gid, _ := strconv.Atoi(local.Gid)
+	dir := filepath.Dir(path)
+	if _, err := os.Stat(dir); errors.Is(err, os.ErrNotExist) {
+		if err := os.MkdirAll(dir, 0o700); err != nil {
+			return err
+		}
+		if err := os.Chown(dir, uid, gid); err != nil {
+			return err
+		}
+	}
+
+	var lines []string
+	file, err := os.Open(path)
+	switch {
+	case err == nil:
+		scanner := bufio.NewScanner(file)
+		scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+		for scanner.Scan() {
+			lines = append(lines, scanner.Text())
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return err
+	}
+
+	lines = edit(lines)
+	temp, err := os.CreateTemp(dir, ".apollo-authorized-keys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(temp.Name())
+	content := strings.Join(lines, "\n")
+	if content != "" {
+		content += "\n"
+	}
+	if _, err := temp.WriteString(content); err != nil {
+		temp.Close()
+		return err
+	}
+	if err := temp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(temp.Name(), 0o600); err != nil {
+		return err
+	}
+	if err := os.Chown(temp.Name(), uid, gid); err != nil {
+		return err
+	}
+	return os.Rename(temp.Name(), path)
+}
+
+// removeKey removes the grant's key from the account's authorized_keys file
+func (m *Module) removeKey(local *user.User, grantID string) error {
+	suffix := " " + marker(grantID)
+	return m.editAuthorizedKeys(local, func(lines []string) []string {
+		kept := lines[:0]
+		for _, line := range lines {
+			if !strings.HasSuffix(strings.TrimSpace(line), suffix) {
+				kept = append(kept, line)
+			}
+		}
+		return kept
+	})
+}
+
+// sudoersPath returns the file of the grant's sudoers entry. sudo skips files
+// in sudoers.d whose name contains a dot, so entries being written are never
+// read before they are checked.
+func (m *Module) sudoersPath(grantID string) string {
+	return filepath.Join(m.config.SudoersDir, marker(grantID))
+}
+
+// writeSudoers installs the grant's sudoers entry, which sudo stops honouring
+// when the grant expires, after visudo checked it. The entry names only the
+// validated grant ID and account: user IDs come from the API and may hold
+// line breaks that would add rules of their own.
+func (m *Module) writeSudoers(ctx context.Context, grant modules.GrantRequest, account, commands string) error {
+	entry := fmt.Sprintf("# Apollo grant %s for %s, expires %s\n%s ALL=(ALL) NOTAFTER=%s NOPASSWD: %s\n",
+		grant.GrantID, account, grant.ExpiresAt.UTC().Format(time.RFC3339),
+		account, grant.ExpiresAt.UTC().Format("20060102150405Z"), commands)
+
+	path := m.sudoersPath(grant.GrantID)
+	temp := path + ".tmp"
+	if err := os.WriteFile(temp, []byte(entry), 0o440); err != nil {
+		return err
+	}
+	defer os.Remove(temp)
+	if output, err := exec.CommandContext(ctx, m.config.Visudo, "-cf", temp).CombinedOutput(); err != nil {
+		return fmt.Errorf("visudo rejected the entry: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	return os.Rename(temp, path)
+}
+
+// revoke removes the key and sudoers entry of a grant; revoking a grant that
+// was revoked already does nothing
+func (m *Module) revoke(grantID, userID string) error {
+	if !grantIDPattern.MatchString(grantID) {
+		return fmt.Errorf("invalid grant ID %q", grantID)
+	}
+	var errs []error
+	if err := os.Remove(m.sudoersPath(grantID)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		errs = append(errs, fmt.Errorf("failed to remove sudoers entry: %v", err))
+	}
+	account, err := m.account(userID)
+	if err != nil {
+		return errors.Join(append(errs, err)...)
+	}
+	local, err := user.Lookup(account)
+	if err != nil {
+		// Without the account there is no key to remove
+		var unknown user.UnknownUserError
+		if errors.As(err, &unknown) {
+			return errors.Join(errs...)
+		}
+		return errors.Join(append(errs, fmt.Errorf("account %s: %v", account, err))...)
+	}
+	if err := m.removeKey(local, grantID); err != nil {
+		errs = append(errs, fmt.Errorf("failed to remove key: %v", err))
+	}
+	return errors.Join(errs...)
+}
+
+// HandleJob runs a job dispatched by the API: pings of the host and
+// revocations of the grants on it
+func (m *Module) HandleJob(ctx context.Context, jobType string, request json.RawMessage) (string, error) {
+	if m.config == nil {
+		return "", fmt.Errorf("module not initialized")
+	}
+
+	switch jobType {
+	case "ping":
+		var ping struct {
+			Server string `json:"server"`
+		}
+		if err := json.Unmarshal(request, &ping); err != nil {
+			return "", fmt.Errorf("invalid ping request: %v", err)
+		}
+		if ping.Server != m.config.Host {
+			return "", modules.ErrNotHandled
+		}
+		return "pong", nil
+
+	case "revoke":
+		var revoke struct {
+			GrantID    string `json:"grant_id"`
+			UserID     string `json:"user_id"`
+			ResourceID string `json:"resource_id"`
+		}
+		if err := json.Unmarshal(request, &revoke); err != nil {
+			return "", fmt.Errorf("invalid revoke request: %v", err)
+		}
+		if revoke.ResourceID != m.config.Host {
+			return "", modules.ErrNotHandled
+		}
+		if err := m.revoke(revoke.GrantID, revoke.UserID); err != nil {
+			return "", err
+		}
+		log.Printf("[SSH] Revoked grant %s of %s", revoke.GrantID, revoke.UserID)
+		return fmt.Sprintf("revoked access of %s to %s", revoke.UserID, m.config.Host), nil
+
+	default:
+		return "", fmt.Errorf("unsupported job type %q", jobType)
+	}
+}
@@ -0,0 +1,53 @@
+package ssh
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/modules"
+)
+
+// TestWriteSudoersUserID checks a user ID with a line break neither reaches
+// the sudoers entry nor passes as an account name
+func TestWriteSudoersUserID(t *testing.T) {
+	userID := "alice\nALL ALL=(ALL) NOPASSWD: ALL"
+	m := &Module{config: &Config{
+		Accounts:   map[string]string{userID: "alice"},
+		SudoersDir: t.TempDir(),
+		Visudo:     "true",
+	}}
+
+	if _, err := m.account("mallory\nALL ALL=(ALL) NOPASSWD: ALL"); err == nil {
+		t.Error("account accepted a user ID with a line break")
+	}
+
+	account, err := m.account(userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	grant := modules.GrantRequest{
+		GrantID:   "grant_1",
+		UserID:    userID,
+		Level:     "admin",
+		ExpiresAt: time.Date(2026, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+	if err := m.writeSudoers(context.Background(), grant, account, "/usr/bin/systemctl"); err != nil {
+		t.Fatal(err)
+	}
+	entry, err := os.ReadFile(m.sudoersPath(grant.GrantID))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := "# Apollo grant grant_1 for alice, expires 2026-01-02T03:04:05Z\n" +
+		"alice ALL=(ALL) NOTAFTER=20260102030405Z NOPASSWD: /usr/bin/systemctl\n"
+	if string(entry) != want {
+		t.Errorf("sudoers entry:\n%s\nwant:\n%s", entry, want)
+	}
+	if strings.Contains(string(entry), "ALL ALL") {
+		t.Errorf("sudoers entry holds a rule from the user ID:\n%s", entry)
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/api"
+	"github.com/petermein/apollo/cmd/operator/config"
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/operatorrpc"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// jobStreamMaxBackoff bounds the delay between attempts to reopen the job stream
+const jobStreamMaxBackoff = 30 * time.Second
+
+// dialOperatorRPC connects to the API servers' gRPC port, authenticating
+// every call with the operator token the API client holds
+func dialOperatorRPC(cfg config.APIConfig, apiClient *api.Client) (*grpc.ClientConn, error) {
+	transport := credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	if cfg.GRPCInsecure {
+		transport = insecure.NewCredentials()
+	}
+	return grpc.Dial(cfg.GRPCEndpoint,
+		grpc.WithTransportCredentials(transport),
+		grpc.WithPerRPCCredentials(operatorrpc.TokenCredentials{Token: apiClient.Token, Insecure: cfg.GRPCInsecure}),
+	)
+}
+
+// streamJobs receives the jobs of every enabled module that runs jobs over
+// gRPC and reports their results, until the context is done. The stream is
+// reopened with backoff when it breaks.
+func streamJobs(ctx context.Context, client operatorrpc.OperatorClient, operatorID string, enabled []modules.Module) {
+	handlers := map[string]modules.JobHandler{}
+	request := &operatorrpc.JobsRequest{OperatorId: operatorID}
+	for _, module := range enabled {
+		if handler, ok := module.(modules.JobHandler); ok {
+			handlers[module.Name()] = handler
+			request.Modules = append(request.Modules, module.Name())
+		}
+	}
+	if len(handlers) == 0 {
+		return
+	}
+	report := func(ctx context.Context, result bus.ResultMessage) error {
+		_, err := client.ReportResult(ctx, &operatorrpc.Result{
+			Id:         result.ID,
+			OperatorId: result.OperatorID,
+			Status:     result.Status,
+			Result:     result.Result,
+			Error:      result.Error,
+		})
+		return err
+	}
+
+	// running holds the jobs being run, which a reopened stream sends again
+	var mu sync.Mutex
+	running := map[string]bool{}
+	backoff := time.Second
+	for {
+		stream, err := client.Jobs(ctx, request)
+		if err == nil {
+			log.Printf("Receiving %v jobs over gRPC", request.Modules)
+			for {
+				var job *operatorrpc.Job
+				if job, err = stream.Recv(); err != nil {
+					break
+				}
+				backoff = time.Second
+				handler, ok := handlers[job.GetModule()]
+				mu.Lock()
+				if !ok || running[job.GetId()] {
+					mu.Unlock()
+					continue
+				}
+				running[job.GetId()] = true
+				mu.Unlock()
+				go func(job bus.JobMessage) {
+					defer func() {
+						mu.Lock()
+						delete(running, job.ID)
+						mu.Unlock()
+					}()
+					runJob(operatorID, handler, job, report)
+				}(bus.JobMessage{ID: job.GetId(), Module: job.GetModule(), Type: job.GetType(), Request: job.GetRequest()})
+			}
+		}
+		if ctx.Err() != nil {
+			return
+		}
+		log.Printf("Job stream broke, reopening in %s: %v", backoff, err)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > jobStreamMaxBackoff {
+			backoff = jobStreamMaxBackoff
+		}
+	}
+}
+
+// sendHeartbeat reports the operator's health over gRPC
+func sendHeartbeat(ctx context.Context, client operatorrpc.OperatorClient, operatorID string, health []modules.ModuleHealth) error {
+	heartbeat := &operatorrpc.HeartbeatRequest{OperatorId: operatorID, Timestamp: timestamppb.Now()}
+	for _, h := range health {
+		heartbeat.Modules = append(heartbeat.Modules, &operatorrpc.ModuleHealth{Name: h.Name, Healthy: h.Healthy, Error: h.Error})
+	}
+	_, err := client.Heartbeat(ctx, heartbeat)
+	return err
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/api"
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/internal/bus"
+	"github.com/petermein/apollo/internal/events"
+)
+
+const (
+	// provisionAttempts is how many times a grant is provisioned before giving up
+	provisionAttempts = 3
+	// provisionRetryDelay is the wait before the first retry, doubling after each
+	provisionRetryDelay = 2 * time.Second
+)
+
+// consumeApprovals subscribes to approved events and has the enabled module
+// of each grant provision it, depositing the credentials with the API
+func consumeApprovals(b bus.Bus, client *api.Client, enabled []modules.Module) error {
+	provisioners := make(map[string]modules.Provisioner)
+	for _, module := range enabled {
+		if provisioner, ok := module.(modules.Provisioner); ok {
+			provisioners[module.Name()] = provisioner
+		}
+	}
+	if len(provisioners) == 0 {
+		return nil
+	}
+	if _, err := b.Subscribe(bus.EventSubject(events.Approved), bus.SubscribeOptions{}, func(data []byte) {
+		go provisionGrant(client, provisioners, data)
+	}); err != nil {
+		return err
+	}
+	log.Printf("Provisioning approved grants over the bus")
+	return nil
+}
+
+// provisionGrant provisions the grant of an approved event, retrying failures.
+// It runs apart from the subscription so retries hold up no other grant.
+func provisionGrant(client *api.Client, provisioners map[string]modules.Provisioner, data []byte) {
+	var event struct {
+		RequestID string                 `json:"request_id"`
+		Data      events.RequestApproved `json:"data"`
+	}
+	if err := json.Unmarshal(data, &event); err != nil || event.Data.GrantID == "" {
+		log.Printf("Ignoring malformed approved event: %v", err)
+		return
+	}
+	provisioner, ok := provisioners[event.Data.Module]
+	if !ok {
+		return
+	}
+
+	grant := modules.GrantRequest{
+		GrantID:    event.Data.GrantID,
+		UserID:     event.Data.UserID,
+		ResourceID: event.Data.ResourceID,
+		Level:      event.Data.Level,
+		ExpiresAt:  event.Data.ExpiresAt,
+		BoundTo:    event.Data.BoundTo,
+	}
+	delay := provisionRetryDelay
+	for attempt := 1; ; attempt++ {
+		ctx, cancel := context.WithTimeout(context.Background(), jobTimeout)
+		err := provision(ctx, client, provisioner, grant)
+		cancel()
+		if errors.Is(err, modules.ErrNotHandled) {
+			return
+		}
+		if err == nil {
+			log.Printf("Provisioned %s grant %s of request %s", event.Data.Module, grant.GrantID, event.RequestID)
+			return
+		}
+		if attempt == provisionAttempts {
+			log.Printf("Giving up on grant %s after %d attempts: %v", grant.GrantID, attempt, err)
+			return
+		}
+		log.Printf("Failed to provision grant %s, retrying in %s: %v", grant.GrantID, delay, err)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// provision has a module provision a grant and deposits its credentials
+func provision(ctx context.Context, client *api.Client, provisioner modules.Provisioner, grant modules.GrantRequest) error {
+	credentials, err := provisioner.Provision(ctx, grant)
+	if err != nil {
+		return err
+	}
+	return client.DepositCredentials(ctx, grant.GrantID, credentials)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/petermein/apollo/cmd/operator/api"
+	"github.com/petermein/apollo/cmd/operator/modules"
+)
+
+// provisionerFunc provisions grants with a function
+type provisionerFunc func(grant modules.GrantRequest) (map[string]string, error)
+
+func (f provisionerFunc) Provision(ctx context.Context, grant modules.GrantRequest) (map[string]string, error) {
+	return f(grant)
+}
+
+// TestProvisionGrantBoundTo checks the source network an approved grant is
+// bound to reaches the module provisioning it
+func TestProvisionGrantBoundTo(t *testing.T) {
+	deposited := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		deposited <- r.URL.Path
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	var provisioned modules.GrantRequest
+	provisioners := map[string]modules.Provisioner{
+		"mysql": provisionerFunc(func(grant modules.GrantRequest) (map[string]string, error) {
+			provisioned = grant
+			return map[string]string{"username": "apollo_1"}, nil
+		}),
+	}
+	event := `{"request_id":"req_1","data":{"grant_id":"grant_1","expires_at":"2030-01-02T03:04:05Z",` +
+		`"module":"mysql","resource_id":"orders","user_id":"alice","level":"read","bound_to":"10.8.0.12/32"}}`
+	provisionGrant(api.NewClient(server.URL, "operator-1", ""), provisioners, []byte(event))
+
+	select {
+	case path := <-deposited:
+		if path != "/api/v1/grants/grant_1/credentials" {
+			t.Errorf("deposited credentials at %s", path)
+		}
+	default:
+		t.Error("no credentials were deposited")
+	}
+	want := modules.GrantRequest{
+		GrantID:    "grant_1",
+		UserID:     "alice",
+		ResourceID: "orders",
+		Level:      "read",
+		ExpiresAt:  time.Date(2030, 1, 2, 3, 4, 5, 0, time.UTC),
+		BoundTo:    "10.8.0.12/32",
+	}
+	if provisioned != want {
+		t.Errorf("provisioned %+v, want %+v", provisioned, want)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/petermein/apollo/cmd/operator/config"
+	"github.com/petermein/apollo/cmd/operator/modules"
+	"github.com/petermein/apollo/cmd/operator/modules/elasticsearch"
+	"github.com/petermein/apollo/cmd/operator/modules/github"
+	"github.com/petermein/apollo/cmd/operator/modules/ldap"
+	"github.com/petermein/apollo/cmd/operator/modules/mock"
+	"github.com/petermein/apollo/cmd/operator/modules/mysql"
+	"github.com/petermein/apollo/cmd/operator/modules/snowflake"
+	"github.com/petermein/apollo/cmd/operator/modules/ssh"
+	"github.com/petermein/apollo/internal/configref"
+)
+
+// validateConfig checks the configuration file and the configuration of every
+// enabled module without connecting to the API or any server, returning
+// every problem found
+func validateConfig(path string) []error {
+	cfg, err := config.Parse(path)
+	if cfg == nil {
+		return []error{err}
+	}
+	var errs []error
+	var refErr *configref.Error
+	if errors.As(err, &refErr) {
+		errs = append(errs, refErr.Errs...)
+	}
+	errs = append(errs, cfg.Validate()...)
+
+	registry := modules.NewRegistry()
+	for _, module := range []modules.Module{mysql.NewModule(nil), mock.NewModule(), ssh.NewModule(), ldap.NewModule(), github.NewModule(), snowflake.NewModule(), elasticsearch.NewModule()} {
+		if err := registry.Register(module); err != nil {
+			return append(errs, err)
+		}
+	}
+
+	for _, name := range strings.Split(cfg.EnabledModules, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		module, err := registry.GetModule(name)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("enabled_modules: %v", err))
+			continue
+		}
+		validator, ok := module.(modules.ConfigValidator)
+		if !ok {
+			continue
+		}
+		for _, err := range configref.Flatten(validator.ValidateConfig(cfg.Modules[name])) {
+			errs = append(errs, fmt.Errorf("modules.%s: %v", name, err))
+		}
+	}
+	return errs
+}
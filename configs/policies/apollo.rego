@@ -0,0 +1,22 @@
+# Example Apollo policy for the OPA rule engine.
+# Decisions are queried at data.apollo.request and data.apollo.grant;
+# approvals are decided by approval.rego.
+package apollo.request
+
+import rego.v1
+
+default allow := false
+
+allow if count(violations) == 0
+
+violations contains {"rule": "max_duration", "message": "privilege duration exceeds 8h"} if {
+	input.duration > 8 * 3600
+}
+
+violations contains {"rule": "reason_required", "message": "reason is required for privilege request"} if {
+	input.request.reason == ""
+}
+
+violations contains {"rule": "no_root", "message": "root level grants must be requested through break-glass"} if {
+	input.request.level == "root"
+}
@@ -0,0 +1,17 @@
+# Example approval policy for the OPA rule engine.
+# Decisions are queried at data.apollo.approval.
+package apollo.approval
+
+import rego.v1
+
+default allow := false
+
+allow if count(violations) == 0
+
+violations contains {"rule": "self_approval", "message": "requesters cannot approve their own request"} if {
+	input.approver.id == input.request.user_id
+}
+
+violations contains {"rule": "operator_approval", "message": "operator identities cannot approve requests"} if {
+	input.approver.kind == "operator"
+}
@@ -0,0 +1,255 @@
+package anomaly
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Anomaly kinds
+const (
+	KindFirstAccess = "first_access"
+	KindOddHours    = "odd_hours"
+	KindSpike       = "request_spike"
+)
+
+// Anomaly is unusual behavior noticed in a user's requests
+type Anomaly struct {
+	Kind      string
+	UserID    string
+	RequestID string
+	Resource  string
+	Message   string
+}
+
+// RequestSource provides the request history the analyzer inspects
+type RequestSource interface {
+	// RequestsSince returns the requests created after the given time, oldest first
+	RequestsSince(ctx context.Context, since time.Time) ([]*models.PrivilegeRequest, error)
+
+	// UserRequests returns the user's requests created after the given time
+	UserRequests(ctx context.Context, userID string, since time.Time) ([]*models.PrivilegeRequest, error)
+}
+
+// Config configures the analyzer
+type Config struct {
+	// Interval between analysis runs
+	Interval time.Duration
+
+	// QuietHours is the local time range in which requests are unusual, e.g. 22:00-06:00
+	Timezone        string
+	QuietHoursStart string
+	QuietHoursEnd   string
+
+	// SpikeThreshold is the number of requests by one user within SpikeWindow that
+	// counts as a spike
+	SpikeWindow    time.Duration
+	SpikeThreshold int
+
+	// History is how far back previous requests are considered for first-access checks
+	History time.Duration
+}
+
+// Analyzer flags unusual request patterns, such as a user's first request for a
+// resource, requests at odd hours and sudden spikes. It never blocks requests;
+// anomalies are recorded as security audit events.
+type Analyzer struct {
+	config   Config
+	source   RequestSource
+	recorder audit.Recorder
+	location *time.Location
+	quiet    [2]int
+
+	mu        sync.Mutex
+	lastRun   time.Time
+	lastSpike map[string]time.Time
+}
+
+// NewAnalyzer creates an analyzer reading from the source and recording to the recorder
+func NewAnalyzer(config Config, source RequestSource, recorder audit.Recorder) (*Analyzer, error) {
+	if config.Interval == 0 {
+		config.Interval = time.Minute
+	}
+	if config.SpikeWindow == 0 {
+		config.SpikeWindow = time.Hour
+	}
+	if config.SpikeThreshold == 0 {
+		config.SpikeThreshold = 5
+	}
+	if config.History == 0 {
+		config.History = 90 * 24 * time.Hour
+	}
+
+	location := time.UTC
+	if config.Timezone != "" {
+		var err error
+		if location, err = time.LoadLocation(config.Timezone); err != nil {
+			return nil, fmt.Errorf("invalid timezone %q: %v", config.Timezone, err)
+		}
+	}
+
+	quiet := [2]int{-1, -1}
+	if config.QuietHoursStart != "" || config.QuietHoursEnd != "" {
+		for i, value := range []string{config.QuietHoursStart, config.QuietHoursEnd} {
+			minutes, err := parseClock(value)
+			if err != nil {
+				return nil, fmt.Errorf("invalid quiet hours: %v", err)
+			}
+			quiet[i] = minutes
+		}
+	}
+
+	return &Analyzer{
+		config:    config,
+		source:    source,
+		recorder:  recorder,
+		location:  location,
+		quiet:     quiet,
+		lastRun:   time.Now().UTC(),
+		lastSpike: make(map[string]time.Time),
+	}, nil
+}
+
+// Start runs the analyzer periodically until the context is cancelled
+func (a *Analyzer) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(a.config.Interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := a.Run(ctx); err != nil {
+					log.Printf("Failed to analyze access patterns: %v", err)
+				}
+			}
+		}
+	}()
+}
+
+// Run analyzes the requests created since the previous run
+func (a *Analyzer) Run(ctx context.Context) error {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	requests, err := a.source.RequestsSince(ctx, a.lastRun)
+	if err != nil {
+		return fmt.Errorf("failed to read requests: %v", err)
+	}
+
+	for _, request := range requests {
+		anomalies, err := a.analyze(ctx, request)
+		if err != nil {
+			return err
+		}
+		for _, anomaly := range anomalies {
+			a.report(ctx, anomaly)
+		}
+		if request.CreatedAt.After(a.lastRun) {
+			a.lastRun = request.CreatedAt
+		}
+	}
+	return nil
+}
+
+// analyze returns the anomalies a single request exhibits
+func (a *Analyzer) analyze(ctx context.Context, request *models.PrivilegeRequest) ([]Anomaly, error) {
+	var anomalies []Anomaly
+	flag := func(kind, format string, args ...interface{}) {
+		anomalies = append(anomalies, Anomaly{
+			Kind:      kind,
+			UserID:    request.UserID,
+			RequestID: request.ID,
+			Resource:  request.ResourceID,
+			Message:   fmt.Sprintf(format, args...),
+		})
+	}
+
+	previous, err := a.source.UserRequests(ctx, request.UserID, request.CreatedAt.Add(-a.config.History))
+	if err != nil {
+		return nil, fmt.Errorf("failed to read requests of %s: %v", request.UserID, err)
+	}
+
+	firstAccess := true
+	recent := 0
+	for _, p := range previous {
+		if p.ID == request.ID || p.CreatedAt.After(request.CreatedAt) {
+			continue
+		}
+		if p.ResourceID == request.ResourceID {
+			firstAccess = false
+		}
+		if request.CreatedAt.Sub(p.CreatedAt) <= a.config.SpikeWindow {
+			recent++
+		}
+	}
+
+	if firstAccess {
+		flag(KindFirstAccess, "%s requested %s access to %s for the first time", request.UserID, request.Level, request.ResourceID)
+	}
+	if a.inQuietHours(request.CreatedAt) {
+		flag(KindOddHours, "%s requested access to %s at %s", request.UserID, request.ResourceID, request.CreatedAt.In(a.location).Format("15:04 MST"))
+	}
+	// Report a spike once per window rather than for every request in it
+	if recent+1 >= a.config.SpikeThreshold && request.CreatedAt.Sub(a.lastSpike[request.UserID]) > a.config.SpikeWindow {
+		a.lastSpike[request.UserID] = request.CreatedAt
+		flag(KindSpike, "%s submitted %d requests within %s", request.UserID, recent+1, a.config.SpikeWindow)
+	}
+
+	return anomalies, nil
+}
+
+// inQuietHours reports whether t lies within the configured quiet hours
+func (a *Analyzer) inQuietHours(t time.Time) bool {
+	start, end := a.quiet[0], a.quiet[1]
+	if start < 0 || start == end {
+		return false
+	}
+	local := t.In(a.location)
+	minute := local.Hour()*60 + local.Minute()
+	if start < end {
+		return minute >= start && minute < end
+	}
+	// The quiet hours wrap around midnight
+	return minute >= start || minute < end
+}
+
+// report records the anomaly as a security event
+func (a *Analyzer) report(ctx context.Context, anomaly Anomaly) {
+	log.Printf("Anomaly detected: %s", anomaly.Message)
+	if a.recorder == nil {
+		return
+	}
+	if err := a.recorder.Record(ctx, audit.Entry{
+		Action:     audit.ActionAnomalyDetected,
+		Actor:      anomaly.UserID,
+		ResourceID: anomaly.Resource,
+		RequestID:  anomaly.RequestID,
+		Rule:       anomaly.Kind,
+		Message:    anomaly.Message,
+	}); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+}
+
+// parseClock parses an HH:MM time of day into minutes after midnight
+func parseClock(value string) (int, error) {
+	parts := strings.Split(value, ":")
+	if len(parts) != 2 {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	hours, errH := strconv.Atoi(parts[0])
+	minutes, errM := strconv.Atoi(parts[1])
+	if errH != nil || errM != nil || hours < 0 || hours > 23 || minutes < 0 || minutes > 59 {
+		return 0, fmt.Errorf("invalid time of day %q", value)
+	}
+	return hours*60 + minutes, nil
+}
@@ -0,0 +1,89 @@
+package audit
+
+import (
+	"context"
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// Audit actions
+const (
+	ActionRateLimited     = "rate_limited"
+	ActionShadowDecision  = "shadow_decision"
+	ActionAnomalyDetected = "anomaly_detected"
+	// ActionCredentialsRetrieved marks a grant's credentials as used
+	ActionCredentialsRetrieved = "credentials_retrieved"
+
+	// Privilege request lifecycle
+	ActionRequestCreated  = "request_created"
+	ActionRequestApproved = "request_approved"
+	ActionRequestDenied   = "request_denied"
+	ActionGrantCreated    = "grant_created"
+	ActionGrantExtended   = "grant_extended"
+	ActionGrantRevoked    = "grant_revoked"
+	ActionGrantExpired    = "grant_expired"
+
+	// Operator actions
+	ActionOperatorRegistered   = "operator_registered"
+	ActionJobCompleted         = "job_completed"
+	ActionCredentialsDeposited = "credentials_deposited"
+)
+
+// Entry is a single audit record
+type Entry struct {
+	ID         string            `json:"id,omitempty"`
+	Time       time.Time         `json:"time"`
+	Action     string            `json:"action"`
+	Actor      string            `json:"actor"`
+	ResourceID string            `json:"resource_id,omitempty"`
+	RequestID  string            `json:"request_id,omitempty"`
+	Rule       string            `json:"rule,omitempty"`
+	Message    string            `json:"message,omitempty"`
+	Details    map[string]string `json:"details,omitempty"`
+
+	// UserID is the user whose access the entry concerns, e.g. the requester
+	// of a request someone else approved
+	UserID string `json:"user_id,omitempty"`
+
+	// PolicyVersion is the version of the policy that made the decision
+	PolicyVersion int `json:"policy_version,omitempty"`
+}
+
+// Recorder records audit entries
+type Recorder interface {
+	Record(ctx context.Context, entry Entry) error
+}
+
+// LogRecorder writes audit entries to the process log as JSON
+type LogRecorder struct{}
+
+// Record writes the entry to the log
+func (LogRecorder) Record(ctx context.Context, entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	log.Printf("AUDIT %s", data)
+	return nil
+}
+
+// MultiRecorder records every entry with each of its recorders
+type MultiRecorder []Recorder
+
+// Record passes the entry to all recorders and returns the first error
+func (m MultiRecorder) Record(ctx context.Context, entry Entry) error {
+	if entry.Time.IsZero() {
+		entry.Time = time.Now().UTC()
+	}
+	var firstErr error
+	for _, recorder := range m {
+		if err := recorder.Record(ctx, entry); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
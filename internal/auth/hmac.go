@@ -0,0 +1,183 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Headers of requests signed with an operator's shared secret
+const (
+	OperatorHeader  = "X-Apollo-Operator"
+	TimestampHeader = "X-Apollo-Timestamp"
+	NonceHeader     = "X-Apollo-Nonce"
+	SignatureHeader = "X-Apollo-Signature"
+)
+
+// DefaultMaxSkew is how far a signed request's timestamp may be from the
+// server's clock when none is configured
+const DefaultMaxSkew = 5 * time.Minute
+
+// MinSecretSize is the minimum size of an operator's shared secret
+const MinSecretSize = 32
+
+// maxSignedBody bounds the body read to check a signature
+const maxSignedBody = 10 << 20
+
+// ErrUnsigned is returned for requests that carry no signature
+var ErrUnsigned = errors.New("request is not signed")
+
+// SignRequest signs the request, whose body is given, as the operator holding the secret
+func SignRequest(req *http.Request, body []byte, operatorID string, secret []byte, now time.Time) error {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		return fmt.Errorf("failed to generate nonce: %v", err)
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set(OperatorHeader, operatorID)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, hex.EncodeToString(nonce))
+	req.Header.Set(SignatureHeader, signature(secret, req.Method, req.URL.RequestURI(), timestamp, req.Header.Get(NonceHeader), body))
+	return nil
+}
+
+// signature returns the hex encoded HMAC-SHA256 of a request's method, path
+// and query, timestamp, nonce and body hash
+func signature(secret []byte, method, uri, timestamp, nonce string, body []byte) string {
+	bodyHash := sha256.Sum256(body)
+	mac := hmac.New(sha256.New, secret)
+	fmt.Fprintf(mac, "APOLLO-HMAC-SHA256\n%s\n%s\n%s\n%s\n%s", method, uri, timestamp, nonce, hex.EncodeToString(bodyHash[:]))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// HMACTransport signs the requests it sends with an operator's shared secret
+type HMACTransport struct {
+	OperatorID string
+	Secret     []byte
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip sends the signed request
+func (t *HMACTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	var body []byte
+	if req.Body != nil {
+		var err error
+		body, err = io.ReadAll(req.Body)
+		req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to read request body: %v", err)
+		}
+	}
+	req = req.Clone(req.Context())
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	req.ContentLength = int64(len(body))
+	if err := SignRequest(req, body, t.OperatorID, t.Secret, time.Now()); err != nil {
+		return nil, err
+	}
+	return base.RoundTrip(req)
+}
+
+// HMACVerifier checks requests signed with the operators' shared secrets.
+// A request is accepted only within the allowed skew of its timestamp and
+// only once, so captured requests can't be replayed.
+type HMACVerifier struct {
+	secrets map[string][]byte
+	maxSkew time.Duration
+
+	// nonces holds the nonces seen until their requests fall out of the skew
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewHMACVerifier creates a verifier for the operators' secrets by operator ID
+func NewHMACVerifier(secrets map[string][]byte, maxSkew time.Duration) (*HMACVerifier, error) {
+	for id, secret := range secrets {
+		if len(secret) < MinSecretSize {
+			return nil, fmt.Errorf("secret of operator %s must be at least %d bytes", id, MinSecretSize)
+		}
+	}
+	if maxSkew <= 0 {
+		maxSkew = DefaultMaxSkew
+	}
+	return &HMACVerifier{secrets: secrets, maxSkew: maxSkew, nonces: make(map[string]time.Time)}, nil
+}
+
+// Verify checks the request's signature and returns the operator that signed
+// it. The body is read and put back for the handler. Requests without a
+// signature return ErrUnsigned.
+func (v *HMACVerifier) Verify(r *http.Request, now time.Time) (string, error) {
+	sig := r.Header.Get(SignatureHeader)
+	if sig == "" {
+		return "", ErrUnsigned
+	}
+	operatorID := r.Header.Get(OperatorHeader)
+	secret, ok := v.secrets[operatorID]
+	if !ok {
+		return "", fmt.Errorf("unknown operator %q", operatorID)
+	}
+
+	timestamp := r.Header.Get(TimestampHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid timestamp")
+	}
+	signedAt := time.Unix(unix, 0)
+	if signedAt.Before(now.Add(-v.maxSkew)) || signedAt.After(now.Add(v.maxSkew)) {
+		return "", fmt.Errorf("timestamp is outside the allowed skew of %s", v.maxSkew)
+	}
+	nonce := r.Header.Get(NonceHeader)
+	if nonce == "" {
+		return "", fmt.Errorf("nonce is required")
+	}
+
+	var body []byte
+	if r.Body != nil {
+		body, err = io.ReadAll(io.LimitReader(r.Body, maxSignedBody))
+		r.Body.Close()
+		if err != nil {
+			return "", fmt.Errorf("failed to read request body: %v", err)
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+	}
+	expected := signature(secret, r.Method, r.URL.RequestURI(), timestamp, nonce, body)
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return "", fmt.Errorf("signature does not match")
+	}
+	// Only a valid signature uses up the nonce, so forged requests can't burn it
+	if err := v.useNonce(operatorID, nonce, signedAt.Add(v.maxSkew), now); err != nil {
+		return "", err
+	}
+	return operatorID, nil
+}
+
+// useNonce accepts an operator's nonce once until it expires
+func (v *HMACVerifier) useNonce(operatorID, nonce string, expiresAt, now time.Time) error {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	for key, expiry := range v.nonces {
+		if now.After(expiry) {
+			delete(v.nonces, key)
+		}
+	}
+	key := operatorID + "\n" + nonce
+	if _, ok := v.nonces[key]; ok {
+		return fmt.Errorf("request was already received")
+	}
+	v.nonces[key] = expiresAt
+	return nil
+}
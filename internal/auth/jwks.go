@@ -0,0 +1,196 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultJWKSRefresh is how long a fetched key set is used before it is fetched again
+	DefaultJWKSRefresh = time.Hour
+	// minJWKSFetchInterval limits how often tokens signed with unknown keys
+	// make the key set be fetched again
+	minJWKSFetchInterval = time.Minute
+)
+
+// keySet caches the keys of a JSON Web Key Set. It is fetched again once it
+// is older than the refresh interval, or when a token is signed with a key it
+// doesn't hold, so rotated keys are picked up.
+type keySet struct {
+	// url returns the location of the key set
+	url        func(ctx context.Context) (string, error)
+	httpClient *http.Client
+	refresh    time.Duration
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetchedAt time.Time
+}
+
+// newKeySet creates a key set read from the URL url returns
+func newKeySet(url func(ctx context.Context) (string, error), httpClient *http.Client, refresh time.Duration) *keySet {
+	if refresh <= 0 {
+		refresh = DefaultJWKSRefresh
+	}
+	return &keySet{url: url, httpClient: httpClient, refresh: refresh}
+}
+
+// key returns the signing key with the given ID. A key set that can't be
+// fetched again keeps being used until a token needs a key it doesn't hold.
+func (s *keySet) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key, ok := s.keys[kid]
+	age := time.Since(s.fetchedAt)
+	if ok && age < s.refresh {
+		return key, nil
+	}
+	if !ok && s.keys != nil && age < minJWKSFetchInterval {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+
+	keys, err := s.fetch(ctx)
+	if err != nil {
+		if ok {
+			log.Printf("Failed to refresh signing keys, using cached keys: %v", err)
+			return key, nil
+		}
+		return nil, err
+	}
+	s.keys = keys
+	s.fetchedAt = time.Now()
+
+	if key, ok = keys[kid]; !ok {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return key, nil
+}
+
+// fetch downloads the key set, skipping keys of unsupported types
+func (s *keySet) fetch(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	url, err := s.url(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var jwks struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			Crv string `json:"crv"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := getJSON(ctx, s.httpClient, url, &jwks); err != nil {
+		return nil, fmt.Errorf("failed to fetch signing keys: %v", err)
+	}
+
+	keys := make(map[string]crypto.PublicKey)
+	for _, k := range jwks.Keys {
+		switch k.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(k.N)
+			e, errE := base64.RawURLEncoding.DecodeString(k.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			if k.Crv != "P-256" {
+				continue
+			}
+			x, errX := base64.RawURLEncoding.DecodeString(k.X)
+			y, errY := base64.RawURLEncoding.DecodeString(k.Y)
+			if errX != nil || errY != nil {
+				continue
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return keys, nil
+}
+
+// discoverJWKS returns a function finding the issuer's key set via OIDC discovery
+func discoverJWKS(issuer string, httpClient *http.Client) func(ctx context.Context) (string, error) {
+	return func(ctx context.Context) (string, error) {
+		var discovery struct {
+			JWKSURI string `json:"jwks_uri"`
+		}
+		if err := getJSON(ctx, httpClient, strings.TrimSuffix(issuer, "/")+"/.well-known/openid-configuration", &discovery); err != nil {
+			return "", fmt.Errorf("failed to discover provider: %v", err)
+		}
+		if discovery.JWKSURI == "" {
+			return "", fmt.Errorf("provider %s doesn't publish a jwks_uri", issuer)
+		}
+		return discovery.JWKSURI, nil
+	}
+}
+
+// parseToken checks the signature of a compact JWS with keys from the key set
+// and returns its decoded payload
+func parseToken(ctx context.Context, rawToken string, keys *keySet) ([]byte, error) {
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("failed to decode token header: %v", err)
+	}
+
+	key, err := keys.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token signature: %v", err)
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %v", err)
+	}
+	return payload, nil
+}
+
+// getJSON fetches a JSON document
+func getJSON(ctx context.Context, httpClient *http.Client, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
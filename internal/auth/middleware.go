@@ -0,0 +1,396 @@
+package auth
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/petermein/apollo/internal/audit"
+)
+
+// UserHeader carries the user a request is made by. With a middleware in
+// front of the handlers it holds the authenticated principal's ID; whatever
+// the client sent is dropped.
+const UserHeader = "X-Apollo-User"
+
+// DefaultLeeway is the clock skew tolerated when checking token times
+const DefaultLeeway = time.Minute
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	// ID is the user handlers act as, taken from the issuer's user claim
+	ID      string
+	Subject string
+	Email   string
+	Groups  []string
+	Issuer  string
+	// ExpiresAt is when the token the principal authenticated with expires
+	ExpiresAt time.Time
+	// IssuedAt is when the token was issued, zero when it doesn't say
+	IssuedAt time.Time
+	// SessionID identifies the token as a session the server can list and
+	// revoke; it is empty for principals that didn't present a JWT
+	SessionID string
+}
+
+// InGroup reports whether the principal is a member of one of the groups
+func (p *Principal) InGroup(groups ...string) bool {
+	for _, group := range groups {
+		for _, member := range p.Groups {
+			if member == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// principalKey is the context key of the request's principal
+type principalKey struct{}
+
+// WithPrincipal returns a context carrying the principal
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal of the request the context belongs to
+func PrincipalFrom(ctx context.Context) (*Principal, bool) {
+	principal, ok := ctx.Value(principalKey{}).(*Principal)
+	return principal, ok
+}
+
+// IssuerConfig trusts the bearer tokens of one issuer
+type IssuerConfig struct {
+	// Issuer is the iss claim of the tokens
+	Issuer string
+	// Audiences lists the aud values accepted; a token must name one of them
+	Audiences []string
+	// JWKSURL is where the signing keys are published; they are found via
+	// OIDC discovery when empty
+	JWKSURL string
+	// UserClaim names the claim holding the user ID, email when empty; the
+	// subject is used when a token lacks it
+	UserClaim string
+	// GroupsClaim names the claim listing the user's groups, groups when empty
+	GroupsClaim string
+	// Nonce requires every token to carry a nonce that is accepted only once,
+	// for issuers minting a token per request
+	Nonce bool
+}
+
+// MiddlewareConfig configures the bearer token middleware
+type MiddlewareConfig struct {
+	Issuers []IssuerConfig
+	// Required rejects requests without a token; otherwise they pass through
+	// with the user header the client sent, while clients move to tokens
+	Required bool
+	// Public lists the paths, or path prefixes ending in /, that are served
+	// without a token, like health checks and links that carry their own signature
+	Public []string
+	// JWKSRefresh is how long key sets are cached, DefaultJWKSRefresh when zero
+	JWKSRefresh time.Duration
+	// Leeway is the clock skew tolerated, DefaultLeeway when zero
+	Leeway time.Duration
+}
+
+// issuer is a trusted issuer with its cached key set
+type issuer struct {
+	config IssuerConfig
+	keys   *keySet
+}
+
+// Middleware authenticates requests by their bearer JWT and puts the
+// principal in the request context for handlers and audit
+type Middleware struct {
+	issuers  map[string]*issuer
+	required bool
+	public   []string
+	leeway   time.Duration
+
+	// nonces holds the nonces seen until their tokens expire
+	mu     sync.Mutex
+	nonces map[string]time.Time
+}
+
+// NewMiddleware creates a middleware trusting the configured issuers
+func NewMiddleware(config MiddlewareConfig) (*Middleware, error) {
+	if len(config.Issuers) == 0 {
+		return nil, fmt.Errorf("at least one issuer is required")
+	}
+	m := &Middleware{
+		issuers:  make(map[string]*issuer),
+		required: config.Required,
+		public:   config.Public,
+		leeway:   config.Leeway,
+		nonces:   make(map[string]time.Time),
+	}
+	if m.leeway <= 0 {
+		m.leeway = DefaultLeeway
+	}
+	httpClient := &http.Client{Timeout: 10 * time.Second}
+	for i, issuerConfig := range config.Issuers {
+		if issuerConfig.Issuer == "" {
+			return nil, fmt.Errorf("issuer %d: issuer is required", i)
+		}
+		if len(issuerConfig.Audiences) == 0 {
+			return nil, fmt.Errorf("issuer %s: at least one audience is required", issuerConfig.Issuer)
+		}
+		if issuerConfig.UserClaim == "" {
+			issuerConfig.UserClaim = "email"
+		}
+		if issuerConfig.GroupsClaim == "" {
+			issuerConfig.GroupsClaim = "groups"
+		}
+		name := strings.TrimSuffix(issuerConfig.Issuer, "/")
+		if _, ok := m.issuers[name]; ok {
+			return nil, fmt.Errorf("issuer %s is configured twice", issuerConfig.Issuer)
+		}
+		url := discoverJWKS(name, httpClient)
+		if jwksURL := issuerConfig.JWKSURL; jwksURL != "" {
+			url = func(ctx context.Context) (string, error) { return jwksURL, nil }
+		}
+		m.issuers[name] = &issuer{config: issuerConfig, keys: newKeySet(url, httpClient, config.JWKSRefresh)}
+	}
+	return m, nil
+}
+
+// Wrap authenticates the requests to next. A valid token sets the principal
+// and the user header; an invalid one is always rejected.
+func (m *Middleware) Wrap(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// A principal authenticated in front of the middleware, like a
+		// service account, is kept
+		if _, ok := PrincipalFrom(r.Context()); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+		rawToken, ok := bearerToken(r)
+		if !ok {
+			if m.required {
+				r.Header.Del(UserHeader)
+				if !m.isPublic(r.URL.Path) {
+					unauthorized(w, "", "Authentication required")
+					return
+				}
+			}
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		principal, err := m.Authenticate(r.Context(), rawToken)
+		if err != nil {
+			log.Printf("Rejected bearer token for %s %s: %v", r.Method, r.URL.Path, err)
+			unauthorized(w, "invalid_token", "Invalid token")
+			return
+		}
+		r = r.WithContext(WithPrincipal(r.Context(), principal))
+		r.Header.Set(UserHeader, principal.ID)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Authenticate verifies a token and returns its principal
+func (m *Middleware) Authenticate(ctx context.Context, rawToken string) (*Principal, error) {
+	// The issuer decides which keys the signature is checked with
+	parts := strings.Split(rawToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed token")
+	}
+	var unverified struct {
+		Issuer string `json:"iss"`
+	}
+	if err := decodeSegment(parts[1], &unverified); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %v", err)
+	}
+	iss, ok := m.issuers[strings.TrimSuffix(unverified.Issuer, "/")]
+	if !ok {
+		return nil, fmt.Errorf("untrusted token issuer %q", unverified.Issuer)
+	}
+
+	payload, err := parseToken(ctx, rawToken, iss.keys)
+	if err != nil {
+		return nil, err
+	}
+	var claims Claims
+	var raw map[string]interface{}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %v", err)
+	}
+	if err := json.Unmarshal(payload, &raw); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %v", err)
+	}
+
+	now := time.Now()
+	if !audienceAccepted(claims.Audience, iss.config.Audiences) {
+		return nil, fmt.Errorf("token is not intended for this server")
+	}
+	if claims.Expiry == 0 {
+		return nil, fmt.Errorf("token has no expiry")
+	}
+	expiresAt := time.Unix(claims.Expiry, 0)
+	if now.After(expiresAt.Add(m.leeway)) {
+		return nil, fmt.Errorf("token has expired")
+	}
+	if claims.NotBefore != 0 && now.Add(m.leeway).Before(time.Unix(claims.NotBefore, 0)) {
+		return nil, fmt.Errorf("token is not valid yet")
+	}
+	if claims.IssuedAt != 0 && now.Add(m.leeway).Before(time.Unix(claims.IssuedAt, 0)) {
+		return nil, fmt.Errorf("token is issued in the future")
+	}
+	if iss.config.Nonce {
+		if err := m.useNonce(iss.config.Issuer, claims.Nonce, expiresAt.Add(m.leeway), now); err != nil {
+			return nil, err
+		}
+	}
+
+	principal := &Principal{
+		ID:        stringClaim(raw, iss.config.UserClaim),
+		Subject:   claims.Subject,
+		Email:     claims.Email,
+		Groups:    stringsClaim(raw, iss.config.GroupsClaim),
+		Issuer:    claims.Issuer,
+		ExpiresAt: expiresAt,
+		SessionID: SessionID(rawToken),
+	}
+	if claims.IssuedAt != 0 {
+		principal.IssuedAt = time.Unix(claims.IssuedAt, 0)
+	}
+	if principal.ID == "" {
+		principal.ID = claims.Subject
+	}
+	if principal.ID == "" {
+		return nil, fmt.Errorf("token names no user")
+	}
+	return principal, nil
+}
+
+// SessionID returns the ID of the session a token stands for, derived from
+// its hash so the token itself is never stored
+func SessionID(rawToken string) string {
+	hash := sha256.Sum256([]byte(rawToken))
+	return "ses_" + hex.EncodeToString(hash[:16])
+}
+
+// useNonce accepts a nonce once until it expires
+func (m *Middleware) useNonce(issuer, nonce string, expiresAt, now time.Time) error {
+	if nonce == "" {
+		return fmt.Errorf("token has no nonce")
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, expiry := range m.nonces {
+		if now.After(expiry) {
+			delete(m.nonces, key)
+		}
+	}
+	key := issuer + "\n" + nonce
+	if _, ok := m.nonces[key]; ok {
+		return fmt.Errorf("token nonce was already used")
+	}
+	m.nonces[key] = expiresAt
+	return nil
+}
+
+// isPublic reports whether the path is served without a token
+func (m *Middleware) isPublic(path string) bool {
+	for _, public := range m.public {
+		if path == public || (strings.HasSuffix(public, "/") && strings.HasPrefix(path, public)) {
+			return true
+		}
+	}
+	return false
+}
+
+// bearerToken returns the token of the request's bearer authorization
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+	token = strings.TrimSpace(token)
+	return token, token != ""
+}
+
+// unauthorized rejects a request, telling the client to authenticate
+func unauthorized(w http.ResponseWriter, errorCode, message string) {
+	challenge := `Bearer realm="apollo"`
+	if errorCode != "" {
+		challenge += fmt.Sprintf(`, error="%s"`, errorCode)
+	}
+	w.Header().Set("WWW-Authenticate", challenge)
+	http.Error(w, message, http.StatusUnauthorized)
+}
+
+// audienceAccepted reports whether the token's audience names an accepted one
+func audienceAccepted(aud audience, accepted []string) bool {
+	for _, a := range accepted {
+		if containsAudience(aud, a) {
+			return true
+		}
+	}
+	return false
+}
+
+// stringClaim returns a string claim, or nothing when it isn't one
+func stringClaim(claims map[string]interface{}, name string) string {
+	value, _ := claims[name].(string)
+	return value
+}
+
+// stringsClaim returns a claim holding a list of strings, or a single string
+// as a list of one
+func stringsClaim(claims map[string]interface{}, name string) []string {
+	switch value := claims[name].(type) {
+	case string:
+		return []string{value}
+	case []interface{}:
+		var values []string
+		for _, v := range value {
+			if s, ok := v.(string); ok {
+				values = append(values, s)
+			}
+		}
+		return values
+	}
+	return nil
+}
+
+// auditRecorder adds the principal of the request an entry is recorded in
+type auditRecorder struct {
+	recorder audit.Recorder
+}
+
+// AuditRecorder wraps a recorder so that entries recorded while serving an
+// authenticated request by its principal name the token's issuer, subject
+// and groups
+func AuditRecorder(recorder audit.Recorder) audit.Recorder {
+	return auditRecorder{recorder: recorder}
+}
+
+// Record adds the principal's details to the entry and records it
+func (a auditRecorder) Record(ctx context.Context, entry audit.Entry) error {
+	if principal, ok := PrincipalFrom(ctx); ok && entry.Actor == principal.ID {
+		details := make(map[string]string, len(entry.Details)+3)
+		for key, value := range entry.Details {
+			details[key] = value
+		}
+		details["auth_issuer"] = principal.Issuer
+		details["auth_subject"] = principal.Subject
+		if len(principal.Groups) > 0 {
+			groups := append([]string(nil), principal.Groups...)
+			sort.Strings(groups)
+			details["auth_groups"] = strings.Join(groups, ",")
+		}
+		entry.Details = details
+	}
+	return a.recorder.Record(ctx, entry)
+}
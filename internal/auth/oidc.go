@@ -0,0 +1,170 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Claims holds the standard claims of a verified OIDC token
+type Claims struct {
+	Issuer    string   `json:"iss"`
+	Subject   string   `json:"sub"`
+	Audience  audience `json:"aud"`
+	Expiry    int64    `json:"exp"`
+	NotBefore int64    `json:"nbf"`
+	IssuedAt  int64    `json:"iat"`
+	AuthTime  int64    `json:"auth_time"`
+	Nonce     string   `json:"nonce"`
+	ACR       string   `json:"acr"`
+	AMR       []string `json:"amr"`
+	Email     string   `json:"email"`
+}
+
+// AuthenticatedAt returns when the user last actively authenticated
+func (c *Claims) AuthenticatedAt() time.Time {
+	if c.AuthTime == 0 {
+		return time.Time{}
+	}
+	return time.Unix(c.AuthTime, 0)
+}
+
+// audience accepts the aud claim as a single string or a list
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var list []string
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+	*a = list
+	return nil
+}
+
+// Verifier verifies ID tokens issued by an OIDC provider
+type Verifier struct {
+	issuer   string
+	clientID string
+	keys     *keySet
+}
+
+// NewVerifier creates a verifier for ID tokens issued to clientID by the issuer
+func NewVerifier(issuer, clientID string) *Verifier {
+	httpClient := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+	return &Verifier{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		clientID: clientID,
+		keys:     newKeySet(discoverJWKS(issuer, httpClient), httpClient, DefaultJWKSRefresh),
+	}
+}
+
+// Verify checks the token's signature, issuer, audience and expiry and returns its claims
+func (v *Verifier) Verify(ctx context.Context, rawToken string) (*Claims, error) {
+	payload, err := parseToken(ctx, rawToken, v.keys)
+	if err != nil {
+		return nil, err
+	}
+
+	var claims Claims
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, fmt.Errorf("failed to decode token claims: %v", err)
+	}
+	if strings.TrimSuffix(claims.Issuer, "/") != v.issuer {
+		return nil, fmt.Errorf("unexpected token issuer %q", claims.Issuer)
+	}
+	if !containsAudience(claims.Audience, v.clientID) {
+		return nil, fmt.Errorf("token is not intended for %s", v.clientID)
+	}
+	if time.Now().After(time.Unix(claims.Expiry, 0)) {
+		return nil, fmt.Errorf("token has expired")
+	}
+
+	return &claims, nil
+}
+
+// verifySignature checks a JWS signature for the supported algorithms
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	digest := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("signing key does not match algorithm %s", alg)
+		}
+		if err := rsa.VerifyPKCS1v15(rsaKey, crypto.SHA256, digest[:], signature); err != nil {
+			return fmt.Errorf("invalid token signature")
+		}
+	case "ES256":
+		ecKey, ok := key.(*ecdsa.PublicKey)
+		if !ok || len(signature) != 64 {
+			return fmt.Errorf("signing key does not match algorithm %s", alg)
+		}
+		r := new(big.Int).SetBytes(signature[:32])
+		s := new(big.Int).SetBytes(signature[32:])
+		if !ecdsa.Verify(ecKey, digest[:], r, s) {
+			return fmt.Errorf("invalid token signature")
+		}
+	default:
+		return fmt.Errorf("unsupported signing algorithm %q", alg)
+	}
+	return nil
+}
+
+func decodeSegment(segment string, out interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, out)
+}
+
+func containsAudience(aud audience, clientID string) bool {
+	for _, a := range aud {
+		if a == clientID {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckStepUp verifies that the claims prove a fresh authentication with one of the
+// accepted authentication context classes
+func CheckStepUp(claims *Claims, acrValues []string, maxAge time.Duration) error {
+	if len(acrValues) > 0 {
+		accepted := false
+		for _, acr := range acrValues {
+			if claims.ACR == acr {
+				accepted = true
+				break
+			}
+		}
+		if !accepted {
+			return fmt.Errorf("authentication context %q is not sufficient", claims.ACR)
+		}
+	}
+
+	authTime := claims.AuthenticatedAt()
+	if authTime.IsZero() {
+		return fmt.Errorf("token does not contain an authentication time")
+	}
+	if maxAge > 0 && time.Since(authTime) > maxAge {
+		return fmt.Errorf("authentication is older than %s", maxAge)
+	}
+	return nil
+}
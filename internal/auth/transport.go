@@ -0,0 +1,22 @@
+package auth
+
+import "net/http"
+
+// BearerTransport authenticates the requests it sends with a bearer token,
+// for clients of a server behind the middleware
+type BearerTransport struct {
+	Token string
+	// Base sends the requests, http.DefaultTransport when nil
+	Base http.RoundTripper
+}
+
+// RoundTrip sends the request with the token
+func (t *BearerTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	base := t.Base
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	req = req.Clone(req.Context())
+	req.Header.Set("Authorization", "Bearer "+t.Token)
+	return base.RoundTrip(req)
+}
@@ -0,0 +1,220 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Memory delivers messages between the publishers and subscribers of a single
+// process, e.g. the API and operator of apollo dev. Nothing is persisted and
+// no other process can connect to it.
+type Memory struct {
+	mu     sync.Mutex
+	subs   map[int]*memorySubscription
+	nextID int
+	// queues counts the deliveries per queue group to rotate between its members
+	queues map[string]int
+	closed bool
+	done   chan struct{}
+}
+
+// memorySubscription delivers the messages of one subscription to its handler in order
+type memorySubscription struct {
+	memory   *Memory
+	id       int
+	subject  string
+	options  SubscribeOptions
+	messages chan []byte
+	// done is closed when the subscription ends
+	done chan struct{}
+
+	delivered atomic.Uint64
+	filtered  atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// NewMemory creates an in-process bus
+func NewMemory() *Memory {
+	return &Memory{
+		subs:   make(map[int]*memorySubscription),
+		queues: make(map[string]int),
+		done:   make(chan struct{}),
+	}
+}
+
+// Publish hands data to every subscription matching the subject, and to one
+// member of each queue group
+func (m *Memory) Publish(ctx context.Context, subject string, data []byte) error {
+	m.mu.Lock()
+	if m.closed {
+		m.mu.Unlock()
+		return fmt.Errorf("memory bus is closed")
+	}
+	var targets []*memorySubscription
+	groups := make(map[string][]*memorySubscription)
+	// Subscriptions are visited in order so queue groups rotate predictably
+	ids := make([]int, 0, len(m.subs))
+	for id := range m.subs {
+		ids = append(ids, id)
+	}
+	sort.Ints(ids)
+	for _, id := range ids {
+		sub := m.subs[id]
+		if !matchSubject(sub.subject, subject) {
+			continue
+		}
+		if sub.options.Queue == "" {
+			targets = append(targets, sub)
+			continue
+		}
+		groups[sub.options.Queue] = append(groups[sub.options.Queue], sub)
+	}
+	for queue, members := range groups {
+		targets = append(targets, members[m.queues[queue]%len(members)])
+		m.queues[queue]++
+	}
+	m.mu.Unlock()
+
+	// Handlers get their own copy, as they would from a connection
+	for _, sub := range targets {
+		sub.push(subject, append([]byte(nil), data...))
+	}
+	return nil
+}
+
+// Subscribe calls handler for every message on the subject
+func (m *Memory) Subscribe(subject string, options SubscribeOptions, handler Handler) (Subscription, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil, fmt.Errorf("memory bus is closed")
+	}
+	m.nextID++
+	sub := &memorySubscription{
+		memory:   m,
+		id:       m.nextID,
+		subject:  subject,
+		options:  options,
+		messages: make(chan []byte, options.Buffer),
+		done:     make(chan struct{}),
+	}
+	m.subs[sub.id] = sub
+	go func() {
+		for {
+			select {
+			case <-m.done:
+				return
+			case <-sub.done:
+				return
+			case data := <-sub.messages:
+				handler(data)
+				sub.delivered.Add(1)
+			}
+		}
+	}()
+	return sub, nil
+}
+
+// Close ends all subscriptions
+func (m *Memory) Close() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.closed {
+		return nil
+	}
+	m.closed = true
+	close(m.done)
+	return nil
+}
+
+// Unsubscribe stops delivering the subscription's messages
+func (s *memorySubscription) Unsubscribe() error {
+	m := s.memory
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if _, ok := m.subs[s.id]; !ok {
+		return nil
+	}
+	delete(m.subs, s.id)
+	close(s.done)
+	return nil
+}
+
+// Stats returns the delivery counts of the subscription
+func (s *memorySubscription) Stats() SubscriptionStats {
+	active := true
+	select {
+	case <-s.done:
+		active = false
+	case <-s.memory.done:
+		active = false
+	default:
+	}
+	stats := SubscriptionStats{
+		Subject:   s.subject,
+		Delivered: s.delivered.Load(),
+		Filtered:  s.filtered.Load(),
+		Dropped:   s.dropped.Load(),
+		Active:    active,
+	}
+	if active {
+		stats.Pending = len(s.messages)
+	}
+	return stats
+}
+
+// push hands a message to the subscription's handler, applying its overflow
+// policy when the buffer is full
+func (s *memorySubscription) push(subject string, data []byte) {
+	if s.options.Filter != nil && !s.options.Filter(subject) {
+		s.filtered.Add(1)
+		return
+	}
+	select {
+	case s.messages <- data:
+		return
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.options.Overflow {
+	case OverflowDrop:
+		s.dropped.Add(1)
+		log.Printf("Subscriber to %s is too slow, message dropped", s.subject)
+	case OverflowDisconnect:
+		s.dropped.Add(1)
+		log.Printf("Subscriber to %s is too slow, unsubscribing it", s.subject)
+		s.Unsubscribe()
+	default:
+		select {
+		case s.messages <- data:
+		case <-s.done:
+		case <-s.memory.done:
+		}
+	}
+}
+
+// matchSubject reports whether a subject matches a pattern, in which * stands
+// for one token and a trailing > for one or more
+func matchSubject(pattern, subject string) bool {
+	patternTokens := strings.Split(pattern, ".")
+	subjectTokens := strings.Split(subject, ".")
+	for i, token := range patternTokens {
+		if token == ">" && i == len(patternTokens)-1 {
+			return len(subjectTokens) > i
+		}
+		if i >= len(subjectTokens) || (token != "*" && token != subjectTokens[i]) {
+			return false
+		}
+	}
+	return len(patternTokens) == len(subjectTokens)
+}
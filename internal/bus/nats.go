@@ -0,0 +1,248 @@
+package bus
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nats-io/nats.go"
+)
+
+const (
+	// natsReconnectWait is the delay between reconnection attempts
+	natsReconnectWait = time.Second
+	// natsDropLogInterval is how many dropped messages of a subscription are
+	// logged as one line
+	natsDropLogInterval = 100
+)
+
+// NATS publishes and subscribes through a NATS server using the nats.go
+// client. The client reconnects when the connection drops and restores its
+// subscriptions; messages published while disconnected fail rather than being
+// buffered.
+type NATS struct {
+	conn *nats.Conn
+
+	mu     sync.Mutex
+	closed bool
+	done   chan struct{}
+}
+
+// natsSubscription delivers the messages of one subscription to its handler in order
+type natsSubscription struct {
+	nats    *NATS
+	sub     *nats.Subscription
+	subject string
+	options SubscribeOptions
+	// messages buffers the messages for the handler, so the overflow policy
+	// decides what happens when it falls behind
+	messages chan []byte
+	// done is closed when the subscription ends
+	done chan struct{}
+	once sync.Once
+
+	delivered atomic.Uint64
+	filtered  atomic.Uint64
+	dropped   atomic.Uint64
+}
+
+// ConnectNATS connects to a NATS server at a nats:// or tls:// URL
+func ConnectNATS(rawURL, name string) (*NATS, error) {
+	if rawURL == "" {
+		return nil, fmt.Errorf("NATS URL is required")
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid NATS URL: %v", err)
+	}
+	if u.Scheme != "nats" && u.Scheme != "tls" {
+		return nil, fmt.Errorf("NATS URL must use the nats or tls scheme")
+	}
+
+	conn, err := nats.Connect(rawURL,
+		nats.Name(name),
+		nats.Timeout(5*time.Second),
+		nats.MaxReconnects(-1),
+		nats.ReconnectWait(natsReconnectWait),
+		// Publishing while disconnected fails instead of buffering
+		nats.ReconnectBufSize(-1),
+		nats.DisconnectErrHandler(func(_ *nats.Conn, err error) {
+			if err != nil {
+				log.Printf("NATS connection lost: %v", err)
+			}
+		}),
+		nats.ReconnectHandler(func(conn *nats.Conn) {
+			log.Printf("Reconnected to NATS at %s", conn.ConnectedAddr())
+		}),
+		nats.ErrorHandler(func(_ *nats.Conn, sub *nats.Subscription, err error) {
+			if sub != nil {
+				log.Printf("NATS error on subscription to %s: %v", sub.Subject, err)
+				return
+			}
+			log.Printf("NATS server error: %v", err)
+		}),
+	)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to NATS: %v", err)
+	}
+	return &NATS{conn: conn, done: make(chan struct{})}, nil
+}
+
+// Publish sends data to the subject. The client writes it in the background;
+// when ctx has a deadline Publish waits until the server has it.
+func (n *NATS) Publish(ctx context.Context, subject string, data []byte) error {
+	if n.conn.IsClosed() {
+		return fmt.Errorf("NATS connection is closed")
+	}
+	if err := n.conn.Publish(subject, data); err != nil {
+		return fmt.Errorf("failed to publish to %s: %v", subject, err)
+	}
+	if _, ok := ctx.Deadline(); ok {
+		if err := n.conn.FlushWithContext(ctx); err != nil {
+			return fmt.Errorf("failed to publish to %s: %v", subject, err)
+		}
+	}
+	return nil
+}
+
+// Subscribe calls handler for every message on the subject
+func (n *NATS) Subscribe(subject string, options SubscribeOptions, handler Handler) (Subscription, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return nil, fmt.Errorf("NATS connection is closed")
+	}
+	s := &natsSubscription{
+		nats:     n,
+		subject:  subject,
+		options:  options,
+		messages: make(chan []byte, options.Buffer),
+		done:     make(chan struct{}),
+	}
+	deliver := func(msg *nats.Msg) { s.push(msg.Subject, msg.Data) }
+	var err error
+	if options.Queue != "" {
+		s.sub, err = n.conn.QueueSubscribe(subject, options.Queue, deliver)
+	} else {
+		s.sub, err = n.conn.Subscribe(subject, deliver)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to subscribe to %s: %v", subject, err)
+	}
+
+	go func() {
+		for {
+			select {
+			case <-n.done:
+				return
+			case <-s.done:
+				return
+			case data := <-s.messages:
+				handler(data)
+				s.delivered.Add(1)
+			}
+		}
+	}()
+	return s, nil
+}
+
+// Unsubscribe stops delivering the subscription's messages
+func (s *natsSubscription) Unsubscribe() error {
+	var err error
+	s.once.Do(func() {
+		close(s.done)
+		if s.nats.conn.IsClosed() {
+			return
+		}
+		if err = s.sub.Unsubscribe(); err != nil {
+			err = fmt.Errorf("failed to unsubscribe from %s: %v", s.subject, err)
+		}
+	})
+	return err
+}
+
+// Stats returns the delivery counts of the subscription
+func (s *natsSubscription) Stats() SubscriptionStats {
+	active := true
+	select {
+	case <-s.done:
+		active = false
+	case <-s.nats.done:
+		active = false
+	default:
+	}
+	stats := SubscriptionStats{
+		Subject:   s.subject,
+		Delivered: s.delivered.Load(),
+		Filtered:  s.filtered.Load(),
+		Dropped:   s.dropped.Load(),
+		Active:    active,
+	}
+	if active {
+		stats.Pending = len(s.messages)
+		// Messages the client dropped because they piled up behind a
+		// blocked handler count as dropped too
+		if dropped, err := s.sub.Dropped(); err == nil {
+			stats.Dropped += uint64(dropped)
+		}
+	}
+	return stats
+}
+
+// push hands a message to the subscription's handler, applying its overflow
+// policy when the buffer is full
+func (s *natsSubscription) push(subject string, data []byte) {
+	if s.options.Filter != nil && !s.options.Filter(subject) {
+		s.filtered.Add(1)
+		return
+	}
+	select {
+	case s.messages <- data:
+		return
+	case <-s.done:
+		return
+	default:
+	}
+
+	switch s.options.Overflow {
+	case OverflowDrop:
+		if dropped := s.dropped.Add(1); dropped == 1 || dropped%natsDropLogInterval == 0 {
+			log.Printf("Subscriber to %s is too slow, %d messages dropped", s.subject, dropped)
+		}
+	case OverflowDisconnect:
+		s.dropped.Add(1)
+		log.Printf("Subscriber to %s is too slow, unsubscribing it", s.subject)
+		if err := s.Unsubscribe(); err != nil {
+			log.Printf("Failed to unsubscribe slow subscriber: %v", err)
+		}
+	default:
+		select {
+		case s.messages <- data:
+		case <-s.done:
+		case <-s.nats.done:
+		}
+	}
+}
+
+// Close disconnects from the server and stops delivering messages
+func (n *NATS) Close() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.closed {
+		return nil
+	}
+	n.closed = true
+	close(n.done)
+	n.conn.Close()
+	return nil
+}
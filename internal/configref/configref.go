@@ -0,0 +1,165 @@
+// Package configref resolves the references configuration files make to
+// environment variables and secrets, so neither has to be written into them
+package configref
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// filePrefix starts a reference to a file holding a secret, e.g. one mounted
+// by Kubernetes or Docker at /run/secrets
+const filePrefix = "file:"
+
+// Error lists the references in a document that couldn't be resolved
+type Error struct {
+	Errs []error
+}
+
+// Error joins the problems, one per line
+func (e *Error) Error() string {
+	return errors.Join(e.Errs...).Error()
+}
+
+// Unwrap returns the problems
+func (e *Error) Unwrap() []error {
+	return e.Errs
+}
+
+// Resolve replaces the references in the values of a parsed YAML document:
+//
+//	${VAR}          the environment variable VAR, which must be set
+//	${VAR:-default} VAR, or default when it is unset or empty
+//	${file:/path}   the contents of the file, without a trailing newline
+//
+// Comments and keys are left alone. A value that was unquoted is typed by
+// what it resolves to, so port: ${PORT} can fill an integer. Every reference
+// that can't be resolved is reported in an *Error, not only the first.
+func Resolve(node *yaml.Node) error {
+	var errs []error
+	resolveNode(node, &errs)
+	if len(errs) > 0 {
+		return &Error{Errs: errs}
+	}
+	return nil
+}
+
+// Unmarshal parses YAML, resolves its references and decodes it into out.
+// References that can't be resolved are left empty and reported in an
+// *Error, but the rest of the document is still decoded, so it can be
+// checked as well.
+func Unmarshal(data []byte, out interface{}) error {
+	var node yaml.Node
+	if err := yaml.Unmarshal(data, &node); err != nil {
+		return err
+	}
+	resolveErr := Resolve(&node)
+	if len(node.Content) == 0 {
+		return resolveErr
+	}
+	if err := node.Decode(out); err != nil {
+		return err
+	}
+	return resolveErr
+}
+
+// Flatten splits errors joined by errors.Join, at any depth, so they can be
+// reported one per line
+func Flatten(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+	var errs []error
+	for _, e := range joined.Unwrap() {
+		errs = append(errs, Flatten(e)...)
+	}
+	return errs
+}
+
+// resolveNode resolves the scalar values under a node
+func resolveNode(node *yaml.Node, errs *[]error) {
+	switch node.Kind {
+	case yaml.DocumentNode, yaml.SequenceNode:
+		for _, child := range node.Content {
+			resolveNode(child, errs)
+		}
+	case yaml.MappingNode:
+		// Content alternates keys and values
+		for i := 1; i < len(node.Content); i += 2 {
+			resolveNode(node.Content[i], errs)
+		}
+	case yaml.ScalarNode:
+		if !strings.Contains(node.Value, "${") {
+			return
+		}
+		value, err := expand(node.Value)
+		if err != nil {
+			for _, e := range Flatten(err) {
+				*errs = append(*errs, fmt.Errorf("line %d: %v", node.Line, e))
+			}
+		}
+		node.Value = value
+		if node.Style == 0 {
+			// Let the decoder type the resolved value, e.g. as an int
+			node.Tag = ""
+		}
+	}
+}
+
+// expand replaces the references in a value
+func expand(value string) (string, error) {
+	var result strings.Builder
+	var errs []error
+	for {
+		start := strings.Index(value, "${")
+		if start == -1 {
+			result.WriteString(value)
+			break
+		}
+		end := strings.Index(value[start:], "}")
+		if end == -1 {
+			errs = append(errs, fmt.Errorf("unterminated reference in %q", value))
+			result.WriteString(value)
+			break
+		}
+		result.WriteString(value[:start])
+		resolved, err := lookup(value[start+2 : start+end])
+		if err != nil {
+			errs = append(errs, err)
+		}
+		result.WriteString(resolved)
+		value = value[start+end+1:]
+	}
+	return result.String(), errors.Join(errs...)
+}
+
+// lookup resolves a single reference
+func lookup(ref string) (string, error) {
+	if path, ok := strings.CutPrefix(ref, filePrefix); ok {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to read secret: %v", err)
+		}
+		return strings.TrimRight(string(data), "\r\n"), nil
+	}
+
+	name, fallback, hasDefault := strings.Cut(ref, ":-")
+	if name == "" {
+		return "", fmt.Errorf("empty reference ${%s}", ref)
+	}
+	if value := os.Getenv(name); value != "" {
+		return value, nil
+	}
+	if !hasDefault {
+		return "", fmt.Errorf("environment variable %s is not set", name)
+	}
+	return fallback, nil
+}
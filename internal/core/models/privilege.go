@@ -18,28 +18,179 @@ const (
 type PrivilegeRequest struct {
 	ID            string         `json:"id" gorm:"primaryKey"`
 	UserID        string         `json:"user_id"`
+	Module        string         `json:"module"`
 	ResourceID    string         `json:"resource_id"`
 	Level         PrivilegeLevel `json:"level"`
 	Reason        string         `json:"reason"`
+	SourceIP      string         `json:"source_ip,omitempty"`
 	RequestedAt   time.Time      `json:"requested_at"`
 	ExpiresAt     time.Time      `json:"expires_at"`
+	// PendingUntil is when the request expires if it is still waiting for a
+	// decision; pending requests without one wait until the server's TTL
+	PendingUntil  *time.Time     `json:"pending_until,omitempty"`
+	RequiredApprovals int        `json:"required_approvals"`
+	Notify        []string       `json:"notify,omitempty"`
+	ApprovalRoute string         `json:"approval_route,omitempty"`
+	Approvers     []string       `json:"approvers,omitempty"`
+	// ApprovalSteps are the stages the request is approved in, in order, when
+	// its approval route has steps
+	ApprovalSteps []ApprovalStep `json:"approval_steps,omitempty"`
+	StepUp        *StepUpRequirement `json:"step_up,omitempty"`
+	PolicyVersion int            `json:"policy_version,omitempty"`
+	RiskScore     int            `json:"risk_score"`
+	RiskFactors   []string       `json:"risk_factors,omitempty"`
+	// ExtendsGrant is set on requests to extend an active grant instead of creating a new one
+	ExtendsGrant  string         `json:"extends_grant,omitempty"`
+	// HandsOverGrant is set on requests to hand the rest of an active grant
+	// over to the requester; approving them ends the grant and creates the
+	// requester's in its place
+	HandsOverGrant string        `json:"hands_over_grant,omitempty"`
+	// HandedOverBy is who asked for the grant to be handed over, its holder
+	// or an administrator
+	HandedOverBy  string         `json:"handed_over_by,omitempty"`
+	// BoundTo restricts the credentials of the grant to this source network,
+	// e.g. 10.8.0.12/32, when the policy binds them to the requester
+	BoundTo       string         `json:"bound_to,omitempty"`
+	// WebAuthnApproval requires every approver to confirm with a WebAuthn
+	// assertion from a hardware key registered to them
+	WebAuthnApproval bool        `json:"webauthn_approval,omitempty"`
 	ApprovedBy    string         `json:"approved_by,omitempty"`
 	ApprovedAt    *time.Time     `json:"approved_at,omitempty"`
+	DeniedBy      string         `json:"denied_by,omitempty"`
+	// Approvals holds the signed record of every approval given so far
+	Approvals     []ApprovalRecord `json:"approvals,omitempty"`
 	Status        string         `json:"status"`
 	CreatedAt     time.Time      `json:"created_at"`
 	UpdatedAt     time.Time      `json:"updated_at"`
 }
 
+// Lapsed reports whether the request waited for a decision past its deadline
+func (r *PrivilegeRequest) Lapsed(now time.Time) bool {
+	return r.Status == "pending" && r.PendingUntil != nil && !now.Before(*r.PendingUntil)
+}
+
+// CurrentStep returns the first approval step still short of approvals, or
+// nil when the request has no steps or all of them are complete
+func (r *PrivilegeRequest) CurrentStep() *ApprovalStep {
+	for i := range r.ApprovalSteps {
+		step := &r.ApprovalSteps[i]
+		approvals := 0
+		for _, record := range r.Approvals {
+			if record.Step == step.Name {
+				approvals++
+			}
+		}
+		if approvals < step.Required {
+			return step
+		}
+	}
+	return nil
+}
+
+// ApprovalStep is one stage of a request's approvals: Required of the
+// Approvers must approve before the next step starts
+type ApprovalStep struct {
+	Name      string   `json:"name"`
+	Approvers []string `json:"approvers"`
+	Required  int      `json:"required"`
+}
+
+// StepUpRequirement describes the fresh MFA assertion a request needs before it can be approved
+type StepUpRequirement struct {
+	ACRValues  []string   `json:"acr_values,omitempty"`
+	MaxAge     int        `json:"max_age"`
+	VerifiedAt *time.Time `json:"verified_at,omitempty"`
+}
+
+// Satisfied reports whether a step-up proof has been attached
+func (s *StepUpRequirement) Satisfied() bool {
+	return s == nil || s.VerifiedAt != nil
+}
+
+// Approver kinds
+const (
+	ApproverKindUser     = "user"
+	ApproverKindOperator = "operator"
+	ApproverKindPolicy   = "policy"
+)
+
+// Approver identifies who approves a privilege request
+type Approver struct {
+	ID   string `json:"id"`
+	Kind string `json:"kind"`
+}
+
+// Approval channels
+const (
+	ApprovalChannelAPI        = "api"
+	ApprovalChannelLink       = "link"
+	ApprovalChannelMattermost = "mattermost"
+	ApprovalChannelPolicy     = "policy"
+)
+
+// ApprovalRecord records one approval of a privilege request: who approved
+// which access, when and how. The server signs every other field, so the
+// record can't be altered or made up later.
+type ApprovalRecord struct {
+	ApproverID   string `json:"approver_id"`
+	ApproverKind string `json:"approver_kind"`
+	// Issuer and Subject identify the bearer token the approver authenticated with
+	Issuer     string    `json:"issuer,omitempty"`
+	Subject    string    `json:"subject,omitempty"`
+	Channel    string    `json:"channel"`
+	ApprovedAt time.Time `json:"approved_at"`
+
+	UserID       string         `json:"user_id"`
+	Module       string         `json:"module"`
+	ResourceID   string         `json:"resource_id"`
+	Level        PrivilegeLevel `json:"level"`
+	Reason       string         `json:"reason"`
+	RequestedAt  time.Time      `json:"requested_at"`
+	ExpiresAt    time.Time      `json:"expires_at"`
+	ExtendsGrant string         `json:"extends_grant,omitempty"`
+	// WebAuthn is the hardware key assertion the approver confirmed with
+	WebAuthn *WebAuthnAssertion `json:"webauthn,omitempty"`
+	// Step is the approval step the approval counts towards, if any
+	Step string `json:"step,omitempty"`
+
+	KeyID     string `json:"key_id"`
+	Signature string `json:"signature"`
+}
+
+// WebAuthnAssertion records the WebAuthn assertion an approver confirmed an
+// approval with, so it can be checked again with the credential's public key.
+// Binary values are base64url encoded without padding.
+type WebAuthnAssertion struct {
+	CredentialID      string `json:"credential_id"`
+	AuthenticatorData string `json:"authenticator_data"`
+	ClientDataJSON    string `json:"client_data_json"`
+	Signature         string `json:"signature"`
+	SignCount         uint32 `json:"sign_count"`
+	UserVerified      bool   `json:"user_verified"`
+}
+
 // PrivilegeGrant represents an active privilege grant
 type PrivilegeGrant struct {
 	ID          string         `json:"id" gorm:"primaryKey"`
 	UserID      string         `json:"user_id"`
+	Module      string         `json:"module"`
 	ResourceID  string         `json:"resource_id"`
 	Level       PrivilegeLevel `json:"level"`
 	GrantedAt   time.Time      `json:"granted_at"`
 	ExpiresAt   time.Time      `json:"expires_at"`
 	GrantedBy   string         `json:"granted_by"`
 	RequestID   string         `json:"request_id"`
+	// Approvals holds the signed approvals of the request and of its extensions
+	Approvals   []ApprovalRecord `json:"approvals,omitempty"`
+	// BoundTo restricts the grant's credentials to this source network
+	BoundTo     string         `json:"bound_to,omitempty"`
+	// HandedOverFrom is the grant this one took over from
+	HandedOverFrom string      `json:"handed_over_from,omitempty"`
+	// HandedOverTo is the grant that took over from this one, which ended
+	// when it was handed over
+	HandedOverTo string        `json:"handed_over_to,omitempty"`
+	RevokedAt   *time.Time     `json:"revoked_at,omitempty"`
+	RevokedBy   string         `json:"revoked_by,omitempty"`
 	CreatedAt   time.Time      `json:"created_at"`
 	UpdatedAt   time.Time      `json:"updated_at"`
 } 
\ No newline at end of file
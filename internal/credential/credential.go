@@ -0,0 +1,264 @@
+// Package credential generates the passwords of the temporary users modules
+// create for grants. Characters are drawn from crypto/rand without bias under
+// a Policy that sets the length of passwords, the characters they may hold
+// and the kinds of character each must contain, so passwords can meet the
+// rules of the system they are set on.
+package credential
+
+import (
+	"crypto/rand"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"strings"
+)
+
+// Character classes policies draw from and may require
+const (
+	Lower  = "abcdefghijklmnopqrstuvwxyz"
+	Upper  = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+	Digits = "0123456789"
+	// Symbols leaves out quotes, backslashes, whitespace and the characters
+	// shells expand, so passwords can be pasted into statements and commands
+	Symbols = "#%+,-.:=@^_~"
+)
+
+// charsets are the named character sets a policy may use
+var charsets = map[string]string{
+	"alphanumeric": Lower + Upper + Digits,
+	"base32":       "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567",
+	"hex":          "0123456789abcdef",
+	"printable":    Lower + Upper + Digits + Symbols,
+}
+
+// classes are the kinds of character a policy may require
+var classes = map[string]func(byte) bool{
+	"lower":  func(c byte) bool { return c >= 'a' && c <= 'z' },
+	"upper":  func(c byte) bool { return c >= 'A' && c <= 'Z' },
+	"digit":  func(c byte) bool { return c >= '0' && c <= '9' },
+	"symbol": func(c byte) bool { return !isAlphanumeric(c) },
+}
+
+// MinEntropy is the fewest bits of randomness a policy may give passwords
+const MinEntropy = 80
+
+// MaxLength is the longest password a policy may ask for
+const MaxLength = 256
+
+// Policy sets how passwords are generated
+type Policy struct {
+	// Length is the number of characters of each password
+	Length int `json:"length"`
+	// Charset is the name of a character set, alphanumeric, base32, hex or
+	// printable, or the characters themselves; alphanumeric when empty
+	Charset string `json:"charset,omitempty"`
+	// Require lists the classes of character every password contains at
+	// least one of: lower, upper, digit and symbol
+	Require []string `json:"require,omitempty"`
+}
+
+// Default gives passwords of 24 letters and digits, about 142 bits, with at
+// least one lowercase letter, uppercase letter and digit each
+var Default = Policy{
+	Length:  24,
+	Charset: "alphanumeric",
+	Require: []string{"lower", "upper", "digit"},
+}
+
+// Alphabet returns the characters the policy's passwords are drawn from
+func (p Policy) Alphabet() string {
+	if p.Charset == "" {
+		return charsets["alphanumeric"]
+	}
+	if charset, ok := charsets[p.Charset]; ok {
+		return charset
+	}
+	return p.Charset
+}
+
+// Validate checks the policy can generate passwords strong enough, reporting
+// every problem found
+func (p Policy) Validate() error {
+	var errs []error
+	alphabet := p.Alphabet()
+	seen := make(map[byte]bool)
+	for i := 0; i < len(alphabet); i++ {
+		c := alphabet[i]
+		if c <= ' ' || c >= 0x7f {
+			errs = append(errs, fmt.Errorf("charset may only hold printable ASCII characters other than space"))
+			break
+		}
+		if seen[c] {
+			errs = append(errs, fmt.Errorf("charset holds %q more than once", c))
+			break
+		}
+		seen[c] = true
+	}
+	if len(alphabet) < 2 {
+		errs = append(errs, fmt.Errorf("charset must hold at least 2 characters"))
+	}
+	if p.Length <= 0 || p.Length > MaxLength {
+		errs = append(errs, fmt.Errorf("length must be between 1 and %d", MaxLength))
+	} else if bits := p.entropy(); bits < MinEntropy {
+		errs = append(errs, fmt.Errorf("%d characters from a charset of %d give %.0f bits; at least %d are required",
+			p.Length, len(alphabet), bits, MinEntropy))
+	}
+	if len(p.Require) > p.Length && p.Length > 0 {
+		errs = append(errs, fmt.Errorf("length must be at least the %d required classes", len(p.Require)))
+	}
+	for _, class := range p.Require {
+		in, ok := classes[class]
+		if !ok {
+			errs = append(errs, fmt.Errorf("unknown character class %q; use lower, upper, digit or symbol", class))
+			continue
+		}
+		if len(members(alphabet, in)) == 0 {
+			errs = append(errs, fmt.Errorf("charset holds no %s characters", class))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// entropy returns the bits of randomness of the policy's passwords, not
+// counting the small loss of requiring classes
+func (p Policy) entropy() float64 {
+	return float64(p.Length) * math.Log2(float64(len(p.Alphabet())))
+}
+
+// Generate returns a new random password following the policy
+func (p Policy) Generate() (string, error) {
+	if err := p.Validate(); err != nil {
+		return "", fmt.Errorf("invalid password policy: %v", err)
+	}
+	alphabet := p.Alphabet()
+	password := make([]byte, p.Length)
+	for i := range password {
+		c, err := pick(alphabet)
+		if err != nil {
+			return "", err
+		}
+		password[i] = c
+	}
+	// One character of each required class takes a place, then the places
+	// are shuffled so they don't give the classes away
+	for i, class := range p.Require {
+		c, err := pick(members(alphabet, classes[class]))
+		if err != nil {
+			return "", err
+		}
+		password[i] = c
+	}
+	for i := len(password) - 1; i > 0; i-- {
+		j, err := index(i + 1)
+		if err != nil {
+			return "", err
+		}
+		password[i], password[j] = password[j], password[i]
+	}
+	return string(password), nil
+}
+
+// Generate returns a new random password following the default policy
+func Generate() (string, error) {
+	return Default.Generate()
+}
+
+// Parse reads a policy from a module's YAML map of length, charset and
+// require; settings left out keep their defaults. The policy is validated,
+// reporting every problem found.
+func Parse(config map[string]interface{}) (Policy, error) {
+	policy := Default
+	var errs []error
+	if value, ok := config["length"]; ok {
+		length, ok := value.(int)
+		if !ok {
+			errs = append(errs, fmt.Errorf("length must be a number"))
+		}
+		policy.Length = length
+	}
+	if value, ok := config["charset"]; ok {
+		charset, ok := value.(string)
+		if !ok || charset == "" {
+			errs = append(errs, fmt.Errorf("charset must be alphanumeric, base32, hex, printable or a string of characters"))
+		}
+		policy.Charset = charset
+		// Classes the new charset lacks are only required when listed
+		if _, ok := config["require"]; !ok {
+			policy.Require = nil
+			for _, class := range Default.Require {
+				if len(members(policy.Alphabet(), classes[class])) > 0 {
+					policy.Require = append(policy.Require, class)
+				}
+			}
+		}
+	}
+	if value, ok := config["require"]; ok {
+		list, ok := value.([]interface{})
+		if !ok && value != nil {
+			errs = append(errs, fmt.Errorf("require must be a list of lower, upper, digit and symbol"))
+		}
+		policy.Require = nil
+		for _, item := range list {
+			class, _ := item.(string)
+			policy.Require = append(policy.Require, class)
+		}
+	}
+	if len(errs) == 0 {
+		if err := policy.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if len(errs) > 0 {
+		return Policy{}, errors.Join(errs...)
+	}
+	return policy, nil
+}
+
+// String describes the policy for logs, e.g. 24 alphanumeric characters
+func (p Policy) String() string {
+	charset := p.Charset
+	if _, named := charsets[charset]; charset == "" {
+		charset = "alphanumeric"
+	} else if !named {
+		charset = "custom"
+	}
+	description := fmt.Sprintf("%d %s characters", p.Length, charset)
+	if len(p.Require) > 0 {
+		description += " with " + strings.Join(p.Require, ", ")
+	}
+	return description
+}
+
+// members returns the characters of the alphabet in a class
+func members(alphabet string, in func(byte) bool) string {
+	var b strings.Builder
+	for i := 0; i < len(alphabet); i++ {
+		if in(alphabet[i]) {
+			b.WriteByte(alphabet[i])
+		}
+	}
+	return b.String()
+}
+
+// pick returns a random character of the set
+func pick(set string) (byte, error) {
+	i, err := index(len(set))
+	if err != nil {
+		return 0, err
+	}
+	return set[i], nil
+}
+
+// index returns a uniformly random number from 0 up to n
+func index(n int) (int, error) {
+	i, err := rand.Int(rand.Reader, big.NewInt(int64(n)))
+	if err != nil {
+		return 0, fmt.Errorf("failed to generate password: %v", err)
+	}
+	return int(i.Int64()), nil
+}
+
+func isAlphanumeric(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9'
+}
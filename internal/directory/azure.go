@@ -0,0 +1,145 @@
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGraphURL is the Microsoft Graph API
+	DefaultGraphURL = "https://graph.microsoft.com"
+	// azureLoginURL issues access tokens to Azure AD applications
+	azureLoginURL = "https://login.microsoftonline.com"
+)
+
+// azure reads groups from Azure AD through Microsoft Graph as an application
+// with the GroupMember.Read.All permission. Groups are named by display name.
+type azure struct {
+	tenantID     string
+	clientID     string
+	clientSecret string
+	baseURL      string
+	users        func(emails []string) []string
+	client       *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// newAzure creates a Microsoft Graph client for the application
+func newAzure(tenantID, clientID, clientSecret, baseURL string, users func([]string) []string) *azure {
+	if baseURL == "" {
+		baseURL = DefaultGraphURL
+	}
+	return &azure{
+		tenantID:     tenantID,
+		clientID:     clientID,
+		clientSecret: clientSecret,
+		baseURL:      strings.TrimSuffix(baseURL, "/"),
+		users:        users,
+		client:       &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Groups returns the tenant's groups with their direct and nested members
+func (a *azure) Groups(ctx context.Context) (map[string][]string, error) {
+	token, err := a.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	next := a.baseURL + "/v1.0/groups?$select=id,displayName&$top=999"
+	for next != "" {
+		var page struct {
+			Value []struct {
+				ID          string `json:"id"`
+				DisplayName string `json:"displayName"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if _, err := getJSON(ctx, a.client, next, "Bearer "+token, &page); err != nil {
+			return nil, fmt.Errorf("failed to list azure groups: %v", err)
+		}
+		for _, group := range page.Value {
+			members, err := a.members(ctx, token, group.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list members of azure group %s: %v", group.DisplayName, err)
+			}
+			groups[group.DisplayName] = members
+		}
+		next = page.NextLink
+	}
+	return groups, nil
+}
+
+// members returns the user principal names of a group's users, including
+// those of nested groups
+func (a *azure) members(ctx context.Context, token, groupID string) ([]string, error) {
+	var names []string
+	next := a.baseURL + "/v1.0/groups/" + url.PathEscape(groupID) + "/transitiveMembers/microsoft.graph.user?$select=userPrincipalName&$top=999"
+	for next != "" {
+		var page struct {
+			Value []struct {
+				UserPrincipalName string `json:"userPrincipalName"`
+			} `json:"value"`
+			NextLink string `json:"@odata.nextLink"`
+		}
+		if _, err := getJSON(ctx, a.client, next, "Bearer "+token, &page); err != nil {
+			return nil, err
+		}
+		for _, user := range page.Value {
+			names = append(names, user.UserPrincipalName)
+		}
+		next = page.NextLink
+	}
+	return a.users(names), nil
+}
+
+// accessToken returns an application access token for Microsoft Graph,
+// requesting a new one shortly before the current one expires
+func (a *azure) accessToken(ctx context.Context) (string, error) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	if a.token != "" && time.Now().Before(a.tokenExpiry.Add(-time.Minute)) {
+		return a.token, nil
+	}
+
+	form := url.Values{}
+	form.Set("grant_type", "client_credentials")
+	form.Set("client_id", a.clientID)
+	form.Set("client_secret", a.clientSecret)
+	form.Set("scope", DefaultGraphURL+"/.default")
+	tokenURL := azureLoginURL + "/" + url.PathEscape(a.tenantID) + "/oauth2/v2.0/token"
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := a.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get azure access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get azure access token: status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode azure access token: %v", err)
+	}
+	a.token = token.AccessToken
+	a.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return a.token, nil
+}
@@ -0,0 +1,241 @@
+// Package directory reads groups and their members from identity providers,
+// Google Workspace, Okta and Azure AD, so policies can refer to them by name
+package directory
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Providers
+const (
+	ProviderGoogle = "google"
+	ProviderOkta   = "okta"
+	ProviderAzure  = "azure"
+)
+
+// Source lists the groups of an identity provider with their members' user IDs
+type Source interface {
+	Groups(ctx context.Context) (map[string][]string, error)
+}
+
+// Config configures the identity provider groups are read from
+type Config struct {
+	// Provider is google, okta or azure
+	Provider string
+	// BaseURL is the Okta org URL, e.g. https://example.okta.com, or overrides
+	// the Google Admin SDK or Microsoft Graph API
+	BaseURL string
+	// Token is the Okta API token
+	Token string
+	// CredentialsFile is the key file of a Google service account with
+	// domain-wide delegation, acting as the Subject, a Workspace admin
+	CredentialsFile string
+	Subject         string
+	// Customer is the Google Workspace customer ID; my_customer when empty
+	Customer string
+	// TenantID, ClientID and ClientSecret identify the Azure AD application
+	TenantID     string
+	ClientID     string
+	ClientSecret string
+	// Groups limits the groups read to these names; all groups when empty
+	Groups []string
+	// EmailDomain is stripped from member email addresses to get user IDs;
+	// addresses in other domains are used as they are
+	EmailDomain string
+}
+
+// New creates the source of the configured provider
+func New(config Config) (Source, error) {
+	users := func(emails []string) []string {
+		return userIDs(emails, strings.TrimPrefix(config.EmailDomain, "@"))
+	}
+
+	var source Source
+	switch config.Provider {
+	case ProviderGoogle:
+		google, err := newGoogle(config.CredentialsFile, config.Subject, config.Customer, config.BaseURL, users)
+		if err != nil {
+			return nil, err
+		}
+		source = google
+	case ProviderOkta:
+		if config.Token == "" || config.BaseURL == "" {
+			return nil, fmt.Errorf("okta token and base URL are required")
+		}
+		source = newOkta(config.Token, config.BaseURL, users)
+	case ProviderAzure:
+		if config.TenantID == "" || config.ClientID == "" || config.ClientSecret == "" {
+			return nil, fmt.Errorf("azure tenant ID, client ID and client secret are required")
+		}
+		source = newAzure(config.TenantID, config.ClientID, config.ClientSecret, config.BaseURL, users)
+	default:
+		return nil, fmt.Errorf("unknown directory provider %q", config.Provider)
+	}
+
+	if len(config.Groups) > 0 {
+		source = &filtered{source: source, groups: config.Groups}
+	}
+	return source, nil
+}
+
+// filtered limits a source to the named groups
+type filtered struct {
+	source Source
+	groups []string
+}
+
+// Groups returns the named groups the source has
+func (f *filtered) Groups(ctx context.Context) (map[string][]string, error) {
+	groups, err := f.source.Groups(ctx)
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[string][]string, len(f.groups))
+	for _, name := range f.groups {
+		if members, ok := groups[name]; ok {
+			kept[name] = members
+		}
+	}
+	return kept, nil
+}
+
+// userIDs turns member email addresses into sorted, unique user IDs
+func userIDs(emails []string, domain string) []string {
+	users := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if domain != "" && strings.HasSuffix(strings.ToLower(email), "@"+strings.ToLower(domain)) {
+			email = email[:len(email)-len(domain)-1]
+		}
+		if email != "" && !slices.Contains(users, email) {
+			users = append(users, email)
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// getJSON fetches a URL with the given Authorization header and decodes the
+// JSON response, returning the response headers for pagination
+func getJSON(ctx context.Context, client *http.Client, url, authorization string, out interface{}) (http.Header, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", authorization)
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("status %d", resp.StatusCode)
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return nil, fmt.Errorf("failed to decode response: %v", err)
+	}
+	return resp.Header, nil
+}
+
+// GroupChange lists the members a group gained and lost
+type GroupChange struct {
+	Group   string   `json:"group"`
+	Added   []string `json:"added,omitempty"`
+	Removed []string `json:"removed,omitempty"`
+}
+
+// Drift is how the groups changed from one sync to the next
+type Drift struct {
+	AddedGroups   []string      `json:"added_groups,omitempty"`
+	RemovedGroups []string      `json:"removed_groups,omitempty"`
+	Changed       []GroupChange `json:"changed,omitempty"`
+}
+
+// Empty reports whether nothing changed
+func (d Drift) Empty() bool {
+	return len(d.AddedGroups) == 0 && len(d.RemovedGroups) == 0 && len(d.Changed) == 0
+}
+
+// Compare returns the drift from the previous groups to the current ones,
+// ordered by group name
+func Compare(previous, current map[string][]string) Drift {
+	var drift Drift
+	for name, members := range current {
+		old, ok := previous[name]
+		if !ok {
+			drift.AddedGroups = append(drift.AddedGroups, name)
+			continue
+		}
+		change := GroupChange{Group: name, Added: missing(members, old), Removed: missing(old, members)}
+		if len(change.Added) > 0 || len(change.Removed) > 0 {
+			drift.Changed = append(drift.Changed, change)
+		}
+	}
+	for name := range previous {
+		if _, ok := current[name]; !ok {
+			drift.RemovedGroups = append(drift.RemovedGroups, name)
+		}
+	}
+	sort.Strings(drift.AddedGroups)
+	sort.Strings(drift.RemovedGroups)
+	sort.Slice(drift.Changed, func(i, j int) bool {
+		return drift.Changed[i].Group < drift.Changed[j].Group
+	})
+	return drift
+}
+
+// missing returns the values of a that b lacks, sorted
+func missing(a, b []string) []string {
+	var values []string
+	for _, value := range a {
+		if !slices.Contains(b, value) {
+			values = append(values, value)
+		}
+	}
+	sort.Strings(values)
+	return values
+}
+
+// Groups holds the groups last synced, for policies to resolve group names
+// with. It is safe for concurrent use.
+type Groups struct {
+	mu       sync.RWMutex
+	members  map[string][]string
+	syncedAt time.Time
+}
+
+// NewGroups creates an empty set of groups
+func NewGroups() *Groups {
+	return &Groups{members: make(map[string][]string)}
+}
+
+// Set replaces the groups with those synced at the given time
+func (g *Groups) Set(members map[string][]string, syncedAt time.Time) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.members, g.syncedAt = members, syncedAt
+}
+
+// GroupMembers returns the members of the named group and whether it exists
+func (g *Groups) GroupMembers(name string) ([]string, bool) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	members, ok := g.members[name]
+	return members, ok
+}
+
+// Snapshot returns the groups and when they were synced
+func (g *Groups) Snapshot() (map[string][]string, time.Time) {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	return g.members, g.syncedAt
+}
@@ -0,0 +1,234 @@
+package directory
+
+import (
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultGoogleURL is the Google Admin SDK API
+	DefaultGoogleURL = "https://admin.googleapis.com"
+	// googleScope allows reading the groups of the Workspace
+	googleScope = "https://www.googleapis.com/auth/admin.directory.group.readonly"
+)
+
+// google reads groups from Google Workspace as a service account with
+// domain-wide delegation. Groups are named by their email address.
+type google struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURL string
+	subject  string
+	customer string
+	baseURL  string
+	users    func(emails []string) []string
+	client   *http.Client
+
+	mu          sync.Mutex
+	token       string
+	tokenExpiry time.Time
+}
+
+// newGoogle creates a Google Workspace client from a service account key file
+func newGoogle(credentialsFile, subject, customer, baseURL string, users func([]string) []string) (*google, error) {
+	if credentialsFile == "" || subject == "" {
+		return nil, fmt.Errorf("google credentials file and subject are required")
+	}
+	data, err := os.ReadFile(credentialsFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read google credentials: %v", err)
+	}
+	var credentials struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &credentials); err != nil {
+		return nil, fmt.Errorf("failed to parse google credentials: %v", err)
+	}
+	block, _ := pem.Decode([]byte(credentials.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("google credentials hold no private key")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse google private key: %v", err)
+	}
+	key, ok := parsed.(*rsa.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("google private key is not an RSA key")
+	}
+	if credentials.TokenURI == "" {
+		credentials.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	if customer == "" {
+		customer = "my_customer"
+	}
+	if baseURL == "" {
+		baseURL = DefaultGoogleURL
+	}
+	return &google{
+		email:    credentials.ClientEmail,
+		key:      key,
+		tokenURL: credentials.TokenURI,
+		subject:  subject,
+		customer: customer,
+		baseURL:  strings.TrimSuffix(baseURL, "/"),
+		users:    users,
+		client:   &http.Client{Timeout: 10 * time.Second},
+	}, nil
+}
+
+// Groups returns the Workspace's groups with their direct and nested members
+func (g *google) Groups(ctx context.Context) (map[string][]string, error) {
+	token, err := g.accessToken(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	groups := make(map[string][]string)
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("customer", g.customer)
+		query.Set("maxResults", "200")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Groups []struct {
+				Email string `json:"email"`
+			} `json:"groups"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if _, err := getJSON(ctx, g.client, g.baseURL+"/admin/directory/v1/groups?"+query.Encode(), "Bearer "+token, &page); err != nil {
+			return nil, fmt.Errorf("failed to list google groups: %v", err)
+		}
+		for _, group := range page.Groups {
+			members, err := g.members(ctx, token, group.Email)
+			if err != nil {
+				return nil, err
+			}
+			groups[group.Email] = members
+		}
+		if page.NextPageToken == "" {
+			return groups, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// members returns the users in a group, including those of nested groups
+func (g *google) members(ctx context.Context, token, group string) ([]string, error) {
+	var emails []string
+	pageToken := ""
+	for {
+		query := url.Values{}
+		query.Set("includeDerivedMembership", "true")
+		query.Set("maxResults", "200")
+		if pageToken != "" {
+			query.Set("pageToken", pageToken)
+		}
+		var page struct {
+			Members []struct {
+				Email string `json:"email"`
+				Type  string `json:"type"`
+			} `json:"members"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		if _, err := getJSON(ctx, g.client, g.baseURL+"/admin/directory/v1/groups/"+url.PathEscape(group)+"/members?"+query.Encode(), "Bearer "+token, &page); err != nil {
+			return nil, fmt.Errorf("failed to list members of google group %s: %v", group, err)
+		}
+		for _, member := range page.Members {
+			if member.Type == "USER" {
+				emails = append(emails, member.Email)
+			}
+		}
+		if page.NextPageToken == "" {
+			return g.users(emails), nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// accessToken returns an access token for the subject, exchanging a signed
+// assertion for a new one shortly before the current one expires
+func (g *google) accessToken(ctx context.Context) (string, error) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.token != "" && time.Now().Before(g.tokenExpiry.Add(-time.Minute)) {
+		return g.token, nil
+	}
+
+	assertion, err := g.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{}
+	form.Set("grant_type", "urn:ietf:params:oauth:grant-type:jwt-bearer")
+	form.Set("assertion", assertion)
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, g.tokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", fmt.Errorf("failed to create token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := g.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("failed to get google access token: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to get google access token: status %d", resp.StatusCode)
+	}
+	var token struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&token); err != nil {
+		return "", fmt.Errorf("failed to decode google access token: %v", err)
+	}
+	g.token = token.AccessToken
+	g.tokenExpiry = time.Now().Add(time.Duration(token.ExpiresIn) * time.Second)
+	return g.token, nil
+}
+
+// assertion returns the JWT, signed with the service account's key, that
+// asks for an access token acting as the subject
+func (g *google) assertion(now time.Time) (string, error) {
+	header, err := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   g.email,
+		"sub":   g.subject,
+		"scope": googleScope,
+		"aud":   g.tokenURL,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(nil, g.key, crypto.SHA256, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("failed to sign google assertion: %v", err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,91 @@
+package directory
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// nextLink finds the next page in a Link header
+var nextLink = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// okta reads groups from an Okta org. Groups are named by their profile name.
+type okta struct {
+	token   string
+	baseURL string
+	users   func(emails []string) []string
+	client  *http.Client
+}
+
+// newOkta creates an Okta client for the org at baseURL
+func newOkta(token, baseURL string, users func([]string) []string) *okta {
+	return &okta{
+		token:   token,
+		baseURL: strings.TrimSuffix(baseURL, "/"),
+		users:   users,
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// Groups returns the org's groups with their members' logins
+func (o *okta) Groups(ctx context.Context) (map[string][]string, error) {
+	groups := make(map[string][]string)
+	next := o.baseURL + "/api/v1/groups?limit=200"
+	for next != "" {
+		var page []struct {
+			ID      string `json:"id"`
+			Profile struct {
+				Name string `json:"name"`
+			} `json:"profile"`
+		}
+		header, err := getJSON(ctx, o.client, next, "SSWS "+o.token, &page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to list okta groups: %v", err)
+		}
+		for _, group := range page {
+			members, err := o.members(ctx, group.ID)
+			if err != nil {
+				return nil, fmt.Errorf("failed to list members of okta group %s: %v", group.Profile.Name, err)
+			}
+			groups[group.Profile.Name] = members
+		}
+		next = nextPage(header)
+	}
+	return groups, nil
+}
+
+// members returns the logins of a group's users
+func (o *okta) members(ctx context.Context, groupID string) ([]string, error) {
+	var logins []string
+	next := o.baseURL + "/api/v1/groups/" + url.PathEscape(groupID) + "/users?limit=200"
+	for next != "" {
+		var page []struct {
+			Profile struct {
+				Login string `json:"login"`
+			} `json:"profile"`
+		}
+		header, err := getJSON(ctx, o.client, next, "SSWS "+o.token, &page)
+		if err != nil {
+			return nil, err
+		}
+		for _, user := range page {
+			logins = append(logins, user.Profile.Login)
+		}
+		next = nextPage(header)
+	}
+	return o.users(logins), nil
+}
+
+// nextPage returns the URL of the next page from the Link headers, if any
+func nextPage(header http.Header) string {
+	for _, link := range header.Values("Link") {
+		if match := nextLink.FindStringSubmatch(link); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
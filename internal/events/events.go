@@ -0,0 +1,198 @@
+// Package events is the contract of the grant lifecycle events Apollo
+// publishes to webhooks and the message bus: their types, the typed payload of
+// each and the JSON schemas describing them
+package events
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"time"
+)
+
+// Event types
+const (
+	Requested   = "requested"
+	Approved    = "approved"
+	Denied      = "denied"
+	Provisioned = "provisioned"
+	Extended    = "extended"
+	Revoked     = "revoked"
+	Expired     = "expired"
+	Closed      = "closed"
+	Lapsed      = "lapsed"
+	Expiring    = "expiring"
+	HandedOver  = "handed_over"
+)
+
+// Envelope is how an event is delivered. Data holds the payload of the
+// event's type, in the version given by SchemaVersion.
+type Envelope struct {
+	ID            string `json:"id"`
+	Type          string `json:"type"`
+	SchemaVersion int    `json:"schema_version"`
+	RequestID     string `json:"request_id"`
+	// Version orders the events of a request
+	Version int         `json:"version"`
+	Actor   string      `json:"actor,omitempty"`
+	Time    time.Time   `json:"time"`
+	Data    interface{} `json:"data"`
+}
+
+// RequestCreated is the payload of requested events
+type RequestCreated struct {
+	UserID     string `json:"user_id"`
+	Module     string `json:"module"`
+	ResourceID string `json:"resource_id"`
+	Level      string `json:"level"`
+	Reason     string `json:"reason"`
+	SourceIP   string `json:"source_ip,omitempty"`
+	// ExpiresAt is when the requested access would end
+	ExpiresAt         time.Time `json:"expires_at"`
+	RequiredApprovals int       `json:"required_approvals"`
+	Approvers         []string  `json:"approvers,omitempty"`
+	ApprovalRoute     string    `json:"approval_route,omitempty"`
+	PolicyVersion     int       `json:"policy_version,omitempty"`
+	RiskScore         int       `json:"risk_score"`
+	RiskFactors       []string  `json:"risk_factors,omitempty"`
+	// ExtendsGrant is the grant a request to extend access extends
+	ExtendsGrant string `json:"extends_grant,omitempty"`
+	// HandsOverGrant is the grant a handover request takes over for the user
+	HandsOverGrant string `json:"hands_over_grant,omitempty"`
+	// HandedOverBy asked for the grant to be handed over
+	HandedOverBy string `json:"handed_over_by,omitempty"`
+}
+
+// Grant identifies the grant an event is about and when it expires
+type Grant struct {
+	GrantID   string    `json:"grant_id"`
+	ExpiresAt time.Time `json:"expires_at"`
+}
+
+// RequestApproved is the payload of approved events; the actor approved it,
+// none when the policy did
+type RequestApproved struct {
+	Grant
+	// Module, ResourceID, UserID and Level describe the granted access, for
+	// the operators provisioning it
+	Module     string `json:"module,omitempty"`
+	ResourceID string `json:"resource_id,omitempty"`
+	UserID     string `json:"user_id,omitempty"`
+	Level      string `json:"level,omitempty"`
+	// BoundTo restricts the grant's credentials to this source network, for
+	// the operators whose targets can enforce it
+	BoundTo string `json:"bound_to,omitempty"`
+}
+
+// RequestDenied is the payload of denied events; the actor denied it
+type RequestDenied struct{}
+
+// RequestClosed is the payload of closed events
+type RequestClosed struct{}
+
+// RequestLapsed is the payload of lapsed events, recorded when a request
+// expires without a decision
+type RequestLapsed struct{}
+
+// GrantProvisioned is the payload of provisioned events
+type GrantProvisioned struct {
+	Grant
+}
+
+// GrantExtended is the payload of extended events, sent on the stream of the
+// extended grant's request
+type GrantExtended struct {
+	Grant
+	// ExtensionID is the approved request that extended the grant
+	ExtensionID string `json:"extension_id"`
+}
+
+// GrantRevoked is the payload of revoked events; the actor revoked it
+type GrantRevoked struct {
+	Grant
+}
+
+// GrantExpired is the payload of expired events
+type GrantExpired struct {
+	Grant
+}
+
+// GrantHandedOver is the payload of handed_over events, sent on the stream of
+// the request of the grant that was handed over; the actor asked for it
+type GrantHandedOver struct {
+	Grant
+	// HandoverID is the approved request that took the grant over
+	HandoverID string `json:"handover_id"`
+	// ToGrantID is the new holder's grant
+	ToGrantID string `json:"to_grant_id"`
+	// ToUserID is the new holder
+	ToUserID string `json:"to_user_id"`
+}
+
+// GrantExpiring is the payload of expiring events, sent once ahead of a
+// grant's expiry
+type GrantExpiring struct {
+	Grant
+}
+
+// Schema describes the payload of an event type in one version
+type Schema struct {
+	Type        string `json:"type"`
+	Version     int    `json:"version"`
+	Name        string `json:"name"`
+	Description string `json:"description"`
+	payload     reflect.Type
+}
+
+// catalog lists the current schema of every event type. A payload only gains
+// optional fields within a version; any other change is a new version.
+var catalog = []Schema{
+	newSchema(Requested, 1, RequestCreated{}, "A user requested access to a resource"),
+	newSchema(Approved, 1, RequestApproved{}, "A request was approved and its grant created"),
+	newSchema(Denied, 1, RequestDenied{}, "A request was denied"),
+	newSchema(Provisioned, 1, GrantProvisioned{}, "An operator provisioned a grant's access"),
+	newSchema(Extended, 1, GrantExtended{}, "An approved extension moved a grant's expiry"),
+	newSchema(Revoked, 1, GrantRevoked{}, "A grant was revoked before it expired"),
+	newSchema(Expired, 1, GrantExpired{}, "A grant expired"),
+	newSchema(Closed, 1, RequestClosed{}, "A request was closed without a decision"),
+	newSchema(Lapsed, 1, RequestLapsed{}, "A request expired without a decision"),
+	newSchema(Expiring, 1, GrantExpiring{}, "A grant is about to expire"),
+	newSchema(HandedOver, 1, GrantHandedOver{}, "A grant's remaining time was handed over to another user"),
+}
+
+// newSchema returns the schema of an event type's payload
+func newSchema(eventType string, version int, payload interface{}, description string) Schema {
+	t := reflect.TypeOf(payload)
+	return Schema{Type: eventType, Version: version, Name: t.Name(), Description: description, payload: t}
+}
+
+// Schemas returns the schemas of all event types
+func Schemas() []Schema {
+	return append([]Schema(nil), catalog...)
+}
+
+// Lookup returns the schema of an event type
+func Lookup(eventType string) (Schema, bool) {
+	for _, schema := range catalog {
+		if schema.Type == eventType {
+			return schema, true
+		}
+	}
+	return Schema{}, false
+}
+
+// Decode decodes the data of an event of the given type into its payload,
+// returned as a pointer such as *RequestCreated
+func Decode(eventType string, data json.RawMessage) (interface{}, error) {
+	schema, ok := Lookup(eventType)
+	if !ok {
+		return nil, fmt.Errorf("unknown event type %q", eventType)
+	}
+	payload := reflect.New(schema.payload).Interface()
+	if len(data) > 0 && string(data) != "null" {
+		if err := json.Unmarshal(data, payload); err != nil {
+			return nil, fmt.Errorf("failed to decode %s event: %v", eventType, err)
+		}
+	}
+	return payload, nil
+}
@@ -0,0 +1,23 @@
+package events
+
+import (
+	"reflect"
+
+	"github.com/petermein/apollo/internal/jsonschema"
+)
+
+// JSONSchema returns the JSON schema of an envelope carrying the event type's
+// payload. Objects don't forbid additional properties, so consumers keep
+// validating when a version gains an optional field.
+func (s Schema) JSONSchema() map[string]interface{} {
+	envelope := jsonschema.For(reflect.TypeOf(Envelope{}))
+	properties := envelope["properties"].(map[string]interface{})
+	properties["type"] = map[string]interface{}{"const": s.Type}
+	properties["schema_version"] = map[string]interface{}{"const": s.Version}
+	properties["data"] = jsonschema.For(s.payload)
+
+	envelope["$schema"] = jsonschema.Dialect
+	envelope["title"] = s.Name
+	envelope["description"] = s.Description
+	return envelope
+}
@@ -0,0 +1,86 @@
+// Package jsonschema derives JSON schemas from Go types, as encoding/json
+// encodes their values, and validates decoded JSON against them. It supports
+// the part of JSON Schema the schemas it derives use.
+package jsonschema
+
+import (
+	"encoding/json"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Dialect is the JSON Schema version the schemas are written in
+const Dialect = "https://json-schema.org/draft/2020-12/schema"
+
+// Schema is a JSON schema
+type Schema = map[string]interface{}
+
+// For returns the JSON schema of the values of a Go type as encoding/json
+// encodes them. The fields of structs are required unless tagged omitempty.
+func For(t reflect.Type) Schema {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return Schema{"type": "string", "format": "date-time"}
+	case reflect.TypeOf(json.RawMessage{}):
+		// Raw JSON holds any value
+		return Schema{}
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return Schema{"type": "string"}
+	case reflect.Bool:
+		return Schema{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return Schema{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return Schema{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			// Bytes are encoded as base64
+			return Schema{"type": "string", "contentEncoding": "base64"}
+		}
+		return Schema{"type": "array", "items": For(t.Elem())}
+	case reflect.Map:
+		return Schema{"type": "object", "additionalProperties": For(t.Elem())}
+	case reflect.Struct:
+		properties := make(Schema)
+		required := []string{}
+		addFields(t, properties, &required)
+		return Schema{"type": "object", "properties": properties, "required": required}
+	default:
+		// interface{} holds any value
+		return Schema{}
+	}
+}
+
+// addFields adds the schemas of a struct's encoded fields to properties,
+// flattening embedded structs like encoding/json does
+func addFields(t reflect.Type, properties Schema, required *[]string) {
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if field.Anonymous && name == "" && field.Type.Kind() == reflect.Struct {
+			addFields(field.Type, properties, required)
+			continue
+		}
+		if !field.IsExported() {
+			continue
+		}
+		if name == "" {
+			name = field.Name
+		}
+		properties[name] = For(field.Type)
+		if !strings.Contains(","+options+",", ",omitempty,") {
+			*required = append(*required, name)
+		}
+	}
+}
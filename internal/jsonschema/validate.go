@@ -0,0 +1,136 @@
+package jsonschema
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// Error is a value that doesn't match its schema
+type Error struct {
+	// Field is the path to the value, like scopes[0].action; empty for the
+	// whole document
+	Field   string `json:"field,omitempty"`
+	Message string `json:"message"`
+}
+
+func (e Error) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Validate returns every way a value decoded with json.Decoder.UseNumber
+// doesn't match the schema: an object's missing fields first, then its other
+// fields by name. Null is taken for a missing value, as encoding/json does, so
+// it only fails required fields. Properties the schema doesn't name are
+// allowed.
+func Validate(schema Schema, value interface{}) []Error {
+	var errs []Error
+	validate(schema, value, "", &errs)
+	return errs
+}
+
+func validate(schema Schema, value interface{}, field string, errs *[]Error) {
+	fail := func(format string, args ...interface{}) {
+		*errs = append(*errs, Error{Field: field, Message: fmt.Sprintf(format, args...)})
+	}
+	if value == nil {
+		return
+	}
+	switch schema["type"] {
+	case "string":
+		s, ok := value.(string)
+		if !ok {
+			fail("must be a string, not %s", kind(value))
+			return
+		}
+		if schema["format"] == "date-time" {
+			if _, err := time.Parse(time.RFC3339, s); err != nil {
+				fail("must be a date and time like 2006-01-02T15:04:05Z")
+			}
+		}
+	case "boolean":
+		if _, ok := value.(bool); !ok {
+			fail("must be true or false, not %s", kind(value))
+		}
+	case "integer":
+		n, ok := value.(json.Number)
+		if !ok {
+			fail("must be an integer, not %s", kind(value))
+			return
+		}
+		if _, err := strconv.ParseInt(n.String(), 10, 64); err != nil {
+			fail("must be an integer, not %s", n)
+		}
+	case "number":
+		if _, ok := value.(json.Number); !ok {
+			fail("must be a number, not %s", kind(value))
+		}
+	case "array":
+		items, ok := value.([]interface{})
+		if !ok {
+			fail("must be an array, not %s", kind(value))
+			return
+		}
+		if itemSchema, ok := schema["items"].(Schema); ok {
+			for i, item := range items {
+				validate(itemSchema, item, fmt.Sprintf("%s[%d]", field, i), errs)
+			}
+		}
+	case "object":
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			fail("must be an object, not %s", kind(value))
+			return
+		}
+		required, _ := schema["required"].([]string)
+		for _, name := range required {
+			if object[name] == nil {
+				*errs = append(*errs, Error{Field: join(field, name), Message: "is required"})
+			}
+		}
+		properties, _ := schema["properties"].(Schema)
+		additional, _ := schema["additionalProperties"].(Schema)
+		names := make([]string, 0, len(object))
+		for name := range object {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			if propertySchema, ok := properties[name].(Schema); ok {
+				validate(propertySchema, object[name], join(field, name), errs)
+			} else if additional != nil {
+				validate(additional, object[name], join(field, name), errs)
+			}
+		}
+	}
+}
+
+// join returns the path to a property of the value at field
+func join(field, name string) string {
+	if field == "" {
+		return name
+	}
+	return field + "." + name
+}
+
+// kind names the JSON type of a decoded value
+func kind(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return "a string"
+	case bool:
+		return "a boolean"
+	case json.Number:
+		return "a number"
+	case []interface{}:
+		return "an array"
+	case map[string]interface{}:
+		return "an object"
+	}
+	return "null"
+}
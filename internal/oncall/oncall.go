@@ -0,0 +1,137 @@
+// Package oncall reads who is currently on call for a service from PagerDuty
+// or Opsgenie schedules
+package oncall
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Providers
+const (
+	ProviderPagerDuty = "pagerduty"
+	ProviderOpsgenie  = "opsgenie"
+)
+
+// Schedule returns the users currently on call for a service
+type Schedule interface {
+	OnCall(ctx context.Context, service string) ([]string, error)
+}
+
+// Config configures an on-call schedule
+type Config struct {
+	// Provider is pagerduty or opsgenie
+	Provider string
+	// Token is the PagerDuty REST API key or the Opsgenie API key
+	Token string
+	// BaseURL overrides the provider's API, e.g. https://api.eu.opsgenie.com
+	BaseURL string
+	// Schedules maps services to the schedule on call for them: a PagerDuty
+	// schedule ID or an Opsgenie schedule name. Services without a schedule
+	// have no one on call.
+	Schedules map[string]string
+	// EmailDomain is stripped from on-call email addresses to get user IDs;
+	// addresses in other domains are used as they are
+	EmailDomain string
+	// CacheTTL is how long on-call users are cached; one minute when zero
+	CacheTTL time.Duration
+}
+
+// New creates the schedule of the configured provider, caching its answers
+func New(config Config) (*Cache, error) {
+	if config.Token == "" {
+		return nil, fmt.Errorf("%s token is required", config.Provider)
+	}
+	users := func(emails []string) []string {
+		return userIDs(emails, strings.TrimPrefix(config.EmailDomain, "@"))
+	}
+
+	var schedule Schedule
+	switch config.Provider {
+	case ProviderPagerDuty:
+		schedule = newPagerDuty(config.Token, config.BaseURL, config.Schedules, users)
+	case ProviderOpsgenie:
+		schedule = newOpsgenie(config.Token, config.BaseURL, config.Schedules, users)
+	default:
+		return nil, fmt.Errorf("unknown on-call provider %q", config.Provider)
+	}
+
+	ttl := config.CacheTTL
+	if ttl == 0 {
+		ttl = time.Minute
+	}
+	return NewCache(schedule, ttl), nil
+}
+
+// userIDs turns on-call email addresses into user IDs
+func userIDs(emails []string, domain string) []string {
+	users := make([]string, 0, len(emails))
+	for _, email := range emails {
+		if domain != "" && strings.HasSuffix(strings.ToLower(email), "@"+strings.ToLower(domain)) {
+			email = email[:len(email)-len(domain)-1]
+		}
+		users = append(users, email)
+	}
+	return users
+}
+
+// Cache caches the on-call users of a schedule. Every request evaluation and
+// Slack message asks who is on call, which the providers rate limit.
+type Cache struct {
+	schedule Schedule
+	ttl      time.Duration
+
+	mu      sync.Mutex
+	entries map[string]cacheEntry
+}
+
+// cacheEntry holds the on-call users of a service
+type cacheEntry struct {
+	users     []string
+	fetchedAt time.Time
+}
+
+// NewCache creates a cache keeping the on-call users of the schedule for ttl
+func NewCache(schedule Schedule, ttl time.Duration) *Cache {
+	return &Cache{
+		schedule: schedule,
+		ttl:      ttl,
+		entries:  make(map[string]cacheEntry),
+	}
+}
+
+// OnCall returns the users currently on call for a service
+func (c *Cache) OnCall(ctx context.Context, service string) ([]string, error) {
+	c.mu.Lock()
+	entry, ok := c.entries[service]
+	c.mu.Unlock()
+	if ok && time.Since(entry.fetchedAt) < c.ttl {
+		return entry.users, nil
+	}
+
+	users, err := c.schedule.OnCall(ctx, service)
+	if err != nil {
+		return nil, err
+	}
+	c.mu.Lock()
+	c.entries[service] = cacheEntry{users: users, fetchedAt: time.Now()}
+	c.mu.Unlock()
+	return users, nil
+}
+
+// IsOnCall reports whether the user is currently on call for a service
+func (c *Cache) IsOnCall(ctx context.Context, userID, service string) (bool, error) {
+	users, err := c.OnCall(ctx, service)
+	if err != nil {
+		return false, err
+	}
+	for _, user := range users {
+		if strings.EqualFold(user, userID) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
@@ -0,0 +1,75 @@
+package oncall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultOpsgenieURL is Opsgenie's API in the US region
+const DefaultOpsgenieURL = "https://api.opsgenie.com"
+
+// opsgenie reads on-call users from Opsgenie schedules
+type opsgenie struct {
+	token     string
+	baseURL   string
+	schedules map[string]string
+	users     func(emails []string) []string
+	client    *http.Client
+}
+
+// newOpsgenie creates an Opsgenie schedule client
+func newOpsgenie(token, baseURL string, schedules map[string]string, users func([]string) []string) *opsgenie {
+	if baseURL == "" {
+		baseURL = DefaultOpsgenieURL
+	}
+	return &opsgenie{
+		token:     token,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		schedules: schedules,
+		users:     users,
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// OnCall returns the users on call in the service's schedule. Opsgenie names
+// users by their email address.
+func (o *opsgenie) OnCall(ctx context.Context, service string) ([]string, error) {
+	schedule, ok := o.schedules[service]
+	if !ok {
+		return nil, nil
+	}
+
+	query := url.Values{}
+	query.Set("scheduleIdentifierType", "name")
+	query.Set("flat", "true")
+	endpoint := o.baseURL + "/v2/schedules/" + url.PathEscape(schedule) + "/on-calls?" + query.Encode()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "GenieKey "+o.token)
+
+	resp, err := o.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Opsgenie: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query Opsgenie schedule %s: status %d", schedule, resp.StatusCode)
+	}
+
+	var result struct {
+		Data struct {
+			OnCallRecipients []string `json:"onCallRecipients"`
+		} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode Opsgenie response: %v", err)
+	}
+	return o.users(result.Data.OnCallRecipients), nil
+}
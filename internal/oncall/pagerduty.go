@@ -0,0 +1,85 @@
+package oncall
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+)
+
+// DefaultPagerDutyURL is PagerDuty's REST API
+const DefaultPagerDutyURL = "https://api.pagerduty.com"
+
+// pagerDuty reads on-call users from PagerDuty schedules
+type pagerDuty struct {
+	token     string
+	baseURL   string
+	schedules map[string]string
+	users     func(emails []string) []string
+	client    *http.Client
+}
+
+// newPagerDuty creates a PagerDuty schedule client
+func newPagerDuty(token, baseURL string, schedules map[string]string, users func([]string) []string) *pagerDuty {
+	if baseURL == "" {
+		baseURL = DefaultPagerDutyURL
+	}
+	return &pagerDuty{
+		token:     token,
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		schedules: schedules,
+		users:     users,
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// OnCall returns the users on the first escalation level of the service's schedule
+func (p *pagerDuty) OnCall(ctx context.Context, service string) ([]string, error) {
+	schedule, ok := p.schedules[service]
+	if !ok {
+		return nil, nil
+	}
+
+	query := url.Values{}
+	query.Set("schedule_ids[]", schedule)
+	query.Set("include[]", "users")
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, p.baseURL+"/oncalls?"+query.Encode(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Authorization", "Token token="+p.token)
+	req.Header.Set("Accept", "application/vnd.pagerduty+json;version=2")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query PagerDuty: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("failed to query PagerDuty schedule %s: status %d", schedule, resp.StatusCode)
+	}
+
+	var result struct {
+		OnCalls []struct {
+			EscalationLevel int `json:"escalation_level"`
+			User            struct {
+				Email string `json:"email"`
+			} `json:"user"`
+		} `json:"oncalls"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to decode PagerDuty response: %v", err)
+	}
+
+	var emails []string
+	for _, onCall := range result.OnCalls {
+		if onCall.EscalationLevel == 1 && onCall.User.Email != "" && !slices.Contains(emails, onCall.User.Email) {
+			emails = append(emails, onCall.User.Email)
+		}
+	}
+	return p.users(emails), nil
+}
@@ -0,0 +1,37 @@
+// Package operatorrpc is the gRPC service operators receive jobs, report their
+// results and send heartbeats over, a typed streaming channel in place of
+// polling the HTTP API. The service and its messages are defined in
+// operator.proto; regenerate operator.pb.go and operator_grpc.pb.go with
+// make proto after changing it.
+package operatorrpc
+
+import (
+	"context"
+	"fmt"
+)
+
+// Authorization is the metadata key operators send their operator token in,
+// as Bearer <token>
+const Authorization = "authorization"
+
+// TokenCredentials sends the operator token returned by token with every
+// call. Insecure allows sending it without TLS, e.g. to a sidecar or to a
+// proxy on the same network that terminates TLS.
+type TokenCredentials struct {
+	Token    func() string
+	Insecure bool
+}
+
+// GetRequestMetadata returns the authorization metadata of a call
+func (c TokenCredentials) GetRequestMetadata(ctx context.Context, uri ...string) (map[string]string, error) {
+	token := c.Token()
+	if token == "" {
+		return nil, fmt.Errorf("no operator token; register with the API first")
+	}
+	return map[string]string{Authorization: "Bearer " + token}, nil
+}
+
+// RequireTransportSecurity reports whether the token may only be sent over TLS
+func (c TokenCredentials) RequireTransportSecurity() bool {
+	return !c.Insecure
+}
@@ -3,16 +3,21 @@ package kubernetes
 import (
 	"context"
 	"fmt"
+	"strings"
 	"time"
 
 	"path/filepath"
 
+	rbacv1 "k8s.io/api/rbac/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/tools/clientcmd"
 	"k8s.io/client-go/util/homedir"
 
 	"github.com/petermein/apollo/internal/operators"
+	"github.com/petermein/apollo/internal/redact"
 )
 
 // Config represents the Kubernetes module configuration
@@ -24,6 +29,19 @@ type Config struct {
 	RolePrefix string `json:"role_prefix"`
 }
 
+// Labels and annotations of the roles and role bindings of grants
+const (
+	// grantLabel holds the grant ID, so the objects of a grant are found
+	// again to revoke it
+	grantLabel = "apollo.io/grant-id"
+	// managedByLabel marks the objects as Apollo's
+	managedByLabel = "app.kubernetes.io/managed-by"
+	// userAnnotation and expiresAnnotation record who holds the grant and
+	// until when, for people looking at the cluster
+	userAnnotation    = "apollo.io/user"
+	expiresAnnotation = "apollo.io/expires-at"
+)
+
 // Module implements the Kubernetes privilege management module
 type Module struct {
 	config *Config
@@ -114,21 +132,72 @@ func (m *Module) Initialize(ctx context.Context, config interface{}) error {
 
 // HandlePrivilegeRequest handles a Kubernetes privilege escalation request
 func (m *Module) HandlePrivilegeRequest(ctx context.Context, request *operators.PrivilegeRequest) error {
+	steps, err := m.ProvisionSteps(request)
+	if err != nil {
+		return err
+	}
+	return operators.RunSaga(ctx, steps...)
+}
+
+// ProvisionSteps creates a role with the rules of the requested cluster role,
+// binds it to the user and leaves the grant on the request's metadata
+func (m *Module) ProvisionSteps(request *operators.PrivilegeRequest) ([]operators.Step, error) {
 	// Parse the privilege level
 	role, err := parseRole(request.Level)
 	if err != nil {
-		return fmt.Errorf("invalid privilege level: %v", err)
+		return nil, fmt.Errorf("invalid privilege level: %v", err)
 	}
 
-	// Create role name
-	roleName := fmt.Sprintf("%s-%s-%s", m.config.RolePrefix, request.UserID, request.ID)
+	// The grant ID labels the objects, so it must be a valid label value
+	if errs := validation.IsValidLabelValue(request.ID); request.ID == "" || len(errs) > 0 {
+		return nil, fmt.Errorf("invalid grant ID %q: %s", request.ID, strings.Join(errs, "; "))
+	}
 
-	// Create role and role binding
-	if err := m.createRoleAndBinding(ctx, roleName, role, request.UserID); err != nil {
-		return fmt.Errorf("failed to create role and binding: %v", err)
+	// Create role name
+	roleName := fmt.Sprintf("%s-%s-%s", strings.TrimSuffix(m.config.RolePrefix, "-"), request.UserID, request.ID)
+	meta := metav1.ObjectMeta{
+		Name:      roleName,
+		Namespace: m.config.Namespace,
+		Labels:    map[string]string{grantLabel: request.ID, managedByLabel: "apollo"},
+		Annotations: map[string]string{
+			userAnnotation:    request.UserID,
+			expiresAnnotation: time.Now().Add(parseDuration(request.Duration)).UTC().Format(time.RFC3339),
+		},
 	}
+	roles := m.client.RbacV1().Roles(m.config.Namespace)
+	bindings := m.client.RbacV1().RoleBindings(m.config.Namespace)
+
+	return []operators.Step{
+		{
+			Name: "create role",
+			Do: func(ctx context.Context) error {
+				return m.createRole(ctx, meta, role)
+			},
+			Compensate: func(ctx context.Context) error {
+				return ignoreNotFound(roles.Delete(ctx, roleName, metav1.DeleteOptions{}))
+			},
+		},
+		{
+			Name: "create role binding",
+			Do: func(ctx context.Context) error {
+				return m.createRoleBinding(ctx, meta, request.UserID)
+			},
+			Compensate: func(ctx context.Context) error {
+				return ignoreNotFound(bindings.Delete(ctx, roleName, metav1.DeleteOptions{}))
+			},
+		},
+		{
+			Name: "store grant metadata",
+			Do: func(ctx context.Context) error {
+				storeGrant(request, roleName, role)
+				return nil
+			},
+		},
+	}, nil
+}
 
-	// Store the grant information
+// storeGrant leaves the grant on the request's metadata for later revocation
+func storeGrant(request *operators.PrivilegeRequest, roleName, role string) {
 	grant := struct {
 		ID        string    `json:"id"`
 		RoleName  string    `json:"role_name"`
@@ -142,20 +211,45 @@ func (m *Module) HandlePrivilegeRequest(ctx context.Context, request *operators.
 	}
 
 	// Store in metadata for later revocation
-	request.Metadata = map[string]interface{}{
+	request.Metadata = redact.Map(map[string]interface{}{
 		"grant": grant,
-	}
-
-	return nil
+	})
 }
 
-// RevokePrivilege revokes Kubernetes privileges
+// RevokePrivilege deletes the role bindings and roles labelled with the
+// grant's ID, bindings first so the user loses access before the rules go.
+// Revoking a grant that was revoked already does nothing.
 func (m *Module) RevokePrivilege(ctx context.Context, grantID string) error {
-	// In a real implementation, you would:
-	// 1. Look up the grant information from persistent storage
-	// 2. Delete the role binding
-	// 3. Delete the role
-	return fmt.Errorf("not implemented")
+	if m.client == nil {
+		return fmt.Errorf("Kubernetes client not initialized")
+	}
+	if errs := validation.IsValidLabelValue(grantID); grantID == "" || len(errs) > 0 {
+		return fmt.Errorf("invalid grant ID %q: %s", grantID, strings.Join(errs, "; "))
+	}
+	selector := metav1.ListOptions{LabelSelector: grantLabel + "=" + grantID}
+
+	bindings := m.client.RbacV1().RoleBindings(m.config.Namespace)
+	bindingList, err := bindings.List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list role bindings of grant %s: %v", grantID, err)
+	}
+	for _, binding := range bindingList.Items {
+		if err := ignoreNotFound(bindings.Delete(ctx, binding.Name, metav1.DeleteOptions{})); err != nil {
+			return fmt.Errorf("failed to delete role binding %s: %v", binding.Name, err)
+		}
+	}
+
+	roles := m.client.RbacV1().Roles(m.config.Namespace)
+	roleList, err := roles.List(ctx, selector)
+	if err != nil {
+		return fmt.Errorf("failed to list roles of grant %s: %v", grantID, err)
+	}
+	for _, role := range roleList.Items {
+		if err := ignoreNotFound(roles.Delete(ctx, role.Name, metav1.DeleteOptions{})); err != nil {
+			return fmt.Errorf("failed to delete role %s: %v", role.Name, err)
+		}
+	}
+	return nil
 }
 
 // HealthCheck performs a Kubernetes health check
@@ -200,10 +294,34 @@ func parseDuration(duration string) time.Duration {
 	return d
 }
 
-func (m *Module) createRoleAndBinding(ctx context.Context, roleName, role, userID string) error {
-	// In a real implementation, you would:
-	// 1. Create a Role with the specified permissions
-	// 2. Create a RoleBinding to bind the role to the user
-	// 3. Handle any errors and cleanup if needed
-	return fmt.Errorf("not implemented")
+// createRole creates a role in the module's namespace with the rules of the given cluster role
+func (m *Module) createRole(ctx context.Context, meta metav1.ObjectMeta, clusterRole string) error {
+	source, err := m.client.RbacV1().ClusterRoles().Get(ctx, clusterRole, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("failed to get cluster role %s: %v", clusterRole, err)
+	}
+
+	_, err = m.client.RbacV1().Roles(m.config.Namespace).Create(ctx, &rbacv1.Role{
+		ObjectMeta: meta,
+		Rules:      source.Rules,
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// createRoleBinding binds the role of the same name to the user
+func (m *Module) createRoleBinding(ctx context.Context, meta metav1.ObjectMeta, userID string) error {
+	_, err := m.client.RbacV1().RoleBindings(m.config.Namespace).Create(ctx, &rbacv1.RoleBinding{
+		ObjectMeta: meta,
+		Subjects:   []rbacv1.Subject{{Kind: rbacv1.UserKind, APIGroup: rbacv1.GroupName, Name: userID}},
+		RoleRef:    rbacv1.RoleRef{APIGroup: rbacv1.GroupName, Kind: "Role", Name: meta.Name},
+	}, metav1.CreateOptions{})
+	return err
+}
+
+// ignoreNotFound treats an object that is already gone as deleted
+func ignoreNotFound(err error) error {
+	if apierrors.IsNotFound(err) {
+		return nil
+	}
+	return err
 }
@@ -24,6 +24,10 @@ type Module interface {
 	// HandlePrivilegeRequest handles a privilege escalation request
 	HandlePrivilegeRequest(ctx context.Context, request *PrivilegeRequest) error
 
+	// ProvisionSteps returns the steps that create the grant of a privilege
+	// request on the target, each with the action that undoes it
+	ProvisionSteps(request *PrivilegeRequest) ([]Step, error)
+
 	// RevokePrivilege revokes a granted privilege
 	RevokePrivilege(ctx context.Context, grantID string) error
 
@@ -39,6 +43,9 @@ type PrivilegeRequest struct {
 	Level       string                 `json:"level"`
 	Duration    string                 `json:"duration"`
 	Reason      string                 `json:"reason"`
+	// BoundTo restricts the credentials to this source network, e.g.
+	// 10.8.0.12/32; empty allows any source
+	BoundTo     string                 `json:"bound_to,omitempty"`
 	Metadata    map[string]interface{} `json:"metadata,omitempty"`
 	RequestedAt string                 `json:"requested_at"`
 }
@@ -0,0 +1,166 @@
+package mysql
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// maxIdentifierLength is the longest database or table name MySQL accepts
+const maxIdentifierLength = 64
+
+// object is a database, or a table in one, privileges are granted on
+type object struct {
+	database string
+	// table is empty for all tables of the database
+	table string
+}
+
+// parseObject parses a grantable object written as db, db.* or db.table.
+// Names may be quoted with backticks to contain dots; the global *.* is
+// never grantable.
+func parseObject(value string) (object, error) {
+	database, rest, err := splitIdentifier(value)
+	if err != nil {
+		return object{}, fmt.Errorf("invalid object %q: %v", value, err)
+	}
+	if database == "*" {
+		return object{}, fmt.Errorf("invalid object %q: privileges can't be granted on all databases", value)
+	}
+	obj := object{database: database}
+	if rest == "" {
+		return obj, nil
+	}
+	if !strings.HasPrefix(rest, ".") {
+		return object{}, fmt.Errorf("invalid object %q: expected a dot after the database", value)
+	}
+	table, rest, err := splitIdentifier(rest[1:])
+	if err != nil {
+		return object{}, fmt.Errorf("invalid object %q: %v", value, err)
+	}
+	if rest != "" {
+		return object{}, fmt.Errorf("invalid object %q: unexpected %q after the table", value, rest)
+	}
+	if table != "*" {
+		obj.table = table
+	}
+	return obj, nil
+}
+
+// splitIdentifier reads one, possibly backtick quoted, identifier from the
+// start of the value and returns it with the rest
+func splitIdentifier(value string) (string, string, error) {
+	var name, rest string
+	if strings.HasPrefix(value, "`") {
+		var b strings.Builder
+		i := 1
+		for {
+			end := strings.IndexByte(value[i:], '`')
+			if end < 0 {
+				return "", "", fmt.Errorf("unterminated quoted name")
+			}
+			b.WriteString(value[i : i+end])
+			i += end + 1
+			// A doubled backtick stands for one in the name
+			if !strings.HasPrefix(value[i:], "`") {
+				break
+			}
+			b.WriteByte('`')
+			i++
+		}
+		name, rest = b.String(), value[i:]
+		if name == "*" {
+			return "", "", fmt.Errorf("quoted name can't be *")
+		}
+	} else {
+		end := strings.IndexByte(value, '.')
+		if end < 0 {
+			end = len(value)
+		}
+		name, rest = value[:end], value[end:]
+		if name != "*" && !unquotedIdentifier(name) {
+			return "", "", fmt.Errorf("name %q must be quoted with backticks", name)
+		}
+	}
+	if err := validateIdentifier(name); err != nil {
+		return "", "", err
+	}
+	return name, rest, nil
+}
+
+// unquotedIdentifier reports whether the name is one MySQL accepts unquoted
+func unquotedIdentifier(name string) bool {
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '$' || r >= 0x80) {
+			return false
+		}
+	}
+	return true
+}
+
+// validateIdentifier checks a database or table name is one MySQL accepts
+func validateIdentifier(name string) error {
+	switch {
+	case name == "":
+		return fmt.Errorf("empty name")
+	case len(name) > maxIdentifierLength:
+		return fmt.Errorf("name %q is longer than %d characters", name, maxIdentifierLength)
+	case strings.HasSuffix(name, " "):
+		return fmt.Errorf("name %q ends with a space", name)
+	}
+	for _, r := range name {
+		if r < 0x20 || r == 0x7f || r > 0xffff {
+			return fmt.Errorf("name %q contains an invalid character", name)
+		}
+	}
+	return nil
+}
+
+// String returns the object as written in a GRANT statement, with its names quoted
+func (o object) String() string {
+	table := "*"
+	if o.table != "" {
+		table = quoteIdentifier(o.table)
+	}
+	return quoteIdentifier(o.database) + "." + table
+}
+
+// quoteIdentifier quotes a validated name with backticks, doubling those in it
+func quoteIdentifier(name string) string {
+	return "`" + strings.ReplaceAll(name, "`", "``") + "`"
+}
+
+// literal quotes a string for the parts of a statement MySQL can't take as
+// parameters, like account names and passwords. Rather than relying on
+// escapes, which depend on the server's SQL mode, it refuses values holding
+// quotes, backslashes or control characters.
+func literal(value string) (string, error) {
+	for _, r := range value {
+		if r == '\'' || r == '"' || r == '\\' || r == '`' || r < 0x20 || r == 0x7f {
+			return "", fmt.Errorf("value contains a character that can't be quoted safely")
+		}
+	}
+	return "'" + value + "'", nil
+}
+
+// account returns the quoted user@host account name of a statement
+func account(username, host string) (string, error) {
+	user, err := literal(username)
+	if err != nil {
+		return "", fmt.Errorf("invalid user name: %v", err)
+	}
+	quotedHost, err := literal(host)
+	if err != nil {
+		return "", fmt.Errorf("invalid host: %v", err)
+	}
+	return user + "@" + quotedHost, nil
+}
+
+// accountName derives the name of the temporary user of a request. It is
+// derived from the user and request rather than spelling them out, so it
+// fits MySQL's 32 character limit and holds no characters from the request.
+func accountName(userID, requestID string) string {
+	hash := sha256.Sum256([]byte(userID + "\n" + requestID))
+	return "apollo_" + hex.EncodeToString(hash[:8])
+}
@@ -0,0 +1,274 @@
+package mysql
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestParseObject(t *testing.T) {
+	long := strings.Repeat("a", maxIdentifierLength)
+	tests := []struct {
+		value   string
+		want    object
+		quoted  string
+		wantErr string
+	}{
+		{value: "orders", want: object{database: "orders"}, quoted: "`orders`.*"},
+		{value: "orders.*", want: object{database: "orders"}, quoted: "`orders`.*"},
+		{value: "orders.customers", want: object{database: "orders", table: "customers"}, quoted: "`orders`.`customers`"},
+		{value: "`orders`.`customers`", want: object{database: "orders", table: "customers"}, quoted: "`orders`.`customers`"},
+		// Quoted names may hold dots and, doubled, backticks
+		{value: "`eu.orders`.`2024.q1`", want: object{database: "eu.orders", table: "2024.q1"}, quoted: "`eu.orders`.`2024.q1`"},
+		{value: "`a``b`.c", want: object{database: "a`b", table: "c"}, quoted: "`a``b`.`c`"},
+		{value: "````", want: object{database: "`"}, quoted: "````.*"},
+		{value: "`orders`.*", want: object{database: "orders"}, quoted: "`orders`.*"},
+		{value: long, want: object{database: long}, quoted: "`" + long + "`.*"},
+		{value: "$orders_2.ünïcode", want: object{database: "$orders_2", table: "ünïcode"}, quoted: "`$orders_2`.`ünïcode`"},
+		// Every database is never grantable, quoted or not
+		{value: "*", wantErr: "can't be granted on all databases"},
+		{value: "*.*", wantErr: "can't be granted on all databases"},
+		{value: "*.customers", wantErr: "can't be granted on all databases"},
+		{value: "`*`.customers", wantErr: "quoted name can't be *"},
+		{value: "orders.`*`", wantErr: "quoted name can't be *"},
+		{value: "orders.*x", wantErr: `name "*x" must be quoted`},
+		{value: "", wantErr: "empty name"},
+		{value: "orders.", wantErr: "empty name"},
+		{value: ".customers", wantErr: "empty name"},
+		{value: "``.customers", wantErr: "empty name"},
+		{value: "orders.customers.id", wantErr: `unexpected ".id" after the table`},
+		{value: "`orders`customers", wantErr: "expected a dot after the database"},
+		{value: "`orders", wantErr: "unterminated quoted name"},
+		{value: "orders.`customers``", wantErr: "unterminated quoted name"},
+		{value: "eu-orders", wantErr: `name "eu-orders" must be quoted`},
+		{value: "orders customers", wantErr: "must be quoted"},
+		{value: "orders;drop", wantErr: "must be quoted"},
+		{value: "`orders `", wantErr: "ends with a space"},
+		{value: "orders.`customers `", wantErr: "ends with a space"},
+		{value: long + "a", wantErr: "longer than 64 characters"},
+		{value: "orders.`" + long + "a`", wantErr: "longer than 64 characters"},
+		{value: "`orders\n`", wantErr: "invalid character"},
+	}
+	for _, tt := range tests {
+		got, err := parseObject(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: error %v, want %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q = %+v, want %+v", tt.value, got, tt.want)
+		}
+		if got.String() != tt.quoted {
+			t.Errorf("%q quoted as %s, want %s", tt.value, got.String(), tt.quoted)
+		}
+	}
+}
+
+func TestSplitIdentifier(t *testing.T) {
+	tests := []struct {
+		value   string
+		name    string
+		rest    string
+		wantErr string
+	}{
+		{value: "orders", name: "orders"},
+		{value: "orders.customers", name: "orders", rest: ".customers"},
+		{value: "*.*", name: "*", rest: ".*"},
+		{value: "`a.b`.c", name: "a.b", rest: ".c"},
+		{value: "`a``b`", name: "a`b"},
+		{value: "`a````b`x", name: "a``b", rest: "x"},
+		{value: "`a", wantErr: "unterminated quoted name"},
+		{value: "`*`", wantErr: "quoted name can't be *"},
+		{value: "a b", wantErr: "must be quoted"},
+	}
+	for _, tt := range tests {
+		name, rest, err := splitIdentifier(tt.value)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: error %v, want %q", tt.value, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if name != tt.name || rest != tt.rest {
+			t.Errorf("%q = %q, %q; want %q, %q", tt.value, name, rest, tt.name, tt.rest)
+		}
+	}
+}
+
+func TestValidateIdentifier(t *testing.T) {
+	tests := []struct {
+		name    string
+		wantErr string
+	}{
+		{name: "orders"},
+		{name: "with space inside"},
+		{name: "a`b"},
+		{name: strings.Repeat("x", maxIdentifierLength)},
+		{name: "", wantErr: "empty name"},
+		{name: strings.Repeat("x", maxIdentifierLength+1), wantErr: "longer than 64 characters"},
+		{name: "orders ", wantErr: "ends with a space"},
+		{name: "orders\t", wantErr: "invalid character"},
+		{name: "or\x00ders", wantErr: "invalid character"},
+		{name: "orders\x7f", wantErr: "invalid character"},
+		{name: "orders😀", wantErr: "invalid character"},
+	}
+	for _, tt := range tests {
+		err := validateIdentifier(tt.name)
+		if tt.wantErr == "" {
+			if err != nil {
+				t.Errorf("%q: unexpected error: %v", tt.name, err)
+			}
+			continue
+		}
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: error %v, want %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestLiteral(t *testing.T) {
+	tests := []struct {
+		value string
+		want  string
+	}{
+		{value: "apollo_0123", want: "'apollo_0123'"},
+		{value: "%", want: "'%'"},
+		{value: "10.0.0.0/255.0.0.0", want: "'10.0.0.0/255.0.0.0'"},
+		{value: "pässword with spaces", want: "'pässword with spaces'"},
+		{value: "", want: "''"},
+		// Anything that would need an escape is refused
+		{value: "o'brien"},
+		{value: `say "hi"`},
+		{value: `back\slash`},
+		{value: "back`tick"},
+		{value: "line\nbreak"},
+		{value: "nul\x00"},
+		{value: "tab\t"},
+		{value: "del\x7f"},
+	}
+	for _, tt := range tests {
+		got, err := literal(tt.value)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("%q quoted as %s, want an error", tt.value, got)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.value, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q quoted as %s, want %s", tt.value, got, tt.want)
+		}
+	}
+}
+
+func TestAccount(t *testing.T) {
+	tests := []struct {
+		username string
+		host     string
+		want     string
+		wantErr  string
+	}{
+		{username: "apollo_0123", host: "%", want: "'apollo_0123'@'%'"},
+		{username: "apollo_0123", host: "10.1.2.3", want: "'apollo_0123'@'10.1.2.3'"},
+		{username: "apollo'@'%", host: "%", wantErr: "invalid user name"},
+		{username: "apollo_0123", host: "%' OR '1", wantErr: "invalid host"},
+		{username: "apollo_0123", host: "localhost\n", wantErr: "invalid host"},
+	}
+	for _, tt := range tests {
+		got, err := account(tt.username, tt.host)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q@%q: error %v, want %q", tt.username, tt.host, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q@%q: unexpected error: %v", tt.username, tt.host, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q@%q = %s, want %s", tt.username, tt.host, got, tt.want)
+		}
+	}
+}
+
+func TestAccountName(t *testing.T) {
+	pattern := regexp.MustCompile(`^apollo_[0-9a-f]{16}$`)
+	tests := []struct {
+		userID    string
+		requestID string
+	}{
+		{"alice", "req_1"},
+		{"alice", "req_2"},
+		{"bob", "req_1"},
+		{"alice@example.com", "req_1"},
+		{"o'brien\n`; DROP USER root; --", "req_1"},
+		{strings.Repeat("long", 100), "req_1"},
+	}
+	seen := make(map[string]int)
+	for i, tt := range tests {
+		name := accountName(tt.userID, tt.requestID)
+		if !pattern.MatchString(name) {
+			t.Errorf("%q, %q: account name %q", tt.userID, tt.requestID, name)
+		}
+		if len(name) > 32 {
+			t.Errorf("%q, %q: account name %q is longer than 32 characters", tt.userID, tt.requestID, name)
+		}
+		if name != accountName(tt.userID, tt.requestID) {
+			t.Errorf("%q, %q: account name is not stable", tt.userID, tt.requestID)
+		}
+		if j, ok := seen[name]; ok {
+			t.Errorf("%q, %q: account name %q shared with case %d", tt.userID, tt.requestID, name, j)
+		}
+		seen[name] = i
+	}
+}
+
+func TestUserHost(t *testing.T) {
+	tests := []struct {
+		boundTo string
+		want    string
+		wantErr string
+	}{
+		// Unbound requests may connect from anywhere
+		{boundTo: "", want: "%"},
+		{boundTo: "10.1.2.3/32", want: "10.1.2.3"},
+		{boundTo: "10.1.2.0/24", want: "10.1.2.0/255.255.255.0"},
+		{boundTo: "10.1.2.7/24", want: "10.1.2.0/255.255.255.0"},
+		{boundTo: "172.16.0.0/12", want: "172.16.0.0/255.240.0.0"},
+		{boundTo: "2001:db8::1/128", want: "2001:db8::1"},
+		{boundTo: "2001:db8::/64", wantErr: "can't bind accounts to IPv6 network"},
+		{boundTo: "10.1.2.3", wantErr: "invalid source binding"},
+		{boundTo: "%", wantErr: "invalid source binding"},
+		{boundTo: "10.1.2.0/33", wantErr: "invalid source binding"},
+	}
+	for _, tt := range tests {
+		got, err := userHost(tt.boundTo)
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%q: error %v, want %q", tt.boundTo, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%q: unexpected error: %v", tt.boundTo, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("%q = %q, want %q", tt.boundTo, got, tt.want)
+		}
+	}
+}
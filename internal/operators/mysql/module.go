@@ -3,10 +3,18 @@ package mysql
 import (
 	"context"
 	"database/sql"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
+	"net/netip"
+	"strings"
 	"time"
 
+	mysqldriver "github.com/go-sql-driver/mysql"
+	"github.com/petermein/apollo/internal/credential"
 	"github.com/petermein/apollo/internal/operators"
+	"github.com/petermein/apollo/internal/redact"
 )
 
 // Config represents the MySQL module configuration
@@ -18,12 +26,32 @@ type Config struct {
 	MaxConnections    int           `json:"max_connections"`
 	ConnectionTimeout time.Duration `json:"connection_timeout"`
 	IdleTimeout       time.Duration `json:"idle_timeout"`
+	// Resources is the catalog of resources requests may name, mapping each
+	// resource ID to the objects privileges are granted on, e.g.
+	// orders: [orders.*]. Requests for other resources are refused.
+	Resources map[string][]string `json:"resources"`
+	// GrantDatabase is the database the grant records are kept in, so grants
+	// can be revoked after the operator restarts; apollo when empty
+	GrantDatabase string `json:"grant_database"`
+	// Vault has Vault's database secrets engine issue the credentials of
+	// grants instead of the module creating users itself; the module then
+	// only records the leases, and Resources is unused
+	Vault *VaultConfig `json:"vault,omitempty"`
+	// PasswordPolicy sets how the passwords of temporary users are generated,
+	// e.g. to meet the validate_password component's rules; credential.Default
+	// when unset. Its characters must need no quoting in a statement.
+	PasswordPolicy *credential.Policy `json:"password_policy,omitempty"`
 }
 
+// defaultGrantDatabase keeps the grant records when no database is configured
+const defaultGrantDatabase = "apollo"
+
 // Module implements the MySQL privilege management module
 type Module struct {
-	config *Config
-	db     *sql.DB
+	config    *Config
+	db        *sql.DB
+	vault     *vaultClient
+	depositor operators.CredentialDepositor
 }
 
 // NewModule creates a new MySQL module
@@ -31,6 +59,11 @@ func NewModule() *Module {
 	return &Module{}
 }
 
+// SetCredentialDepositor sets where the passwords of provisioned users are deposited
+func (m *Module) SetCredentialDepositor(depositor operators.CredentialDepositor) {
+	m.depositor = depositor
+}
+
 // Name returns the module name
 func (m *Module) Name() string {
 	return "mysql"
@@ -60,6 +93,34 @@ func (m *Module) ValidateConfig(config interface{}) error {
 	if cfg.Password == "" {
 		return fmt.Errorf("password is required")
 	}
+	if cfg.GrantDatabase != "" {
+		if err := validateIdentifier(cfg.GrantDatabase); err != nil {
+			return fmt.Errorf("invalid grant database: %v", err)
+		}
+	}
+	if cfg.Vault != nil {
+		if err := cfg.Vault.validate(); err != nil {
+			return err
+		}
+	}
+	if cfg.PasswordPolicy != nil {
+		if err := cfg.PasswordPolicy.Validate(); err != nil {
+			return fmt.Errorf("invalid password policy: %v", err)
+		}
+		if _, err := literal(cfg.PasswordPolicy.Alphabet()); err != nil {
+			return fmt.Errorf("invalid password policy: charset %v", err)
+		}
+	}
+	for resourceID, objects := range cfg.Resources {
+		if len(objects) == 0 {
+			return fmt.Errorf("resource %s names no objects", resourceID)
+		}
+		for _, value := range objects {
+			if _, err := parseObject(value); err != nil {
+				return fmt.Errorf("resource %s: %v", resourceID, err)
+			}
+		}
+	}
 
 	return nil
 }
@@ -72,6 +133,9 @@ func (m *Module) Initialize(ctx context.Context, config interface{}) error {
 	}
 
 	m.config = cfg
+	if cfg.Vault != nil {
+		m.vault = newVaultClient(cfg.Vault)
+	}
 
 	dsn := fmt.Sprintf("%s:%s@tcp(%s:%d)/?timeout=%s&readTimeout=%s&writeTimeout=%s",
 		cfg.User, cfg.Password, cfg.Host, cfg.Port,
@@ -91,60 +155,283 @@ func (m *Module) Initialize(ctx context.Context, config interface{}) error {
 	}
 
 	m.db = db
+	return m.createGrantTable(ctx)
+}
+
+// grantDatabase returns the quoted name of the database of grant records
+func (m *Module) grantDatabase() string {
+	if m.config != nil && m.config.GrantDatabase != "" {
+		return quoteIdentifier(m.config.GrantDatabase)
+	}
+	return quoteIdentifier(defaultGrantDatabase)
+}
+
+// grantTable returns the quoted name of the table of grant records
+func (m *Module) grantTable() string {
+	return m.grantDatabase() + ".`grants`"
+}
+
+// createGrantTable creates the table of grant records if it doesn't exist
+func (m *Module) createGrantTable(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, "CREATE DATABASE IF NOT EXISTS "+m.grantDatabase()); err != nil {
+		return fmt.Errorf("failed to create grant database: %v", err)
+	}
+	if _, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.grantTable()+` (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id VARCHAR(255) NOT NULL,
+		username VARCHAR(32) NOT NULL,
+		host VARCHAR(255) NOT NULL,
+		privileges VARCHAR(255) NOT NULL,
+		objects TEXT NOT NULL,
+		expires_at DATETIME(6) NOT NULL,
+		created_at DATETIME(6) NOT NULL,
+		revoked_at DATETIME(6) NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create grant table: %v", err)
+	}
+	if m.vault != nil {
+		return m.createLeaseTable(ctx)
+	}
 	return nil
 }
 
 // HandlePrivilegeRequest handles a MySQL privilege escalation request
 func (m *Module) HandlePrivilegeRequest(ctx context.Context, request *operators.PrivilegeRequest) error {
+	steps, err := m.ProvisionSteps(request)
+	if err != nil {
+		return err
+	}
+	return operators.RunSaga(ctx, steps...)
+}
+
+// ProvisionSteps creates a temporary user, records the grant, grants the user
+// the requested privileges, deposits its password with the API server and
+// leaves the grant on the request's metadata. Dropping the user undoes both
+// the user and its privileges.
+func (m *Module) ProvisionSteps(request *operators.PrivilegeRequest) ([]operators.Step, error) {
+	if m.depositor == nil {
+		return nil, fmt.Errorf("no credential depositor configured")
+	}
+	if m.vault != nil {
+		return m.vaultSteps(request)
+	}
 	// Parse the privilege level
 	privileges, err := parsePrivileges(request.Level)
 	if err != nil {
-		return fmt.Errorf("invalid privilege level: %v", err)
+		return nil, fmt.Errorf("invalid privilege level: %v", err)
+	}
+	// Only the objects the catalog lists for the resource are granted on;
+	// nothing of the request ends up in a statement
+	objects, err := m.grantableObjects(request.ResourceID)
+	if err != nil {
+		return nil, err
 	}
 
-	// Create a temporary user with the requested privileges
-	username := fmt.Sprintf("apollo_%s_%s", request.UserID, request.ID)
-	password := generateSecurePassword()
+	// Create a temporary user with the requested privileges, reachable only
+	// from the network the grant is bound to
+	host, err := userHost(request.BoundTo)
+	if err != nil {
+		return nil, err
+	}
+	username := accountName(request.UserID, request.ID)
+	password, err := m.generatePassword()
+	if err != nil {
+		return nil, err
+	}
+	// MySQL takes no parameters for account names and passwords, so they
+	// are quoted after checking they hold nothing that needs escaping
+	user, err := account(username, host)
+	if err != nil {
+		return nil, err
+	}
+	quotedPassword, err := literal(password)
+	if err != nil {
+		return nil, fmt.Errorf("invalid password: %v", err)
+	}
 
-	// Grant privileges
-	for _, privilege := range privileges {
-		query := fmt.Sprintf("GRANT %s ON %s TO '%s'@'%%' IDENTIFIED BY '%s'",
-			privilege, request.ResourceID, username, password)
+	steps := []operators.Step{{
+		Name: "create user",
+		Do: func(ctx context.Context) error {
+			_, err := m.db.ExecContext(ctx, "CREATE USER "+user+" IDENTIFIED BY "+quotedPassword)
+			return err
+		},
+		Compensate: func(ctx context.Context) error {
+			_, err := m.db.ExecContext(ctx, "DROP USER IF EXISTS "+user)
+			return err
+		},
+	}, {
+		Name: "record grant",
+		Do: func(ctx context.Context) error {
+			return m.recordGrant(ctx, request, username, host, privileges, objects)
+		},
+		Compensate: func(ctx context.Context) error {
+			_, err := m.db.ExecContext(ctx, "DELETE FROM "+m.grantTable()+" WHERE id = ?", request.ID)
+			return err
+		},
+	}}
+	for _, obj := range objects {
+		query := fmt.Sprintf("GRANT %s ON %s TO %s", strings.Join(privileges, ", "), obj, user)
+		steps = append(steps, operators.Step{
+			Name: "grant on " + obj.String(),
+			Do: func(ctx context.Context) error {
+				_, err := m.db.ExecContext(ctx, query)
+				return err
+			},
+		})
+	}
+	steps = append(steps, operators.Step{
+		Name: "deposit credentials",
+		Do: func(ctx context.Context) error {
+			return m.depositor.DepositCredentials(ctx, request.ID, map[string]string{
+				"username": username,
+				"password": password,
+			})
+		},
+	}, operators.Step{
+		Name: "store grant metadata",
+		Do: func(ctx context.Context) error {
+			storeGrant(request, username, host, privileges)
+			return nil
+		},
+	})
+	return steps, nil
+}
 
-		if _, err := m.db.ExecContext(ctx, query); err != nil {
-			return fmt.Errorf("failed to grant privileges: %v", err)
+// grantableObjects resolves a resource to the objects the catalog lists for it
+func (m *Module) grantableObjects(resourceID string) ([]object, error) {
+	if m.config == nil {
+		return nil, fmt.Errorf("module not initialized")
+	}
+	values, ok := m.config.Resources[resourceID]
+	if !ok || len(values) == 0 {
+		return nil, fmt.Errorf("resource %q is not in the MySQL resource catalog", resourceID)
+	}
+	objects := make([]object, 0, len(values))
+	for _, value := range values {
+		obj, err := parseObject(value)
+		if err != nil {
+			return nil, fmt.Errorf("resource %s: %v", resourceID, err)
 		}
+		objects = append(objects, obj)
 	}
+	return objects, nil
+}
 
-	// Store the grant information
+// storeGrant leaves the grant on the request's metadata for later revocation
+func storeGrant(request *operators.PrivilegeRequest, username, host string, privileges []string) {
 	grant := struct {
 		ID         string    `json:"id"`
 		Username   string    `json:"username"`
-		Password   string    `json:"password"`
+		Host       string    `json:"host"`
 		Privileges []string  `json:"privileges"`
 		ExpiresAt  time.Time `json:"expires_at"`
 	}{
 		ID:         request.ID,
 		Username:   username,
-		Password:   password,
+		Host:       host,
 		Privileges: privileges,
 		ExpiresAt:  time.Now().Add(parseDuration(request.Duration)),
 	}
 
-	request.Metadata = map[string]interface{}{
+	request.Metadata = redact.Map(map[string]interface{}{
 		"grant": grant,
-	}
+	})
+}
 
+// recordGrant keeps the user, privileges and expiry of a grant in the grant
+// table, where RevokePrivilege finds them
+func (m *Module) recordGrant(ctx context.Context, request *operators.PrivilegeRequest, username, host string, privileges []string, objects []object) error {
+	names := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		names = append(names, obj.String())
+	}
+	encodedObjects, err := json.Marshal(names)
+	if err != nil {
+		return fmt.Errorf("failed to encode objects: %v", err)
+	}
+	now := time.Now().UTC()
+	_, err = m.db.ExecContext(ctx, "INSERT INTO "+m.grantTable()+
+		" (id, user_id, username, host, privileges, objects, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?, ?)",
+		request.ID, request.UserID, username, host, strings.Join(privileges, ","), string(encodedObjects),
+		now.Add(parseDuration(request.Duration)), now)
+	if err != nil {
+		return fmt.Errorf("failed to record grant: %v", err)
+	}
 	return nil
 }
 
-// RevokePrivilege revokes MySQL privileges
+// RevokePrivilege revokes the privileges of a recorded grant and drops its
+// temporary user, or revokes its Vault lease. Revoking a grant that was
+// revoked already does nothing.
 func (m *Module) RevokePrivilege(ctx context.Context, grantID string) error {
-	// In a real implementation, you would:
-	// 1. Look up the grant information from persistent storage
-	// 2. Revoke the privileges
-	// 3. Drop the user
-	return fmt.Errorf("not implemented")
+	if m.db == nil {
+		return fmt.Errorf("database not initialized")
+	}
+	if m.vault != nil {
+		// Grants provisioned before Vault was configured are still users
+		// of the module's own
+		if revoked, err := m.revokeLease(ctx, grantID); revoked || err != nil {
+			return err
+		}
+	}
+
+	var username, host, privilegeList, objectList string
+	var revoked bool
+	err := m.db.QueryRowContext(ctx, "SELECT username, host, privileges, objects, revoked_at IS NOT NULL FROM "+
+		m.grantTable()+" WHERE id = ?", grantID).Scan(&username, &host, &privilegeList, &objectList, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return fmt.Errorf("grant %s not found", grantID)
+	}
+	if err != nil {
+		return fmt.Errorf("failed to look up grant %s: %v", grantID, err)
+	}
+	if revoked {
+		return nil
+	}
+
+	// The record is read back into statements, so it is checked like a request
+	user, err := account(username, host)
+	if err != nil {
+		return fmt.Errorf("grant %s: %v", grantID, err)
+	}
+	privileges := strings.Split(privilegeList, ",")
+	for _, privilege := range privileges {
+		if !knownPrivilege(privilege) {
+			return fmt.Errorf("grant %s: unknown privilege %q", grantID, privilege)
+		}
+	}
+	var names []string
+	if err := json.Unmarshal([]byte(objectList), &names); err != nil {
+		return fmt.Errorf("grant %s: invalid objects: %v", grantID, err)
+	}
+	for _, name := range names {
+		obj, err := parseObject(name)
+		if err != nil {
+			return fmt.Errorf("grant %s: %v", grantID, err)
+		}
+		query := fmt.Sprintf("REVOKE %s ON %s FROM %s", strings.Join(privileges, ", "), obj, user)
+		if _, err := m.db.ExecContext(ctx, query); err != nil && !missingGrant(err) {
+			return fmt.Errorf("failed to revoke privileges on %s: %v", obj, err)
+		}
+	}
+	if _, err := m.db.ExecContext(ctx, "DROP USER IF EXISTS "+user); err != nil {
+		return fmt.Errorf("failed to drop user %s: %v", username, err)
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE "+m.grantTable()+" SET revoked_at = ? WHERE id = ?", time.Now().UTC(), grantID); err != nil {
+		return fmt.Errorf("failed to record revocation of grant %s: %v", grantID, err)
+	}
+	return nil
+}
+
+// missingGrant reports whether a REVOKE failed because the privileges or the
+// user are gone already, e.g. dropped by hand
+func missingGrant(err error) bool {
+	var mysqlErr *mysqldriver.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return false
+	}
+	// ER_NONEXISTING_GRANT and ER_NONEXISTING_TABLE_GRANT
+	return mysqlErr.Number == 1141 || mysqlErr.Number == 1147
 }
 
 // HealthCheck performs a MySQL health check
@@ -156,6 +443,11 @@ func (m *Module) HealthCheck(ctx context.Context) error {
 	if err := m.db.PingContext(ctx); err != nil {
 		return fmt.Errorf("database health check failed: %v", err)
 	}
+	if m.vault != nil {
+		if err := m.vault.health(ctx); err != nil {
+			return fmt.Errorf("vault health check failed: %v", err)
+		}
+	}
 
 	return nil
 }
@@ -232,14 +524,14 @@ func (m *Module) ListServers(ctx context.Context) ([]ServerInfo, error) {
 
 // Helper functions
 
-func parsePrivileges(level string) ([]string, error) {
-	// Map privilege levels to actual MySQL privileges
-	privilegeMap := map[string][]string{
-		"read":  {"SELECT"},
-		"write": {"SELECT", "INSERT", "UPDATE", "DELETE"},
-		"admin": {"ALL PRIVILEGES"},
-	}
+// privilegeMap maps privilege levels to actual MySQL privileges
+var privilegeMap = map[string][]string{
+	"read":  {"SELECT"},
+	"write": {"SELECT", "INSERT", "UPDATE", "DELETE"},
+	"admin": {"ALL PRIVILEGES"},
+}
 
+func parsePrivileges(level string) ([]string, error) {
 	privileges, ok := privilegeMap[level]
 	if !ok {
 		return nil, fmt.Errorf("invalid privilege level: %s", level)
@@ -248,6 +540,40 @@ func parsePrivileges(level string) ([]string, error) {
 	return privileges, nil
 }
 
+// knownPrivilege reports whether a privilege is one of those levels grant
+func knownPrivilege(privilege string) bool {
+	for _, privileges := range privilegeMap {
+		for _, p := range privileges {
+			if p == privilege {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// userHost returns the host part of a MySQL account reachable only from the
+// network the grant is bound to: an address, an IPv4 network with its netmask,
+// or any host when unbound. MySQL matches IPv6 hosts only by exact address.
+func userHost(boundTo string) (string, error) {
+	if boundTo == "" {
+		return "%", nil
+	}
+	prefix, err := netip.ParsePrefix(boundTo)
+	if err != nil {
+		return "", fmt.Errorf("invalid source binding %q: %v", boundTo, err)
+	}
+	addr := prefix.Addr()
+	if prefix.IsSingleIP() {
+		return addr.String(), nil
+	}
+	if !addr.Is4() {
+		return "", fmt.Errorf("MySQL can't bind accounts to IPv6 network %s", boundTo)
+	}
+	mask := net.CIDRMask(prefix.Bits(), 32)
+	return fmt.Sprintf("%s/%s", prefix.Masked().Addr(), net.IP(mask)), nil
+}
+
 func parseDuration(duration string) time.Duration {
 	d, err := time.ParseDuration(duration)
 	if err != nil {
@@ -257,7 +583,10 @@ func parseDuration(duration string) time.Duration {
 	return d
 }
 
-func generateSecurePassword() string {
-	// In a real implementation, generate a secure random password
-	return "temporary_password" // This should be replaced with proper password generation
+// generatePassword returns a random password following the configured policy
+func (m *Module) generatePassword() (string, error) {
+	if m.config.PasswordPolicy != nil {
+		return m.config.PasswordPolicy.Generate()
+	}
+	return credential.Generate()
 }
@@ -0,0 +1,103 @@
+package mysql
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/petermein/apollo/internal/operators"
+)
+
+// recorder is a database connector that records the statements executed on
+// it instead of running them
+type recorder struct {
+	mu         sync.Mutex
+	statements []string
+}
+
+func (r *recorder) Connect(ctx context.Context) (driver.Conn, error) { return r, nil }
+func (r *recorder) Driver() driver.Driver                            { return nil }
+func (r *recorder) Prepare(query string) (driver.Stmt, error) {
+	return nil, errors.New("statements are not prepared")
+}
+func (r *recorder) Close() error { return nil }
+func (r *recorder) Begin() (driver.Tx, error) {
+	return nil, errors.New("transactions are not supported")
+}
+
+func (r *recorder) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (driver.Result, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.statements = append(r.statements, query)
+	return driver.RowsAffected(1), nil
+}
+
+// executed returns the statements executed starting with the prefix
+func (r *recorder) executed(prefix string) []string {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	var statements []string
+	for _, statement := range r.statements {
+		if strings.HasPrefix(statement, prefix) {
+			statements = append(statements, statement)
+		}
+	}
+	return statements
+}
+
+// depositorFunc deposits credentials with a function
+type depositorFunc func(grantID string, credentials map[string]string) error
+
+func (f depositorFunc) DepositCredentials(ctx context.Context, grantID string, credentials map[string]string) error {
+	return f(grantID, credentials)
+}
+
+// TestProvisionBoundAccount checks the temporary user of a request bound to a
+// source network can only connect from that network
+func TestProvisionBoundAccount(t *testing.T) {
+	tests := []struct {
+		boundTo string
+		host    string
+	}{
+		{boundTo: "", host: "'%'"},
+		{boundTo: "10.8.0.12/32", host: "'10.8.0.12'"},
+		{boundTo: "10.8.0.0/24", host: "'10.8.0.0/255.255.255.0'"},
+	}
+	for _, tt := range tests {
+		db := &recorder{}
+		var username string
+		m := &Module{
+			config: &Config{Resources: map[string][]string{"orders": {"orders.*"}}},
+			db:     sql.OpenDB(db),
+			depositor: depositorFunc(func(grantID string, credentials map[string]string) error {
+				username = credentials["username"]
+				return nil
+			}),
+		}
+		request := &operators.PrivilegeRequest{
+			ID:         "grant_1",
+			UserID:     "alice",
+			ResourceID: "orders",
+			Level:      "read",
+			Duration:   "1h",
+			BoundTo:    tt.boundTo,
+		}
+		if err := m.HandlePrivilegeRequest(context.Background(), request); err != nil {
+			t.Fatalf("%q: %v", tt.boundTo, err)
+		}
+
+		want := "'" + username + "'@" + tt.host
+		created := db.executed("CREATE USER ")
+		if len(created) != 1 || !strings.HasPrefix(created[0], "CREATE USER "+want+" IDENTIFIED BY ") {
+			t.Errorf("%q: created %q, want user %s", tt.boundTo, created, want)
+		}
+		granted := db.executed("GRANT ")
+		if len(granted) != 1 || !strings.HasSuffix(granted[0], " TO "+want) {
+			t.Errorf("%q: granted %q, want to %s", tt.boundTo, granted, want)
+		}
+	}
+}
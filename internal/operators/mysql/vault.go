@@ -0,0 +1,322 @@
+package mysql
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/operators"
+	"github.com/petermein/apollo/internal/redact"
+)
+
+// defaultVaultMount is where the database secrets engine is mounted when no
+// mount is configured
+const defaultVaultMount = "database"
+
+// VaultConfig has the database secrets engine of a HashiCorp Vault issue the
+// credentials of grants
+type VaultConfig struct {
+	Address string `json:"address"`
+	// Token authenticates the module to Vault; VAULT_TOKEN when empty
+	Token string `json:"token"`
+	// Mount is the path the database secrets engine is mounted at
+	Mount string `json:"mount"`
+	// Roles maps resources and levels to the Vault roles issuing their
+	// credentials, e.g. orders: {read: orders-read}. The roles' creation
+	// statements decide what the users may do.
+	Roles map[string]map[string]string `json:"roles"`
+}
+
+// validate checks the Vault configuration
+func (c *VaultConfig) validate() error {
+	if c.Address == "" {
+		return fmt.Errorf("vault address is required")
+	}
+	if c.Token == "" && os.Getenv("VAULT_TOKEN") == "" {
+		return fmt.Errorf("vault token is required")
+	}
+	if len(c.Roles) == 0 {
+		return fmt.Errorf("vault roles are required")
+	}
+	for resourceID, levels := range c.Roles {
+		if len(levels) == 0 {
+			return fmt.Errorf("vault resource %s names no roles", resourceID)
+		}
+		for level, role := range levels {
+			if _, ok := privilegeMap[level]; !ok {
+				return fmt.Errorf("vault resource %s: invalid privilege level: %s", resourceID, level)
+			}
+			if role == "" || strings.ContainsAny(role, "/?#") {
+				return fmt.Errorf("vault resource %s: invalid role %q for %s", resourceID, role, level)
+			}
+		}
+	}
+	return nil
+}
+
+// role returns the Vault role issuing the credentials of a resource and level
+func (c *VaultConfig) role(resourceID, level string) (string, error) {
+	levels, ok := c.Roles[resourceID]
+	if !ok {
+		return "", fmt.Errorf("resource %q has no Vault roles", resourceID)
+	}
+	role, ok := levels[level]
+	if !ok {
+		return "", fmt.Errorf("resource %s has no Vault role for %s access", resourceID, level)
+	}
+	return role, nil
+}
+
+// vaultLease is a credential issued by the database secrets engine
+type vaultLease struct {
+	ID       string
+	Duration time.Duration
+	Username string
+	Password string
+}
+
+// leaseTable returns the quoted name of the table of Vault leases
+func (m *Module) leaseTable() string {
+	return m.grantDatabase() + ".`vault_leases`"
+}
+
+// createLeaseTable creates the table of Vault leases if it doesn't exist
+func (m *Module) createLeaseTable(ctx context.Context) error {
+	if _, err := m.db.ExecContext(ctx, `CREATE TABLE IF NOT EXISTS `+m.leaseTable()+` (
+		id VARCHAR(64) PRIMARY KEY,
+		user_id VARCHAR(255) NOT NULL,
+		username VARCHAR(255) NOT NULL,
+		role VARCHAR(255) NOT NULL,
+		lease_id VARCHAR(512) NOT NULL,
+		expires_at DATETIME(6) NOT NULL,
+		created_at DATETIME(6) NOT NULL,
+		revoked_at DATETIME(6) NULL
+	)`); err != nil {
+		return fmt.Errorf("failed to create lease table: %v", err)
+	}
+	return nil
+}
+
+// vaultSteps has the Vault role of the request's resource and level issue a
+// user, makes its lease last as long as the grant, records the lease and
+// deposits the credentials with the API server. Revoking the lease drops the
+// user, so the module never creates users or keeps passwords itself.
+func (m *Module) vaultSteps(request *operators.PrivilegeRequest) ([]operators.Step, error) {
+	// The role's creation statements decide where users connect from
+	if request.BoundTo != "" {
+		return nil, fmt.Errorf("credentials issued by Vault can't be bound to %s", request.BoundTo)
+	}
+	role, err := m.config.Vault.role(request.ResourceID, request.Level)
+	if err != nil {
+		return nil, err
+	}
+	duration := parseDuration(request.Duration)
+
+	var lease *vaultLease
+	return []operators.Step{{
+		Name: "issue credentials",
+		Do: func(ctx context.Context) error {
+			var err error
+			lease, err = m.vault.issue(ctx, role)
+			return err
+		},
+		Compensate: func(ctx context.Context) error {
+			return m.vault.revoke(ctx, lease.ID)
+		},
+	}, {
+		Name: "extend lease",
+		Do: func(ctx context.Context) error {
+			if lease.Duration >= duration {
+				return nil
+			}
+			lasts, err := m.vault.renew(ctx, lease.ID, duration)
+			if err != nil {
+				return err
+			}
+			if lasts < duration {
+				log.Printf("Vault lease of grant %s lasts %s of %s; raise the max_ttl of role %s", request.ID, lasts, duration, role)
+			}
+			return nil
+		},
+	}, {
+		Name: "record lease",
+		Do: func(ctx context.Context) error {
+			now := time.Now().UTC()
+			_, err := m.db.ExecContext(ctx, "INSERT INTO "+m.leaseTable()+
+				" (id, user_id, username, role, lease_id, expires_at, created_at) VALUES (?, ?, ?, ?, ?, ?, ?)",
+				request.ID, request.UserID, lease.Username, role, lease.ID, now.Add(duration), now)
+			return err
+		},
+		Compensate: func(ctx context.Context) error {
+			_, err := m.db.ExecContext(ctx, "DELETE FROM "+m.leaseTable()+" WHERE id = ?", request.ID)
+			return err
+		},
+	}, {
+		Name: "deposit credentials",
+		Do: func(ctx context.Context) error {
+			return m.depositor.DepositCredentials(ctx, request.ID, map[string]string{
+				"username": lease.Username,
+				"password": lease.Password,
+			})
+		},
+	}, {
+		Name: "store grant metadata",
+		Do: func(ctx context.Context) error {
+			request.Metadata = redact.Map(map[string]interface{}{
+				"grant": map[string]interface{}{
+					"id":         request.ID,
+					"username":   lease.Username,
+					"vault_role": role,
+					"expires_at": time.Now().Add(duration),
+				},
+			})
+			return nil
+		},
+	}}, nil
+}
+
+// revokeLease revokes the Vault lease of a grant, reporting false when the
+// grant has no lease
+func (m *Module) revokeLease(ctx context.Context, grantID string) (bool, error) {
+	var leaseID string
+	var revoked bool
+	err := m.db.QueryRowContext(ctx, "SELECT lease_id, revoked_at IS NOT NULL FROM "+
+		m.leaseTable()+" WHERE id = ?", grantID).Scan(&leaseID, &revoked)
+	if errors.Is(err, sql.ErrNoRows) {
+		return false, nil
+	}
+	if err != nil {
+		return true, fmt.Errorf("failed to look up lease of grant %s: %v", grantID, err)
+	}
+	if revoked {
+		return true, nil
+	}
+	if err := m.vault.revoke(ctx, leaseID); err != nil {
+		return true, fmt.Errorf("failed to revoke lease of grant %s: %v", grantID, err)
+	}
+	if _, err := m.db.ExecContext(ctx, "UPDATE "+m.leaseTable()+" SET revoked_at = ? WHERE id = ?", time.Now().UTC(), grantID); err != nil {
+		return true, fmt.Errorf("failed to record revocation of grant %s: %v", grantID, err)
+	}
+	return true, nil
+}
+
+// vaultClient talks to the database secrets engine and the lease API of Vault
+type vaultClient struct {
+	address string
+	token   string
+	mount   string
+	client  *http.Client
+}
+
+// newVaultClient creates a client for the configured Vault
+func newVaultClient(cfg *VaultConfig) *vaultClient {
+	token := cfg.Token
+	if token == "" {
+		token = os.Getenv("VAULT_TOKEN")
+	}
+	mount := cfg.Mount
+	if mount == "" {
+		mount = defaultVaultMount
+	}
+	return &vaultClient{
+		address: strings.TrimSuffix(cfg.Address, "/"),
+		token:   token,
+		mount:   strings.Trim(mount, "/"),
+		client:  &http.Client{Timeout: 10 * time.Second},
+	}
+}
+
+// issue has the role create a database user and returns its lease
+func (v *vaultClient) issue(ctx context.Context, role string) (*vaultLease, error) {
+	var resp struct {
+		LeaseID       string `json:"lease_id"`
+		LeaseDuration int    `json:"lease_duration"`
+		Data          struct {
+			Username string `json:"username"`
+			Password string `json:"password"`
+		} `json:"data"`
+	}
+	if err := v.call(ctx, http.MethodGet, v.mount+"/creds/"+role, nil, &resp); err != nil {
+		return nil, err
+	}
+	if resp.LeaseID == "" || resp.Data.Username == "" {
+		return nil, fmt.Errorf("vault role %s issued no lease", role)
+	}
+	return &vaultLease{
+		ID:       resp.LeaseID,
+		Duration: time.Duration(resp.LeaseDuration) * time.Second,
+		Username: resp.Data.Username,
+		Password: resp.Data.Password,
+	}, nil
+}
+
+// renew asks for the lease to last the duration from now and returns how long
+// it lasts; Vault caps it at the role's maximum TTL
+func (v *vaultClient) renew(ctx context.Context, leaseID string, duration time.Duration) (time.Duration, error) {
+	var resp struct {
+		LeaseDuration int `json:"lease_duration"`
+	}
+	body := map[string]interface{}{"lease_id": leaseID, "increment": int(duration.Seconds())}
+	if err := v.call(ctx, http.MethodPut, "sys/leases/renew", body, &resp); err != nil {
+		return 0, err
+	}
+	return time.Duration(resp.LeaseDuration) * time.Second, nil
+}
+
+// revoke revokes a lease, which drops its database user. Revoking an expired
+// or revoked lease does nothing.
+func (v *vaultClient) revoke(ctx context.Context, leaseID string) error {
+	return v.call(ctx, http.MethodPut, "sys/leases/revoke", map[string]string{"lease_id": leaseID}, nil)
+}
+
+// health checks that Vault is reachable and the token is valid
+func (v *vaultClient) health(ctx context.Context) error {
+	return v.call(ctx, http.MethodGet, "auth/token/lookup-self", nil, nil)
+}
+
+// call sends a request to Vault's API
+func (v *vaultClient) call(ctx context.Context, method, path string, input, output interface{}) error {
+	var body io.Reader
+	if input != nil {
+		data, err := json.Marshal(input)
+		if err != nil {
+			return fmt.Errorf("failed to marshal vault request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+	req, err := http.NewRequestWithContext(ctx, method, v.address+"/v1/"+path, body)
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("X-Vault-Token", v.token)
+	if input != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to call vault: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		message, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("vault returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(message)))
+	}
+	if output == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(output); err != nil {
+		return fmt.Errorf("failed to decode vault response: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,103 @@
+package operators
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+)
+
+// compensationTimeout bounds how long undoing a failed saga may take
+const compensationTimeout = time.Minute
+
+// Step is one step of a saga together with the action that undoes it
+type Step struct {
+	// Name describes the step, e.g. "create user"
+	Name string
+	// Do performs the step
+	Do func(ctx context.Context) error
+	// Compensate undoes the step after a later step failed; nil when there
+	// is nothing to undo or an earlier step's compensation covers it
+	Compensate func(ctx context.Context) error
+}
+
+// GrantRecords keeps Apollo's record of the grants provisioned on targets
+type GrantRecords interface {
+	// RecordGrant records a grant before it is created on the target
+	RecordGrant(ctx context.Context, request *PrivilegeRequest) error
+	// SaveGrant persists the metadata the module left on the request
+	SaveGrant(ctx context.Context, request *PrivilegeRequest) error
+	// DiscardGrant removes the record of a grant that could not be provisioned
+	DiscardGrant(ctx context.Context, request *PrivilegeRequest) error
+}
+
+// CredentialDepositor hands the credentials of a grant to the API server, which
+// seals them and keeps them until the holder redeems them; modules never put
+// credentials on the request metadata
+type CredentialDepositor interface {
+	DepositCredentials(ctx context.Context, grantID string, credentials map[string]string) error
+}
+
+// RunSaga runs the steps in order. When a step fails, the steps completed
+// before it are compensated in reverse order and the step's error is returned,
+// together with any compensation that failed as well.
+func RunSaga(ctx context.Context, steps ...Step) error {
+	for i, step := range steps {
+		if err := step.Do(ctx); err != nil {
+			err = fmt.Errorf("failed to %s: %v", step.Name, err)
+			if cerr := compensate(ctx, steps[:i]); cerr != nil {
+				return fmt.Errorf("%v; %v", err, cerr)
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// compensate undoes the completed steps in reverse order. It keeps going
+// when a compensation fails so as much as possible is cleaned up, and runs
+// even when ctx is cancelled, as a timed-out step is a common reason to undo.
+func compensate(ctx context.Context, completed []Step) error {
+	ctx, cancel := context.WithTimeout(context.WithoutCancel(ctx), compensationTimeout)
+	defer cancel()
+
+	var failed []string
+	for i := len(completed) - 1; i >= 0; i-- {
+		step := completed[i]
+		if step.Compensate == nil {
+			continue
+		}
+		if err := step.Compensate(ctx); err != nil {
+			log.Printf("Failed to compensate %s: %v", step.Name, err)
+			failed = append(failed, fmt.Sprintf("%s: %v", step.Name, err))
+		}
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to compensate %v", failed)
+	}
+	return nil
+}
+
+// Provision provisions a privilege request as a saga: the grant is recorded,
+// created on the target by the module and its metadata persisted. When a step
+// fails the completed ones are undone, so a target never holds credentials
+// Apollo has no record of.
+func Provision(ctx context.Context, module Module, request *PrivilegeRequest, records GrantRecords) error {
+	target, err := module.ProvisionSteps(request)
+	if err != nil {
+		return err
+	}
+
+	steps := make([]Step, 0, len(target)+2)
+	steps = append(steps, Step{
+		Name:       "record grant",
+		Do:         func(ctx context.Context) error { return records.RecordGrant(ctx, request) },
+		Compensate: func(ctx context.Context) error { return records.DiscardGrant(ctx, request) },
+	})
+	steps = append(steps, target...)
+	steps = append(steps, Step{
+		Name: "persist grant metadata",
+		Do:   func(ctx context.Context) error { return records.SaveGrant(ctx, request) },
+	})
+	return RunSaga(ctx, steps...)
+}
@@ -0,0 +1,131 @@
+package rules
+
+import (
+	"fmt"
+	"path"
+	"slices"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Principals lists users, teams, as defined under teams, and groups synced
+// from the identity provider by name
+type Principals struct {
+	Users  []string `yaml:"users"`
+	Teams  []string `yaml:"teams"`
+	Groups []string `yaml:"groups"`
+}
+
+// empty reports whether no principals are listed
+func (p Principals) empty() bool {
+	return len(p.Users) == 0 && len(p.Teams) == 0 && len(p.Groups) == 0
+}
+
+// includes reports whether the user is listed directly or through one of the
+// teams or groups. Groups unknown to the directory have no members.
+func (p Principals) includes(r *Rules, groups GroupDirectory, userID string) bool {
+	if slices.Contains(p.Users, userID) {
+		return true
+	}
+	for _, team := range r.teamsOf(userID) {
+		if slices.Contains(p.Teams, team) {
+			return true
+		}
+	}
+	for _, group := range p.Groups {
+		if slices.Contains(groupMembers(groups, group), userID) {
+			return true
+		}
+	}
+	return false
+}
+
+// AccessRule explicitly allows or denies users and teams access to resources at the
+// given levels. Denials always win; when any rule covering a resource and level has
+// an allow list, only the principals allowed by one of those rules may request it.
+//
+//	resources: ["prod-mysql-*"]
+//	levels: [admin, root]
+//	allow:
+//	  teams: [dba]
+type AccessRule struct {
+	Name string `yaml:"name"`
+
+	// Resources lists resource IDs or glob patterns; empty matches every resource
+	Resources []string `yaml:"resources"`
+	Levels    []string `yaml:"levels"`
+
+	Allow Principals `yaml:"allow"`
+	Deny  Principals `yaml:"deny"`
+}
+
+// validateAccessRules checks the access rules for missing or malformed fields
+func (r *Rules) validateAccessRules() error {
+	for i, rule := range r.AccessRules {
+		if rule.Name == "" {
+			return fmt.Errorf("access rule %d: name is required", i)
+		}
+		if rule.Allow.empty() && rule.Deny.empty() {
+			return fmt.Errorf("access rule %s: allow or deny is required", rule.Name)
+		}
+		for _, pattern := range rule.Resources {
+			if _, err := path.Match(pattern, ""); err != nil {
+				return fmt.Errorf("access rule %s: invalid resource pattern %q: %v", rule.Name, pattern, err)
+			}
+		}
+		for _, teams := range [][]string{rule.Allow.Teams, rule.Deny.Teams} {
+			for _, team := range teams {
+				if _, ok := r.Teams[team]; !ok {
+					return fmt.Errorf("access rule %s: unknown team %q", rule.Name, team)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// covers reports whether the rule applies to the requested resource and level
+func (a *AccessRule) covers(request *models.PrivilegeRequest) bool {
+	if len(a.Levels) > 0 && !slices.Contains(a.Levels, string(request.Level)) {
+		return false
+	}
+	if len(a.Resources) == 0 {
+		return true
+	}
+	for _, pattern := range a.Resources {
+		if matched, _ := path.Match(pattern, request.ResourceID); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateAccessRules applies the allow and deny lists to a request
+func (e *DefaultRuleEngine) evaluateAccessRules(request *models.PrivilegeRequest, decision *Decision) error {
+	rules := e.Rules()
+	groups := e.groupDirectory()
+
+	restricted, allowed := false, false
+	for i := range rules.AccessRules {
+		rule := &rules.AccessRules[i]
+		if !rule.covers(request) {
+			continue
+		}
+		if rule.Deny.includes(rules, groups, request.UserID) {
+			return violation(rule.Name, "%s is not allowed %s access to %s", request.UserID, request.Level, request.ResourceID)
+		}
+		if rule.Allow.empty() {
+			continue
+		}
+		restricted = true
+		if rule.Allow.includes(rules, groups, request.UserID) {
+			allowed = true
+			decision.record(rule.Name, ResultMatched, fmt.Sprintf("%s is eligible", request.UserID))
+		}
+	}
+
+	if restricted && !allowed {
+		return violation("access_rules", "%s is not eligible for %s access to %s", request.UserID, request.Level, request.ResourceID)
+	}
+	return nil
+}
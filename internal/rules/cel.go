@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// ExpressionRule is a policy rule written as a CEL expression. When the
+// condition evaluates to true the rule either denies the request or raises the
+// number of approvals it requires, e.g.
+//
+//	when: "request.level == 'admin' && resource.tier == 'production'"
+//	required_approvals: 2
+type ExpressionRule struct {
+	Name              string `yaml:"name"`
+	When              string `yaml:"when"`
+	Deny              bool   `yaml:"deny"`
+	Message           string `yaml:"message"`
+	RequiredApprovals int    `yaml:"required_approvals"`
+
+	program *expression
+}
+
+// compileExpressions compiles every expression rule so syntax and type errors
+// surface when the rules are loaded rather than when a request arrives
+func (r *Rules) compileExpressions() error {
+	for i := range r.Expressions {
+		rule := &r.Expressions[i]
+		if rule.Name == "" {
+			return fmt.Errorf("expression rule %d: name is required", i)
+		}
+		if !rule.Deny && rule.RequiredApprovals == 0 {
+			return fmt.Errorf("expression rule %s: either deny or required_approvals must be set", rule.Name)
+		}
+
+		program, err := parseExpression(rule.When)
+		if err != nil {
+			return fmt.Errorf("expression rule %s: %v", rule.Name, err)
+		}
+		if !program.returnsBool() {
+			return fmt.Errorf("expression rule %s: condition must be a bool, not %s", rule.Name, program.output)
+		}
+		rule.program = program
+	}
+
+	return nil
+}
+
+// expressionInput builds the variables passed to CEL expressions for a request
+func expressionInput(request *models.PrivilegeRequest, resource map[string]interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+	var requestMap map[string]interface{}
+	if err := json.Unmarshal(data, &requestMap); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal request: %v", err)
+	}
+
+	return map[string]interface{}{
+		"request":  requestMap,
+		"resource": resource,
+		"duration": request.ExpiresAt.Sub(request.RequestedAt),
+		"now":      time.Now().UTC(),
+	}, nil
+}
+
+// evaluate runs the expression against the input and reports whether it matched
+func (rule *ExpressionRule) evaluate(input map[string]interface{}) (bool, error) {
+	if rule.program == nil {
+		return false, fmt.Errorf("expression rule %s is not compiled", rule.Name)
+	}
+
+	matched, err := evalBool(rule.program, input)
+	if err != nil {
+		return false, fmt.Errorf("failed to evaluate expression rule %s: %v", rule.Name, err)
+	}
+	return matched, nil
+}
@@ -0,0 +1,511 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Sensitivity tiers that resources can declare
+const (
+	TierPublic       = "public"
+	TierInternal     = "internal"
+	TierConfidential = "confidential"
+	TierRestricted   = "restricted"
+)
+
+// Limits holds the configurable limits applied to privilege requests.
+// Zero values mean "inherit from the enclosing scope".
+type Limits struct {
+	MaxDuration     time.Duration           `yaml:"max_duration" json:"max_duration"`
+	MinDuration     time.Duration           `yaml:"min_duration" json:"min_duration"`
+	MinReasonLength int                     `yaml:"min_reason_length" json:"min_reason_length"`
+	AllowedLevels   []models.PrivilegeLevel `yaml:"allowed_levels" json:"allowed_levels,omitempty"`
+
+	// LevelMaxDuration caps the duration per privilege level, e.g. admin: 1h
+	LevelMaxDuration map[models.PrivilegeLevel]time.Duration `yaml:"level_max_duration" json:"level_max_duration,omitempty"`
+
+	// Approvals is the number of approvals required; nil inherits, zero means auto-approve
+	Approvals *int `yaml:"approvals" json:"approvals,omitempty"`
+
+	// Notify lists the notification targets, such as Slack channels, informed of requests
+	Notify []string `yaml:"notify" json:"notify,omitempty"`
+
+	// BindSource binds the credentials of grants to the requester's source
+	// address, e.g. as the host of a MySQL user; nil inherits
+	BindSource *bool `yaml:"bind_source" json:"bind_source,omitempty"`
+	// BindPrefix widens the binding to the requester's network of this prefix
+	// length, e.g. 24; zero binds the exact address
+	BindPrefix int `yaml:"bind_prefix" json:"bind_prefix,omitempty"`
+
+	// WebAuthnLevels lists the privilege levels whose approvers must confirm
+	// with a WebAuthn assertion from a hardware key; nil inherits
+	WebAuthnLevels []models.PrivilegeLevel `yaml:"webauthn_levels" json:"webauthn_levels,omitempty"`
+}
+
+// ModuleRules holds the limits for a module, optionally refined per resource tier
+type ModuleRules struct {
+	Limits `yaml:",inline"`
+	Tiers  map[string]Limits `yaml:"tiers"`
+}
+
+// Resource declares the sensitivity tier of a resource and limits specific to it.
+// In YAML a resource can be written as just its tier, e.g. "prod-mysql-1: restricted".
+type Resource struct {
+	Tier   string   `yaml:"tier"`
+	Tags   []string `yaml:"tags"`
+	Limits `yaml:",inline"`
+
+	// Owner and Environment select the approval route, e.g. payments and production
+	Owner       string `yaml:"owner"`
+	Environment string `yaml:"environment"`
+
+	// Service names the on-call schedule covering the resource; the resource
+	// ID is used when empty
+	Service string `yaml:"service"`
+}
+
+// UnmarshalYAML accepts either a tier name or a mapping
+func (r *Resource) UnmarshalYAML(value *yaml.Node) error {
+	if value.Kind == yaml.ScalarNode {
+		r.Tier = value.Value
+		return nil
+	}
+	type plain Resource
+	return value.Decode((*plain)(r))
+}
+
+// Rules is the complete set of rule definitions evaluated by the DefaultRuleEngine
+type Rules struct {
+	// Version is the policy version the rules were loaded from; zero when unversioned
+	Version int `yaml:"-"`
+
+	// Defaults apply to every request unless overridden
+	Defaults Limits `yaml:"defaults"`
+
+	// Modules overrides the defaults per module (e.g. mysql, kubernetes)
+	Modules map[string]ModuleRules `yaml:"modules"`
+
+	// Tiers derives the limits of a resource from its sensitivity tier
+	Tiers map[string]Limits `yaml:"tiers"`
+
+	// Resources maps resource IDs to their declaration
+	Resources map[string]Resource `yaml:"resources"`
+
+	// AccessRules explicitly allow or deny users and teams per resource and level
+	AccessRules []AccessRule `yaml:"access_rules"`
+
+	// ApprovalRoutes decide who approves and who is notified of requests per resource
+	ApprovalRoutes []ApprovalRoute `yaml:"approval_routes"`
+
+	// TagRules apply to resources carrying data classification tags
+	TagRules []TagRule `yaml:"tag_rules"`
+
+	// NetworkPolicies restrict the source networks requests may come from
+	NetworkPolicies []NetworkPolicy `yaml:"network_policies"`
+
+	// RateLimits restricts how often a user may request privileges
+	RateLimits RateLimits `yaml:"rate_limits"`
+
+	// Teams maps team names to their members, used for separation of duties
+	Teams map[string][]string `yaml:"teams"`
+
+	// SeparationOfDuties restricts who may approve whose requests
+	SeparationOfDuties SeparationOfDuties `yaml:"separation_of_duties"`
+
+	// StepUp requires a fresh MFA assertion for high-privilege requests
+	StepUp *StepUpPolicy `yaml:"step_up"`
+
+	// TimePolicies restrict when requests can be submitted or auto-approved
+	TimePolicies []TimePolicy `yaml:"time_policies"`
+
+	// OnCallPolicies auto-approve requests from the engineer on call for the resource
+	OnCallPolicies []OnCallPolicy `yaml:"on_call_policies"`
+
+	// Risk configures risk scoring of requests
+	Risk RiskPolicy `yaml:"risk"`
+
+	// Expressions are CEL rules evaluated after the limits
+	Expressions []ExpressionRule `yaml:"expressions"`
+
+	// DecisionWebhook delegates the final decision to an external service
+	DecisionWebhook *DecisionWebhook `yaml:"decision_webhook"`
+}
+
+// DefaultRules returns the built-in rules used when no rule definitions are configured
+func DefaultRules() *Rules {
+	return &Rules{
+		Defaults: Limits{
+			MaxDuration:     24 * time.Hour,
+			MinDuration:     5 * time.Minute,
+			MinReasonLength: 1,
+			// Requests need a human approval unless a tier or override allows auto-approval
+			Approvals: intPtr(1),
+		},
+		Tiers: map[string]Limits{
+			TierPublic: {
+				Approvals: intPtr(0),
+			},
+			TierInternal: {
+				Approvals: intPtr(1),
+			},
+			TierConfidential: {
+				MaxDuration:   8 * time.Hour,
+				AllowedLevels: []models.PrivilegeLevel{models.PrivilegeLevelRead, models.PrivilegeLevelWrite, models.PrivilegeLevelAdmin},
+				Approvals:     intPtr(1),
+			},
+			TierRestricted: {
+				MaxDuration:   time.Hour,
+				AllowedLevels: []models.PrivilegeLevel{models.PrivilegeLevelRead, models.PrivilegeLevelWrite},
+				Approvals:     intPtr(2),
+				Notify:        []string{"security"},
+				// Admin and root access, where allowed, is approved with a hardware key
+				WebAuthnLevels: []models.PrivilegeLevel{models.PrivilegeLevelAdmin, models.PrivilegeLevelRoot},
+			},
+		},
+	}
+}
+
+// Resolve returns the effective limits for a resource of the given module,
+// applying the resource's tier, module and resource overrides on top of the defaults
+func (r *Rules) Resolve(module, resourceID string) Limits {
+	builtin := DefaultRules()
+	limits := builtin.Defaults.merge(r.Defaults)
+
+	tier := r.Resources[resourceID].Tier
+	if tier != "" {
+		limits = limits.merge(builtin.Tiers[tier]).merge(r.Tiers[tier])
+	}
+
+	if moduleRules, ok := r.Modules[module]; ok {
+		limits = limits.merge(moduleRules.Limits)
+		if tierLimits, ok := moduleRules.Tiers[tier]; ok && tier != "" {
+			limits = limits.merge(tierLimits)
+		}
+	}
+
+	return limits.merge(r.Resources[resourceID].Limits)
+}
+
+// Service returns the on-call service of a resource
+func (r *Rules) Service(resourceID string) string {
+	if service := r.Resources[resourceID].Service; service != "" {
+		return service
+	}
+	return resourceID
+}
+
+// resourceAttributes describes a resource to expression rules
+func (r *Rules) resourceAttributes(module, resourceID string, tags []string) map[string]interface{} {
+	tagList := make([]interface{}, len(tags))
+	for i, tag := range tags {
+		tagList[i] = tag
+	}
+	return map[string]interface{}{
+		"id":          resourceID,
+		"module":      module,
+		"tier":        r.Resources[resourceID].Tier,
+		"tags":        tagList,
+		"owner":       r.Resources[resourceID].Owner,
+		"environment": r.Resources[resourceID].Environment,
+	}
+}
+
+// merge returns l with every non-zero field of override applied
+func (l Limits) merge(override Limits) Limits {
+	if override.MaxDuration != 0 {
+		l.MaxDuration = override.MaxDuration
+	}
+	if override.MinDuration != 0 {
+		l.MinDuration = override.MinDuration
+	}
+	if override.MinReasonLength != 0 {
+		l.MinReasonLength = override.MinReasonLength
+	}
+	if override.AllowedLevels != nil {
+		l.AllowedLevels = override.AllowedLevels
+	}
+	if len(override.LevelMaxDuration) > 0 {
+		levels := make(map[models.PrivilegeLevel]time.Duration, len(l.LevelMaxDuration)+len(override.LevelMaxDuration))
+		for level, d := range l.LevelMaxDuration {
+			levels[level] = d
+		}
+		for level, d := range override.LevelMaxDuration {
+			levels[level] = d
+		}
+		l.LevelMaxDuration = levels
+	}
+	if override.Approvals != nil {
+		l.Approvals = override.Approvals
+	}
+	if override.Notify != nil {
+		l.Notify = override.Notify
+	}
+	if override.BindSource != nil {
+		l.BindSource = override.BindSource
+	}
+	if override.BindPrefix != 0 {
+		l.BindPrefix = override.BindPrefix
+	}
+	if override.WebAuthnLevels != nil {
+		l.WebAuthnLevels = override.WebAuthnLevels
+	}
+	return l
+}
+
+// MaxDurationFor returns the maximum duration for the privilege level, taking the
+// stricter of the general and the level-specific limit. Zero means unlimited.
+func (l Limits) MaxDurationFor(level models.PrivilegeLevel) time.Duration {
+	maxDuration := l.MaxDuration
+	if d, ok := l.LevelMaxDuration[level]; ok && d > 0 && (maxDuration == 0 || d < maxDuration) {
+		maxDuration = d
+	}
+	return maxDuration
+}
+
+// RequiredApprovals returns the number of approvals required by the limits
+func (l Limits) RequiredApprovals() int {
+	if l.Approvals == nil {
+		return 0
+	}
+	return *l.Approvals
+}
+
+func intPtr(v int) *int {
+	return &v
+}
+
+// allowsLevel reports whether the level is permitted by the limits
+func (l Limits) allowsLevel(level models.PrivilegeLevel) bool {
+	if len(l.AllowedLevels) == 0 {
+		return true
+	}
+	for _, allowed := range l.AllowedLevels {
+		if allowed == level {
+			return true
+		}
+	}
+	return false
+}
+
+// requiresWebAuthn reports whether approvers of the level must confirm with a hardware key
+func (l Limits) requiresWebAuthn(level models.PrivilegeLevel) bool {
+	for _, required := range l.WebAuthnLevels {
+		if required == level {
+			return true
+		}
+	}
+	return false
+}
+
+// validate checks the rule definitions for inconsistencies
+func (r *Rules) validate() error {
+	check := func(scope string, l Limits) error {
+		if l.MaxDuration < 0 || l.MinDuration < 0 {
+			return fmt.Errorf("%s: durations must not be negative", scope)
+		}
+		if l.MaxDuration != 0 && l.MinDuration > l.MaxDuration {
+			return fmt.Errorf("%s: min_duration exceeds max_duration", scope)
+		}
+		if l.MinReasonLength < 0 {
+			return fmt.Errorf("%s: min_reason_length must not be negative", scope)
+		}
+		for level, d := range l.LevelMaxDuration {
+			if d < 0 {
+				return fmt.Errorf("%s: level_max_duration.%s must not be negative", scope, level)
+			}
+		}
+		if l.Approvals != nil && *l.Approvals < 0 {
+			return fmt.Errorf("%s: approvals must not be negative", scope)
+		}
+		if l.BindPrefix < 0 || l.BindPrefix > 128 {
+			return fmt.Errorf("%s: bind_prefix must be between 0 and 128", scope)
+		}
+		return nil
+	}
+
+	if err := check("defaults", r.Defaults); err != nil {
+		return err
+	}
+	for tier, limits := range r.Tiers {
+		if err := check("tiers."+tier, limits); err != nil {
+			return err
+		}
+	}
+	if err := r.validateAccessRules(); err != nil {
+		return err
+	}
+	if err := r.validateApprovalRoutes(); err != nil {
+		return err
+	}
+	if err := r.validateTagRules(); err != nil {
+		return err
+	}
+	if err := r.validateOnCallPolicies(); err != nil {
+		return err
+	}
+	if err := r.RateLimits.validate(); err != nil {
+		return err
+	}
+	if r.StepUp != nil {
+		if err := r.StepUp.validate(); err != nil {
+			return err
+		}
+	}
+	if r.DecisionWebhook != nil {
+		if err := r.DecisionWebhook.validate(); err != nil {
+			return err
+		}
+	}
+	builtin := DefaultRules()
+	for id, resource := range r.Resources {
+		if err := check("resources."+id, resource.Limits); err != nil {
+			return err
+		}
+		if resource.Tier == "" {
+			continue
+		}
+		if _, ok := builtin.Tiers[resource.Tier]; ok {
+			continue
+		}
+		if _, ok := r.Tiers[resource.Tier]; ok {
+			continue
+		}
+		if !r.moduleDefinesTier(resource.Tier) {
+			return fmt.Errorf("resources.%s: unknown tier %q", id, resource.Tier)
+		}
+	}
+	for name, module := range r.Modules {
+		if err := check("modules."+name, module.Limits); err != nil {
+			return err
+		}
+		for tier, limits := range module.Tiers {
+			if err := check(fmt.Sprintf("modules.%s.tiers.%s", name, tier), limits); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// moduleDefinesTier reports whether any module has limits for the tier
+func (r *Rules) moduleDefinesTier(tier string) bool {
+	for _, module := range r.Modules {
+		if _, ok := module.Tiers[tier]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Source loads rule definitions from a backing store such as a YAML file or database
+type Source interface {
+	// Load returns the current rule definitions
+	Load(ctx context.Context) (*Rules, error)
+}
+
+// FileSource loads rule definitions from a YAML file
+type FileSource struct {
+	Path string
+}
+
+// Load reads and parses the YAML rule definitions
+func (s *FileSource) Load(ctx context.Context) (*Rules, error) {
+	data, err := os.ReadFile(s.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read rules file: %v", err)
+	}
+	return ParseRules(data)
+}
+
+// ParseRules parses, validates and compiles YAML rule definitions
+func ParseRules(data []byte) (*Rules, error) {
+	var rules Rules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("failed to parse rules: %v", err)
+	}
+
+	if err := rules.validate(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+
+	if err := rules.compileNetworkPolicies(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+
+	if err := rules.compileTimePolicies(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+
+	if err := rules.Risk.compile(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+
+	if err := rules.compileExpressions(); err != nil {
+		return nil, fmt.Errorf("invalid rules: %v", err)
+	}
+
+	return &rules, nil
+}
+
+// Reloader periodically reloads rule definitions from a source into an engine
+type Reloader struct {
+	source   Source
+	engine   *DefaultRuleEngine
+	interval time.Duration
+}
+
+// NewReloader creates a reloader that refreshes the engine's rules at the given interval
+func NewReloader(source Source, engine *DefaultRuleEngine, interval time.Duration) *Reloader {
+	return &Reloader{
+		source:   source,
+		engine:   engine,
+		interval: interval,
+	}
+}
+
+// Reload loads the rules once and applies them to the engine. On failure the
+// previously loaded rules stay in effect.
+func (r *Reloader) Reload(ctx context.Context) error {
+	rules, err := r.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	// Sources with staged rollout also provide the rules evaluated in shadow mode
+	if shadowSource, ok := r.source.(ShadowSource); ok {
+		shadow, err := shadowSource.LoadShadow(ctx)
+		if err != nil {
+			return err
+		}
+		r.engine.SetShadowRules(shadow)
+	}
+
+	r.engine.SetRules(rules)
+	return nil
+}
+
+// Start reloads the rules in the background until the context is cancelled
+func (r *Reloader) Start(ctx context.Context) {
+	go func() {
+		ticker := time.NewTicker(r.interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := r.Reload(ctx); err != nil {
+					log.Printf("Failed to reload rules: %v", err)
+				}
+			}
+		}
+	}()
+}
@@ -0,0 +1,117 @@
+package rules
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Trace results
+const (
+	ResultPassed   = "passed"
+	ResultViolated = "violated"
+	ResultMatched  = "matched"
+)
+
+// TraceStep records the outcome of a single rule
+type TraceStep struct {
+	Rule    string `json:"rule"`
+	Result  string `json:"result"`
+	Message string `json:"message,omitempty"`
+}
+
+// Decision is the full outcome of evaluating a request, including the trace of every rule
+type Decision struct {
+	Allowed           bool                      `json:"allowed"`
+	PolicyVersion     int                       `json:"policy_version,omitempty"`
+	Violations        []Violation               `json:"violations,omitempty"`
+	RequiredApprovals int                       `json:"required_approvals"`
+	MaxDuration       string                    `json:"max_duration,omitempty"`
+	Notify            []string                  `json:"notify,omitempty"`
+	Approvers         []string                  `json:"approvers,omitempty"`
+	StepUp            *models.StepUpRequirement `json:"step_up,omitempty"`
+	BoundTo           string                    `json:"bound_to,omitempty"`
+	WebAuthnApproval  bool                      `json:"webauthn_approval,omitempty"`
+	RiskScore         int                       `json:"risk_score"`
+	RiskFactors       []string                  `json:"risk_factors,omitempty"`
+	Trace             []TraceStep               `json:"trace"`
+}
+
+// Simulator is implemented by rule engines that can explain a decision without
+// acting on it
+type Simulator interface {
+	// Simulate evaluates a hypothetical request and returns the decision trace
+	Simulate(request *models.PrivilegeRequest) (*Decision, error)
+}
+
+// record appends a step to the trace; it is a no-op outside simulations
+func (d *Decision) record(rule, result, message string) {
+	if d == nil {
+		return
+	}
+	d.Trace = append(d.Trace, TraceStep{Rule: rule, Result: result, Message: message})
+}
+
+// violated handles a failed rule. During simulation policy violations are recorded
+// and evaluation continues; otherwise, and for internal errors, the error is returned.
+func (d *Decision) violated(err error) error {
+	var violationErr *ViolationError
+	if d == nil || !errors.As(err, &violationErr) {
+		return err
+	}
+	d.Violations = append(d.Violations, violationErr.Violations...)
+	for _, v := range violationErr.Violations {
+		d.record(v.Rule, ResultViolated, v.Message)
+	}
+	return nil
+}
+
+// violation builds a ViolationError for a single rule
+func violation(rule, format string, args ...interface{}) error {
+	return &ViolationError{Violations: []Violation{{Rule: rule, Message: fmt.Sprintf(format, args...)}}}
+}
+
+// Simulate evaluates a hypothetical request against every rule without side effects
+func (e *DefaultRuleEngine) Simulate(request *models.PrivilegeRequest) (*Decision, error) {
+	simulated := *request
+	simulated.StepUp = nil
+
+	decision := &Decision{}
+	if err := e.evaluate(&simulated, decision); err != nil {
+		return nil, err
+	}
+
+	limits := e.Rules().Resolve(request.Module, request.ResourceID)
+	decision.Allowed = len(decision.Violations) == 0
+	decision.PolicyVersion = simulated.PolicyVersion
+	decision.RequiredApprovals = simulated.RequiredApprovals
+	decision.MaxDuration = formatMaxDuration(limits.MaxDurationFor(request.Level))
+	decision.Notify = simulated.Notify
+	decision.Approvers = simulated.Approvers
+	decision.StepUp = simulated.StepUp
+	decision.BoundTo = simulated.BoundTo
+	decision.WebAuthnApproval = simulated.WebAuthnApproval
+	decision.RiskScore = simulated.RiskScore
+	decision.RiskFactors = simulated.RiskFactors
+	return decision, nil
+}
+
+// Simulate queries OPA for the decision on a hypothetical request. OPA policies
+// only report violations, so the trace lists those.
+func (e *OPAEngine) Simulate(request *models.PrivilegeRequest) (*Decision, error) {
+	decision := &Decision{}
+	if err := decision.violated(e.EvaluateRequest(request)); err != nil {
+		return nil, err
+	}
+	decision.Allowed = len(decision.Violations) == 0
+	return decision, nil
+}
+
+func formatMaxDuration(d time.Duration) string {
+	if d == 0 {
+		return "unlimited"
+	}
+	return d.String()
+}
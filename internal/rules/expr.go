@@ -0,0 +1,83 @@
+package rules
+
+import (
+	"fmt"
+
+	"github.com/google/cel-go/cel"
+)
+
+// Expression rules are written in the Common Expression Language (CEL) and
+// compiled with cel-go against an environment declaring the variables
+// expressionInput provides. Expressions are checked when they are compiled,
+// so references to unknown variables or functions and mistyped operands are
+// refused when the rules are loaded.
+
+// expressionEnv declares the variables expression rules can read
+var expressionEnv = newExpressionEnv()
+
+// newExpressionEnv creates the CEL environment of expression rules. The
+// request and resource are maps, so their fields are only known when a
+// request is evaluated.
+func newExpressionEnv() *cel.Env {
+	env, err := cel.NewEnv(
+		cel.Variable("request", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("resource", cel.MapType(cel.StringType, cel.DynType)),
+		cel.Variable("duration", cel.DurationType),
+		cel.Variable("now", cel.TimestampType),
+		// Numbers decoded from the request's JSON are doubles; let them be
+		// compared with integer literals such as request.risk_score >= 30
+		cel.CrossTypeNumericComparisons(true),
+	)
+	if err != nil {
+		panic(fmt.Sprintf("invalid expression environment: %v", err))
+	}
+	return env
+}
+
+// expression is a compiled CEL expression
+type expression struct {
+	program cel.Program
+	output  *cel.Type
+}
+
+// parseExpression parses and type-checks a CEL expression and plans its program
+func parseExpression(src string) (*expression, error) {
+	ast, issues := expressionEnv.Compile(src)
+	if issues != nil && issues.Err() != nil {
+		return nil, issues.Err()
+	}
+	program, err := expressionEnv.Program(ast)
+	if err != nil {
+		return nil, err
+	}
+	return &expression{program: program, output: ast.OutputType()}, nil
+}
+
+// returnsBool reports whether the expression can produce a bool. Expressions
+// reading request or resource fields are only typed when they run.
+func (e *expression) returnsBool() bool {
+	return e.output.IsExactType(cel.BoolType) || e.output.IsExactType(cel.DynType)
+}
+
+// eval runs the expression against the variables and returns its value as a
+// Go value. Reading a key the request or resource lacks is an error.
+func (e *expression) eval(vars map[string]interface{}) (interface{}, error) {
+	value, _, err := e.program.Eval(vars)
+	if err != nil {
+		return nil, err
+	}
+	return value.Value(), nil
+}
+
+// evalBool evaluates an expression that must produce a bool
+func evalBool(expr *expression, vars map[string]interface{}) (bool, error) {
+	value, err := expr.eval(vars)
+	if err != nil {
+		return false, err
+	}
+	b, ok := value.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, got %T", value)
+	}
+	return b, nil
+}
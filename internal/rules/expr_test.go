@@ -0,0 +1,303 @@
+package rules
+
+import (
+	"errors"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// testVars is the input the expression tests evaluate against, shaped like
+// expressionInput's
+func testVars() map[string]interface{} {
+	return map[string]interface{}{
+		"request": map[string]interface{}{
+			"user_id": "alice",
+			"level":   "admin",
+			"reason":  "debug order 42",
+			// Numbers decoded from JSON are float64
+			"risk_score": 40.0,
+		},
+		"resource": map[string]interface{}{
+			"id":   "orders",
+			"tier": "critical",
+			"tags": []interface{}{"pii", "gdpr"},
+		},
+		"duration": 2 * time.Hour,
+		"now":      time.Now().UTC(),
+	}
+}
+
+// evalSource parses and evaluates an expression
+func evalSource(src string, vars map[string]interface{}) (interface{}, error) {
+	expr, err := parseExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	return expr.eval(vars)
+}
+
+func TestExpressionPrecedence(t *testing.T) {
+	tests := []struct {
+		src  string
+		want interface{}
+	}{
+		{"1 + 2 * 3", int64(7)},
+		{"(1 + 2) * 3", int64(9)},
+		{"10 - 4 - 3", int64(3)},
+		{"12 / 2 / 3", int64(2)},
+		{"7 % 4 * 2", int64(6)},
+		{"-2 * 3", int64(-6)},
+		{"- -2", int64(2)},
+		{"1.5 * 2.0", 3.0},
+		{"1 + 2 == 3", true},
+		{"1 < 2 == true", true},
+		{"true || false && false", true},
+		{"(true || false) && false", false},
+		{"false && true || true", true},
+		{"!false && false", false},
+		{"!(false && false)", true},
+		{"!true == false", true},
+		{"1 + 1 == 2 && 'pii' in resource.tags", true},
+		{"request.level in ['admin', 'root'] || resource.tier == 'low'", true},
+		{"1 < 2 ? 'yes' : 'no'", "yes"},
+		{"false ? 1 : true ? 2 : 3", int64(2)},
+		{"true ? (false ? 1 : 2) : 3", int64(2)},
+		{"1 + 1 > 1 ? 'more' : 'less'", "more"},
+		{"duration > duration('1h') + duration('30m')", true},
+		{"now > timestamp('2020-01-01T00:00:00Z')", true},
+		{"'a' + 'b' == 'ab'", true},
+		{"resource.tags[1] == 'gdpr'", true},
+		{"request['level'].startsWith('ad')", true},
+		{"size(resource.tags) * 2", int64(4)},
+		{"request.risk_score >= 30", true},
+		{"request.risk_score == 40", true},
+	}
+	for _, tt := range tests {
+		got, err := evalSource(tt.src, testVars())
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v (%T), want %v (%T)", tt.src, got, got, tt.want, tt.want)
+		}
+	}
+}
+
+func TestExpressionShortCircuit(t *testing.T) {
+	tests := []struct {
+		src     string
+		want    interface{}
+		wantErr string
+	}{
+		// A side that decides the result hides an error on the other side,
+		// whichever side it is on
+		{src: "false && request.missing == 'x'", want: false},
+		{src: "request.missing == 'x' && false", want: false},
+		{src: "true || request.missing == 'x'", want: true},
+		{src: "request.missing == 'x' || true", want: true},
+		{src: "false && 1 / 0 == 1", want: false},
+		{src: "true ? 1 : 1 / 0", want: int64(1)},
+		{src: "false ? 1 / 0 : 2", want: int64(2)},
+		// Otherwise the error surfaces
+		{src: "true && request.missing == 'x'", wantErr: "no such key: missing"},
+		{src: "request.missing == 'x' || false", wantErr: "no such key: missing"},
+		{src: "true ? request.missing : 2", wantErr: "no such key: missing"},
+		// in evaluates both of its operands
+		{src: "request.missing in []", wantErr: "no such key: missing"},
+		{src: "'pii' in request.missing", wantErr: "no such key: missing"},
+		{src: "'pii' in resource.tags", want: true},
+		{src: "'pci' in resource.tags", want: false},
+		{src: "'tier' in resource", want: true},
+		{src: "'owner' in resource", want: false},
+	}
+	for _, tt := range tests {
+		got, err := evalSource(tt.src, testVars())
+		if tt.wantErr != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("%s: error %v, want %q", tt.src, err, tt.wantErr)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%s: unexpected error: %v", tt.src, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s = %v, want %v", tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestExpressionTypeMismatch(t *testing.T) {
+	// Operands of known types are checked when the expression is compiled
+	compileErrors := []struct {
+		src     string
+		wantErr string
+	}{
+		{"1 + 'a'", "found no matching overload for '_+_' applied to '(int, string)'"},
+		{"'a' - 'b'", "found no matching overload for '_-_' applied to '(string, string)'"},
+		{"duration + 1", "found no matching overload for '_+_' applied to '(duration, int)'"},
+		{"'a' < 1", "found no matching overload for '_<_' applied to '(string, int)'"},
+		{"1 && true", "expected type 'bool' but found 'int'"},
+		{"true || 'yes'", "expected type 'bool' but found 'string'"},
+		{"!1", "found no matching overload for '!_' applied to '(int)'"},
+		{"-'a'", "found no matching overload for '-_' applied to '(string)'"},
+		{"'yes' ? 1 : 2", "found no matching overload for '_?_:_' applied to '(string, int, int)'"},
+		{"'a' in 'abc'", "found no matching overload for '@in' applied to '(string, string)'"},
+		{"1 in resource", "found no matching overload for '@in' applied to '(int, map(string, dyn))'"},
+		{"size(1)", "found no matching overload for 'size' applied to '(int)'"},
+		{"request.level.startsWith(1)", "found no matching overload for 'startsWith' applied to 'dyn.(int)'"},
+		{"resource[0]", "found no matching overload for '_[_]' applied to '(map(string, dyn), int)'"},
+		{"1 == '1'", "found no matching overload for '_==_' applied to '(int, string)'"},
+		{"duration == 7200", "found no matching overload for '_==_' applied to '(duration, int)'"},
+	}
+	for _, tt := range compileErrors {
+		_, err := parseExpression(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.src, err, tt.wantErr)
+		}
+	}
+
+	// Fields of the request and resource are only typed when they are read
+	evalErrors := []struct {
+		src     string
+		wantErr string
+	}{
+		{"resource.tags > 1", "no such overload"},
+		{"request.level.matches('[')", "error parsing regexp"},
+		{"resource.tags['pii']", "unsupported index type 'string' in list"},
+		{"resource.tags[0.5]", "unsupported index value 0.5 in list"},
+		{"request.level.length", "no such key: length"},
+		{"request.level[0]", "no such key: 0"},
+		{"1 / 0", "division by zero"},
+		{"duration('soon')", "type conversion error from 'string' to 'google.protobuf.Duration'"},
+	}
+	for _, tt := range evalErrors {
+		expr, err := parseExpression(tt.src)
+		if err != nil {
+			t.Errorf("%s: unexpected compile error: %v", tt.src, err)
+			continue
+		}
+		if _, err := expr.eval(testVars()); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.src, err, tt.wantErr)
+		}
+	}
+
+	// Equality of values of different types read from the request is false
+	for _, src := range []string{"request.level == 1", "request == resource", "request.risk_score == 'high'"} {
+		got, err := evalSource(src, testVars())
+		if err != nil || got != false {
+			t.Errorf("%s = %v, %v; want false", src, got, err)
+		}
+	}
+}
+
+func TestExpressionMissingKeys(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantErr string
+	}{
+		{"request.ticket == ''", "no such key: ticket"},
+		{"request['ticket'] == ''", "no such key: ticket"},
+		{"!(request.ticket == '')", "no such key: ticket"},
+		{"request.ticket != 'OPS-1'", "no such key: ticket"},
+		{"request.ticket.startsWith('OPS-')", "no such key: ticket"},
+		{"size(request.ticket) == 0", "no such key: ticket"},
+		{"request.metadata.ticket == ''", "no such key: metadata"},
+		{"resource.tags[5] == 'pii'", "index out of bounds: 5"},
+		{"user == 'alice'", "undeclared reference to 'user'"},
+	}
+	for _, tt := range tests {
+		_, err := evalSource(tt.src, testVars())
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%s: error %v, want %q", tt.src, err, tt.wantErr)
+		}
+	}
+}
+
+// TestExpressionRulesFailClosed checks a rule whose expression reads a key the
+// request lacks rejects the request, whether the rule denies or requires
+// approvals, rather than being skipped as not matching. CEL's logical
+// operators are commutative, so this holds unless another operand decides
+// the result, as TestExpressionShortCircuit shows.
+func TestExpressionRulesFailClosed(t *testing.T) {
+	tests := []ExpressionRule{
+		{Name: "internal-only", When: "!request.source_ip.startsWith('10.')", Deny: true},
+		{Name: "ticket-required", When: "request.ticket == ''", Deny: true},
+		{Name: "pii-review", When: "request.ticket == '' && resource.id == 'orders'", RequiredApprovals: 2},
+	}
+	for _, rule := range tests {
+		rules := &Rules{Expressions: []ExpressionRule{rule}}
+		if err := rules.compileExpressions(); err != nil {
+			t.Fatalf("%s: %v", rule.Name, err)
+		}
+		engine := NewDefaultRuleEngine(rules)
+		request := &models.PrivilegeRequest{
+			UserID:      "alice",
+			Module:      "mysql",
+			ResourceID:  "orders",
+			Level:       models.PrivilegeLevelRead,
+			RequestedAt: time.Now(),
+			ExpiresAt:   time.Now().Add(time.Hour),
+		}
+
+		err := engine.evaluateExpressions(request, nil, nil)
+		if err == nil {
+			t.Errorf("%s: request without the key was allowed", rule.Name)
+			continue
+		}
+		if !strings.Contains(err.Error(), "failed to evaluate expression rule "+rule.Name) {
+			t.Errorf("%s: unexpected error: %v", rule.Name, err)
+		}
+		var violation *ViolationError
+		if errors.As(err, &violation) {
+			t.Errorf("%s: evaluation error reported as a violation: %v", rule.Name, err)
+		}
+	}
+}
+
+func TestExpressionParseErrors(t *testing.T) {
+	tests := []struct {
+		src     string
+		wantErr string
+	}{
+		{"", "<input>:1:0: Syntax error: mismatched input '<EOF>'"},
+		{"1 +", "<input>:1:4: Syntax error: mismatched input '<EOF>'"},
+		{"(1 + 2", "<input>:1:7: Syntax error: missing ')' at '<EOF>'"},
+		{"[1, 2", "Syntax error"},
+		{"1 2", "<input>:1:3: Syntax error: extraneous input '2' expecting <EOF>"},
+		{"a b", "Syntax error: extraneous input 'b' expecting <EOF>"},
+		{"true ? 1", "Syntax error"},
+		{"'unterminated", "<input>:1:1: Syntax error: token recognition error at: ''unterminated'"},
+		{"1 # 2", "<input>:1:3: Syntax error: token recognition error at: '#'"},
+		{"request.1", "<input>:1:8: Syntax error: extraneous input '.1' expecting <EOF>"},
+		{"exec('rm')", "undeclared reference to 'exec'"},
+		{"resource.tags[]", "<input>:1:15: Syntax error: mismatched input ']'"},
+		{")", "Syntax error"},
+	}
+	for _, tt := range tests {
+		_, err := parseExpression(tt.src)
+		if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+			t.Errorf("%q: error %v, want %q", tt.src, err, tt.wantErr)
+		}
+	}
+
+	// Rules with a syntax or type error, or whose condition is not a bool,
+	// are refused when they are loaded
+	for src, wantErr := range map[string]string{
+		"request.level ==":        "Syntax error",
+		"request.level == 1 + ''": "found no matching overload",
+		"size(resource.tags)":     "condition must be a bool, not int",
+	} {
+		rules := &Rules{Expressions: []ExpressionRule{{Name: "broken", When: src, Deny: true}}}
+		err := rules.compileExpressions()
+		if err == nil || !strings.Contains(err.Error(), "expression rule broken") || !strings.Contains(err.Error(), wantErr) {
+			t.Errorf("compileExpressions(%q): error %v, want the rule named and %q", src, err, wantErr)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package rules
+
+import (
+	"slices"
+	"sort"
+)
+
+// GroupDirectory resolves the identity provider groups policies refer to
+type GroupDirectory interface {
+	// GroupMembers returns the members of the named group and whether it exists
+	GroupMembers(name string) ([]string, bool)
+}
+
+// SetGroupDirectory configures the directory the groups named in access rules
+// and approval routes are resolved with
+func (e *DefaultRuleEngine) SetGroupDirectory(directory GroupDirectory) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.groups = directory
+}
+
+// groupDirectory returns the configured group directory, or nil
+func (e *DefaultRuleEngine) groupDirectory() GroupDirectory {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	return e.groups
+}
+
+// groupMembers returns the members of a group, none without a directory
+func groupMembers(groups GroupDirectory, name string) []string {
+	if groups == nil {
+		return nil
+	}
+	members, _ := groups.GroupMembers(name)
+	return members
+}
+
+// ReferencedGroups returns the groups named by the access rules and approval
+// routes, sorted
+func (r *Rules) ReferencedGroups() []string {
+	var groups []string
+	add := func(p Principals) {
+		for _, group := range p.Groups {
+			if !slices.Contains(groups, group) {
+				groups = append(groups, group)
+			}
+		}
+	}
+	for _, rule := range r.AccessRules {
+		add(rule.Allow)
+		add(rule.Deny)
+	}
+	for _, route := range r.ApprovalRoutes {
+		add(route.Approvers)
+		for _, step := range route.Steps {
+			add(step.Approvers)
+		}
+	}
+	sort.Strings(groups)
+	return groups
+}
@@ -0,0 +1,161 @@
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// TestSuite is a set of declarative policy test cases, e.g.
+//
+//	tests:
+//	  - name: root on production is denied
+//	    request: {user_id: alice, resource_id: prod-mysql-1, level: root, reason: "...", duration: 30m}
+//	    expect: {allowed: false, violations: [allowed_levels]}
+type TestSuite struct {
+	Tests []TestCase `yaml:"tests" json:"tests"`
+}
+
+// TestCase describes a hypothetical request and the decision expected for it
+type TestCase struct {
+	Name    string      `yaml:"name" json:"name"`
+	Request TestRequest `yaml:"request" json:"request"`
+	Expect  Expectation `yaml:"expect" json:"expect"`
+}
+
+// TestRequest is the request evaluated by a test case
+type TestRequest struct {
+	UserID      string                `yaml:"user_id" json:"user_id"`
+	Module      string                `yaml:"module" json:"module"`
+	ResourceID  string                `yaml:"resource_id" json:"resource_id"`
+	Level       models.PrivilegeLevel `yaml:"level" json:"level"`
+	Reason      string                `yaml:"reason" json:"reason"`
+	Duration    time.Duration         `yaml:"duration" json:"duration"`
+	SourceIP    string                `yaml:"source_ip" json:"source_ip"`
+	RequestedAt time.Time             `yaml:"requested_at" json:"requested_at"`
+}
+
+// Expectation lists the properties the decision must have; unset fields are not checked
+type Expectation struct {
+	Allowed           *bool    `yaml:"allowed" json:"allowed,omitempty"`
+	Violations        []string `yaml:"violations" json:"violations,omitempty"`
+	Message           string   `yaml:"message" json:"message,omitempty"`
+	RequiredApprovals *int     `yaml:"required_approvals" json:"required_approvals,omitempty"`
+	StepUp            *bool    `yaml:"step_up" json:"step_up,omitempty"`
+}
+
+// TestResult is the outcome of a single test case
+type TestResult struct {
+	Name     string    `json:"name"`
+	Passed   bool      `json:"passed"`
+	Failures []string  `json:"failures,omitempty"`
+	Decision *Decision `json:"decision,omitempty"`
+}
+
+// TestReport is the outcome of a test suite
+type TestReport struct {
+	PolicyVersion int          `json:"policy_version,omitempty"`
+	Passed        int          `json:"passed"`
+	Failed        int          `json:"failed"`
+	Results       []TestResult `json:"results"`
+}
+
+// ParseTestSuite parses a YAML or JSON test suite
+func ParseTestSuite(data []byte) (*TestSuite, error) {
+	var suite TestSuite
+	if err := yaml.Unmarshal(data, &suite); err != nil {
+		return nil, fmt.Errorf("failed to parse test suite: %v", err)
+	}
+	if len(suite.Tests) == 0 {
+		return nil, fmt.Errorf("test suite contains no tests")
+	}
+	for i, test := range suite.Tests {
+		if test.Name == "" {
+			return nil, fmt.Errorf("test %d: name is required", i)
+		}
+		if test.Request.ResourceID == "" || test.Request.Level == "" {
+			return nil, fmt.Errorf("test %s: request resource_id and level are required", test.Name)
+		}
+	}
+	return &suite, nil
+}
+
+// RunTests evaluates every test case of the suite with the simulator
+func RunTests(simulator Simulator, suite *TestSuite) (*TestReport, error) {
+	report := &TestReport{}
+	for _, test := range suite.Tests {
+		requestedAt := test.Request.RequestedAt
+		if requestedAt.IsZero() {
+			requestedAt = time.Now().UTC()
+		}
+
+		decision, err := simulator.Simulate(&models.PrivilegeRequest{
+			UserID:      test.Request.UserID,
+			Module:      test.Request.Module,
+			ResourceID:  test.Request.ResourceID,
+			Level:       test.Request.Level,
+			Reason:      test.Request.Reason,
+			SourceIP:    test.Request.SourceIP,
+			RequestedAt: requestedAt,
+			ExpiresAt:   requestedAt.Add(test.Request.Duration),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("test %s: %v", test.Name, err)
+		}
+
+		result := TestResult{Name: test.Name, Decision: decision}
+		result.Failures = test.Expect.check(decision)
+		result.Passed = len(result.Failures) == 0
+		if result.Passed {
+			report.Passed++
+		} else {
+			report.Failed++
+		}
+		report.PolicyVersion = decision.PolicyVersion
+		report.Results = append(report.Results, result)
+	}
+	return report, nil
+}
+
+// check returns a description of every way the decision differs from the expectation
+func (x Expectation) check(decision *Decision) []string {
+	var failures []string
+	if x.Allowed != nil && decision.Allowed != *x.Allowed {
+		failures = append(failures, fmt.Sprintf("expected allowed=%t, got %t", *x.Allowed, decision.Allowed))
+	}
+
+	violated := make([]string, 0, len(decision.Violations))
+	for _, v := range decision.Violations {
+		violated = append(violated, v.Rule)
+	}
+	for _, rule := range x.Violations {
+		if !slices.Contains(violated, rule) {
+			failures = append(failures, fmt.Sprintf("expected rule %s to be violated, violated: %v", rule, violated))
+		}
+	}
+	if x.Message != "" {
+		found := false
+		for _, v := range decision.Violations {
+			if strings.Contains(v.Message, x.Message) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			failures = append(failures, fmt.Sprintf("expected a violation mentioning %q", x.Message))
+		}
+	}
+
+	if x.RequiredApprovals != nil && decision.RequiredApprovals != *x.RequiredApprovals {
+		failures = append(failures, fmt.Sprintf("expected %d required approvals, got %d", *x.RequiredApprovals, decision.RequiredApprovals))
+	}
+	if x.StepUp != nil && (decision.StepUp != nil) != *x.StepUp {
+		failures = append(failures, fmt.Sprintf("expected step_up=%t, got %t", *x.StepUp, decision.StepUp != nil))
+	}
+	return failures
+}
@@ -0,0 +1,97 @@
+package rules
+
+import (
+	"fmt"
+	"net/netip"
+	"slices"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// NetworkPolicy restricts the source networks requests may be submitted from,
+// e.g. admin-level grants only from the corporate VPN ranges
+type NetworkPolicy struct {
+	Name    string   `yaml:"name"`
+	Modules []string `yaml:"modules"`
+	Levels  []string `yaml:"levels"`
+
+	// AllowedCIDRs lists the networks requests covered by the policy must come from
+	AllowedCIDRs []string `yaml:"allowed_cidrs"`
+
+	prefixes []netip.Prefix
+}
+
+// compileNetworkPolicies parses the CIDRs of every network policy
+func (r *Rules) compileNetworkPolicies() error {
+	for i := range r.NetworkPolicies {
+		policy := &r.NetworkPolicies[i]
+		if policy.Name == "" {
+			return fmt.Errorf("network policy %d: name is required", i)
+		}
+		if len(policy.AllowedCIDRs) == 0 {
+			return fmt.Errorf("network policy %s: allowed_cidrs is required", policy.Name)
+		}
+
+		policy.prefixes = make([]netip.Prefix, 0, len(policy.AllowedCIDRs))
+		for _, cidr := range policy.AllowedCIDRs {
+			prefix, err := netip.ParsePrefix(cidr)
+			if err != nil {
+				return fmt.Errorf("network policy %s: invalid cidr %q: %v", policy.Name, cidr, err)
+			}
+			policy.prefixes = append(policy.prefixes, prefix.Masked())
+		}
+	}
+	return nil
+}
+
+// applies reports whether the policy covers the request
+func (p *NetworkPolicy) applies(request *models.PrivilegeRequest) bool {
+	if len(p.Modules) > 0 && !slices.Contains(p.Modules, request.Module) {
+		return false
+	}
+	if len(p.Levels) > 0 && !slices.Contains(p.Levels, string(request.Level)) {
+		return false
+	}
+	return true
+}
+
+// allows reports whether the source address lies within one of the allowed networks.
+// Requests without a known source address are rejected.
+func (p *NetworkPolicy) allows(sourceIP string) bool {
+	addr, err := netip.ParseAddr(sourceIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range p.prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// evaluateSourceBinding binds the credentials of the request to the
+// requester's source network when the limits ask for it. Requests without a
+// known source address can't be bound and are rejected.
+func evaluateSourceBinding(request *models.PrivilegeRequest, limits Limits) error {
+	request.BoundTo = ""
+	if limits.BindSource == nil || !*limits.BindSource {
+		return nil
+	}
+	addr, err := netip.ParseAddr(request.SourceIP)
+	if err != nil {
+		return violation("source_binding", "credentials for %s must be bound to the requester's address, which is unknown", request.ResourceID)
+	}
+	addr = addr.Unmap()
+	bits := limits.BindPrefix
+	if bits == 0 || bits > addr.BitLen() {
+		bits = addr.BitLen()
+	}
+	prefix, err := addr.Prefix(bits)
+	if err != nil {
+		return err
+	}
+	request.BoundTo = prefix.String()
+	return nil
+}
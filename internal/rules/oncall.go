@@ -0,0 +1,87 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// OnCallPolicy auto-approves requests from the engineer currently on call for
+// the resource's service, who needs access during an incident without waiting
+// for an approver. Tag rules, time policies, risk thresholds and expressions
+// still apply afterwards and can require approvals again.
+//
+//	modules: [mysql]
+//	levels: [read, write]
+//	tiers: [internal, confidential]
+type OnCallPolicy struct {
+	Name    string   `yaml:"name"`
+	Modules []string `yaml:"modules"`
+	Levels  []string `yaml:"levels"`
+	Tiers   []string `yaml:"tiers"`
+}
+
+// validateOnCallPolicies checks that every on-call policy has a name
+func (r *Rules) validateOnCallPolicies() error {
+	for i, policy := range r.OnCallPolicies {
+		if policy.Name == "" {
+			return fmt.Errorf("on-call policy %d: name is required", i)
+		}
+	}
+	return nil
+}
+
+// applies reports whether the policy covers a request for a resource of the tier
+func (p *OnCallPolicy) applies(request *models.PrivilegeRequest, tier string) bool {
+	if len(p.Modules) > 0 && !slices.Contains(p.Modules, request.Module) {
+		return false
+	}
+	if len(p.Levels) > 0 && !slices.Contains(p.Levels, string(request.Level)) {
+		return false
+	}
+	if len(p.Tiers) > 0 && !slices.Contains(p.Tiers, tier) {
+		return false
+	}
+	return true
+}
+
+// evaluateOnCallPolicies auto-approves the request when a policy covers it and
+// the requester is on call for the resource's service. A schedule that can't
+// be read leaves the request to its approvers.
+func (e *DefaultRuleEngine) evaluateOnCallPolicies(request *models.PrivilegeRequest, decision *Decision) error {
+	rules := e.Rules()
+	e.mu.RLock()
+	onCall := e.onCall
+	e.mu.RUnlock()
+	if onCall == nil || request.RequiredApprovals == 0 {
+		return nil
+	}
+
+	tier := rules.Resources[request.ResourceID].Tier
+	service := rules.Service(request.ResourceID)
+	for i := range rules.OnCallPolicies {
+		policy := &rules.OnCallPolicies[i]
+		if !policy.applies(request, tier) {
+			continue
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		isOnCall, err := onCall.IsOnCall(ctx, request.UserID, service)
+		cancel()
+		if err != nil {
+			log.Printf("Failed to check whether %s is on call for %s: %v", request.UserID, service, err)
+			return nil
+		}
+		if isOnCall {
+			request.RequiredApprovals = 0
+			request.ApprovalSteps = nil
+			decision.record(policy.Name, ResultMatched, fmt.Sprintf("requester is on call for %s; auto-approved", service))
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,213 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Violation describes a single policy rule that denied a request or grant
+type Violation struct {
+	Rule    string `json:"rule"`
+	Message string `json:"message"`
+}
+
+// ViolationError is returned when a request or grant is denied by policy
+type ViolationError struct {
+	Violations []Violation `json:"violations"`
+}
+
+// Error joins the violation messages
+func (e *ViolationError) Error() string {
+	if len(e.Violations) == 0 {
+		return "denied by policy"
+	}
+	messages := make([]string, 0, len(e.Violations))
+	for _, v := range e.Violations {
+		messages = append(messages, v.Message)
+	}
+	return strings.Join(messages, "; ")
+}
+
+// OPAConfig configures the Open Policy Agent rule engine
+type OPAConfig struct {
+	// URL is the base URL of the OPA server, e.g. http://localhost:8181
+	URL string `yaml:"url"`
+
+	// RequestPath is the data path of the decision for privilege requests, e.g. apollo/request
+	RequestPath string `yaml:"request_path"`
+
+	// GrantPath is the data path of the decision for privilege grants, e.g. apollo/grant
+	GrantPath string `yaml:"grant_path"`
+
+	// ApprovalPath is the data path of the decision for approvals, e.g. apollo/approval
+	ApprovalPath string `yaml:"approval_path"`
+
+	// Policies lists bundled Rego files that are pushed to the OPA server on startup.
+	// Leave empty when policies are managed on the OPA server itself.
+	Policies []string `yaml:"policies"`
+
+	// Timeout bounds each decision query
+	Timeout time.Duration `yaml:"timeout"`
+}
+
+// OPAEngine evaluates privilege requests and grants against Rego policies served by OPA
+type OPAEngine struct {
+	config     OPAConfig
+	httpClient *http.Client
+}
+
+// opaDecision is the decision document expected from the Rego policies. Policies may
+// also return a plain boolean.
+type opaDecision struct {
+	Allow      bool        `json:"allow"`
+	Violations []Violation `json:"violations"`
+	Reasons    []string    `json:"reasons"`
+}
+
+// NewOPAEngine creates a rule engine backed by an OPA server
+func NewOPAEngine(config OPAConfig) (*OPAEngine, error) {
+	if config.URL == "" {
+		return nil, fmt.Errorf("opa url is required")
+	}
+	if config.RequestPath == "" {
+		config.RequestPath = "apollo/request"
+	}
+	if config.GrantPath == "" {
+		config.GrantPath = "apollo/grant"
+	}
+	if config.ApprovalPath == "" {
+		config.ApprovalPath = "apollo/approval"
+	}
+	if config.Timeout == 0 {
+		config.Timeout = 2 * time.Second
+	}
+
+	return &OPAEngine{
+		config: config,
+		httpClient: &http.Client{
+			Timeout: config.Timeout,
+		},
+	}, nil
+}
+
+// LoadPolicies pushes the bundled Rego policies to the OPA server
+func (e *OPAEngine) LoadPolicies(ctx context.Context) error {
+	for _, path := range e.config.Policies {
+		data, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("failed to read policy %s: %v", path, err)
+		}
+
+		id := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+		req, err := http.NewRequestWithContext(ctx, http.MethodPut, fmt.Sprintf("%s/v1/policies/%s", e.config.URL, id), bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("failed to create request: %v", err)
+		}
+		req.Header.Set("Content-Type", "text/plain")
+
+		resp, err := e.httpClient.Do(req)
+		if err != nil {
+			return fmt.Errorf("failed to upload policy %s: %v", id, err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("failed to upload policy %s: status %d", id, resp.StatusCode)
+		}
+	}
+	return nil
+}
+
+// EvaluateRequest queries OPA for a decision on a privilege request
+func (e *OPAEngine) EvaluateRequest(request *models.PrivilegeRequest) error {
+	return e.evaluate(e.config.RequestPath, map[string]interface{}{
+		"request":  request,
+		"duration": request.ExpiresAt.Sub(request.RequestedAt).Seconds(),
+	})
+}
+
+// ValidateGrant queries OPA for a decision on a privilege grant
+func (e *OPAEngine) ValidateGrant(grant *models.PrivilegeGrant) error {
+	return e.evaluate(e.config.GrantPath, map[string]interface{}{
+		"grant": grant,
+		"now":   time.Now().UTC(),
+	})
+}
+
+// ValidateApproval queries OPA for a decision on an approval. Self-approval is
+// always rejected, whatever the policy says.
+func (e *OPAEngine) ValidateApproval(request *models.PrivilegeRequest, approver *models.Approver) error {
+	if approver.ID == request.UserID {
+		return &ViolationError{Violations: []Violation{{Rule: "self_approval", Message: "requesters cannot approve their own request"}}}
+	}
+	return e.evaluate(e.config.ApprovalPath, map[string]interface{}{
+		"request":  request,
+		"approver": approver,
+	})
+}
+
+// evaluate posts the input document to the decision path and interprets the result
+func (e *OPAEngine) evaluate(path string, input map[string]interface{}) error {
+	body, err := json.Marshal(map[string]interface{}{"input": input})
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy input: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), e.config.Timeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("%s/v1/data/%s", e.config.URL, strings.Trim(path, "/")), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.httpClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to query policy engine: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to query policy engine: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		Result json.RawMessage `json:"result"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to decode policy decision: %v", err)
+	}
+
+	// An undefined decision means the policy is missing; fail closed
+	if len(result.Result) == 0 {
+		return &ViolationError{Violations: []Violation{{Rule: path, Message: "no policy decision available"}}}
+	}
+
+	var decision opaDecision
+	var allow bool
+	if err := json.Unmarshal(result.Result, &allow); err == nil {
+		decision.Allow = allow
+	} else if err := json.Unmarshal(result.Result, &decision); err != nil {
+		return fmt.Errorf("failed to decode policy decision: %v", err)
+	}
+
+	if decision.Allow {
+		return nil
+	}
+
+	violations := decision.Violations
+	for _, reason := range decision.Reasons {
+		violations = append(violations, Violation{Rule: path, Message: reason})
+	}
+	return &ViolationError{Violations: violations}
+}
@@ -0,0 +1,121 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// RateLimits restricts how often a user may request privileges
+type RateLimits struct {
+	// MaxRequestsPerDay caps the number of requests per user in a rolling 24 hours
+	MaxRequestsPerDay int `yaml:"max_requests_per_day"`
+
+	// DenialCooldown blocks new requests from a user for this long after a denial
+	DenialCooldown time.Duration `yaml:"denial_cooldown"`
+}
+
+// RequestHistory provides the request history needed to enforce rate limits
+type RequestHistory interface {
+	// CountRequests returns the number of requests the user submitted since the given time
+	CountRequests(ctx context.Context, userID string, since time.Time) (int, error)
+
+	// LastDenial returns when the user's most recent request was denied, or the zero time
+	LastDenial(ctx context.Context, userID string) (time.Time, error)
+}
+
+// validate checks the rate limits
+func (l RateLimits) validate() error {
+	if l.MaxRequestsPerDay < 0 {
+		return fmt.Errorf("rate_limits: max_requests_per_day must not be negative")
+	}
+	if l.DenialCooldown < 0 {
+		return fmt.Errorf("rate_limits: denial_cooldown must not be negative")
+	}
+	return nil
+}
+
+// SetRequestHistory configures the history used to enforce rate limits
+func (e *DefaultRuleEngine) SetRequestHistory(history RequestHistory) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.history = history
+}
+
+// SetAuditRecorder configures where the engine records triggered limits
+func (e *DefaultRuleEngine) SetAuditRecorder(recorder audit.Recorder) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.audit = recorder
+}
+
+// evaluateRateLimits rejects requests from users over their daily quota or in a
+// cool-down period after a denial, recording an audit entry when a limit triggers.
+// Simulated decisions are not audited.
+func (e *DefaultRuleEngine) evaluateRateLimits(request *models.PrivilegeRequest, decision *Decision) error {
+	rules := e.Rules()
+	limits := rules.RateLimits
+	e.mu.RLock()
+	history, recorder := e.history, e.audit
+	e.mu.RUnlock()
+
+	if history == nil || (limits.MaxRequestsPerDay == 0 && limits.DenialCooldown == 0) {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	now := time.Now()
+	var violation *Violation
+
+	if limits.DenialCooldown > 0 {
+		deniedAt, err := history.LastDenial(ctx, request.UserID)
+		if err != nil {
+			return fmt.Errorf("failed to check request history: %v", err)
+		}
+		if until := deniedAt.Add(limits.DenialCooldown); !deniedAt.IsZero() && now.Before(until) {
+			violation = &Violation{
+				Rule:    "denial_cooldown",
+				Message: fmt.Sprintf("a recent request was denied; new requests are blocked until %s", until.UTC().Format(time.RFC3339)),
+			}
+		}
+	}
+
+	if violation == nil && limits.MaxRequestsPerDay > 0 {
+		count, err := history.CountRequests(ctx, request.UserID, now.Add(-24*time.Hour))
+		if err != nil {
+			return fmt.Errorf("failed to check request history: %v", err)
+		}
+		if count >= limits.MaxRequestsPerDay {
+			violation = &Violation{
+				Rule:    "max_requests_per_day",
+				Message: fmt.Sprintf("request limit of %d per day reached", limits.MaxRequestsPerDay),
+			}
+		}
+	}
+
+	if violation == nil {
+		return nil
+	}
+
+	if recorder != nil && decision == nil {
+		if err := recorder.Record(ctx, audit.Entry{
+			Action:        audit.ActionRateLimited,
+			Actor:         request.UserID,
+			ResourceID:    request.ResourceID,
+			RequestID:     request.ID,
+			Rule:          violation.Rule,
+			Message:       violation.Message,
+			PolicyVersion: rules.Version,
+		}); err != nil {
+			log.Printf("Failed to record audit entry: %v", err)
+		}
+	}
+
+	return &ViolationError{Violations: []Violation{*violation}}
+}
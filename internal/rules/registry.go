@@ -0,0 +1,77 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// Names of the built-in rule engines
+const (
+	EngineDefault = "default"
+	EngineOPA     = "opa"
+)
+
+// EngineFactory creates a rule engine. decode unmarshals the engine's configuration
+// section into the given value and is a no-op when no configuration is present.
+type EngineFactory func(decode func(interface{}) error) (RuleEngine, error)
+
+var (
+	enginesMu sync.RWMutex
+	engines   = make(map[string]EngineFactory)
+)
+
+func init() {
+	RegisterEngine(EngineDefault, func(decode func(interface{}) error) (RuleEngine, error) {
+		return NewDefaultRuleEngine(DefaultRules()), nil
+	})
+	RegisterEngine(EngineOPA, func(decode func(interface{}) error) (RuleEngine, error) {
+		var config OPAConfig
+		if err := decode(&config); err != nil {
+			return nil, fmt.Errorf("invalid opa configuration: %v", err)
+		}
+		engine, err := NewOPAEngine(config)
+		if err != nil {
+			return nil, err
+		}
+		if err := engine.LoadPolicies(context.Background()); err != nil {
+			return nil, err
+		}
+		return engine, nil
+	})
+}
+
+// RegisterEngine makes a rule engine available by name. Registering a name twice
+// replaces the earlier factory, so custom builds can override the built-in engines.
+func RegisterEngine(name string, factory EngineFactory) {
+	enginesMu.Lock()
+	defer enginesMu.Unlock()
+	engines[name] = factory
+}
+
+// NewEngine creates the rule engine registered under the name
+func NewEngine(name string, decode func(interface{}) error) (RuleEngine, error) {
+	enginesMu.RLock()
+	factory, ok := engines[name]
+	enginesMu.RUnlock()
+	if !ok {
+		return nil, fmt.Errorf("unknown rule engine %q (available: %v)", name, Engines())
+	}
+	if decode == nil {
+		decode = func(interface{}) error { return nil }
+	}
+	return factory(decode)
+}
+
+// Engines returns the names of the registered rule engines
+func Engines() []string {
+	enginesMu.RLock()
+	defer enginesMu.RUnlock()
+	names := make([]string, 0, len(engines))
+	for name := range engines {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,177 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sort"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Risk factor weights. A score is the sum of the weights of the factors that
+// apply, capped at 100.
+var (
+	riskLevelWeights = map[models.PrivilegeLevel]int{
+		models.PrivilegeLevelRead:  0,
+		models.PrivilegeLevelWrite: 15,
+		models.PrivilegeLevelAdmin: 30,
+		models.PrivilegeLevelRoot:  40,
+	}
+	riskTierWeights = map[string]int{
+		TierPublic:       0,
+		TierInternal:     5,
+		TierConfidential: 15,
+		TierRestricted:   25,
+	}
+	riskTagWeights = map[string]int{
+		TagPII:  5,
+		TagPCI:  5,
+		TagGDPR: 5,
+	}
+)
+
+const (
+	riskLongDurationWeight  = 10
+	riskFirstRequestWeight  = 10
+	riskRecentDenialWeight  = 10
+	riskOffHoursWeight      = 10
+	riskLongDuration        = 4 * time.Hour
+	riskRecentDenialWindow  = 7 * 24 * time.Hour
+	riskRequestHistoryRange = 30 * 24 * time.Hour
+)
+
+// RiskPolicy configures how risk scores influence requests
+type RiskPolicy struct {
+	// BusinessHours are the windows outside of which requests score as off-hours
+	BusinessHours []TimeWindow `yaml:"business_hours"`
+
+	// Thresholds raise the required approvals or deny requests at or above a score
+	Thresholds []RiskThreshold `yaml:"thresholds"`
+}
+
+// RiskThreshold applies to requests whose risk score is at least Score
+type RiskThreshold struct {
+	Score             int  `yaml:"score"`
+	RequiredApprovals int  `yaml:"required_approvals"`
+	Deny              bool `yaml:"deny"`
+}
+
+// compile validates the thresholds and prepares the business hours
+func (p *RiskPolicy) compile() error {
+	for i := range p.BusinessHours {
+		if err := p.BusinessHours[i].compile(); err != nil {
+			return fmt.Errorf("risk business_hours: %v", err)
+		}
+	}
+	for _, threshold := range p.Thresholds {
+		if threshold.Score < 0 || threshold.Score > 100 {
+			return fmt.Errorf("risk threshold %d: score must be between 0 and 100", threshold.Score)
+		}
+		if !threshold.Deny && threshold.RequiredApprovals <= 0 {
+			return fmt.Errorf("risk threshold %d: either deny or required_approvals must be set", threshold.Score)
+		}
+	}
+	return nil
+}
+
+// offHours reports whether t lies outside the business hours; without business
+// hours nothing is off-hours
+func (p *RiskPolicy) offHours(t time.Time) bool {
+	if len(p.BusinessHours) == 0 {
+		return false
+	}
+	for i := range p.BusinessHours {
+		if p.BusinessHours[i].contains(t) {
+			return false
+		}
+	}
+	return true
+}
+
+// scoreRisk computes the risk score of a request and the factors contributing to it
+func (e *DefaultRuleEngine) scoreRisk(rules *Rules, request *models.PrivilegeRequest, tags []string) (int, []string) {
+	score := 0
+	var factors []string
+	add := func(weight int, format string, args ...interface{}) {
+		if weight == 0 {
+			return
+		}
+		score += weight
+		factors = append(factors, fmt.Sprintf("%s (+%d)", fmt.Sprintf(format, args...), weight))
+	}
+
+	add(riskLevelWeights[request.Level], "%s level", request.Level)
+	if tier := rules.Resources[request.ResourceID].Tier; tier != "" {
+		add(riskTierWeights[tier], "%s resource", tier)
+	}
+	sortedTags := append([]string(nil), tags...)
+	sort.Strings(sortedTags)
+	for _, tag := range sortedTags {
+		add(riskTagWeights[tag], "%s data", tag)
+	}
+	if request.ExpiresAt.Sub(request.RequestedAt) > riskLongDuration {
+		add(riskLongDurationWeight, "longer than %s", riskLongDuration)
+	}
+
+	at := request.RequestedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+	if rules.Risk.offHours(at) {
+		add(riskOffHoursWeight, "outside business hours")
+	}
+
+	e.mu.RLock()
+	history := e.history
+	e.mu.RUnlock()
+	if history != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		if count, err := history.CountRequests(ctx, request.UserID, at.Add(-riskRequestHistoryRange)); err != nil {
+			log.Printf("Failed to read request history of %s: %v", request.UserID, err)
+		} else if count == 0 {
+			add(riskFirstRequestWeight, "no requests in the last 30 days")
+		}
+		if deniedAt, err := history.LastDenial(ctx, request.UserID); err != nil {
+			log.Printf("Failed to read request history of %s: %v", request.UserID, err)
+		} else if !deniedAt.IsZero() && at.Sub(deniedAt) < riskRecentDenialWindow {
+			add(riskRecentDenialWeight, "denied request in the last 7 days")
+		}
+	}
+
+	if score > 100 {
+		score = 100
+	}
+	return score, factors
+}
+
+// evaluateRisk attaches the risk score to the request and applies the highest
+// threshold it reaches
+func (e *DefaultRuleEngine) evaluateRisk(request *models.PrivilegeRequest, tags []string, decision *Decision) error {
+	rules := e.Rules()
+	request.RiskScore, request.RiskFactors = e.scoreRisk(rules, request, tags)
+	decision.record("risk_score", ResultMatched, fmt.Sprintf("score %d", request.RiskScore))
+
+	var reached *RiskThreshold
+	for i := range rules.Risk.Thresholds {
+		threshold := &rules.Risk.Thresholds[i]
+		if request.RiskScore >= threshold.Score && (reached == nil || threshold.Score > reached.Score) {
+			reached = threshold
+		}
+	}
+	if reached == nil {
+		return nil
+	}
+
+	if reached.Deny {
+		return violation("risk_score", "risk score %d exceeds the allowed maximum of %d", request.RiskScore, reached.Score-1)
+	}
+	if reached.RequiredApprovals > request.RequiredApprovals {
+		request.RequiredApprovals = reached.RequiredApprovals
+	}
+	decision.record("risk_threshold", ResultMatched, fmt.Sprintf("score %d requires %d approvals", request.RiskScore, reached.RequiredApprovals))
+	return nil
+}
@@ -0,0 +1,224 @@
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// ApprovalRoute sends requests for matching resources to a group of approvers. A
+// route matches when every non-empty attribute list contains the resource's value;
+// the first matching route wins.
+//
+//	owners: [payments]
+//	environments: [production]
+//	approvers:
+//	  teams: [payments-leads]
+//	notify: ["#payments-access"]
+//
+// Routes with steps are approved in stages instead: each step needs its own
+// number of approvals from its approvers before the next one starts.
+//
+//	steps:
+//	  - name: lead
+//	    approvers: {teams: [payments-leads]}
+//	  - name: security
+//	    approvers: {users: [alice, bob, carol]}
+//	    approvals: 2
+type ApprovalRoute struct {
+	Name         string   `yaml:"name"`
+	Modules      []string `yaml:"modules"`
+	Owners       []string `yaml:"owners"`
+	Environments []string `yaml:"environments"`
+	Tiers        []string `yaml:"tiers"`
+
+	// Approvers are the only users allowed to approve routed requests
+	Approvers Principals `yaml:"approvers"`
+
+	// Steps are the stages routed requests are approved in, in order
+	Steps []ApprovalRouteStep `yaml:"steps"`
+
+	// Notify lists additional notification targets informed of routed requests
+	Notify []string `yaml:"notify"`
+}
+
+// ApprovalRouteStep is one stage of a route's approvals: Approvals of the
+// Approvers must approve, one when unset
+type ApprovalRouteStep struct {
+	Name      string     `yaml:"name"`
+	Approvers Principals `yaml:"approvers"`
+	Approvals int        `yaml:"approvals"`
+}
+
+// required returns the number of approvals the step needs
+func (s ApprovalRouteStep) required() int {
+	if s.Approvals == 0 {
+		return 1
+	}
+	return s.Approvals
+}
+
+// validateApprovalRoutes checks that every route names its approvers, either
+// directly or for each of its steps
+func (r *Rules) validateApprovalRoutes() error {
+	for i, route := range r.ApprovalRoutes {
+		if route.Name == "" {
+			return fmt.Errorf("approval route %d: name is required", i)
+		}
+		switch {
+		case len(route.Steps) > 0 && !route.Approvers.empty():
+			return fmt.Errorf("approval route %s: approvers and steps are mutually exclusive", route.Name)
+		case len(route.Steps) == 0 && route.Approvers.empty():
+			return fmt.Errorf("approval route %s: approvers is required", route.Name)
+		}
+		if err := r.validatePrincipalTeams(route.Approvers); err != nil {
+			return fmt.Errorf("approval route %s: %v", route.Name, err)
+		}
+
+		seen := make(map[string]bool)
+		for j, step := range route.Steps {
+			if step.Name == "" {
+				return fmt.Errorf("approval route %s: step %d: name is required", route.Name, j)
+			}
+			if seen[step.Name] {
+				return fmt.Errorf("approval route %s: duplicate step %s", route.Name, step.Name)
+			}
+			seen[step.Name] = true
+			if step.Approvers.empty() {
+				return fmt.Errorf("approval route %s: step %s: approvers is required", route.Name, step.Name)
+			}
+			if err := r.validatePrincipalTeams(step.Approvers); err != nil {
+				return fmt.Errorf("approval route %s: step %s: %v", route.Name, step.Name, err)
+			}
+			if step.Approvals < 0 {
+				return fmt.Errorf("approval route %s: step %s: approvals must not be negative", route.Name, step.Name)
+			}
+			// Group membership is only known at runtime
+			if len(step.Approvers.Groups) == 0 {
+				if members := step.Approvers.members(r, nil); step.required() > len(members) {
+					return fmt.Errorf("approval route %s: step %s: requires %d approvals but has %d approvers",
+						route.Name, step.Name, step.required(), len(members))
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// validatePrincipalTeams checks that the teams the principals name exist
+func (r *Rules) validatePrincipalTeams(p Principals) error {
+	for _, team := range p.Teams {
+		if _, ok := r.Teams[team]; !ok {
+			return fmt.Errorf("unknown team %q", team)
+		}
+	}
+	return nil
+}
+
+// matches reports whether the route covers a resource of the module
+func (a *ApprovalRoute) matches(module string, resource Resource) bool {
+	if len(a.Modules) > 0 && !slices.Contains(a.Modules, module) {
+		return false
+	}
+	if len(a.Owners) > 0 && !slices.Contains(a.Owners, resource.Owner) {
+		return false
+	}
+	if len(a.Environments) > 0 && !slices.Contains(a.Environments, resource.Environment) {
+		return false
+	}
+	if len(a.Tiers) > 0 && !slices.Contains(a.Tiers, resource.Tier) {
+		return false
+	}
+	return true
+}
+
+// route returns the first approval route matching the resource, or nil
+func (r *Rules) route(module, resourceID string) *ApprovalRoute {
+	resource := r.Resources[resourceID]
+	for i := range r.ApprovalRoutes {
+		if r.ApprovalRoutes[i].matches(module, resource) {
+			return &r.ApprovalRoutes[i]
+		}
+	}
+	return nil
+}
+
+// members expands the principals into the sorted list of user IDs they cover
+func (p Principals) members(r *Rules, groups GroupDirectory) []string {
+	users := append([]string(nil), p.Users...)
+	for _, team := range p.Teams {
+		for _, member := range r.Teams[team] {
+			if !slices.Contains(users, member) {
+				users = append(users, member)
+			}
+		}
+	}
+	for _, group := range p.Groups {
+		for _, member := range groupMembers(groups, group) {
+			if !slices.Contains(users, member) {
+				users = append(users, member)
+			}
+		}
+	}
+	sort.Strings(users)
+	return users
+}
+
+// evaluateApprovalRouting records who may approve the request and who is notified
+// of it according to the first matching approval route. For routes with steps
+// the request needs every step's approvals, and at least as many in total.
+func (e *DefaultRuleEngine) evaluateApprovalRouting(request *models.PrivilegeRequest, decision *Decision) error {
+	rules := e.Rules()
+	route := rules.route(request.Module, request.ResourceID)
+	if route == nil {
+		return nil
+	}
+
+	request.ApprovalRoute = route.Name
+	request.Approvers = route.Approvers.members(rules, e.groupDirectory())
+	request.ApprovalSteps = nil
+	if len(route.Steps) > 0 {
+		total := 0
+		var approvers []string
+		for _, step := range route.Steps {
+			members := step.Approvers.members(rules, e.groupDirectory())
+			request.ApprovalSteps = append(request.ApprovalSteps, models.ApprovalStep{
+				Name:      step.Name,
+				Approvers: members,
+				Required:  step.required(),
+			})
+			total += step.required()
+			for _, member := range members {
+				if !slices.Contains(approvers, member) {
+					approvers = append(approvers, member)
+				}
+			}
+		}
+		sort.Strings(approvers)
+		request.Approvers = approvers
+		if total > request.RequiredApprovals {
+			request.RequiredApprovals = total
+		}
+	}
+	notify := append([]string(nil), request.Notify...)
+	for _, target := range route.Notify {
+		if !slices.Contains(notify, target) {
+			notify = append(notify, target)
+		}
+	}
+	request.Notify = notify
+
+	if len(request.ApprovalSteps) > 0 {
+		steps := make([]string, 0, len(request.ApprovalSteps))
+		for _, step := range request.ApprovalSteps {
+			steps = append(steps, fmt.Sprintf("%s (%d of %v)", step.Name, step.Required, step.Approvers))
+		}
+		decision.record(route.Name, ResultMatched, fmt.Sprintf("steps: %s", strings.Join(steps, ", ")))
+		return nil
+	}
+	decision.record(route.Name, ResultMatched, fmt.Sprintf("approvers: %v", request.Approvers))
+	return nil
+}
@@ -2,9 +2,13 @@ package rules
 
 import (
 	"errors"
+	"fmt"
+	"strings"
+	"sync"
 	"time"
 
-	"apollo/internal/core/models"
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
 )
 
 // SecurityRule defines a security rule for privilege management
@@ -18,33 +22,285 @@ type SecurityRule struct {
 
 // RuleEngine handles the evaluation of security rules
 type RuleEngine interface {
-	// EvaluateRequest evaluates a privilege request against security rules
+	// EvaluateRequest evaluates a privilege request against security rules and
+	// records derived requirements, such as required approvals, on the request
 	EvaluateRequest(request *models.PrivilegeRequest) error
 
 	// ValidateGrant validates a privilege grant against security rules
 	ValidateGrant(grant *models.PrivilegeGrant) error
+
+	// ValidateApproval checks that the approver may approve the request
+	ValidateApproval(request *models.PrivilegeRequest, approver *models.Approver) error
+}
+
+// DefaultRuleEngine implements basic security rules driven by configurable rule definitions
+type DefaultRuleEngine struct {
+	mu      sync.RWMutex
+	rules   *Rules
+	onCall  OnCallChecker
+	history RequestHistory
+	audit   audit.Recorder
+	shadow  *Rules
+	catalog ResourceCatalog
+	groups  GroupDirectory
+}
+
+// NewDefaultRuleEngine creates a rule engine using the given rule definitions
+func NewDefaultRuleEngine(rules *Rules) *DefaultRuleEngine {
+	return &DefaultRuleEngine{rules: rules}
 }
 
-// DefaultRuleEngine implements basic security rules
-type DefaultRuleEngine struct{}
+// SetRules replaces the rule definitions used by the engine
+func (e *DefaultRuleEngine) SetRules(rules *Rules) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.rules = rules
+}
+
+// SetOnCallChecker configures the schedule used by on-call policies and time
+// policies that require on-call status
+func (e *DefaultRuleEngine) SetOnCallChecker(checker OnCallChecker) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.onCall = checker
+}
+
+// Rules returns the rule definitions currently in effect
+func (e *DefaultRuleEngine) Rules() *Rules {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+	if e.rules == nil {
+		return DefaultRules()
+	}
+	return e.rules
+}
 
 // EvaluateRequest implements basic security rules for privilege requests
 func (e *DefaultRuleEngine) EvaluateRequest(request *models.PrivilegeRequest) error {
-	// Rule 1: Maximum privilege duration
-	maxDuration := 24 * time.Hour
-	if request.ExpiresAt.Sub(request.RequestedAt) > maxDuration {
-		return errors.New("privilege duration exceeds maximum allowed time")
+	err := e.evaluate(request, nil)
+	e.evaluateShadow(request, err)
+	return err
+}
+
+// evaluate runs the request rules in order. Without a decision the first violation
+// ends the evaluation; with one, every rule runs and its outcome is traced.
+func (e *DefaultRuleEngine) evaluate(request *models.PrivilegeRequest, decision *Decision) error {
+	rules := e.Rules()
+	limits := rules.Resolve(request.Module, request.ResourceID)
+	duration := request.ExpiresAt.Sub(request.RequestedAt)
+	request.PolicyVersion = rules.Version
+	tags := e.resourceTags(rules, request)
+
+	checks := []struct {
+		rule  string
+		check func() error
+	}{
+		// Explicit allow and deny lists, evaluated before the generic rules
+		{"access_rules", func() error {
+			return e.evaluateAccessRules(request, decision)
+		}},
+		// Maximum privilege duration for the module, resource and level
+		{"max_duration", func() error {
+			if maxDuration := limits.MaxDurationFor(request.Level); maxDuration > 0 && duration > maxDuration {
+				return violation("max_duration", "privilege duration %s exceeds maximum allowed time of %s for %s access to %s", duration, maxDuration, request.Level, request.ResourceID)
+			}
+			return nil
+		}},
+		// Minimum privilege duration
+		{"min_duration", func() error {
+			if duration < limits.MinDuration {
+				return violation("min_duration", "privilege duration is less than minimum allowed time of %s", limits.MinDuration)
+			}
+			return nil
+		}},
+		// Required reason
+		{"reason", func() error {
+			if request.Reason == "" {
+				return violation("reason", "reason is required for privilege request")
+			}
+			if len(strings.TrimSpace(request.Reason)) < limits.MinReasonLength {
+				return violation("reason", "reason must be at least %d characters", limits.MinReasonLength)
+			}
+			return nil
+		}},
+		// Allowed privilege levels
+		{"allowed_levels", func() error {
+			if !limits.allowsLevel(request.Level) {
+				return violation("allowed_levels", "privilege level %s is not allowed for this resource", request.Level)
+			}
+			return nil
+		}},
+		// Approvals and notifications derived from the resource's tier
+		{"tier", func() error {
+			if approvals := limits.RequiredApprovals(); approvals > request.RequiredApprovals {
+				request.RequiredApprovals = approvals
+			}
+			request.Notify = limits.Notify
+			return nil
+		}},
+		// Approvers and notification targets from the approval routes
+		{"approval_routing", func() error {
+			return e.evaluateApprovalRouting(request, decision)
+		}},
+		// Auto-approval of the engineer on call, before the tag rules, time
+		// policies, risk thresholds and expressions can require approvals again
+		{"on_call_policies", func() error {
+			return e.evaluateOnCallPolicies(request, decision)
+		}},
+		// Data classification tags
+		{"tag_rules", func() error {
+			return e.evaluateTagRules(request, tags, decision)
+		}},
+		// Step-up MFA for high-privilege requests
+		{"step_up", func() error {
+			if request.StepUp == nil {
+				request.StepUp = rules.StepUp.requirement(request)
+			}
+			return nil
+		}},
+		// Hardware key confirmation by the approvers of high-privilege requests
+		{"webauthn_approval", func() error {
+			if limits.requiresWebAuthn(request.Level) {
+				request.WebAuthnApproval = true
+			}
+			return nil
+		}},
+		// Source network policies
+		{"network_policies", func() error {
+			return e.evaluateNetworkPolicies(request)
+		}},
+		// Binding of the credentials to the requester's source address
+		{"source_binding", func() error {
+			return evaluateSourceBinding(request, limits)
+		}},
+		// Time-window policies
+		{"time_policies", func() error {
+			return e.evaluateTimePolicies(request, decision)
+		}},
+		// Per-user rate limits
+		{"rate_limits", func() error {
+			return e.evaluateRateLimits(request, decision)
+		}},
+		// Risk score and thresholds
+		{"risk", func() error {
+			return e.evaluateRisk(request, tags, decision)
+		}},
+		// Expression rules
+		{"expressions", func() error {
+			return e.evaluateExpressions(request, tags, decision)
+		}},
+		// External decision service, consulted once the local rules pass
+		{"decision_webhook", func() error {
+			return e.evaluateDecisionWebhook(request, tags, decision)
+		}},
 	}
 
-	// Rule 2: Minimum privilege duration
-	minDuration := 5 * time.Minute
-	if request.ExpiresAt.Sub(request.RequestedAt) < minDuration {
-		return errors.New("privilege duration is less than minimum allowed time")
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			if err := decision.violated(err); err != nil {
+				return err
+			}
+			continue
+		}
+		decision.record(c.rule, ResultPassed, "")
 	}
 
-	// Rule 3: Required reason
-	if request.Reason == "" {
-		return errors.New("reason is required for privilege request")
+	return nil
+}
+
+// evaluateNetworkPolicies rejects requests submitted from outside the allowed networks
+func (e *DefaultRuleEngine) evaluateNetworkPolicies(request *models.PrivilegeRequest) error {
+	for _, policy := range e.Rules().NetworkPolicies {
+		if !policy.applies(request) || policy.allows(request.SourceIP) {
+			continue
+		}
+		source := request.SourceIP
+		if source == "" {
+			source = "an unknown address"
+		}
+		return &ViolationError{Violations: []Violation{{
+			Rule:    policy.Name,
+			Message: fmt.Sprintf("%s access to %s is not allowed from %s", request.Level, request.ResourceID, source),
+		}}}
+	}
+	return nil
+}
+
+// evaluateTimePolicies rejects requests submitted outside their allowed windows and
+// requires a human approval for requests outside their auto-approval windows
+func (e *DefaultRuleEngine) evaluateTimePolicies(request *models.PrivilegeRequest, decision *Decision) error {
+	rules := e.Rules()
+	e.mu.RLock()
+	onCall := e.onCall
+	e.mu.RUnlock()
+
+	at := request.RequestedAt
+	if at.IsZero() {
+		at = time.Now()
+	}
+
+	for i := range rules.TimePolicies {
+		policy := &rules.TimePolicies[i]
+		if !policy.applies(request) {
+			continue
+		}
+
+		allowed, reason, err := policy.allows(request, rules.Service(request.ResourceID), at, onCall)
+		if err != nil {
+			return err
+		}
+		if allowed {
+			continue
+		}
+
+		switch policy.Action {
+		case TimeActionSubmit:
+			return &ViolationError{Violations: []Violation{{Rule: policy.Name, Message: reason}}}
+		case TimeActionAutoApprove:
+			if request.RequiredApprovals < 1 {
+				request.RequiredApprovals = 1
+			}
+			decision.record(policy.Name, ResultMatched, reason+"; approval required")
+		}
+	}
+
+	return nil
+}
+
+// evaluateExpressions applies the CEL expression rules to a request, denying it or
+// raising its required approvals when a rule matches
+func (e *DefaultRuleEngine) evaluateExpressions(request *models.PrivilegeRequest, tags []string, decision *Decision) error {
+	rules := e.Rules()
+	if len(rules.Expressions) == 0 {
+		return nil
+	}
+
+	input, err := expressionInput(request, rules.resourceAttributes(request.Module, request.ResourceID, tags))
+	if err != nil {
+		return err
+	}
+
+	for i := range rules.Expressions {
+		rule := &rules.Expressions[i]
+		matched, err := rule.evaluate(input)
+		if err != nil {
+			return err
+		}
+		if !matched {
+			continue
+		}
+
+		if rule.Deny {
+			message := rule.Message
+			if message == "" {
+				message = fmt.Sprintf("request denied by rule %s", rule.Name)
+			}
+			return &ViolationError{Violations: []Violation{{Rule: rule.Name, Message: message}}}
+		}
+		if rule.RequiredApprovals > request.RequiredApprovals {
+			request.RequiredApprovals = rule.RequiredApprovals
+		}
+		decision.record(rule.Name, ResultMatched, fmt.Sprintf("requires %d approvals", rule.RequiredApprovals))
 	}
 
 	return nil
@@ -58,10 +314,10 @@ func (e *DefaultRuleEngine) ValidateGrant(grant *models.PrivilegeGrant) error {
 	}
 
 	// Rule 2: Validate grant duration
-	maxDuration := 24 * time.Hour
-	if grant.ExpiresAt.Sub(grant.GrantedAt) > maxDuration {
+	limits := e.Rules().Resolve(grant.Module, grant.ResourceID)
+	if maxDuration := limits.MaxDurationFor(grant.Level); maxDuration > 0 && grant.ExpiresAt.Sub(grant.GrantedAt) > maxDuration {
 		return errors.New("privilege grant duration exceeds maximum allowed time")
 	}
 
 	return nil
-} 
\ No newline at end of file
+}
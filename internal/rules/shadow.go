@@ -0,0 +1,81 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"strconv"
+
+	"github.com/petermein/apollo/internal/audit"
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SetShadowRules configures rules that are evaluated alongside the active rules
+// and logged, but never enforced. Pass nil to disable shadow evaluation.
+func (e *DefaultRuleEngine) SetShadowRules(rules *Rules) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.shadow = rules
+}
+
+// evaluateShadow evaluates the request against the shadow rules and records how
+// their decision compares to the enforced one
+func (e *DefaultRuleEngine) evaluateShadow(request *models.PrivilegeRequest, enforced error) {
+	e.mu.RLock()
+	shadow, onCall, history, recorder := e.shadow, e.onCall, e.history, e.audit
+	active := e.rules
+	e.mu.RUnlock()
+
+	if shadow == nil {
+		return
+	}
+
+	shadowEngine := &DefaultRuleEngine{rules: shadow, onCall: onCall, history: history}
+	simulated := *request
+	simulated.StepUp = nil
+	simulated.RequiredApprovals = 0
+
+	decision := &Decision{}
+	if err := shadowEngine.evaluate(&simulated, decision); err != nil {
+		log.Printf("Failed to evaluate shadow policy version %d: %v", shadow.Version, err)
+		return
+	}
+
+	activeAllowed := enforced == nil
+	shadowAllowed := len(decision.Violations) == 0
+	divergent := activeAllowed != shadowAllowed || (activeAllowed && simulated.RequiredApprovals != request.RequiredApprovals)
+
+	outcome := "allow"
+	if !shadowAllowed {
+		outcome = "deny"
+	}
+	message := fmt.Sprintf("shadow policy would %s with %d required approvals", outcome, simulated.RequiredApprovals)
+	if !shadowAllowed {
+		message = fmt.Sprintf("shadow policy would deny: %v", &ViolationError{Violations: decision.Violations})
+	}
+	if divergent {
+		log.Printf("Shadow policy version %d diverges for request %s: %s", shadow.Version, request.ID, message)
+	}
+
+	if recorder == nil {
+		return
+	}
+	activeVersion := 0
+	if active != nil {
+		activeVersion = active.Version
+	}
+	if err := recorder.Record(context.Background(), audit.Entry{
+		Action:        audit.ActionShadowDecision,
+		Actor:         request.UserID,
+		ResourceID:    request.ResourceID,
+		RequestID:     request.ID,
+		Message:       message,
+		PolicyVersion: shadow.Version,
+		Details: map[string]string{
+			"active_version": strconv.Itoa(activeVersion),
+			"divergent":      strconv.FormatBool(divergent),
+		},
+	}); err != nil {
+		log.Printf("Failed to record audit entry: %v", err)
+	}
+}
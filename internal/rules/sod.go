@@ -0,0 +1,84 @@
+package rules
+
+import (
+	"fmt"
+	"slices"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// SeparationOfDuties configures who may approve whose requests
+type SeparationOfDuties struct {
+	// SameTeamTiers lists the sensitivity tiers for which approvers may not share a
+	// team with the requester
+	SameTeamTiers []string `yaml:"same_team_tiers"`
+
+	// OperatorIDs lists identities used by operators; they can never approve requests
+	OperatorIDs []string `yaml:"operator_ids"`
+}
+
+// teamsOf returns the teams the user is a member of
+func (r *Rules) teamsOf(userID string) []string {
+	var teams []string
+	for team, members := range r.Teams {
+		if slices.Contains(members, userID) {
+			teams = append(teams, team)
+		}
+	}
+	return teams
+}
+
+// sharedTeam returns a team both users are members of, or an empty string
+func (r *Rules) sharedTeam(a, b string) string {
+	for _, team := range r.teamsOf(a) {
+		if slices.Contains(r.Teams[team], b) {
+			return team
+		}
+	}
+	return ""
+}
+
+// ValidateApproval enforces separation of duties between requester and approver
+func (e *DefaultRuleEngine) ValidateApproval(request *models.PrivilegeRequest, approver *models.Approver) error {
+	rules := e.Rules()
+	sod := rules.SeparationOfDuties
+
+	// Rule 1: Nobody approves their own request
+	if approver.ID == request.UserID {
+		return &ViolationError{Violations: []Violation{{Rule: "self_approval", Message: "requesters cannot approve their own request"}}}
+	}
+
+	// Rule 2: Operator identities never approve
+	if approver.Kind == models.ApproverKindOperator || slices.Contains(sod.OperatorIDs, approver.ID) {
+		return &ViolationError{Violations: []Violation{{Rule: "operator_approval", Message: "operator identities cannot approve requests"}}}
+	}
+
+	// Rule 3: Routed requests are approved by their route's approvers only
+	if request.ApprovalRoute != "" && !slices.Contains(request.Approvers, approver.ID) {
+		return &ViolationError{Violations: []Violation{{
+			Rule:    request.ApprovalRoute,
+			Message: fmt.Sprintf("%s is not an approver for %s", approver.ID, request.ResourceID),
+		}}}
+	}
+
+	// Rule 4: Requests approved in steps are approved by the current step's approvers
+	if step := request.CurrentStep(); step != nil && !slices.Contains(step.Approvers, approver.ID) {
+		return &ViolationError{Violations: []Violation{{
+			Rule:    request.ApprovalRoute,
+			Message: fmt.Sprintf("%s is not an approver for the %s step of %s", approver.ID, step.Name, request.ID),
+		}}}
+	}
+
+	// Rule 5: Same-team approvals are excluded for sensitive tiers
+	tier := rules.Resources[request.ResourceID].Tier
+	if tier != "" && slices.Contains(sod.SameTeamTiers, tier) {
+		if team := rules.sharedTeam(request.UserID, approver.ID); team != "" {
+			return &ViolationError{Violations: []Violation{{
+				Rule:    "same_team_approval",
+				Message: fmt.Sprintf("members of team %s cannot approve each other's requests for %s resources", team, tier),
+			}}}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,53 @@
+package rules
+
+import (
+	"fmt"
+	"slices"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// StepUpPolicy requires a fresh MFA assertion for high-privilege requests. The
+// requester re-authenticates with the identity provider using the ACR values and
+// max age below and attaches the resulting ID token to the request.
+type StepUpPolicy struct {
+	Levels  []string `yaml:"levels"`
+	Modules []string `yaml:"modules"`
+
+	// ACRValues lists the acceptable authentication context classes, e.g. a phishing-resistant MFA class
+	ACRValues []string `yaml:"acr_values"`
+
+	// MaxAge is the maximum time since the user last authenticated
+	MaxAge time.Duration `yaml:"max_age"`
+}
+
+// validate checks the step-up policy
+func (p *StepUpPolicy) validate() error {
+	if p.MaxAge < 0 {
+		return fmt.Errorf("step_up: max_age must not be negative")
+	}
+	return nil
+}
+
+// requirement returns the step-up requirement for a request, or nil when none applies
+func (p *StepUpPolicy) requirement(request *models.PrivilegeRequest) *models.StepUpRequirement {
+	if p == nil {
+		return nil
+	}
+	if len(p.Modules) > 0 && !slices.Contains(p.Modules, request.Module) {
+		return nil
+	}
+	if !slices.Contains(p.Levels, string(request.Level)) {
+		return nil
+	}
+
+	maxAge := p.MaxAge
+	if maxAge == 0 {
+		maxAge = 5 * time.Minute
+	}
+	return &models.StepUpRequirement{
+		ACRValues: p.ACRValues,
+		MaxAge:    int(maxAge.Seconds()),
+	}
+}
@@ -0,0 +1,146 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"slices"
+	"sort"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Data classification tags commonly attached to resources
+const (
+	TagPII  = "pii"
+	TagPCI  = "pci"
+	TagGDPR = "gdpr"
+)
+
+// ResourceCatalog provides the classification tags of the resources known to the system
+type ResourceCatalog interface {
+	// Tags returns the tags of a resource, or nil when the resource is unknown
+	Tags(ctx context.Context, module, resourceID string) ([]string, error)
+}
+
+// TagRule applies to requests for resources carrying one of its tags. A matching
+// rule either denies the request or tightens it, e.g. pii-tagged databases never
+// allow write-level auto-approval:
+//
+//	tags: [pii]
+//	levels: [write, admin]
+//	required_approvals: 1
+type TagRule struct {
+	Name    string   `yaml:"name"`
+	Tags    []string `yaml:"tags"`
+	Modules []string `yaml:"modules"`
+	Levels  []string `yaml:"levels"`
+
+	Deny              bool          `yaml:"deny"`
+	Message           string        `yaml:"message"`
+	RequiredApprovals int           `yaml:"required_approvals"`
+	MaxDuration       time.Duration `yaml:"max_duration"`
+}
+
+// validateTagRules checks that every tag rule has a name, tags and an effect
+func (r *Rules) validateTagRules() error {
+	for i, rule := range r.TagRules {
+		if rule.Name == "" {
+			return fmt.Errorf("tag rule %d: name is required", i)
+		}
+		if len(rule.Tags) == 0 {
+			return fmt.Errorf("tag rule %s: tags is required", rule.Name)
+		}
+		if rule.RequiredApprovals < 0 || rule.MaxDuration < 0 {
+			return fmt.Errorf("tag rule %s: required_approvals and max_duration must not be negative", rule.Name)
+		}
+		if !rule.Deny && rule.RequiredApprovals == 0 && rule.MaxDuration == 0 {
+			return fmt.Errorf("tag rule %s: one of deny, required_approvals or max_duration must be set", rule.Name)
+		}
+	}
+	return nil
+}
+
+// applies reports whether the rule covers a request for a resource with the given tags
+func (t *TagRule) applies(request *models.PrivilegeRequest, tags []string) bool {
+	if len(t.Modules) > 0 && !slices.Contains(t.Modules, request.Module) {
+		return false
+	}
+	if len(t.Levels) > 0 && !slices.Contains(t.Levels, string(request.Level)) {
+		return false
+	}
+	for _, tag := range t.Tags {
+		if slices.Contains(tags, tag) {
+			return true
+		}
+	}
+	return false
+}
+
+// SetResourceCatalog configures the catalog resource tags are read from
+func (e *DefaultRuleEngine) SetResourceCatalog(catalog ResourceCatalog) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.catalog = catalog
+}
+
+// resourceTags returns the tags of the requested resource: those declared in the
+// rules together with those reported by the resource catalog. Catalog errors are
+// logged and the declared tags are used, so a catalog outage cannot drop tags
+// declared in the rules.
+func (e *DefaultRuleEngine) resourceTags(rules *Rules, request *models.PrivilegeRequest) []string {
+	tags := append([]string(nil), rules.Resources[request.ResourceID].Tags...)
+
+	e.mu.RLock()
+	catalog := e.catalog
+	e.mu.RUnlock()
+	if catalog != nil {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+
+		catalogTags, err := catalog.Tags(ctx, request.Module, request.ResourceID)
+		if err != nil {
+			log.Printf("Failed to look up tags of %s: %v", request.ResourceID, err)
+		}
+		for _, tag := range catalogTags {
+			if !slices.Contains(tags, tag) {
+				tags = append(tags, tag)
+			}
+		}
+	}
+
+	sort.Strings(tags)
+	return tags
+}
+
+// evaluateTagRules applies the tag rules to a request, denying it, raising its
+// required approvals or capping its duration when a rule matches
+func (e *DefaultRuleEngine) evaluateTagRules(request *models.PrivilegeRequest, tags []string, decision *Decision) error {
+	rules := e.Rules()
+	duration := request.ExpiresAt.Sub(request.RequestedAt)
+
+	for i := range rules.TagRules {
+		rule := &rules.TagRules[i]
+		if !rule.applies(request, tags) {
+			continue
+		}
+
+		if rule.Deny {
+			message := rule.Message
+			if message == "" {
+				message = fmt.Sprintf("%s access to resources tagged %v is not allowed", request.Level, rule.Tags)
+			}
+			return &ViolationError{Violations: []Violation{{Rule: rule.Name, Message: message}}}
+		}
+		if rule.MaxDuration > 0 && duration > rule.MaxDuration {
+			return violation(rule.Name, "privilege duration %s exceeds maximum allowed time of %s for resources tagged %v", duration, rule.MaxDuration, rule.Tags)
+		}
+		if rule.RequiredApprovals > request.RequiredApprovals {
+			request.RequiredApprovals = rule.RequiredApprovals
+		}
+		decision.record(rule.Name, ResultMatched, fmt.Sprintf("resource tagged %v", rule.Tags))
+	}
+
+	return nil
+}
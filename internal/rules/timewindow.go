@@ -0,0 +1,248 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Time policy actions
+const (
+	// TimeActionSubmit rejects requests submitted outside the allowed windows
+	TimeActionSubmit = "submit"
+	// TimeActionAutoApprove only allows auto-approval inside the allowed windows;
+	// outside of them requests need at least one human approval
+	TimeActionAutoApprove = "auto_approve"
+)
+
+// TimeWindow is a recurring weekly window, evaluated in its own timezone
+type TimeWindow struct {
+	Timezone string   `yaml:"timezone"`
+	Days     []string `yaml:"days"`
+	Start    string   `yaml:"start"`
+	End      string   `yaml:"end"`
+
+	location *time.Location
+	days     map[time.Weekday]bool
+	start    int
+	end      int
+}
+
+// Freeze is a fixed period, such as a change freeze, during which the policy blocks requests
+type Freeze struct {
+	Name string    `yaml:"name"`
+	From time.Time `yaml:"from"`
+	To   time.Time `yaml:"to"`
+}
+
+// TimePolicy restricts when requests can be submitted or auto-approved
+type TimePolicy struct {
+	Name    string   `yaml:"name"`
+	Action  string   `yaml:"action"`
+	Modules []string `yaml:"modules"`
+	Levels  []string `yaml:"levels"`
+
+	// Windows lists the periods in which the action is allowed. No windows means always.
+	Windows []TimeWindow `yaml:"windows"`
+
+	// Freezes lists periods in which the action is never allowed
+	Freezes []Freeze `yaml:"freezes"`
+
+	// RequireOnCall only allows the action while the requester is on call for
+	// the resource's service
+	RequireOnCall bool `yaml:"require_on_call"`
+}
+
+// OnCallChecker reports whether a user is currently on call for a service
+type OnCallChecker interface {
+	IsOnCall(ctx context.Context, userID, service string) (bool, error)
+}
+
+// ScheduleAPIChecker checks on-call status against an HTTP schedule API that answers
+// GET <url>?user=<id>&service=<service> with {"on_call": true|false}
+type ScheduleAPIChecker struct {
+	URL        string
+	httpClient *http.Client
+}
+
+// NewScheduleAPIChecker creates an on-call checker backed by a schedule API
+func NewScheduleAPIChecker(scheduleURL string) *ScheduleAPIChecker {
+	return &ScheduleAPIChecker{
+		URL: scheduleURL,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+}
+
+// IsOnCall queries the schedule API for the user's on-call status
+func (c *ScheduleAPIChecker) IsOnCall(ctx context.Context, userID, service string) (bool, error) {
+	query := url.Values{}
+	query.Set("user", userID)
+	query.Set("service", service)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, c.URL+"?"+query.Encode(), nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %v", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("failed to query schedule API: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("failed to query schedule API: status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		OnCall bool `json:"on_call"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return false, fmt.Errorf("failed to decode schedule response: %v", err)
+	}
+	return result.OnCall, nil
+}
+
+// compileTimePolicies validates the time policies and prepares them for evaluation
+func (r *Rules) compileTimePolicies() error {
+	for i := range r.TimePolicies {
+		policy := &r.TimePolicies[i]
+		if policy.Name == "" {
+			return fmt.Errorf("time policy %d: name is required", i)
+		}
+		if policy.Action != TimeActionSubmit && policy.Action != TimeActionAutoApprove {
+			return fmt.Errorf("time policy %s: action must be %q or %q", policy.Name, TimeActionSubmit, TimeActionAutoApprove)
+		}
+		for j := range policy.Windows {
+			if err := policy.Windows[j].compile(); err != nil {
+				return fmt.Errorf("time policy %s: %v", policy.Name, err)
+			}
+		}
+		for _, freeze := range policy.Freezes {
+			if !freeze.To.After(freeze.From) {
+				return fmt.Errorf("time policy %s: freeze %s ends before it starts", policy.Name, freeze.Name)
+			}
+		}
+	}
+	return nil
+}
+
+// compile parses the window's timezone, days and clock times
+func (w *TimeWindow) compile() error {
+	location := time.UTC
+	if w.Timezone != "" {
+		loc, err := time.LoadLocation(w.Timezone)
+		if err != nil {
+			return fmt.Errorf("invalid timezone %q: %v", w.Timezone, err)
+		}
+		location = loc
+	}
+	w.location = location
+
+	weekdays := map[string]time.Weekday{
+		"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+		"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+	}
+	w.days = make(map[time.Weekday]bool)
+	for _, day := range w.Days {
+		weekday, ok := weekdays[strings.ToLower(day)[:min(3, len(day))]]
+		if !ok {
+			return fmt.Errorf("invalid day %q", day)
+		}
+		w.days[weekday] = true
+	}
+
+	var err error
+	if w.start, err = parseClock(w.Start, 0); err != nil {
+		return err
+	}
+	if w.end, err = parseClock(w.End, 24*60); err != nil {
+		return err
+	}
+	return nil
+}
+
+// contains reports whether the instant falls inside the window
+func (w *TimeWindow) contains(t time.Time) bool {
+	local := t.In(w.location)
+	if len(w.days) > 0 && !w.days[local.Weekday()] {
+		return false
+	}
+	minute := local.Hour()*60 + local.Minute()
+	if w.start <= w.end {
+		return minute >= w.start && minute < w.end
+	}
+	// Window wraps around midnight, e.g. 22:00-06:00
+	return minute >= w.start || minute < w.end
+}
+
+// parseClock parses HH:MM into minutes since midnight
+func parseClock(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q: expected HH:MM", value)
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// applies reports whether the policy covers the request
+func (p *TimePolicy) applies(request *models.PrivilegeRequest) bool {
+	if len(p.Modules) > 0 && !slices.Contains(p.Modules, request.Module) {
+		return false
+	}
+	if len(p.Levels) > 0 && !slices.Contains(p.Levels, string(request.Level)) {
+		return false
+	}
+	return true
+}
+
+// allows reports whether the policy permits its action at the given time, with a reason when it does not
+func (p *TimePolicy) allows(request *models.PrivilegeRequest, service string, at time.Time, onCall OnCallChecker) (bool, string, error) {
+	for _, freeze := range p.Freezes {
+		if !at.Before(freeze.From) && at.Before(freeze.To) {
+			return false, fmt.Sprintf("change freeze %s is in effect until %s", freeze.Name, freeze.To.Format(time.RFC3339)), nil
+		}
+	}
+
+	if len(p.Windows) > 0 {
+		inside := false
+		for i := range p.Windows {
+			if p.Windows[i].contains(at) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false, fmt.Sprintf("outside the allowed time windows of policy %s", p.Name), nil
+		}
+	}
+
+	if p.RequireOnCall {
+		if onCall == nil {
+			return false, fmt.Sprintf("policy %s requires on-call status but no schedule is configured", p.Name), nil
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		isOnCall, err := onCall.IsOnCall(ctx, request.UserID, service)
+		if err != nil {
+			return false, "", fmt.Errorf("failed to check on-call status: %v", err)
+		}
+		if !isOnCall {
+			return false, fmt.Sprintf("requester is not on call for %s", service), nil
+		}
+	}
+
+	return true, "", nil
+}
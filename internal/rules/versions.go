@@ -0,0 +1,228 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Policy version statuses. A version starts as a draft, can be evaluated in shadow
+// mode (logged but not enforced) and is then promoted to active. Promoting a version
+// retires the previously active one.
+const (
+	VersionStatusDraft   = "draft"
+	VersionStatusShadow  = "shadow"
+	VersionStatusActive  = "active"
+	VersionStatusRetired = "retired"
+)
+
+// PolicyVersion describes a stored version of the rule definitions
+type PolicyVersion struct {
+	Version    int        `json:"version"`
+	Status     string     `json:"status"`
+	Comment    string     `json:"comment,omitempty"`
+	CreatedBy  string     `json:"created_by,omitempty"`
+	CreatedAt  time.Time  `json:"created_at"`
+	PromotedAt *time.Time `json:"promoted_at,omitempty"`
+}
+
+// ShadowSource is implemented by sources that provide rules evaluated in shadow mode
+type ShadowSource interface {
+	// LoadShadow returns the shadow rules, or nil when no version is in shadow mode
+	LoadShadow(ctx context.Context) (*Rules, error)
+}
+
+// VersionStore stores versioned rule definitions in a directory. Each version is
+// kept as <version>.yaml next to an index.json holding the version metadata.
+type VersionStore struct {
+	Dir string
+
+	mu sync.Mutex
+}
+
+// NewVersionStore creates a version store in the given directory
+func NewVersionStore(dir string) (*VersionStore, error) {
+	if err := os.MkdirAll(dir, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create policy directory: %v", err)
+	}
+	return &VersionStore{Dir: dir}, nil
+}
+
+// List returns all versions, newest first
+func (s *VersionStore) List(ctx context.Context) ([]PolicyVersion, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+	sort.Slice(versions, func(i, j int) bool { return versions[i].Version > versions[j].Version })
+	return versions, nil
+}
+
+// Create validates the rule definitions and stores them as a new draft version
+func (s *VersionStore) Create(ctx context.Context, data []byte, author, comment string) (*PolicyVersion, error) {
+	if _, err := ParseRules(data); err != nil {
+		return nil, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	version := PolicyVersion{
+		Version:   1,
+		Status:    VersionStatusDraft,
+		Comment:   comment,
+		CreatedBy: author,
+		CreatedAt: time.Now().UTC(),
+	}
+	for _, v := range versions {
+		if v.Version >= version.Version {
+			version.Version = v.Version + 1
+		}
+	}
+
+	if err := os.WriteFile(s.rulesPath(version.Version), data, 0o640); err != nil {
+		return nil, fmt.Errorf("failed to write policy version: %v", err)
+	}
+	if err := s.writeIndex(append(versions, version)); err != nil {
+		return nil, err
+	}
+	return &version, nil
+}
+
+// SetStatus moves a version to shadow or active. Only one version can hold each
+// of these statuses; the version previously holding it is retired, or returned to
+// draft when it was only shadowed.
+func (s *VersionStore) SetStatus(ctx context.Context, version int, status string) (*PolicyVersion, error) {
+	if status != VersionStatusShadow && status != VersionStatusActive {
+		return nil, fmt.Errorf("invalid status %q", status)
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	var updated *PolicyVersion
+	for i := range versions {
+		v := &versions[i]
+		switch {
+		case v.Version == version:
+			v.Status = status
+			if status == VersionStatusActive {
+				now := time.Now().UTC()
+				v.PromotedAt = &now
+			}
+			updated = v
+		case v.Status == status && status == VersionStatusActive:
+			v.Status = VersionStatusRetired
+		case v.Status == status:
+			v.Status = VersionStatusDraft
+		}
+	}
+	if updated == nil {
+		return nil, fmt.Errorf("policy version %d not found", version)
+	}
+
+	if err := s.writeIndex(versions); err != nil {
+		return nil, err
+	}
+	result := *updated
+	return &result, nil
+}
+
+// Load returns the active rules
+func (s *VersionStore) Load(ctx context.Context) (*Rules, error) {
+	rules, err := s.loadStatus(VersionStatusActive)
+	if err != nil {
+		return nil, err
+	}
+	if rules == nil {
+		return nil, fmt.Errorf("no active policy version")
+	}
+	return rules, nil
+}
+
+// LoadShadow returns the rules in shadow mode, if any
+func (s *VersionStore) LoadShadow(ctx context.Context) (*Rules, error) {
+	return s.loadStatus(VersionStatusShadow)
+}
+
+// loadStatus loads the version holding the given status
+func (s *VersionStore) loadStatus(status string) (*Rules, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	versions, err := s.readIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, v := range versions {
+		if v.Status != status {
+			continue
+		}
+		data, err := os.ReadFile(s.rulesPath(v.Version))
+		if err != nil {
+			return nil, fmt.Errorf("failed to read policy version %d: %v", v.Version, err)
+		}
+		rules, err := ParseRules(data)
+		if err != nil {
+			return nil, fmt.Errorf("policy version %d: %v", v.Version, err)
+		}
+		rules.Version = v.Version
+		return rules, nil
+	}
+	return nil, nil
+}
+
+func (s *VersionStore) rulesPath(version int) string {
+	return filepath.Join(s.Dir, fmt.Sprintf("%d.yaml", version))
+}
+
+func (s *VersionStore) readIndex() ([]PolicyVersion, error) {
+	data, err := os.ReadFile(filepath.Join(s.Dir, "index.json"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to read policy index: %v", err)
+	}
+
+	var versions []PolicyVersion
+	if err := json.Unmarshal(data, &versions); err != nil {
+		return nil, fmt.Errorf("failed to parse policy index: %v", err)
+	}
+	return versions, nil
+}
+
+// writeIndex replaces the index atomically
+func (s *VersionStore) writeIndex(versions []PolicyVersion) error {
+	data, err := json.MarshalIndent(versions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal policy index: %v", err)
+	}
+	tmp := filepath.Join(s.Dir, "index.json.tmp")
+	if err := os.WriteFile(tmp, data, 0o640); err != nil {
+		return fmt.Errorf("failed to write policy index: %v", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.Dir, "index.json")); err != nil {
+		return fmt.Errorf("failed to write policy index: %v", err)
+	}
+	return nil
+}
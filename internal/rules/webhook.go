@@ -0,0 +1,153 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/petermein/apollo/internal/core/models"
+)
+
+// Decisions returned by a decision webhook
+const (
+	WebhookAllow            = "allow"
+	WebhookDeny             = "deny"
+	WebhookRequireApprovers = "require_approvers"
+)
+
+// DecisionWebhook delegates request decisions to an external HTTP service. The
+// request context is POSTed as JSON and the service answers with
+//
+//	{"decision": "allow" | "deny" | "require_approvers", "reason": "...",
+//	 "approvers": ["..."], "required_approvals": 2}
+type DecisionWebhook struct {
+	URL     string            `yaml:"url"`
+	Headers map[string]string `yaml:"headers"`
+	Timeout time.Duration     `yaml:"timeout"`
+
+	// FailOpen lets requests through the webhook when the service cannot be reached
+	// or answers invalidly; by default such requests are denied
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// webhookRequest is the document sent to the decision service
+type webhookRequest struct {
+	Request  *models.PrivilegeRequest `json:"request"`
+	Resource map[string]interface{}   `json:"resource"`
+	Duration float64                  `json:"duration_seconds"`
+}
+
+// webhookResponse is the decision service's answer
+type webhookResponse struct {
+	Decision          string   `json:"decision"`
+	Reason            string   `json:"reason"`
+	Approvers         []string `json:"approvers"`
+	RequiredApprovals int      `json:"required_approvals"`
+}
+
+// validate checks the webhook configuration
+func (w *DecisionWebhook) validate() error {
+	if w.URL == "" {
+		return fmt.Errorf("decision_webhook: url is required")
+	}
+	if w.Timeout < 0 {
+		return fmt.Errorf("decision_webhook: timeout must not be negative")
+	}
+	return nil
+}
+
+// query posts the request to the decision service and returns its answer
+func (w *DecisionWebhook) query(request *models.PrivilegeRequest, resource map[string]interface{}) (*webhookResponse, error) {
+	timeout := w.Timeout
+	if timeout == 0 {
+		timeout = 2 * time.Second
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	body, err := json.Marshal(webhookRequest{
+		Request:  request,
+		Resource: resource,
+		Duration: request.ExpiresAt.Sub(request.RequestedAt).Seconds(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to marshal request: %v", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, w.URL, bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	for name, value := range w.Headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query decision service: %v", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("decision service returned status %d", resp.StatusCode)
+	}
+
+	var answer webhookResponse
+	if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+		return nil, fmt.Errorf("failed to decode decision: %v", err)
+	}
+	switch answer.Decision {
+	case WebhookAllow, WebhookDeny, WebhookRequireApprovers:
+	default:
+		return nil, fmt.Errorf("decision service returned unknown decision %q", answer.Decision)
+	}
+	return &answer, nil
+}
+
+// evaluateDecisionWebhook applies the external decision service's answer to the request
+func (e *DefaultRuleEngine) evaluateDecisionWebhook(request *models.PrivilegeRequest, tags []string, decision *Decision) error {
+	rules := e.Rules()
+	webhook := rules.DecisionWebhook
+	if webhook == nil {
+		return nil
+	}
+
+	answer, err := webhook.query(request, rules.resourceAttributes(request.Module, request.ResourceID, tags))
+	if err != nil {
+		if webhook.FailOpen {
+			log.Printf("Decision webhook failed, continuing without it: %v", err)
+			decision.record("decision_webhook", ResultMatched, "service unavailable; failing open")
+			return nil
+		}
+		log.Printf("Decision webhook failed, denying request: %v", err)
+		return violation("decision_webhook", "the decision service is unavailable")
+	}
+
+	switch answer.Decision {
+	case WebhookDeny:
+		message := answer.Reason
+		if message == "" {
+			message = "request denied by the decision service"
+		}
+		return violation("decision_webhook", "%s", message)
+	case WebhookRequireApprovers:
+		approvals := answer.RequiredApprovals
+		if approvals < 1 {
+			approvals = 1
+		}
+		if approvals > request.RequiredApprovals {
+			request.RequiredApprovals = approvals
+		}
+		if len(answer.Approvers) > 0 {
+			request.ApprovalRoute = "decision_webhook"
+			request.Approvers = answer.Approvers
+		}
+		decision.record("decision_webhook", ResultMatched, fmt.Sprintf("requires %d approvals from %v", approvals, answer.Approvers))
+	}
+	return nil
+}
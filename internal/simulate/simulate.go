@@ -0,0 +1,92 @@
+// Package simulate stands in for a target system: operations take a
+// configurable time and fail at a configurable rate, so the mock modules of
+// the API and the operator can exercise the request, grant and revoke
+// pipeline, retries and expiry without a real database or cluster.
+package simulate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"time"
+)
+
+// ErrFailure is returned for the operations the failure rate fails
+var ErrFailure = errors.New("simulated failure")
+
+// Config sets how the simulated target behaves
+type Config struct {
+	// Latency is how long every operation takes
+	Latency time.Duration
+	// Jitter is added to or taken from the latency at random, up to this much
+	Jitter time.Duration
+	// FailureRate is the fraction of operations that fail, from 0 to 1
+	FailureRate float64
+}
+
+// Parse reads latency, jitter and failure_rate from a module's YAML map,
+// reporting every problem found
+func Parse(config map[string]interface{}) (Config, error) {
+	var cfg Config
+	var errs []error
+	for key, target := range map[string]*time.Duration{"latency": &cfg.Latency, "jitter": &cfg.Jitter} {
+		value, ok := config[key]
+		if !ok {
+			continue
+		}
+		s, ok := value.(string)
+		if !ok {
+			errs = append(errs, fmt.Errorf("%s must be a duration such as 200ms", key))
+			continue
+		}
+		d, err := time.ParseDuration(s)
+		if err != nil || d < 0 {
+			errs = append(errs, fmt.Errorf("invalid %s %q: expected a duration such as 200ms", key, s))
+			continue
+		}
+		*target = d
+	}
+	switch rate := config["failure_rate"].(type) {
+	case nil:
+	case int:
+		cfg.FailureRate = float64(rate)
+	case float64:
+		cfg.FailureRate = rate
+	default:
+		errs = append(errs, fmt.Errorf("failure_rate must be a number from 0 to 1"))
+	}
+	if cfg.FailureRate < 0 || cfg.FailureRate > 1 {
+		errs = append(errs, fmt.Errorf("invalid failure_rate %v: expected a number from 0 to 1", cfg.FailureRate))
+	}
+	if cfg.Jitter > cfg.Latency {
+		errs = append(errs, fmt.Errorf("jitter %s can't exceed latency %s", cfg.Jitter, cfg.Latency))
+	}
+	if len(errs) > 0 {
+		return Config{}, errors.Join(errs...)
+	}
+	return cfg, nil
+}
+
+// Run waits out the latency of an operation and fails it at the failure
+// rate. It returns how long the operation took, or the context's error when
+// it ends first.
+func (c Config) Run(ctx context.Context, operation string) (time.Duration, error) {
+	delay := c.Latency
+	if c.Jitter > 0 {
+		delay += time.Duration(rand.Int64N(int64(2*c.Jitter)+1)) - c.Jitter
+	}
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return 0, ctx.Err()
+	case <-timer.C:
+	}
+
+	if c.FailureRate > 0 && rand.Float64() < c.FailureRate {
+		return delay, fmt.Errorf("%s: %w", operation, ErrFailure)
+	}
+	return delay, nil
+}
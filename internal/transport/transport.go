@@ -0,0 +1,62 @@
+// Package transport provides the HTTP transport the CLI and operators talk to
+// the API with, tuned to keep connections open between requests
+package transport
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"time"
+)
+
+// drainLimit is how much of an unread response body is read on close so the
+// connection can be reused; connections with more left are closed instead
+const drainLimit = 64 << 10
+
+// New returns a transport that keeps idle connections to the API open,
+// negotiates HTTP/2 with servers that offer it over TLS and asks for gzip
+// compressed responses, which it decompresses
+func New() http.RoundTripper {
+	dialer := &net.Dialer{
+		Timeout:   10 * time.Second,
+		KeepAlive: 30 * time.Second,
+	}
+	return &drainingTransport{base: &http.Transport{
+		Proxy:                 http.ProxyFromEnvironment,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   16,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}}
+}
+
+// drainingTransport reads what's left of response bodies when they are
+// closed, so that responses decoded without reading to the end, as JSON
+// decoders do, don't cost the connection
+type drainingTransport struct {
+	base http.RoundTripper
+}
+
+// RoundTrip sends the request
+func (t *drainingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := t.base.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = &drainingBody{ReadCloser: resp.Body}
+	return resp, nil
+}
+
+// drainingBody is a response body that is drained when closed
+type drainingBody struct {
+	io.ReadCloser
+}
+
+// Close drains and closes the body
+func (b *drainingBody) Close() error {
+	io.CopyN(io.Discard, b.ReadCloser, drainLimit)
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,168 @@
+package webauthn
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// maxDepth bounds the nesting of decoded CBOR values
+const maxDepth = 16
+
+// decodeCBOR decodes the first CBOR value of data and returns it with the
+// bytes that follow. Authenticators encode canonical CBOR, so indefinite
+// lengths aren't supported. Integers decode to int64, byte strings to []byte,
+// text to string, arrays to []interface{} and maps to map[interface{}]interface{}
+// keyed by int64 or string.
+func decodeCBOR(data []byte) (interface{}, []byte, error) {
+	return decodeValue(data, 0)
+}
+
+func decodeValue(data []byte, depth int) (interface{}, []byte, error) {
+	if depth > maxDepth {
+		return nil, nil, fmt.Errorf("cbor: nesting too deep")
+	}
+	if len(data) == 0 {
+		return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+	}
+	major, info := data[0]>>5, data[0]&0x1f
+	data = data[1:]
+
+	if major == 7 {
+		return decodeSimple(info, data)
+	}
+	arg, data, err := decodeArgument(info, data)
+	if err != nil {
+		return nil, nil, err
+	}
+	switch major {
+	case 0:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflows int64")
+		}
+		return int64(arg), data, nil
+	case 1:
+		if arg > math.MaxInt64 {
+			return nil, nil, fmt.Errorf("cbor: integer overflows int64")
+		}
+		return -1 - int64(arg), data, nil
+	case 2, 3:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("cbor: string longer than data")
+		}
+		if major == 2 {
+			return append([]byte(nil), data[:arg]...), data[arg:], nil
+		}
+		return string(data[:arg]), data[arg:], nil
+	case 4:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("cbor: array longer than data")
+		}
+		items := make([]interface{}, 0, arg)
+		for i := uint64(0); i < arg; i++ {
+			var item interface{}
+			if item, data, err = decodeValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			items = append(items, item)
+		}
+		return items, data, nil
+	case 5:
+		if arg > uint64(len(data)) {
+			return nil, nil, fmt.Errorf("cbor: map longer than data")
+		}
+		entries := make(map[interface{}]interface{}, arg)
+		for i := uint64(0); i < arg; i++ {
+			var key, value interface{}
+			if key, data, err = decodeValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			switch key.(type) {
+			case int64, string:
+			default:
+				return nil, nil, fmt.Errorf("cbor: unsupported map key %T", key)
+			}
+			if value, data, err = decodeValue(data, depth+1); err != nil {
+				return nil, nil, err
+			}
+			entries[key] = value
+		}
+		return entries, data, nil
+	default:
+		// Tags only annotate the value that follows
+		return decodeValue(data, depth+1)
+	}
+}
+
+// decodeArgument reads the length or value that follows the initial byte
+func decodeArgument(info byte, data []byte) (uint64, []byte, error) {
+	switch {
+	case info < 24:
+		return uint64(info), data, nil
+	case info <= 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return 0, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		var arg uint64
+		switch size {
+		case 1:
+			arg = uint64(data[0])
+		case 2:
+			arg = uint64(binary.BigEndian.Uint16(data))
+		case 4:
+			arg = uint64(binary.BigEndian.Uint32(data))
+		default:
+			arg = binary.BigEndian.Uint64(data)
+		}
+		return arg, data[size:], nil
+	default:
+		return 0, nil, fmt.Errorf("cbor: indefinite or reserved length %d", info)
+	}
+}
+
+// decodeSimple decodes booleans, null and floats
+func decodeSimple(info byte, data []byte) (interface{}, []byte, error) {
+	switch info {
+	case 20:
+		return false, data, nil
+	case 21:
+		return true, data, nil
+	case 22, 23:
+		return nil, data, nil
+	case 25, 26, 27:
+		size := 1 << (info - 24)
+		if len(data) < size {
+			return nil, nil, fmt.Errorf("cbor: unexpected end of data")
+		}
+		switch size {
+		case 2:
+			return float64(halfFloat(binary.BigEndian.Uint16(data))), data[size:], nil
+		case 4:
+			return float64(math.Float32frombits(binary.BigEndian.Uint32(data))), data[size:], nil
+		default:
+			return math.Float64frombits(binary.BigEndian.Uint64(data)), data[size:], nil
+		}
+	default:
+		return nil, nil, fmt.Errorf("cbor: unsupported simple value %d", info)
+	}
+}
+
+// halfFloat converts an IEEE 754 half-precision float
+func halfFloat(bits uint16) float32 {
+	sign := uint32(bits>>15) << 31
+	exponent := uint32(bits>>10) & 0x1f
+	fraction := uint32(bits) & 0x3ff
+	switch exponent {
+	case 0:
+		value := float32(fraction) / (1 << 24)
+		if sign != 0 {
+			value = -value
+		}
+		return value
+	case 0x1f:
+		return math.Float32frombits(sign | 0xff<<23 | fraction<<13)
+	default:
+		return math.Float32frombits(sign | (exponent+112)<<23 | fraction<<13)
+	}
+}
@@ -0,0 +1,114 @@
+package webauthn
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"fmt"
+	"math/big"
+)
+
+// COSE algorithms of the supported credentials
+const (
+	AlgorithmES256 = -7
+	AlgorithmEdDSA = -8
+	AlgorithmRS256 = -257
+)
+
+// COSE key parameters
+const (
+	coseKeyType   = 1
+	coseAlgorithm = 3
+	coseCurve     = -1
+	coseX         = -2
+	coseY         = -3
+	coseRSAN      = -1
+	coseRSAE      = -2
+
+	coseKeyTypeOKP = 1
+	coseKeyTypeEC2 = 2
+	coseKeyTypeRSA = 3
+
+	coseCurveP256    = 1
+	coseCurveEd25519 = 6
+)
+
+// publicKey is a credential public key with its COSE algorithm
+type publicKey struct {
+	algorithm int64
+	key       crypto.PublicKey
+}
+
+// parsePublicKey decodes a COSE_Key encoded public key
+func parsePublicKey(encoded []byte) (*publicKey, error) {
+	value, rest, err := decodeCBOR(encoded)
+	if err != nil {
+		return nil, fmt.Errorf("invalid public key: %v", err)
+	}
+	if len(rest) > 0 {
+		return nil, fmt.Errorf("invalid public key: trailing data")
+	}
+	return publicKeyFrom(value)
+}
+
+// publicKeyFrom converts a decoded COSE_Key
+func publicKeyFrom(value interface{}) (*publicKey, error) {
+	params, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid public key: not a map")
+	}
+	keyType, _ := params[int64(coseKeyType)].(int64)
+	algorithm, _ := params[int64(coseAlgorithm)].(int64)
+
+	switch {
+	case keyType == coseKeyTypeEC2 && algorithm == AlgorithmES256:
+		curve, _ := params[int64(coseCurve)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+		y, _ := params[int64(coseY)].([]byte)
+		if curve != coseCurveP256 || len(x) != 32 || len(y) != 32 {
+			return nil, fmt.Errorf("invalid ES256 public key")
+		}
+		key := &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		if !key.Curve.IsOnCurve(key.X, key.Y) {
+			return nil, fmt.Errorf("invalid ES256 public key: point is not on the curve")
+		}
+		return &publicKey{algorithm: algorithm, key: key}, nil
+	case keyType == coseKeyTypeOKP && algorithm == AlgorithmEdDSA:
+		curve, _ := params[int64(coseCurve)].(int64)
+		x, _ := params[int64(coseX)].([]byte)
+		if curve != coseCurveEd25519 || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid EdDSA public key")
+		}
+		return &publicKey{algorithm: algorithm, key: ed25519.PublicKey(x)}, nil
+	case keyType == coseKeyTypeRSA && algorithm == AlgorithmRS256:
+		n, _ := params[int64(coseRSAN)].([]byte)
+		e, _ := params[int64(coseRSAE)].([]byte)
+		if len(n) < 256 || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("invalid RS256 public key")
+		}
+		return &publicKey{algorithm: algorithm, key: &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported public key type %d with algorithm %d", keyType, algorithm)
+	}
+}
+
+// verify checks the signature over the signed data
+func (k *publicKey) verify(signed, signature []byte) error {
+	digest := sha256.Sum256(signed)
+	var ok bool
+	switch key := k.key.(type) {
+	case *ecdsa.PublicKey:
+		ok = ecdsa.VerifyASN1(key, digest[:], signature)
+	case ed25519.PublicKey:
+		ok = ed25519.Verify(key, signed, signature)
+	case *rsa.PublicKey:
+		ok = rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], signature) == nil
+	}
+	if !ok {
+		return fmt.Errorf("invalid signature")
+	}
+	return nil
+}
@@ -0,0 +1,101 @@
+package webauthn
+
+// CreationOptions are the options the dashboard passes to
+// navigator.credentials.create, in the JSON form of WebAuthn level 3 that
+// PublicKeyCredential.parseCreationOptionsFromJSON accepts
+type CreationOptions struct {
+	Challenge              string                 `json:"challenge"`
+	RP                     RelyingPartyEntity     `json:"rp"`
+	User                   UserEntity             `json:"user"`
+	PubKeyCredParams       []CredentialParameter  `json:"pubKeyCredParams"`
+	Timeout                int64                  `json:"timeout"`
+	ExcludeCredentials     []CredentialDescriptor `json:"excludeCredentials"`
+	AuthenticatorSelection AuthenticatorSelection `json:"authenticatorSelection"`
+	Attestation            string                 `json:"attestation"`
+}
+
+// RequestOptions are the options the dashboard passes to
+// navigator.credentials.get, in the JSON form PublicKeyCredential.parseRequestOptionsFromJSON accepts
+type RequestOptions struct {
+	Challenge        string                 `json:"challenge"`
+	RPID             string                 `json:"rpId"`
+	Timeout          int64                  `json:"timeout"`
+	AllowCredentials []CredentialDescriptor `json:"allowCredentials"`
+	UserVerification string                 `json:"userVerification"`
+}
+
+// RelyingPartyEntity names the relying party
+type RelyingPartyEntity struct {
+	ID   string `json:"id"`
+	Name string `json:"name"`
+}
+
+// UserEntity names the user a credential is registered to
+type UserEntity struct {
+	ID          string `json:"id"`
+	Name        string `json:"name"`
+	DisplayName string `json:"displayName"`
+}
+
+// CredentialParameter is a credential algorithm the relying party accepts
+type CredentialParameter struct {
+	Type string `json:"type"`
+	Alg  int    `json:"alg"`
+}
+
+// CredentialDescriptor identifies a registered credential
+type CredentialDescriptor struct {
+	Type string `json:"type"`
+	ID   string `json:"id"`
+}
+
+// AuthenticatorSelection asks for a roaming hardware key
+type AuthenticatorSelection struct {
+	AuthenticatorAttachment string `json:"authenticatorAttachment"`
+	ResidentKey             string `json:"residentKey"`
+	UserVerification        string `json:"userVerification"`
+}
+
+// CreationOptions returns the options for the user to register a new
+// hardware key with, excluding the credentials they registered already
+func (rp *RelyingParty) CreationOptions(userID string, challenge []byte, registered [][]byte) CreationOptions {
+	return CreationOptions{
+		Challenge: Encode(challenge),
+		RP:        RelyingPartyEntity{ID: rp.id, Name: rp.name},
+		User:      UserEntity{ID: Encode([]byte(userID)), Name: userID, DisplayName: userID},
+		PubKeyCredParams: []CredentialParameter{
+			{Type: "public-key", Alg: AlgorithmES256},
+			{Type: "public-key", Alg: AlgorithmEdDSA},
+			{Type: "public-key", Alg: AlgorithmRS256},
+		},
+		Timeout:            rp.ttl.Milliseconds(),
+		ExcludeCredentials: descriptors(registered),
+		AuthenticatorSelection: AuthenticatorSelection{
+			AuthenticatorAttachment: "cross-platform",
+			ResidentKey:             "discouraged",
+			UserVerification:        "preferred",
+		},
+		Attestation: "none",
+	}
+}
+
+// RequestOptions returns the options for the user to sign the challenge
+// with one of their registered credentials
+func (rp *RelyingParty) RequestOptions(challenge []byte, registered [][]byte) RequestOptions {
+	return RequestOptions{
+		Challenge:        Encode(challenge),
+		RPID:             rp.id,
+		Timeout:          rp.ttl.Milliseconds(),
+		AllowCredentials: descriptors(registered),
+		UserVerification: "preferred",
+	}
+}
+
+// descriptors describes the credentials by ID
+func descriptors(ids [][]byte) []CredentialDescriptor {
+	list := make([]CredentialDescriptor, 0, len(ids))
+	for _, id := range ids {
+		list = append(list, CredentialDescriptor{Type: "public-key", ID: Encode(id)})
+	}
+	return list
+}
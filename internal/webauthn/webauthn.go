@@ -0,0 +1,267 @@
+// Package webauthn verifies WebAuthn registrations and assertions, so users
+// can confirm sensitive actions with a hardware key in the browser. Only what
+// the server needs is implemented: attestation statements aren't verified,
+// and ES256, EdDSA and RS256 credentials are supported.
+package webauthn
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Authenticator data flags
+const (
+	flagUserPresent  = 0x01
+	flagUserVerified = 0x04
+	flagAttested     = 0x40
+)
+
+// Client data types
+const (
+	typeCreate = "webauthn.create"
+	typeGet    = "webauthn.get"
+)
+
+// challengeSize is the size of a challenge: its expiry, a random nonce and the MAC
+const challengeSize = 8 + 16 + sha256.Size
+
+// ErrSignCount is returned for assertions whose signature counter didn't
+// increase, a sign the authenticator was cloned or the assertion replayed
+var ErrSignCount = errors.New("signature counter did not increase")
+
+// RelyingParty verifies credentials registered to, and assertions made for,
+// the dashboard's origins. Challenges are signed rather than stored, so any
+// server sharing the challenge key can verify them.
+type RelyingParty struct {
+	id      string
+	name    string
+	origins []string
+	key     []byte
+	ttl     time.Duration
+}
+
+// NewRelyingParty creates a relying party for the domain id, e.g.
+// apollo.example.com, accepting responses from the given origins. An empty
+// challenge key is replaced by a random one, so challenges only verify on
+// this server until it exits.
+func NewRelyingParty(id, name string, origins []string, challengeKey []byte, ttl time.Duration) (*RelyingParty, error) {
+	if id == "" || len(origins) == 0 {
+		return nil, fmt.Errorf("webauthn relying party ID and origins are required")
+	}
+	if len(challengeKey) == 0 {
+		challengeKey = make([]byte, 32)
+		if _, err := rand.Read(challengeKey); err != nil {
+			return nil, fmt.Errorf("failed to generate challenge key: %v", err)
+		}
+	}
+	if name == "" {
+		name = "Apollo"
+	}
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	return &RelyingParty{id: id, name: name, origins: origins, key: challengeKey, ttl: ttl}, nil
+}
+
+// Credential is a public key credential registered to a user
+type Credential struct {
+	ID []byte
+	// PublicKey is the COSE_Key encoded public key
+	PublicKey []byte
+	SignCount uint32
+}
+
+// Assertion is a verified assertion of a credential
+type Assertion struct {
+	SignCount    uint32
+	UserVerified bool
+}
+
+// NewChallenge returns a challenge for the user to sign for the subject, such
+// as the approval of a request, valid until the relying party's TTL passes
+func (rp *RelyingParty) NewChallenge(userID, subject string, now time.Time) ([]byte, error) {
+	challenge := make([]byte, challengeSize)
+	binary.BigEndian.PutUint64(challenge, uint64(now.Add(rp.ttl).Unix()))
+	if _, err := rand.Read(challenge[8:24]); err != nil {
+		return nil, fmt.Errorf("failed to generate challenge: %v", err)
+	}
+	copy(challenge[24:], rp.mac(challenge[:24], userID, subject))
+	return challenge, nil
+}
+
+// checkChallenge checks that the challenge was issued to the user for the
+// subject and hasn't expired
+func (rp *RelyingParty) checkChallenge(challenge []byte, userID, subject string, now time.Time) error {
+	if len(challenge) != challengeSize || !hmac.Equal(challenge[24:], rp.mac(challenge[:24], userID, subject)) {
+		return fmt.Errorf("challenge was not issued for this")
+	}
+	if now.Unix() > int64(binary.BigEndian.Uint64(challenge)) {
+		return fmt.Errorf("challenge has expired")
+	}
+	return nil
+}
+
+// mac authenticates a challenge's expiry and nonce for the user and subject
+func (rp *RelyingParty) mac(nonce []byte, userID, subject string) []byte {
+	mac := hmac.New(sha256.New, rp.key)
+	mac.Write([]byte("apollo-webauthn\n" + rp.id + "\n" + userID + "\n" + subject + "\n"))
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// clientData is the part of the client data JSON the server checks
+type clientData struct {
+	Type        string `json:"type"`
+	Challenge   string `json:"challenge"`
+	Origin      string `json:"origin"`
+	CrossOrigin bool   `json:"crossOrigin"`
+}
+
+// checkClientData checks the client data of a response of the given type
+func (rp *RelyingParty) checkClientData(raw []byte, kind, userID, subject string, now time.Time) error {
+	var data clientData
+	if err := json.Unmarshal(raw, &data); err != nil {
+		return fmt.Errorf("invalid client data: %v", err)
+	}
+	if data.Type != kind {
+		return fmt.Errorf("client data is of type %q, expected %q", data.Type, kind)
+	}
+	if !slices.Contains(rp.origins, data.Origin) || data.CrossOrigin {
+		return fmt.Errorf("origin %s is not allowed", data.Origin)
+	}
+	challenge, err := Decode(data.Challenge)
+	if err != nil {
+		return fmt.Errorf("invalid challenge: %v", err)
+	}
+	return rp.checkChallenge(challenge, userID, subject, now)
+}
+
+// authenticatorData is the parsed authenticator data of a response
+type authenticatorData struct {
+	flags        byte
+	signCount    uint32
+	credentialID []byte
+	publicKey    []byte
+}
+
+// parseAuthenticatorData parses authenticator data and checks it was made for
+// the relying party with the user present
+func (rp *RelyingParty) parseAuthenticatorData(raw []byte) (*authenticatorData, error) {
+	if len(raw) < 37 {
+		return nil, fmt.Errorf("authenticator data is too short")
+	}
+	rpIDHash := sha256.Sum256([]byte(rp.id))
+	if !bytes.Equal(raw[:32], rpIDHash[:]) {
+		return nil, fmt.Errorf("authenticator data is for another relying party")
+	}
+	data := &authenticatorData{flags: raw[32], signCount: binary.BigEndian.Uint32(raw[33:37])}
+	if data.flags&flagUserPresent == 0 {
+		return nil, fmt.Errorf("user was not present")
+	}
+	if data.flags&flagAttested == 0 {
+		return data, nil
+	}
+
+	rest := raw[37:]
+	if len(rest) < 18 {
+		return nil, fmt.Errorf("attested credential data is too short")
+	}
+	idLength := int(binary.BigEndian.Uint16(rest[16:18]))
+	rest = rest[18:]
+	if len(rest) < idLength {
+		return nil, fmt.Errorf("credential ID is longer than the data")
+	}
+	data.credentialID = append([]byte(nil), rest[:idLength]...)
+	rest = rest[idLength:]
+	_, extensions, err := decodeCBOR(rest)
+	if err != nil {
+		return nil, fmt.Errorf("invalid credential public key: %v", err)
+	}
+	data.publicKey = append([]byte(nil), rest[:len(rest)-len(extensions)]...)
+	return data, nil
+}
+
+// Register verifies the response of navigator.credentials.create to a
+// challenge issued to the user for registration and returns the new credential
+func (rp *RelyingParty) Register(userID string, clientDataJSON, attestationObject []byte, now time.Time) (*Credential, error) {
+	if err := rp.checkClientData(clientDataJSON, typeCreate, userID, registrationSubject, now); err != nil {
+		return nil, err
+	}
+	value, _, err := decodeCBOR(attestationObject)
+	if err != nil {
+		return nil, fmt.Errorf("invalid attestation object: %v", err)
+	}
+	object, ok := value.(map[interface{}]interface{})
+	if !ok {
+		return nil, fmt.Errorf("invalid attestation object: not a map")
+	}
+	raw, ok := object["authData"].([]byte)
+	if !ok {
+		return nil, fmt.Errorf("attestation object holds no authenticator data")
+	}
+	data, err := rp.parseAuthenticatorData(raw)
+	if err != nil {
+		return nil, err
+	}
+	if data.credentialID == nil {
+		return nil, fmt.Errorf("authenticator data holds no credential")
+	}
+	if _, err := parsePublicKey(data.publicKey); err != nil {
+		return nil, err
+	}
+	return &Credential{ID: data.credentialID, PublicKey: data.publicKey, SignCount: data.signCount}, nil
+}
+
+// registrationSubject is what registration challenges are issued for
+const registrationSubject = "register"
+
+// NewRegistrationChallenge returns a challenge for the user to register a credential with
+func (rp *RelyingParty) NewRegistrationChallenge(userID string, now time.Time) ([]byte, error) {
+	return rp.NewChallenge(userID, registrationSubject, now)
+}
+
+// VerifyAssertion verifies the response of navigator.credentials.get with
+// the credential to a challenge issued to the user for the subject
+func (rp *RelyingParty) VerifyAssertion(credential *Credential, userID, subject string, clientDataJSON, authenticatorDataRaw, signature []byte, now time.Time) (*Assertion, error) {
+	if err := rp.checkClientData(clientDataJSON, typeGet, userID, subject, now); err != nil {
+		return nil, err
+	}
+	data, err := rp.parseAuthenticatorData(authenticatorDataRaw)
+	if err != nil {
+		return nil, err
+	}
+	key, err := parsePublicKey(credential.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	clientDataHash := sha256.Sum256(clientDataJSON)
+	signed := append(append([]byte(nil), authenticatorDataRaw...), clientDataHash[:]...)
+	if err := key.verify(signed, signature); err != nil {
+		return nil, err
+	}
+	// Authenticators without a counter always report zero
+	if (data.signCount != 0 || credential.SignCount != 0) && data.signCount <= credential.SignCount {
+		return nil, ErrSignCount
+	}
+	return &Assertion{SignCount: data.signCount, UserVerified: data.flags&flagUserVerified != 0}, nil
+}
+
+// Encode encodes binary values as WebAuthn JSON does, base64url without padding
+func Encode(value []byte) string {
+	return base64.RawURLEncoding.EncodeToString(value)
+}
+
+// Decode decodes base64url, with or without padding
+func Decode(value string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(value, "="))
+}